@@ -0,0 +1,65 @@
+package apiclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		switch r.URL.Path {
+		case "/torrent/api/list":
+			w.Write([]byte(`{"Total":1,"Torrents":[{"InfoHash":"aa","Name":"Ubuntu","Percent":50}]}`))
+		case "/torrent/api/torrent":
+			if strings.HasSuffix(string(body), "unknown") {
+				http.Error(w, "POST:/api/torrent:ERROR: Invalid state: unknown", http.StatusBadRequest)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := &Client{URL: ts.URL + "/torrent/", Token: "secret"}
+	if err := c.AddMagnet(ctx, "magnet:?xt=urn:btih:aa", "tv"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Action(ctx, "stop", "aa"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.List(ctx, url.Values{"state": {"seeding"}})
+	if err != nil || p.Total != 1 || p.Torrents[0].Name != "Ubuntu" {
+		t.Fatalf("List() = %+v, %v", p, err)
+	}
+	want := []string{
+		"POST /torrent/api/magnet?dir=tv magnet:?xt=urn:btih:aa",
+		"POST /torrent/api/torrent stop:aa",
+		"POST /torrent/api/restart ",
+		"GET /torrent/api/list?state=seeding ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+
+	if err := c.Action(ctx, "start", "unknown"); err == nil || err.Error() != "ERROR: Invalid state: unknown (400)" {
+		t.Errorf("Action() error = %v", err)
+	}
+	c.Token = "wrong"
+	if err := c.Action(ctx, "start", "aa"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Action() with a wrong token = %v", err)
+	}
+}
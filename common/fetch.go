@@ -0,0 +1,34 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// MaxTorrentSize is the limit of a .torrent fetched from a URL
+const MaxTorrentSize = 512 * 1024
+
+// FetchTorrentFile downloads the .torrent at url, up to MaxTorrentSize
+func FetchTorrentFile(url string) ([]byte, error) {
+	remote, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote torrent URL: %s %w", url, err)
+	}
+	defer remote.Body.Close()
+	if remote.ContentLength > MaxTorrentSize {
+		//enforce max body size (512k)
+		return nil, errors.New("remote torrent too large")
+	}
+	// the length may be unknown or false
+	data, err := ioutil.ReadAll(io.LimitReader(remote.Body, MaxTorrentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxTorrentSize {
+		return nil, errors.New("remote torrent too large")
+	}
+	return data, nil
+}
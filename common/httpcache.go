@@ -0,0 +1,185 @@
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPCacheMeta is stored along with the cached body
+type HTTPCacheMeta struct {
+	URL          string
+	ETag         string
+	LastModified string
+	ContentType  string
+	FetchedAt    time.Time
+}
+
+// HTTPCache keeps fetched bodies on disk, revalidates them with
+// ETag/Last-Modified and serves the stale copy if the upstream is unreachable
+type HTTPCache struct {
+	Dir string
+	// MinInterval skips the upstream if the cache is younger than it
+	MinInterval time.Duration
+	Client      *http.Client
+	mu          sync.Mutex
+}
+
+func NewHTTPCache(dir string, minInterval time.Duration) *HTTPCache {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Println("[HTTPCache] failed to create cache dir", err)
+	}
+	return &HTTPCache{
+		Dir:         dir,
+		MinInterval: minInterval,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (c *HTTPCache) paths(key string) (string, string) {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.Dir, name+".body"), filepath.Join(c.Dir, name+".meta")
+}
+
+// Load reads the cached body of key
+func (c *HTTPCache) Load(key string) ([]byte, *HTTPCacheMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bodyPath, metaPath := c.paths(key)
+	mb, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := &HTTPCacheMeta{}
+	if err := json.Unmarshal(mb, meta); err != nil {
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadFile(bodyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, meta, nil
+}
+
+// Store saves body of key into cache
+func (c *HTTPCache) Store(key string, body []byte, meta *HTTPCacheMeta) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bodyPath, metaPath := c.paths(key)
+	mb, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		if err := os.WriteFile(bodyPath, body, 0644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(metaPath, mb, 0644)
+}
+
+// Prune removes the entries of the keys with prefix fetched longer than
+// maxAge ago, returns the number removed
+func (c *HTTPCache) Prune(prefix string, maxAge time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metas, err := filepath.Glob(filepath.Join(c.Dir, "*.meta"))
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, metaPath := range metas {
+		mb, err := ioutil.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		meta := &HTTPCacheMeta{}
+		if err := json.Unmarshal(mb, meta); err != nil || !strings.HasPrefix(meta.URL, prefix) || time.Since(meta.FetchedAt) < maxAge {
+			continue
+		}
+		if err := os.Remove(strings.TrimSuffix(metaPath, ".meta") + ".body"); err != nil && !os.IsNotExist(err) {
+			log.Println("[HTTPCache] prune", err)
+			continue
+		}
+		HandleError(os.Remove(metaPath))
+		n++
+	}
+	return n
+}
+
+// Get fetches url through the cache
+func (c *HTTPCache) Get(url string) ([]byte, error) {
+	return c.get(url, c.MinInterval)
+}
+
+// Revalidate fetches url through the cache regardless of MinInterval, the
+// upstream answers 304 if the cached copy is still current
+func (c *HTTPCache) Revalidate(url string) ([]byte, error) {
+	return c.get(url, 0)
+}
+
+func (c *HTTPCache) get(url string, minInterval time.Duration) ([]byte, error) {
+	body, meta, cerr := c.Load(url)
+	if cerr == nil && time.Since(meta.FetchedAt) < minInterval {
+		return body, nil
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cerr == nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		if cerr == nil {
+			log.Printf("[HTTPCache] %s unreachable, using cache from %s: %v", url, meta.FetchedAt, err)
+			return body, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cerr == nil:
+		meta.FetchedAt = time.Now()
+		HandleError(c.Store(url, nil, meta))
+		return body, nil
+	case resp.StatusCode == http.StatusOK:
+		nbody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		HandleError(c.Store(url, nbody, &HTTPCacheMeta{
+			URL:          url,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			ContentType:  resp.Header.Get("Content-Type"),
+			FetchedAt:    time.Now(),
+		}))
+		return nbody, nil
+	case cerr == nil:
+		log.Printf("[HTTPCache] %s responsed %s, using cache from %s", url, resp.Status, meta.FetchedAt)
+		return body, nil
+	}
+	return nil, fmt.Errorf("fetch %s: %s", url, resp.Status)
+}
@@ -0,0 +1,339 @@
+// Package logging is the leveled logger of the modules, it prints the lines
+// in the console or the JSON format to stdout or a rotated file
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn (or warning) and error
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// parseLevels parses the default level followed by the ones of the modules,
+// separated by commas, eg: info,engine=debug,torrent=warn
+func parseLevels(s string) (Level, map[string]Level, error) {
+	def := LevelInfo
+	modules := make(map[string]Level)
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		l, err := ParseLevel(kv[len(kv)-1])
+		if err != nil {
+			return def, nil, err
+		}
+		if len(kv) == 1 {
+			def = l
+			continue
+		}
+		module := strings.ToLower(strings.TrimSpace(kv[0]))
+		if module == "" {
+			return def, nil, fmt.Errorf("invalid log level %q", part)
+		}
+		modules[module] = l
+	}
+	return def, modules, nil
+}
+
+// Options of Setup
+type Options struct {
+	// the default level and the ones of the modules, eg: info,engine=debug,torrent=warn
+	Level string
+	// console or json
+	Format string
+	// written to the file instead of stdout when set
+	File string
+	// the file is rotated once larger or older, the rotated ones beyond
+	// MaxBackups are deleted, 0 for no limit
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	// no timestamps in the console format
+	NoTime bool
+}
+
+type state struct {
+	sync.Mutex
+	def     Level
+	modules map[string]Level
+	json    bool
+	noTime  bool
+	out     io.Writer
+	// the file written, nil for stdout
+	file *rotateWriter
+}
+
+var std = state{def: LevelInfo, out: os.Stdout}
+
+// Setup applies the options to all the loggers, the standard log package
+// included, it's logged as the module "app"
+func Setup(o Options) error {
+	def, modules, err := parseLevels(o.Level)
+	if err != nil {
+		return err
+	}
+	var isJSON bool
+	switch strings.ToLower(o.Format) {
+	case "", "console":
+	case "json":
+		isJSON = true
+	default:
+		return fmt.Errorf("unknown log format %q, console or json", o.Format)
+	}
+	var file *rotateWriter
+	if o.File != "" {
+		if file, err = openRotateWriter(o.File, o.MaxSize, o.MaxAge, o.MaxBackups); err != nil {
+			return err
+		}
+	}
+
+	std.Lock()
+	old := std.file
+	std.def, std.modules = def, modules
+	std.json, std.noTime = isJSON, o.NoTime
+	std.file = file
+	std.out = os.Stdout
+	if file != nil {
+		std.out = file
+	}
+	std.Unlock()
+	if old != nil {
+		old.Close()
+	}
+
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(New("app").Writer(LevelInfo))
+	return nil
+}
+
+// Logger logs the lines of a module, the Print functions log at the info level
+type Logger struct {
+	module string
+}
+
+func New(module string) *Logger {
+	return &Logger{module: module}
+}
+
+// Enabled tells if the lines of the level are logged
+func (l *Logger) Enabled(level Level) bool {
+	std.Lock()
+	defer std.Unlock()
+	return level >= std.level(l.module)
+}
+
+// level must hold the lock
+func (s *state) level(module string) Level {
+	if l, ok := s.modules[strings.ToLower(module)]; ok {
+		return l
+	}
+	return s.def
+}
+
+// Log logs msg with the key value pairs kv, added as fields in JSON and
+// dropped by the console format
+func (l *Logger) Log(level Level, msg string, kv ...string) {
+	msg = strings.TrimSuffix(msg, "\n")
+	now := time.Now()
+	std.Lock()
+	defer std.Unlock()
+	if level < std.level(l.module) {
+		return
+	}
+	var line []byte
+	if std.json {
+		line = jsonLine(now, level, l.module, msg, kv)
+	} else {
+		line = consoleLine(now, std.noTime, level, l.module, msg)
+	}
+	std.out.Write(line)
+}
+
+func consoleLine(now time.Time, noTime bool, level Level, module, msg string) []byte {
+	var b bytes.Buffer
+	if !noTime {
+		b.WriteString(now.Format("2006/01/02 15:04:05 "))
+	}
+	if level != LevelInfo {
+		b.WriteString(strings.ToUpper(level.String()))
+		b.WriteByte(' ')
+	}
+	b.WriteString("[" + module + "]")
+	b.WriteString(msg)
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// jsonLine writes the fields in a stable order, the leading [Tag] of the
+// message is its own field
+func jsonLine(now time.Time, level Level, module, msg string, kv []string) []byte {
+	var b bytes.Buffer
+	field := func(k, v string) {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		} else {
+			b.WriteByte('{')
+		}
+		kb, _ := json.Marshal(k)
+		vb, _ := json.Marshal(v)
+		b.Write(kb)
+		b.WriteByte(':')
+		b.Write(vb)
+	}
+	field("time", now.Format(time.RFC3339Nano))
+	field("level", level.String())
+	field("module", module)
+	if tag, rest, ok := splitTag(msg); ok {
+		field("tag", tag)
+		msg = rest
+	}
+	field("msg", msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		field(kv[i], kv[i+1])
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// splitTag splits the leading [Tag] of the message
+func splitTag(msg string) (string, string, bool) {
+	if !strings.HasPrefix(msg, "[") {
+		return "", msg, false
+	}
+	end := strings.IndexByte(msg, ']')
+	if end < 2 || strings.ContainsAny(msg[1:end], " [") {
+		return "", msg, false
+	}
+	return msg[1:end], strings.TrimSpace(msg[end+1:]), true
+}
+
+// sprintln formats like fmt.Sprintln without the newline
+func sprintln(v ...interface{}) string {
+	s := fmt.Sprintln(v...)
+	return s[:len(s)-1]
+}
+
+func (l *Logger) Println(v ...interface{}) {
+	l.Log(LevelInfo, sprintln(v...))
+}
+
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.Log(LevelInfo, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Debug(v ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.Log(LevelDebug, sprintln(v...))
+	}
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.Log(LevelDebug, fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Warn(v ...interface{}) {
+	l.Log(LevelWarn, sprintln(v...))
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.Log(LevelWarn, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Error(v ...interface{}) {
+	l.Log(LevelError, sprintln(v...))
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.Log(LevelError, fmt.Sprintf(format, v...))
+}
+
+// Fatal logs at the error level and exits
+func (l *Logger) Fatal(v ...interface{}) {
+	l.Log(LevelError, fmt.Sprint(v...))
+	Close()
+	os.Exit(1)
+}
+
+// Fatalln logs at the error level and exits
+func (l *Logger) Fatalln(v ...interface{}) {
+	l.Log(LevelError, sprintln(v...))
+	Close()
+	os.Exit(1)
+}
+
+// Panic logs at the error level and panics
+func (l *Logger) Panic(v ...interface{}) {
+	s := sprintln(v...)
+	l.Log(LevelError, s)
+	panic(s)
+}
+
+// Writer returns a writer logging each line written at the level, for the
+// libraries taking an io.Writer or a log.Logger
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{l: l, level: level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.l.Log(w.level, line)
+	}
+	return len(p), nil
+}
+
+// Close closes the log file, the lines go to stdout afterwards
+func Close() {
+	std.Lock()
+	defer std.Unlock()
+	if std.file != nil {
+		std.file.Close()
+		std.file = nil
+		std.out = os.Stdout
+	}
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseLevels(t *testing.T) {
+	def, modules, err := parseLevels("warn, engine=debug,RSS=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Level{"engine": LevelDebug, "rss": LevelError}
+	if def != LevelWarn || !reflect.DeepEqual(modules, want) {
+		t.Errorf("parseLevels() = %v %v, want warn %v", def, modules, want)
+	}
+	if def, _, err := parseLevels(""); err != nil || def != LevelInfo {
+		t.Errorf("parseLevels(\"\") = %v %v", def, err)
+	}
+	for _, s := range []string{"verbose", "engine=loud", "=debug"} {
+		if _, _, err := parseLevels(s); err == nil {
+			t.Errorf("parseLevels(%q) didn't fail", s)
+		}
+	}
+}
+
+func Test_jsonLine(t *testing.T) {
+	now := time.Date(2021, 12, 1, 8, 0, 0, 0, time.UTC)
+	var got map[string]string
+	line := jsonLine(now, LevelWarn, "engine", "[Watcher] a \"b\"", []string{"task", "ab12"})
+	if err := json.Unmarshal(line, &got); err != nil {
+		t.Fatal(err, string(line))
+	}
+	want := map[string]string{"time": "2021-12-01T08:00:00Z", "level": "warn", "module": "engine", "tag": "Watcher", "msg": "a \"b\"", "task": "ab12"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("jsonLine() = %v, want %v", got, want)
+	}
+	if got := string(consoleLine(now, true, LevelInfo, "engine", "[Watcher] a")); got != "[engine][Watcher] a\n" {
+		t.Errorf("consoleLine() = %q", got)
+	}
+}
+
+func Test_splitTag(t *testing.T) {
+	tests := []struct {
+		msg, tag, rest string
+		ok             bool
+	}{
+		{"[Session] loaded", "Session", "loaded", true},
+		{"[DoneCmd:file][abcdef..]ERR: x", "DoneCmd:file", "[abcdef..]ERR: x", true},
+		{"[] x", "", "[] x", false},
+		{"[not a tag] x", "", "[not a tag] x", false},
+		{"plain", "", "plain", false},
+	}
+	for _, tt := range tests {
+		tag, rest, ok := splitTag(tt.msg)
+		if tag != tt.tag || rest != tt.rest || ok != tt.ok {
+			t.Errorf("splitTag(%q) = %q %q %v", tt.msg, tag, rest, ok)
+		}
+	}
+}
+
+func Test_rotateWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "app.log")
+	w, err := openRotateWriter(p, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		w.Write([]byte("12345678\n"))
+		// the rotated files are named by the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+	w.Close()
+	backups, _ := filepath.Glob(p + ".*")
+	if len(backups) != 2 {
+		t.Errorf("backups = %v, want 2", backups)
+	}
+	if data, _ := ioutil.ReadFile(p); strings.Count(string(data), "\n") != 1 {
+		t.Errorf("current file = %q", data)
+	}
+}
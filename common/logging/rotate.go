@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupLayout is appended to the name of the rotated files, sorting by time
+const backupLayout = "20060102-150405.000"
+
+// rotateWriter appends to the file, which is renamed with the time it's
+// rotated at once larger than maxSize or older than maxAge
+type rotateWriter struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func openRotateWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotateWriter, error) {
+	w := &rotateWriter{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.openedAt = f, st.Size(), time.Now()
+	return nil
+}
+
+// due tells if writing n bytes more needs a new file
+func (w *rotateWriter) due(n int, now time.Time) bool {
+	if w.size == 0 {
+		return false
+	}
+	return (w.maxSize > 0 && w.size+int64(n) > w.maxSize) ||
+		(w.maxAge > 0 && now.Sub(w.openedAt) >= w.maxAge)
+}
+
+// Write must hold the lock of the loggers
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+	if now := time.Now(); w.due(len(p), now) {
+		if err := w.rotate(now); err != nil {
+			fmt.Fprintln(os.Stderr, "[logging] rotate:", err)
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) rotate(now time.Time) error {
+	w.f.Close()
+	w.f = nil
+	renameErr := os.Rename(w.path, w.path+"."+now.Format(backupLayout))
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	for _, p := range staleBackups(w.path, w.maxBackups) {
+		os.Remove(p)
+	}
+	return nil
+}
+
+// staleBackups lists the rotated files of path but the latest keep ones,
+// none with keep 0
+func staleBackups(path string, keep int) []string {
+	if keep <= 0 {
+		return nil
+	}
+	matches, _ := filepath.Glob(path + ".*")
+	var backups []string
+	for _, m := range matches {
+		if _, err := time.Parse(backupLayout, strings.TrimPrefix(m, path+".")); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	sort.Strings(backups)
+	return backups[:len(backups)-keep]
+}
+
+func (w *rotateWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package selfupdate
+
+import (
+	"os"
+	"syscall"
+)
+
+// Restart replaces the process by exe with the same arguments and environment,
+// the listening sockets are closed on exec
+func Restart(exe string) error {
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
+
+// WaitParent does nothing, the restart keeps the process
+func WaitParent() {}
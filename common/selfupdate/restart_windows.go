@@ -0,0 +1,49 @@
+package selfupdate
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// the pid of the restarting process, waited by the new one
+const parentEnv = "SIMPLE_TORRENT_RESTART_PARENT"
+
+// Restart starts exe with the same arguments and exits, there's no exec on
+// windows. The new process waits in WaitParent for this one to exit, as it
+// holds the listening port and the lock of the database till then.
+func Restart(exe string) error {
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), parentEnv+"="+strconv.Itoa(os.Getpid()))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// WaitParent waits up to a minute for the process restarting into this one
+// to exit
+func WaitParent() {
+	pid, err := strconv.Atoi(os.Getenv(parentEnv))
+	os.Unsetenv(parentEnv)
+	if err != nil {
+		return
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		// exited already
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		p.Wait() // nolint: errcheck
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+	}
+}
@@ -0,0 +1,374 @@
+// Package selfupdate replaces the running binary by the one of the latest
+// GitHub release for the platform, verified by its checksum and signature.
+//
+// The assets are named as by scripts/make_release.sh, eg:
+// cloud-torrent_linux_amd64_static.gz, each with a <asset>.sha256 checksum and
+// a <asset>.sha256.sig ed25519 signature of the tag, the asset name and the
+// checksum (see SignedMessage). A checksum only proves the download intact, a
+// binary without PublicKey installs only if AllowUnsigned.
+package selfupdate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRepo = "boypt/simple-torrent"
+	assetPrefix = "cloud-torrent"
+	// the binaries are a few tens of MB, more is refused
+	maxAssetSize = 256 << 20
+)
+
+// PublicKey is the base64 ed25519 key of the release signatures, set with
+// -ldflags "-X github.com/boypt/simple-torrent/common/selfupdate.PublicKey=...".
+// Without it the releases aren't authenticated, see Updater.AllowUnsigned.
+var PublicKey = ""
+
+var (
+	ErrNoAsset    = errors.New("no binary of this platform in the release")
+	ErrNoChecksum = errors.New("no checksum of the binary in the release")
+	ErrChecksum   = errors.New("checksum mismatch")
+	ErrSignature  = errors.New("invalid signature of the release")
+	ErrUnsigned   = errors.New("no public key built in to verify the release, allow the unsigned update to install it by the checksum only")
+	ErrNotNewer   = errors.New("already the latest release")
+)
+
+// Release is the latest release checked against the running version
+type Release struct {
+	Current   string
+	Latest    string
+	Available bool
+	URL       string
+	Asset     string
+	CheckedAt time.Time
+
+	assetURL, sumURL, sigURL string
+}
+
+type ghAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+type ghRelease struct {
+	TagName string    `json:"tag_name"`
+	HTMLURL string    `json:"html_url"`
+	Assets  []ghAsset `json:"assets"`
+}
+
+// Updater checks and installs the releases of Repo
+type Updater struct {
+	Repo    string
+	Version string
+	Client  *http.Client
+	// AllowUnsigned installs the releases checked by the checksum only when
+	// the binary has no PublicKey
+	AllowUnsigned bool
+}
+
+func (u *Updater) client() *http.Client {
+	if u.Client != nil {
+		return u.Client
+	}
+	return &http.Client{Timeout: 5 * time.Minute}
+}
+
+func (u *Updater) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "simple-torrent/"+u.Version)
+	resp, err := u.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// Check fetches the latest release and picks the binary of this platform
+func (u *Updater) Check(ctx context.Context) (*Release, error) {
+	repo := u.Repo
+	if repo == "" {
+		repo = DefaultRepo
+	}
+	resp, err := u.get(ctx, "https://api.github.com/repos/"+repo+"/releases/latest")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var gr ghRelease
+	if err := json.NewDecoder(resp.Body).Decode(&gr); err != nil {
+		return nil, err
+	}
+
+	r := &Release{
+		Current:   u.Version,
+		Latest:    gr.TagName,
+		URL:       gr.HTMLURL,
+		CheckedAt: time.Now(),
+	}
+	r.Available = Newer(gr.TagName, u.Version)
+	byName := make(map[string]string, len(gr.Assets))
+	var names []string
+	for _, a := range gr.Assets {
+		byName[a.Name] = a.URL
+		names = append(names, a.Name)
+	}
+	if r.Asset = PickAsset(names, runtime.GOOS, runtime.GOARCH); r.Asset != "" {
+		r.assetURL = byName[r.Asset]
+		r.sumURL = byName[r.Asset+".sha256"]
+		r.sigURL = byName[r.Asset+".sha256.sig"]
+	}
+	return r, nil
+}
+
+// PickAsset returns the binary of the platform among the names of the
+// assets, the static build preferred, empty if none. The binaries are
+// plain or gzipped.
+func PickAsset(names []string, goos, goarch string) string {
+	prefix := assetPrefix + "_" + goos + "_" + goarch
+	var plain string
+	for _, n := range names {
+		if !strings.HasPrefix(n, prefix) {
+			continue
+		}
+		rest := strings.TrimSuffix(strings.TrimSuffix(n[len(prefix):], ".gz"), ".exe")
+		switch rest {
+		case "_static":
+			return n
+		case "":
+			if plain == "" {
+				plain = n
+			}
+		}
+	}
+	return plain
+}
+
+// Newer tells whether the version latest is after current, both dotted
+// numbers with an optional v prefix. A development build like 0.0.0-src or
+// a commit hash is never older.
+func Newer(latest, current string) bool {
+	l, ok1 := parseVersion(latest)
+	c, ok2 := parseVersion(current)
+	if !ok1 || !ok2 {
+		return false
+	}
+	for i := 0; i < len(l) || i < len(c); i++ {
+		var a, b int
+		if i < len(l) {
+			a = l[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return false
+}
+
+// parseVersion parses 1.2.3 out of v1.2.3 or 1.2.3-4-gabcdef
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var nums []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, false
+		}
+		nums = append(nums, n)
+	}
+	return nums, len(nums) > 0 && !(len(nums) == 3 && nums[0] == 0 && nums[1] == 0 && nums[2] == 0)
+}
+
+// Apply downloads the binary of r, verifies it and swaps it with the
+// running executable, whose path is returned. It refuses a release that
+// isn't newer unless force, and an unsigned one unless AllowUnsigned.
+func (u *Updater) Apply(ctx context.Context, r *Release, force bool) (string, error) {
+	if !r.Available && !force {
+		return "", ErrNotNewer
+	}
+	if PublicKey == "" && !u.AllowUnsigned {
+		return "", ErrUnsigned
+	}
+	if r.assetURL == "" {
+		return "", ErrNoAsset
+	}
+	if r.sumURL == "" {
+		return "", ErrNoChecksum
+	}
+	sum, err := u.fetchSmall(ctx, r.sumURL)
+	if err != nil {
+		return "", err
+	}
+	want, err := ParseChecksum(sum)
+	if err != nil {
+		return "", err
+	}
+	if PublicKey != "" {
+		if r.sigURL == "" {
+			return "", ErrSignature
+		}
+		sig, err := u.fetchSmall(ctx, r.sigURL)
+		if err != nil {
+			return "", err
+		}
+		if err := VerifySignature(PublicKey, SignedMessage(r.Latest, r.Asset, want), sig); err != nil {
+			return "", err
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	resp, err := u.get(ctx, r.assetURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// next to the executable for an atomic rename
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), "."+filepath.Base(exe)+".update-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeAsset(tmp, resp.Body, want, strings.HasSuffix(r.Asset, ".gz")); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", err
+	}
+	if err := swap(tmp.Name(), exe); err != nil {
+		return "", err
+	}
+	return exe, nil
+}
+
+// writeAsset checks the sha256 of the downloaded asset and writes it to f,
+// gunzipped if gz
+func writeAsset(f *os.File, body io.Reader, want []byte, gz bool) error {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxAssetSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxAssetSize {
+		return fmt.Errorf("binary larger than %d bytes", maxAssetSize)
+	}
+	if got := sha256.Sum256(data); !bytes.Equal(got[:], want) {
+		return ErrChecksum
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if gz {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	_, err = io.Copy(f, io.LimitReader(r, maxAssetSize))
+	return err
+}
+
+func (u *Updater) fetchSmall(ctx context.Context, url string) ([]byte, error) {
+	resp, err := u.get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+}
+
+// ParseChecksum reads the hex sha256 of a sha256sum(1) line or a bare hash
+func ParseChecksum(data []byte) ([]byte, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return nil, ErrNoChecksum
+	}
+	sum, err := hex.DecodeString(fields[0])
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid checksum %q", fields[0])
+	}
+	return sum, nil
+}
+
+// SignedMessage is what the release signs of an asset: its tag and name with
+// the checksum, so that the signature of another release or another asset
+// doesn't pass for it
+func SignedMessage(tag, asset string, sum []byte) []byte {
+	return []byte(fmt.Sprintf("simple-torrent %s %s %x\n", tag, asset, sum))
+}
+
+// VerifySignature checks the ed25519 signature, raw or base64, of msg by the
+// base64 public key
+func VerifySignature(key string, msg, sig []byte) error {
+	pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+			return ErrSignature
+		}
+	}
+	if len(sig) != ed25519.SignatureSize || !ed25519.Verify(pub, msg, sig) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// swap renames the new binary over exe. Windows can't replace a running
+// executable, there it's moved aside first.
+func swap(newPath, exe string) error {
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(newPath, exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(newPath, exe)
+}
@@ -0,0 +1,141 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPickAsset(t *testing.T) {
+	names := []string{
+		"cloud-torrent_linux_amd64.gz",
+		"cloud-torrent_linux_amd64_static.gz",
+		"cloud-torrent_linux_amd64_static.gz.sha256",
+		"cloud-torrent_linux_arm64.gz",
+		"cloud-torrent_windows_amd64.exe.gz",
+	}
+	tests := []struct {
+		goos, goarch, want string
+	}{
+		{"linux", "amd64", "cloud-torrent_linux_amd64_static.gz"},
+		{"linux", "arm64", "cloud-torrent_linux_arm64.gz"},
+		{"windows", "amd64", "cloud-torrent_windows_amd64.exe.gz"},
+		{"linux", "386", ""},
+	}
+	for _, tt := range tests {
+		if got := PickAsset(names, tt.goos, tt.goarch); got != tt.want {
+			t.Errorf("PickAsset(%s/%s) = %q, want %q", tt.goos, tt.goarch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyUnsigned(t *testing.T) {
+	if PublicKey != "" {
+		t.Skip("built with a public key")
+	}
+	r := &Release{Available: true}
+	if _, err := (&Updater{}).Apply(context.Background(), r, false); err != ErrUnsigned {
+		t.Errorf("Apply without a key = %v, want %v", err, ErrUnsigned)
+	}
+	if _, err := (&Updater{AllowUnsigned: true}).Apply(context.Background(), r, false); err != ErrNoAsset {
+		t.Errorf("Apply allowed unsigned = %v, want %v", err, ErrNoAsset)
+	}
+}
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		want            bool
+	}{
+		{"1.3.4", "1.3.3", true},
+		{"v1.3.10", "1.3.9-2-gabcdef", true},
+		{"1.3", "1.3.0", false},
+		{"1.3.3", "1.3.3", false},
+		{"1.2.9", "1.3.0", false},
+		{"1.3.4", "0.0.0-src", false},
+		{"1.3.4", "abcdef", false},
+	}
+	for _, tt := range tests {
+		if got := Newer(tt.latest, tt.current); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v", tt.latest, tt.current, got)
+		}
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	const hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	for _, s := range []string{hash, hash + "  cloud-torrent_linux_amd64.gz\n"} {
+		if _, err := ParseChecksum([]byte(s)); err != nil {
+			t.Errorf("ParseChecksum(%q): %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "abc", hash[:10]} {
+		if _, err := ParseChecksum([]byte(s)); err == nil {
+			t.Errorf("ParseChecksum(%q) succeeded", s)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(pub)
+	msg := []byte("checksum\n")
+	sig := ed25519.Sign(priv, msg)
+	if err := VerifySignature(key, msg, sig); err != nil {
+		t.Errorf("raw signature: %v", err)
+	}
+	if err := VerifySignature(key, msg, []byte(base64.StdEncoding.EncodeToString(sig)+"\n")); err != nil {
+		t.Errorf("base64 signature: %v", err)
+	}
+	if err := VerifySignature(key, []byte("other"), sig); err != ErrSignature {
+		t.Errorf("other message: %v", err)
+	}
+}
+
+func TestApplySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	sum, _ := hex.DecodeString(hash)
+	const asset = "cloud-torrent_linux_amd64.gz"
+	sig := ed25519.Sign(priv, SignedMessage("1.3.3", asset, sum))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sum":
+			fmt.Fprintf(w, "%s  %s\n", hash, asset)
+		case "/sig":
+			w.Write(sig)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	old := PublicKey
+	PublicKey = base64.StdEncoding.EncodeToString(pub)
+	defer func() { PublicKey = old }()
+	// the signature of 1.3.3 passes for neither another release nor another asset
+	for _, r := range []*Release{
+		{Available: true, Latest: "1.3.4", Asset: asset},
+		{Available: true, Latest: "1.3.3", Asset: "cloud-torrent_linux_arm64.gz"},
+	} {
+		r.assetURL, r.sumURL, r.sigURL = ts.URL+"/asset", ts.URL+"/sum", ts.URL+"/sig"
+		if _, err := (&Updater{}).Apply(context.Background(), r, false); err != ErrSignature {
+			t.Errorf("Apply(%s %s) = %v, want %v", r.Latest, r.Asset, err, ErrSignature)
+		}
+	}
+	if err := VerifySignature(PublicKey, SignedMessage("1.3.3", asset, sum), sig); err != nil {
+		t.Errorf("signature of the release: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportQueue    = 2048
+	exportBatch    = 512
+	exportInterval = 5 * time.Second
+	exportTimeout  = 10 * time.Second
+)
+
+// exporter posts the spans in batches to an OTLP/HTTP endpoint, encoded as
+// JSON. The spans are dropped when the collector can't keep up.
+type exporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	spans    chan *Span
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func newExporter(endpoint, service string) *exporter {
+	x := &exporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: exportTimeout},
+		spans:    make(chan *Span, exportQueue),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go x.run()
+	return x
+}
+
+func (x *exporter) add(s *Span) {
+	select {
+	case x.spans <- s:
+	default:
+	}
+}
+
+// stop flushes the pending spans
+func (x *exporter) stop() {
+	close(x.quit)
+	<-x.done
+}
+
+func (x *exporter) run() {
+	defer close(x.done)
+	tick := time.NewTicker(exportInterval)
+	defer tick.Stop()
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := x.post(batch); err != nil {
+			log.Warnf("[Export] %d spans to %s: %v", len(batch), x.endpoint, err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-x.spans:
+			if batch = append(batch, s); len(batch) >= exportBatch {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		case <-x.quit:
+			for {
+				select {
+				case s := <-x.spans:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// the OTLP JSON encoding of the traces
+type (
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttr `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+)
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	statusOK         = 1
+	statusError      = 2
+)
+
+func attrs(kv ...string) []otlpAttr {
+	var as []otlpAttr
+	for i := 0; i+1 < len(kv); i += 2 {
+		as = append(as, otlpAttr{kv[i], otlpValue{kv[i+1]}})
+	}
+	return as
+}
+
+// encode converts the spans to an OTLP request
+func encode(service string, spans []*Span) otlpRequest {
+	ss := otlpScopeSpans{}
+	ss.Scope.Name = "github.com/boypt/simple-torrent"
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:      s.TraceID,
+			SpanID:       s.SpanID,
+			ParentSpanID: s.ParentID,
+			Name:         s.Name,
+			Kind:         spanKindInternal,
+			Start:        strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:   attrs(s.Attrs...),
+			Status:       otlpStatus{Code: statusOK},
+		}
+		if s.RequestID != "" {
+			o.Attributes = append(o.Attributes, attrs("request.id", s.RequestID)...)
+		}
+		if s.Err != "" {
+			o.Status = otlpStatus{Code: statusError, Message: s.Err}
+		}
+		s.mu.Unlock()
+		if strings.HasPrefix(s.Name, "HTTP ") {
+			o.Kind = spanKindServer
+		}
+		ss.Spans = append(ss.Spans, o)
+	}
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{ss}}
+	rs.Resource.Attributes = attrs("service.name", service)
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+func (x *exporter) post(spans []*Span) error {
+	body, err := json.Marshal(encode(x.service, spans))
+	if err != nil {
+		return err
+	}
+	resp, err := x.client.Post(x.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
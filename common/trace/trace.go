@@ -0,0 +1,281 @@
+// Package trace times the operations of the requests and the engine: the
+// spans carry the request ID of the API request they were started from,
+// the ones over the slow threshold are logged, and all of them are exported
+// to an OpenTelemetry collector when an endpoint is set.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+)
+
+var log = logging.New("trace")
+
+// HeaderRequestID is the header of the request IDs, taken from the client
+// or the proxy when given
+const HeaderRequestID = "X-Request-Id"
+
+// Options of the default tracer
+type Options struct {
+	// the operations taking longer are logged, 0 for never
+	SlowThreshold time.Duration
+	// OTLP/HTTP traces endpoint, eg: http://localhost:4318/v1/traces
+	Endpoint string
+	// resource service.name of the exported spans
+	Service string
+}
+
+type tracer struct {
+	sync.RWMutex
+	opts     Options
+	exporter *exporter
+}
+
+var std tracer
+
+// Configure sets the options of the tracer, the exporter is started or
+// stopped by the Endpoint
+func Configure(o Options) {
+	std.Lock()
+	defer std.Unlock()
+	if o.Service == "" {
+		o.Service = "simple-torrent"
+	}
+	if std.exporter != nil && (std.opts.Endpoint != o.Endpoint || std.opts.Service != o.Service) {
+		std.exporter.stop()
+		std.exporter = nil
+	}
+	if std.exporter == nil && o.Endpoint != "" {
+		std.exporter = newExporter(o.Endpoint, o.Service)
+	}
+	std.opts = o
+}
+
+type ctxKey int
+
+const (
+	spanKey ctxKey = iota
+	requestIDKey
+)
+
+// Span is a timed operation
+type Span struct {
+	Name      string
+	TraceID   string
+	SpanID    string
+	ParentID  string
+	RequestID string
+	Start     time.Time
+	End       time.Time
+	Attrs     []string
+	Err       string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	return randomHex(8)
+}
+
+// WithRequestID returns ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID of ctx, empty if none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// FromContext returns the current span of ctx, nil if none
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// Start starts a span, the child of the span of ctx if any, with the
+// attributes as key value pairs
+func Start(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	s := &Span{
+		Name:      name,
+		SpanID:    randomHex(8),
+		RequestID: RequestID(ctx),
+		Start:     time.Now(),
+		Attrs:     attrs,
+	}
+	if p := FromContext(ctx); p != nil {
+		s.TraceID, s.ParentID = p.TraceID, p.SpanID
+	} else {
+		s.TraceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+// SetAttr adds an attribute to the span
+func (s *Span) SetAttr(key, value string) {
+	s.mu.Lock()
+	s.Attrs = append(s.Attrs, key, value)
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed by err, if not nil
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// Finish ends the span, logged if slow and exported
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	std.RLock()
+	slow := std.opts.SlowThreshold
+	exp := std.exporter
+	std.RUnlock()
+	if d := s.End.Sub(s.Start); slow > 0 && d >= slow {
+		s.logSlow(d)
+	}
+	if exp != nil {
+		exp.add(s)
+	}
+}
+
+// Observe records an operation started at start when it took longer than the
+// slow threshold, cheap for the hot paths not worth a span each
+func Observe(ctx context.Context, name string, start time.Time, attrs ...string) {
+	std.RLock()
+	slow := std.opts.SlowThreshold
+	std.RUnlock()
+	if slow <= 0 || time.Since(start) < slow {
+		return
+	}
+	_, s := Start(ctx, name, attrs...)
+	s.Start = start
+	s.Finish()
+}
+
+func (s *Span) logSlow(d time.Duration) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Slow] %s took %s", s.Name, d.Round(time.Millisecond))
+	kv := []string{"trace_id", s.TraceID, "duration", d.String()}
+	if s.RequestID != "" {
+		fmt.Fprintf(&b, " request=%s", s.RequestID)
+		kv = append(kv, "request_id", s.RequestID)
+	}
+	for i := 0; i+1 < len(s.Attrs); i += 2 {
+		fmt.Fprintf(&b, " %s=%s", s.Attrs[i], s.Attrs[i+1])
+	}
+	kv = append(kv, s.Attrs...)
+	if s.Err != "" {
+		fmt.Fprintf(&b, " error=%q", s.Err)
+		kv = append(kv, "error", s.Err)
+	}
+	log.Log(logging.LevelWarn, b.String(), kv...)
+}
+
+// validID accepts the request IDs of up to 64 letters, digits, - _ and .
+func validID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent reads the W3C traceparent header, 00-<trace id>-<span id>-<flags>
+func parseTraceparent(h string) *Span {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil
+	}
+	for _, p := range parts[1:3] {
+		if b, err := hex.DecodeString(p); err != nil || strings.Trim(p, "0") == "" || len(b) == 0 {
+			return nil
+		}
+	}
+	return &Span{TraceID: strings.ToLower(parts[1]), SpanID: strings.ToLower(parts[2]), ended: true}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush keeps the streams of the events working
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Handler gives each request an ID, sent back in the X-Request-Id header,
+// and a span in its context
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if !validID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		ctx := WithRequestID(r.Context(), id)
+		if p := parseTraceparent(r.Header.Get("Traceparent")); p != nil {
+			// the remote parent of the caller
+			ctx = context.WithValue(ctx, spanKey, p)
+		}
+		// the streams last as long as the client, never slow
+		if r.Header.Get("Accept") == "text/event-stream" {
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		ctx, span := Start(ctx, "HTTP "+r.Method,
+			"http.method", r.Method, "http.target", r.URL.Path)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			span.SetAttr("http.status_code", fmt.Sprint(sw.status))
+			if sw.status >= 500 {
+				span.SetError(fmt.Errorf("HTTP %d", sw.status))
+			}
+			span.Finish()
+		}()
+		h.ServeHTTP(sw, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,67 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "req1")
+	ctx, parent := Start(ctx, "HTTP POST")
+	_, child := Start(ctx, "engine.AddTask", "infohash", "abc")
+	if child.TraceID != parent.TraceID || child.ParentID != parent.SpanID || child.RequestID != "req1" {
+		t.Errorf("child = %+v, parent = %+v", child, parent)
+	}
+	child.SetError(errors.New("boom"))
+	child.Finish()
+	parent.Finish()
+
+	req := encode("svc", []*Span{parent, child})
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Kind != spanKindServer || spans[1].Status.Code != statusError {
+		t.Errorf("encoded = %+v", spans)
+	}
+	if a := spans[1].Attributes; len(a) != 2 || a[0].Key != "infohash" || a[1].Value.StringValue != "req1" {
+		t.Errorf("attributes = %+v", a)
+	}
+}
+
+func Test_parseTraceparent(t *testing.T) {
+	p := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if p == nil || p.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || p.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("parseTraceparent() = %+v", p)
+	}
+	for _, h := range []string{"", "00-abc-def-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if p := parseTraceparent(h); p != nil {
+			t.Errorf("parseTraceparent(%q) = %+v", h, p)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var got string
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+		if FromContext(r.Context()) == nil {
+			t.Error("no span")
+		}
+	}))
+
+	r := httptest.NewRequest("GET", "/api/torrents", nil)
+	r.Header.Set(HeaderRequestID, "proxy-id.1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got != "proxy-id.1" || w.Header().Get(HeaderRequestID) != got {
+		t.Errorf("request id = %q, header %q", got, w.Header().Get(HeaderRequestID))
+	}
+
+	r.Header.Set(HeaderRequestID, "bad\nid")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got == "bad\nid" || len(got) != 16 || w.Header().Get(HeaderRequestID) != got {
+		t.Errorf("request id = %q", got)
+	}
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"log"
 	"runtime"
+	"strings"
 )
 
 func HandleError(err error) (b bool) {
@@ -34,3 +35,17 @@ func Must(err error) {
 		panic(err)
 	}
 }
+
+// SplitLines splits a multiline config value, empty lines and lines
+// starting with # are skipped
+func SplitLines(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// the sources of the adds given to the AddHook
+const (
+	SourceAPI   = "api"
+	SourceRSS   = "rss"
+	SourceWatch = "watch"
+)
+
+const (
+	AddAccept = "accept"
+	AddReject = "reject"
+
+	defaultAddHookTimeout = 10 * time.Second
+)
+
+// addHookInput is written to the stdin of the AddHook, the size and the
+// files of a magnet are unknown
+type addHookInput struct {
+	InfoHash string
+	Name     string
+	Size     int64
+	Files    []string
+	Trackers []string
+	Source   string
+	Owner    string `json:",omitempty"`
+	Dir      string `json:",omitempty"`
+}
+
+// addDecision is the optional JSON printed by the AddHook
+type addDecision struct {
+	Decision string
+	Label    string
+	Paused   bool
+	Reason   string
+}
+
+// parseAddDecision reads the output of the AddHook, empty output accepts
+func parseAddDecision(out []byte) (*addDecision, error) {
+	d := &addDecision{}
+	if out = bytes.TrimSpace(out); len(out) > 0 {
+		if err := json.Unmarshal(out, d); err != nil {
+			return nil, fmt.Errorf("invalid output: %w", err)
+		}
+	}
+	switch d.Decision = strings.ToLower(d.Decision); d.Decision {
+	case "":
+		d.Decision = AddAccept
+	case AddAccept, AddReject:
+	default:
+		return nil, fmt.Errorf("unknown decision %q", d.Decision)
+	}
+	return d, nil
+}
+
+// runAddHook asks the AddHook about a new task, nil without the hook. A
+// non-zero exit rejects the add with the stderr as the reason, the hook
+// failing to run or timing out accepts it
+func (e *Engine) runAddHook(ih string, spec *torrent.TorrentSpec, dir string) (*addDecision, error) {
+	if e.config.AddHook == "" {
+		return nil, nil
+	}
+	in := addHookInput{
+		InfoHash: ih,
+		Name:     spec.DisplayName,
+		Trackers: flattenTrackers(spec.Trackers),
+		Dir:      dir,
+	}
+	in.Source, in.Owner = e.presetSource(ih)
+	if len(spec.InfoBytes) > 0 {
+		var info metainfo.Info
+		if err := bencode.Unmarshal(spec.InfoBytes, &info); err == nil {
+			in.Name, in.Size = info.Name, info.TotalLength()
+			for _, f := range info.UpvertedFiles() {
+				in.Files = append(in.Files, strings.Join(append([]string{info.Name}, f.Path...), "/"))
+			}
+		}
+	}
+	data, _ := json.Marshal(in)
+
+	timeout := e.config.AddHookTimeout
+	if timeout <= 0 {
+		timeout = defaultAddHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, e.config.AddHook)
+	if err := prepareDoneCmd(cmd, &e.config); err != nil {
+		log.Warnf("[AddHook] %s ERR: %v, accepted", ih, err)
+		return nil, nil
+	}
+	cmd.Env = append(doneCmdEnv(os.Environ(), e.config.DoneCmdEnv),
+		"CLD_HASH="+ih, "CLD_SOURCE="+in.Source)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		log.Warnf("[AddHook] %s timed out after %s, accepted", ih, timeout)
+		return nil, nil
+	case errors.As(err, &exit):
+		reason := strings.TrimSpace(stderr.String())
+		if reason == "" {
+			reason = exit.Error()
+		}
+		return e.logAddDecision(in, &addDecision{Decision: AddReject, Reason: reason})
+	case err != nil:
+		log.Warnf("[AddHook] %s ERR: %v, accepted", ih, err)
+		return nil, nil
+	}
+	d, err := parseAddDecision(stdout.Bytes())
+	if err != nil {
+		log.Warnf("[AddHook] %s %v, accepted", ih, err)
+		return nil, nil
+	}
+	return e.logAddDecision(in, d)
+}
+
+// logAddDecision logs the decision, a PolicyError for the rejected add
+func (e *Engine) logAddDecision(in addHookInput, d *addDecision) (*addDecision, error) {
+	msg := fmt.Sprintf("[AddHook] %s %q from %s: %s", in.InfoHash, in.Name, in.Source, d.Decision)
+	if d.Label != "" {
+		msg += " label=" + d.Label
+	}
+	if d.Paused {
+		msg += " paused"
+	}
+	if d.Reason != "" {
+		msg += " (" + d.Reason + ")"
+	}
+	log.Println(msg)
+	if d.Decision == AddReject {
+		reason := "AddHook"
+		if d.Reason != "" {
+			reason += ": " + d.Reason
+		}
+		return d, &PolicyError{reason}
+	}
+	return d, nil
+}
+
+// applyAddDecision sets the label and the paused state decided for the task
+func applyAddDecision(t *Torrent, d *addDecision) {
+	if d == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if d.Label != "" {
+		t.Label = d.Label
+	}
+	if d.Paused {
+		t.resumeStarted, t.resumeStopped = false, true
+	}
+}
@@ -0,0 +1,28 @@
+package engine
+
+import "testing"
+
+func Test_parseAddDecision(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    addDecision
+		wantErr bool
+	}{
+		{"", addDecision{Decision: AddAccept}, false},
+		{" \n", addDecision{Decision: AddAccept}, false},
+		{`{"Label":"tv","Paused":true}`, addDecision{Decision: AddAccept, Label: "tv", Paused: true}, false},
+		{`{"Decision":"REJECT","Reason":"too old"}`, addDecision{Decision: AddReject, Reason: "too old"}, false},
+		{`{"Decision":"maybe"}`, addDecision{}, true},
+		{"accept", addDecision{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseAddDecision([]byte(tt.out))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAddDecision(%q) error = %v, wantErr %v", tt.out, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseAddDecision(%q) = %+v, want %+v", tt.out, *got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/dustin/go-humanize"
+)
+
+// EventAlert is published when a task crosses AlertUploadTotal or
+// downloads below AlertMinSpeed for AlertSlowTime
+const EventAlert = "alert"
+
+// parseByteSize parses the sizes of the config like 5GB, 0 when empty
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	var v datasize.ByteSize
+	if err := v.UnmarshalText([]byte(strings.ToLower(s))); err != nil {
+		return 0, err
+	}
+	return int64(v), nil
+}
+
+// alert records and publishes an EventAlert of the task, must hold the lock of t
+func (e *Engine) alert(t *Torrent, msg string) {
+	log.Printf("[Alert] %s %s", t.InfoHash, msg)
+	ev := Event{
+		Type:     EventAlert,
+		InfoHash: t.InfoHash,
+		Name:     t.Name,
+		Size:     t.Size,
+		Message:  msg,
+		Time:     time.Now(),
+	}
+	e.record(ev)
+	e.bus.publish(ev)
+}
+
+// checkAlerts alerts once when the task uploaded AlertUploadTotal, and once
+// per slow period when it downloads below AlertMinSpeed for AlertSlowTime
+func (e *Engine) checkAlerts(t *Torrent) {
+	upTotal, err := parseByteSize(e.config.AlertUploadTotal)
+	if err != nil {
+		log.Warn("[Alert] AlertUploadTotal", err)
+	}
+	minSpeed, err := parseByteSize(e.config.AlertMinSpeed)
+	if err != nil {
+		log.Warn("[Alert] AlertMinSpeed", err)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if upTotal > 0 && !t.uploadAlerted && t.Uploaded >= upTotal {
+		t.uploadAlerted = true
+		e.alert(t, fmt.Sprintf("uploaded %s, over %s", humanize.IBytes(uint64(t.Uploaded)), humanize.IBytes(uint64(upTotal))))
+	}
+
+	downloading := t.Loaded && t.Started && !t.Done
+	if minSpeed <= 0 || e.config.AlertSlowTime <= 0 || !downloading || int64(t.DownloadRate) >= minSpeed {
+		t.slowSince = time.Time{}
+		t.slowAlerted = false
+		return
+	}
+	now := time.Now()
+	if t.slowSince.IsZero() {
+		t.slowSince = now
+	}
+	if !t.slowAlerted && now.Sub(t.slowSince) >= e.config.AlertSlowTime {
+		t.slowAlerted = true
+		e.alert(t, fmt.Sprintf("downloading at %s/s, below %s/s for %s",
+			humanize.IBytes(uint64(t.DownloadRate)), humanize.IBytes(uint64(minSpeed)), e.config.AlertSlowTime))
+	}
+}
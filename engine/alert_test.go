@@ -0,0 +1,28 @@
+package engine
+
+import "testing"
+
+func Test_parseByteSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"20KB", 20 << 10, false},
+		{" 50gb ", 50 << 30, false},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseByteSize(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseByteSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseByteSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
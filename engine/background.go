@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// errBackgroundCanceled is returned by acquire when the work is dropped while waiting
+var errBackgroundCanceled = errors.New("background work canceled")
+
+// backgroundWork caps the CPU taken by the work not asked for by a peer or a
+// stream: the verifications, the hashing of the created torrents and the
+// extraction of the archives. At most
+// BackgroundWorkers jobs run at once, each throttled to BackgroundCPU percent
+// of a core, on threads niced to BackgroundNice where the work is ours to run.
+type backgroundWork struct {
+	sync.Mutex
+	slots chan struct{}
+	cpu   int
+	nice  int
+	// jobs waiting for a worker
+	waiting int
+}
+
+// BackgroundStats reports the background jobs against the limits
+type BackgroundStats struct {
+	Workers int
+	Running int
+	Waiting int
+	CPU     int
+	Nice    int
+}
+
+func (c *Config) checkBackground() error {
+	if c.BackgroundWorkers < 0 {
+		return fmt.Errorf("Invalid BackgroundWorkers (%d)", c.BackgroundWorkers)
+	}
+	if c.BackgroundCPU < 0 || c.BackgroundCPU > 100 {
+		return fmt.Errorf("Invalid BackgroundCPU (%d), expecting a percent from 0 to 100", c.BackgroundCPU)
+	}
+	if c.BackgroundNice < 0 || c.BackgroundNice > 19 {
+		return fmt.Errorf("Invalid BackgroundNice (%d), expecting 0 to 19", c.BackgroundNice)
+	}
+	return nil
+}
+
+// configure sets the limits, the running jobs keep the worker they hold
+func (w *backgroundWork) configure(workers, cpu, nice int) {
+	w.Lock()
+	defer w.Unlock()
+	if workers > 0 && (w.slots == nil || cap(w.slots) != workers) {
+		w.slots = make(chan struct{}, workers)
+	} else if workers <= 0 {
+		w.slots = nil
+	}
+	w.cpu = cpu
+	w.nice = nice
+}
+
+// acquire waits for a free worker, it returns the release of the worker or
+// errBackgroundCanceled once drop or closeSync is closed, nil ones never are
+func (w *backgroundWork) acquire(drop, closeSync <-chan struct{}) (func(), error) {
+	w.Lock()
+	slots := w.slots
+	w.waiting++
+	w.Unlock()
+	defer func() {
+		w.Lock()
+		w.waiting--
+		w.Unlock()
+	}()
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-drop:
+	case <-closeSync:
+	}
+	return nil, errBackgroundCanceled
+}
+
+// pause is the sleep after a unit of work that took d, for the work to take
+// cpu percent of the time
+func (w *backgroundWork) pause(d time.Duration) time.Duration {
+	w.Lock()
+	cpu := w.cpu
+	w.Unlock()
+	if cpu <= 0 || cpu >= 100 {
+		return 0
+	}
+	return d * time.Duration(100-cpu) / time.Duration(cpu)
+}
+
+// throttle sleeps the pause of d, cut short by cancel
+func (w *backgroundWork) throttle(d time.Duration, cancel <-chan struct{}) {
+	p := w.pause(d)
+	if p <= 0 {
+		return
+	}
+	tm := time.NewTimer(p)
+	defer tm.Stop()
+	select {
+	case <-tm.C:
+	case <-cancel:
+	}
+}
+
+// run calls fn on a thread niced to BackgroundNice, fn is called directly
+// without a nice level or where threads can't be niced
+func (w *backgroundWork) run(fn func()) {
+	w.Lock()
+	nice := w.nice
+	w.Unlock()
+	if nice <= 0 || !threadNiceSupported {
+		fn()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the thread is never unlocked, it exits with the goroutine instead
+		// of going back to the scheduler niced
+		runtime.LockOSThread()
+		if err := setThreadNice(nice); err != nil {
+			log.Warn("[Background] nice", err)
+		}
+		fn()
+	}()
+	<-done
+}
+
+// niceCmd lowers the started command to BackgroundNice
+func (w *backgroundWork) niceCmd(pid int) {
+	w.Lock()
+	nice := w.nice
+	w.Unlock()
+	if nice <= 0 {
+		return
+	}
+	if err := setCmdNice(pid, nice); err != nil {
+		log.Warn("[Background] nice", err)
+	}
+}
+
+func (w *backgroundWork) stats() BackgroundStats {
+	w.Lock()
+	defer w.Unlock()
+	s := BackgroundStats{CPU: w.cpu, Nice: w.nice, Waiting: w.waiting}
+	if w.slots != nil {
+		s.Workers = cap(w.slots)
+		s.Running = len(w.slots)
+	}
+	return s
+}
+
+// BackgroundStats returns the background jobs running and waiting for a worker
+func (e *Engine) BackgroundStats() BackgroundStats {
+	return e.background.stats()
+}
@@ -0,0 +1,11 @@
+package engine
+
+import "syscall"
+
+const threadNiceSupported = true
+
+// setThreadNice lowers the priority of the calling thread only, the nice
+// level is per thread on linux
+func setThreadNice(nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), nice)
+}
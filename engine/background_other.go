@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package engine
+
+import "errors"
+
+// the nice level is of the whole process off linux
+const threadNiceSupported = false
+
+func setThreadNice(nice int) error {
+	return errors.New("thread nice is only supported on linux")
+}
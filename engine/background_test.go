@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_backgroundWork_acquire(t *testing.T) {
+	var w backgroundWork
+	w.configure(1, 0, 0)
+	release, err := w.acquire(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := w.stats(); s.Workers != 1 || s.Running != 1 {
+		t.Errorf("stats() = %+v", s)
+	}
+
+	// no worker left, waits until dropped
+	drop := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(drop)
+	}()
+	if _, err := w.acquire(drop, nil); err != errBackgroundCanceled {
+		t.Errorf("acquire() with all workers busy = %v", err)
+	}
+	release()
+	if _, err := w.acquire(nil, nil); err != nil {
+		t.Errorf("acquire() after release = %v", err)
+	}
+
+	// no limit
+	w.configure(0, 0, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := w.acquire(nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_backgroundWork_pause(t *testing.T) {
+	var w backgroundWork
+	tests := []struct {
+		cpu  int
+		want time.Duration
+	}{
+		{0, 0},
+		{100, 0},
+		{50, time.Second},
+		{25, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		w.configure(1, tt.cpu, 0)
+		if got := w.pause(time.Second); got != tt.want {
+			t.Errorf("pause() at %d%% = %v, want %v", tt.cpu, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the layout of the backups, the config file and the files next to it under
+// config/, the files of the cache dir under cache/
+const (
+	backupManifest  = "manifest.json"
+	backupConfigDir = "config/"
+	backupCacheDir  = "cache/"
+)
+
+var errNotBackup = errors.New("not a backup, no manifest")
+
+// BackupManifest describes a backup
+type BackupManifest struct {
+	CreatedAt time.Time
+	Version   string
+	// the name of the config file under config/
+	ConfigFile string
+	Tasks      int
+}
+
+// WriteBackup writes a tar.gz of the config file and the files next to it,
+// eg: the users and the API tokens, the cached torrents and magnets and the
+// state of the tasks: the sessions, their directories, the wait list, the
+// history and the task records
+func (e *Engine) WriteBackup(w io.Writer, version, configFile string, nextFiles []string) error {
+	e.SaveState()
+	e.RLock()
+	cacheDir := e.cacheDir
+	e.RUnlock()
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	m := BackupManifest{CreatedAt: time.Now(), Version: version, ConfigFile: filepath.Base(configFile)}
+	for _, f := range append([]string{configFile}, nextFiles...) {
+		if err := tarFile(tw, backupConfigDir+filepath.Base(f), f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	entries, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || name == dirtyFlagFile || name == taskDBFile || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		if strings.HasPrefix(name, cacheSavedPrefix) {
+			m.Tasks++
+		}
+		if err := tarFile(tw, backupCacheDir+name, filepath.Join(cacheDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// a consistent copy of the open database
+	var db bytes.Buffer
+	if err := e.writeTaskDB(&db); err == nil {
+		if err := tarBytes(tw, backupCacheDir+taskDBFile, db.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tarBytes(tw, backupManifest, data); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	log.Printf("[Backup] %d tasks", m.Tasks)
+	return gz.Close()
+}
+
+func tarFile(tw *tar.Writer, name, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return tarBytes(tw, name, data)
+}
+
+func tarBytes(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// readBackup calls fn with the files of the backup by their name in it,
+// the manifest is required
+func readBackup(r io.Reader, fn func(name string, data []byte) error) (*BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var m *BackupManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if name == backupManifest {
+			m = &BackupManifest{}
+			if err := json.Unmarshal(data, m); err != nil {
+				return nil, fmt.Errorf("manifest: %w", err)
+			}
+			continue
+		}
+		if err := fn(name, data); err != nil {
+			return nil, err
+		}
+	}
+	if m == nil {
+		return nil, errNotBackup
+	}
+	return m, nil
+}
+
+// backupFileName returns the name of the file under dir of the backup,
+// empty for the other files
+func backupFileName(name, dir string) string {
+	if !strings.HasPrefix(name, dir) {
+		return ""
+	}
+	base := strings.TrimPrefix(name, dir)
+	if base == "" || strings.ContainsAny(base, `/\`) || strings.HasPrefix(base, "..") {
+		return ""
+	}
+	return base
+}
+
+// ExtractBackupConfig writes the config file of the backup to configPath and
+// the files next to it in its dir, before the config is loaded by --restore.
+// An empty configPath is the name in the backup in the working dir, the path
+// written is returned.
+func ExtractBackupConfig(backup, configPath string) (string, error) {
+	f, err := os.Open(backup)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	files := make(map[string][]byte)
+	m, err := readBackup(f, func(name string, data []byte) error {
+		if base := backupFileName(name, backupConfigDir); base != "" {
+			files[base] = data
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if configPath == "" {
+		configPath = m.ConfigFile
+	}
+	dir := filepath.Dir(configPath)
+	for base, data := range files {
+		p := filepath.Join(dir, base)
+		if base == m.ConfigFile {
+			p = configPath
+		}
+		if err := ioutil.WriteFile(p, data, 0600); err != nil {
+			return "", err
+		}
+	}
+	log.Printf("[Restore] config %s of the backup of %s", configPath, m.CreatedAt.Format(time.RFC3339))
+	return configPath, nil
+}
+
+// ExtractBackupCache writes the cached torrents and the state of the tasks of
+// the backup to the cache dir of the DownloadDirectory of c, before the tasks
+// are restored by --restore. The files there are replaced.
+func ExtractBackupCache(backup string, c *Config) (int, error) {
+	f, err := os.Open(backup)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	dir := filepath.Join(c.DownloadDirectory, CachedTorrentDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	var dirs map[string]string
+	m, err := readBackup(f, func(name string, data []byte) error {
+		switch base := backupFileName(name, backupCacheDir); base {
+		case "":
+			return nil
+		case taskDirsFile:
+			return json.Unmarshal(data, &dirs)
+		default:
+			return ioutil.WriteFile(filepath.Join(dir, base), data, 0644)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if dirs != nil {
+		data, err := json.Marshal(confineTaskDirs(c, dirs))
+		if err != nil {
+			return 0, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, taskDirsFile), data, 0644); err != nil {
+			return 0, err
+		}
+	}
+	log.Printf("[Restore] %d tasks to %s", m.Tasks, dir)
+	return m.Tasks, nil
+}
+
+// confineTaskDirs returns the dirs of the tasks of a backup within the roots
+// of c, see resolveTaskDir. The others are dropped, the tasks restored to
+// DownloadDirectory.
+func confineTaskDirs(c *Config, dirs map[string]string) map[string]string {
+	confined := make(map[string]string, len(dirs))
+	for ih, dir := range dirs {
+		resolved, err := c.resolveTaskDir(dir)
+		if err != nil {
+			log.Warnf("[Restore] %s dir %s dropped: %v", ih, dir, err)
+			continue
+		}
+		if resolved != "" {
+			confined[ih] = resolved
+		}
+	}
+	return confined
+}
+
+// RestoreBackup adds the tasks of the backup not added yet with their state,
+// the config and the other files are left as they are. Returns the tasks
+// added.
+func (e *Engine) RestoreBackup(r io.Reader) (int, error) {
+	e.RLock()
+	cacheDir := e.cacheDir
+	e.RUnlock()
+
+	var tasks []string
+	var sessions map[string]taskSession
+	var dirs map[string]string
+	restored := make(map[string]bool)
+	_, err := readBackup(r, func(name string, data []byte) error {
+		base := backupFileName(name, backupCacheDir)
+		switch {
+		case base == sessionFile:
+			return json.Unmarshal(data, &sessions)
+		case base == taskDirsFile:
+			return json.Unmarshal(data, &dirs)
+		case !strings.HasPrefix(base, cacheSavedPrefix):
+			return nil
+		}
+		// the magnet and the torrent of a task are both restored
+		ih := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(base, cacheSavedPrefix), ".torrent"), ".info")
+		if !restored[ih] && e.isKnownTask(ih) {
+			return nil
+		}
+		restored[ih] = true
+		fn := filepath.Join(cacheDir, base)
+		if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+			return err
+		}
+		tasks = append(tasks, fn)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	e.sessions.Lock()
+	if e.sessions.m != nil {
+		for ih, s := range sessions {
+			if restored[ih] {
+				e.sessions.m[ih] = s
+				e.sessions.dirty = true
+			}
+		}
+	}
+	e.sessions.Unlock()
+	for ih := range dirs {
+		if !restored[ih] {
+			delete(dirs, ih)
+		}
+	}
+	for ih, dir := range confineTaskDirs(&e.config, dirs) {
+		e.setTaskDir(ih, dir)
+	}
+
+	added := 0
+	for _, fn := range tasks {
+		// the torrent of a magnet got its info, the magnet is dropped once added
+		if strings.HasSuffix(fn, ".info") {
+			if _, err := os.Stat(strings.TrimSuffix(fn, ".info") + ".torrent"); err == nil {
+				continue
+			}
+		}
+		if err := e.RestoreTask(fn); err != nil && !errors.Is(err, ErrMaxConnTasks) {
+			log.Warn("[Restore]", fn, err)
+			continue
+		}
+		added++
+	}
+	log.Printf("[Restore] added %d tasks of the backup", added)
+	return added, nil
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupFileName(t *testing.T) {
+	for name, want := range map[string]string{
+		"config/cloud-torrent.yaml": "cloud-torrent.yaml",
+		"config/":                   "",
+		"config/a/b.json":           "",
+		"config/..x":                "",
+		"cache/.sessions.json":      "",
+	} {
+		if got := backupFileName(name, backupConfigDir); got != want {
+			t.Errorf("backupFileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExtractBackup(t *testing.T) {
+	dir := t.TempDir()
+	backup := filepath.Join(dir, "backup.tar.gz")
+	f, err := os.Create(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	m, _ := json.Marshal(BackupManifest{ConfigFile: "cloud-torrent.yaml", Tasks: 1})
+	down := filepath.Join(dir, "down")
+	dirs, _ := json.Marshal(map[string]string{"ab": filepath.Join(down, "movies"), "cd": "/etc"})
+	for name, data := range map[string]string{
+		backupManifest:                                string(m),
+		backupConfigDir + "cloud-torrent.yaml":        "DownloadDirectory: /data\n",
+		backupConfigDir + "users.json":                "[]",
+		backupCacheDir + cacheSavedPrefix + "ab.info": "magnet:?xt=urn:btih:ab",
+		backupCacheDir + "../escape":                  "x",
+		backupCacheDir + taskDirsFile:                 string(dirs),
+	} {
+		if err := tarBytes(tw, name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	confDir := filepath.Join(dir, "conf")
+	os.Mkdir(confDir, 0755)
+	p, err := ExtractBackupConfig(backup, filepath.Join(confDir, "new.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(p); string(data) != "DownloadDirectory: /data\n" {
+		t.Errorf("ExtractBackupConfig() config = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(confDir, "users.json")); err != nil {
+		t.Errorf("ExtractBackupConfig() users: %v", err)
+	}
+
+	n, err := ExtractBackupCache(backup, &Config{DownloadDirectory: down})
+	if err != nil || n != 1 {
+		t.Fatalf("ExtractBackupCache() = %d, %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(down, CachedTorrentDir, cacheSavedPrefix+"ab.info")); err != nil {
+		t.Errorf("ExtractBackupCache() task: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(down, "escape")); !os.IsNotExist(err) {
+		t.Errorf("ExtractBackupCache() wrote outside the cache dir: %v", err)
+	}
+	var restored map[string]string
+	data, _ := ioutil.ReadFile(filepath.Join(down, CachedTorrentDir, taskDirsFile))
+	if err := json.Unmarshal(data, &restored); err != nil || len(restored) != 1 || restored["ab"] != filepath.Join(down, "movies") {
+		t.Errorf("ExtractBackupCache() task dirs = %v, %v", restored, err)
+	}
+
+	var empty bytes.Buffer
+	gz = gzip.NewWriter(&empty)
+	tar.NewWriter(gz).Close()
+	gz.Close()
+	if _, err := readBackup(&empty, func(string, []byte) error { return nil }); err != errNotBackup {
+		t.Errorf("readBackup() without manifest = %v", err)
+	}
+}
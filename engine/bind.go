@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const bindCheckTick = 5 * time.Second
+
+var errBindDown = errors.New("the bound network interface is down")
+
+// bindState is the address the client is bound to, and the tasks paused by
+// the kill switch while it's gone
+type bindState struct {
+	sync.Mutex
+	ip     net.IP
+	down   bool
+	paused []string
+}
+
+// bindIP resolves the address of BindAddress or ListenInterface, nil if
+// neither is set. An IPv4 address of the interface is preferred.
+func bindIP(c *Config) (net.IP, error) {
+	if c.BindAddress != "" {
+		ip := net.ParseIP(c.BindAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid BindAddress %q", c.BindAddress)
+		}
+		// the address must be on the interface, or any one
+		var addrs []net.IP
+		var err error
+		if c.ListenInterface != "" {
+			addrs, err = interfaceIPs(c.ListenInterface)
+		} else {
+			addrs, err = localIPs()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if a.Equal(ip) {
+				return ip, nil
+			}
+		}
+		return nil, fmt.Errorf("address %s is gone", ip)
+	}
+	if c.ListenInterface == "" {
+		return nil, nil
+	}
+	addrs, err := interfaceIPs(c.ListenInterface)
+	if err != nil {
+		return nil, err
+	}
+	var found net.IP
+	for _, a := range addrs {
+		if a.IsLinkLocalUnicast() {
+			continue
+		}
+		if a.To4() != nil {
+			return a, nil
+		}
+		if found == nil {
+			found = a
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("interface %s has no address", c.ListenInterface)
+	}
+	return found, nil
+}
+
+// interfaceIPs returns the addresses of the interface, only when it's up
+func interfaceIPs(name string) ([]net.IP, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if ifi.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %s is down", name)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	return addrIPs(addrs), nil
+}
+
+// localIPs returns the addresses of all the interfaces
+func localIPs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	return addrIPs(addrs), nil
+}
+
+func addrIPs(addrs []net.Addr) []net.IP {
+	var ips []net.IP
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			ips = append(ips, ipn.IP)
+		}
+	}
+	return ips
+}
+
+// setBindAddress binds the listeners and the outgoing TCP connections of the
+// client config to ip, called in Configure
+func (e *Engine) setBindAddress(tc *torrent.ClientConfig, ip net.IP) {
+	e.bind.Lock()
+	e.bind.ip = ip
+	e.bind.Unlock()
+	if ip == nil {
+		torrent.DefaultNetDialer.LocalAddr = nil
+		return
+	}
+	host := ip.String()
+	tc.ListenHost = func(string) string { return host }
+	if ip.To4() != nil {
+		tc.DisableIPv6 = true
+	} else {
+		tc.DisableIPv4 = true
+	}
+	// TCP peers are dialed by the package dialer, uTP ones from the bound sockets
+	torrent.DefaultNetDialer.LocalAddr = &net.TCPAddr{IP: ip}
+	log.Printf("[Bind] peer traffic bound to %s", host)
+}
+
+// bindDown tells whether the kill switch paused the tasks
+func (e *Engine) bindDown() bool {
+	e.bind.Lock()
+	defer e.bind.Unlock()
+	return e.bind.down
+}
+
+// bindRoutine is the kill switch, pausing the started tasks while the bound
+// address is gone and resuming them when it's back. A new address of
+// ListenInterface, eg: a VPN reconnected, rebinds the client.
+func (e *Engine) bindRoutine() {
+	tk := time.NewTicker(bindCheckTick)
+	defer tk.Stop()
+	for range tk.C {
+		e.bind.Lock()
+		bound := e.bind.ip
+		e.bind.Unlock()
+		if bound == nil {
+			continue
+		}
+		c := e.Config()
+		ip, err := bindIP(&c)
+		if err != nil || ip == nil {
+			e.bindLost(err)
+			continue
+		}
+		e.bindBack()
+		if !ip.Equal(bound) {
+			log.Printf("[Bind] address changed from %s to %s, rebinding", bound, ip)
+			if err := e.Configure(&c); err != nil {
+				log.Warn("[Bind] rebind", err)
+				continue
+			}
+			e.RestoreCacheDir()
+		}
+	}
+}
+
+func (e *Engine) bindLost(err error) {
+	e.bind.Lock()
+	if e.bind.down {
+		e.bind.Unlock()
+		return
+	}
+	e.bind.down = true
+	e.bind.Unlock()
+
+	var paused []string
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		started := t.Started
+		t.Unlock()
+		if started && e.StopTorrent(ih) == nil {
+			paused = append(paused, ih)
+			t.Lock()
+			e.emit(EventError, t, errBindDown)
+			t.Unlock()
+		}
+	}
+	e.bind.Lock()
+	e.bind.paused = append(e.bind.paused, paused...)
+	e.bind.Unlock()
+	log.Warnf("[Bind] %v, paused %d tasks", err, len(paused))
+}
+
+func (e *Engine) bindBack() {
+	e.bind.Lock()
+	if !e.bind.down {
+		e.bind.Unlock()
+		return
+	}
+	e.bind.down = false
+	paused := e.bind.paused
+	e.bind.paused = nil
+	e.bind.Unlock()
+
+	log.Printf("[Bind] address is back, resuming %d tasks", len(paused))
+	for _, ih := range paused {
+		if err := e.StartTorrent(ih); err != nil {
+			log.Warn("[Bind] resume", ih, err)
+		}
+	}
+}
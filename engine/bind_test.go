@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBindIP(t *testing.T) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	var lo string
+	for _, ifi := range ifs {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			lo = ifi.Name
+		}
+	}
+	if lo == "" {
+		t.Skip("no loopback interface")
+	}
+
+	tests := []struct {
+		c    Config
+		want string
+		err  bool
+	}{
+		{Config{}, "<nil>", false},
+		{Config{ListenInterface: lo}, "127.0.0.1", false},
+		{Config{BindAddress: "127.0.0.1"}, "127.0.0.1", false},
+		{Config{ListenInterface: lo, BindAddress: "127.0.0.1"}, "127.0.0.1", false},
+		{Config{ListenInterface: lo, BindAddress: "192.0.2.1"}, "", true},
+		{Config{BindAddress: "not-an-ip"}, "", true},
+		{Config{ListenInterface: "no-such-if0"}, "", true},
+	}
+	for _, tt := range tests {
+		ip, err := bindIP(&tt.c)
+		if (err != nil) != tt.err || (!tt.err && ip.String() != tt.want) {
+			t.Errorf("bindIP(%q, %q) = %v, %v", tt.c.ListenInterface, tt.c.BindAddress, ip, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// eMule DAT ranges of a higher access level are allowed
+const emuleBlockLevel = 127
+
+// BlocklistStats is the state of the IP blocklist
+type BlocklistStats struct {
+	Ranges    int
+	Blocked   uint64
+	UpdatedAt time.Time
+	Error     string
+}
+
+// blocklist is set to the client once and swaps the loaded list on refresh,
+// the client has no way to replace it
+type blocklist struct {
+	sync.RWMutex
+	list      *iplist.IPList
+	updatedAt time.Time
+	err       error
+	// counted atomically
+	blocked uint64
+}
+
+func (b *blocklist) Lookup(ip net.IP) (iplist.Range, bool) {
+	b.RLock()
+	l := b.list
+	b.RUnlock()
+	r, ok := l.Lookup(ip)
+	if ok {
+		atomic.AddUint64(&b.blocked, 1)
+	}
+	return r, ok
+}
+
+func (b *blocklist) NumRanges() int {
+	b.RLock()
+	defer b.RUnlock()
+	return b.list.NumRanges()
+}
+
+// BlocklistStats returns the number of ranges loaded and the lookups blocked
+func (e *Engine) BlocklistStats() BlocklistStats {
+	b := &e.blocklist
+	b.RLock()
+	defer b.RUnlock()
+	s := BlocklistStats{
+		Ranges:    b.list.NumRanges(),
+		Blocked:   atomic.LoadUint64(&b.blocked),
+		UpdatedAt: b.updatedAt,
+	}
+	if b.err != nil {
+		s.Error = b.err.Error()
+	}
+	return s
+}
+
+// blocklistRoutine loads the Blocklist and reloads it every BlocklistRefresh
+func (e *Engine) blocklistRoutine(closeSync chan struct{}) {
+	e.loadBlocklist()
+	refresh := e.config.BlocklistRefresh
+	if refresh <= 0 || e.config.Blocklist == "" {
+		return
+	}
+	tk := time.NewTicker(refresh)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			e.loadBlocklist()
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+func (e *Engine) loadBlocklist() {
+	src := strings.TrimSpace(e.config.Blocklist)
+	var list *iplist.IPList
+	var err error
+	if src != "" {
+		var data []byte
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			data, err = e.httpCache.Get(src)
+		} else {
+			data, err = ioutil.ReadFile(src)
+		}
+		if err == nil {
+			list, err = parseBlocklist(data)
+		}
+	}
+
+	b := &e.blocklist
+	b.Lock()
+	defer b.Unlock()
+	if err != nil {
+		// the last good list stays in effect
+		log.Warnf("[Blocklist] load %s: %v", src, err)
+		b.err = err
+		return
+	}
+	b.list, b.err = list, nil
+	if src != "" {
+		b.updatedAt = time.Now()
+		log.Printf("[Blocklist] loaded %d ranges from %s", list.NumRanges(), src)
+	}
+}
+
+// parseBlocklist reads a PeerGuardian P2P or eMule DAT list, gzipped or not.
+// Malformed lines are skipped, overlapping ranges are merged.
+func parseBlocklist(data []byte) (*iplist.IPList, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var ranges []iplist.Range
+	var bad int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rg, ok, err := parseBlocklistLine(scanner.Bytes())
+		if err != nil {
+			bad++
+			continue
+		}
+		if ok {
+			ranges = append(ranges, rg)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ranges, %d malformed lines", bad)
+	}
+	if bad > 0 {
+		log.Warnf("[Blocklist] skipped %d malformed lines", bad)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].First, ranges[j].First) < 0 })
+	merged := ranges[:1]
+	for _, rg := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if len(rg.First) == len(last.Last) && bytes.Compare(rg.First, last.Last) <= 0 {
+			if bytes.Compare(rg.Last, last.Last) > 0 {
+				last.Last = rg.Last
+			}
+			continue
+		}
+		merged = append(merged, rg)
+	}
+	return iplist.New(merged), nil
+}
+
+// parseBlocklistLine parses "desc:first-last" (P2P) or "first - last , level , desc"
+// (eMule DAT), !ok for comments and the allowed eMule ranges
+func parseBlocklistLine(l []byte) (iplist.Range, bool, error) {
+	s := strings.TrimSpace(string(l))
+	if s == "" || strings.HasPrefix(s, "#") || strings.HasPrefix(s, "//") {
+		return iplist.Range{}, false, nil
+	}
+
+	parts := strings.SplitN(s, ",", 3)
+	if len(parts) < 2 {
+		return iplist.ParseBlocklistP2PLine(l)
+	}
+	level, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	ips := strings.SplitN(parts[0], "-", 2)
+	if err != nil || len(ips) != 2 {
+		// a P2P description with commas
+		return iplist.ParseBlocklistP2PLine(l)
+	}
+	rg := iplist.Range{
+		First: parseRangeIP(ips[0]),
+		Last:  parseRangeIP(ips[1]),
+	}
+	if len(parts) == 3 {
+		rg.Description = strings.TrimSpace(parts[2])
+	}
+	if rg.First == nil || rg.Last == nil || len(rg.First) != len(rg.Last) {
+		return iplist.Range{}, false, fmt.Errorf("bad IP range %q", parts[0])
+	}
+	return rg, level <= emuleBlockLevel, nil
+}
+
+// parseRangeIP parses the IPs of the eMule lists, zero padded like 001.002.003.004
+func parseRangeIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, ":") {
+		octets := strings.Split(s, ".")
+		for i, o := range octets {
+			if t := strings.TrimLeft(o, "0"); t != "" {
+				octets[i] = t
+			} else if o != "" {
+				octets[i] = "0"
+			}
+		}
+		s = strings.Join(octets, ".")
+	}
+	ip := net.ParseIP(s)
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"testing"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	list := []byte(`# comment
+Some Org, Inc:1.2.3.0-1.2.3.255
+bad line
+001.002.004.000 - 001.002.004.255 , 000 , eMule range
+005.000.000.000 - 005.000.000.255 , 200 , allowed
+1.2.3.128-1.2.5.0,100,overlapping
+`)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(list) // nolint: errcheck
+	w.Close()
+
+	for name, data := range map[string][]byte{"plain": list, "gzip": gz.Bytes()} {
+		l, err := parseBlocklist(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n := l.NumRanges(); n != 1 {
+			t.Errorf("%s: %d ranges, want 1 merged", name, n)
+		}
+		for ip, blocked := range map[string]bool{
+			"1.2.3.4":   true,
+			"1.2.4.200": true,
+			"1.2.5.1":   false,
+			"5.0.0.1":   false,
+			"8.8.8.8":   false,
+		} {
+			if _, ok := l.Lookup(net.ParseIP(ip)); ok != blocked {
+				t.Errorf("%s: lookup %s = %v", name, ip, ok)
+			}
+		}
+	}
+
+	if _, err := parseBlocklist([]byte("garbage\n")); err == nil {
+		t.Error("no error for a list without ranges")
+	}
+}
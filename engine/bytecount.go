@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// fileCounter counts the bytes written to (downloaded) and read from the
+// completed pieces of (uploaded) every file of a torrent, at the storage level
+type fileCounter struct {
+	offsets    []int64
+	lengths    []int64
+	downloaded []int64
+	uploaded   []int64
+}
+
+func newFileCounter(info *metainfo.Info) *fileCounter {
+	files := info.UpvertedFiles()
+	c := &fileCounter{
+		offsets:    make([]int64, len(files)),
+		lengths:    make([]int64, len(files)),
+		downloaded: make([]int64, len(files)),
+		uploaded:   make([]int64, len(files)),
+	}
+	var off int64
+	for i, f := range files {
+		c.offsets[i] = off
+		c.lengths[i] = f.Length
+		off += f.Length
+	}
+	return c
+}
+
+// add distributes n bytes at the torrent offset off to the files it spans
+func (c *fileCounter) add(counts []int64, off int64, n int) {
+	end := off + int64(n)
+	// first file ends after off
+	i := sort.Search(len(c.offsets), func(i int) bool {
+		return c.offsets[i]+c.lengths[i] > off
+	})
+	for ; i < len(c.offsets) && c.offsets[i] < end; i++ {
+		fstart, fend := c.offsets[i], c.offsets[i]+c.lengths[i]
+		if fstart < off {
+			fstart = off
+		}
+		if fend > end {
+			fend = end
+		}
+		atomic.AddInt64(&counts[i], fend-fstart)
+	}
+}
+
+func (c *fileCounter) get(i int) (downloaded, uploaded int64) {
+	if i >= len(c.offsets) {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&c.downloaded[i]), atomic.LoadInt64(&c.uploaded[i])
+}
+
+type counterMap struct {
+	sync.Mutex
+	m map[string]*fileCounter
+}
+
+// fileCounter returns the counter of ih, the counts are kept across
+// client reconfigures as long as the files are the same
+func (e *Engine) fileCounter(ih string, info *metainfo.Info) *fileCounter {
+	e.counters.Lock()
+	defer e.counters.Unlock()
+	c, ok := e.counters.m[ih]
+	if !ok || len(c.offsets) != len(info.UpvertedFiles()) {
+		c = newFileCounter(info)
+		e.counters.m[ih] = c
+	}
+	return c
+}
+
+func (e *Engine) loadFileCounter(ih string) *fileCounter {
+	e.counters.Lock()
+	defer e.counters.Unlock()
+	return e.counters.m[ih]
+}
+
+func (e *Engine) removeFileCounter(ih string) {
+	e.counters.Lock()
+	defer e.counters.Unlock()
+	delete(e.counters.m, ih)
+}
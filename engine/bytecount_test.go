@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_fileCounter_add(t *testing.T) {
+	type args struct {
+		off int64
+		n   int
+	}
+	tests := []struct {
+		name string
+		args args
+		want []int64
+	}{
+		{"first", args{0, 5}, []int64{5, 0, 0}},
+		{"span", args{8, 10}, []int64{2, 4, 4}},
+		{"last", args{16, 4}, []int64{0, 0, 4}},
+		{"exact", args{10, 4}, []int64{0, 4, 0}},
+		{"past end", args{20, 4}, []int64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// files of 10, 4 and 6 bytes
+			c := &fileCounter{
+				offsets:    []int64{0, 10, 14},
+				lengths:    []int64{10, 4, 6},
+				downloaded: make([]int64, 3),
+			}
+			c.add(c.downloaded, tt.args.off, tt.args.n)
+			if !reflect.DeepEqual(c.downloaded, tt.want) {
+				t.Errorf("fileCounter.add() = %v, want %v", c.downloaded, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent"
+)
+
+// the peer ID is 20 bytes, the prefix leaves some of them random
+const maxPeerIDPrefix = 16
+
+// setClientID applies PeerIDPrefix, ClientName and UserAgent, the fingerprint
+// of the client whitelisted by some private trackers. Empty keeps the
+// defaults of anacrolix/torrent.
+func (c *Config) setClientID(tc *torrent.ClientConfig) error {
+	if c.PeerIDPrefix != "" {
+		if len(c.PeerIDPrefix) > maxPeerIDPrefix || !printableASCII(c.PeerIDPrefix) {
+			return fmt.Errorf("Invalid PeerIDPrefix %q, up to %d printable ASCII characters, eg: -qB4450-",
+				c.PeerIDPrefix, maxPeerIDPrefix)
+		}
+		tc.Bep20 = c.PeerIDPrefix
+	}
+	if c.ClientName != "" {
+		tc.ExtendedHandshakeClientVersion = c.ClientName
+	}
+	if c.UserAgent != "" {
+		if !printableASCII(c.UserAgent) {
+			return fmt.Errorf("Invalid UserAgent %q", c.UserAgent)
+		}
+		tc.HTTPUserAgent = c.UserAgent
+	}
+	return nil
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
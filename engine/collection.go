@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+)
+
+// Collection is a named group of tasks, eg: the seasons of a series, with
+// their totals
+type Collection struct {
+	Name       string
+	InfoHashes []string
+	Size       int64
+	Downloaded int64
+	Uploaded   int64
+	// of the completed bytes
+	Percent      float32
+	Ratio        float32
+	DownloadRate float32
+	UploadRate   float32
+	// tasks done and started
+	Done    int
+	Started int
+}
+
+// SetTorrentCollection puts the task in the collection, empty name takes it out
+func (e *Engine) SetTorrentCollection(infohash, name string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.Collection = strings.TrimSpace(name)
+	t.Unlock()
+	log.Printf("[SetTorrentCollection] %s %q", infohash, name)
+	e.notifyChanged()
+	return nil
+}
+
+// Collections returns the collections of the tasks by name
+func (e *Engine) Collections() []Collection {
+	m := make(map[string]*Collection)
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		name := t.Collection
+		if name == "" {
+			t.Unlock()
+			continue
+		}
+		c, ok := m[name]
+		if !ok {
+			c = &Collection{Name: name}
+			m[name] = c
+		}
+		c.InfoHashes = append(c.InfoHashes, ih)
+		c.Size += t.Size
+		c.Downloaded += t.Downloaded
+		c.Uploaded += t.Uploaded
+		c.DownloadRate += t.DownloadRate
+		c.UploadRate += t.UploadRate
+		if t.Done {
+			c.Done++
+		}
+		if t.Started {
+			c.Started++
+		}
+		t.Unlock()
+	}
+
+	cs := make([]Collection, 0, len(m))
+	for _, c := range m {
+		c.Percent = percent(c.Downloaded, c.Size)
+		if c.Downloaded > 0 {
+			c.Ratio = float32(c.Uploaded) / float32(c.Downloaded)
+		}
+		sort.Strings(c.InfoHashes)
+		cs = append(cs, *c)
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Name < cs[j].Name })
+	return cs
+}
+
+// CollectionTasks returns the tasks in the collection
+func (e *Engine) CollectionTasks(name string) []string {
+	var ihs []string
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		if t.Collection != "" && t.Collection == name {
+			ihs = append(ihs, ih)
+		}
+		t.Unlock()
+	}
+	sort.Strings(ihs)
+	return ihs
+}
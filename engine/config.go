@@ -3,12 +3,16 @@ package engine
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"time"
 
+	eglog "github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
 	"github.com/boypt/simple-torrent/common"
 	"github.com/spf13/viper"
 	"golang.org/x/time/rate"
@@ -37,25 +41,129 @@ type Config struct {
 	ObfsRequirePreferred    bool          `yaml:"ObfsRequirePreferred"`
 	DisableTrackers         bool          `yaml:"DisableTrackers"`
 	DisableIPv6             bool          `yaml:"DisableIPv6"`
+	DisableDHT              bool          `yaml:"DisableDHT"`
+	DisablePEX              bool          `yaml:"DisablePEX"`
+	DisableLSD              bool          `yaml:"DisableLSD"`
+	DHTBootstrapNodes       string        `yaml:"DHTBootstrapNodes"`
+	PeerIDPrefix            string        `yaml:"PeerIDPrefix"`
+	ClientName              string        `yaml:"ClientName"`
+	UserAgent               string        `yaml:"UserAgent"`
 	NoDefaultPortForwarding bool          `yaml:"NoDefaultPortForwarding"`
 	DisableUTP              bool          `yaml:"DisableUTP"`
 	DownloadDirectory       string        `yaml:"DownloadDirectory"`
+	ExtractArchives         bool          `yaml:"ExtractArchives"`
+	ExtractDirectory        string        `yaml:"ExtractDirectory"`
+	UploadRemote            string        `yaml:"UploadRemote"`
+	UploadArgs              string        `yaml:"UploadArgs"`
+	UploadRemoveData        string        `yaml:"UploadRemoveData"`
+	DiskReserve             string        `yaml:"DiskReserve"`
+	LowDiskSpace            string        `yaml:"LowDiskSpace"`
+	StorageBackend          string        `yaml:"StorageBackend"`
+	FileAllocation          string        `yaml:"FileAllocation"`
+	PieceCompletion         string        `yaml:"PieceCompletion"`
+	PieceCompletionDir      string        `yaml:"PieceCompletionDir"`
+	MmapMaxTaskSize         string        `yaml:"MmapMaxTaskSize"`
+	ShutdownTimeout         time.Duration `yaml:"ShutdownTimeout"`
+	ReclaimSpace            bool          `yaml:"ReclaimSpace"`
+	ReclaimScoring          string        `yaml:"ReclaimScoring"`
+	ReclaimTrackers         string        `yaml:"ReclaimTrackers"`
+	MaxTorrentSize          string        `yaml:"MaxTorrentSize"`
+	MaxTorrentFiles         int           `yaml:"MaxTorrentFiles"`
+	BannedExtensions        string        `yaml:"BannedExtensions"`
 	WatchDirectory          string        `yaml:"WatchDirectory"`
+	WatchDirs               string        `yaml:"WatchDirs"`
 	EnableUpload            bool          `yaml:"EnableUpload"`
 	EnableSeeding           bool          `yaml:"EnableSeeding"`
 	IncomingPort            int           `yaml:"IncomingPort"`
+	ListenInterface         string        `yaml:"ListenInterface"`
+	BindAddress             string        `yaml:"BindAddress"`
+	PortCheckURL            string        `yaml:"PortCheckURL"`
 	DoneCmd                 string        `yaml:"DoneCmd"`
+	DoneCmdDir              string        `yaml:"DoneCmdDir"`
+	DoneCmdEnv              string        `yaml:"DoneCmdEnv"`
+	DoneCmdUser             string        `yaml:"DoneCmdUser"`
+	DoneCmdNice             int           `yaml:"DoneCmdNice"`
+	DoneCmdIONice           string        `yaml:"DoneCmdIONice"`
+	AddHook                 string        `yaml:"AddHook"`
+	AddHookTimeout          time.Duration `yaml:"AddHookTimeout"`
+	Hooks                   string        `yaml:"Hooks"`
+	HookTimeout             time.Duration `yaml:"HookTimeout"`
+	HookRetries             int           `yaml:"HookRetries"`
+	Plugins                 string        `yaml:"Plugins"`
 	SeedRatio               float32       `yaml:"SeedRatio"`
 	SeedTime                time.Duration `yaml:"SeedTime"`
+	MaxSeedTime             time.Duration `yaml:"MaxSeedTime"`
+	MaxIdleTime             time.Duration `yaml:"MaxIdleTime"`
+	SeedLimitAction         string        `yaml:"SeedLimitAction"`
+	ReverifyInterval        time.Duration `yaml:"ReverifyInterval"`
+	StalledSeedTime         time.Duration `yaml:"StalledSeedTime"`
+	StalledSeedLabels       string        `yaml:"StalledSeedLabels"`
 	UploadRate              string        `yaml:"UploadRate"`
 	DownloadRate            string        `yaml:"DownloadRate"`
+	AltUploadRate           string        `yaml:"AltUploadRate"`
+	AltDownloadRate         string        `yaml:"AltDownloadRate"`
+	AltRateSchedule         string        `yaml:"AltRateSchedule"`
+	TorrentUploadRate       string        `yaml:"TorrentUploadRate"`
+	TorrentDownloadRate     string        `yaml:"TorrentDownloadRate"`
 	TrackerList             string        `yaml:"TrackerList"`
+	TrackerListRefresh      time.Duration `yaml:"TrackerListRefresh"`
 	AlwaysAddTrackers       bool          `yaml:"AlwaysAddTrackers"`
+	PrivateTrackersOnly     bool          `yaml:"PrivateTrackersOnly"`
+	TrackerFallback         bool          `yaml:"TrackerFallback"`
+	ScrapeInterval          time.Duration `yaml:"ScrapeInterval"`
 	ProxyURL                string        `yaml:"ProxyURL"`
+	PeerProxyURL            string        `yaml:"PeerProxyURL"`
+	Blocklist               string        `yaml:"Blocklist"`
+	BlocklistRefresh        time.Duration `yaml:"BlocklistRefresh"`
+	WebseedURL              string        `yaml:"WebseedURL"`
+	GeoIPDatabase           string        `yaml:"GeoIPDatabase"`
+	ASNDatabase             string        `yaml:"ASNDatabase"`
 	RssURL                  string        `yaml:"RssURL"`
+	RssRulesFile            string        `yaml:"RssRulesFile"`
+	AlertUploadTotal        string        `yaml:"AlertUploadTotal"`
+	AlertMinSpeed           string        `yaml:"AlertMinSpeed"`
+	AlertSlowTime           time.Duration `yaml:"AlertSlowTime"`
+	WebhookURL              string        `yaml:"WebhookURL"`
+	WebhookEvents           string        `yaml:"WebhookEvents"`
+	Notifications           string        `yaml:"Notifications"`
+	TelegramToken           string        `yaml:"TelegramToken"`
+	TelegramChatIDs         string        `yaml:"TelegramChatIDs"`
+	JellyfinURL             string        `yaml:"JellyfinURL"`
+	JellyfinAPIKey          string        `yaml:"JellyfinAPIKey"`
+	PlexURL                 string        `yaml:"PlexURL"`
+	PlexToken               string        `yaml:"PlexToken"`
+	LibraryPathMap          string        `yaml:"LibraryPathMap"`
 	ScraperURL              string        `yaml:"ScraperURL"`
+	SearchProviderDir       string        `yaml:"SearchProviderDir"`
+	SearchDisabled          string        `yaml:"SearchDisabled"`
+	TorznabURL              string        `yaml:"TorznabURL"`
+	LabelRules              string        `yaml:"LabelRules"`
+	LabelDirs               string        `yaml:"LabelDirs"`
+	TaskDirRoots            string        `yaml:"TaskDirRoots"`
+	PieceCacheSize          string        `yaml:"PieceCacheSize"`
+	MemoryLimit             string        `yaml:"MemoryLimit"`
+	MaxConnsPerTask         int           `yaml:"MaxConnsPerTask"`
+	MaxUploadSlots          int           `yaml:"MaxUploadSlots"`
+	MaxHalfOpenConns        int           `yaml:"MaxHalfOpenConns"`
+	BackgroundWorkers       int           `yaml:"BackgroundWorkers"`
+	BackgroundCPU           int           `yaml:"BackgroundCPU"`
+	BackgroundNice          int           `yaml:"BackgroundNice"`
+	SlowOpThreshold         time.Duration `yaml:"SlowOpThreshold"`
+	TraceEndpoint           string        `yaml:"TraceEndpoint"`
 	MaxConcurrentTask       int           `yaml:"MaxConcurrentTask"`
+	MaxActiveDownloads      int           `yaml:"MaxActiveDownloads"`
+	MaxActiveSeeds          int           `yaml:"MaxActiveSeeds"`
+	UndoDeleteWindow        time.Duration `yaml:"UndoDeleteWindow"`
+	RemoveData              string        `yaml:"RemoveData"`
+	TrashRetention          time.Duration `yaml:"TrashRetention"`
+	MetadataTimeout         time.Duration `yaml:"MetadataTimeout"`
+	MetadataRetries         int           `yaml:"MetadataRetries"`
+	MetadataTimeoutRemove   bool          `yaml:"MetadataTimeoutRemove"`
+	StallTimeout            time.Duration `yaml:"StallTimeout"`
+	RetryInterval           time.Duration `yaml:"RetryInterval"`
+	RetryMax                int           `yaml:"RetryMax"`
 	AllowRuntimeConfigure   bool          `yaml:"AllowRuntimeConfigure"`
+	ConfigVersions          int           `yaml:"ConfigVersions"`
 }
 
 func InitConf(specPath *string) (*Config, error) {
@@ -70,19 +178,64 @@ func InitConf(specPath *string) (*Config, error) {
 
 	viper.SetDefault("DownloadDirectory", "./downloads")
 	viper.SetDefault("WatchDirectory", "./torrents")
+	viper.SetDefault("WatchDirs", "")
 	viper.SetDefault("EnableUpload", true)
 	viper.SetDefault("EnableSeeding", true)
 	viper.SetDefault("NoDefaultPortForwarding", true)
 	viper.SetDefault("DisableUTP", false)
 	viper.SetDefault("AutoStart", true)
 	viper.SetDefault("DoneCmd", "")
+	viper.SetDefault("AddHook", "")
+	viper.SetDefault("AddHookTimeout", "10s")
+	viper.SetDefault("Hooks", "")
+	viper.SetDefault("HookTimeout", "30m")
+	viper.SetDefault("HookRetries", 2)
+	viper.SetDefault("Plugins", "")
+	viper.SetDefault("Notifications", "")
+	viper.SetDefault("LowDiskSpace", "")
+	viper.SetDefault("StorageBackend", StorageAuto)
+	viper.SetDefault("FileAllocation", FileAllocationSparse)
+	viper.SetDefault("PieceCompletion", PieceCompletionDefault)
+	viper.SetDefault("ShutdownTimeout", "8s")
+	viper.SetDefault("UploadRemote", "")
+	viper.SetDefault("UploadArgs", "")
+	viper.SetDefault("UploadRemoveData", RemoveDataKeep)
 	viper.SetDefault("SeedRatio", 0)
 	viper.SetDefault("SeedTime", "0")
+	viper.SetDefault("MaxSeedTime", "0")
+	viper.SetDefault("MaxIdleTime", "0")
+	viper.SetDefault("SeedLimitAction", SeedLimitRemove)
+	viper.SetDefault("ReverifyInterval", "0")
+	viper.SetDefault("StalledSeedTime", "0")
 	viper.SetDefault("ObfsPreferred", true)
+	viper.SetDefault("PrivateTrackersOnly", true)
 	viper.SetDefault("ObfsRequirePreferred", false)
 	viper.SetDefault("IncomingPort", 50007)
+	viper.SetDefault("PortCheckURL", defaultPortCheckURL)
 	viper.SetDefault("MaxConcurrentTask", 0)
+	viper.SetDefault("MaxActiveDownloads", 0)
+	viper.SetDefault("MaxActiveSeeds", 0)
+	viper.SetDefault("BackgroundWorkers", 1)
+	viper.SetDefault("BackgroundCPU", 0)
+	viper.SetDefault("BackgroundNice", 0)
+	viper.SetDefault("SlowOpThreshold", "5s")
+	viper.SetDefault("TraceEndpoint", "")
+	viper.SetDefault("UndoDeleteWindow", "5m")
+	viper.SetDefault("AlertSlowTime", "30m")
+	viper.SetDefault("ReclaimScoring", "age:1,ratio:1,tracker:1")
+	viper.SetDefault("RemoveData", RemoveDataKeep)
+	viper.SetDefault("TrashRetention", "168h")
+	viper.SetDefault("TrackerFallback", false)
+	viper.SetDefault("ScrapeInterval", "30m")
+	viper.SetDefault("TrackerListRefresh", "24h")
+	viper.SetDefault("BlocklistRefresh", "24h")
+	viper.SetDefault("MetadataTimeout", "0")
+	viper.SetDefault("MetadataRetries", 0)
+	viper.SetDefault("StallTimeout", "30m")
+	viper.SetDefault("RetryInterval", "5m")
+	viper.SetDefault("RetryMax", 5)
 	viper.SetDefault("AllowRuntimeConfigure", true)
+	viper.SetDefault("ConfigVersions", 5)
 
 	configExists := true
 	if err := viper.ReadInConfig(); err != nil {
@@ -152,11 +305,154 @@ func (c *Config) NormlizeConfigDir() (bool, error) {
 	return changed, nil
 }
 
+// clientConfig builds the client config of c, failing on the values the
+// client can't take. The storage, the bound address and the callbacks are
+// set by Configure.
+func (c *Config) clientConfig() (*torrent.ClientConfig, error) {
+	if c.IncomingPort <= 0 || c.IncomingPort > 65535 {
+		return nil, fmt.Errorf("Invalid incoming port (%d)", c.IncomingPort)
+	}
+	tc := torrent.NewDefaultClientConfig()
+	tc.NoDefaultPortForwarding = c.NoDefaultPortForwarding
+	tc.DisableUTP = c.DisableUTP
+	tc.ListenPort = c.IncomingPort
+	tc.DataDir = c.DownloadDirectory
+	if c.MuteEngineLog {
+		tc.Logger = eglog.Discard
+	} else {
+		tc.Logger = torrentLogger(c.EngineDebug)
+	}
+	tc.Debug = c.EngineDebug
+	// EnableUpload and EnableSeeding are applied to the tasks, see applyUpload
+	tc.Seed = true
+	tc.UploadRateLimiter = c.UploadLimiter()
+	tc.DownloadRateLimiter = c.DownloadLimiter()
+	tc.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{
+		Preferred:        c.ObfsPreferred,
+		RequirePreferred: c.ObfsRequirePreferred,
+	}
+	tc.DisableTrackers = c.DisableTrackers
+	tc.DisableIPv6 = c.DisableIPv6
+	if err := c.setDHT(tc); err != nil {
+		return nil, err
+	}
+	if err := c.setClientID(tc); err != nil {
+		return nil, err
+	}
+	if c.MaxConnsPerTask < 0 || c.MaxHalfOpenConns < 0 {
+		return nil, fmt.Errorf("Invalid MaxConnsPerTask/MaxHalfOpenConns (%d/%d)", c.MaxConnsPerTask, c.MaxHalfOpenConns)
+	}
+	c.setConnLimits(tc)
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ProxyURL: %w", err)
+		}
+		tc.HTTPProxy = http.ProxyURL(u)
+		tc.LookupTrackerIp = lookupProxiedTrackerIP
+	}
+	if err := c.setPeerProxy(tc); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Check validates c before it's saved, by building the client config and
+// parsing the options in their own formats
+func (c *Config) Check() error {
+	for _, r := range []string{c.UploadRate, c.DownloadRate, c.AltUploadRate, c.AltDownloadRate} {
+		if _, err := rateLimiter(r); err != nil {
+			return fmt.Errorf("Invalid rate %q: %w", r, err)
+		}
+	}
+	// a copy, the limiters reset the bad rates
+	cc := *c
+	if _, err := cc.clientConfig(); err != nil {
+		return err
+	}
+	if _, err := parseLabelRules(c.LabelRules); err != nil {
+		return err
+	}
+	if _, err := parseLabelDirs(c.LabelDirs); err != nil {
+		return err
+	}
+	if _, err := parseWatchDirs(c.WatchDirs); err != nil {
+		return err
+	}
+	if _, err := parseSchedule(c.AltRateSchedule); err != nil {
+		return err
+	}
+	if _, err := parseReclaimScoring(c.ReclaimScoring); err != nil {
+		return err
+	}
+	if _, err := parseHooks(c.Hooks); err != nil {
+		return err
+	}
+	if c.PeerProxyURL != "" {
+		if _, err := newPeerProxy(c.PeerProxyURL); err != nil {
+			return err
+		}
+	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("Invalid ShutdownTimeout (%s)", c.ShutdownTimeout)
+	}
+	if c.MaxUploadSlots < 0 {
+		return fmt.Errorf("Invalid MaxUploadSlots (%d)", c.MaxUploadSlots)
+	}
+	if c.TrackerListRefresh < 0 {
+		return fmt.Errorf("Invalid TrackerListRefresh (%s)", c.TrackerListRefresh)
+	}
+	if c.ScrapeInterval < 0 {
+		return fmt.Errorf("Invalid ScrapeInterval (%s)", c.ScrapeInterval)
+	}
+	if c.HookRetries < 0 {
+		return fmt.Errorf("Invalid HookRetries (%d)", c.HookRetries)
+	}
+	if c.StallTimeout < 0 {
+		return fmt.Errorf("Invalid StallTimeout (%s)", c.StallTimeout)
+	}
+	if c.RetryMax < 0 || (c.RetryMax > 0 && c.RetryInterval <= 0) {
+		return fmt.Errorf("Invalid RetryInterval (%s) or RetryMax (%d)", c.RetryInterval, c.RetryMax)
+	}
+	if _, err := parsePlugins(c.Plugins); err != nil {
+		return err
+	}
+	if _, err := parseNotifications(c.Notifications); err != nil {
+		return err
+	}
+	if err := c.checkStorage(); err != nil {
+		return err
+	}
+	if err := c.checkTraceEndpoint(); err != nil {
+		return err
+	}
+	if err := c.checkPortCheckURL(); err != nil {
+		return err
+	}
+	if _, err := splitCommandLine(c.UploadArgs); err != nil {
+		return fmt.Errorf("Invalid UploadArgs: %w", err)
+	}
+	switch c.UploadRemoveData {
+	case "", RemoveDataKeep, RemoveDataTrash, RemoveDataDelete:
+	default:
+		return fmt.Errorf("Invalid UploadRemoveData %q, keep, trash or delete", c.UploadRemoveData)
+	}
+	for _, s := range []string{c.PieceCacheSize, c.MemoryLimit, c.LowDiskSpace, c.MmapMaxTaskSize} {
+		if _, err := parseByteSize(s); err != nil {
+			return fmt.Errorf("Invalid size %q: %w", s, err)
+		}
+	}
+	if err := c.checkBackground(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *Config) UploadLimiter() *rate.Limiter {
 	l, err := rateLimiter(c.UploadRate)
 	if err != nil {
 		c.UploadRate = ""
-		log.Printf("RateLimit [%s] unreconized, set as unlimited", c.UploadRate)
+		log.Warnf("RateLimit [%s] unreconized, set as unlimited", c.UploadRate)
 		return rate.NewLimiter(rate.Inf, 0)
 	}
 	return l
@@ -166,7 +462,7 @@ func (c *Config) DownloadLimiter() *rate.Limiter {
 	l, err := rateLimiter(c.DownloadRate)
 	if err != nil {
 		c.DownloadRate = ""
-		log.Printf("RateLimit [%s] unreconized, set as unlimited", c.DownloadRate)
+		log.Warnf("RateLimit [%s] unreconized, set as unlimited", c.DownloadRate)
 		return rate.NewLimiter(rate.Inf, 0)
 	}
 	return l
@@ -176,16 +472,22 @@ func (c *Config) Validate(nc *Config) uint8 {
 
 	var status uint8
 
-	if c.DoneCmd != nc.DoneCmd {
+	if c.DoneCmd != nc.DoneCmd || c.DoneCmdDir != nc.DoneCmdDir || c.DoneCmdEnv != nc.DoneCmdEnv ||
+		c.DoneCmdUser != nc.DoneCmdUser || c.DoneCmdNice != nc.DoneCmdNice || c.DoneCmdIONice != nc.DoneCmdIONice ||
+		c.AddHook != nc.AddHook || c.Hooks != nc.Hooks || c.Plugins != nc.Plugins ||
+		c.UploadRemote != nc.UploadRemote || c.UploadArgs != nc.UploadArgs {
 		status |= ForbidRuntimeChange
 	}
-	if c.WatchDirectory != nc.WatchDirectory {
+	if c.WatchDirectory != nc.WatchDirectory || c.WatchDirs != nc.WatchDirs {
 		status |= NeedRestartWatch
 	}
-	if c.TrackerList != nc.TrackerList {
+	if c.TrackerList != nc.TrackerList || c.TrackerFallback != nc.TrackerFallback ||
+		(nc.TrackerFallback && c.ProxyURL != nc.ProxyURL) {
 		status |= NeedUpdateTracker
 	}
-	if c.MaxConcurrentTask < nc.MaxConcurrentTask {
+	if raisedLimit(c.MaxConcurrentTask, nc.MaxConcurrentTask) ||
+		raisedLimit(c.MaxActiveDownloads, nc.MaxActiveDownloads) ||
+		raisedLimit(c.MaxActiveSeeds, nc.MaxActiveSeeds) {
 		status |= NeedLoadWaitList
 	}
 	if c.RssURL != nc.RssURL {
@@ -196,9 +498,10 @@ func (c *Config) Validate(nc *Config) uint8 {
 	rfnc := reflect.ValueOf(nc)
 
 	for _, field := range []string{"IncomingPort", "DownloadDirectory",
-		"EngineDebug", "EnableUpload", "EnableSeeding", "UploadRate",
-		"DownloadRate", "ObfsPreferred", "ObfsRequirePreferred",
-		"DisableTrackers", "DisableIPv6", "ProxyURL"} {
+		"EngineDebug", "ObfsPreferred", "ObfsRequirePreferred",
+		"DisableTrackers", "DisableIPv6", "DisableDHT", "DisablePEX", "DisableLSD", "DHTBootstrapNodes", "ProxyURL", "PeerProxyURL",
+		"PeerIDPrefix", "ClientName", "UserAgent", "ListenInterface", "BindAddress",
+		"MaxHalfOpenConns", "StorageBackend", "PieceCompletion", "PieceCompletionDir"} {
 
 		cval := reflect.Indirect(rfc).FieldByName(field)
 		ncval := reflect.Indirect(rfnc).FieldByName(field)
@@ -212,6 +515,16 @@ func (c *Config) Validate(nc *Config) uint8 {
 	return status
 }
 
+// raisedLimit tells whether more tasks are allowed by the new limit, 0 is unlimited
+func raisedLimit(old, new int) bool {
+	return old > 0 && (new <= 0 || new > old)
+}
+
+// QueueEnabled tells whether tasks may wait in the wait list
+func (c *Config) QueueEnabled() bool {
+	return c.MaxConcurrentTask > 0 || c.MaxActiveDownloads > 0 || c.MaxActiveSeeds > 0
+}
+
 func (c *Config) SyncViper(nc Config) {
 	cv := reflect.ValueOf(*c)
 	nv := reflect.ValueOf(nc)
@@ -227,17 +540,6 @@ func (c *Config) SyncViper(nc Config) {
 	}
 }
 
-func (c *Config) WriteDefault() error {
-	cf := viper.ConfigFileUsed()
-	cfext := strings.ToLower(filepath.Ext(cf))
-	if cfext == "yml" || cfext == "yaml" {
-		// keeps keys cases
-		return c.WriteYaml(cf)
-	}
-	// viper's write make all keys lowercased
-	return viper.WriteConfig()
-}
-
 func (c *Config) WriteYaml(cf string) error {
 	d, err := yaml.Marshal(c)
 	if err != nil {
@@ -250,6 +552,61 @@ func (c *Config) GetCmdConfig() (string, []string, error) {
 	if c.DoneCmd == "" {
 		return "", nil, fmt.Errorf("unconfigred Donecmd")
 	}
-	env := append(os.Environ(), fmt.Sprintf("CLD_DIR=%s", c.DownloadDirectory))
+	env := append(doneCmdEnv(os.Environ(), c.DoneCmdEnv), fmt.Sprintf("CLD_DIR=%s", c.DownloadDirectory))
 	return c.DoneCmd, env, nil
 }
+
+// secretParams are the url query parameters masked by Redacted
+var secretParams = []string{"apikey", "api_key", "key", "token", "passkey", "auth", "secret"}
+
+// Redacted returns a copy of c with the tokens, passwords and api keys masked,
+// to be shared outside
+func (c Config) Redacted() Config {
+	for _, s := range []*string{&c.TelegramToken, &c.JellyfinAPIKey, &c.PlexToken} {
+		if *s != "" {
+			*s = redactedMask
+		}
+	}
+	for _, s := range []*string{&c.ProxyURL, &c.PeerProxyURL, &c.WebhookURL, &c.Notifications, &c.RssURL, &c.TorznabURL,
+		&c.ScraperURL, &c.Blocklist, &c.TrackerList} {
+		*s = redactURLs(*s)
+	}
+	return c
+}
+
+const redactedMask = "REDACTED"
+
+// redactURLs masks the passwords and secret query values of the urls, one per
+// line, after the "events =>" of the Notifications lines
+func redactURLs(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		var prefix string
+		if parts := strings.SplitN(l, "=>", 2); len(parts) == 2 {
+			prefix, l = parts[0]+"=> ", parts[1]
+		}
+		u, err := url.Parse(strings.TrimSpace(l))
+		if err != nil || u.Host == "" {
+			continue
+		}
+		changed := false
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedMask)
+			changed = true
+		}
+		q := u.Query()
+		for k := range q {
+			for _, p := range secretParams {
+				if strings.EqualFold(k, p) {
+					q.Set(k, redactedMask)
+					changed = true
+				}
+			}
+		}
+		if changed {
+			u.RawQuery = q.Encode()
+			lines[i] = prefix + u.String()
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigVersion is a previous config file kept by WriteDefault, version 1 is
+// the latest
+type ConfigVersion struct {
+	Version int
+	SavedAt time.Time
+}
+
+// WriteDefault saves c to the config file in use. It's written to a temp
+// file, read back to build the client config, then renamed over the config
+// file, the replaced one kept as the version 1 of the ConfigVersions.
+func (c *Config) WriteDefault() error {
+	cf := viper.ConfigFileUsed()
+	ext := filepath.Ext(cf)
+	tmp := filepath.Join(filepath.Dir(cf), "."+strings.TrimSuffix(filepath.Base(cf), ext)+".tmp"+ext)
+	if err := writeConfigFile(c, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// the client must take what's read back
+	saved, err := readConfigFile(tmp, configType(cf))
+	if err == nil {
+		_, err = saved.clientConfig()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("config not saved: %w", err)
+	}
+
+	if _, err := os.Stat(cf); err == nil {
+		if err := rotateConfigVersions(cf, c.ConfigVersions); err != nil {
+			log.Warn("[config] keep version", err)
+		}
+	}
+	return os.Rename(tmp, cf)
+}
+
+func writeConfigFile(c *Config, cf string) error {
+	switch strings.ToLower(filepath.Ext(cf)) {
+	case ".yml", ".yaml":
+		// keeps keys cases
+		return c.WriteYaml(cf)
+	}
+	// viper's write make all keys lowercased
+	return viper.WriteConfigAs(cf)
+}
+
+func configType(cf string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(cf), "."))
+}
+
+// readConfigFile reads the config file of the format typ, the keys missing
+// in it keep the values in use
+func readConfigFile(cf, typ string) (*Config, error) {
+	v := viper.New()
+	for k, val := range viper.AllSettings() {
+		v.SetDefault(k, val)
+	}
+	v.SetConfigFile(cf)
+	v.SetConfigType(typ)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := v.Unmarshal(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadConfigFile reads the config file in use again, eg: edited by hand
+func ReadConfigFile() (*Config, error) {
+	cf := viper.ConfigFileUsed()
+	return readConfigFile(cf, configType(cf))
+}
+
+func configVersionFile(cf string, version int) string {
+	return cf + "." + strconv.Itoa(version)
+}
+
+// rotateConfigVersions copies cf to version 1, shifting the older ones and
+// dropping those over keep
+func rotateConfigVersions(cf string, keep int) error {
+	for n := keep; ; n++ {
+		if err := os.Remove(configVersionFile(cf, n+1)); err != nil {
+			break
+		}
+	}
+	if keep <= 0 {
+		return nil
+	}
+	for n := keep - 1; n > 0; n-- {
+		if err := os.Rename(configVersionFile(cf, n), configVersionFile(cf, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	data, err := ioutil.ReadFile(cf)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(configVersionFile(cf, 1), data, 0600)
+}
+
+// ConfigVersions lists the previous config files kept, the latest first
+func ConfigVersions() []ConfigVersion {
+	cf := viper.ConfigFileUsed()
+	list := []ConfigVersion{}
+	for n := 1; ; n++ {
+		st, err := os.Stat(configVersionFile(cf, n))
+		if err != nil {
+			return list
+		}
+		list = append(list, ConfigVersion{Version: n, SavedAt: st.ModTime()})
+	}
+}
+
+// LoadConfigVersion reads a previous config file listed by ConfigVersions
+func LoadConfigVersion(version int) (*Config, error) {
+	if version <= 0 {
+		return nil, fmt.Errorf("invalid config version %d", version)
+	}
+	cf := viper.ConfigFileUsed()
+	p := configVersionFile(cf, version)
+	if _, err := os.Stat(p); err != nil {
+		return nil, fmt.Errorf("config version %d not found", version)
+	}
+	return readConfigFile(p, configType(cf))
+}
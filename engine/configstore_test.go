@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_rotateConfigVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cf := filepath.Join(dir, "cloud-torrent.yaml")
+
+	for _, content := range []string{"a", "b", "c", "d"} {
+		if err := ioutil.WriteFile(cf, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := rotateConfigVersions(cf, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for version, want := range map[int]string{1: "d", 2: "c"} {
+		data, err := ioutil.ReadFile(configVersionFile(cf, version))
+		if err != nil || string(data) != want {
+			t.Errorf("version %d = %q %v, want %q", version, data, err, want)
+		}
+	}
+	if _, err := os.Stat(configVersionFile(cf, 3)); !os.IsNotExist(err) {
+		t.Errorf("version 3 kept over the limit")
+	}
+
+	// lowering the limit drops the older ones
+	if err := rotateConfigVersions(cf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configVersionFile(cf, 1)); !os.IsNotExist(err) {
+		t.Errorf("version 1 kept with no version to keep")
+	}
+}
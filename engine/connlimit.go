@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// the connections of a task when neither MaxConnsPerTask nor the task limits
+// them, the EstablishedConnsPerTorrent default of anacrolix/torrent
+const defaultConnsPerTask = 50
+
+// ConnLimits overrides MaxConnsPerTask and MaxUploadSlots for a task, 0 keeps
+// the global ones
+type ConnLimits struct {
+	MaxConns    int
+	UploadSlots int
+}
+
+// connLimit is the established connections allowed to the task. The client
+// unchokes every interested peer, so the upload slots of a seeding task are
+// its connections; a downloading one only uploads to the peers it downloads
+// from.
+func connLimit(c *Config, l ConnLimits, seeding bool) int {
+	n := l.MaxConns
+	if n == 0 {
+		n = c.MaxConnsPerTask
+	}
+	if n == 0 {
+		n = defaultConnsPerTask
+	}
+	slots := l.UploadSlots
+	if slots == 0 {
+		slots = c.MaxUploadSlots
+	}
+	if seeding && slots > 0 && slots < n {
+		n = slots
+	}
+	return n
+}
+
+// applyConnLimits applies the connection limits to all the tasks
+func (e *Engine) applyConnLimits() {
+	for _, t := range e.Torrents() {
+		e.applyConnLimit(t)
+	}
+}
+
+// applyConnLimit sets the connection limit of the task to the client torrent,
+// dropping the worst connections over it
+func (e *Engine) applyConnLimit(t *Torrent) {
+	c := e.Config()
+	t.Lock()
+	tt := t.t
+	n := connLimit(&c, t.ConnLimits, t.Done)
+	// a task started again has a new client torrent
+	changed := tt != nil && (t.connLimitT != tt || t.connLimit != n)
+	if changed {
+		t.connLimitT, t.connLimit = tt, n
+	}
+	t.Unlock()
+	if changed {
+		tt.SetMaxEstablishedConns(n)
+	}
+}
+
+// SetTorrentConnLimits sets the connections and the upload slots of a single
+// torrent, 0 for the global MaxConnsPerTask and MaxUploadSlots
+func (e *Engine) SetTorrentConnLimits(infohash string, maxConns, uploadSlots int) error {
+	if maxConns < 0 || uploadSlots < 0 {
+		return fmt.Errorf("Invalid connection limits (%d/%d)", maxConns, uploadSlots)
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	t.ConnLimits = ConnLimits{MaxConns: maxConns, UploadSlots: uploadSlots}
+	t.Unlock()
+	e.applyConnLimit(t)
+	log.Printf("[SetTorrentConnLimits] %s connections %d upload slots %d", infohash, maxConns, uploadSlots)
+	return nil
+}
+
+// TorrentConnLimit returns the connection limit in effect for the task
+func (e *Engine) TorrentConnLimit(t *Torrent) int {
+	c := e.Config()
+	t.Lock()
+	defer t.Unlock()
+	return connLimit(&c, t.ConnLimits, t.Done)
+}
@@ -0,0 +1,25 @@
+package engine
+
+import "testing"
+
+func TestConnLimit(t *testing.T) {
+	for _, tt := range []struct {
+		c       Config
+		l       ConnLimits
+		seeding bool
+		want    int
+	}{
+		{Config{}, ConnLimits{}, false, defaultConnsPerTask},
+		{Config{MaxConnsPerTask: 80}, ConnLimits{}, false, 80},
+		{Config{MaxConnsPerTask: 80}, ConnLimits{MaxConns: 20}, false, 20},
+		{Config{MaxConnsPerTask: 80, MaxUploadSlots: 4}, ConnLimits{}, false, 80},
+		{Config{MaxConnsPerTask: 80, MaxUploadSlots: 4}, ConnLimits{}, true, 4},
+		{Config{MaxUploadSlots: 4}, ConnLimits{UploadSlots: 10}, true, 10},
+		{Config{MaxUploadSlots: 100}, ConnLimits{}, true, defaultConnsPerTask},
+	} {
+		if got := connLimit(&tt.c, tt.l, tt.seeding); got != tt.want {
+			t.Errorf("connLimit(%d/%d, %+v, %v) = %d, want %d", tt.c.MaxConnsPerTask, tt.c.MaxUploadSlots,
+				tt.l, tt.seeding, got, tt.want)
+		}
+	}
+}
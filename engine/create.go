@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	// pieces aimed at when choosing the piece length
+	targetPieces = 1500
+)
+
+var errCreatePath = errors.New("the data must be in the download directory")
+
+// CreateTorrent makes a torrent of path, a file or directory in the download
+// directory, and adds it for seeding. The trackers are put in tiers of one,
+// pieceLength is chosen by the size when 0. The torrent gets the web seed of
+// this instance if WebseedURL is configured.
+func (e *Engine) CreateTorrent(path string, trackers []string, private bool, pieceLength int64) (*metainfo.MetaInfo, error) {
+	root, err := e.createPath(path)
+	if err != nil {
+		return nil, err
+	}
+	total, err := dataSize(root)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no data in %s", root)
+	}
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(total)
+	} else if pieceLength < minPieceLength || pieceLength > maxPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("invalid piece length %d, expecting a power of 2 from %d to %d", pieceLength, minPieceLength, maxPieceLength)
+	}
+
+	log.Printf("[CreateTorrent] hashing %s, piece length %d", root, pieceLength)
+	info := metainfo.Info{PieceLength: pieceLength}
+	if private {
+		info.Private = &private
+	}
+	release, err := e.background.acquire(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.background.run(func() {
+		err = info.BuildFromFilePath(root)
+	})
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	mi := &metainfo.MetaInfo{
+		CreatedBy:    "simple-torrent",
+		CreationDate: time.Now().Unix(),
+	}
+	for _, tr := range trackers {
+		if tr = strings.TrimSpace(tr); tr != "" {
+			mi.AnnounceList = append(mi.AnnounceList, []string{tr})
+		}
+	}
+	if len(mi.AnnounceList) > 0 {
+		mi.Announce = mi.AnnounceList[0][0]
+	}
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		return nil, err
+	}
+	ih := mi.HashInfoBytes().HexString()
+	if ws := e.WebseedURL(ih); ws != "" {
+		mi.UrlList = []string{ws}
+	}
+
+	e.RLock()
+	_, exists := e.ts[ih]
+	e.RUnlock()
+	if exists {
+		return mi, nil
+	}
+	log.Printf("[CreateTorrent] %s created of %s, adding for seeding", ih, root)
+	e.newTorrentCacheFile(mi)
+	// the piece completion of the dir knows nothing about the data
+	e.markRecheck(ih)
+	if err := e.newTorrentBySpec(context.Background(), torrent.TorrentSpecFromMetaInfo(mi), taskTorrent, filepath.Dir(root)); err != nil && !errors.Is(err, ErrMaxConnTasks) {
+		return mi, err
+	}
+	return mi, nil
+}
+
+// createPath resolves path, relative ones are in DownloadDirectory, which it
+// must be in
+func (e *Engine) createPath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(e.config.DownloadDirectory, path)
+	}
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	base, err := filepath.Abs(e.config.DownloadDirectory)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(base, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errCreatePath
+	}
+	return p, nil
+}
+
+// dataSize sums the sizes of the files in root
+func dataSize(root string) (int64, error) {
+	var total int64
+	err := filepath.Walk(root, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// choosePieceLength returns the power of 2 making about targetPieces pieces
+func choosePieceLength(total int64) int64 {
+	l := int64(minPieceLength)
+	for l < maxPieceLength && (total+l-1)/l > targetPieces {
+		l <<= 1
+	}
+	return l
+}
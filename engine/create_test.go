@@ -0,0 +1,24 @@
+package engine
+
+import "testing"
+
+func Test_choosePieceLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int64
+		want  int64
+	}{
+		{"tiny", 1, 16 << 10},
+		{"target", 1500 * 16 << 10, 16 << 10},
+		{"over", 1500*16<<10 + 1, 32 << 10},
+		{"1GiB", 1 << 30, 1 << 20},
+		{"huge", 1 << 50, 16 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := choosePieceLength(tt.total); got != tt.want {
+				t.Errorf("choosePieceLength() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
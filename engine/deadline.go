@@ -0,0 +1,243 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+const (
+	// deadlines further than that aren't taken
+	maxDeadline = time.Hour
+	// pending deadlines of a task
+	maxDeadlines      = 64
+	deadlineInterval  = time.Second
+	deadlineNextAhead = 10 * time.Second
+	deadlineSoonAhead = time.Minute
+)
+
+var errTooManyDeadlines = errors.New("too many pending deadlines")
+
+// PieceDeadline is a byte range of a file wanted by a time, the pieces of it
+// get a higher priority as the time approaches until they are complete, the
+// task is stopped or the deadline is cleared. The client has no deadlines of
+// its own, they are kept by raising the priority of the pieces.
+type PieceDeadline struct {
+	Path       string
+	Offset     int64
+	Length     int64
+	Deadline   time.Time
+	FirstPiece int
+	LastPiece  int
+}
+
+type pieceDeadline struct {
+	PieceDeadline
+	tt   *torrent.Torrent
+	prio types.PiecePriority
+	done chan struct{}
+}
+
+// deadlinePriority is the priority of the pieces wanted in left
+func deadlinePriority(left time.Duration) types.PiecePriority {
+	switch {
+	case left <= 0:
+		return torrent.PiecePriorityNow
+	case left <= deadlineNextAhead:
+		return torrent.PiecePriorityNext
+	case left <= deadlineSoonAhead:
+		return torrent.PiecePriorityReadahead
+	}
+	return torrent.PiecePriorityHigh
+}
+
+// filePieces returns the pieces of the byte range of the file, length 0 is up
+// to the end of the file
+func filePieces(fileOffset, fileSize, pieceLength, off, length int64) (int, int, error) {
+	if off < 0 || off >= fileSize || length < 0 || pieceLength <= 0 {
+		return 0, 0, fmt.Errorf("invalid range %d+%d of %d bytes", off, length, fileSize)
+	}
+	if length == 0 || off+length > fileSize {
+		length = fileSize - off
+	}
+	begin := fileOffset + off
+	end := begin + length - 1
+	return int(begin / pieceLength), int(end / pieceLength), nil
+}
+
+// SetPieceDeadline wants the range of the file of a started task within in
+func (e *Engine) SetPieceDeadline(infohash, path string, off, length int64, in time.Duration) (PieceDeadline, error) {
+	if in < 0 || in > maxDeadline {
+		return PieceDeadline{}, fmt.Errorf("invalid deadline %s, expecting up to %s", in, maxDeadline)
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return PieceDeadline{}, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if !t.Loaded || t.t == nil || t.t.Info() == nil {
+		return PieceDeadline{}, errNotLoaded
+	}
+	if !t.Started {
+		return PieceDeadline{}, fmt.Errorf("task %s stopped", infohash)
+	}
+	if len(t.deadlines) >= maxDeadlines {
+		return PieceDeadline{}, errTooManyDeadlines
+	}
+	var f *File
+	for _, file := range t.Files {
+		if file != nil && file.Path == path {
+			f = file
+			break
+		}
+	}
+	if f == nil {
+		return PieceDeadline{}, fmt.Errorf("Missing file %s", path)
+	}
+	first, last, err := filePieces(f.f.Offset(), f.Size, t.t.Info().PieceLength, off, length)
+	if err != nil {
+		return PieceDeadline{}, err
+	}
+
+	d := &pieceDeadline{
+		PieceDeadline: PieceDeadline{
+			Path:       path,
+			Offset:     off,
+			Length:     length,
+			Deadline:   time.Now().Add(in),
+			FirstPiece: first,
+			LastPiece:  last,
+		},
+		tt:   t.t,
+		done: make(chan struct{}),
+	}
+	t.deadlines = append(t.deadlines, d)
+	t.raiseDeadline(d)
+	log.Printf("[Deadline] %s %s pieces %d-%d in %s", infohash, path, first, last, in)
+	go e.watchDeadline(t, d)
+	return d.PieceDeadline, nil
+}
+
+// PieceDeadlines lists the pending deadlines of the task
+func (e *Engine) PieceDeadlines(infohash string) ([]PieceDeadline, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	defer t.Unlock()
+	list := make([]PieceDeadline, 0, len(t.deadlines))
+	for _, d := range t.deadlines {
+		list = append(list, d.PieceDeadline)
+	}
+	return list, nil
+}
+
+// ClearPieceDeadlines drops the deadlines of the file of the task, or all of
+// them when path is empty
+func (e *Engine) ClearPieceDeadlines(infohash, path string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	defer t.Unlock()
+	for _, d := range append([]*pieceDeadline(nil), t.deadlines...) {
+		if path == "" || d.Path == path {
+			t.releaseDeadline(d)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) watchDeadline(t *Torrent, d *pieceDeadline) {
+	tk := time.NewTicker(deadlineInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-d.done:
+			return
+		case <-t.dropWait:
+			return
+		}
+		t.Lock()
+		select {
+		case <-d.done:
+			// cleared meanwhile
+			t.Unlock()
+			return
+		default:
+		}
+		if t.t != d.tt || !t.Started || d.complete() {
+			t.releaseDeadline(d)
+			t.Unlock()
+			return
+		}
+		t.raiseDeadline(d)
+		t.Unlock()
+	}
+}
+
+// complete tells whether the pieces of d are all done
+func (d *pieceDeadline) complete() bool {
+	for i := d.FirstPiece; i <= d.LastPiece; i++ {
+		if !d.tt.Piece(i).State().Complete {
+			return false
+		}
+	}
+	return true
+}
+
+// raiseDeadline sets the pieces of d to the priority of the time left, must
+// hold lock
+func (t *Torrent) raiseDeadline(d *pieceDeadline) {
+	prio := deadlinePriority(time.Until(d.Deadline))
+	if prio == d.prio {
+		return
+	}
+	d.prio = prio
+	t.setDeadlinePieces(d)
+}
+
+// releaseDeadline drops d and resets its pieces to the priority of the
+// files, or of the other deadlines. Must hold lock.
+func (t *Torrent) releaseDeadline(d *pieceDeadline) {
+	for i, o := range t.deadlines {
+		if o == d {
+			t.deadlines = append(t.deadlines[:i], t.deadlines[i+1:]...)
+			close(d.done)
+			break
+		}
+	}
+	if t.t == d.tt {
+		t.setDeadlinePieces(d)
+	}
+}
+
+// setDeadlinePieces sets the pieces of d to the highest priority of the
+// deadlines having them, must hold lock
+func (t *Torrent) setDeadlinePieces(d *pieceDeadline) {
+	for i := d.FirstPiece; i <= d.LastPiece; i++ {
+		var prio types.PiecePriority
+		if t.Started {
+			for _, o := range t.deadlines {
+				if o.FirstPiece <= i && i <= o.LastPiece {
+					prio.Raise(o.prio)
+				}
+			}
+		}
+		d.tt.Piece(i).SetPriority(prio)
+	}
+}
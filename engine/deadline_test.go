@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+func Test_filePieces(t *testing.T) {
+	type args struct {
+		fileOffset, fileSize, pieceLength, off, length int64
+	}
+	tests := []struct {
+		name        string
+		args        args
+		first, last int
+		wantErr     bool
+	}{
+		{"head", args{0, 1000, 100, 0, 150}, 0, 1, false},
+		{"to end", args{0, 1000, 100, 950, 0}, 9, 9, false},
+		{"clamped", args{0, 1000, 100, 900, 500}, 9, 9, false},
+		{"second file", args{250, 1000, 100, 0, 100}, 2, 3, false},
+		{"one byte", args{250, 1000, 100, 49, 1}, 2, 2, false},
+		{"past end", args{0, 1000, 100, 1000, 1}, 0, 0, true},
+		{"negative", args{0, 1000, 100, -1, 1}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.args
+			first, last, err := filePieces(a.fileOffset, a.fileSize, a.pieceLength, a.off, a.length)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filePieces() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if first != tt.first || last != tt.last {
+				t.Errorf("filePieces() = %d-%d, want %d-%d", first, last, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func Test_deadlinePriority(t *testing.T) {
+	tests := []struct {
+		left time.Duration
+		want types.PiecePriority
+	}{
+		{-time.Second, torrent.PiecePriorityNow},
+		{5 * time.Second, torrent.PiecePriorityNext},
+		{30 * time.Second, torrent.PiecePriorityReadahead},
+		{10 * time.Minute, torrent.PiecePriorityHigh},
+	}
+	for _, tt := range tests {
+		if got := deadlinePriority(tt.left); got != tt.want {
+			t.Errorf("deadlinePriority(%s) = %v, want %v", tt.left, got, tt.want)
+		}
+	}
+}
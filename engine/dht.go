@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/torrent"
+	"github.com/boypt/simple-torrent/common"
+)
+
+const dhtBootstrapTimeout = 10 * time.Second
+
+// parseDHTBootstrapNodes parses the host:port of DHTBootstrapNodes, one per
+// line
+func parseDHTBootstrapNodes(s string) ([]string, error) {
+	nodes := common.SplitLines(s)
+	for _, n := range nodes {
+		host, port, err := net.SplitHostPort(n)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid DHT bootstrap node %q, eg: router.bittorrent.com:6881", n)
+		}
+		if p, err := strconv.Atoi(port); err != nil || host == "" || p <= 0 || p > 65535 {
+			return nil, fmt.Errorf("Invalid DHT bootstrap node %q, eg: router.bittorrent.com:6881", n)
+		}
+	}
+	return nodes, nil
+}
+
+// setDHT applies DisableDHT, DisablePEX and the DHT bootstrap nodes replacing
+// the default routers
+func (c *Config) setDHT(tc *torrent.ClientConfig) error {
+	tc.NoDHT = c.DisableDHT
+	tc.DisablePEX = c.DisablePEX
+	nodes, err := parseDHTBootstrapNodes(c.DHTBootstrapNodes)
+	if err != nil || len(nodes) == 0 {
+		return err
+	}
+	tc.DhtStartingNodes = func(network string) dht.StartingNodesGetter {
+		return func() ([]dht.Addr, error) {
+			return resolveDHTNodes(network, nodes)
+		}
+	}
+	return nil
+}
+
+// resolveDHTNodes resolves the nodes to their addresses of the network, the
+// unresolved ones skipped
+func resolveDHTNodes(network string, nodes []string) ([]dht.Addr, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dhtBootstrapTimeout)
+	defer cancel()
+	var addrs []dht.Addr
+	for _, n := range nodes {
+		host, port, _ := net.SplitHostPort(n)
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			log.Debugf("[DHT] bootstrap node %s: %v", n, err)
+			continue
+		}
+		for _, ip := range ips {
+			ua, err := net.ResolveUDPAddr(network, net.JoinHostPort(ip, port))
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, dht.NewAddr(ua))
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no DHT bootstrap node resolved")
+	}
+	return addrs, nil
+}
@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	// only the first trackers of a task are probed
+	diagnoseMaxTrackers = 20
+	// complete pieces hashed again
+	diagnoseSamplePieces = 8
+)
+
+// HealthCheck is one item of a health report
+type HealthCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// HealthReport is the result of diagnosing a task
+type HealthReport struct {
+	InfoHash string
+	Name     string
+	Time     time.Time
+	Checks   []HealthCheck
+}
+
+func (r *HealthReport) add(name string, ok bool, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, HealthCheck{Name: name, OK: ok, Detail: fmt.Sprintf(format, args...)})
+}
+
+// String formats the report for humans
+func (r *HealthReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Health report of %s (%s) at %s\n\n", r.Name, r.InfoHash, r.Time.Format(time.RFC3339))
+	failed := 0
+	for _, c := range r.Checks {
+		mark := "OK  "
+		if !c.OK {
+			mark = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(&sb, "[%s] %-10s %s\n", mark, c.Name, c.Detail)
+	}
+	fmt.Fprintf(&sb, "\n%d of %d checks failed\n", failed, len(r.Checks))
+	return sb.String()
+}
+
+// Diagnose runs the checks of a task, it may take a while as the trackers
+// are probed and a sample of the pieces are hashed
+func (e *Engine) Diagnose(infohash string) (*HealthReport, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	client := e.client
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	tt, ok := client.Torrent(metainfo.NewHashFromHex(infohash))
+	if !ok {
+		return nil, fmt.Errorf("task %s is not active", infohash)
+	}
+
+	r := &HealthReport{InfoHash: infohash, Name: t.Name, Time: time.Now()}
+	e.diagnoseTrackers(r, tt)
+	e.diagnoseDHT(r, client, tt)
+	e.diagnosePort(r, client, tt)
+	e.diagnoseDisk(r, t)
+	e.pieceCache.dropTask(infohash)
+	diagnosePieces(r, tt)
+	return r, nil
+}
+
+func (e *Engine) diagnoseTrackers(r *HealthReport, tt *torrent.Torrent) {
+	if e.config.DisableTrackers {
+		r.add("trackers", true, "disabled by config")
+		return
+	}
+	var trackers []string
+	seen := make(map[string]bool)
+	mi := tt.Metainfo()
+	for _, tier := range mi.UpvertedAnnounceList() {
+		for _, tr := range tier {
+			if !seen[tr] {
+				seen[tr] = true
+				trackers = append(trackers, tr)
+			}
+		}
+	}
+	if len(trackers) == 0 {
+		r.add("trackers", false, "no trackers, peers come from DHT/PEX only")
+		return
+	}
+	probed := trackers
+	if len(probed) > diagnoseMaxTrackers {
+		probed = probed[:diagnoseMaxTrackers]
+	}
+
+	c := e.config
+	p := newTrackerProber(&c)
+	errs := make([]error, len(probed))
+	var wg sync.WaitGroup
+	for i, tr := range probed {
+		wg.Add(1)
+		go func(i int, tr string) {
+			defer wg.Done()
+			errs[i] = p.probe(tr)
+		}(i, tr)
+	}
+	wg.Wait()
+
+	var reachable int
+	var failed []string
+	for i, err := range errs {
+		if err == nil {
+			reachable++
+		} else {
+			failed = append(failed, fmt.Sprintf("%s (%v)", probed[i], err))
+		}
+	}
+	detail := fmt.Sprintf("%d of %d probed trackers reachable", reachable, len(probed))
+	if len(trackers) > len(probed) {
+		detail += fmt.Sprintf(", %d not probed", len(trackers)-len(probed))
+	}
+	if len(failed) > 0 {
+		detail += "; unreachable: " + strings.Join(failed, ", ")
+	}
+	r.add("trackers", reachable > 0, detail)
+}
+
+func (e *Engine) diagnoseDHT(r *HealthReport, client *torrent.Client, tt *torrent.Torrent) {
+	if e.config.DisableDHT {
+		r.add("dht", true, "DHT disabled by DisableDHT")
+		return
+	}
+	servers := len(client.DhtServers())
+	if servers == 0 {
+		r.add("dht", false, "DHT is not running")
+		return
+	}
+	ps := countPeerSources(tt)
+	r.add("dht", ps.DHT > 0, "%d DHT servers, %d connected peers found by DHT", servers, ps.DHT)
+}
+
+func (e *Engine) diagnosePort(r *HealthReport, client *torrent.Client, tt *torrent.Torrent) {
+	port := client.LocalPort()
+	if port == 0 {
+		r.add("port", false, "not listening for incoming peers")
+		return
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), 3*time.Second)
+	if err != nil {
+		r.add("port", false, "port %d not accepting connections locally: %v", port, err)
+		return
+	}
+	conn.Close()
+	// reachability from outside can only be told by the peers connected in
+	if ps := countPeerSources(tt); ps.Incoming > 0 {
+		r.add("port", true, "port %d listening, %d incoming peers connected", port, ps.Incoming)
+	} else {
+		r.add("port", false, "port %d listening but no incoming peers, it may be blocked by firewall/NAT", port)
+	}
+}
+
+func (e *Engine) diagnoseDisk(r *HealthReport, t *Torrent) {
+	dir := e.config.DownloadDirectory
+	t.Lock()
+	if t.DownloadDir != "" {
+		dir = t.DownloadDir
+	}
+	t.Unlock()
+	if err := writableDir(dir); err != nil {
+		r.add("disk", false, "%s not writable: %v", dir, err)
+		return
+	}
+	r.add("disk", true, "%s writable", dir)
+}
+
+func diagnosePieces(r *HealthReport, tt *torrent.Torrent) {
+	if tt.Info() == nil {
+		r.add("pieces", false, "metadata not received yet")
+		return
+	}
+	var complete []int
+	for i := 0; i < tt.NumPieces(); i++ {
+		if tt.PieceState(i).Complete {
+			complete = append(complete, i)
+		}
+	}
+	if len(complete) == 0 {
+		r.add("pieces", true, "no complete pieces to verify")
+		return
+	}
+
+	// evenly spread over the complete pieces
+	sample := complete
+	if len(sample) > diagnoseSamplePieces {
+		sample = make([]int, diagnoseSamplePieces)
+		for i := range sample {
+			sample[i] = complete[i*len(complete)/diagnoseSamplePieces]
+		}
+	}
+	var bad []string
+	for _, i := range sample {
+		p := tt.Piece(i)
+		p.VerifyData()
+		if !p.State().Complete {
+			bad = append(bad, strconv.Itoa(i))
+		}
+	}
+	if len(bad) > 0 {
+		r.add("pieces", false, "%d of %d sampled pieces failed verification: %s", len(bad), len(sample), strings.Join(bad, ","))
+		return
+	}
+	r.add("pieces", true, "%d sampled pieces verified", len(sample))
+}
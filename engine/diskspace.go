@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/dustin/go-humanize"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// ErrDiskSpace is returned when adding or starting a task that can't fit the free space
+var ErrDiskSpace = errors.New("not enough disk space")
+
+// DiskSpaceError tells how much space a task needs, it is ErrDiskSpace
+type DiskSpaceError struct {
+	Dir string
+	// remaining bytes of the task and the other active tasks on the filesystem
+	Need    int64
+	Free    int64
+	Reserve int64
+}
+
+func (d *DiskSpaceError) Error() string {
+	return fmt.Sprintf("%s: %s to download, %s free in %s (%s reserved)", ErrDiskSpace,
+		humanize.IBytes(uint64(d.Need)), humanize.IBytes(uint64(d.Free)), d.Dir, humanize.IBytes(uint64(d.Reserve)))
+}
+
+func (d *DiskSpaceError) Is(target error) bool {
+	return target == ErrDiskSpace
+}
+
+// checkDiskSpace returns a DiskSpaceError if the task can't fit when started.
+// Must be called with e locked and t unlocked.
+func (e *Engine) checkDiskSpace(t *Torrent) error {
+	t.Lock()
+	var need int64
+	// the files of a task are allocated whole once opened, taking their space already
+	if !t.Started && e.config.FileAllocation != FileAllocationFull {
+		need = remainingBytes(t, true)
+	}
+	dir := e.taskPath(t)
+	t.Unlock()
+	if need == 0 {
+		return nil
+	}
+	if err := e.lowDiskErr(dir); err != nil {
+		return err
+	}
+	return e.fitDiskSpace(dir, need, t)
+}
+
+// checkAddSpace returns a DiskSpaceError if a new torrent can't fit
+func (e *Engine) checkAddSpace(mi *metainfo.MetaInfo, info *metainfo.Info, dir string) error {
+	e.RLock()
+	defer e.RUnlock()
+	// the dir as newTorrentBySpec would use, not created yet
+	if dir == "" {
+		label, _ := e.inferLabel(info.Name, flattenTrackers(mi.UpvertedAnnounceList()))
+		dir = e.labelDir(label).download
+	}
+	path := e.config.DownloadDirectory
+	if filepath.IsAbs(dir) {
+		path = dir
+	} else if dir != "" {
+		path = filepath.Join(path, dir)
+	}
+	return e.fitDiskSpace(existingDir(path), info.TotalLength(), nil)
+}
+
+// fitDiskSpace returns a DiskSpaceError if the free space of the filesystem of dir,
+// less DiskReserve, can't hold need bytes and the remaining bytes of the other
+// active tasks on the same filesystem. The sparse data files don't count the bytes
+// still to be written in their sizes on disk, the ones of FileAllocation full
+// do. Must be called with e locked.
+func (e *Engine) fitDiskSpace(dir string, need int64, self *Torrent) error {
+	usage, err := disk.Usage(dir)
+	if err != nil {
+		// unknown, don't block the task
+		return nil
+	}
+	parts, _ := disk.Partitions(false)
+	mount := mountPoint(parts, dir)
+	for _, o := range e.ts {
+		if o == self || e.config.FileAllocation == FileAllocationFull {
+			continue
+		}
+		o.Lock()
+		if o.Started && !o.Done && mountPoint(parts, e.taskPath(o)) == mount {
+			need += remainingBytes(o, false)
+		}
+		o.Unlock()
+	}
+
+	reserve := e.diskReserve()
+	if need+reserve > int64(usage.Free) {
+		return &DiskSpaceError{Dir: dir, Need: need, Free: int64(usage.Free), Reserve: reserve}
+	}
+	return nil
+}
+
+// diskReserve is the DiskReserve in bytes, kept free of the downloads
+func (e *Engine) diskReserve() int64 {
+	v, err := parseByteSize(e.config.DiskReserve)
+	if err != nil {
+		log.Warn("[DiskReserve]", err)
+	}
+	return v
+}
+
+// existingDir returns dir or its closest existing parent
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// taskPath is the absolute download dir of the task, t locked
+func (e *Engine) taskPath(t *Torrent) string {
+	dir := t.DownloadDir
+	if dir == "" {
+		dir = e.config.DownloadDirectory
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return dir
+}
+
+// remainingBytes counts the bytes of the task still to be downloaded, only the
+// started files unless all, t locked. Zero before the info is got.
+func remainingBytes(t *Torrent, all bool) int64 {
+	var n int64
+	for _, f := range t.Files {
+		if f == nil || f.Done || !(all || f.Started) {
+			continue
+		}
+		if f.Size > f.Completed {
+			n += f.Size - f.Completed
+		}
+	}
+	return n
+}
+
+// mountPoint returns the longest mountpoint containing dir, dir itself if none
+func mountPoint(parts []disk.PartitionStat, dir string) string {
+	mount := ""
+	for _, p := range parts {
+		mp := p.Mountpoint
+		if len(mp) <= len(mount) {
+			continue
+		}
+		if dir == mp || strings.HasPrefix(dir, strings.TrimSuffix(mp, string(filepath.Separator))+string(filepath.Separator)) {
+			mount = mp
+		}
+	}
+	if mount == "" {
+		return dir
+	}
+	return mount
+}
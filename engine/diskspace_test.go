@@ -0,0 +1,31 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func Test_mountPoint(t *testing.T) {
+	parts := []disk.PartitionStat{{Mountpoint: "/"}, {Mountpoint: "/mnt/data"}, {Mountpoint: "/mnt/data/usb"}}
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"/root/downloads", "/"},
+		{"/mnt/data", "/mnt/data"},
+		{"/mnt/data/tv", "/mnt/data"},
+		{"/mnt/data/usb/tv", "/mnt/data/usb"},
+		{"/mnt/database", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := mountPoint(parts, tt.dir); got != tt.want {
+				t.Errorf("mountPoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := mountPoint(nil, "/a"); got != "/a" {
+		t.Errorf("mountPoint() without partitions = %v, want /a", got)
+	}
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// doneCmdEnv keeps the variables of environ named in the comma separated
+// keep, all of them when keep is empty
+func doneCmdEnv(environ []string, keep string) []string {
+	if strings.TrimSpace(keep) == "" {
+		return environ
+	}
+	names := make(map[string]bool)
+	for _, n := range strings.Split(keep, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names[n] = true
+		}
+	}
+	env := []string{}
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 && names[kv[:i]] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// prepareDoneCmd sets the working directory and the user of the command
+func prepareDoneCmd(cmd *exec.Cmd, c *Config) error {
+	cmd.Dir = c.DoneCmdDir
+	if c.DoneCmdUser != "" {
+		return setCmdUser(cmd, c.DoneCmdUser)
+	}
+	return nil
+}
+
+// lowerCmdPriority applies DoneCmdNice and DoneCmdIONice to the started command
+func lowerCmdPriority(pid int, c *Config) {
+	if c.DoneCmdNice != 0 {
+		if err := setCmdNice(pid, c.DoneCmdNice); err != nil {
+			log.Warn("[DoneCmd] nice", err)
+		}
+	}
+	if c.DoneCmdIONice != "" {
+		if err := setCmdIONice(pid, c.DoneCmdIONice); err != nil {
+			log.Warn("[DoneCmd] ionice", err)
+		}
+	}
+}
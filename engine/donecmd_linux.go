@@ -0,0 +1,31 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// setCmdIONice sets the I/O scheduling of the process as ionice(1) does,
+// class is "idle" or the best-effort level 0-7
+func setCmdIONice(pid int, class string) error {
+	prio := ioprioClassIdle << ioprioClassShift
+	if class != "idle" {
+		level, err := strconv.Atoi(class)
+		if err != nil || level < 0 || level > 7 {
+			return fmt.Errorf("invalid DoneCmdIONice %q", class)
+		}
+		prio = ioprioClassBE<<ioprioClassShift | level
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); errno != 0 {
+		return errno
+	}
+	return nil
+}
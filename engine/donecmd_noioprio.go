@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package engine
+
+import "errors"
+
+func setCmdIONice(pid int, class string) error {
+	return errors.New("ionice is only supported on linux")
+}
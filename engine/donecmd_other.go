@@ -0,0 +1,19 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package engine
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errCmdSandbox = errors.New("not supported on this platform")
+
+func setCmdUser(cmd *exec.Cmd, name string) error {
+	return errCmdSandbox
+}
+
+func setCmdNice(pid, nice int) error {
+	return errCmdSandbox
+}
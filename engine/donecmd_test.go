@@ -0,0 +1,23 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDoneCmdEnv(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "HOME=/root", "SECRET=x", "LANG=C.UTF-8"}
+	tests := []struct {
+		keep string
+		want []string
+	}{
+		{"", environ},
+		{"PATH, LANG", []string{"PATH=/usr/bin", "LANG=C.UTF-8"}},
+		{"NOPE", []string{}},
+	}
+	for _, tt := range tests {
+		if got := doneCmdEnv(environ, tt.keep); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("doneCmdEnv(%q) = %v, want %v", tt.keep, got, tt.want)
+		}
+	}
+}
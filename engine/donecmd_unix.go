@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setCmdUser runs the command as user, a name or <uid>:<gid>
+func setCmdUser(cmd *exec.Cmd, name string) error {
+	var uid, gid uint64
+	var err error
+	if parts := strings.SplitN(name, ":", 2); len(parts) == 2 {
+		if uid, err = strconv.ParseUint(parts[0], 10, 32); err != nil {
+			return fmt.Errorf("invalid DoneCmdUser %q", name)
+		}
+		if gid, err = strconv.ParseUint(parts[1], 10, 32); err != nil {
+			return fmt.Errorf("invalid DoneCmdUser %q", name)
+		}
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.ParseUint(u.Uid, 10, 32); err != nil {
+			return err
+		}
+		if gid, err = strconv.ParseUint(u.Gid, 10, 32); err != nil {
+			return err
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), NoSetGroups: true},
+	}
+	return nil
+}
+
+func setCmdNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// EncryptionRequire only keeps the peer connections of the task obfuscated by
+// MSE, the empty policy follows ObfsPreferred and ObfsRequirePreferred
+const EncryptionRequire = "require"
+
+// encryptionPassBit marks the handshakes passing the policy of their task,
+// the client drops the others as missing one of its MinPeerExtensions. The
+// bit isn't assigned by any BEP.
+const encryptionPassBit = pp.ExtensionBit(63)
+
+var errPlaintextRequired = errors.New("the config requires unencrypted connections")
+
+// setEncryptionPolicy enforces the policies of the tasks on the handshakes,
+// called in Configure
+func (e *Engine) setEncryptionPolicy(tc *torrent.ClientConfig) {
+	tc.MinPeerExtensions.SetBit(encryptionPassBit, true)
+	prev := tc.Callbacks.CompletedHandshake
+	tc.Callbacks.CompletedHandshake = func(pc *torrent.PeerConn, ih torrent.InfoHash) {
+		if prev != nil {
+			prev(pc, ih)
+		}
+		pass := true
+		if t, ok := e.Torrent(ih.HexString()); ok {
+			t.Lock()
+			policy := t.Encryption
+			trackersOnly := t.TrackersOnly
+			t.Unlock()
+			if encrypted, known := headerEncrypted(pc); known {
+				pass = encryptionPass(policy, encrypted)
+			}
+			// the private tasks refuse the peers of the DHT and PEX, see PrivateTrackersOnly
+			pass = pass && peerSourceAllowed(trackersOnly, pc.Discovery)
+		}
+		pc.PeerExtensionBytes.SetBit(encryptionPassBit, pass)
+	}
+}
+
+// headerEncrypted tells if the connection is obfuscated, known is false if
+// anacrolix/torrent stops having the unexported field
+func headerEncrypted(pc *torrent.PeerConn) (encrypted, known bool) {
+	f := reflect.ValueOf(pc).Elem().FieldByName("headerEncrypted")
+	if !f.IsValid() || f.Kind() != reflect.Bool {
+		return false, false
+	}
+	return f.Bool(), true
+}
+
+func encryptionPass(policy string, encrypted bool) bool {
+	return policy != EncryptionRequire || encrypted
+}
+
+// SetTorrentEncryption sets the encryption policy of the task, "" or
+// "require", for the connections made after it
+func (e *Engine) SetTorrentEncryption(infohash, policy string) error {
+	switch policy {
+	case "":
+	case EncryptionRequire:
+		c := e.Config()
+		if c.ObfsRequirePreferred && !c.ObfsPreferred {
+			return errPlaintextRequired
+		}
+	default:
+		return fmt.Errorf("invalid encryption policy %q", policy)
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.Encryption = policy
+	t.Unlock()
+	log.Printf("[Encryption] %s %q", infohash, policy)
+	e.notifyChanged()
+	return nil
+}
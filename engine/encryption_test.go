@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+func Test_headerEncrypted(t *testing.T) {
+	// fails open if anacrolix/torrent renames the field
+	if _, known := headerEncrypted(&torrent.PeerConn{}); !known {
+		t.Error("headerEncrypted() can't read the field of PeerConn")
+	}
+}
+
+func Test_encryptionPass(t *testing.T) {
+	tests := []struct {
+		policy    string
+		encrypted bool
+		want      bool
+	}{
+		{"", false, true},
+		{"", true, true},
+		{EncryptionRequire, false, false},
+		{EncryptionRequire, true, true},
+	}
+	for _, tt := range tests {
+		if got := encryptionPass(tt.policy, tt.encrypted); got != tt.want {
+			t.Errorf("encryptionPass(%q, %v) = %v, want %v", tt.policy, tt.encrypted, got, tt.want)
+		}
+	}
+}
@@ -1,22 +1,22 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
-	"net/url"
 	"path"
-	"strconv"
 	"sync"
 	"time"
 
-	eglog "github.com/anacrolix/log"
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/storage"
 	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/common/trace"
+	"github.com/boypt/simple-torrent/engine/plugin"
 	"github.com/fsnotify/fsnotify"
+	"golang.org/x/time/rate"
 )
 
 type Server interface {
@@ -27,12 +27,17 @@ type Server interface {
 const (
 	CachedTorrentDir = ".cachedTorrents"
 	TrashTorrentDir  = ".trashTorrents"
+	httpCacheDir     = ".httpcache"
+	// remote lists aren't fetched again within the interval
+	httpCacheInterval = 10 * time.Minute
 )
 
 var (
 	ErrTaskExists    = errors.New("Task already exists")
 	ErrWaitListEmpty = errors.New("Wait list empty")
 	ErrMaxConnTasks  = errors.New("Max conncurrent task reached")
+
+	errMetadataTimeout = errors.New("metadata not received in time")
 )
 
 //the Engine Cloud Torrent engine, backed by anacrolix/torrent
@@ -43,23 +48,122 @@ type Engine struct {
 	cacheDir     string
 	trashDir     string
 	client       *torrent.Client
+	dataStorage  storage.ClientImplCloser
+	httpCache    *common.HTTPCache
 	closeSync    chan struct{}
 	config       Config
 	ts           map[string]*Torrent
-	TsChanged    chan struct{}
 	Trackers     []string
 	waitList     *syncList
+	//tasks to verify after unclean shutdown
+	recheckMu  sync.Mutex
+	recheckSet map[string]struct{}
+	//queued tasks added bypassing MaxConcurrentTask
+	forceMu  sync.Mutex
+	forceSet map[string]struct{}
+	//soft deleted tasks within the undo window
+	deleted DeletedList
+	//data of the removed tasks moved to the trash
+	recycle recycleBin
+	//IP ranges the client never connects to
+	blocklist blocklist
+	//bumped on every change of the tasks, counted atomically
+	revision uint64
+	//ListenInterface/BindAddress kill switch
+	bind bindState
+	nat  natState
+	//swarm counts of the tasks by tracker
+	scrapes scrapeState
+	//sources of Trackers
+	trackerList trackerListState
+	//client wide limiters, adjusted by the temporary limit
+	uploadLimiter   *rate.Limiter
+	downloadLimiter *rate.Limiter
+	tempLimit       TempRateLimit
+	altRate         AltRate
+	//per peer byte counters and the country and ASN databases
+	peerCounters peerCounterMap
+	superSeeds   superSeedMap
+	storageErrs  storageErrorMap
+	taskErrors   taskErrorMap
+	peerProxy    *peerProxyDialer // nil without PeerProxyURL
+	geo          geoDB
+	asn          geoDB
+	//per torrent rate limiters
+	limiters limiterMap
+	//the buffers of the local reads, not limited as peer uploads
+	localReads localReads
+	//per file byte counters
+	counters counterMap
+	//per task event history
+	timelines timelineMap
+	//fetches of FetchMetadata by the magnets held, guarded by taskMutex
+	metaFetches map[string]int
+	//runtime state of the tasks across restarts
+	sessions sessionMap
+	//per task download dirs
+	taskDirs taskDirs
+	//progress of RestoreCacheDir
+	restore restoreState
+	//reads of the complete pieces, shed over MemoryLimit
+	pieceCache pieceCache
+	memory     memoryGuard
+	//workers of the verifications and the hashing
+	background backgroundWork
+	//speed samples and lifetime totals
+	history historyState
 	//file watcher
 	watcher *fsnotify.Watcher
+	//unreadable watched files waiting to settle
+	watchPending watchPending
+	//counted atomically
+	doneCmdFailures uint64
+	//runs of DoneCmd and the Hooks, one at a time
+	hooks hookQueue
+	//uploads to UploadRemote, one at a time
+	uploads chan struct{}
+	//last EventLowDisk, throttled
+	lowDiskState lowDiskState
+	//every task ever added, kept in taskDBFile
+	taskRecords taskRecords
+	//set by Shutdown, counted atomically
+	shuttingDown int32
+	//external plugins, nil without Plugins
+	plugins *plugin.Manager
+	//events to the webhooks, the server and the other subscribers
+	bus eventBus
+	//file priorities and owners given when adding
+	presets taskPresets
+	//accounts of the users, for their quotas
+	users *UserStore
 }
 
 func New(s Server) *Engine {
-	return &Engine{
-		ts:        make(map[string]*Torrent),
-		cld:       s,
-		waitList:  NewSyncList(),
-		TsChanged: make(chan struct{}, 1),
-	}
+	e := &Engine{
+		ts:         make(map[string]*Torrent),
+		cld:        s,
+		waitList:   NewSyncList(),
+		recheckSet: make(map[string]struct{}),
+		forceSet:   make(map[string]struct{}),
+		deleted:    DeletedList{Tasks: make(map[string]*DeletedTask)},
+		limiters:   limiterMap{m: make(map[string]*torrentLimiter)},
+		counters:   counterMap{m: make(map[string]*fileCounter)},
+		timelines:  timelineMap{m: make(map[string][]Event)},
+		hooks:      hookQueue{wake: make(chan struct{}, 1)},
+		uploads:    make(chan struct{}, 1),
+	}
+	events, _ := e.Subscribe(webhookQueue, lifecycleEvents...)
+	go e.webhookRoutine(events)
+	notifyEvents, _ := e.Subscribe(webhookQueue, lifecycleEvents...)
+	go e.notifyRoutine(notifyEvents)
+	hookEvents, _ := e.Subscribe(webhookQueue, lifecycleEvents...)
+	go e.hookEventRoutine(hookEvents)
+	uploadEvents, _ := e.Subscribe(webhookQueue, EventMetadata, EventStarted, EventCompleted)
+	go e.uploadRoutine(uploadEvents)
+	go e.hookRoutine()
+	go e.bindRoutine()
+	go e.lowDiskRoutine()
+	return e
 }
 
 func (e *Engine) Config() Config {
@@ -68,72 +172,81 @@ func (e *Engine) Config() Config {
 
 func (e *Engine) SetConfig(c *Config) {
 	e.config = *c
+	e.applyTraceConfig(c)
+	e.applyMemoryConfig(c)
+	e.background.configure(c.BackgroundWorkers, c.BackgroundCPU, c.BackgroundNice)
+	go e.applyRateLimits()
+	go e.applyUploads()
+	go e.applyConnLimits()
 }
 
 func (e *Engine) Configure(c *Config) error {
+	return e.ConfigureContext(context.Background(), c)
+}
+
+// ConfigureContext is Configure traced as a part of the request of ctx
+func (e *Engine) ConfigureContext(ctx context.Context, c *Config) (err error) {
+	ctx, span := trace.Start(ctx, "engine.Configure")
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
 	//recieve config
-	if c.IncomingPort <= 0 {
-		return fmt.Errorf("Invalid incoming port (%d)", c.IncomingPort)
-	}
 	if c.TrackerList == "" {
 		c.TrackerList = "remote:" + defaultTrackerListURL
 	}
+	bindAddr, err := bindIP(c)
+	if err != nil {
+		return fmt.Errorf("Invalid ListenInterface/BindAddress: %w", err)
+	}
 
 	e.Lock()
 	defer e.Unlock()
-	tc := torrent.NewDefaultClientConfig()
-	tc.NoDefaultPortForwarding = c.NoDefaultPortForwarding
-	tc.DisableUTP = c.DisableUTP
-	tc.ListenPort = c.IncomingPort
-	tc.DataDir = c.DownloadDirectory
-
-	if !(e.cld.GetBoolAttribute("DisableMmap")) {
-		// enable MMap on 64bit machines
-		if strconv.IntSize == 64 {
-			log.Println("[Configure] 64bit arch detected, using MMap for storage")
-			tc.DefaultStorage = storage.NewMMap(tc.DataDir)
-		}
-	} else {
-		log.Println("[Configure] mmap disabled")
+	isFirstConfigure := e.client == nil
+	tc, err := c.clientConfig()
+	if err != nil {
+		return err
 	}
 
-	if c.MuteEngineLog {
-		tc.Logger = eglog.Discard
-	}
-	tc.Debug = c.EngineDebug
-	tc.NoUpload = !c.EnableUpload
-	tc.Seed = c.EnableSeeding
-	tc.UploadRateLimiter = c.UploadLimiter()
-	tc.DownloadRateLimiter = c.DownloadLimiter()
-	tc.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{
-		Preferred:        c.ObfsPreferred,
-		RequirePreferred: c.ObfsRequirePreferred,
-	}
-	tc.DisableTrackers = c.DisableTrackers
-	tc.DisableIPv6 = c.DisableIPv6
-	if c.ProxyURL != "" {
-		tc.HTTPProxy = func(*http.Request) (*url.URL, error) {
-			return url.Parse(c.ProxyURL)
-		}
+	if e.cld.GetBoolAttribute("DisableMmap") {
+		log.Println("[Configure] mmap disabled")
+	} else if e.useMmap() {
+		log.Println("[Configure] using MMap for storage")
 	}
+	dataStorage := e.newDataStorage(tc.DataDir)
+	// storage wrapped for per torrent rate limits
+	tc.DefaultStorage = &limitedStorage{ClientImpl: dataStorage, e: e}
+
+	e.setBindAddress(tc, bindAddr)
+	tc.Logger = e.portMappingLogger(tc.Logger)
+	e.setPeerCallbacks(&tc.Callbacks)
+	e.setSuperSeeding(&tc.Callbacks, tc.Extensions.SupportsFast())
+	e.setEncryptionPolicy(tc)
+	tc.IPBlocklist = &e.blocklist
 
 	{
 		if e.client != nil {
+			// keep the state for the new client
+			e.writeSession(e.sessionSnapshot())
 			// stop all current torrents
 			for _, t := range e.client.Torrents() {
 				t.Drop()
 			}
 			e.client.Close()
+			common.FancyHandleError(e.dataStorage.Close())
+			e.closeTaskStorages()
 			close(e.closeSync)
-			log.Println("Configure: old client closed")
+			log.Debug("Configure: old client closed")
 			e.client = nil
 			e.ts = make(map[string]*Torrent)
+			e.notifyChanged()
 			time.Sleep(3 * time.Second)
 		}
 
 		// runtime reconfigure need to retry while creating client,
 		// wait max for 3 * 10 seconds
 		var err error
+		_, newSpan := trace.Start(ctx, "torrent.NewClient")
 		max := 10
 		for max > 0 {
 			max--
@@ -141,12 +254,23 @@ func (e *Engine) Configure(c *Config) error {
 			if err == nil {
 				break
 			}
-			log.Printf("[Configure] error %s\n", err)
+			log.Warnf("[Configure] error %s\n", err)
 			time.Sleep(time.Second * 3)
 		}
+		newSpan.SetError(err)
+		newSpan.Finish()
 		if err != nil {
+			common.FancyHandleError(dataStorage.Close())
 			return err
 		}
+		e.dataStorage = dataStorage
+		e.peerProxy = nil
+		if c.PeerProxyURL != "" {
+			// checked by clientConfig
+			e.peerProxy, _ = newPeerProxy(c.PeerProxyURL)
+			e.client.AddDialer(e.peerProxy)
+			log.Println("[Configure] dialing the peers by the proxy", c.Redacted().PeerProxyURL)
+		}
 	}
 
 	e.closeSync = make(chan struct{})
@@ -154,45 +278,116 @@ func (e *Engine) Configure(c *Config) error {
 	e.trashDir = path.Join(c.DownloadDirectory, TrashTorrentDir)
 	mkdir(e.cacheDir)
 	mkdir(e.trashDir)
+	e.httpCache = common.NewHTTPCache(path.Join(e.cacheDir, httpCacheDir), httpCacheInterval)
 	e.config = *c
+	e.applyTraceConfig(c)
+	e.applyMemoryConfig(c)
+	e.background.configure(c.BackgroundWorkers, c.BackgroundCPU, c.BackgroundNice)
+	e.uploadLimiter = tc.UploadRateLimiter
+	e.downloadLimiter = tc.DownloadRateLimiter
+	if isFirstConfigure {
+		// before the tasks of the session are added
+		e.openTaskDB()
+	}
+	_, sessionSpan := trace.Start(ctx, "engine.loadSession")
+	e.loadSession()
+	sessionSpan.Finish()
+	if isFirstConfigure {
+		e.loadDirtyFlag()
+		e.loadHistory()
+		e.startPlugins(c)
+	}
+	go e.dirtyFlagRoutine(e.closeSync)
+	go e.reverifyRoutine(e.closeSync)
+	go e.sessionRoutine(e.closeSync)
+	go e.scheduleRoutine(e.closeSync)
+	go e.recycleRoutine(e.closeSync)
+	go e.blocklistRoutine(e.closeSync)
+	go e.memoryRoutine(e.closeSync)
+	go e.historyRoutine(e.closeSync)
+	go e.scrapeRoutine(e.closeSync)
+	go e.trackerListRoutine(e.closeSync)
+	go e.webseedRoutine(e.closeSync)
+	go e.taskTimesRoutine(e.closeSync)
+	go e.taskErrorRoutine(e.closeSync)
+	if !c.DisableLSD {
+		go e.lsdRoutine(e.closeSync)
+	}
+	// a temporary limit outlives the reconfigure
+	go e.applyRateLimits()
 	return nil
 }
 
+// HTTPCache is the on-disk cache for fetching remote resources
+func (e *Engine) HTTPCache() *common.HTTPCache {
+	e.RLock()
+	defer e.RUnlock()
+	return e.httpCache
+}
+
 func (e *Engine) IsConfigred() bool {
 	e.RLock()
 	defer e.RUnlock()
 	return e.client != nil
 }
 
-// NewMagnet -> newTorrentBySpec
-func (e *Engine) NewMagnet(magnetURI string) error {
+// NewMagnet -> newTorrentBySpec, dir is the download directory of the task,
+// relative to DownloadDirectory, empty for DownloadDirectory itself
+func (e *Engine) NewMagnet(magnetURI, dir string) error {
+	return e.NewMagnetContext(context.Background(), magnetURI, dir)
+}
+
+// NewMagnetContext is NewMagnet traced as a part of the request of ctx
+func (e *Engine) NewMagnetContext(ctx context.Context, magnetURI, dir string) error {
 	log.Println("[NewMagnet] called:", magnetURI)
+	if m, ok := MagnetOfInfoHash(magnetURI); ok {
+		magnetURI = m
+	}
 	spec, err := torrent.TorrentSpecFromMagnetUri(magnetURI)
 	if err != nil {
 		return err
 	}
-	e.newMagnetCacheFile(magnetURI, spec.InfoHash.HexString())
-	return e.newTorrentBySpec(spec, taskMagnet)
+	ih := spec.InfoHash.HexString()
+	if !e.isKnownTask(ih) {
+		if err := e.lowDiskErr(""); err != nil {
+			log.Warn("[NewMagnet]", err)
+			e.dropPreset(ih)
+			return err
+		}
+	}
+	e.newMagnetCacheFile(magnetURI, ih)
+	return e.newTorrentBySpec(ctx, spec, taskMagnet, dir)
 }
 
 // NewTorrentByReader -> newTorrentBySpec
-func (e *Engine) NewTorrentByReader(r io.Reader) error {
+func (e *Engine) NewTorrentByReader(r io.Reader, dir string) error {
+	return e.NewTorrentByReaderContext(context.Background(), r, dir)
+}
+
+// NewTorrentByReaderContext is NewTorrentByReader traced as a part of the
+// request of ctx
+func (e *Engine) NewTorrentByReaderContext(ctx context.Context, r io.Reader, dir string) error {
 	info, err := metainfo.Load(r)
 	if err != nil {
 		return err
 	}
+	if err := e.checkNewTorrent(info, dir); err != nil {
+		log.Warn("[NewTorrentByReader]", err)
+		e.dropPreset(info.HashInfoBytes().HexString())
+		return err
+	}
 	spec := torrent.TorrentSpecFromMetaInfo(info)
 	e.newTorrentCacheFile(info)
-	return e.newTorrentBySpec(spec, taskTorrent)
+	return e.newTorrentBySpec(ctx, spec, taskTorrent, dir)
 }
 
 // NewTorrentByFilePath -> newTorrentBySpec
-func (e *Engine) NewTorrentByFilePath(path string) error {
+func (e *Engine) NewTorrentByFilePath(path, dir string) error {
 	// torrent.TorrentSpecFromMetaInfo may panic if the info is malformed
 	defer func() error {
 		if r := recover(); r != nil {
 			err := fmt.Errorf("Error loading new torrent from file %s: %+v", path, r)
-			log.Println(err)
+			log.Warn(err)
 			return err
 		}
 		return nil
@@ -202,49 +397,173 @@ func (e *Engine) NewTorrentByFilePath(path string) error {
 	if err != nil {
 		return err
 	}
+	if err := e.checkNewTorrent(info, dir); err != nil {
+		log.Warn("[NewTorrentByFilePath]", err)
+		e.dropPreset(info.HashInfoBytes().HexString())
+		return err
+	}
 	e.newTorrentCacheFile(info)
 	spec := torrent.TorrentSpecFromMetaInfo(info)
-	return e.newTorrentBySpec(spec, taskTorrent)
+	return e.newTorrentBySpec(context.Background(), spec, taskTorrent, dir)
 }
 
-func (e *Engine) isReadyAddTask() bool {
+// isReadyAddTask tells whether the task can be added by MaxConcurrentTask for all
+// the tasks, and MaxActiveDownloads or MaxActiveSeeds by the finished state of it
+func (e *Engine) isReadyAddTask(ih string) bool {
 	nowTorrentsLen := len(e.client.Torrents())
 	if e.config.MaxConcurrentTask > 0 && nowTorrentsLen >= e.config.MaxConcurrentTask {
 		return false
 	}
-	return true
+	if e.config.MaxActiveDownloads <= 0 && e.config.MaxActiveSeeds <= 0 {
+		return true
+	}
+	downloads, seeds := e.activeTasks()
+	if e.isFinishedTask(ih) {
+		return e.config.MaxActiveSeeds <= 0 || seeds < e.config.MaxActiveSeeds
+	}
+	return e.config.MaxActiveDownloads <= 0 || downloads < e.config.MaxActiveDownloads
 }
 
 // NewTorrentBySpec -> *Torrent -> addTorrentTask
-func (e *Engine) newTorrentBySpec(spec *torrent.TorrentSpec, taskT taskType) error {
+func (e *Engine) newTorrentBySpec(ctx context.Context, spec *torrent.TorrentSpec, taskT taskType, dir string) (err error) {
 	ih := spec.InfoHash.HexString()
-	log.Println("[newTorrentBySpec] called", ih)
+	log.Debug("[newTorrentBySpec] called", ih)
+	ctx, span := trace.Start(ctx, "engine.AddTask", "infohash", ih)
+	defer func() {
+		// queued is no failure
+		if !errors.Is(err, ErrMaxConnTasks) {
+			span.SetError(err)
+		}
+		span.Finish()
+	}()
+	// the preset is taken by the new task, or left by the add that failed or
+	// found the task, and mustn't go to a later add
+	defer e.dropPreset(ih)
+	if err := e.shuttingDownErr(); err != nil {
+		return err
+	}
+
+	// adding an added task again merges its trackers and web seeds, the
+	// queued ones are being added
+	e.RLock()
+	existing, ok := e.ts[ih]
+	e.RUnlock()
+	if ok {
+		existing.Lock()
+		queueing := existing.IsQueueing
+		existing.Unlock()
+		if !queueing {
+			return e.mergeSpec(existing, spec)
+		}
+	}
+
+	// the quotas of the user adding it, the restored tasks are known
+	if owner := e.presetOwner(ih); owner != "" && !e.hasSession(ih) {
+		if err := e.checkUserQuota(owner, specSize(spec), true); err != nil {
+			log.Warn("[newTorrentBySpec]", ih, err)
+			e.removeMagnetCache(ih)
+			e.removeTorrentCache(ih, false)
+			return err
+		}
+	}
+
+	// the AddHook decides on the new tasks, the queued ones are decided
+	var decision *addDecision
+	if !ok && !e.hasSession(ih) {
+		_, hookSpan := trace.Start(ctx, "engine.AddHook", "infohash", ih)
+		decision, err = e.runAddHook(ih, spec, dir)
+		hookSpan.SetError(err)
+		hookSpan.Finish()
+		if err != nil {
+			e.removeMagnetCache(ih)
+			e.removeTorrentCache(ih, false)
+			return err
+		}
+	}
+
+	// restored tasks come without dir, use the one saved when added, checked
+	// then
+	saved := false
+	if dir == "" {
+		dir = e.taskDir(ih)
+		saved = dir != ""
+	}
+	// new tasks go to the dir of their label
+	if dir == "" && !e.hasSession(ih) {
+		label, _ := e.inferLabel(spec.DisplayName, flattenTrackers(spec.Trackers))
+		if decision != nil && decision.Label != "" {
+			label = decision.Label
+		}
+		dir = e.labelDir(label).download
+	}
+	if dir != "" && !saved {
+		var err error
+		if dir, err = e.config.resolveTaskDir(dir); err != nil {
+			return err
+		}
+		e.setTaskDir(ih, dir)
+	}
 
 	e.taskMutex.Lock()
 	defer e.taskMutex.Unlock()
+	// the torrent of FetchMetadata stores no data
+	if e.metaFetches[ih] > 0 {
+		return ErrFetchingMetadata
+	}
 	// whether add as pretasks
-	if !e.isReadyAddTask() {
+	forced := e.takeForceStart(ih)
+	if !forced && !e.isReadyAddTask(ih) {
 		if !e.isTaskInList(ih) {
 			log.Printf("[newTorrentBySpec] reached max task %d, add as pretask: %s %v", e.config.MaxConcurrentTask, ih, taskT)
 			e.pushWaitTask(ih, taskT)
 		} else {
 			log.Printf("[newTorrentBySpec] reached max task %d, task already in queue: %s %v", e.config.MaxConcurrentTask, ih, taskT)
 		}
-		_, err := e.upsertTorrent(ih, spec.DisplayName, true) // show queueing task
+		t, err := e.upsertTorrent(ih, spec.DisplayName, true) // show queueing task
 		common.FancyHandleError(err)
+		t.Lock()
+		t.DownloadDir = dir
+		t.Unlock()
+		applyAddDecision(t, decision)
 		return ErrMaxConnTasks
 	}
 
 	t, _ := e.upsertTorrent(ih, spec.DisplayName, false)
+	t.Lock()
+	t.DownloadDir = dir
+	t.Unlock()
+	applyAddDecision(t, decision)
+	if forced {
+		t.Lock()
+		t.resumeStarted, t.resumeStopped = true, false
+		t.Unlock()
+	}
+	e.applyLabel(t, spec.DisplayName, flattenTrackers(spec.Trackers))
+	if dir != "" {
+		spec.Storage = e.taskStorage(dir)
+	}
+	_, addSpan := trace.Start(ctx, "torrent.AddTorrentSpec", "infohash", ih)
 	tt, _, err := e.client.AddTorrentSpec(spec)
+	addSpan.SetError(err)
+	addSpan.Finish()
 	if err != nil {
+		e.emit(EventError, t, err)
 		return err
 	}
 
 	meta := tt.Metainfo()
-	if len(e.Trackers) > 0 && (e.config.AlwaysAddTrackers || len(meta.AnnounceList) == 0) {
-		log.Printf("[newTorrent] added %d public trackers\n", len(e.Trackers))
+	trackersOnly := e.applyPrivate(t, tt.Info())
+	if len(e.Trackers) > 0 && !trackersOnly && (e.config.AlwaysAddTrackers || len(meta.AnnounceList) == 0) {
+		log.Debugf("[newTorrent] added %d public trackers\n", len(e.Trackers))
 		tt.AddTrackers([][]string{e.Trackers})
+		if tt.Info() == nil {
+			// removed if the info turns out private
+			t.Lock()
+			t.injectedTrackers = trackersMissing(meta.UpvertedAnnounceList(), e.Trackers)
+			t.Unlock()
+		}
+	} else if trackersOnly {
+		log.Printf("[newTorrent] %s is private, peers only from its trackers", ih)
 	}
 
 	go e.torrentEventProcessor(tt, t, ih)
@@ -253,27 +572,70 @@ func (e *Engine) newTorrentBySpec(spec *torrent.TorrentSpec, taskT taskType) err
 
 func (e *Engine) torrentEventProcessor(tt *torrent.Torrent, t *Torrent, ih string) {
 
-	select {
-	case <-e.closeSync:
-		log.Println("Engine shutdown while waiting Info", ih)
-		tt.Drop()
-		return
-	case <-t.dropWait:
-		tt.Drop()
-		log.Println("Task Dropped while waiting Info", ih)
-		go e.NextWaitTask() // nolint: errcheck
-		return
-	case <-tt.GotInfo():
-		// Already got full torrent info
-		// If the origin is from a magnet link, remove it, cache the torrent data
-		e.removeMagnetCache(ih)
-		m := tt.Metainfo()
-		e.newTorrentCacheFile(&m)
-		t.updateOnGotInfo(tt)
-		e.TsChanged <- struct{}{}
-	}
-
-	if e.config.AutoStart {
+	var infoTimer *time.Timer
+	var infoTimeout <-chan time.Time
+	if e.config.MetadataTimeout > 0 {
+		infoTimer = time.NewTimer(e.config.MetadataTimeout)
+		defer infoTimer.Stop()
+		infoTimeout = infoTimer.C
+	}
+
+waitInfo:
+	for {
+		select {
+		case <-e.closeSync:
+			log.Debug("Engine shutdown while waiting Info", ih)
+			tt.Drop()
+			return
+		case <-t.dropWait:
+			tt.Drop()
+			log.Debug("Task Dropped while waiting Info", ih)
+			go e.NextWaitTask() // nolint: errcheck
+			return
+		case <-infoTimeout:
+			if e.onMetadataTimeout(tt, t) {
+				infoTimer.Reset(e.config.MetadataTimeout)
+			} else {
+				infoTimeout = nil
+			}
+		case <-tt.GotInfo():
+			// the size and files of a new magnet are known now
+			if err := e.checkGotInfo(t, tt.Info()); err != nil {
+				e.rejectTask(t, err)
+				tt.Drop()
+				go e.NextWaitTask() // nolint: errcheck
+				return
+			}
+			// Already got full torrent info
+			// If the origin is from a magnet link, remove it, cache the torrent data
+			e.removeMagnetCache(ih)
+			m := tt.Metainfo()
+			e.newTorrentCacheFile(&m)
+			t.updateOnGotInfo(tt)
+			if e.applyPrivate(t, tt.Info()) {
+				go e.dropInjectedTrackers(t)
+			} else {
+				t.Lock()
+				t.injectedTrackers = nil
+				t.Unlock()
+			}
+			t.Lock()
+			// the info got after the timeout
+			t.MetadataTimeout = false
+			t.applyFilePriorities()
+			t.Unlock()
+			// the name of a magnet is known now
+			e.applyLabel(t, t.Name, flattenTrackers(m.UpvertedAnnounceList()))
+			e.emit(EventMetadata, t, nil)
+			e.notifyChanged()
+			if e.takeRecheck(ih) {
+				go e.recheckTorrent(t)
+			}
+			break waitInfo
+		}
+	}
+
+	if e.shouldStart(t) {
 		go e.StartTorrent(ih) // nolint: errcheck
 	}
 
@@ -284,30 +646,67 @@ func (e *Engine) torrentEventProcessor(tt *torrent.Torrent, t *Torrent, ih strin
 	for {
 		select {
 		case <-timeTk.C:
-			if !t.IsAllFilesDone {
-				t.updateFileStatus()
-			}
+			// the file counters keep going after the files are done
+			t.updateFileStatus()
 			if !t.Done {
 				t.updateTorrentStatus()
+				t.Lock()
+				e.publishProgress(t)
+				t.Unlock()
 			}
 			if t.Started {
 				e.taskRoutine(t)
 			}
+			e.applyTaskSchedule(t, time.Now())
 			t.updateConnStat()
 		case <-t.dropWait:
 			tt.Drop()
-			log.Println("Task Droped, exit loop:", ih)
+			log.Debug("Task Droped, exit loop:", ih)
 			go e.NextWaitTask() // nolint: errcheck
 			return
 		case <-e.closeSync:
-			log.Println("Engine shutdown while downloading", ih)
+			log.Debug("Engine shutdown while downloading", ih)
 			tt.Drop()
 			return
 		}
 	}
 }
 
-//GetTorrents just get the local infohash->Torrent map
+// onMetadataTimeout is called when the info isn't got in time,
+// returns true if the task is going to wait for another round
+func (e *Engine) onMetadataTimeout(tt *torrent.Torrent, t *Torrent) bool {
+	t.Lock()
+	if t.MetadataRetries < e.config.MetadataRetries {
+		t.MetadataRetries++
+		log.Printf("[MetadataTimeout] %s retry %d/%d", t.InfoHash, t.MetadataRetries, e.config.MetadataRetries)
+		if len(e.Trackers) > 0 {
+			mi := tt.Metainfo()
+			t.injectedTrackers = append(t.injectedTrackers, trackersMissing(mi.UpvertedAnnounceList(), e.Trackers)...)
+		}
+		t.Unlock()
+		if len(e.Trackers) > 0 {
+			tt.AddTrackers([][]string{e.Trackers})
+		}
+		return true
+	}
+	t.MetadataTimeout = true
+	e.emit(EventError, t, errMetadataTimeout)
+	t.Unlock()
+
+	if e.config.MetadataTimeoutRemove {
+		log.Warnf("[MetadataTimeout] %s removed after %d retries", t.InfoHash, e.config.MetadataRetries)
+		go e.stopRemoveTask(t.InfoHash)
+	} else {
+		log.Warnf("[MetadataTimeout] %s flagged after %d retries", t.InfoHash, e.config.MetadataRetries)
+	}
+	e.notifyChanged()
+	return false
+}
+
+//GetTorrents just get the local infohash->Torrent map, which is only safe to
+//read with the engine lock held.
+//
+//Deprecated: use Torrents or Torrent.
 func (e *Engine) GetTorrents() *map[string]*Torrent {
 	return &e.ts
 }
@@ -315,12 +714,12 @@ func (e *Engine) GetTorrents() *map[string]*Torrent {
 // TaskRoutine
 func (e *Engine) taskRoutine(t *Torrent) {
 
-	// stops task on reaching ratio
-	if e.config.SeedRatio > 0 && t.SeedRatio > e.config.SeedRatio &&
-		t.Started && !t.ManualStarted && t.Done {
-		log.Printf("[TaskRoutine]%s Stopped and Drop due to reaching SeedRatio %f", t.InfoHash, t.SeedRatio)
-		go e.stopRemoveTask(t.InfoHash)
-	}
+	e.checkStalledSeed(t)
+
+	// stops task on reaching the seed ratio, seed time or idle time
+	e.checkSeedLimits(t)
+
+	e.checkAlerts(t)
 
 	// stops task when there're tasks waiting after `SeedTime`
 	if e.config.SeedTime > 0 && e.waitList.Len() > 0 &&
@@ -333,9 +732,8 @@ func (e *Engine) taskRoutine(t *Torrent) {
 }
 
 func (e *Engine) stopRemoveTask(ih string) {
-	common.FancyHandleError(e.StopTorrent(ih))
-	e.RemoveCache(ih)
-	common.FancyHandleError(e.DeleteTorrent(ih))
+	e.setDeleteReason(ih, DeleteReasonSeedLimit)
+	common.FancyHandleError(e.RemoveTorrentData(ih, e.config.RemoveData))
 }
 
 func (e *Engine) ManualStartTorrent(infohash string) error {
@@ -344,6 +742,8 @@ func (e *Engine) ManualStartTorrent(infohash string) error {
 		t.Lock()
 		defer t.Unlock()
 		t.ManualStarted = true
+		// started by the user before its time
+		t.StartAt = time.Time{}
 	} else {
 		return err
 	}
@@ -352,6 +752,10 @@ func (e *Engine) ManualStartTorrent(infohash string) error {
 
 func (e *Engine) StartTorrent(infohash string) error {
 	log.Println("StartTorrent", infohash)
+	if e.bindDown() {
+		return errBindDown
+	}
+	e.reclaimForStart(infohash)
 	e.Lock()
 	defer e.Unlock()
 
@@ -359,6 +763,12 @@ func (e *Engine) StartTorrent(infohash string) error {
 	if err != nil {
 		return err
 	}
+	if err := e.checkDiskSpace(t); err != nil {
+		log.Warn("[StartTorrent]", infohash, err)
+		e.emit(EventError, t, err)
+		e.lowDisk(t.InfoHash, t.Name, err)
+		return err
+	}
 	t.Lock()
 	defer t.Unlock()
 
@@ -367,14 +777,9 @@ func (e *Engine) StartTorrent(infohash string) error {
 	}
 	t.Started = true
 	t.StartedAt = time.Now()
-	for _, f := range t.Files {
-		if f != nil {
-			f.Started = true
-		}
-	}
-	if t.t.Info() != nil {
-		t.t.DownloadAll()
-	}
+	// the skipped files aren't downloaded
+	t.applyFilePriorities()
+	e.emit(EventStarted, t, nil)
 	return nil
 }
 
@@ -399,9 +804,10 @@ func (e *Engine) StopTorrent(infohash string) error {
 
 	t.Started = false
 	t.StoppedAt = time.Now()
-	for _, f := range t.Files {
-		f.Started = false
-	}
+	t.applyFilePriorities()
+	e.emit(EventStopped, t, nil)
+	// stopped tasks aren't active for MaxActiveDownloads/MaxActiveSeeds
+	go e.NextWaitTask() // nolint: errcheck
 
 	return nil
 }
@@ -418,63 +824,66 @@ func (e *Engine) DeleteTorrent(infohash string) error {
 	close(t.dropWait)
 	e.waitList.Remove(infohash)
 	e.deleteTorrent(infohash)
+	// kept when reloaded, see reloadTask
+	e.removeHistory(infohash)
+	e.peerCounters.dropTask(infohash)
+	e.superSeeds.set(infohash, false)
+	e.taskErrors.drop(infohash)
+	e.recordDeleting(t)
+	e.emit(EventDeleted, t, nil)
+	e.dropTimeline(infohash)
 	return nil
 }
 
+// StartFile downloads the file at normal priority, starting the task
 func (e *Engine) StartFile(infohash, filepath string) error {
+	e.RLock()
 	t, err := e.getTorrent(infohash)
+	e.RUnlock()
 	if err != nil {
 		return err
 	}
 	t.Lock()
 	defer t.Unlock()
-	var f *File
 	for _, file := range t.Files {
-		if file.Path == filepath {
-			f = file
-			break
+		if file != nil && file.Path == filepath && file.Started {
+			return fmt.Errorf("already started")
 		}
 	}
-	if f == nil {
-		return fmt.Errorf("Missing file %s", filepath)
-	}
-	if f.Started {
-		return fmt.Errorf("already started")
+	if err := t.setFilePriority(filepath, FilePriorityNormal); err != nil {
+		return err
 	}
 	if !t.Started {
 		t.Started = true
+		t.StartedAt = time.Now()
 	}
-	f.Started = true
-	f.f.SetPriority(torrent.PiecePriorityNormal)
+	t.applyFilePriorities()
+	e.notifyChanged()
 	return nil
 }
 
+// StopFile skips the file, stopping the task when all files are skipped
 func (e *Engine) StopFile(infohash, filepath string) error {
+	e.RLock()
 	t, err := e.getTorrent(infohash)
+	e.RUnlock()
 	if err != nil {
 		return err
 	}
 	t.Lock()
 	defer t.Unlock()
-	var f *File
-	for _, file := range t.Files {
-		if file.Path == filepath {
-			f = file
-			break
+	for i, file := range t.Files {
+		if file != nil && file.Path == filepath && t.filePriorities.of(i) == FilePrioritySkip {
+			return fmt.Errorf("already stopped")
 		}
 	}
-	if f == nil {
-		return fmt.Errorf("missing file %s", filepath)
-	}
-	if !f.Started {
-		return fmt.Errorf("already stopped")
+	if err := t.setFilePriority(filepath, FilePrioritySkip); err != nil {
+		return err
 	}
-	f.Started = false
-	f.f.SetPriority(torrent.PiecePriorityNone)
 
 	allStopped := true
-	for _, file := range t.Files {
-		if file.Started {
+	for i := range t.Files {
+		if t.filePriorities.of(i) != FilePrioritySkip {
 			allStopped = false
 			break
 		}
@@ -484,11 +893,13 @@ func (e *Engine) StopFile(infohash, filepath string) error {
 		t.Started = false
 		t.StoppedAt = time.Now()
 	}
-
+	t.applyFilePriorities()
+	e.notifyChanged()
 	return nil
 }
 
 func (e *Engine) RemoveCache(infohash string) {
 	e.removeMagnetCache(infohash)
 	e.removeTorrentCache(infohash, true)
+	e.setTaskDir(infohash, "")
 }
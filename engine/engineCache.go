@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -29,7 +30,7 @@ func (e *Engine) newMagnetCacheFile(magnetURI, infohash string) {
 				defer cf.Close()
 				_, err := cf.WriteString(magnetURI)
 				common.HandleError(err)
-				log.Println("created magnet cache info file", infohash)
+				log.Debug("created magnet cache info file", infohash)
 			}
 		}
 	}
@@ -48,9 +49,9 @@ func (e *Engine) newTorrentCacheFile(meta *metainfo.MetaInfo) {
 			if err == nil {
 				defer cf.Close()
 				common.FancyHandleError(meta.Write(cf))
-				log.Println("created torrent cache file", infohash)
+				log.Debug("created torrent cache file", infohash)
 			} else {
-				log.Println("failed to create torrent file", err)
+				log.Warn("failed to create torrent file", err)
 			}
 		}
 	}
@@ -61,9 +62,9 @@ func (e *Engine) removeMagnetCache(infohash string) {
 	cacheInfoPath := filepath.Join(e.cacheDir,
 		fmt.Sprintf("%s%s.info", cacheSavedPrefix, infohash))
 	if err := os.Remove(cacheInfoPath); err == nil {
-		log.Printf("removed magnet info file %s", cacheInfoPath)
+		log.Debugf("removed magnet info file %s", cacheInfoPath)
 	} else if !os.IsNotExist(err) { // it's fine if the cache is not exists
-		log.Printf("fail to removed cache file [%s], %s", infohash, err)
+		log.Warnf("fail to removed cache file [%s], %s", infohash, err)
 	}
 }
 
@@ -74,42 +75,92 @@ func (e *Engine) removeTorrentCache(infohash string, toTrash bool) {
 	if toTrash {
 		trashFilePath := filepath.Join(e.trashDir, fileName)
 		if err := os.Rename(cacheFilePath, trashFilePath); err == nil {
-			log.Printf("move torrent file to trash [%s]", trashFilePath)
+			log.Debugf("move torrent file to trash [%s]", trashFilePath)
 		} else {
-			log.Println("fail to move to trash", err)
+			log.Warn("fail to move to trash", err)
 		}
 	} else {
 		if err := os.Remove(cacheFilePath); err == nil {
-			log.Printf("removed torrent file [%s]", cacheFilePath)
+			log.Debugf("removed torrent file [%s]", cacheFilePath)
 		} else if !os.IsNotExist(err) { // it's fine if the cache is not exists
-			log.Printf("fail to removed cache file [%s] %s", infohash, err)
+			log.Warnf("fail to removed cache file [%s] %s", infohash, err)
 		}
 	}
 }
 
+func (e *Engine) magnetCacheFileName(infohash string) string {
+	return filepath.Join(e.cacheDir,
+		fmt.Sprintf("%s%s.info", cacheSavedPrefix, infohash))
+}
+
 func (e *Engine) TorrentCacheFileName(infohash string) string {
 	cacheFilePath := filepath.Join(e.cacheDir,
 		fmt.Sprintf("%s%s.torrent", cacheSavedPrefix, infohash))
 	return cacheFilePath
 }
 
+// PushWaitTask stops the task and puts it back to the wait list. The state
+// and settings of the task are kept, it's resumed when promoted.
 func (e *Engine) PushWaitTask(ih string) error {
-	log.Println("Pushed task to wait", ih)
-	e.pushWaitTask(ih, taskTorrent)
-	info, err := metainfo.LoadFromFile(e.TorrentCacheFileName(ih))
+	spec, tp, err := e.cachedSpec(ih)
 	if err != nil {
 		return err
 	}
-	spec := torrent.TorrentSpecFromMetaInfo(info)
+	e.RLock()
+	t, err := e.getTorrent(ih)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	s := t.session()
+	t.Unlock()
+	s.Started, s.Stopped = true, false
+
+	e.Lock()
+	if e.ts[ih] == t {
+		close(t.dropWait)
+		e.waitList.Remove(ih)
+		e.deleteTorrent(ih)
+	}
+	e.Unlock()
+	e.sessions.Lock()
+	if e.sessions.m != nil {
+		e.sessions.m[ih] = s
+		e.sessions.dirty = true
+	}
+	e.sessions.Unlock()
+
+	log.Debug("Pushed task to wait", ih)
+	e.pushWaitTask(ih, tp)
 	_, err = e.upsertTorrent(ih, spec.DisplayName, true)
 	return err
 }
 
+// cachedSpec loads the spec the task was added with from its cached torrent,
+// or its magnet while the info isn't got
+func (e *Engine) cachedSpec(ih string) (*torrent.TorrentSpec, taskType, error) {
+	info, err := metainfo.LoadFromFile(e.TorrentCacheFileName(ih))
+	if err == nil {
+		spec, err := torrent.TorrentSpecFromMetaInfoErr(info)
+		return spec, taskTorrent, err
+	}
+	if !os.IsNotExist(err) {
+		return nil, taskTorrent, err
+	}
+	mag, err := ioutil.ReadFile(e.magnetCacheFileName(ih))
+	if err != nil {
+		return nil, taskMagnet, err
+	}
+	spec, err := torrent.TorrentSpecFromMagnetUri(strings.TrimSpace(string(mag)))
+	return spec, taskMagnet, err
+}
+
 func (e *Engine) RestoreTask(fn string) error {
 
 	isCachedFile := strings.HasPrefix(filepath.Base(fn), cacheSavedPrefix)
 	if strings.HasSuffix(fn, ".torrent") {
-		if err := e.NewTorrentByFilePath(fn); err != nil {
+		if err := e.NewTorrentByFilePath(fn, ""); err != nil {
 			return err
 		}
 		if isCachedFile {
@@ -121,15 +172,15 @@ func (e *Engine) RestoreTask(fn string) error {
 	} else if strings.HasSuffix(fn, ".info") && isCachedFile {
 		mag, err := ioutil.ReadFile(fn)
 		if err != nil {
-			log.Printf("Task: fail to read %s\n", fn)
+			log.Warnf("Task: fail to read %s\n", fn)
 			return err
 		}
-		if err := e.NewMagnet(string(mag)); err != nil {
+		if err := e.NewMagnet(string(mag), ""); err != nil {
 			return err
 		}
 		log.Printf("[RestoreMagnet] Restored: %s \n", fn)
 	} else {
-		log.Println("Cache file doesn't match", fn)
+		log.Warn("Cache file doesn't match", fn)
 	}
 
 	return nil
@@ -139,7 +190,7 @@ func (e *Engine) RestoreCacheDir() {
 
 	files, err := ioutil.ReadDir(e.cacheDir)
 	if err != nil {
-		log.Println("RestoreCacheDir failed read cachedir", err)
+		log.Warn("RestoreCacheDir failed read cachedir", err)
 		return
 	}
 
@@ -148,45 +199,71 @@ func (e *Engine) RestoreCacheDir() {
 		return files[i].ModTime().Before(files[j].ModTime())
 	})
 
+	var restore []string
 	for _, i := range files {
-		if i.IsDir() {
+		if i.IsDir() || strings.HasPrefix(i.Name(), ".") {
 			continue
 		}
-		common.FancyHandleError(e.RestoreTask(path.Join(e.cacheDir, i.Name())))
+		restore = append(restore, i.Name())
 	}
-}
+	// the tasks waiting in the last run are queued again in their order
+	sortByWaitList(restore, e.loadWaitList())
 
-func (e *Engine) NextWaitTask() error {
-	if !e.isReadyAddTask() {
-		log.Println("NextWaitTask: engine tasks max")
-		return ErrMaxConnTasks
+	e.beginRestore(len(restore))
+	defer e.endRestore()
+	for _, name := range restore {
+		common.FancyHandleError(e.RestoreTask(path.Join(e.cacheDir, name)))
+		e.restoredOne()
 	}
+}
 
+// NextWaitTask adds the first task of the wait list ready to be added, a seed may
+// go before the downloads when only MaxActiveDownloads is reached
+func (e *Engine) NextWaitTask() error {
 	for {
-		if elm := e.waitList.Pop(); elm != nil {
-			var res string
-			te := elm.(taskElem)
-			switch te.tp {
-			case taskTorrent:
-				res = fmt.Sprintf("%s%s.torrent", cacheSavedPrefix, te.ih)
-			case taskMagnet:
-				res = fmt.Sprintf("%s%s.info", cacheSavedPrefix, te.ih)
-			}
-
-			fn := path.Join(e.cacheDir, res)
-			if _, err := os.Stat(fn); err != nil {
-				log.Println("NextWaitTask RestoreTask err:", fn, err)
+		if e.waitList.Len() == 0 {
+			log.Debug("NextWaitTask: wait list empty")
+			return ErrWaitListEmpty
+		}
+		if te, ok := e.nextReadyTask(); ok {
+			err := e.promoteWaitTask(te)
+			if os.IsNotExist(err) {
+				log.Warn("NextWaitTask RestoreTask err:", te.ih, err)
 				continue
 			}
-			return e.RestoreTask(fn)
+			return err
 		} else {
-			log.Println("NextWaitTask: wait list empty")
-			return ErrWaitListEmpty
+			log.Debug("NextWaitTask: engine tasks max")
+			return ErrMaxConnTasks
 		}
 	}
 }
 
+// promoteWaitTask adds the queued task by the spec it was added with, the
+// trackers and name of its cached torrent or magnet. The settings it got when
+// added, the directory, label and file priorities, stay with the task.
+func (e *Engine) promoteWaitTask(te taskElem) error {
+	spec, tp, err := e.cachedSpec(te.ih)
+	if err != nil {
+		return err
+	}
+	e.RLock()
+	t, ok := e.ts[te.ih]
+	e.RUnlock()
+	if ok && spec.DisplayName == "" {
+		t.Lock()
+		spec.DisplayName = t.Name
+		t.Unlock()
+	}
+	err = e.newTorrentBySpec(context.Background(), spec, tp, e.taskDir(te.ih))
+	if err == nil {
+		log.Printf("[NextWaitTask] promoted %s", te.ih)
+	}
+	e.notifyChanged()
+	return err
+}
+
 func (e *Engine) pushWaitTask(ih string, tp taskType) {
-	e.waitList.Push(taskElem{ih: ih, tp: tp})
-	log.Println("waitqueue len", e.waitList.Len())
+	e.waitList.Push(taskElem{ih: ih, tp: tp, priority: e.queuePriority(ih)})
+	log.Debug("waitqueue len", e.waitList.Len())
 }
@@ -3,14 +3,40 @@ package engine
 import (
 	"fmt"
 	"io"
-	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/anacrolix/torrent"
-	"github.com/fsnotify/fsnotify"
 )
 
+// Torrents returns a copy of the infohash->Torrent map, safe to range over
+// without the engine lock. The tasks are shared, lock each one to read it.
+func (e *Engine) Torrents() map[string]*Torrent {
+	e.RLock()
+	defer e.RUnlock()
+	m := make(map[string]*Torrent, len(e.ts))
+	for ih, t := range e.ts {
+		m[ih] = t
+	}
+	return m
+}
+
+// Torrent returns the task of the infohash
+func (e *Engine) Torrent(infohash string) (*Torrent, bool) {
+	e.RLock()
+	defer e.RUnlock()
+	t, ok := e.ts[infohash]
+	return t, ok
+}
+
+// Revision increases on every change of the tasks, to tell cheaply whether a
+// state built from them is stale
+func (e *Engine) Revision() uint64 {
+	return atomic.LoadUint64(&e.revision)
+}
+
 func (e *Engine) isTaskInList(ih string) bool {
 	e.RLock()
 	defer e.RUnlock()
@@ -20,7 +46,7 @@ func (e *Engine) isTaskInList(ih string) bool {
 
 func (e *Engine) upsertTorrent(ih, name string, isQueueing bool) (*Torrent, error) {
 	defer func() {
-		e.TsChanged <- struct{}{}
+		e.notifyChanged()
 	}()
 
 	e.RLock()
@@ -36,9 +62,13 @@ func (e *Engine) upsertTorrent(ih, name string, isQueueing bool) (*Torrent, erro
 			e:          e,
 			dropWait:   make(chan struct{}),
 		}
+		e.applyDefaultRateLimit(torrent)
+		e.restoreSession(torrent)
+		e.takePreset(torrent)
 		e.Lock()
 		e.ts[ih] = torrent
 		e.Unlock()
+		e.emit(EventAdded, torrent, nil)
 		return torrent, nil
 	}
 	torrent.IsQueueing = isQueueing
@@ -52,44 +82,34 @@ func (e *Engine) getTorrent(infohash string) (*Torrent, error) {
 	return nil, fmt.Errorf("Missing torrent %x", infohash)
 }
 
-func (e *Engine) deleteTorrent(infohash string) {
-	delete(e.ts, infohash)
-	e.TsChanged <- struct{}{}
-}
-
-func (e *Engine) ParseTrackerList() error {
-	conf := e.config.TrackerList
-
-	trackers := []string{}
-
-	for _, l := range strings.Split(conf, "\n") {
-		line := strings.TrimSpace(l)
-		if line == "" {
-			continue
-		}
-
-		if strings.HasPrefix(line, "remote:") {
-			if lst, err := fetchTxtList(line[7:]); err == nil {
-				trackers = append(trackers, lst...)
-			} else {
-				log.Println("[ParseTrackerList] ignored", err, line)
-			}
-		} else {
-			trackers = append(trackers, line)
-		}
+// TorrentDataPath returns the path of the downloaded data of the torrent,
+// empty if the info isn't loaded or the path is outside of the download directory
+func (e *Engine) TorrentDataPath(infohash string) string {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil || !t.Loaded || t.Name == "" {
+		return ""
 	}
 
-	// remove duplicated entries
-	dupMap := make(map[string]struct{})
-	for _, t := range trackers {
-		dupMap[t] = struct{}{}
+	dldir := e.config.DownloadDirectory
+	if t.DownloadDir != "" {
+		dldir = t.DownloadDir
 	}
-	for t := range dupMap {
-		e.Trackers = append(e.Trackers, t)
+	p, err := filepath.Abs(filepath.Join(dldir, t.Name))
+	if err != nil || !strings.HasPrefix(p, dldir+string(filepath.Separator)) {
+		return ""
 	}
+	return p
+}
 
-	log.Printf("[ParseTrackerList] got %d trackers", len(e.Trackers))
-	return nil
+func (e *Engine) deleteTorrent(infohash string) {
+	delete(e.ts, infohash)
+	e.removeTorrentLimiter(infohash)
+	e.pieceCache.dropTask(infohash)
+	e.removeFileCounter(infohash)
+	e.removeSession(infohash)
+	e.notifyChanged()
 }
 
 func (e *Engine) WriteStauts(_w io.Writer) {
@@ -109,63 +129,12 @@ func (e *Engine) ConnStat() torrent.ConnStats {
 	return torrent.ConnStats{}
 }
 
-func (e *Engine) StartTorrentWatcher() error {
-
-	if e.watcher != nil {
-		log.Println("Torrent Watcher: close")
-		e.watcher.Close()
-		e.watcher = nil
-	}
-
-	if w, err := os.Stat(e.config.WatchDirectory); os.IsNotExist(err) || (err == nil && !w.IsDir()) {
-		return fmt.Errorf("[Watcher] WatchDirectory [%s] is not a dir, will not watch", e.config.WatchDirectory)
-	}
-
-	log.Printf("Torrent Watcher: watching torrent file in %s", e.config.WatchDirectory)
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
-	}
-	e.watcher = watcher
-
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				// log.Println("event:", event)
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					if !strings.HasSuffix(event.Name, ".torrent") {
-						continue
-					}
-					if st, err := os.Stat(event.Name); err != nil {
-						log.Println(err)
-						continue
-					} else if st.IsDir() {
-						continue
-					}
-
-					if err := e.NewTorrentByFilePath(event.Name); err == nil {
-						log.Printf("Torrent Watcher: added %s, file removed\n", event.Name)
-						os.Remove(event.Name)
-					} else {
-						log.Printf("Torrent Watcher: fail to add %s, ERR:%#v\n", event.Name, err)
-					}
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Println("error:", err)
-			}
-		}
-	}()
-	err = watcher.Add(e.config.WatchDirectory)
-	if err != nil {
-		log.Fatal(err)
-	}
+// WaitListLen returns the number of tasks in the wait list
+func (e *Engine) WaitListLen() int {
+	return e.waitList.Len()
+}
 
-	return nil
+// DoneCmdFailures returns the count of DoneCmd calls failed to start or exited non-zero
+func (e *Engine) DoneCmdFailures() uint64 {
+	return atomic.LoadUint64(&e.doneCmdFailures)
 }
@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// state events, only published on the bus
+const (
+	// EventChanged tells the tasks were added, removed or edited
+	EventChanged = "changed"
+	// EventProgress is published on every status update of a downloading task
+	EventProgress = "progress"
+)
+
+// lifecycleEvents are the events recorded in the timelines and posted to the webhooks
+var lifecycleEvents = []string{
+	EventAdded, EventMetadata, EventStarted, EventCompleted,
+	EventStopped, EventDeleted, EventError, EventVerified, EventAlert, EventLowDisk,
+}
+
+type subscriber struct {
+	ch    chan Event
+	types map[string]bool
+}
+
+// eventBus fans the events out to the subscribers, never blocking the publisher
+type eventBus struct {
+	sync.RWMutex
+	subs map[*subscriber]struct{}
+}
+
+func (b *eventBus) subscribe(size int, types []string) (<-chan Event, func()) {
+	s := &subscriber{ch: make(chan Event, size)}
+	if len(types) > 0 {
+		s.types = make(map[string]bool)
+		for _, typ := range types {
+			s.types[typ] = true
+		}
+	}
+	b.Lock()
+	if b.subs == nil {
+		b.subs = make(map[*subscriber]struct{})
+	}
+	b.subs[s] = struct{}{}
+	b.Unlock()
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			b.Lock()
+			delete(b.subs, s)
+			close(s.ch)
+			b.Unlock()
+		})
+	}
+}
+
+func (b *eventBus) publish(ev Event) {
+	b.RLock()
+	defer b.RUnlock()
+	for s := range b.subs {
+		if s.types != nil && !s.types[ev.Type] {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			// a pending changed/progress event stands for the dropped one
+			if ev.Type != EventChanged && ev.Type != EventProgress {
+				log.Warn("[Event] subscriber queue full, dropped", ev.Type, ev.InfoHash)
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events of the types, all when
+// none given. Events are dropped once size of them are pending, so a slow
+// subscriber never holds back the engine. cancel closes the channel.
+func (e *Engine) Subscribe(size int, types ...string) (events <-chan Event, cancel func()) {
+	return e.bus.subscribe(size, types)
+}
+
+// AddEventListener calls fn with every lifecycle event, in order
+func (e *Engine) AddEventListener(fn func(Event)) {
+	ch, _ := e.Subscribe(webhookQueue, lifecycleEvents...)
+	go func() {
+		for ev := range ch {
+			fn(ev)
+		}
+	}()
+}
+
+// notifyChanged tells the subscribers the tasks changed
+func (e *Engine) notifyChanged() {
+	atomic.AddUint64(&e.revision, 1)
+	e.bus.publish(Event{Type: EventChanged, Time: time.Now()})
+}
+
+// publishProgress must hold the lock of t
+func (e *Engine) publishProgress(t *Torrent) {
+	e.bus.publish(Event{
+		Type:     EventProgress,
+		InfoHash: t.InfoHash,
+		Name:     t.Name,
+		Size:     t.Size,
+		Percent:  t.Percent,
+		Time:     time.Now(),
+	})
+}
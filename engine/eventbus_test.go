@@ -0,0 +1,35 @@
+package engine
+
+import "testing"
+
+func TestEventBus(t *testing.T) {
+	var b eventBus
+	all, cancelAll := b.subscribe(4, nil)
+	changed, cancelChanged := b.subscribe(1, []string{EventChanged})
+
+	b.publish(Event{Type: EventAdded})
+	b.publish(Event{Type: EventChanged})
+	b.publish(Event{Type: EventChanged})
+
+	if n := len(all); n != 3 {
+		t.Errorf("all got %d events, want 3", n)
+	}
+	// the second changed event is dropped
+	if n := len(changed); n != 1 {
+		t.Errorf("changed got %d events, want 1", n)
+	}
+	if ev := <-changed; ev.Type != EventChanged {
+		t.Errorf("changed got %q", ev.Type)
+	}
+
+	cancelChanged()
+	cancelChanged()
+	b.publish(Event{Type: EventChanged})
+	if _, ok := <-changed; ok {
+		t.Error("event after cancel")
+	}
+	cancelAll()
+	if len(b.subs) != 0 {
+		t.Errorf("%d subscribers left", len(b.subs))
+	}
+}
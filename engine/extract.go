@@ -0,0 +1,455 @@
+package engine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// the limits of the data extracted from the archives of a task, against
+// the archives expanding to fill the disk
+const (
+	extractMaxEntries = 100000
+	// times the size of the task
+	extractMaxRatio = 100
+)
+
+// extractMaxSize is the most extracted from a task
+var extractMaxSize int64 = 1 << 40
+
+var (
+	errExtractPath  = errors.New("entry out of the extract directory")
+	errNoExtractor  = errors.New("no unrar or 7z found in PATH")
+	errExtractLimit = errors.New("archive over the extract limits")
+)
+
+// extractLimit is what's left to extract of a task
+type extractLimit struct {
+	size, max int64
+	entries   int
+}
+
+// newExtractLimit allows extractMaxRatio times the data of the task, up to
+// extractMaxSize
+func newExtractLimit(dataSize int64) *extractLimit {
+	size := dataSize * extractMaxRatio
+	if size <= 0 || size > extractMaxSize || size/extractMaxRatio != dataSize {
+		size = extractMaxSize
+	}
+	return &extractLimit{size: size, max: size, entries: extractMaxEntries}
+}
+
+// entry counts an entry of the size it tells, the size is checked again
+// when written
+func (l *extractLimit) entry(size int64) error {
+	if l.entries--; l.entries < 0 {
+		return fmt.Errorf("%w: more than %d entries", errExtractLimit, extractMaxEntries)
+	}
+	if size > l.size {
+		return l.sizeErr()
+	}
+	return nil
+}
+
+func (l *extractLimit) sizeErr() error {
+	return fmt.Errorf("%w: more than %s extracted", errExtractLimit, humanize.IBytes(uint64(l.max)))
+}
+
+// copy copies r to w, failing past the size left
+func (l *extractLimit) copy(w io.Writer, r io.Reader) error {
+	n, err := io.Copy(w, io.LimitReader(r, l.size+1))
+	l.size -= n
+	if err == nil && l.size < 0 {
+		err = l.sizeErr()
+	}
+	return err
+}
+
+// the volumes of a split rar other than the first, the first is .part1.rar
+// (or .part01.rar...) or the plain .rar of the old .r00 naming
+var rarVolumeRe = regexp.MustCompile(`(?i)\.part0*([0-9]+)\.rar$`)
+
+// archiveKind returns the format of the archive by its name, empty for the
+// other files and the later volumes of a split rar
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(lower, ".7z"):
+		return "7z"
+	case strings.HasSuffix(lower, ".rar"):
+		if m := rarVolumeRe.FindStringSubmatch(lower); m != nil && m[1] != "1" {
+			return ""
+		}
+		return "rar"
+	}
+	return ""
+}
+
+// findArchives lists the archives in root, a file or a directory, with
+// their total size and the size of all the files of root
+func findArchives(root string) ([]string, int64, int64, error) {
+	var found []string
+	var total, data int64
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		data += fi.Size()
+		if archiveKind(fi.Name()) != "" {
+			found = append(found, p)
+			total += fi.Size()
+		}
+		return nil
+	})
+	return found, total, data, err
+}
+
+// extractDir is where the archives of the task are extracted: next to them
+// without ExtractDirectory, otherwise in a directory named after the task in it
+func (e *Engine) extractDir(archive, name string) (string, error) {
+	if e.config.ExtractDirectory == "" {
+		return filepath.Dir(archive), nil
+	}
+	dir := e.config.ExtractDirectory
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(e.config.DownloadDirectory, dir)
+	}
+	dir = filepath.Join(dir, name)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// extractArchives extracts the archives in the data of a newly completed task
+// when ExtractArchives is on, in a background worker. The progress and the
+// result are on the Extract fields of the task.
+func (e *Engine) extractArchives(infohash string) {
+	if !e.config.ExtractArchives {
+		return
+	}
+	root := e.TorrentDataPath(infohash)
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	closeSync := e.closeSync
+	e.RUnlock()
+	if err != nil || root == "" {
+		return
+	}
+	archives, total, data, err := findArchives(root)
+	if err != nil {
+		log.Warn("[Extract]", infohash, err)
+		return
+	}
+	if len(archives) == 0 {
+		return
+	}
+
+	t.Lock()
+	name := t.Name
+	t.Extracting = true
+	t.ExtractPercent = 0
+	t.ExtractError = ""
+	t.Unlock()
+	release, err := e.background.acquire(t.dropWait, closeSync)
+	if err != nil {
+		t.Lock()
+		t.Extracting = false
+		t.Unlock()
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.dropWait:
+		case <-closeSync:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	log.Printf("[Extract] %s extracting %d archives", infohash, len(archives))
+	limit := newExtractLimit(data)
+	// bytes of the archives done
+	var base int64
+	setPercent := func(n int64) {
+		t.Lock()
+		t.ExtractPercent = percent(n, total)
+		t.Unlock()
+	}
+	var errs []string
+	for _, a := range archives {
+		var size, read int64
+		if fi, err := os.Stat(a); err == nil {
+			size = fi.Size()
+		}
+		progress := func(n int64) {
+			if read += n; read > size {
+				read = size
+			}
+			setPercent(base + read)
+		}
+		dir, err := e.extractDir(a, name)
+		if err == nil {
+			e.background.run(func() {
+				err = e.extractArchive(ctx, a, dir, limit, progress)
+			})
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			rel, _ := filepath.Rel(root, a)
+			log.Warnf("[Extract] %s %s: %v", infohash, a, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", rel, err))
+			if errors.Is(err, errExtractLimit) {
+				break
+			}
+		}
+		base += size
+		setPercent(base)
+	}
+
+	t.Lock()
+	t.Extracting = false
+	if ctx.Err() == nil {
+		t.ExtractPercent = 100
+		t.ExtractedAt = time.Now()
+		t.ExtractError = strings.Join(errs, "; ")
+	}
+	t.Unlock()
+	if len(errs) > 0 {
+		e.emit(EventError, t, fmt.Errorf("extract: %s", strings.Join(errs, "; ")))
+	} else if ctx.Err() == nil {
+		log.Printf("[Extract] %s done", infohash)
+	}
+}
+
+// extractArchive extracts the archive into dir, progress is told the bytes
+// of the archive read
+func (e *Engine) extractArchive(ctx context.Context, archive, dir string, limit *extractLimit, progress func(int64)) error {
+	switch archiveKind(archive) {
+	case "zip":
+		return extractZip(ctx, archive, dir, limit, progress)
+	case "tar", "tgz":
+		return extractTar(ctx, archive, dir, limit, progress)
+	default:
+		return e.extractExternal(ctx, archive, dir, limit, progress)
+	}
+}
+
+// extractPath joins the name of an entry to dir, refusing the ones escaping it
+func extractPath(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if p != filepath.Clean(dir) && !strings.HasPrefix(p, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", errExtractPath, name)
+	}
+	return p, nil
+}
+
+// writeEntry creates the file p with the content of r, the directories
+// included
+func writeEntry(p string, r io.Reader, mode os.FileMode, limit *extractLimit) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if err := limit.copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// extractZip extracts the files and the directories, the links are skipped
+func extractZip(ctx context.Context, archive, dir string, limit *extractLimit, progress func(int64)) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p, err := extractPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if err := limit.entry(int64(f.UncompressedSize64)); err != nil {
+			return err
+		}
+		switch {
+		case f.FileInfo().IsDir():
+			err = os.MkdirAll(p, 0755)
+		case f.Mode().IsRegular():
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err == nil {
+				err = writeEntry(p, rc, f.Mode(), limit)
+				rc.Close()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		progress(int64(f.CompressedSize64))
+	}
+	return nil
+}
+
+// countingReader tells progress the bytes read
+type countingReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.progress(int64(n))
+	return n, err
+}
+
+// extractTar extracts the files and the directories of a tar, gzipped or
+// not, the links are skipped
+func extractTar(ctx context.Context, archive, dir string, limit *extractLimit, progress func(int64)) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = &countingReader{f, progress}
+	if archiveKind(archive) == "tgz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := extractPath(dir, h.Name)
+		if err != nil {
+			return err
+		}
+		if err := limit.entry(h.Size); err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, 0755)
+		case tar.TypeReg:
+			err = writeEntry(p, tr, os.FileMode(h.Mode), limit)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractExternal extracts the rar and 7z archives by unrar or 7z, which
+// handle the later volumes of the split ones. They run niced to BackgroundNice.
+// The entries they list are checked against the limit first.
+func (e *Engine) extractExternal(ctx context.Context, archive, dir string, limit *extractLimit, progress func(int64)) error {
+	var cmd, list *exec.Cmd
+	if archiveKind(archive) == "rar" {
+		if bin, err := exec.LookPath("unrar"); err == nil {
+			list = exec.CommandContext(ctx, bin, "lt", "-idq", archive)
+			cmd = exec.CommandContext(ctx, bin, "x", "-o+", "-y", "-idq", archive, dir+string(filepath.Separator))
+		}
+	}
+	if cmd == nil {
+		for _, name := range []string{"7z", "7zz", "7za"} {
+			if bin, err := exec.LookPath(name); err == nil {
+				list = exec.CommandContext(ctx, bin, "l", "-slt", "-bd", archive)
+				cmd = exec.CommandContext(ctx, bin, "x", "-y", "-bd", "-o"+dir, archive)
+				break
+			}
+		}
+	}
+	if cmd == nil {
+		return errNoExtractor
+	}
+	listing, err := list.Output()
+	if err != nil {
+		return fmt.Errorf("listing: %w", err)
+	}
+	if err := checkListing(listing, limit); err != nil {
+		return err
+	}
+	out := &limitedBuffer{max: 4 << 10}
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	e.background.niceCmd(cmd.Process.Pid)
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	if fi, err := os.Stat(archive); err == nil {
+		progress(fi.Size())
+	}
+	return nil
+}
+
+// checkListing counts the entries of the technical listing of unrar (Size: n)
+// or 7z (Size = n) against the limit
+func checkListing(listing []byte, limit *extractLimit) error {
+	sc := bufio.NewScanner(bytes.NewReader(listing))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		v := strings.TrimPrefix(strings.TrimPrefix(line, "Size:"), "Size =")
+		if v == line {
+			continue
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := limit.entry(size); err != nil {
+			return err
+		}
+		limit.size -= size
+	}
+	return sc.Err()
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_archiveKind(t *testing.T) {
+	tests := map[string]string{
+		"a.zip":           "zip",
+		"a.tar.gz":        "tgz",
+		"A.7Z":            "7z",
+		"show.rar":        "rar",
+		"show.part1.rar":  "rar",
+		"show.part01.rar": "rar",
+		"show.part02.rar": "",
+		"show.r00":        "",
+		"show.mkv":        "",
+	}
+	for name, want := range tests {
+		if got := archiveKind(name); got != want {
+			t.Errorf("archiveKind(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func Test_extractZip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "a.zip")
+	writeZip(t, archive, map[string]string{"sub/b.txt": "hello"})
+	var read int64
+	if err := extractZip(context.Background(), archive, dir, newExtractLimit(0), func(n int64) { read += n }); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "sub", "b.txt")); err != nil || string(data) != "hello" {
+		t.Errorf("extracted %q, %v", data, err)
+	}
+	if read == 0 {
+		t.Error("no progress")
+	}
+
+	writeZip(t, archive, map[string]string{"../evil.txt": "x"})
+	if err := extractZip(context.Background(), archive, filepath.Join(dir, "out"), newExtractLimit(0), func(int64) {}); !errors.Is(err, errExtractPath) {
+		t.Errorf("extractZip() of an escaping entry = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+		t.Error("escaping entry written")
+	}
+}
+
+func Test_extractLimit(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "a.zip")
+	writeZip(t, archive, map[string]string{"big.txt": strings.Repeat("x", 4096)})
+	// 4096 bytes out of a task of 4 bytes
+	if err := extractZip(context.Background(), archive, filepath.Join(dir, "out"), newExtractLimit(4), func(int64) {}); !errors.Is(err, errExtractLimit) {
+		t.Errorf("extractZip() over the ratio = %v", err)
+	}
+	if err := extractZip(context.Background(), archive, filepath.Join(dir, "out"), newExtractLimit(4096), func(int64) {}); err != nil {
+		t.Errorf("extractZip() under the ratio = %v", err)
+	}
+
+	limit := &extractLimit{size: 100, max: 100, entries: 2}
+	listing := []byte("Path = a\nSize = 60\nPacked Size = 10\n\nPath = b\nSize = 60\n")
+	if err := checkListing(listing, limit); !errors.Is(err, errExtractLimit) {
+		t.Errorf("checkListing() over the size = %v", err)
+	}
+	limit = &extractLimit{size: 1000, max: 1000, entries: 2}
+	listing = []byte("Size: 1\nSize: 1\nSize: 1\n")
+	if err := checkListing(listing, limit); !errors.Is(err, errExtractLimit) {
+		t.Errorf("checkListing() over the entries = %v", err)
+	}
+}
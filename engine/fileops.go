@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errOutsideDownloads = errors.New("path outside the download directory or hidden")
+	errFileExists       = errors.New("destination already exists")
+)
+
+// downloadPath resolves p, relative to the download directory dldir, to the
+// absolute path, ok is false out of dldir or for the hidden files, like the
+// ones of the engine. dldir itself is ok.
+func downloadPath(dldir, p string) (string, bool) {
+	rel := path.Clean("/" + p)
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") {
+			return "", false
+		}
+	}
+	full := filepath.Join(dldir, filepath.FromSlash(rel))
+	if full != dldir && !strings.HasPrefix(full, dldir+string(filepath.Separator)) {
+		return "", false
+	}
+	return full, true
+}
+
+// pathsOverlap tells if either of the absolute paths holds the other
+func pathsOverlap(a, b string) bool {
+	sep := string(filepath.Separator)
+	return a == b || strings.HasPrefix(a, b+sep) || strings.HasPrefix(b, a+sep)
+}
+
+// resolveDownloadPath resolves p like downloadPath, the directories on the way
+// mustn't be symlinks out of the download directory either
+func (e *Engine) resolveDownloadPath(p string) (string, error) {
+	dldir, err := filepath.Abs(e.config.DownloadDirectory)
+	if err != nil {
+		return "", err
+	}
+	full, ok := downloadPath(dldir, p)
+	if !ok {
+		return "", errOutsideDownloads
+	}
+	if full == dldir {
+		return full, nil
+	}
+	realdir, err := filepath.EvalSymlinks(dldir)
+	if err != nil {
+		return "", err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(full))
+	if err != nil {
+		return "", err
+	}
+	if parent != realdir && !strings.HasPrefix(parent, realdir+string(filepath.Separator)) {
+		return "", errOutsideDownloads
+	}
+	return full, nil
+}
+
+// taskUsing returns the name of the task whose data overlaps the path
+func (e *Engine) taskUsing(full string) string {
+	dldir, err := filepath.Abs(e.config.DownloadDirectory)
+	if err != nil {
+		return ""
+	}
+	for _, t := range e.Torrents() {
+		t.Lock()
+		name, dir := t.Name, t.DownloadDir
+		t.Unlock()
+		if name == "" {
+			continue
+		}
+		if dir == "" {
+			dir = dldir
+		}
+		if pathsOverlap(full, filepath.Join(dir, name)) {
+			return name
+		}
+	}
+	return ""
+}
+
+// fileOpSource resolves the path of an existing file or directory, not the
+// download directory itself nor of any task
+func (e *Engine) fileOpSource(p string) (string, error) {
+	full, err := e.resolveDownloadPath(p)
+	if err != nil {
+		return "", err
+	}
+	dldir, _ := filepath.Abs(e.config.DownloadDirectory)
+	if full == dldir {
+		return "", errOutsideDownloads
+	}
+	if _, err := os.Lstat(full); err != nil {
+		return "", err
+	}
+	if name := e.taskUsing(full); name != "" {
+		return "", fmt.Errorf("%s is the data of the task %s, remove the task first", p, name)
+	}
+	return full, nil
+}
+
+// fileOpRename renames src to dst if nothing is there and no task uses it
+func (e *Engine) fileOpRename(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return errFileExists
+	}
+	if name := e.taskUsing(dst); name != "" {
+		return fmt.Errorf("%s is in the data of the task %s", dst, name)
+	}
+	log.Printf("[FileOps] rename %s to %s", src, dst)
+	return os.Rename(src, dst)
+}
+
+// RenameData renames the file or directory at p in the download directory,
+// the name stays in the same directory
+func (e *Engine) RenameData(p, name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	src, err := e.fileOpSource(p)
+	if err != nil {
+		return err
+	}
+	return e.fileOpRename(src, filepath.Join(filepath.Dir(src), name))
+}
+
+// MoveData moves the file or directory at p into dir, both relative to the
+// download directory, "" for itself
+func (e *Engine) MoveData(p, dir string) error {
+	src, err := e.fileOpSource(p)
+	if err != nil {
+		return err
+	}
+	dstDir, err := e.resolveDownloadPath(dir)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(dstDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	if dstDir == src || strings.HasPrefix(dstDir, src+string(filepath.Separator)) {
+		return fmt.Errorf("can't move %s into itself", p)
+	}
+	return e.fileOpRename(src, filepath.Join(dstDir, filepath.Base(src)))
+}
+
+// DeleteData removes the file or directory at p in the download directory
+func (e *Engine) DeleteData(p string) error {
+	full, err := e.fileOpSource(p)
+	if err != nil {
+		return err
+	}
+	log.Printf("[FileOps] delete %s", full)
+	return os.RemoveAll(full)
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"testing"
+)
+
+func Test_downloadPath(t *testing.T) {
+	tests := []struct {
+		p    string
+		want string
+		ok   bool
+	}{
+		{"a/b.mkv", "/dl/a/b.mkv", true},
+		{"/a/", "/dl/a", true},
+		{"", "/dl", true},
+		{"../etc/passwd", "/dl/etc/passwd", true},
+		{"a/../../dl2", "/dl/dl2", true},
+		{".torrent.db", "", false},
+		{"a/.cachedTorrents/b", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := downloadPath("/dl", tt.p)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("downloadPath(%q) = %q, %v, want %q, %v", tt.p, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func Test_pathsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/dl/Show", "/dl/Show", true},
+		{"/dl/Show", "/dl/Show/S01/e1.mkv", true},
+		{"/dl", "/dl/Show", true},
+		{"/dl/Show 2", "/dl/Show", false},
+		{"/dl/Sho", "/dl/Show", false},
+	}
+	for _, tt := range tests {
+		if got := pathsOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("pathsOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
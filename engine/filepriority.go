@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+// file priorities, from not downloaded to first downloaded
+const (
+	FilePrioritySkip   = "skip"
+	FilePriorityLow    = "low"
+	FilePriorityNormal = "normal"
+	FilePriorityHigh   = "high"
+)
+
+// allFiles is the key of the priority of the files not listed
+const allFiles = -1
+
+// FilePriorities are the priorities of the files of a task by index, the
+// files not listed follow the one of allFiles, or normal
+type FilePriorities map[int]string
+
+// piecePriority maps the priority to the client. The client has no priority
+// between not wanted and normal, so normal is its high and high is the
+// readahead of the streams.
+func piecePriority(p string) types.PiecePriority {
+	switch p {
+	case FilePrioritySkip:
+		return torrent.PiecePriorityNone
+	case FilePriorityLow:
+		return torrent.PiecePriorityNormal
+	case FilePriorityHigh:
+		return torrent.PiecePriorityReadahead
+	}
+	return torrent.PiecePriorityHigh
+}
+
+func validFilePriority(p string) bool {
+	switch p {
+	case FilePrioritySkip, FilePriorityLow, FilePriorityNormal, FilePriorityHigh:
+		return true
+	}
+	return false
+}
+
+// ParseFilePriorities parses "<index>:<priority>" separated by commas, the
+// index * sets the files not listed, eg: "*:skip,0:high,3:normal"
+func ParseFilePriorities(s string) (FilePriorities, error) {
+	prios := make(FilePriorities)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid file priority %q", item)
+		}
+		p := strings.ToLower(strings.TrimSpace(kv[1]))
+		if !validFilePriority(p) {
+			return nil, fmt.Errorf("invalid file priority %q, expecting skip, low, normal or high", p)
+		}
+		idx := allFiles
+		if k := strings.TrimSpace(kv[0]); k != "*" {
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid file index %q", k)
+			}
+			idx = i
+		}
+		prios[idx] = p
+	}
+	return prios, nil
+}
+
+// String formats the priorities as ParseFilePriorities takes them
+func (fp FilePriorities) String() string {
+	var items []string
+	for i, p := range fp {
+		k := "*"
+		if i != allFiles {
+			k = strconv.Itoa(i)
+		}
+		items = append(items, k+":"+p)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// of returns the priority of the file i
+func (fp FilePriorities) of(i int) string {
+	if p, ok := fp[i]; ok {
+		return p
+	}
+	if p, ok := fp[allFiles]; ok {
+		return p
+	}
+	return FilePriorityNormal
+}
+
+// SetFilePriority sets the priority of the file at path of the task
+func (e *Engine) SetFilePriority(infohash, path, priority string) error {
+	if !validFilePriority(priority) {
+		return fmt.Errorf("invalid file priority %q, expecting skip, low, normal or high", priority)
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	defer t.Unlock()
+	if err := t.setFilePriority(path, priority); err != nil {
+		return err
+	}
+	t.applyFilePriorities()
+	e.notifyChanged()
+	return nil
+}
+
+// setFilePriority must hold lock
+func (t *Torrent) setFilePriority(path, priority string) error {
+	for i, f := range t.Files {
+		if f != nil && f.Path == path {
+			if t.filePriorities == nil {
+				t.filePriorities = make(FilePriorities)
+			}
+			t.filePriorities[i] = priority
+			return nil
+		}
+	}
+	return fmt.Errorf("Missing file %s", path)
+}
+
+// applyFilePriorities sets the priorities of the files to the client, none
+// when the task is stopped. Must hold lock.
+func (t *Torrent) applyFilePriorities() {
+	if t.t == nil || t.t.Info() == nil {
+		return
+	}
+	for i, f := range t.t.Files() {
+		p := t.filePriorities.of(i)
+		if t.Started {
+			f.SetPriority(piecePriority(p))
+		} else {
+			f.SetPriority(torrent.PiecePriorityNone)
+		}
+		if i < len(t.Files) && t.Files[i] != nil {
+			t.Files[i].Priority = p
+			t.Files[i].Started = t.Started && p != FilePrioritySkip
+		}
+	}
+}
+
+// wantedDone tells whether the files not skipped are all done, must hold lock
+func (t *Torrent) wantedDone() bool {
+	if len(t.Files) == 0 {
+		return false
+	}
+	skipped := false
+	for i, f := range t.Files {
+		if f == nil {
+			return false
+		}
+		if t.filePriorities.of(i) == FilePrioritySkip {
+			skipped = true
+			continue
+		}
+		if !f.Done {
+			return false
+		}
+	}
+	return skipped
+}
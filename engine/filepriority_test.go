@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilePriorities(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    FilePriorities
+		wantErr bool
+	}{
+		{"empty", "", FilePriorities{}, false},
+		{"all", "*:skip,0:high, 3:Normal", FilePriorities{allFiles: "skip", 0: "high", 3: "normal"}, false},
+		{"last wins", "1:low,1:skip", FilePriorities{1: "skip"}, false},
+		{"bad priority", "0:urgent", nil, true},
+		{"bad index", "-1:skip", nil, true},
+		{"no priority", "0", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilePriorities(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFilePriorities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilePriorities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilePriorities_of(t *testing.T) {
+	fp := FilePriorities{allFiles: FilePrioritySkip, 1: FilePriorityHigh}
+	if p := fp.of(1); p != FilePriorityHigh {
+		t.Errorf("of(1) = %v", p)
+	}
+	if p := fp.of(2); p != FilePrioritySkip {
+		t.Errorf("of(2) = %v", p)
+	}
+	if p := FilePriorities(nil).of(0); p != FilePriorityNormal {
+		t.Errorf("nil of(0) = %v", p)
+	}
+	if s := fp.String(); s != "*:skip,1:high" {
+		t.Errorf("String() = %v", s)
+	}
+}
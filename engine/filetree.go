@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"strings"
+)
+
+// FileNode is a directory or file inside a torrent, directories sum up the
+// size and progress of their children
+type FileNode struct {
+	Name      string
+	Path      string
+	Size      int64
+	Completed int64
+	Percent   float32
+	Children  []*FileNode `json:",omitempty"`
+}
+
+func (n *FileNode) child(name string) *FileNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &FileNode{Name: name, Path: strings.TrimPrefix(n.Path+"/"+name, "/")}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *FileNode) updatePercent() {
+	n.Percent = percent(n.Completed, n.Size)
+	for _, c := range n.Children {
+		c.updatePercent()
+	}
+}
+
+// TorrentFileTree returns the files of the torrent as a tree
+func (e *Engine) TorrentFileTree(infohash string) (*FileNode, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	root := &FileNode{Name: t.Name}
+	for _, f := range t.Files {
+		if f == nil {
+			continue
+		}
+		node := root
+		for _, name := range strings.Split(f.Path, "/") {
+			node = node.child(name)
+			node.Size += f.Size
+			node.Completed += f.Completed
+		}
+		root.Size += f.Size
+		root.Completed += f.Completed
+	}
+	root.updatePercent()
+	return root, nil
+}
+
+// SearchTorrentFiles finds the files whose path contains all the words of query, case insensitive
+func (e *Engine) SearchTorrentFiles(infohash, query string) ([]*File, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	words := strings.Fields(strings.ToLower(query))
+	t.Lock()
+	defer t.Unlock()
+	found := []*File{}
+	for _, f := range t.Files {
+		if f == nil {
+			continue
+		}
+		lpath := strings.ToLower(f.Path)
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(lpath, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found = append(found, f)
+		}
+	}
+	return found, nil
+}
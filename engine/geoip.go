@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoDB is a database of GeoIPDatabase or ASNDatabase loaded on first use,
+// reloaded when the path changes
+type geoDB struct {
+	sync.Mutex
+	path   string
+	reader *maxminddb.Reader
+	err    error
+}
+
+// open returns the reader of the database at path, nil if it fails to load
+func (g *geoDB) open(path string) *maxminddb.Reader {
+	g.Lock()
+	defer g.Unlock()
+	if g.path != path {
+		g.path = path
+		g.reader = nil
+		// read into memory, the file may be replaced by updaters
+		var data []byte
+		if data, g.err = ioutil.ReadFile(path); g.err == nil {
+			g.reader, g.err = maxminddb.FromBytes(data)
+		}
+		if g.err != nil {
+			log.Warn("[GeoIP]", g.err)
+		}
+	}
+	return g.reader
+}
+
+// geoCountry returns the ISO country code of ip, empty if unknown or no database
+func (e *Engine) geoCountry(ip net.IP) string {
+	e.RLock()
+	path := e.config.GeoIPDatabase
+	e.RUnlock()
+	if path == "" || ip == nil {
+		return ""
+	}
+	reader := e.geo.open(path)
+	if reader == nil {
+		return ""
+	}
+
+	// works with both the Country and City databases
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := reader.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+	return rec.Country.ISOCode
+}
+
+// geoASN returns the autonomous system of ip and its organization, 0 if
+// unknown or no database
+func (e *Engine) geoASN(ip net.IP) (uint, string) {
+	e.RLock()
+	path := e.config.ASNDatabase
+	e.RUnlock()
+	if path == "" || ip == nil {
+		return 0, ""
+	}
+	reader := e.asn.open(path)
+	if reader == nil {
+		return 0, ""
+	}
+
+	// the MaxMind GeoLite2 ASN and DB-IP ASN Lite layout
+	var rec struct {
+		Number uint   `maxminddb:"autonomous_system_number"`
+		Org    string `maxminddb:"autonomous_system_organization"`
+	}
+	if err := reader.Lookup(ip, &rec); err != nil {
+		return 0, ""
+	}
+	return rec.Number, rec.Org
+}
+
+// peerGeo is where a peer is, resolved once on its handshake
+type peerGeo struct {
+	country string
+	asn     uint
+	org     string
+}
+
+// lookupPeerGeo resolves the country and the ASN of the host:port
+func (e *Engine) lookupPeerGeo(addr string) peerGeo {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return peerGeo{}
+	}
+	ip := net.ParseIP(host)
+	g := peerGeo{country: e.geoCountry(ip)}
+	g.asn, g.org = e.geoASN(ip)
+	return g
+}
+
+// GeoTraffic is the traffic of the peers of a country or an ASN, empty or 0
+// for the peers not in the databases
+type GeoTraffic struct {
+	Country string `json:",omitempty"`
+	ASN     uint   `json:",omitempty"`
+	Org     string `json:",omitempty"`
+	// connected now
+	Peers      int
+	Downloaded int64
+	Uploaded   int64
+}
+
+// PeerGeoStats is the traffic of the peers by country and by ASN, the most first
+type PeerGeoStats struct {
+	Countries []GeoTraffic
+	ASNs      []GeoTraffic
+}
+
+// geoTraffic is the traffic of the closed connections
+type geoTraffic struct {
+	downloaded, uploaded int64
+}
+
+// PeerGeoStats returns the traffic of the peers of the task, or of all the
+// tasks with infohash empty, since the engine is started
+func (e *Engine) PeerGeoStats(infohash string) PeerGeoStats {
+	return e.peerCounters.geoStats(infohash)
+}
+
+func (pm *peerCounterMap) geoStats(ih string) PeerGeoStats {
+	countries := make(map[string]*GeoTraffic)
+	asns := make(map[uint]*GeoTraffic)
+	count := func(g peerGeo, down, up int64, peers int) {
+		c, ok := countries[g.country]
+		if !ok {
+			c = &GeoTraffic{Country: g.country}
+			countries[g.country] = c
+		}
+		a, ok := asns[g.asn]
+		if !ok {
+			a = &GeoTraffic{ASN: g.asn, Org: g.org}
+			asns[g.asn] = a
+		}
+		for _, t := range []*GeoTraffic{c, a} {
+			t.Peers += peers
+			t.Downloaded += down
+			t.Uploaded += up
+		}
+	}
+
+	pm.Lock()
+	closed := pm.closedGlobal
+	if ih != "" {
+		closed = pm.closed[ih]
+	}
+	for g, t := range closed {
+		count(g, t.downloaded, t.uploaded, 0)
+	}
+	for _, c := range pm.m {
+		if c.ih != "" && (ih == "" || c.ih == ih) {
+			count(c.geo, c.downloaded, c.uploaded, 1)
+		}
+	}
+	pm.Unlock()
+
+	s := PeerGeoStats{Countries: []GeoTraffic{}, ASNs: []GeoTraffic{}}
+	for _, c := range countries {
+		s.Countries = append(s.Countries, *c)
+	}
+	for _, a := range asns {
+		s.ASNs = append(s.ASNs, *a)
+	}
+	for _, l := range [][]GeoTraffic{s.Countries, s.ASNs} {
+		sort.Slice(l, func(i, j int) bool {
+			return l[i].Downloaded+l[i].Uploaded > l[j].Downloaded+l[j].Uploaded
+		})
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestGeoStats(t *testing.T) {
+	var pm peerCounterMap
+	a, b, c := &torrent.PeerConn{}, &torrent.PeerConn{}, &torrent.PeerConn{}
+	de := peerGeo{country: "DE", asn: 3320, org: "Deutsche Telekom AG"}
+	pm.attach(a, "aa", de)
+	pm.attach(b, "aa", peerGeo{country: "US", asn: 7922, org: "Comcast"})
+	pm.attach(c, "bb", de)
+	pm.add(a, 100, 10)
+	pm.add(b, 50, 0)
+	pm.add(c, 0, 30)
+	pm.remove(a)
+
+	s := pm.geoStats("aa")
+	if len(s.Countries) != 2 || s.Countries[0].Country != "DE" || s.Countries[0].Downloaded != 100 ||
+		s.Countries[0].Peers != 0 || s.Countries[1].Peers != 1 {
+		t.Errorf("geoStats(aa) countries = %+v", s.Countries)
+	}
+	s = pm.geoStats("")
+	if len(s.ASNs) != 2 || s.ASNs[0].ASN != 3320 || s.ASNs[0].Uploaded != 40 || s.ASNs[0].Peers != 1 {
+		t.Errorf("geoStats() ASNs = %+v", s.ASNs)
+	}
+
+	pm.dropTask("aa")
+	if s := pm.geoStats("aa"); len(s.Countries) != 1 || s.Countries[0].Country != "US" {
+		t.Errorf("geoStats(aa) after drop = %+v", s.Countries)
+	}
+	if s := pm.geoStats(""); s.Countries[0].Downloaded != 100 {
+		t.Errorf("geoStats() after drop = %+v", s.Countries)
+	}
+}
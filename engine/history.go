@@ -0,0 +1,376 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// historyFile keeps the speed samples and the lifetime totals across restarts
+	historyFile         = ".history.json"
+	historyInterval     = time.Minute
+	historySaveInterval = 5 * time.Minute
+	// a week of the global speeds, a day of each task
+	historyGlobalLen = 7 * 24 * 60
+	historyTaskLen   = 24 * 60
+	// the samples are averaged down to fit the points of a range
+	historyMaxPoints = 360
+)
+
+var errHistoryRange = errors.New("Invalid range, eg: 1h, 24h or 7d")
+
+// speedSample is the average speed in bytes/s of the interval ending at T
+type speedSample struct {
+	T    int64   `json:"t"`
+	Down float64 `json:"d"`
+	Up   float64 `json:"u"`
+}
+
+// speedRing is a ring buffer of the latest max samples
+type speedRing struct {
+	buf   []speedSample
+	start int
+	max   int
+}
+
+func newSpeedRing(max int, samples []speedSample) *speedRing {
+	r := &speedRing{max: max}
+	for _, s := range samples {
+		r.add(s)
+	}
+	return r
+}
+
+func (r *speedRing) add(s speedSample) {
+	if len(r.buf) < r.max {
+		r.buf = append(r.buf, s)
+		return
+	}
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % r.max
+}
+
+// samples returns a copy, oldest first
+func (r *speedRing) samples() []speedSample {
+	ret := make([]speedSample, 0, len(r.buf))
+	ret = append(ret, r.buf[r.start:]...)
+	return append(ret, r.buf[:r.start]...)
+}
+
+// byteCounters are the bytes transferred as counted by the client
+type byteCounters struct {
+	down, up int64
+}
+
+// sub is the bytes since last, counting from zero when the counters reset
+// with a new client or the task loaded again
+func (c byteCounters) sub(last byteCounters) byteCounters {
+	d := byteCounters{c.down - last.down, c.up - last.up}
+	if d.down < 0 || d.up < 0 {
+		return c
+	}
+	return d
+}
+
+type historyState struct {
+	sync.Mutex
+	global *speedRing
+	tasks  map[string]*speedRing
+	// counters of the last sample
+	lastAt     time.Time
+	lastGlobal byteCounters
+	lastTasks  map[string]byteCounters
+	// bytes of all the sessions, the removed tasks included
+	downloaded, uploaded int64
+}
+
+type historyData struct {
+	Downloaded int64                    `json:"downloaded"`
+	Uploaded   int64                    `json:"uploaded"`
+	Global     []speedSample            `json:"global"`
+	Tasks      map[string][]speedSample `json:"tasks"`
+}
+
+// SpeedPoint is the average speed in bytes/s of the Step ending at Time
+type SpeedPoint struct {
+	Time     time.Time
+	Download float64
+	Upload   float64
+}
+
+// SpeedHistory is the speeds of a range, the lifetime totals and ratio
+type SpeedHistory struct {
+	// seconds each point averages
+	Step       int64
+	Points     []SpeedPoint
+	Downloaded int64
+	Uploaded   int64
+	Ratio      float64
+}
+
+// loadHistory reads the samples saved by the last run, called by the first
+// Configure. The counters of a new client start over, see byteCounters.sub
+func (e *Engine) loadHistory() {
+	h := &e.history
+	h.Lock()
+	defer h.Unlock()
+	var d historyData
+	if data, err := ioutil.ReadFile(filepath.Join(e.cacheDir, historyFile)); err == nil {
+		if err := json.Unmarshal(data, &d); err != nil {
+			log.Warn("[History] load", err)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Warn("[History] load", err)
+	}
+	h.global = newSpeedRing(historyGlobalLen, d.Global)
+	h.tasks = make(map[string]*speedRing)
+	for ih, samples := range d.Tasks {
+		h.tasks[ih] = newSpeedRing(historyTaskLen, samples)
+	}
+	h.downloaded, h.uploaded = d.Downloaded, d.Uploaded
+	h.lastTasks = make(map[string]byteCounters)
+}
+
+func (e *Engine) saveHistory() {
+	h := &e.history
+	h.Lock()
+	if h.global == nil {
+		h.Unlock()
+		return
+	}
+	d := historyData{
+		Downloaded: h.downloaded,
+		Uploaded:   h.uploaded,
+		Global:     h.global.samples(),
+		Tasks:      make(map[string][]speedSample, len(h.tasks)),
+	}
+	for ih, r := range h.tasks {
+		d.Tasks[ih] = r.samples()
+	}
+	h.Unlock()
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		log.Warn("[History] save", err)
+		return
+	}
+	tmp := filepath.Join(e.cacheDir, historyFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		log.Warn("[History] save", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(e.cacheDir, historyFile)); err != nil {
+		log.Warn("[History] save", err)
+	}
+}
+
+// historyRoutine samples the speeds every historyInterval, saved every
+// historySaveInterval and when the client is closed
+func (e *Engine) historyRoutine(closeSync chan struct{}) {
+	sample := time.NewTicker(historyInterval)
+	save := time.NewTicker(historySaveInterval)
+	defer sample.Stop()
+	defer save.Stop()
+	e.sampleHistory(time.Now())
+	for {
+		select {
+		case now := <-sample.C:
+			e.sampleHistory(now)
+		case <-save.C:
+			e.saveHistory()
+		case <-closeSync:
+			e.saveHistory()
+			return
+		}
+	}
+}
+
+// sampleHistory adds the speeds since the last sample
+func (e *Engine) sampleHistory(now time.Time) {
+	e.RLock()
+	var global byteCounters
+	if e.client != nil {
+		cs := e.client.ConnStats()
+		global = byteCounters{cs.BytesReadUsefulData.Int64(), cs.BytesWrittenData.Int64()}
+	}
+	tasks := make(map[string]byteCounters, len(e.ts))
+	for ih, t := range e.ts {
+		t.Lock()
+		if t.t != nil {
+			st := t.t.Stats()
+			tasks[ih] = byteCounters{st.BytesReadUsefulData.Int64(), st.BytesWrittenData.Int64()}
+		}
+		t.Unlock()
+	}
+	e.RUnlock()
+
+	h := &e.history
+	h.Lock()
+	defer h.Unlock()
+	if h.global == nil {
+		return
+	}
+	secs := now.Sub(h.lastAt).Seconds()
+	first := h.lastAt.IsZero()
+	h.lastAt = now
+	speed := func(d byteCounters) speedSample {
+		return speedSample{T: now.Unix(), Down: float64(d.down) / secs, Up: float64(d.up) / secs}
+	}
+
+	d := global.sub(h.lastGlobal)
+	h.lastGlobal = global
+	if !first {
+		h.downloaded += d.down
+		h.uploaded += d.up
+		h.global.add(speed(d))
+	}
+	for ih, c := range tasks {
+		last, ok := h.lastTasks[ih]
+		h.lastTasks[ih] = c
+		if first || !ok {
+			continue
+		}
+		r, ok := h.tasks[ih]
+		if !ok {
+			r = newSpeedRing(historyTaskLen, nil)
+			h.tasks[ih] = r
+		}
+		r.add(speed(c.sub(last)))
+	}
+	for ih := range h.lastTasks {
+		if _, ok := tasks[ih]; !ok {
+			delete(h.lastTasks, ih)
+		}
+	}
+}
+
+// removeHistory drops the samples of a deleted task
+func (e *Engine) removeHistory(ih string) {
+	h := &e.history
+	h.Lock()
+	defer h.Unlock()
+	delete(h.tasks, ih)
+	delete(h.lastTasks, ih)
+}
+
+// ParseHistoryRange parses a duration, days included like 7d
+func ParseHistoryRange(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 24 * time.Hour, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n <= 0 {
+			return 0, errHistoryRange
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errHistoryRange
+	}
+	return d, nil
+}
+
+// downsample averages the samples after since into points of step, the
+// steps without samples are left out
+func downsample(samples []speedSample, since time.Time, step time.Duration) []SpeedPoint {
+	secs := int64(step / time.Second)
+	if secs <= 0 {
+		secs = 1
+	}
+	points := []SpeedPoint{}
+	var cur SpeedPoint
+	var end int64
+	n := 0
+	flush := func() {
+		if n > 0 {
+			cur.Download /= float64(n)
+			cur.Upload /= float64(n)
+			cur.Time = time.Unix(end, 0)
+			points = append(points, cur)
+		}
+		cur, n = SpeedPoint{}, 0
+	}
+	for _, s := range samples {
+		if s.T <= since.Unix() {
+			continue
+		}
+		// the step of the sample, aligned to the clock
+		stepEnd := (s.T + secs - 1) / secs * secs
+		if stepEnd != end {
+			flush()
+			end = stepEnd
+		}
+		cur.Download += s.Down
+		cur.Upload += s.Up
+		n++
+	}
+	flush()
+	return points
+}
+
+// historyStep is the step fitting the range in historyMaxPoints
+func historyStep(r time.Duration) time.Duration {
+	step := historyInterval
+	if n := r / historyMaxPoints; n > step {
+		step = (n + historyInterval - 1) / historyInterval * historyInterval
+	}
+	return step
+}
+
+// SpeedHistory returns the global speeds of the last range with the bytes
+// transferred since the history was first kept
+func (e *Engine) SpeedHistory(r time.Duration) SpeedHistory {
+	step := historyStep(r)
+	h := &e.history
+	h.Lock()
+	defer h.Unlock()
+	sh := SpeedHistory{Step: int64(step / time.Second), Downloaded: h.downloaded, Uploaded: h.uploaded,
+		Points: []SpeedPoint{}}
+	if h.global != nil {
+		sh.Points = downsample(h.global.samples(), time.Now().Add(-r), step)
+	}
+	if sh.Downloaded > 0 {
+		sh.Ratio = float64(sh.Uploaded) / float64(sh.Downloaded)
+	}
+	return sh
+}
+
+// TorrentSpeedHistory returns the speeds of the task with its bytes of all sessions
+func (e *Engine) TorrentSpeedHistory(infohash string, r time.Duration) (SpeedHistory, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return SpeedHistory{}, err
+	}
+	step := historyStep(r)
+	sh := SpeedHistory{Step: int64(step / time.Second)}
+	t.Lock()
+	sh.Uploaded = t.Uploaded
+	sh.Ratio = float64(t.SeedRatio)
+	if t.Stats != nil {
+		sh.Downloaded = t.Stats.BytesReadUsefulData.Int64()
+	}
+	sh.Downloaded += t.prevDownloaded
+	t.Unlock()
+
+	h := &e.history
+	h.Lock()
+	defer h.Unlock()
+	if ring, ok := h.tasks[infohash]; ok {
+		sh.Points = downsample(ring.samples(), time.Now().Add(-r), step)
+	} else {
+		sh.Points = []SpeedPoint{}
+	}
+	return sh, nil
+}
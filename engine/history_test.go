@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_speedRing(t *testing.T) {
+	r := newSpeedRing(3, nil)
+	for i := int64(1); i <= 5; i++ {
+		r.add(speedSample{T: i})
+	}
+	var got []int64
+	for _, s := range r.samples() {
+		got = append(got, s.T)
+	}
+	if want := []int64{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("samples() = %v, want %v", got, want)
+	}
+}
+
+func Test_byteCounters_sub(t *testing.T) {
+	if got := (byteCounters{10, 20}).sub(byteCounters{4, 5}); got != (byteCounters{6, 15}) {
+		t.Errorf("sub() = %v", got)
+	}
+	// a new client counts from zero
+	if got := (byteCounters{3, 30}).sub(byteCounters{4, 5}); got != (byteCounters{3, 30}) {
+		t.Errorf("sub() after reset = %v", got)
+	}
+}
+
+func Test_downsample(t *testing.T) {
+	samples := []speedSample{
+		{T: 60, Down: 10, Up: 1},
+		{T: 120, Down: 20, Up: 2},
+		{T: 180, Down: 30, Up: 3},
+		{T: 240, Down: 40, Up: 4},
+		// a gap while stopped
+		{T: 600, Down: 50, Up: 5},
+	}
+	got := downsample(samples, time.Unix(60, 0), 2*time.Minute)
+	want := []SpeedPoint{
+		{Time: time.Unix(120, 0), Download: 20, Upload: 2},
+		{Time: time.Unix(240, 0), Download: 35, Upload: 3.5},
+		{Time: time.Unix(600, 0), Download: 50, Upload: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("downsample() = %v, want %v", got, want)
+	}
+}
+
+func TestParseHistoryRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{"", 24 * time.Hour, false},
+		{"6h", 6 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"0d", 0, true},
+		{"-1h", 0, true},
+		{"week", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHistoryRange(tt.in)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("ParseHistoryRange(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+	if got := historyStep(7 * 24 * time.Hour); got != 28*time.Minute {
+		t.Errorf("historyStep(7d) = %v", got)
+	}
+	if got := historyStep(time.Hour); got != historyInterval {
+		t.Errorf("historyStep(1h) = %v", got)
+	}
+}
@@ -0,0 +1,451 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// the states of a HookRun
+const (
+	HookQueued   = "queued"
+	HookRunning  = "running"
+	HookRetrying = "retrying"
+	HookOK       = "ok"
+	HookFailed   = "failed"
+)
+
+const (
+	defaultHookTimeout = 30 * time.Minute
+	// the first retry waits this long, doubled for each next one
+	hookRetryDelay = 30 * time.Second
+	// runs kept for the API, the finished ones are dropped first
+	hookRunsMax = 100
+	// output kept of a run, the tail is cut
+	hookOutputMax = 64 << 10
+)
+
+// hookRule is a line of Hooks, eg:
+//  completed,stopped => /opt/post.sh {{.Path}} "{{.Label}}"
+// the events are comma separated, * for all the lifecycle events
+type hookRule struct {
+	line   string
+	events map[string]bool
+	args   []*template.Template
+}
+
+// hookData is expanded in the arguments of the hooks
+type hookData struct {
+	Event    string
+	InfoHash string
+	Name     string
+	Path     string
+	Dir      string
+	Label    string
+	Size     int64
+	Error    string
+}
+
+// HookRun is a run of a hook, DoneCmd included, with its captured output
+type HookRun struct {
+	ID         uint64
+	Hook       string
+	Event      string
+	InfoHash   string
+	Name       string
+	Command    []string
+	Status     string
+	Attempts   int
+	ExitCode   int
+	Error      string `json:",omitempty"`
+	Output     string
+	QueuedAt   time.Time
+	StartedAt  time.Time `json:",omitempty"`
+	FinishedAt time.Time `json:",omitempty"`
+	NextRetry  time.Time `json:",omitempty"`
+}
+
+type hookJob struct {
+	run *HookRun
+	env []string
+	// called with the final error, nil once succeeded
+	done func(error)
+}
+
+// hookQueue runs the jobs one at a time in order, a failed job is queued
+// again after its backoff
+type hookQueue struct {
+	sync.Mutex
+	pending []*hookJob
+	runs    []*HookRun
+	lastID  uint64
+	wake    chan struct{}
+}
+
+func parseHooks(s string) ([]hookRule, error) {
+	var rules []hookRule
+	for _, line := range common.SplitLines(s) {
+		parts := strings.SplitN(line, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid hook %q, expecting <events> => <command>", line)
+		}
+		r := hookRule{line: line, events: make(map[string]bool)}
+		for _, ev := range strings.Split(parts[0], ",") {
+			ev = strings.TrimSpace(ev)
+			if ev == "*" {
+				for _, typ := range lifecycleEvents {
+					r.events[typ] = true
+				}
+				continue
+			}
+			if !isLifecycleEvent(ev) {
+				return nil, fmt.Errorf("hook %q: unknown event %q", line, ev)
+			}
+			r.events[ev] = true
+		}
+		args, err := splitCommandLine(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("hook %q: %w", line, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("hook %q: no command", line)
+		}
+		for _, a := range args {
+			tpl, err := template.New("").Option("missingkey=error").Parse(a)
+			if err != nil {
+				return nil, fmt.Errorf("hook %q: %w", line, err)
+			}
+			r.args = append(r.args, tpl)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func isLifecycleEvent(typ string) bool {
+	for _, t := range lifecycleEvents {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCommandLine splits s by the spaces out of the single or double quotes,
+// a backslash escapes the next character out of the single quotes
+func splitCommandLine(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	var quote rune
+	inArg, escaped := false, false
+	for _, c := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// expand executes the arguments of the rule with d
+func (r *hookRule) expand(d hookData) ([]string, error) {
+	args := make([]string, 0, len(r.args))
+	for _, tpl := range r.args {
+		var b strings.Builder
+		if err := tpl.Execute(&b, d); err != nil {
+			return nil, err
+		}
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+// hookEventRoutine queues the Hooks bound to the lifecycle events
+func (e *Engine) hookEventRoutine(events <-chan Event) {
+	for ev := range events {
+		c := e.Config()
+		if c.Hooks == "" {
+			continue
+		}
+		rules, err := parseHooks(c.Hooks)
+		if err != nil {
+			log.Warn("[Hooks]", err)
+			continue
+		}
+		var d *hookData
+		for i, r := range rules {
+			if !r.events[ev.Type] {
+				continue
+			}
+			if d == nil {
+				d = e.hookData(ev)
+			}
+			hook := fmt.Sprintf("Hooks#%d", i+1)
+			args, err := r.expand(*d)
+			if err != nil {
+				log.Warnf("[Hooks] %s %s: %v", hook, ev.InfoHash, err)
+				e.recordHook(ev.InfoHash, ev.Name, hook+" "+ev.Type, err)
+				continue
+			}
+			env := append(doneCmdEnv(os.Environ(), c.DoneCmdEnv),
+				"CLD_EVENT="+ev.Type, "CLD_HASH="+ev.InfoHash, "CLD_PATH="+d.Path)
+			e.queueHook(hook, ev, args, env, func(err error) {
+				e.recordHook(ev.InfoHash, ev.Name, hook+" "+ev.Type, err)
+			})
+		}
+	}
+}
+
+// hookData fills the template data of the event with the task, if it's
+// still there
+func (e *Engine) hookData(ev Event) *hookData {
+	d := &hookData{
+		Event:    ev.Type,
+		InfoHash: ev.InfoHash,
+		Name:     ev.Name,
+		Size:     ev.Size,
+		Error:    ev.Error,
+		Dir:      e.config.DownloadDirectory,
+	}
+	e.RLock()
+	t, err := e.getTorrent(ev.InfoHash)
+	e.RUnlock()
+	if err != nil {
+		return d
+	}
+	t.Lock()
+	d.Label = t.Label
+	if t.DownloadDir != "" {
+		d.Dir = t.DownloadDir
+	}
+	if d.Name == "" {
+		d.Name = t.Name
+	}
+	t.Unlock()
+	if d.Path = e.TorrentDataPath(ev.InfoHash); d.Path == "" && d.Name != "" {
+		d.Path = filepath.Join(d.Dir, d.Name)
+	}
+	return d
+}
+
+// queueHook adds a run of args to the queue, done is called once it
+// succeeded or failed the last retry
+func (e *Engine) queueHook(hook string, ev Event, args, env []string, done func(error)) {
+	q := &e.hooks
+	q.Lock()
+	defer q.Unlock()
+	q.lastID++
+	run := &HookRun{
+		ID:       q.lastID,
+		Hook:     hook,
+		Event:    ev.Type,
+		InfoHash: ev.InfoHash,
+		Name:     ev.Name,
+		Command:  args,
+		Status:   HookQueued,
+		QueuedAt: time.Now(),
+	}
+	q.runs = append(q.runs, run)
+	q.trim()
+	q.push(&hookJob{run: run, env: env, done: done})
+}
+
+// push must hold the lock
+func (q *hookQueue) push(j *hookJob) {
+	q.pending = append(q.pending, j)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// trim drops the oldest finished runs beyond hookRunsMax, must hold the lock
+func (q *hookQueue) trim() {
+	for i := 0; len(q.runs) > hookRunsMax && i < len(q.runs); {
+		if s := q.runs[i].Status; s == HookOK || s == HookFailed {
+			q.runs = append(q.runs[:i], q.runs[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+func (q *hookQueue) pop() *hookJob {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.pending) == 0 {
+		return nil
+	}
+	j := q.pending[0]
+	q.pending = q.pending[1:]
+	return j
+}
+
+// hookRoutine runs the queued hooks one at a time
+func (e *Engine) hookRoutine() {
+	for range e.hooks.wake {
+		for j := e.hooks.pop(); j != nil; j = e.hooks.pop() {
+			e.runHookJob(j)
+		}
+	}
+}
+
+func (e *Engine) runHookJob(j *hookJob) {
+	c := e.Config()
+	q := &e.hooks
+	q.Lock()
+	j.run.Status = HookRunning
+	j.run.Attempts++
+	j.run.StartedAt = time.Now()
+	j.run.NextRetry = time.Time{}
+	args := j.run.Command
+	q.Unlock()
+
+	out, code, err := runHookCmd(&c, args, j.env)
+	if err != nil {
+		log.Warnf("[Hooks] %s %s attempt %d: %v", j.run.Hook, j.run.InfoHash, j.run.Attempts, err)
+	} else {
+		log.Printf("[Hooks] %s %s done", j.run.Hook, j.run.InfoHash)
+	}
+
+	q.Lock()
+	r := j.run
+	r.FinishedAt = time.Now()
+	r.Output = out
+	r.ExitCode = code
+	r.Error = ""
+	switch {
+	case err == nil:
+		r.Status = HookOK
+	case r.Attempts <= c.HookRetries:
+		r.Status = HookRetrying
+		r.Error = err.Error()
+		delay := hookRetryDelay << (r.Attempts - 1)
+		r.NextRetry = time.Now().Add(delay)
+		time.AfterFunc(delay, func() {
+			q.Lock()
+			defer q.Unlock()
+			q.push(j)
+		})
+	default:
+		r.Status = HookFailed
+		r.Error = err.Error()
+	}
+	status := r.Status
+	q.Unlock()
+
+	if status == HookRetrying {
+		return
+	}
+	if j.done != nil {
+		j.done(err)
+	}
+}
+
+// runHookCmd runs args within HookTimeout as DoneCmdUser in DoneCmdDir,
+// niced as DoneCmd. The stdout and stderr are returned interleaved.
+func runHookCmd(c *Config, args, env []string) (string, int, error) {
+	timeout := c.HookTimeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if err := prepareDoneCmd(cmd, c); err != nil {
+		return "", -1, err
+	}
+	cmd.Env = env
+	out := &limitedBuffer{max: hookOutputMax}
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		return "", -1, err
+	}
+	lowerCmdPriority(cmd.Process.Pid, c)
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return out.String(), cmd.ProcessState.ExitCode(), err
+}
+
+// limitedBuffer keeps the first max bytes written
+type limitedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+	max int
+	cut bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	if n := b.max - b.Buffer.Len(); n < len(p) {
+		if n > 0 {
+			b.Buffer.Write(p[:n])
+		}
+		b.cut = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	if b.cut {
+		return b.Buffer.String() + "\n[output cut]"
+	}
+	return b.Buffer.String()
+}
+
+// HookRuns returns the latest runs of the hooks and DoneCmd, oldest first,
+// only the ones of the task unless infohash is empty
+func (e *Engine) HookRuns(infohash string) []HookRun {
+	q := &e.hooks
+	q.Lock()
+	defer q.Unlock()
+	runs := []HookRun{}
+	for _, r := range q.runs {
+		if infohash == "" || r.InfoHash == infohash {
+			runs = append(runs, *r)
+		}
+	}
+	return runs
+}
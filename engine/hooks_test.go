@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_splitCommandLine(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"/bin/post.sh a  b", []string{"/bin/post.sh", "a", "b"}, false},
+		{`cp "{{.Path}}" '/mnt/my dir'`, []string{"cp", "{{.Path}}", "/mnt/my dir"}, false},
+		{`echo a\ b "" 'it\'`, []string{"echo", "a b", "", `it\`}, false},
+		{`echo "open`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitCommandLine(tt.in)
+		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.err {
+			t.Errorf("splitCommandLine(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func Test_parseHooks(t *testing.T) {
+	rules, err := parseHooks(`
+# post processing
+completed, stopped => /bin/post.sh {{.Path}} "{{.Label}}"
+* => /bin/log {{.Event}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules", len(rules))
+	}
+	if !rules[0].events[EventStopped] || rules[0].events[EventAdded] {
+		t.Errorf("events = %v", rules[0].events)
+	}
+	if !rules[1].events[EventAlert] {
+		t.Errorf("* events = %v", rules[1].events)
+	}
+	args, err := rules[0].expand(hookData{Path: "/dl/a b", Label: "tv shows"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/post.sh", "/dl/a b", "tv shows"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("expand() = %q, want %q", args, want)
+	}
+
+	for _, s := range []string{
+		"/bin/post.sh",
+		"finished => /bin/post.sh",
+		"completed =>  ",
+		"completed => /bin/post.sh {{.Path",
+	} {
+		if _, err := parseHooks(s); err == nil {
+			t.Errorf("parseHooks(%q) succeeded", s)
+		}
+	}
+	// checked when expanded
+	rules, _ = parseHooks("completed => echo {{.Nope}}")
+	if _, err := rules[0].expand(hookData{}); err == nil {
+		t.Error("expand() of unknown field succeeded")
+	}
+}
+
+func Test_limitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 5}
+	b.Write([]byte("abc"))
+	if n, err := b.Write([]byte("defg")); n != 4 || err != nil {
+		t.Errorf("Write() = %d, %v", n, err)
+	}
+	if got := b.String(); !strings.HasPrefix(got, "abcde\n") {
+		t.Errorf("String() = %q", got)
+	}
+}
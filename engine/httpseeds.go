@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	webseedTick = 10 * time.Second
+	// the chunks requested from the web seeds, the default of anacrolix/torrent
+	webseedChunkSize = 16 << 10
+)
+
+var errNoWebSeeds = errors.New("no web seeds given")
+
+// WebSeedStatus is a BEP 19 web seed (url-list) of a task with its transfer.
+// The client doesn't support the BEP 17 HTTP seeds (httpseeds).
+type WebSeedStatus struct {
+	URL string
+	// requested by the client now, false if it gave up on it
+	Active bool
+	// about, in chunks of 16KB
+	Downloaded   int64
+	DownloadRate float32
+}
+
+// the web seeds are peers of the client, their counts are only shown by its
+// status: " 3. https://host/path/" followed by the lines of the peer
+var (
+	webseedPeerRe   = regexp.MustCompile(`^\s*\d+\. (https?://\S+)$`)
+	webseedChunksRe = regexp.MustCompile(`good chunks: (\d+)/\d+:\d+ .*, dr: ([\d.]+) KiB/s$`)
+)
+
+// parseWebSeedStatus reads the web seeds of the tasks from the status of the
+// client, by infohash and url
+func parseWebSeedStatus(r io.Reader) map[string]map[string]WebSeedStatus {
+	m := make(map[string]map[string]WebSeedStatus)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var ih, seed string
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "Infohash: ") {
+			ih, seed = strings.TrimPrefix(line, "Infohash: "), ""
+			continue
+		}
+		if ih == "" {
+			continue
+		}
+		if sm := webseedPeerRe.FindStringSubmatch(line); sm != nil {
+			seed = sm[1]
+			if m[ih] == nil {
+				m[ih] = make(map[string]WebSeedStatus)
+			}
+			m[ih][seed] = WebSeedStatus{URL: seed, Active: true}
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			seed = ""
+		}
+		if seed == "" {
+			continue
+		}
+		if sm := webseedChunksRe.FindStringSubmatch(line); sm != nil {
+			ws := m[ih][seed]
+			chunks, _ := strconv.ParseInt(sm[1], 10, 64)
+			rate, _ := strconv.ParseFloat(sm[2], 32)
+			ws.Downloaded = chunks * webseedChunkSize
+			ws.DownloadRate = float32(rate * 1024)
+			m[ih][seed] = ws
+			seed = ""
+		}
+	}
+	return m
+}
+
+// webseedRoutine updates the web seeds of the tasks
+func (e *Engine) webseedRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(webseedTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			e.updateWebSeeds()
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+// updateWebSeeds sets the web seeds of the tasks having any, the status of
+// the client is only read for them
+func (e *Engine) updateWebSeeds() {
+	type seeded struct {
+		t    *Torrent
+		urls []string
+	}
+	var tasks []seeded
+	for _, t := range e.Torrents() {
+		t.Lock()
+		tt := t.t
+		t.Unlock()
+		if tt == nil {
+			continue
+		}
+		if mi := tt.Metainfo(); len(mi.UrlList) > 0 {
+			tasks = append(tasks, seeded{t, mi.UrlList})
+		}
+	}
+	if len(tasks) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	e.RLock()
+	if e.client != nil {
+		e.client.WriteStatus(&buf)
+	}
+	e.RUnlock()
+	status := parseWebSeedStatus(&buf)
+	for _, s := range tasks {
+		seeds := webSeedList(s.urls, status[s.t.InfoHash])
+		s.t.Lock()
+		s.t.WebSeeds = seeds
+		s.t.Unlock()
+	}
+}
+
+// webSeedList is the web seeds of the task in order of the urls
+func webSeedList(urls []string, status map[string]WebSeedStatus) []WebSeedStatus {
+	sort.Strings(urls)
+	list := make([]WebSeedStatus, 0, len(urls))
+	for _, u := range urls {
+		ws, ok := status[u]
+		if !ok {
+			ws = WebSeedStatus{URL: u}
+		}
+		list = append(list, ws)
+	}
+	return list
+}
+
+// TorrentWebSeeds returns the web seeds of the task with their transfer
+func (e *Engine) TorrentWebSeeds(infohash string) ([]WebSeedStatus, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	e.updateWebSeeds()
+	t.Lock()
+	defer t.Unlock()
+	return append([]WebSeedStatus{}, t.WebSeeds...), nil
+}
+
+// AddTorrentWebSeeds adds the BEP 19 web seeds to the task, kept after
+// restarts once the info is loaded
+func (e *Engine) AddTorrentWebSeeds(infohash string, urls []string) error {
+	var seeds []string
+	for _, u := range urls {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if pu, err := url.Parse(u); err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+			return fmt.Errorf("Invalid web seed %q, eg: https://mirror.example/pub/", u)
+		}
+		seeds = append(seeds, u)
+	}
+	if len(seeds) == 0 {
+		return errNoWebSeeds
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	client := e.client
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	ih := metainfo.NewHashFromHex(infohash)
+	inClient := false
+	if client != nil {
+		_, inClient = client.Torrent(ih)
+	}
+	// the queued tasks aren't in the client yet
+	if !inClient {
+		return errNotLoaded
+	}
+	var merged *MergedError
+	if err := e.mergeSpec(t, &torrent.TorrentSpec{InfoHash: ih, Webseeds: seeds}); !errors.As(err, &merged) {
+		return err
+	}
+	log.Printf("[AddTorrentWebSeeds] %s %v", infohash, seeds)
+	return nil
+}
+
+// saveTorrentWebSeeds writes the web seeds to the cached torrent file
+func (e *Engine) saveTorrentWebSeeds(infohash string, urls []string) error {
+	return e.rewriteTorrentCache(infohash, func(mi *metainfo.MetaInfo) {
+		mi.UrlList = urls
+	})
+}
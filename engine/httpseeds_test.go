@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestParseWebSeedStatus(t *testing.T) {
+	status := `Infohash: 0123456789abcdef0123456789abcdef01234567
+ 1. https://mirror.example/pub/
+    bep40-prio: 00000000
+    last msg: never, connected: never, last helpful: 1.20s ago, itime: 0s, etime: 3s
+    12/12 completed, 4 pieces touched, good chunks: 64/64:0 reqq: 2+0/(16/250):0/0, flags: , dr: 512.0 KiB/s
+ 2. "-qB4450-abcdefghijkl"                                  0000000000100005 tcp 1.2.3.4:6881
+    12/12 completed, 4 pieces touched, good chunks: 9/9:0 reqq: 0+0/(16/250):0/0, flags: , dr: 1.0 KiB/s
+Infohash: 89abcdef0123456789abcdef0123456789abcdef
+ 1. http://other.example/f.iso
+`
+	m := parseWebSeedStatus(strings.NewReader(status))
+	ws := m["0123456789abcdef0123456789abcdef01234567"]["https://mirror.example/pub/"]
+	if !ws.Active || ws.Downloaded != 64*webseedChunkSize || ws.DownloadRate != 512*1024 {
+		t.Errorf("web seed = %+v", ws)
+	}
+	if len(m["0123456789abcdef0123456789abcdef01234567"]) != 1 {
+		t.Errorf("peers parsed as web seeds: %+v", m)
+	}
+	if ws := m["89abcdef0123456789abcdef0123456789abcdef"]["http://other.example/f.iso"]; !ws.Active {
+		t.Errorf("web seed of the second task = %+v", ws)
+	}
+
+	list := webSeedList([]string{"https://mirror.example/pub/", "http://gone.example/"}, m["0123456789abcdef0123456789abcdef01234567"])
+	if len(list) != 2 || list[0].URL != "http://gone.example/" || list[0].Active || !list[1].Active {
+		t.Errorf("web seed list = %+v", list)
+	}
+}
+
+func TestWebSeedStatusOfClient(t *testing.T) {
+	tc := torrent.NewDefaultClientConfig()
+	tc.DataDir = t.TempDir()
+	tc.ListenPort = 0
+	tc.NoDHT = true
+	tc.DisableTrackers = true
+	tc.NoDefaultPortForwarding = true
+	cl, err := torrent.NewClient(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	ih := metainfo.NewHashFromHex("0123456789abcdef0123456789abcdef01234567")
+	if _, _, err := cl.AddTorrentSpec(&torrent.TorrentSpec{InfoHash: ih, Webseeds: []string{"http://127.0.0.1:1/pub/"}}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cl.WriteStatus(&buf)
+	m := parseWebSeedStatus(&buf)
+	if ws := m[ih.HexString()]["http://127.0.0.1:1/pub/"]; !ws.Active {
+		t.Errorf("web seed not found in the status of the client: %+v", m)
+	}
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var errImportNoData = errors.New("no existing data found")
+
+// ImportTorrent adds a torrent whose data already exists at dataPath, eg: from
+// another client. dataPath is either the content itself (named as the torrent)
+// or the directory containing it. The data is verified, the complete pieces
+// are seeded and the rest is downloaded.
+func (e *Engine) ImportTorrent(r io.Reader, dataPath string) error {
+	mi, err := metainfo.Load(r)
+	if err != nil {
+		return err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	e.RLock()
+	roots := e.config.taskDirRoots()
+	e.RUnlock()
+	dir, err := importDir(dataPath, info.Name, roots)
+	if err != nil {
+		return err
+	}
+	ih := mi.HashInfoBytes().HexString()
+	log.Printf("[ImportTorrent] %s with data in %s", ih, dir)
+
+	e.RLock()
+	_, exists := e.ts[ih]
+	e.RUnlock()
+	if exists {
+		return ErrTaskExists
+	}
+
+	e.newTorrentCacheFile(mi)
+	// the piece completion of the dir knows nothing about the data
+	e.markRecheck(ih)
+	return e.newTorrentBySpec(context.Background(), torrent.TorrentSpecFromMetaInfo(mi), taskTorrent, dir)
+}
+
+// importDir returns the storage dir holding the content named name, within
+// the roots of the task dirs
+func importDir(dataPath, name string, roots []string) (string, error) {
+	p, err := filepath.Abs(dataPath)
+	if err != nil {
+		return "", err
+	}
+	if !withinRoots(p, roots) {
+		return "", errTaskDirOutside
+	}
+	dir := p
+	if filepath.Base(p) == name {
+		dir = filepath.Dir(p)
+	}
+	if !withinRoots(dir, roots) {
+		return "", errTaskDirOutside
+	}
+	if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+		return "", fmt.Errorf("%w: %s", errImportNoData, filepath.Join(dir, name))
+	}
+	return dir, nil
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportDir(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "downloads")
+	outside := filepath.Join(base, "outside")
+	for _, d := range []string{filepath.Join(root, "old", "movie"), filepath.Join(outside, "movie")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots := []string{root}
+
+	for _, tc := range []struct {
+		path string
+		want string
+		err  error
+	}{
+		{filepath.Join(root, "old"), filepath.Join(root, "old"), nil},
+		{filepath.Join(root, "old", "movie"), filepath.Join(root, "old"), nil},
+		{filepath.Join(root, "new"), "", errImportNoData},
+		{outside, "", errTaskDirOutside},
+		{filepath.Join(outside, "movie"), "", errTaskDirOutside},
+		{filepath.Join(root, "..", "outside"), "", errTaskDirOutside},
+	} {
+		got, err := importDir(tc.path, "movie", roots)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("importDir(%s) = %q, %v; want %q, %v", tc.path, got, err, tc.want, tc.err)
+		}
+	}
+}
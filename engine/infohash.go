@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// MagnetOfInfoHash returns the magnet of a bare infohash, 40 hex or 32 base32
+// characters as given by the indexers, false for the other strings. The info
+// of the magnet is resolved from the DHT and the public trackers.
+func MagnetOfInfoHash(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	var ih metainfo.Hash
+	switch len(s) {
+	case 40:
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return "", false
+		}
+		copy(ih[:], b)
+	case 32:
+		b, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		if err != nil || len(b) != len(ih) {
+			return "", false
+		}
+		copy(ih[:], b)
+	default:
+		return "", false
+	}
+	return "magnet:?xt=urn:btih:" + ih.HexString(), true
+}
@@ -0,0 +1,21 @@
+package engine
+
+import "testing"
+
+func TestMagnetOfInfoHash(t *testing.T) {
+	const magnet = "magnet:?xt=urn:btih:dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c"
+	for s, want := range map[string]string{
+		"dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c":   magnet,
+		" DD8255ECDC7CA55FB0BBF81323D87062DB1F6D1C ": magnet,
+		"3WBFL3G4PSSV7MF37AJSHWDQMLNR63I4":           magnet,
+		"3wbfl3g4pssv7mf37ajshwdqmlnr63i4":           magnet,
+		"dd8255ecdc7ca55fb0bbf81323d87062db1f6d1":    "",
+		"zz8255ecdc7ca55fb0bbf81323d87062db1f6d1c":   "",
+		"magnet:?xt=urn:btih:dd8255ecdc7ca55fb0bbf8": "",
+	} {
+		got, ok := MagnetOfInfoHash(s)
+		if got != want || ok != (want != "") {
+			t.Errorf("MagnetOfInfoHash(%q) = %q, %v, want %q", s, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Inspection is the content of a torrent or magnet before it's added
+type Inspection struct {
+	InfoHash    string
+	Name        string
+	Size        int64
+	PieceLength int64
+	NumPieces   int
+	Private     bool
+	Trackers    []string
+	Files       []InspectedFile
+	// a task of the torrent exists
+	Exists bool
+}
+
+// InspectedFile is a file of the torrent, Index in the order of the info
+type InspectedFile struct {
+	Index int
+	Path  string
+	Size  int64
+}
+
+// InspectTorrent parses a .torrent, or a magnet whose info is fetched from
+// the peers as FetchMetadata does, without adding it
+func (e *Engine) InspectTorrent(ctx context.Context, data []byte) (*Inspection, error) {
+	var mi *metainfo.MetaInfo
+	var err error
+	if s := strings.TrimSpace(string(data)); strings.HasPrefix(s, "magnet:") {
+		mi, err = e.FetchMetadata(ctx, s)
+	} else {
+		mi, err = metainfo.Load(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	ins, err := Inspect(mi)
+	if err != nil {
+		return nil, err
+	}
+	_, ins.Exists = e.Torrent(ins.InfoHash)
+	return ins, nil
+}
+
+// Inspect lists the content of the torrent
+func Inspect(mi *metainfo.MetaInfo) (*Inspection, error) {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, err
+	}
+	ins := &Inspection{
+		InfoHash:    mi.HashInfoBytes().HexString(),
+		Name:        info.Name,
+		Size:        info.TotalLength(),
+		PieceLength: info.PieceLength,
+		NumPieces:   info.NumPieces(),
+		Private:     info.Private != nil && *info.Private,
+		Trackers:    flattenTrackers(mi.UpvertedAnnounceList()),
+		Files:       []InspectedFile{},
+	}
+	for i, f := range info.UpvertedFiles() {
+		// the paths of the tasks, a single file torrent has none
+		fp := f.Path
+		if len(f.PathUTF8) > 0 {
+			fp = f.PathUTF8
+		}
+		p := strings.Join(append([]string{info.Name}, fp...), "/")
+		ins.Files = append(ins.Files, InspectedFile{Index: i, Path: p, Size: f.Length})
+	}
+	return ins, nil
+}
+
+// InfoHashOf returns the info hash of a magnet or torrent bytes
+func InfoHashOf(data []byte) (string, error) {
+	if s := strings.TrimSpace(string(data)); strings.HasPrefix(s, "magnet:") {
+		m, err := metainfo.ParseMagnetUri(s)
+		if err != nil {
+			return "", err
+		}
+		return m.InfoHash.HexString(), nil
+	}
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	return mi.HashInfoBytes().HexString(), nil
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestInspect(t *testing.T) {
+	private := true
+	tests := []struct {
+		name  string
+		info  metainfo.Info
+		files []InspectedFile
+	}{
+		{"single", metainfo.Info{Name: "a.mkv", Length: 10, PieceLength: 16 << 10},
+			[]InspectedFile{{0, "a.mkv", 10}}},
+		{"multi", metainfo.Info{Name: "dir", PieceLength: 16 << 10, Private: &private, Files: []metainfo.FileInfo{
+			{Path: []string{"a.txt"}, Length: 1},
+			{Path: []string{"sub", "b.txt"}, Length: 2},
+		}}, []InspectedFile{{0, "dir/a.txt", 1}, {1, "dir/sub/b.txt", 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mi := &metainfo.MetaInfo{AnnounceList: [][]string{{"udp://a/announce"}, {"udp://b/announce"}}}
+			var err error
+			if mi.InfoBytes, err = bencode.Marshal(tt.info); err != nil {
+				t.Fatal(err)
+			}
+			ins, err := Inspect(mi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ins.Files, tt.files) {
+				t.Errorf("Inspect() files = %v, want %v", ins.Files, tt.files)
+			}
+			if ins.Name != tt.info.Name || ins.Size != tt.info.TotalLength() || ins.Private != (tt.info.Private != nil) || len(ins.Trackers) != 2 {
+				t.Errorf("Inspect() = %+v", ins)
+			}
+		})
+	}
+}
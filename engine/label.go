@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// labelRule is a line of LabelRules, eg:
+//  name:(?i)\bS\d\dE\d\d\b => tv:episode,hd
+//  tracker:tracker.example.org => music
+type labelRule struct {
+	name    *regexp.Regexp
+	tracker string
+	label   string
+	tags    []string
+}
+
+// labelDir is a line of LabelDirs, eg:
+//  tv => tv/incoming | tv/done
+type labelDir struct {
+	download  string
+	completed string
+}
+
+func parseLabelRules(s string) ([]labelRule, error) {
+	var rules []labelRule
+	for _, line := range common.SplitLines(s) {
+		parts := strings.SplitN(line, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid label rule %q", line)
+		}
+		match := strings.TrimSpace(parts[0])
+		target := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+
+		r := labelRule{label: strings.TrimSpace(target[0])}
+		if len(target) == 2 {
+			for _, tag := range strings.Split(target[1], ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					r.tags = append(r.tags, tag)
+				}
+			}
+		}
+		switch {
+		case strings.HasPrefix(match, "name:"):
+			re, err := regexp.Compile(match[5:])
+			if err != nil {
+				return nil, fmt.Errorf("label rule %q: %w", line, err)
+			}
+			r.name = re
+		case strings.HasPrefix(match, "tracker:"):
+			r.tracker = strings.ToLower(strings.TrimSpace(match[8:]))
+		default:
+			return nil, fmt.Errorf("invalid label rule %q, expecting name: or tracker:", line)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseLabelDirs(s string) (map[string]labelDir, error) {
+	dirs := make(map[string]labelDir)
+	for _, line := range common.SplitLines(s) {
+		parts := strings.SplitN(line, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid label dir %q", line)
+		}
+		paths := strings.SplitN(parts[1], "|", 2)
+		d := labelDir{download: strings.TrimSpace(paths[0])}
+		if len(paths) == 2 {
+			d.completed = strings.TrimSpace(paths[1])
+		}
+		dirs[strings.TrimSpace(parts[0])] = d
+	}
+	return dirs, nil
+}
+
+func (r *labelRule) match(name string, hosts []string) bool {
+	if r.name != nil {
+		return name != "" && r.name.MatchString(name)
+	}
+	for _, h := range hosts {
+		if h == r.tracker || strings.HasSuffix(h, "."+r.tracker) {
+			return true
+		}
+	}
+	return false
+}
+
+// inferLabel returns the label of the first matching rule and
+// the tags of all matching rules
+func inferLabel(rules []labelRule, name string, trackers []string) (string, []string) {
+	var hosts []string
+	for _, tr := range trackers {
+		if u, err := url.Parse(tr); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, strings.ToLower(u.Hostname()))
+		}
+	}
+
+	var label string
+	var tags []string
+	seen := make(map[string]bool)
+	for i := range rules {
+		r := &rules[i]
+		if !r.match(name, hosts) {
+			continue
+		}
+		if label == "" {
+			label = r.label
+		}
+		for _, tag := range r.tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return label, tags
+}
+
+func (e *Engine) inferLabel(name string, trackers []string) (string, []string) {
+	if strings.TrimSpace(e.config.LabelRules) == "" {
+		return "", nil
+	}
+	rules, err := parseLabelRules(e.config.LabelRules)
+	if err != nil {
+		log.Warn("[Label]", err)
+		return "", nil
+	}
+	return inferLabel(rules, name, trackers)
+}
+
+func (e *Engine) labelDir(label string) labelDir {
+	if label == "" {
+		return labelDir{}
+	}
+	dirs, err := parseLabelDirs(e.config.LabelDirs)
+	if err != nil {
+		log.Warn("[Label]", err)
+		return labelDir{}
+	}
+	return dirs[label]
+}
+
+// applyLabel labels a new task by the rules, the tasks restored
+// from the last session keep their labels.
+// trackers are the announce urls of the torrent
+func (e *Engine) applyLabel(t *Torrent, name string, trackers []string) {
+	label, tags := e.inferLabel(name, trackers)
+
+	t.Lock()
+	defer t.Unlock()
+	if t.restored {
+		return
+	}
+	if t.Label == "" && label != "" {
+		t.Label = label
+		log.Printf("[Label] %s -> %s %v", t.InfoHash, label, tags)
+	}
+	for _, tag := range tags {
+		if !t.hasTag(tag) {
+			t.Tags = append(t.Tags, tag)
+		}
+	}
+}
+
+// SetTorrentLabel sets the label of the task, empty label clears it.
+// The data stays where it is.
+func (e *Engine) SetTorrentLabel(infohash, label string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.Label = strings.TrimSpace(label)
+	t.Unlock()
+	log.Printf("[SetTorrentLabel] %s %q", infohash, label)
+	e.notifyChanged()
+	return nil
+}
+
+// moveCompleted moves the data of a completed task to the completed dir of its label
+func (e *Engine) moveCompleted(t *Torrent) {
+	t.Lock()
+	label := t.Label
+	t.Unlock()
+	if dir := e.labelDir(label).completed; dir != "" {
+		if err := e.relocateTask(t, dir); err != nil {
+			log.Warnf("[Label] move %s to %s: %v", t.InfoHash, dir, err)
+			e.emit(EventError, t, err)
+		}
+	}
+}
+
+// relocateTask moves the data of the task to dir and reloads it.
+// The piece completion of dir knows nothing about the data, so it's rechecked.
+func (e *Engine) relocateTask(t *Torrent, dir string) error {
+	dst, err := e.config.resolveTaskDir(dir)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	ih, name, src := t.InfoHash, t.Name, t.DownloadDir
+	t.Unlock()
+	if dst == src {
+		return nil
+	}
+	if name == "" {
+		return errNotLoaded
+	}
+	dldir, err := filepath.Abs(e.config.DownloadDirectory)
+	if err != nil {
+		return err
+	}
+	srcDir, dstDir := src, dst
+	if srcDir == "" {
+		srcDir = dldir
+	}
+	if dstDir == "" {
+		dstDir = dldir
+	}
+	log.Printf("[relocateTask] %s from %s to %s", ih, srcDir, dstDir)
+
+	return e.reloadTask(t, func(*taskSession) error {
+		if err := os.Rename(filepath.Join(srcDir, name), filepath.Join(dstDir, name)); err != nil {
+			// added back in place
+			return err
+		}
+		e.setTaskDir(ih, dst)
+		e.markRecheck(ih)
+		return nil
+	})
+}
+
+// hasTag must hold lock
+func (t *Torrent) hasTag(tag string) bool {
+	for _, tg := range t.Tags {
+		if tg == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func flattenTrackers(tiers [][]string) []string {
+	var trackers []string
+	for _, tier := range tiers {
+		trackers = append(trackers, tier...)
+	}
+	return trackers
+}
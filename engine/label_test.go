@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_inferLabel(t *testing.T) {
+	rules, err := parseLabelRules(`
+# comment
+name:(?i)\bS\d\dE\d\d\b => tv:episode
+tracker:tv.example.org => tv2:hd
+name:(?i)\.flac\b => music:lossless,episode
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type args struct {
+		name     string
+		trackers []string
+	}
+	tests := []struct {
+		name  string
+		args  args
+		label string
+		tags  []string
+	}{
+		{"name", args{"Show.s01e02.720p", nil}, "tv", []string{"episode"}},
+		{"tracker", args{"Movie", []string{"udp://tv.example.org:80/announce"}}, "tv2", []string{"hd"}},
+		{"subdomain", args{"Movie", []string{"https://a.TV.example.org/announce"}}, "tv2", []string{"hd"}},
+		{"suffix", args{"Movie", []string{"https://notv.example.org/announce"}}, "", nil},
+		{"first", args{"Show.S01E02.flac", []string{"http://tv.example.org/a"}}, "tv", []string{"episode", "hd", "lossless"}},
+		{"none", args{"", nil}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			label, tags := inferLabel(rules, tt.args.name, tt.args.trackers)
+			if label != tt.label {
+				t.Errorf("inferLabel() label = %v, want %v", label, tt.label)
+			}
+			if !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("inferLabel() tags = %v, want %v", tags, tt.tags)
+			}
+		})
+	}
+}
+
+func Test_parseLabelRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"arrow", "name:abc tv", true},
+		{"field", "size:1 => tv", true},
+		{"regexp", "name:( => tv", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseLabelRules(tt.rules); (err != nil) != tt.wantErr {
+				t.Errorf("parseLabelRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseLabelDirs(t *testing.T) {
+	got, err := parseLabelDirs(`
+tv => tv/incoming | /data/tv
+music => music
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]labelDir{
+		"tv":    {"tv/incoming", "/data/tv"},
+		"music": {"music", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLabelDirs() = %v, want %v", got, want)
+	}
+	if _, err := parseLabelDirs("tv"); err == nil {
+		t.Error("parseLabelDirs() want error")
+	}
+}
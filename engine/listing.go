@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// states of the tasks in ListQuery
+const (
+	ListDownloading = "downloading"
+	ListSeeding     = "seeding"
+	ListStopped     = "stopped"
+	ListQueued      = "queued"
+	ListError       = "error"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// ListQuery selects a page of the tasks, the empty fields don't filter
+type ListQuery struct {
+	// in the names, case insensitive
+	Search string
+	State  string
+	Label  string
+	// the tasks of the collection
+	Collection string
+	// added, name, size, progress, ratio or speed
+	Sort   string
+	Desc   bool
+	Offset int
+	Limit  int
+}
+
+// ListPage is a page of the tasks matching the query, Total before paging
+type ListPage struct {
+	Total    int
+	Offset   int
+	Limit    int
+	Torrents []*Torrent
+}
+
+// listRow is the snapshot of a task filtered and sorted
+type listRow struct {
+	t       *Torrent
+	ih      string
+	name    string
+	state   string
+	failed  bool
+	label   string
+	coll    string
+	addedAt time.Time
+	size    int64
+	percent float32
+	ratio   float32
+	speed   float32
+}
+
+// ParseListQuery reads the query of the listing API:
+// q, state, label, collection, sort, order (asc or desc), offset and limit
+func ParseListQuery(v url.Values) (ListQuery, error) {
+	q := ListQuery{
+		Search:     strings.TrimSpace(v.Get("q")),
+		State:      strings.ToLower(v.Get("state")),
+		Label:      v.Get("label"),
+		Collection: v.Get("collection"),
+		Sort:       strings.ToLower(v.Get("sort")),
+		Limit:      defaultListLimit,
+	}
+	switch q.State {
+	case "", ListDownloading, ListSeeding, ListStopped, ListQueued, ListError:
+	default:
+		return q, fmt.Errorf("invalid state %q", q.State)
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = "added"
+	case "added", "name", "size", "progress", "ratio", "speed":
+	default:
+		return q, fmt.Errorf("invalid sort %q", q.Sort)
+	}
+	switch strings.ToLower(v.Get("order")) {
+	case "", "desc":
+		q.Desc = true
+	case "asc":
+	default:
+		return q, fmt.Errorf("invalid order %q", v.Get("order"))
+	}
+	var err error
+	if s := v.Get("offset"); s != "" {
+		if q.Offset, err = strconv.Atoi(s); err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("invalid offset %q", s)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	if q.Limit > maxListLimit {
+		q.Limit = maxListLimit
+	}
+	return q, nil
+}
+
+// ListTorrents returns the page of the tasks selected by q
+func (e *Engine) ListTorrents(q ListQuery) ListPage {
+	ts := e.Torrents()
+	rows := make([]listRow, 0, len(ts))
+	for ih, t := range ts {
+		t.Lock()
+		r := listRow{
+			t:       t,
+			ih:      ih,
+			name:    t.Name,
+			state:   taskState(t),
+			failed:  t.MetadataTimeout || t.ErrorState != nil,
+			label:   t.Label,
+			coll:    t.Collection,
+			addedAt: t.AddedAt,
+			size:    t.Size,
+			percent: t.Percent,
+			ratio:   t.SeedRatio,
+			speed:   t.DownloadRate + t.UploadRate,
+		}
+		t.Unlock()
+		if !r.failed {
+			r.failed = e.lastEventFailed(ih)
+		}
+		rows = append(rows, r)
+	}
+
+	rows = filterRows(rows, q)
+	sortRows(rows, q.Sort, q.Desc)
+	page := ListPage{Total: len(rows), Offset: q.Offset, Limit: q.Limit, Torrents: []*Torrent{}}
+	for i := q.Offset; i < len(rows) && i < q.Offset+q.Limit; i++ {
+		page.Torrents = append(page.Torrents, rows[i].t)
+	}
+	return page
+}
+
+// taskState is the state of t in ListQuery but error, must hold lock
+func taskState(t *Torrent) string {
+	switch {
+	case t.IsQueueing:
+		return ListQueued
+	case !t.Started:
+		return ListStopped
+	case t.Done:
+		return ListSeeding
+	}
+	return ListDownloading
+}
+
+// lastEventFailed tells whether the last event of the task is an error
+func (e *Engine) lastEventFailed(ih string) bool {
+	e.timelines.Lock()
+	defer e.timelines.Unlock()
+	tl := e.timelines.m[ih]
+	return len(tl) > 0 && tl[len(tl)-1].Type == EventError
+}
+
+func filterRows(rows []listRow, q ListQuery) []listRow {
+	search := strings.ToLower(q.Search)
+	ret := rows[:0]
+	for _, r := range rows {
+		if search != "" && !strings.Contains(strings.ToLower(r.name), search) {
+			continue
+		}
+		if q.Label != "" && !strings.EqualFold(r.label, q.Label) {
+			continue
+		}
+		if q.Collection != "" && r.coll != q.Collection {
+			continue
+		}
+		if q.State == ListError {
+			if !r.failed {
+				continue
+			}
+		} else if q.State != "" && r.state != q.State {
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// sortRows sorts by the key, the info hash keeps the pages stable
+func sortRows(rows []listRow, key string, desc bool) {
+	less := func(a, b listRow) (bool, bool) {
+		switch key {
+		case "name":
+			an, bn := strings.ToLower(a.name), strings.ToLower(b.name)
+			return an < bn, an == bn
+		case "size":
+			return a.size < b.size, a.size == b.size
+		case "progress":
+			return a.percent < b.percent, a.percent == b.percent
+		case "ratio":
+			return a.ratio < b.ratio, a.ratio == b.ratio
+		case "speed":
+			return a.speed < b.speed, a.speed == b.speed
+		}
+		return a.addedAt.Before(b.addedAt), a.addedAt.Equal(b.addedAt)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		lt, eq := less(rows[i], rows[j])
+		if eq {
+			return rows[i].ih < rows[j].ih
+		}
+		return lt != desc
+	})
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_filterRows_sortRows(t *testing.T) {
+	now := time.Now()
+	rows := func() []listRow {
+		return []listRow{
+			{ih: "a", name: "Show S01E01", state: ListSeeding, label: "tv", addedAt: now, size: 300, ratio: 2},
+			{ih: "b", name: "Movie", state: ListDownloading, coll: "Trilogy", addedAt: now.Add(-time.Hour), size: 100, speed: 50},
+			{ih: "c", name: "show s01e02", state: ListStopped, label: "TV", failed: true, addedAt: now.Add(time.Hour), size: 200},
+			{ih: "d", name: "Album", state: ListDownloading, addedAt: now, size: 100, speed: 10},
+		}
+	}
+	ihs := func(rows []listRow) []string {
+		ret := []string{}
+		for _, r := range rows {
+			ret = append(ret, r.ih)
+		}
+		return ret
+	}
+	tests := []struct {
+		name string
+		q    ListQuery
+		want []string
+	}{
+		{"added desc", ListQuery{Sort: "added", Desc: true}, []string{"c", "a", "d", "b"}},
+		{"search", ListQuery{Search: "SHOW", Sort: "name"}, []string{"a", "c"}},
+		{"label", ListQuery{Label: "tv", Sort: "size"}, []string{"c", "a"}},
+		{"state", ListQuery{State: ListDownloading, Sort: "speed", Desc: true}, []string{"b", "d"}},
+		{"error", ListQuery{State: ListError, Sort: "added"}, []string{"c"}},
+		{"collection", ListQuery{Collection: "Trilogy", Sort: "added"}, []string{"b"}},
+		{"size ties by hash", ListQuery{Sort: "size"}, []string{"b", "d", "c", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filterRows(rows(), tt.q)
+			sortRows(got, tt.q.Sort, tt.q.Desc)
+			if !reflect.DeepEqual(ihs(got), tt.want) {
+				t.Errorf("rows = %v, want %v", ihs(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseListQuery(t *testing.T) {
+	q, err := ParseListQuery(url.Values{"sort": {"Size"}, "order": {"asc"}, "offset": {"10"}, "limit": {"1000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ListQuery{Sort: "size", Offset: 10, Limit: maxListLimit}
+	if !reflect.DeepEqual(q, want) {
+		t.Errorf("ParseListQuery() = %+v, want %+v", q, want)
+	}
+	for _, v := range []url.Values{{"state": {"paused"}}, {"sort": {"peers"}}, {"limit": {"0"}}, {"offset": {"-1"}}, {"order": {"up"}}} {
+		if _, err := ParseListQuery(v); err == nil {
+			t.Errorf("ParseListQuery(%v) expecting error", v)
+		}
+	}
+}
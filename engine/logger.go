@@ -2,8 +2,9 @@ package engine
 
 import (
 	"fmt"
-	stdlog "log"
-	"os"
+
+	eglog "github.com/anacrolix/log"
+	"github.com/boypt/simple-torrent/common/logging"
 )
 
 var (
@@ -11,7 +12,7 @@ var (
 )
 
 type filteredLogger struct {
-	logger *stdlog.Logger
+	logger *logging.Logger
 }
 
 func (f *filteredLogger) filteredArg(v ...interface{}) []interface{} {
@@ -31,25 +32,92 @@ func (f *filteredLogger) filteredArg(v ...interface{}) []interface{} {
 	return v
 }
 
+// taskField is the infohash among the args as the task field of the JSON
+// lines, it's shortened in the message
+func taskField(v []interface{}) []string {
+	for _, arg := range v {
+		if s, ok := arg.(string); ok && len(s) == 40 {
+			return []string{"task", s}
+		}
+	}
+	return nil
+}
+
+func (f *filteredLogger) logln(level logging.Level, v []interface{}) {
+	if !f.logger.Enabled(level) {
+		return
+	}
+	kv := taskField(v)
+	s := fmt.Sprintln(f.filteredArg(v...)...)
+	f.logger.Log(level, s, kv...)
+}
+
+func (f *filteredLogger) logf(level logging.Level, format string, v []interface{}) {
+	if !f.logger.Enabled(level) {
+		return
+	}
+	kv := taskField(v)
+	f.logger.Log(level, fmt.Sprintf(format, f.filteredArg(v...)...), kv...)
+}
+
 func (f *filteredLogger) Println(v ...interface{}) {
-	f.logger.Println(f.filteredArg(v...)...)
+	f.logln(logging.LevelInfo, v)
 }
 func (f *filteredLogger) Printf(format string, v ...interface{}) {
-	f.logger.Printf(format, f.filteredArg(v...)...)
+	f.logf(logging.LevelInfo, format, v)
+}
+func (f *filteredLogger) Debug(v ...interface{}) {
+	f.logln(logging.LevelDebug, v)
+}
+func (f *filteredLogger) Debugf(format string, v ...interface{}) {
+	f.logf(logging.LevelDebug, format, v)
+}
+func (f *filteredLogger) Warn(v ...interface{}) {
+	f.logln(logging.LevelWarn, v)
+}
+func (f *filteredLogger) Warnf(format string, v ...interface{}) {
+	f.logf(logging.LevelWarn, format, v)
+}
+func (f *filteredLogger) Error(v ...interface{}) {
+	f.logln(logging.LevelError, v)
+}
+func (f *filteredLogger) Errorf(format string, v ...interface{}) {
+	f.logf(logging.LevelError, format, v)
 }
 func (f *filteredLogger) Fatal(v ...interface{}) {
 	f.logger.Fatal(f.filteredArg(v...)...)
 }
 func (f *filteredLogger) Panic(v ...interface{}) {
-	f.logger.Panicln(f.filteredArg(v...)...)
+	f.logger.Panic(f.filteredArg(v...)...)
+}
+
+// torrentLogger logs the lines of anacrolix/torrent as the torrent module, its
+// debug lines are shown as info with EngineDebug
+func torrentLogger(debug bool) eglog.Logger {
+	l := logging.New("torrent")
+	return eglog.Logger{LoggerImpl: eglog.LoggerFunc(func(m eglog.Msg) {
+		level := logging.LevelInfo
+		if lv, ok := m.GetLevel(); ok {
+			switch {
+			case lv.LessThan(eglog.Info):
+				if !debug {
+					level = logging.LevelDebug
+				}
+			case lv.LessThan(eglog.Warning):
+			case lv.LessThan(eglog.Error):
+				level = logging.LevelWarn
+			default:
+				level = logging.LevelError
+			}
+		}
+		if l.Enabled(level) {
+			l.Log(level, m.Text())
+		}
+	})}
 }
 
 func init() {
 	log = &filteredLogger{
-		logger: stdlog.New(os.Stdout, "[engine]", stdlog.LstdFlags|stdlog.Lmsgprefix),
+		logger: logging.New("engine"),
 	}
 }
-
-func SetLoggerFlag(flag int) {
-	log.logger.SetFlags(flag)
-}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const lowDiskTick = 30 * time.Second
+
+// the paused downloads are resumed once the free space is this share of
+// LowDiskSpace over it, not to pause them again right away
+const lowDiskResumeMargin = 10
+
+// LowDiskStatus is the state of the LowDiskSpace watchdog
+type LowDiskStatus struct {
+	// the free space of the download directory is below the threshold, the
+	// downloads on its disk are paused and new tasks refused
+	Low       bool
+	Dir       string
+	Free      int64
+	Threshold int64
+	Since     time.Time `json:",omitempty"`
+	// the tasks paused, resumed when the space is freed
+	Paused []string
+}
+
+type lowDiskState struct {
+	sync.Mutex
+	status LowDiskStatus
+	// the last EventLowDisk
+	notified time.Time
+}
+
+// LowDiskStatus returns the state of the watchdog of the last check
+func (e *Engine) LowDiskStatus() LowDiskStatus {
+	e.lowDiskState.Lock()
+	defer e.lowDiskState.Unlock()
+	s := e.lowDiskState.status
+	s.Paused = append([]string{}, s.Paused...)
+	return s
+}
+
+// lowDiskErr returns an ErrDiskSpace while the downloads on the disk of dir
+// are paused, of any disk if empty
+func (e *Engine) lowDiskErr(dir string) error {
+	e.lowDiskState.Lock()
+	s := e.lowDiskState.status
+	e.lowDiskState.Unlock()
+	if !s.Low {
+		return nil
+	}
+	if dir != "" {
+		parts, _ := disk.Partitions(false)
+		if mountPoint(parts, existingDir(dir)) != mountPoint(parts, s.Dir) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s free in %s, below LowDiskSpace %s", ErrDiskSpace,
+		humanize.IBytes(uint64(s.Free)), s.Dir, humanize.IBytes(uint64(s.Threshold)))
+}
+
+// lowDiskRoutine checks the free space of the download directory against
+// LowDiskSpace
+func (e *Engine) lowDiskRoutine() {
+	tk := time.NewTicker(lowDiskTick)
+	defer tk.Stop()
+	for range tk.C {
+		e.checkLowDisk()
+	}
+}
+
+// checkLowDisk pauses the downloads when the free space is below LowDiskSpace,
+// and resumes them once it's back over it by lowDiskResumeMargin
+func (e *Engine) checkLowDisk() {
+	c := e.Config()
+	threshold, err := parseByteSize(c.LowDiskSpace)
+	if err != nil {
+		log.Warn("[LowDisk] LowDiskSpace", err)
+	}
+	if threshold <= 0 || c.DownloadDirectory == "" {
+		e.lowDiskResume()
+		return
+	}
+	dir := c.DownloadDirectory
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	dir = existingDir(dir)
+	usage, err := disk.Usage(dir)
+	if err != nil {
+		return
+	}
+	free := int64(usage.Free)
+
+	e.lowDiskState.Lock()
+	s := &e.lowDiskState.status
+	s.Dir, s.Free, s.Threshold = dir, free, threshold
+	low := s.Low
+	e.lowDiskState.Unlock()
+
+	switch {
+	case !low && free < threshold:
+		e.lowDiskPause(dir)
+	case low && free >= threshold+threshold*lowDiskResumeMargin/100:
+		e.lowDiskResume()
+	}
+}
+
+// lowDiskPause stops the downloads on the disk of dir
+func (e *Engine) lowDiskPause(dir string) {
+	e.lowDiskState.Lock()
+	s := &e.lowDiskState.status
+	s.Low = true
+	s.Since = time.Now()
+	e.lowDiskState.Unlock()
+
+	parts, _ := disk.Partitions(false)
+	mount := mountPoint(parts, dir)
+	var paused []string
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		downloading := t.Started && !t.Done && mountPoint(parts, e.taskPath(t)) == mount
+		t.Unlock()
+		if downloading && e.StopTorrent(ih) == nil {
+			paused = append(paused, ih)
+		}
+	}
+	e.lowDiskState.Lock()
+	s.Paused = append(s.Paused, paused...)
+	e.lowDiskState.Unlock()
+
+	err := e.lowDiskErr("")
+	log.Warnf("[LowDisk] %v, paused %d downloads", err, len(paused))
+	e.lowDisk("", "", fmt.Errorf("%w, paused %d downloads", err, len(paused)))
+}
+
+// lowDiskResume starts again the downloads paused by lowDiskPause
+func (e *Engine) lowDiskResume() {
+	e.lowDiskState.Lock()
+	s := &e.lowDiskState.status
+	if !s.Low {
+		e.lowDiskState.Unlock()
+		return
+	}
+	s.Low = false
+	s.Since = time.Time{}
+	paused := s.Paused
+	s.Paused = nil
+	e.lowDiskState.Unlock()
+
+	log.Printf("[LowDisk] space is back, resuming %d downloads", len(paused))
+	for _, ih := range paused {
+		if err := e.StartTorrent(ih); err != nil {
+			log.Warn("[LowDisk] resume", ih, err)
+		}
+	}
+}
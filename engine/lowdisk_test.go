@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckLowDisk(t *testing.T) {
+	dir := t.TempDir()
+	e := &Engine{ts: make(map[string]*Torrent)}
+	e.config = Config{DownloadDirectory: dir, LowDiskSpace: "1000PB"}
+	e.checkLowDisk()
+	s := e.LowDiskStatus()
+	if !s.Low || s.Threshold != 1000<<50 || s.Since.IsZero() {
+		t.Fatalf("status = %+v", s)
+	}
+	if err := e.lowDiskErr(dir); !errors.Is(err, ErrDiskSpace) {
+		t.Errorf("lowDiskErr() = %v", err)
+	}
+
+	e.config.LowDiskSpace = ""
+	e.checkLowDisk()
+	if s := e.LowDiskStatus(); s.Low {
+		t.Errorf("not resumed, status = %+v", s)
+	}
+	if err := e.lowDiskErr(""); err != nil {
+		t.Errorf("lowDiskErr() = %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// local service discovery (BEP 14): the tasks are announced to the
+// multicast group of the LAN, and the peers announcing them are added
+const (
+	lsdGroup = "239.192.152.143:6771"
+	// BEP 14 allows an announce per task a minute
+	lsdInterval = 5 * time.Minute
+	lsdMaxMsg   = 1400
+)
+
+// peerSourceLSD is the discovery of the peers found on the LAN, the torrent
+// client knows none
+const peerSourceLSD torrent.PeerSource = "LSD"
+
+// lsdRoutine announces the public tasks every lsdInterval and listens to
+// the announces of the LAN until closeSync
+func (e *Engine) lsdRoutine(closeSync chan struct{}) {
+	group, err := net.ResolveUDPAddr("udp4", lsdGroup)
+	if err != nil {
+		log.Warn("[LSD]", err)
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		log.Warn("[LSD] disabled:", err)
+		return
+	}
+	b := make([]byte, 8)
+	rand.Read(b) // nolint: errcheck
+	cookie := hex.EncodeToString(b)
+	go func() {
+		<-closeSync
+		conn.Close()
+	}()
+	go e.lsdListen(conn, cookie)
+
+	tk := time.NewTicker(lsdInterval)
+	defer tk.Stop()
+	for {
+		e.lsdAnnounce(conn, group, cookie)
+		select {
+		case <-tk.C:
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+// lsdAnnounce sends the announce of each started task known not private
+func (e *Engine) lsdAnnounce(conn *net.UDPConn, group *net.UDPAddr, cookie string) {
+	e.RLock()
+	cl := e.client
+	e.RUnlock()
+	if cl == nil {
+		return
+	}
+	port := cl.LocalPort()
+	if port == 0 {
+		return
+	}
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		tt, started := t.t, t.Started
+		t.Unlock()
+		if tt == nil || !started || !lsdAllowed(tt) {
+			continue
+		}
+		if _, err := conn.WriteToUDP(lsdMessage(port, ih, cookie), group); err != nil {
+			log.Debugf("[LSD] announce %s: %v", ih, err)
+			return
+		}
+	}
+}
+
+// lsdAllowed tells whether the task has its info and it isn't private, the
+// private torrents find their peers by the trackers only (BEP 27)
+func lsdAllowed(tt *torrent.Torrent) bool {
+	info := tt.Info()
+	return info != nil && (info.Private == nil || !*info.Private)
+}
+
+func lsdMessage(port int, infohash, cookie string) []byte {
+	return []byte(fmt.Sprintf("BT-SEARCH * HTTP/1.1\r\nHost: %s\r\nPort: %d\r\nInfohash: %s\r\ncookie: %s\r\n\r\n\r\n",
+		lsdGroup, port, infohash, cookie))
+}
+
+// parseLSDMessage returns the port and the infohashes of an announce,
+// false for another message
+func parseLSDMessage(b []byte) (port int, infohashes []string, cookie string, ok bool) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil || req.Method != "BT-SEARCH" {
+		return 0, nil, "", false
+	}
+	port, err = strconv.Atoi(req.Header.Get("Port"))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, nil, "", false
+	}
+	for _, ih := range req.Header.Values("Infohash") {
+		if ih = strings.ToLower(strings.TrimSpace(ih)); len(ih) == 40 {
+			infohashes = append(infohashes, ih)
+		}
+	}
+	return port, infohashes, req.Header.Get("Cookie"), len(infohashes) > 0
+}
+
+// lsdListen adds the peers announcing the tasks, but ours
+func (e *Engine) lsdListen(conn *net.UDPConn, cookie string) {
+	buf := make([]byte, lsdMaxMsg)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		port, ihs, c, ok := parseLSDMessage(buf[:n])
+		if !ok || c == cookie {
+			continue
+		}
+		for _, ih := range ihs {
+			t, ok := e.Torrent(ih)
+			if !ok {
+				continue
+			}
+			t.Lock()
+			tt := t.t
+			t.Unlock()
+			if tt == nil || !lsdAllowed(tt) {
+				continue
+			}
+			addr := &net.TCPAddr{IP: from.IP, Port: port}
+			if tt.AddPeers([]torrent.PeerInfo{{Addr: addr, Source: peerSourceLSD}}) > 0 {
+				log.Debugf("[LSD] %s peer %s", ih, addr)
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestParseLSDMessage(t *testing.T) {
+	ih := "0123456789abcdef0123456789abcdef01234567"
+	port, ihs, cookie, ok := parseLSDMessage(lsdMessage(50007, ih, "c00k1e"))
+	if !ok || port != 50007 || len(ihs) != 1 || ihs[0] != ih || cookie != "c00k1e" {
+		t.Errorf("parseLSDMessage() = %d %q %q %v", port, ihs, cookie, ok)
+	}
+	// several infohashes of a message, in upper case
+	msg := "BT-SEARCH * HTTP/1.1\r\nHost: 239.192.152.143:6771\r\nPort: 6881\r\n" +
+		"Infohash: " + ih + "\r\nInfohash: 89ABCDEF0123456789ABCDEF0123456789ABCDEF\r\n\r\n\r\n"
+	if _, ihs, _, ok := parseLSDMessage([]byte(msg)); !ok || len(ihs) != 2 || ihs[1] != "89abcdef0123456789abcdef0123456789abcdef" {
+		t.Errorf("parseLSDMessage() of two infohashes = %q %v", ihs, ok)
+	}
+	for _, msg := range []string{
+		"M-SEARCH * HTTP/1.1\r\nHost: 239.255.255.250:1900\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 0\r\nInfohash: " + ih + "\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\nInfohash: abc\r\n\r\n",
+		"garbage",
+	} {
+		if _, _, _, ok := parseLSDMessage([]byte(msg)); ok {
+			t.Errorf("parseLSDMessage(%q) accepted", msg)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const mediaServerTimeout = 30 * time.Second
+
+// refreshLibraries asks Jellyfin and Plex to scan the data of the completed
+// task at its final location
+func (e *Engine) refreshLibraries(t *Torrent) {
+	c := e.Config()
+	if c.JellyfinURL == "" && c.PlexURL == "" {
+		return
+	}
+	local := e.TorrentDataPath(t.InfoHash)
+	if local == "" {
+		return
+	}
+	t.Lock()
+	ih, name := t.InfoHash, t.Name
+	t.Unlock()
+	p := mapLibraryPath(c.LibraryPathMap, local)
+	client := &http.Client{Timeout: mediaServerTimeout}
+	if c.JellyfinURL != "" {
+		err := refreshJellyfin(client, c.JellyfinURL, c.JellyfinAPIKey, p)
+		e.logRefresh(ih, name, "jellyfin refresh "+p, err)
+	}
+	if c.PlexURL != "" {
+		dir := p
+		if fi, err := os.Stat(local); err == nil && !fi.IsDir() {
+			// plex scans folders
+			dir = filepath.Dir(p)
+		}
+		err := refreshPlex(client, c.PlexURL, c.PlexToken, dir)
+		e.logRefresh(ih, name, "plex refresh "+dir, err)
+	}
+}
+
+func (e *Engine) logRefresh(ih, name, hook string, err error) {
+	if err != nil {
+		log.Warnf("[Library] %s %s: %v", ih, hook, err)
+	} else {
+		log.Printf("[Library] %s %s", ih, hook)
+	}
+	e.recordHook(ih, name, hook, err)
+}
+
+// mapLibraryPath translates the local path to the one seen by the media
+// servers by the first matching "local=remote" prefix, one per line
+func mapLibraryPath(mapping, p string) string {
+	for _, line := range strings.Split(mapping, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		local := strings.TrimSuffix(strings.TrimSpace(kv[0]), "/")
+		remote := strings.TrimSuffix(strings.TrimSpace(kv[1]), "/")
+		if p == local || strings.HasPrefix(p, local+"/") {
+			return remote + p[len(local):]
+		}
+	}
+	return p
+}
+
+// refreshJellyfin reports the path as created, Jellyfin scans the library
+// holding it
+func refreshJellyfin(client *http.Client, base, key, p string) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"Updates": []map[string]string{{"Path": p, "UpdateType": "Created"}},
+	})
+	req, err := http.NewRequest("POST", strings.TrimSuffix(base, "/")+"/Library/Media/Updated", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Emby-Token", key)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("jellyfin: %s", resp.Status)
+	}
+	return nil
+}
+
+// plexSections is the list of the libraries of Plex
+type plexSections struct {
+	Directories []struct {
+		Key       string `xml:"key,attr"`
+		Locations []struct {
+			Path string `xml:"path,attr"`
+		} `xml:"Location"`
+	} `xml:"Directory"`
+}
+
+// plexSection finds the library with the longest location holding dir
+func (s *plexSections) plexSection(dir string) string {
+	var key, best string
+	for _, d := range s.Directories {
+		for _, l := range d.Locations {
+			loc := strings.TrimSuffix(l.Path, "/")
+			if (dir == loc || strings.HasPrefix(dir, loc+"/")) && len(loc) > len(best) {
+				key, best = d.Key, loc
+			}
+		}
+	}
+	return key
+}
+
+// refreshPlex scans the dir in the library holding it
+func refreshPlex(client *http.Client, base, token, dir string) error {
+	base = strings.TrimSuffix(base, "/")
+	get := func(u string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Plex-Token", token)
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode/100 != 2 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("plex: %s", resp.Status)
+		}
+		return resp, err
+	}
+	resp, err := get(base + "/library/sections")
+	if err != nil {
+		return err
+	}
+	var sections plexSections
+	err = xml.NewDecoder(resp.Body).Decode(&sections)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("plex sections: %w", err)
+	}
+	key := sections.plexSection(dir)
+	if key == "" {
+		return fmt.Errorf("plex: no library holds %s", dir)
+	}
+	resp, err = get(base + "/library/sections/" + url.PathEscape(key) + "/refresh?path=" + url.QueryEscape(dir))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
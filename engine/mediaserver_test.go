@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_mapLibraryPath(t *testing.T) {
+	mapping := "/downloads/tv=/media/tv\n/downloads = /data/\n\n# no"
+	tests := []struct {
+		p, want string
+	}{
+		{"/downloads/tv/Show S01", "/media/tv/Show S01"},
+		{"/downloads/movie.mkv", "/data/movie.mkv"},
+		{"/downloads", "/data"},
+		{"/downloads2/movie.mkv", "/downloads2/movie.mkv"},
+		{"/other/movie.mkv", "/other/movie.mkv"},
+	}
+	for _, tt := range tests {
+		if got := mapLibraryPath(mapping, tt.p); got != tt.want {
+			t.Errorf("mapLibraryPath(%q) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func Test_plexSection(t *testing.T) {
+	doc := `<MediaContainer size="2">
+<Directory key="1" title="Movies"><Location id="1" path="/media"/></Directory>
+<Directory key="2" title="TV"><Location id="2" path="/media/tv/"/><Location id="3" path="/nas/tv"/></Directory>
+</MediaContainer>`
+	var s plexSections
+	if err := xml.Unmarshal([]byte(doc), &s); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir, want string
+	}{
+		{"/media/tv/Show", "2"},
+		{"/nas/tv", "2"},
+		{"/media/Movie (2020)", "1"},
+		{"/mediax/Movie", ""},
+	}
+	for _, tt := range tests {
+		if got := s.plexSection(tt.dir); got != tt.want {
+			t.Errorf("plexSection(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"container/list"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	memoryCheckInterval = 15 * time.Second
+	// the caches are back once the usage drops below this part of MemoryLimit
+	memoryResumeRatio = 0.9
+)
+
+// MemoryStats reports the memory used by the process against MemoryLimit and
+// the piece read cache
+type MemoryStats struct {
+	InUse int64
+	Limit int64
+	// the usage is over the limit, the caches are shed
+	Over     bool
+	Sheds    int
+	LastShed time.Time
+	// bytes cached of PieceCacheSize
+	PieceCache     int64
+	PieceCacheSize int64
+	CacheHits      int64
+	CacheMisses    int64
+}
+
+type pieceKey struct {
+	ih     string
+	offset int64
+}
+
+type chunkKey struct {
+	off int64
+	n   int
+}
+
+type cachedPiece struct {
+	key    pieceKey
+	chunks map[chunkKey][]byte
+	size   int64
+}
+
+// pieceCache keeps the chunks read of the complete pieces, the ones requested
+// by several peers or the streams are read from the disk once. The least
+// recently used pieces are evicted beyond max.
+type pieceCache struct {
+	sync.Mutex
+	max, used    int64
+	paused       bool
+	lru          *list.List
+	m            map[pieceKey]*list.Element
+	hits, misses int64
+}
+
+// resize sets the capacity, 0 disables the cache
+func (c *pieceCache) resize(max int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.max = max
+	c.evict(0)
+}
+
+// evict removes the pieces until n more bytes fit, must hold the lock
+func (c *pieceCache) evict(n int64) {
+	for c.lru != nil && c.lru.Len() > 0 && c.used+n > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove must hold the lock
+func (c *pieceCache) remove(el *list.Element) {
+	p := c.lru.Remove(el).(*cachedPiece)
+	delete(c.m, p.key)
+	c.used -= p.size
+}
+
+// get fills b with the chunk at off of the piece if it's cached
+func (c *pieceCache) get(k pieceKey, off int64, b []byte) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.max <= 0 {
+		return false
+	}
+	if el, ok := c.m[k]; ok {
+		if data, ok := el.Value.(*cachedPiece).chunks[chunkKey{off, len(b)}]; ok {
+			copy(b, data)
+			c.lru.MoveToFront(el)
+			c.hits++
+			return true
+		}
+	}
+	c.misses++
+	return false
+}
+
+// put caches a copy of the chunk read at off of the piece
+func (c *pieceCache) put(k pieceKey, off int64, b []byte) {
+	n := int64(len(b))
+	c.Lock()
+	defer c.Unlock()
+	// a single chunk doesn't take the whole cache
+	if c.paused || n == 0 || n > c.max/8 {
+		return
+	}
+	if c.lru == nil {
+		c.lru = list.New()
+		c.m = make(map[pieceKey]*list.Element)
+	}
+	if el, ok := c.m[k]; ok {
+		if _, ok := el.Value.(*cachedPiece).chunks[chunkKey{off, len(b)}]; ok {
+			c.lru.MoveToFront(el)
+			return
+		}
+	}
+	c.evict(n)
+	el, ok := c.m[k]
+	if ok {
+		c.lru.MoveToFront(el)
+	} else {
+		el = c.lru.PushFront(&cachedPiece{key: k, chunks: make(map[chunkKey][]byte)})
+		c.m[k] = el
+	}
+	p := el.Value.(*cachedPiece)
+	ck := chunkKey{off, len(b)}
+	p.chunks[ck] = append([]byte(nil), b...)
+	p.size += n
+	c.used += n
+}
+
+// invalidate drops the chunks of a piece being written
+func (c *pieceCache) invalidate(k pieceKey) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.m[k]; ok {
+		c.remove(el)
+	}
+}
+
+// dropTask drops the pieces of a removed task
+func (c *pieceCache) dropTask(ih string) {
+	c.Lock()
+	defer c.Unlock()
+	for k, el := range c.m {
+		if k.ih == ih {
+			c.remove(el)
+		}
+	}
+}
+
+// shed empties the cache, it stays empty while paused
+func (c *pieceCache) shed(pause bool) int64 {
+	c.Lock()
+	defer c.Unlock()
+	freed := c.used
+	c.lru, c.m, c.used = nil, nil, 0
+	c.paused = pause
+	return freed
+}
+
+type memoryGuard struct {
+	sync.Mutex
+	inUse    int64
+	over     bool
+	sheds    int
+	lastShed time.Time
+}
+
+// applyMemoryConfig sizes the piece cache of c, the MemoryLimit is checked
+// by memoryRoutine
+func (e *Engine) applyMemoryConfig(c *Config) {
+	size, err := parseByteSize(c.PieceCacheSize)
+	if err != nil {
+		log.Warn("[PieceCacheSize]", err)
+	}
+	e.pieceCache.resize(size)
+}
+
+// setConnLimits applies MaxConnsPerTask and MaxHalfOpenConns, the buffers of
+// a peer connection are fixed by the client, their number bounds the memory
+func (c *Config) setConnLimits(tc *torrent.ClientConfig) {
+	if c.MaxConnsPerTask > 0 {
+		tc.EstablishedConnsPerTorrent = c.MaxConnsPerTask
+		if tc.TorrentPeersLowWater > c.MaxConnsPerTask {
+			tc.TorrentPeersLowWater = c.MaxConnsPerTask
+		}
+	}
+	if c.MaxHalfOpenConns > 0 {
+		tc.TotalHalfOpenConns = c.MaxHalfOpenConns
+		if tc.HalfOpenConnsPerTorrent > c.MaxHalfOpenConns {
+			tc.HalfOpenConnsPerTorrent = c.MaxHalfOpenConns
+		}
+	}
+}
+
+// memoryRoutine checks the memory used against MemoryLimit
+func (e *Engine) memoryRoutine(closeSync chan struct{}) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closeSync:
+			return
+		case <-ticker.C:
+			e.checkMemory()
+		}
+	}
+}
+
+// memoryInUse is the memory taken from the OS by the runtime and not released
+func memoryInUse() int64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.Sys - ms.HeapReleased)
+}
+
+// checkMemory sheds the caches and returns the freed memory to the OS when
+// the usage is over MemoryLimit, the piece cache is paused until the usage
+// drops below memoryResumeRatio of the limit
+func (e *Engine) checkMemory() {
+	limit, err := parseByteSize(e.config.MemoryLimit)
+	if err != nil {
+		log.Warn("[MemoryLimit]", err)
+	}
+	inUse := memoryInUse()
+
+	g := &e.memory
+	g.Lock()
+	defer g.Unlock()
+	g.inUse = inUse
+	if limit <= 0 {
+		if g.over {
+			g.over = false
+			e.pieceCache.shed(false)
+		}
+		return
+	}
+	if inUse > limit {
+		freed := e.pieceCache.shed(true)
+		debug.FreeOSMemory()
+		g.inUse = memoryInUse()
+		g.sheds++
+		g.lastShed = time.Now()
+		if !g.over {
+			log.Warnf("[MemoryLimit] %s in use over %s, shed %s of cache, %s in use now",
+				humanize.IBytes(uint64(inUse)), humanize.IBytes(uint64(limit)),
+				humanize.IBytes(uint64(freed)), humanize.IBytes(uint64(g.inUse)))
+		}
+		g.over = true
+		return
+	}
+	if g.over && float64(inUse) < float64(limit)*memoryResumeRatio {
+		g.over = false
+		e.pieceCache.shed(false)
+		log.Printf("[MemoryLimit] %s in use, caches resumed", humanize.IBytes(uint64(inUse)))
+	}
+}
+
+// MemoryStats returns the memory usage of the last check and the piece cache
+func (e *Engine) MemoryStats() MemoryStats {
+	limit, _ := parseByteSize(e.config.MemoryLimit)
+	g := &e.memory
+	g.Lock()
+	s := MemoryStats{
+		InUse:    g.inUse,
+		Limit:    limit,
+		Over:     g.over,
+		Sheds:    g.sheds,
+		LastShed: g.lastShed,
+	}
+	g.Unlock()
+	if s.InUse == 0 {
+		s.InUse = memoryInUse()
+	}
+
+	c := &e.pieceCache
+	c.Lock()
+	s.PieceCache, s.PieceCacheSize = c.used, c.max
+	s.CacheHits, s.CacheMisses = c.hits, c.misses
+	c.Unlock()
+	return s
+}
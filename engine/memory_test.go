@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_pieceCache(t *testing.T) {
+	var c pieceCache
+	c.resize(80)
+	a, b := pieceKey{"a", 0}, pieceKey{"a", 16}
+	chunk := bytes.Repeat([]byte{1}, 10)
+
+	buf := make([]byte, 10)
+	if c.get(a, 0, buf) {
+		t.Fatal("hit on empty cache")
+	}
+	c.put(a, 0, chunk)
+	c.put(a, 10, chunk)
+	if !c.get(a, 0, buf) || !bytes.Equal(buf, chunk) {
+		t.Fatal("missed a cached chunk")
+	}
+	if c.get(a, 0, make([]byte, 5)) {
+		t.Error("hit with another length")
+	}
+
+	// a is used the latest, b is evicted first
+	c.put(b, 0, chunk)
+	c.get(a, 0, buf)
+	for i := 0; i < 6; i++ {
+		c.put(pieceKey{"c", int64(i)}, 0, chunk)
+	}
+	if c.used > c.max {
+		t.Errorf("used %d over %d", c.used, c.max)
+	}
+	if c.get(b, 0, buf) {
+		t.Error("b not evicted")
+	}
+
+	c.put(a, 0, chunk)
+	c.invalidate(a)
+	if c.get(a, 0, buf) {
+		t.Error("hit on invalidated piece")
+	}
+	c.dropTask("c")
+	if c.used != 0 {
+		t.Errorf("used %d after dropping all", c.used)
+	}
+
+	c.put(a, 0, chunk)
+	if freed := c.shed(true); freed != 10 {
+		t.Errorf("shed freed %d", freed)
+	}
+	c.put(a, 0, chunk)
+	if c.get(a, 0, buf) {
+		t.Error("cached while paused")
+	}
+	c.resize(0)
+	c.shed(false)
+	c.put(a, 0, chunk)
+	if c.used != 0 {
+		t.Error("cached with no capacity")
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent"
+)
+
+// MergedError is returned adding a task already added, the trackers and web
+// seeds it brought were merged into the task. It is an ErrTaskExists, so
+// the adds of a torrent from several trackers succeed.
+type MergedError struct {
+	InfoHash string
+	Trackers int
+	WebSeeds int
+}
+
+func (m *MergedError) Error() string {
+	return fmt.Sprintf("Task already exists, merged %d trackers and %d web seeds", m.Trackers, m.WebSeeds)
+}
+
+func (m *MergedError) Is(target error) bool {
+	return target == ErrTaskExists
+}
+
+// mergeSpec adds the trackers and web seeds of spec missing in the added task
+func (e *Engine) mergeSpec(t *Torrent, spec *torrent.TorrentSpec) error {
+	e.RLock()
+	var tt *torrent.Torrent
+	if e.client != nil {
+		tt, _ = e.client.Torrent(spec.InfoHash)
+	}
+	e.RUnlock()
+	merged := &MergedError{InfoHash: t.InfoHash}
+	if tt == nil {
+		return merged
+	}
+
+	mi := tt.Metainfo()
+	known := make(map[string]bool)
+	for _, tr := range flattenTrackers(mi.UpvertedAnnounceList()) {
+		known[tr] = true
+	}
+	for _, ws := range mi.UrlList {
+		known[ws] = true
+	}
+	var trackers []string
+	var webseeds []string
+	for _, tr := range flattenTrackers(spec.Trackers) {
+		if !known[tr] {
+			known[tr] = true
+			trackers = append(trackers, tr)
+		}
+	}
+	for _, ws := range spec.Webseeds {
+		if !known[ws] {
+			known[ws] = true
+			webseeds = append(webseeds, ws)
+		}
+	}
+	merged.Trackers, merged.WebSeeds = len(trackers), len(webseeds)
+	if len(trackers) == 0 && len(webseeds) == 0 {
+		return merged
+	}
+
+	// MergeSpec sets these, keep the ones of the task
+	t.Lock()
+	ms := &torrent.TorrentSpec{
+		Webseeds:             webseeds,
+		DisallowDataDownload: t.ScheduleBlocked,
+		DisallowDataUpload:   t.uploadDisallowed == tt,
+	}
+	t.Unlock()
+	if len(trackers) > 0 {
+		// a tier of their own, tried after the ones of the task
+		ms.Trackers = append(make([][]string, len(mi.UpvertedAnnounceList())), trackers)
+	}
+	if err := tt.MergeSpec(ms); err != nil {
+		return err
+	}
+	// kept after restarts, a magnet has no cached torrent file before the info
+	mi = tt.Metainfo()
+	if len(trackers) > 0 {
+		if err := e.saveTorrentTrackers(t.InfoHash, mi.UpvertedAnnounceList()); err != nil {
+			log.Debugf("[newTorrentBySpec] %s trackers not saved: %v", t.InfoHash, err)
+		}
+	}
+	if len(webseeds) > 0 {
+		if err := e.saveTorrentWebSeeds(t.InfoHash, mi.UrlList); err != nil {
+			log.Debugf("[newTorrentBySpec] %s web seeds not saved: %v", t.InfoHash, err)
+		}
+	}
+	log.Printf("[newTorrentBySpec] %s merged %d trackers and %d web seeds", t.InfoHash, len(trackers), len(webseeds))
+	e.notifyChanged()
+	return merged
+}
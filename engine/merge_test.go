@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestMergeSpec(t *testing.T) {
+	tc := torrent.NewDefaultClientConfig()
+	tc.DataDir = t.TempDir()
+	tc.ListenPort = 0
+	tc.NoDHT = true
+	tc.DisableTrackers = true
+	tc.NoDefaultPortForwarding = true
+	cl, err := torrent.NewClient(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	ih := metainfo.NewHashFromHex("0123456789abcdef0123456789abcdef01234567")
+	tt, _, err := cl.AddTorrentSpec(&torrent.TorrentSpec{
+		InfoHash: ih,
+		Trackers: [][]string{{"http://a.example/announce"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{client: cl, ts: map[string]*Torrent{}}
+	task := &Torrent{InfoHash: ih.HexString()}
+
+	err = e.mergeSpec(task, &torrent.TorrentSpec{
+		InfoHash: ih,
+		Trackers: [][]string{{"http://a.example/announce", "http://b.example/announce"}},
+		Webseeds: []string{"http://w.example/data/"},
+	})
+	var merged *MergedError
+	if !errors.As(err, &merged) || !errors.Is(err, ErrTaskExists) {
+		t.Fatalf("mergeSpec = %v, want a MergedError", err)
+	}
+	if merged.Trackers != 1 || merged.WebSeeds != 1 {
+		t.Errorf("merged %d trackers and %d web seeds, want 1 and 1", merged.Trackers, merged.WebSeeds)
+	}
+	mi := tt.Metainfo()
+	want := [][]string{{"http://a.example/announce"}, {"http://b.example/announce"}}
+	if got := mi.UpvertedAnnounceList(); len(got) != 2 || got[1][0] != want[1][0] {
+		t.Errorf("announce list = %v, want %v", got, want)
+	}
+	if len(mi.UrlList) != 1 {
+		t.Errorf("web seeds = %v", mi.UrlList)
+	}
+
+	// nothing new the second time
+	err = e.mergeSpec(task, &torrent.TorrentSpec{InfoHash: ih, Trackers: want})
+	if !errors.As(err, &merged) || merged.Trackers != 0 || merged.WebSeeds != 0 {
+		t.Errorf("mergeSpec again = %v", err)
+	}
+}
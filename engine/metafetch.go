@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/boypt/simple-torrent/common"
+)
+
+// metadataDir in the cache dir keeps the torrents fetched by FetchMetadata,
+// apart from the ones restored as tasks
+const metadataDir = ".metadata"
+
+var (
+	// ErrFetchingMetadata refuses adding a magnet held by FetchMetadata
+	ErrFetchingMetadata = errors.New("metadata of the magnet being fetched, add it again later")
+
+	errNoData        = errors.New("metadata only, no data stored")
+	errNotConfigured = errors.New("engine not configured")
+)
+
+// FetchMetadata resolves the info of the magnet from the peers and returns
+// the torrent, saved in the cache dir, without adding a task or storing any
+// data. A magnet being downloaded is left as is.
+func (e *Engine) FetchMetadata(ctx context.Context, magnetURI string) (*metainfo.MetaInfo, error) {
+	if m, ok := MagnetOfInfoHash(magnetURI); ok {
+		magnetURI = m
+	}
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnetURI)
+	if err != nil {
+		return nil, err
+	}
+	ih := spec.InfoHash.HexString()
+	fn := filepath.Join(e.cacheDir, metadataDir, ih+".torrent")
+	for _, cached := range []string{e.TorrentCacheFileName(ih), fn} {
+		if mi, err := metainfo.LoadFromFile(cached); err == nil {
+			return mi, nil
+		}
+	}
+
+	e.RLock()
+	client, closeSync := e.client, e.closeSync
+	e.RUnlock()
+	if client == nil {
+		return nil, errNotConfigured
+	}
+	tt, held, err := e.holdMetadata(client, spec)
+	if err != nil {
+		return nil, err
+	}
+	if held {
+		defer e.releaseMetadata(tt, ih)
+	}
+	log.Printf("[FetchMetadata] %s resolving", ih)
+	select {
+	case <-tt.GotInfo():
+	case <-tt.Closed():
+		return nil, errors.New("task dropped")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-closeSync:
+		return nil, errors.New("engine reconfigured")
+	}
+
+	mi := tt.Metainfo()
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err == nil {
+		if f, err := os.Create(fn); err == nil {
+			common.FancyHandleError(mi.Write(f))
+			f.Close()
+		}
+	}
+	log.Printf("[FetchMetadata] %s got %s", ih, tt.Name())
+	return &mi, nil
+}
+
+// holdMetadata returns the torrent of the magnet in the client, the task
+// if any, or else held, added without storage. The magnets held aren't added
+// as tasks till released, taskMutex guards them as newTorrentBySpec adds
+// under it.
+func (e *Engine) holdMetadata(client *torrent.Client, spec *torrent.TorrentSpec) (*torrent.Torrent, bool, error) {
+	ih := spec.InfoHash.HexString()
+	e.taskMutex.Lock()
+	defer e.taskMutex.Unlock()
+	tt, ok := client.Torrent(spec.InfoHash)
+	if ok && e.metaFetches[ih] == 0 {
+		return tt, false, nil
+	}
+	if !ok {
+		spec.Storage = metadataStorage{}
+		var err error
+		if tt, _, err = client.AddTorrentSpec(spec); err != nil {
+			return nil, false, err
+		}
+	}
+	if e.metaFetches == nil {
+		e.metaFetches = make(map[string]int)
+	}
+	e.metaFetches[ih]++
+	return tt, true, nil
+}
+
+// releaseMetadata drops the torrent held by holdMetadata after the last fetch
+func (e *Engine) releaseMetadata(tt *torrent.Torrent, ih string) {
+	e.taskMutex.Lock()
+	defer e.taskMutex.Unlock()
+	if n := e.metaFetches[ih]; n > 1 {
+		e.metaFetches[ih] = n - 1
+		return
+	}
+	delete(e.metaFetches, ih)
+	tt.Drop()
+}
+
+// metadataStorage holds no data, the pieces are never complete or written
+type metadataStorage struct{}
+
+func (metadataStorage) OpenTorrent(*metainfo.Info, metainfo.Hash) (storage.TorrentImpl, error) {
+	return storage.TorrentImpl{
+		Piece: func(metainfo.Piece) storage.PieceImpl { return metadataPiece{} },
+		Close: func() error { return nil },
+	}, nil
+}
+
+type metadataPiece struct{}
+
+func (metadataPiece) ReadAt([]byte, int64) (int, error)  { return 0, errNoData }
+func (metadataPiece) WriteAt([]byte, int64) (int, error) { return 0, errNoData }
+func (metadataPiece) MarkComplete() error                { return errNoData }
+func (metadataPiece) MarkNotComplete() error             { return nil }
+func (metadataPiece) Completion() storage.Completion {
+	return storage.Completion{Complete: false, Ok: true}
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestFetchMetadataNotConfigured(t *testing.T) {
+	e := &Engine{cacheDir: t.TempDir()}
+	_, err := e.FetchMetadata(context.Background(), "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567")
+	if !errors.Is(err, errNotConfigured) {
+		t.Fatalf("FetchMetadata = %v, want not configured", err)
+	}
+}
+
+func TestHoldMetadata(t *testing.T) {
+	tc := torrent.NewDefaultClientConfig()
+	tc.DataDir = t.TempDir()
+	tc.ListenPort = 0
+	tc.NoDHT = true
+	tc.DisableTrackers = true
+	tc.NoDefaultPortForwarding = true
+	cl, err := torrent.NewClient(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	e := &Engine{client: cl}
+	ih := metainfo.NewHashFromHex("0123456789abcdef0123456789abcdef01234567")
+	spec := func() *torrent.TorrentSpec { return &torrent.TorrentSpec{InfoHash: ih} }
+
+	// two fetches of the same magnet share the torrent held
+	tt, held, err := e.holdMetadata(cl, spec())
+	if err != nil || !held {
+		t.Fatalf("hold = %v %v", held, err)
+	}
+	tt2, held, err := e.holdMetadata(cl, spec())
+	if err != nil || !held || tt2 != tt {
+		t.Fatalf("second hold = %v %v, same torrent %v", held, err, tt2 == tt)
+	}
+	e.releaseMetadata(tt, ih.HexString())
+	if _, ok := cl.Torrent(ih); !ok || e.metaFetches[ih.HexString()] != 1 {
+		t.Fatalf("dropped while still fetched")
+	}
+	e.releaseMetadata(tt, ih.HexString())
+	if _, ok := cl.Torrent(ih); ok || e.metaFetches[ih.HexString()] != 0 {
+		t.Fatalf("held after the last fetch")
+	}
+
+	// the tasks are left as they are
+	task, _, err := cl.AddTorrentSpec(spec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt, held, err = e.holdMetadata(cl, spec())
+	if err != nil || held || tt != task {
+		t.Fatalf("hold of the task = %v %v, the task %v", held, err, tt == task)
+	}
+}
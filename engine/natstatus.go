@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	eglog "github.com/anacrolix/log"
+)
+
+const (
+	defaultPortCheckURL = "https://ifconfig.co/port/{port}"
+	portCheckTimeout    = 20 * time.Second
+)
+
+// results of a port check
+const (
+	PortOpen     = "open"
+	PortClosed   = "closed"
+	PortFiltered = "filtered"
+	PortUnknown  = "unknown"
+)
+
+var errNoPortCheckURL = errors.New("PortCheckURL not configured")
+
+// the client only logs the UPnP port mappings, see anacrolix/torrent portfwd.go
+var (
+	upnpMappingRe  = regexp.MustCompile(`^UPnP device at (\S+): mapping internal (\S+) port (\d+): (?:success: external port (\d+)|error: (.*))$`)
+	upnpDiscoverRe = regexp.MustCompile(`^discovered (\d+) upnp devices$`)
+)
+
+// PortMapping is the result of mapping IncomingPort on a UPnP gateway
+type PortMapping struct {
+	Gateway      string
+	Protocol     string
+	InternalPort int
+	ExternalPort int    `json:",omitempty"`
+	Error        string `json:",omitempty"`
+	At           time.Time
+}
+
+// PortCheck is the result of connecting to the port from the internet:
+// open, closed (nothing listening), filtered (listening but blocked by a
+// firewall or NAT in front) or unknown (the check failed)
+type PortCheck struct {
+	Port     int
+	PublicIP string `json:",omitempty"`
+	State    string
+	Detail   string
+	At       time.Time
+}
+
+// NATStatus tells whether the peers can connect to the client. The torrent
+// client maps the port by UPnP only, NAT-PMP isn't supported.
+type NATStatus struct {
+	IncomingPort int
+	// the port the client listens on, 0 when not running
+	ListenPort int
+	// UPnP enabled, by NoDefaultPortForwarding false
+	PortForwarding bool
+	// the UPnP gateways found, -1 before the discovery is done or without
+	// PortForwarding
+	Gateways int
+	Mappings []PortMapping
+	// the last check by CheckPort
+	Check *PortCheck `json:",omitempty"`
+	// incoming peers connected right now, the port is open if any
+	IncomingPeers int
+}
+
+type natState struct {
+	sync.Mutex
+	gateways int
+	mappings map[string]PortMapping
+	check    *PortCheck
+}
+
+// portMappingLogger records the UPnP results logged by the client before
+// passing the messages to next
+func (e *Engine) portMappingLogger(next eglog.Logger) eglog.Logger {
+	e.nat.Lock()
+	e.nat.gateways = -1
+	e.nat.mappings = nil
+	e.nat.Unlock()
+	return eglog.Logger{LoggerImpl: eglog.LoggerFunc(func(m eglog.Msg) {
+		e.observePortMapping(m.Text())
+		next.Log(m)
+	})}
+}
+
+func (e *Engine) observePortMapping(text string) {
+	if m := upnpDiscoverRe.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		e.nat.Lock()
+		e.nat.gateways = n
+		e.nat.Unlock()
+		return
+	}
+	m := upnpMappingRe.FindStringSubmatch(text)
+	if m == nil {
+		return
+	}
+	pm := PortMapping{Gateway: m[1], Protocol: m[2], Error: m[5], At: time.Now()}
+	pm.InternalPort, _ = strconv.Atoi(m[3])
+	pm.ExternalPort, _ = strconv.Atoi(m[4])
+	e.nat.Lock()
+	if e.nat.mappings == nil {
+		e.nat.mappings = map[string]PortMapping{}
+	}
+	e.nat.mappings[pm.Gateway+"/"+pm.Protocol] = pm
+	e.nat.Unlock()
+}
+
+// NATStatus returns the port mappings of the client and the last port check
+func (e *Engine) NATStatus() NATStatus {
+	e.RLock()
+	client := e.client
+	c := e.config
+	e.RUnlock()
+	s := NATStatus{IncomingPort: c.IncomingPort, PortForwarding: !c.NoDefaultPortForwarding}
+	if client != nil {
+		s.ListenPort = client.LocalPort()
+		for _, t := range client.Torrents() {
+			s.IncomingPeers += countPeerSources(t).Incoming
+		}
+	}
+	e.nat.Lock()
+	defer e.nat.Unlock()
+	s.Gateways = e.nat.gateways
+	for _, pm := range e.nat.mappings {
+		s.Mappings = append(s.Mappings, pm)
+	}
+	sort.Slice(s.Mappings, func(i, j int) bool {
+		a, b := s.Mappings[i], s.Mappings[j]
+		return a.Gateway < b.Gateway || (a.Gateway == b.Gateway && a.Protocol < b.Protocol)
+	})
+	if e.nat.check != nil {
+		pc := *e.nat.check
+		s.Check = &pc
+	}
+	return s
+}
+
+// CheckPort asks PortCheckURL to connect to the listening port from the
+// internet, from the bound address if any, and returns the NAT status with
+// the result
+func (e *Engine) CheckPort(ctx context.Context) (NATStatus, error) {
+	e.RLock()
+	client := e.client
+	c := e.config
+	e.RUnlock()
+	pc := &PortCheck{Port: c.IncomingPort, At: time.Now()}
+	if client != nil {
+		pc.Port = client.LocalPort()
+	}
+	switch {
+	case client == nil || pc.Port == 0:
+		pc.State, pc.Detail = PortClosed, "the torrent client isn't listening"
+	case c.PortCheckURL == "":
+		return e.NATStatus(), errNoPortCheckURL
+	default:
+		e.checkPort(ctx, c.PortCheckURL, pc)
+	}
+	e.nat.Lock()
+	e.nat.check = pc
+	e.nat.Unlock()
+	return e.NATStatus(), nil
+}
+
+// checkPort fills pc with the answer of the checker, the JSON of
+// ifconfig.co/port: {"ip": "1.2.3.4", "port": 50007, "reachable": true}
+func (e *Engine) checkPort(ctx context.Context, checkURL string, pc *PortCheck) {
+	ctx, cancel := context.WithTimeout(ctx, portCheckTimeout)
+	defer cancel()
+	u := strings.ReplaceAll(checkURL, "{port}", strconv.Itoa(pc.Port))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		pc.State, pc.Detail = PortUnknown, err.Error()
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := e.portCheckClient().Do(req)
+	if err != nil {
+		pc.State, pc.Detail = PortUnknown, err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	var r struct {
+		IP        string `json:"ip"`
+		Reachable bool   `json:"reachable"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		pc.State, pc.Detail = PortUnknown, fmt.Sprintf("%s answered %s", req.URL.Host, resp.Status)
+		return
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&r); err != nil {
+		pc.State, pc.Detail = PortUnknown, fmt.Sprintf("%s answered: %v", req.URL.Host, err)
+		return
+	}
+	pc.PublicIP = r.IP
+	if r.Reachable {
+		pc.State, pc.Detail = PortOpen, fmt.Sprintf("port %d reachable from the internet", pc.Port)
+		return
+	}
+	pc.State = PortFiltered
+	pc.Detail = fmt.Sprintf("port %d listening but not reachable from the internet, forward it to this host on the router", pc.Port)
+	if c := e.Config(); c.NoDefaultPortForwarding {
+		pc.Detail += " or enable UPnP (NoDefaultPortForwarding: false)"
+	}
+}
+
+// portCheckClient connects from the address the peers are bound to, the
+// checker tests the IP it sees
+func (e *Engine) portCheckClient() *http.Client {
+	dialer := &net.Dialer{Timeout: portCheckTimeout}
+	e.bind.Lock()
+	if e.bind.ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: e.bind.ip}
+	}
+	e.bind.Unlock()
+	tr := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: portCheckTimeout}
+	return &http.Client{Transport: tr, Timeout: portCheckTimeout}
+}
+
+func (c *Config) checkPortCheckURL() error {
+	if c.PortCheckURL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.PortCheckURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		!strings.Contains(c.PortCheckURL, "{port}") {
+		return fmt.Errorf("Invalid PortCheckURL %q, eg: %s", c.PortCheckURL, defaultPortCheckURL)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestObservePortMapping(t *testing.T) {
+	e := &Engine{}
+	e.portMappingLogger(torrentLogger(false))
+	e.observePortMapping("discovered 1 upnp devices")
+	e.observePortMapping("UPnP device at 192.168.1.1: mapping internal TCP port 50007: success: external port 50007")
+	e.observePortMapping("UPnP device at 192.168.1.1: mapping internal UDP port 50007: error: 718 ConflictInMappingEntry")
+	e.observePortMapping("unrelated")
+
+	s := e.NATStatus()
+	if s.Gateways != 1 || len(s.Mappings) != 2 {
+		t.Fatalf("NATStatus() = %+v, want 1 gateway and 2 mappings", s)
+	}
+	tcp, udp := s.Mappings[0], s.Mappings[1]
+	if tcp.Protocol != "TCP" || tcp.ExternalPort != 50007 || tcp.Error != "" {
+		t.Errorf("TCP mapping = %+v", tcp)
+	}
+	if udp.Protocol != "UDP" || udp.ExternalPort != 0 || udp.Error != "718 ConflictInMappingEntry" {
+		t.Errorf("UDP mapping = %+v", udp)
+	}
+}
+
+func TestCheckPort(t *testing.T) {
+	reachable := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/port/50007" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"ip":"203.0.113.7","port":50007,"reachable":%v}`, reachable)
+	}))
+	defer srv.Close()
+
+	e := &Engine{config: Config{NoDefaultPortForwarding: true}}
+	for _, tc := range []struct {
+		url       string
+		reachable bool
+		want      string
+	}{
+		{srv.URL + "/port/{port}", true, PortOpen},
+		{srv.URL + "/port/{port}", false, PortFiltered},
+		{srv.URL + "/other/{port}", true, PortUnknown},
+	} {
+		reachable = tc.reachable
+		pc := &PortCheck{Port: 50007}
+		e.checkPort(context.Background(), tc.url, pc)
+		if pc.State != tc.want {
+			t.Errorf("%s reachable %v: State = %s (%s), want %s", tc.url, tc.reachable, pc.State, pc.Detail, tc.want)
+		}
+		if pc.State == PortOpen && pc.PublicIP != "203.0.113.7" {
+			t.Errorf("PublicIP = %q", pc.PublicIP)
+		}
+	}
+
+	c := Config{PortCheckURL: "https://example.com/port"}
+	if err := c.checkPortCheckURL(); err == nil {
+		t.Error("PortCheckURL without {port} accepted")
+	}
+}
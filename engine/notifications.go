@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine/notify"
+)
+
+// EventLowDisk is published when a task is refused for the free space or the
+// downloads are paused by LowDiskSpace, at most once per lowDiskInterval
+const EventLowDisk = "lowdisk"
+
+const lowDiskInterval = 10 * time.Minute
+
+// defaultNotifyEvents are notified by the Notifications lines without events
+var defaultNotifyEvents = []string{EventCompleted, EventError, EventLowDisk}
+
+// notifyRule is a line of Notifications, eg:
+//  completed,lowdisk => ntfy://ntfy.sh/mytopic
+// the events are comma separated, * for all, omitted for defaultNotifyEvents
+type notifyRule struct {
+	events   map[string]bool
+	provider notify.Provider
+}
+
+func parseNotifications(s string) ([]notifyRule, error) {
+	var rules []notifyRule
+	for _, line := range common.SplitLines(s) {
+		events, rawurl := strings.Join(defaultNotifyEvents, ","), line
+		if parts := strings.SplitN(line, "=>", 2); len(parts) == 2 {
+			events, rawurl = parts[0], parts[1]
+		}
+		p, err := notify.Parse(rawurl)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Notifications line: %w", err)
+		}
+		r := notifyRule{events: make(map[string]bool), provider: p}
+		for _, ev := range strings.Split(events, ",") {
+			ev = strings.TrimSpace(ev)
+			if ev == "*" {
+				for _, typ := range lifecycleEvents {
+					r.events[typ] = true
+				}
+				continue
+			}
+			if !isLifecycleEvent(ev) {
+				return nil, fmt.Errorf("Notifications %s: unknown event %q", p.Name(), ev)
+			}
+			r.events[ev] = true
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// notifyMessage is the notification of the event
+func notifyMessage(ev Event) notify.Message {
+	m := notify.Message{Body: ev.Name, Priority: notify.PriorityNormal, Tag: ev.Type}
+	switch ev.Type {
+	case EventCompleted:
+		m.Title = "Download completed"
+	case EventError:
+		m.Title, m.Priority = "Task error", notify.PriorityHigh
+	case EventAlert:
+		m.Title = "Task alert"
+	case EventLowDisk:
+		m.Title, m.Priority = "Low disk space", notify.PriorityHigh
+	default:
+		m.Title, m.Priority = "Task "+ev.Type, notify.PriorityLow
+	}
+	detail := ev.Error
+	if detail == "" {
+		detail = ev.Message
+	}
+	switch {
+	case ev.Name == "":
+		// of no task, eg: the downloads paused by LowDiskSpace
+		m.Body = detail
+	case detail != "":
+		m.Body = fmt.Sprintf("%s: %s", ev.Name, detail)
+	}
+	return m
+}
+
+// notifyRoutine sends the lifecycle events to the providers of Notifications
+func (e *Engine) notifyRoutine(events <-chan Event) {
+	for ev := range events {
+		c := e.Config()
+		if c.Notifications == "" {
+			continue
+		}
+		rules, err := parseNotifications(c.Notifications)
+		if err != nil {
+			log.Warn("[Notify]", err)
+			continue
+		}
+		for _, r := range rules {
+			if !r.events[ev.Type] {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), notify.Timeout)
+			err := r.provider.Send(ctx, notifyMessage(ev))
+			cancel()
+			if err != nil {
+				log.Warnf("[Notify] %s %s %s: %v", ev.Type, ev.InfoHash, r.provider.Name(), err)
+			}
+			// the task refused for the space may not be added
+			if ev.Type != EventLowDisk {
+				e.recordHook(ev.InfoHash, ev.Name, fmt.Sprintf("notify %s %s", ev.Type, r.provider.Name()), err)
+			}
+		}
+	}
+}
+
+// lowDisk publishes an EventLowDisk for the task refused by err, if an
+// ErrDiskSpace and none was published within lowDiskInterval
+func (e *Engine) lowDisk(infohash, name string, err error) {
+	if !errors.Is(err, ErrDiskSpace) {
+		return
+	}
+	e.lowDiskState.Lock()
+	if time.Since(e.lowDiskState.notified) < lowDiskInterval {
+		e.lowDiskState.Unlock()
+		return
+	}
+	e.lowDiskState.notified = time.Now()
+	e.lowDiskState.Unlock()
+	e.bus.publish(Event{
+		Type:     EventLowDisk,
+		InfoHash: infohash,
+		Name:     name,
+		Message:  err.Error(),
+		Time:     time.Now(),
+	})
+}
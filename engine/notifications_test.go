@@ -0,0 +1,28 @@
+package engine
+
+import "testing"
+
+func Test_parseNotifications(t *testing.T) {
+	rules, err := parseNotifications(`
+ntfy://ntfy.sh/downloads
+# errors only
+error, lowdisk => pushover://ukey/?token=abc
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules", len(rules))
+	}
+	if !rules[0].events[EventCompleted] || !rules[0].events[EventLowDisk] || rules[0].events[EventAdded] {
+		t.Errorf("default events = %v", rules[0].events)
+	}
+	if !rules[1].events[EventError] || rules[1].events[EventCompleted] {
+		t.Errorf("events = %v", rules[1].events)
+	}
+	for _, s := range []string{"done => ntfy://ntfy.sh/a", "mailto:me@lan"} {
+		if _, err := parseNotifications(s); err == nil {
+			t.Errorf("parseNotifications(%q) no error", s)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// email sends the messages by SMTP, over STARTTLS when the server offers it
+// or TLS from the start for smtps
+type email struct {
+	name     string
+	addr     string
+	host     string
+	implicit bool
+	user     *url.Userinfo
+	from     string
+	to       []string
+}
+
+func newEmail(u *url.URL) (*email, error) {
+	e := &email{
+		name:     redacted(u),
+		addr:     u.Host,
+		host:     u.Hostname(),
+		implicit: strings.EqualFold(u.Scheme, "smtps"),
+		user:     u.User,
+	}
+	if u.Port() == "" {
+		port := "587"
+		if e.implicit {
+			port = "465"
+		}
+		e.addr = net.JoinHostPort(e.host, port)
+	}
+	q := u.Query()
+	from, err := mail.ParseAddress(q.Get("from"))
+	if err != nil {
+		return nil, fmt.Errorf("smtp: from: %w", err)
+	}
+	e.from = from.Address
+	for _, to := range strings.Split(q.Get("to"), ",") {
+		if to = strings.TrimSpace(to); to == "" {
+			continue
+		}
+		a, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: to: %w", err)
+		}
+		e.to = append(e.to, a.Address)
+	}
+	if len(e.to) == 0 {
+		return nil, errors.New("smtp: no recipient")
+	}
+	return e, nil
+}
+
+func (e *email) Name() string { return e.name }
+
+// message is the mail of m, a plain text one
+func (e *email) message(m Message, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	if m.Priority == PriorityHigh {
+		b.WriteString("X-Priority: 1\r\n")
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n")
+	b.WriteString(body)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+func (e *email) Send(ctx context.Context, m Message) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, Timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", e.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: e.host}
+	if e.implicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !e.implicit {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if pass, ok := e.user.Password(); ok {
+		if err := c.Auth(smtp.PlainAuth("", e.user.Username(), pass, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.message(m, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
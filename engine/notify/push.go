@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ntfy posts to a topic of a ntfy server
+type ntfy struct {
+	name  string
+	url   string
+	user  *url.Userinfo
+	token string
+}
+
+func newNtfy(u *url.URL) (*ntfy, error) {
+	b := httpBase(u)
+	if strings.Trim(b.Path, "/") == "" {
+		return nil, errors.New("ntfy: no topic")
+	}
+	return &ntfy{name: redacted(u), url: b.String(), user: u.User, token: u.Query().Get("token")}, nil
+}
+
+func (n *ntfy) Name() string { return n.name }
+
+// ntfyPriority maps the priorities to those of ntfy, 1 to 5
+var ntfyPriority = map[int]string{PriorityLow: "2", PriorityNormal: "3", PriorityHigh: "4"}
+
+func (n *ntfy) Send(ctx context.Context, m Message) error {
+	req, err := http.NewRequest("POST", n.url, strings.NewReader(m.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", m.Title)
+	req.Header.Set("Priority", ntfyPriority[m.Priority])
+	if m.Tag != "" {
+		req.Header.Set("Tags", m.Tag)
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	} else if pass, ok := n.user.Password(); ok {
+		req.SetBasicAuth(n.user.Username(), pass)
+	}
+	return do(ctx, req)
+}
+
+// gotify posts to the message API of a Gotify server
+type gotify struct {
+	name  string
+	url   string
+	token string
+}
+
+func newGotify(u *url.URL) (*gotify, error) {
+	token := u.Query().Get("token")
+	if token == "" {
+		return nil, errors.New("gotify: no token")
+	}
+	b := httpBase(u)
+	b.Path += "/message"
+	return &gotify{name: redacted(u), url: b.String(), token: token}, nil
+}
+
+func (g *gotify) Name() string { return g.name }
+
+// gotifyPriority maps the priorities to those of Gotify, 0 to 10
+var gotifyPriority = map[int]int{PriorityLow: 2, PriorityNormal: 5, PriorityHigh: 8}
+
+func (g *gotify) Send(ctx context.Context, m Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    m.Title,
+		"message":  m.Body,
+		"priority": gotifyPriority[m.Priority],
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", g.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.token)
+	return do(ctx, req)
+}
+
+const pushoverAPI = "https://api.pushover.net/1/messages.json"
+
+// pushover posts to the Pushover API, the user key as the host of the URL
+type pushover struct {
+	name   string
+	api    string
+	user   string
+	token  string
+	device string
+}
+
+func newPushover(u *url.URL) (*pushover, error) {
+	q := u.Query()
+	if q.Get("token") == "" {
+		return nil, errors.New("pushover: no token")
+	}
+	return &pushover{
+		name:   redacted(u),
+		api:    pushoverAPI,
+		user:   u.Host,
+		token:  q.Get("token"),
+		device: q.Get("device"),
+	}, nil
+}
+
+func (p *pushover) Name() string { return p.name }
+
+func (p *pushover) Send(ctx context.Context, m Message) error {
+	form := url.Values{
+		"token":    {p.token},
+		"user":     {p.user},
+		"title":    {m.Title},
+		"message":  {m.Body},
+		"priority": {strconv.Itoa(m.Priority)},
+	}
+	if p.device != "" {
+		form.Set("device", p.device)
+	}
+	req, err := http.NewRequest("POST", p.api, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(ctx, req)
+}
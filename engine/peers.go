@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// rates of a peer are sampled at most once in the interval
+const peerRateInterval = 2 * time.Second
+
+// PeerInfo is a connected peer of a task
+type PeerInfo struct {
+	Addr    string
+	Client  string
+	Network string
+	// qBittorrent alike: I incoming, T tracker, H DHT, X PEX, P uTP, E prefers encryption,
+	// e encrypted handshake
+	Flags string
+	// percent of the pieces the peer has
+	Progress     float32
+	DownloadRate float32
+	// the upload is counted by the bytes requested by the peer
+	UploadRate float32
+	Downloaded int64
+	Uploaded   int64
+	Country    string `json:",omitempty"`
+	ASN        uint   `json:",omitempty"`
+	Org        string `json:",omitempty"`
+}
+
+type peerCounter struct {
+	downloaded, uploaded int64
+	// last sample of the rates
+	at                     time.Time
+	sampledDown, sampledUp int64
+	downRate, upRate       float32
+	// the task and where the peer is, set on the handshake
+	ih  string
+	geo peerGeo
+}
+
+// peerCounterMap counts the bytes of the peer connections with the client callbacks
+type peerCounterMap struct {
+	sync.Mutex
+	m map[*torrent.PeerConn]*peerCounter
+	// the traffic of the closed connections by task and in all
+	closed       map[string]map[peerGeo]geoTraffic
+	closedGlobal map[peerGeo]geoTraffic
+}
+
+// counter returns the counter of pc, pm locked
+func (pm *peerCounterMap) counter(pc *torrent.PeerConn) *peerCounter {
+	if pm.m == nil {
+		pm.m = make(map[*torrent.PeerConn]*peerCounter)
+	}
+	c, ok := pm.m[pc]
+	if !ok {
+		c = &peerCounter{at: time.Now()}
+		pm.m[pc] = c
+	}
+	return c
+}
+
+// attach sets the task and the place of the peer for the geo stats
+func (pm *peerCounterMap) attach(pc *torrent.PeerConn, ih string, g peerGeo) {
+	pm.Lock()
+	defer pm.Unlock()
+	c := pm.counter(pc)
+	c.ih, c.geo = ih, g
+}
+
+func (pm *peerCounterMap) add(pc *torrent.PeerConn, down, up int64) {
+	pm.Lock()
+	defer pm.Unlock()
+	c := pm.counter(pc)
+	c.downloaded += down
+	c.uploaded += up
+}
+
+// remove drops the counter of pc, its bytes are kept for the geo stats
+func (pm *peerCounterMap) remove(pc *torrent.PeerConn) {
+	pm.Lock()
+	defer pm.Unlock()
+	c, ok := pm.m[pc]
+	if !ok {
+		return
+	}
+	delete(pm.m, pc)
+	if c.ih == "" || c.downloaded+c.uploaded == 0 {
+		return
+	}
+	if pm.closed == nil {
+		pm.closed = make(map[string]map[peerGeo]geoTraffic)
+		pm.closedGlobal = make(map[peerGeo]geoTraffic)
+	}
+	if pm.closed[c.ih] == nil {
+		pm.closed[c.ih] = make(map[peerGeo]geoTraffic)
+	}
+	for _, m := range []map[peerGeo]geoTraffic{pm.closed[c.ih], pm.closedGlobal} {
+		t := m[c.geo]
+		t.downloaded += c.downloaded
+		t.uploaded += c.uploaded
+		m[c.geo] = t
+	}
+}
+
+// dropTask forgets the geo stats of the deleted task, they stay in the global ones
+func (pm *peerCounterMap) dropTask(ih string) {
+	pm.Lock()
+	defer pm.Unlock()
+	delete(pm.closed, ih)
+}
+
+// sample returns the counter of pc with the rates updated
+func (pm *peerCounterMap) sample(pc *torrent.PeerConn, now time.Time) peerCounter {
+	pm.Lock()
+	defer pm.Unlock()
+	c, ok := pm.m[pc]
+	if !ok {
+		return peerCounter{}
+	}
+	if d := now.Sub(c.at); d >= peerRateInterval {
+		c.downRate = float32(float64(c.downloaded-c.sampledDown) / d.Seconds())
+		c.upRate = float32(float64(c.uploaded-c.sampledUp) / d.Seconds())
+		c.sampledDown, c.sampledUp, c.at = c.downloaded, c.uploaded, now
+	}
+	return *c
+}
+
+// setPeerCallbacks counts the bytes of the peers for the rates
+func (e *Engine) setPeerCallbacks(cb *torrent.Callbacks) {
+	cb.ReceivedUsefulData = append(cb.ReceivedUsefulData, func(ev torrent.ReceivedUsefulDataEvent) {
+		if pc, ok := ev.Peer.TryAsPeerConn(); ok {
+			e.peerCounters.add(pc, int64(len(ev.Message.Piece)), 0)
+		}
+	})
+	cb.ReadMessage = func(pc *torrent.PeerConn, msg *pp.Message) {
+		if msg.Type == pp.Request {
+			e.peerCounters.add(pc, 0, int64(msg.Length))
+		}
+	}
+	cb.PeerConnClosed = e.peerCounters.remove
+	prev := cb.CompletedHandshake
+	cb.CompletedHandshake = func(pc *torrent.PeerConn, ih torrent.InfoHash) {
+		if prev != nil {
+			prev(pc, ih)
+		}
+		e.peerCounters.attach(pc, ih.HexString(), e.lookupPeerGeo(pc.RemoteAddr.String()))
+	}
+}
+
+// TorrentPeers returns the connected peers of the task, fastest first
+func (e *Engine) TorrentPeers(infohash string) ([]PeerInfo, error) {
+	tt, err := e.loadedTorrent(infohash)
+	if err != nil {
+		return nil, err
+	}
+	numPieces := tt.NumPieces()
+	now := time.Now()
+	peers := []PeerInfo{}
+	for _, pc := range tt.PeerConns() {
+		p := PeerInfo{
+			Addr:    pc.RemoteAddr.String(),
+			Network: pc.Network,
+			Flags:   peerFlags(pc),
+		}
+		if name, ok := pc.PeerClientName.Load().(string); ok {
+			p.Client = name
+		}
+		if numPieces > 0 {
+			p.Progress = percent(int64(pc.PeerPieces().GetCardinality()), int64(numPieces))
+		}
+		c := e.peerCounters.sample(pc, now)
+		p.Downloaded, p.Uploaded = c.downloaded, c.uploaded
+		p.DownloadRate, p.UploadRate = c.downRate, c.upRate
+		g := c.geo
+		if c.ih == "" {
+			g = e.lookupPeerGeo(p.Addr)
+		}
+		p.Country, p.ASN, p.Org = g.country, g.asn, g.org
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].DownloadRate+peers[i].UploadRate > peers[j].DownloadRate+peers[j].UploadRate
+	})
+	return peers, nil
+}
+
+func peerFlags(pc *torrent.PeerConn) string {
+	var f []byte
+	switch pc.Discovery {
+	case torrent.PeerSourceIncoming:
+		f = append(f, 'I')
+	case torrent.PeerSourceTracker:
+		f = append(f, 'T')
+	case torrent.PeerSourceDhtGetPeers, torrent.PeerSourceDhtAnnouncePeer:
+		f = append(f, 'H')
+	case torrent.PeerSourcePex:
+		f = append(f, 'X')
+	}
+	if pc.Network == "utp" {
+		f = append(f, 'P')
+	}
+	if pc.PeerPrefersEncryption {
+		f = append(f, 'E')
+	}
+	if encrypted, _ := headerEncrypted(pc); encrypted {
+		f = append(f, 'e')
+	}
+	return string(f)
+}
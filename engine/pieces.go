@@ -0,0 +1,88 @@
+package engine
+
+import "github.com/anacrolix/torrent"
+
+// states of the pieces in PieceRun
+const (
+	PieceHave        = "h"
+	PieceDownloading = "d"
+	PieceMissing     = "m"
+)
+
+// PieceStates is the run-length encoded piece map of a task
+type PieceStates struct {
+	NumPieces   int
+	PieceLength int64
+	Runs        []PieceRun
+}
+
+// PieceRun is a series of consecutive pieces in the same state,
+// available from the same number of connected peers
+type PieceRun struct {
+	State        string
+	Availability int
+	Length       int
+}
+
+// PieceStates returns the piece map of the task for rendering
+func (torrent *Torrent) PieceStates() (PieceStates, error) {
+	torrent.Lock()
+	t, loaded := torrent.t, torrent.Loaded
+	torrent.Unlock()
+	if !loaded || t == nil || t.Info() == nil {
+		return PieceStates{}, errNotLoaded
+	}
+
+	n := t.NumPieces()
+	states := make([]string, 0, n)
+	for _, run := range t.PieceStateRuns() {
+		s := pieceState(run.PieceState)
+		for i := 0; i < run.Length; i++ {
+			states = append(states, s)
+		}
+	}
+
+	avail := make([]int, n)
+	for _, pc := range t.PeerConns() {
+		pc.PeerPieces().Iterate(func(i uint32) bool {
+			if int(i) >= n {
+				return false
+			}
+			avail[i]++
+			return true
+		})
+	}
+
+	return PieceStates{
+		NumPieces:   n,
+		PieceLength: t.Info().PieceLength,
+		Runs:        encodePieceRuns(states, avail),
+	}, nil
+}
+
+func pieceState(ps torrent.PieceState) string {
+	switch {
+	case ps.Complete:
+		return PieceHave
+	case ps.Partial || ps.Checking:
+		return PieceDownloading
+	}
+	return PieceMissing
+}
+
+// encodePieceRuns run-length encodes the pieces by state and availability
+func encodePieceRuns(states []string, avail []int) []PieceRun {
+	runs := []PieceRun{}
+	for i, s := range states {
+		var a int
+		if i < len(avail) {
+			a = avail[i]
+		}
+		if l := len(runs) - 1; l >= 0 && runs[l].State == s && runs[l].Availability == a {
+			runs[l].Length++
+			continue
+		}
+		runs = append(runs, PieceRun{State: s, Availability: a, Length: 1})
+	}
+	return runs
+}
@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_encodePieceRuns(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []string
+		avail  []int
+		want   []PieceRun
+	}{
+		{"empty", nil, nil, []PieceRun{}},
+		{"same", []string{"h", "h", "h"}, []int{0, 0, 0}, []PieceRun{{"h", 0, 3}}},
+		{"state", []string{"h", "d", "m", "m"}, []int{1, 1, 1, 1}, []PieceRun{{"h", 1, 1}, {"d", 1, 1}, {"m", 1, 2}}},
+		{"availability", []string{"m", "m", "m"}, []int{2, 2, 3}, []PieceRun{{"m", 2, 2}, {"m", 3, 1}}},
+		{"short avail", []string{"m", "m"}, []int{1}, []PieceRun{{"m", 1, 1}, {"m", 0, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodePieceRuns(tt.states, tt.avail); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("encodePieceRuns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
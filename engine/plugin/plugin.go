@@ -0,0 +1,477 @@
+// Package plugin runs the external plugins extending the engine, processes
+// speaking JSON lines over their stdin and stdout.
+//
+// Each line is a message. The engine sends the requests
+//
+//	{"id":1,"method":"search","params":{"query":"ubuntu","page":1}}
+//
+// and the plugin answers each of them once, in any order
+//
+//	{"id":1,"result":[{"name":"ubuntu.iso","magnet":"magnet:?xt=..."}]}
+//	{"id":1,"error":"site down"}
+//
+// A message without id is a notification, never answered. The stderr of the
+// plugin goes to the log.
+//
+// The first request is init, the result of which names the plugin and its
+// capabilities:
+//
+//	{"name":"mysite","version":"1.0","capabilities":["search","notify","postprocess"]}
+//
+// Then by the capabilities:
+//   - search: params {"query","page"}, result a list of SearchResult
+//   - notify: notifications of the lifecycle events of the tasks
+//   - postprocess: params the completed task, result an optional {"message"}
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// the capabilities of a plugin
+const (
+	CapSearch      = "search"
+	CapNotify      = "notify"
+	CapPostProcess = "postprocess"
+)
+
+const (
+	// a crashed plugin is started again on a call after this delay
+	restartDelay = 10 * time.Second
+	initTimeout  = 10 * time.Second
+	// longest message line read
+	maxLine = 4 << 20
+)
+
+var (
+	ErrNotRunning = errors.New("plugin not running")
+	ErrExited     = errors.New("plugin exited")
+)
+
+// Info describes a plugin, from its answer to init
+type Info struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Status is a plugin as listed by the API
+type Status struct {
+	Info
+	Command   []string
+	Running   bool
+	Pid       int       `json:",omitempty"`
+	StartedAt time.Time `json:",omitempty"`
+	Error     string    `json:",omitempty"`
+}
+
+// SearchResult is a result of a search, as the results of the scraper
+// providers: a magnet, an infohash or a torrent URL is needed to add it
+type SearchResult struct {
+	Name     string `json:"name"`
+	URL      string `json:"url,omitempty"`
+	Magnet   string `json:"magnet,omitempty"`
+	InfoHash string `json:"infohash,omitempty"`
+	Torrent  string `json:"torrent,omitempty"`
+	Size     string `json:"size,omitempty"`
+	Seeds    string `json:"seeds,omitempty"`
+	Peers    string `json:"peers,omitempty"`
+}
+
+type message struct {
+	ID     uint64          `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params interface{}     `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Plugin is an external plugin process, started again when it crashed
+type Plugin struct {
+	Command []string
+	// Logf receives the stderr lines and the exits of the plugin
+	Logf func(format string, args ...interface{})
+
+	mu        sync.Mutex
+	info      Info
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	done      chan struct{}
+	pending   map[uint64]chan message
+	nextID    uint64
+	startedAt time.Time
+	err       error
+	closed    bool
+}
+
+// New returns the plugin running the command, not started yet
+func New(command []string, logf func(format string, args ...interface{})) *Plugin {
+	p := &Plugin{
+		Command: command,
+		Logf:    logf,
+		pending: make(map[uint64]chan message),
+	}
+	p.info.Name = filepath.Base(command[0])
+	return p
+}
+
+func (p *Plugin) logf(format string, args ...interface{}) {
+	if p.Logf != nil {
+		p.Logf(format, args...)
+	}
+}
+
+// Info returns the name and the capabilities of the plugin, the base name of
+// the command until it answered init
+func (p *Plugin) Info() Info {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.info
+}
+
+// Has tells whether the plugin declared the capability
+func (p *Plugin) Has(capability string) bool {
+	for _, c := range p.Info().Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the state of the plugin process
+func (p *Plugin) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := Status{
+		Info:      p.info,
+		Command:   p.Command,
+		Running:   p.cmd != nil,
+		StartedAt: p.startedAt,
+	}
+	if p.cmd != nil {
+		s.Pid = p.cmd.Process.Pid
+	}
+	if p.err != nil {
+		s.Error = p.err.Error()
+	}
+	return s
+}
+
+// Start starts the plugin and asks for its capabilities
+func (p *Plugin) Start(ctx context.Context) error {
+	p.mu.Lock()
+	err := p.start()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.init(ctx)
+}
+
+func (p *Plugin) init(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, initTimeout)
+	defer cancel()
+	var info Info
+	if err := p.call(ctx, "init", struct{}{}, &info); err != nil {
+		p.setErr(fmt.Errorf("init: %w", err))
+		return err
+	}
+	p.mu.Lock()
+	if info.Name != "" {
+		p.info.Name = info.Name
+	}
+	p.info.Version = info.Version
+	p.info.Capabilities = info.Capabilities
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Plugin) setErr(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// start must hold p.mu
+func (p *Plugin) start() error {
+	if p.closed {
+		return ErrNotRunning
+	}
+	if p.cmd != nil {
+		return nil
+	}
+	cmd := exec.Command(p.Command[0], p.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	p.startedAt = time.Now()
+	if err := cmd.Start(); err != nil {
+		p.err = err
+		return err
+	}
+	p.cmd, p.stdin, p.err = cmd, stdin, nil
+	p.done = make(chan struct{})
+	logged := make(chan struct{})
+	go p.logStderr(stderr, logged)
+	go p.readRoutine(cmd, stdout, logged, p.done)
+	return nil
+}
+
+func (p *Plugin) logStderr(r io.Reader, logged chan struct{}) {
+	defer close(logged)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		p.logf("%s: %s", p.Info().Name, s.Text())
+	}
+}
+
+// readRoutine dispatches the answers until the plugin exits, then fails the
+// pending calls
+func (p *Plugin) readRoutine(cmd *exec.Cmd, stdout io.Reader, logged, done chan struct{}) {
+	s := bufio.NewScanner(stdout)
+	s.Buffer(make([]byte, 64<<10), maxLine)
+	for s.Scan() {
+		var m message
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil || m.ID == 0 {
+			p.logf("%s: invalid message %.100q", p.Info().Name, s.Text())
+			continue
+		}
+		p.mu.Lock()
+		ch := p.pending[m.ID]
+		delete(p.pending, m.ID)
+		p.mu.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+	}
+	// unblock a plugin stuck writing
+	io.Copy(ioutil.Discard, stdout)
+	<-logged
+	err := cmd.Wait()
+	if err == nil {
+		err = ErrExited
+	}
+	p.mu.Lock()
+	if !p.closed {
+		p.logf("%s: %v", p.info.Name, err)
+		p.err = err
+	}
+	p.cmd, p.stdin = nil, nil
+	p.pending = make(map[uint64]chan message)
+	p.mu.Unlock()
+	close(done)
+}
+
+// Call sends a request and decodes its result into result, if not nil. A
+// crashed plugin is started again first, at most once per restartDelay.
+func (p *Plugin) Call(ctx context.Context, method string, params, result interface{}) error {
+	p.mu.Lock()
+	restarted := false
+	if p.cmd == nil {
+		if time.Since(p.startedAt) < restartDelay {
+			err := p.err
+			p.mu.Unlock()
+			if err == nil {
+				err = ErrNotRunning
+			}
+			return err
+		}
+		if err := p.start(); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+		restarted = true
+	}
+	p.mu.Unlock()
+	if restarted {
+		if err := p.init(ctx); err != nil {
+			return err
+		}
+	}
+	return p.call(ctx, method, params, result)
+}
+
+func (p *Plugin) call(ctx context.Context, method string, params, result interface{}) error {
+	p.mu.Lock()
+	if p.cmd == nil {
+		p.mu.Unlock()
+		return ErrNotRunning
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan message, 1)
+	p.pending[id] = ch
+	done := p.done
+	err := p.write(message{ID: id, Method: method, Params: params})
+	p.mu.Unlock()
+	if err != nil {
+		p.forget(id)
+		return err
+	}
+
+	select {
+	case m := <-ch:
+		if m.Error != "" {
+			return errors.New(m.Error)
+		}
+		if result == nil || len(m.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(m.Result, result)
+	case <-done:
+		return ErrExited
+	case <-ctx.Done():
+		p.forget(id)
+		return ctx.Err()
+	}
+}
+
+func (p *Plugin) forget(id uint64) {
+	p.mu.Lock()
+	delete(p.pending, id)
+	p.mu.Unlock()
+}
+
+// Notify sends a notification, dropped if the plugin isn't running
+func (p *Plugin) Notify(method string, params interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return ErrNotRunning
+	}
+	return p.write(message{Method: method, Params: params})
+}
+
+// write must hold p.mu
+func (p *Plugin) write(m message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = p.stdin.Write(append(b, '\n'))
+	return err
+}
+
+// Close stops the plugin: its stdin is closed, it's killed if still running
+// after the timeout
+func (p *Plugin) Close(timeout time.Duration) {
+	p.mu.Lock()
+	p.closed = true
+	cmd, done := p.cmd, p.done
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	p.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// Search asks the plugin for the results of the query
+func (p *Plugin) Search(ctx context.Context, query string, page int) ([]SearchResult, error) {
+	var results []SearchResult
+	err := p.Call(ctx, "search", struct {
+		Query string `json:"query"`
+		Page  int    `json:"page"`
+	}{query, page}, &results)
+	return results, err
+}
+
+// Manager holds the plugins of the engine
+type Manager struct {
+	plugins []*Plugin
+}
+
+// NewManager returns the plugins running the commands, not started yet
+func NewManager(commands [][]string, logf func(format string, args ...interface{})) *Manager {
+	m := &Manager{}
+	for _, c := range commands {
+		m.plugins = append(m.plugins, New(c, logf))
+	}
+	return m
+}
+
+// Start starts the plugins, a plugin failing is logged and left stopped
+// until it's called
+func (m *Manager) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range m.plugins {
+		wg.Add(1)
+		go func(p *Plugin) {
+			defer wg.Done()
+			if err := p.Start(ctx); err != nil {
+				p.logf("%s: %v", p.Info().Name, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// Get returns the plugin by its name, nil if none
+func (m *Manager) Get(name string) *Plugin {
+	for _, p := range m.plugins {
+		if p.Info().Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// With returns the plugins with the capability
+func (m *Manager) With(capability string) []*Plugin {
+	var ps []*Plugin
+	for _, p := range m.plugins {
+		if p.Has(capability) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// Statuses lists the plugins in the configured order
+func (m *Manager) Statuses() []Status {
+	ss := make([]Status, 0, len(m.plugins))
+	for _, p := range m.plugins {
+		ss = append(ss, p.Status())
+	}
+	return ss
+}
+
+// Close stops the plugins
+func (m *Manager) Close(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, p := range m.plugins {
+		wg.Add(1)
+		go func(p *Plugin) {
+			defer wg.Done()
+			p.Close(timeout)
+		}(p)
+	}
+	wg.Wait()
+}
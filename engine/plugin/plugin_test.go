@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// the test binary is the plugin when run with this set
+const helperEnv = "PLUGIN_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) == "1" {
+		helper()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// helper is a plugin searching, failing on "fail" and exiting on "crash"
+func helper() {
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		var req struct {
+			ID     uint64
+			Method string
+			Params struct {
+				Query string
+				Page  int
+			}
+		}
+		json.Unmarshal(s.Bytes(), &req)
+		var res interface{}
+		var errMsg string
+		switch req.Method {
+		case "init":
+			res = Info{Name: "helper", Version: "1.0", Capabilities: []string{CapSearch}}
+		case "search":
+			switch req.Params.Query {
+			case "fail":
+				errMsg = "site down"
+			case "crash":
+				fmt.Fprintln(os.Stderr, "crashing")
+				os.Exit(3)
+			default:
+				res = []SearchResult{{Name: fmt.Sprintf("%s %d", req.Params.Query, req.Params.Page), InfoHash: "abc"}}
+			}
+		default:
+			continue
+		}
+		b, _ := json.Marshal(struct {
+			ID     uint64      `json:"id"`
+			Result interface{} `json:"result,omitempty"`
+			Error  string      `json:"error,omitempty"`
+		}{req.ID, res, errMsg})
+		fmt.Println(string(b))
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	os.Setenv(helperEnv, "1")
+	defer os.Unsetenv(helperEnv)
+	m := NewManager([][]string{{os.Args[0]}}, t.Logf)
+	defer m.Close(time.Second)
+	ctx := context.Background()
+	m.Start(ctx)
+
+	p := m.Get("helper")
+	if p == nil {
+		t.Fatalf("plugins = %+v", m.Statuses())
+	}
+	if len(m.With(CapSearch)) != 1 || len(m.With(CapNotify)) != 0 {
+		t.Errorf("capabilities = %v", p.Info().Capabilities)
+	}
+	results, err := p.Search(ctx, "ubuntu", 2)
+	if err != nil || len(results) != 1 || results[0].Name != "ubuntu 2" {
+		t.Errorf("Search() = %+v, %v", results, err)
+	}
+	if _, err := p.Search(ctx, "fail", 1); err == nil || err.Error() != "site down" {
+		t.Errorf("Search(fail) error = %v", err)
+	}
+	if err := p.Notify("notify", struct{}{}); err != nil {
+		t.Errorf("Notify() = %v", err)
+	}
+
+	if _, err := p.Search(ctx, "crash", 1); err != ErrExited {
+		t.Errorf("Search(crash) error = %v", err)
+	}
+	// not restarted before restartDelay
+	if _, err := p.Search(ctx, "ubuntu", 1); err == nil {
+		t.Error("Search() after crash succeeded")
+	}
+	p.mu.Lock()
+	p.startedAt = p.startedAt.Add(-restartDelay)
+	p.mu.Unlock()
+	if _, err := p.Search(ctx, "ubuntu", 1); err != nil {
+		t.Errorf("Search() after restart = %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine/plugin"
+)
+
+const (
+	// a search of a plugin is given up after this long
+	pluginSearchTimeout = 30 * time.Second
+)
+
+var errNoPlugin = errors.New("no such plugin")
+
+// pluginTask is the task given to the postprocess plugins
+type pluginTask struct {
+	InfoHash string `json:"infohash"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Dir      string `json:"dir"`
+	Label    string `json:"label,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+// parsePlugins reads the command lines of Plugins, one per line
+func parsePlugins(s string) ([][]string, error) {
+	var commands [][]string
+	for _, line := range common.SplitLines(s) {
+		args, err := splitCommandLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Plugins line %q: %w", line, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		commands = append(commands, args)
+	}
+	return commands, nil
+}
+
+// startPlugins starts the Plugins, once as they can't change at runtime
+func (e *Engine) startPlugins(c *Config) {
+	commands, err := parsePlugins(c.Plugins)
+	if err != nil || len(commands) == 0 {
+		return
+	}
+	m := plugin.NewManager(commands, func(format string, args ...interface{}) {
+		log.Printf("[Plugin] "+format, args...)
+	})
+	e.plugins = m
+	go func() {
+		m.Start(context.Background())
+		for _, s := range m.Statuses() {
+			if s.Error == "" {
+				log.Printf("[Plugin] %s %s started: %s", s.Name, s.Version, strings.Join(s.Capabilities, ","))
+			}
+		}
+		events, _ := e.Subscribe(webhookQueue, lifecycleEvents...)
+		e.pluginRoutine(m, events)
+	}()
+}
+
+// pluginRoutine notifies the plugins of the lifecycle events, and runs the
+// postprocess ones on the completed tasks
+func (e *Engine) pluginRoutine(m *plugin.Manager, events <-chan Event) {
+	for ev := range events {
+		for _, p := range m.With(plugin.CapNotify) {
+			if err := p.Notify("notify", ev); err != nil {
+				log.Debugf("[Plugin] %s notify %s: %v", p.Info().Name, ev.Type, err)
+			}
+		}
+		if ev.Type != EventCompleted {
+			continue
+		}
+		d := e.hookData(ev)
+		task := pluginTask{
+			InfoHash: d.InfoHash,
+			Name:     d.Name,
+			Path:     d.Path,
+			Dir:      d.Dir,
+			Label:    d.Label,
+			Size:     d.Size,
+		}
+		for _, p := range m.With(plugin.CapPostProcess) {
+			go e.postProcess(p, task)
+		}
+	}
+}
+
+// postProcess runs a postprocess plugin on the task, recorded in its timeline
+func (e *Engine) postProcess(p *plugin.Plugin, task pluginTask) {
+	timeout := e.Config().HookTimeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var res struct {
+		Message string `json:"message"`
+	}
+	name := p.Info().Name
+	err := p.Call(ctx, "postprocess", task, &res)
+	if err != nil {
+		log.Warnf("[Plugin] %s postprocess %s: %v", name, task.InfoHash, err)
+	} else if res.Message != "" {
+		log.Printf("[Plugin] %s postprocess %s: %s", name, task.InfoHash, res.Message)
+	}
+	e.recordHook(task.InfoHash, task.Name, "plugin "+name+" postprocess", err)
+}
+
+func (e *Engine) pluginManager() *plugin.Manager {
+	e.RLock()
+	defer e.RUnlock()
+	return e.plugins
+}
+
+// Plugins lists the plugins and their state
+func (e *Engine) Plugins() []plugin.Status {
+	m := e.pluginManager()
+	if m == nil {
+		return []plugin.Status{}
+	}
+	return m.Statuses()
+}
+
+// SearchPlugins returns the names of the plugins able to search
+func (e *Engine) SearchPlugins() []string {
+	var names []string
+	m := e.pluginManager()
+	if m == nil {
+		return names
+	}
+	for _, p := range m.With(plugin.CapSearch) {
+		names = append(names, p.Info().Name)
+	}
+	return names
+}
+
+// PluginSearch searches by the plugin name, page from 1
+func (e *Engine) PluginSearch(ctx context.Context, name, query string, page int) ([]plugin.SearchResult, error) {
+	m := e.pluginManager()
+	if m == nil {
+		return nil, errNoPlugin
+	}
+	p := m.Get(name)
+	if p == nil || !p.Has(plugin.CapSearch) {
+		return nil, errNoPlugin
+	}
+	ctx, cancel := context.WithTimeout(ctx, pluginSearchTimeout)
+	defer cancel()
+	return p.Search(ctx, query, page)
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/boypt/simple-torrent/common"
+	"github.com/c2h5oh/datasize"
+	"github.com/dustin/go-humanize"
+)
+
+// ErrPolicy is returned when adding a torrent violating the MaxTorrentSize,
+// MaxTorrentFiles or BannedExtensions
+var ErrPolicy = errors.New("rejected by the policy")
+
+// PolicyError tells which rule the torrent violates, it is ErrPolicy
+type PolicyError struct {
+	Reason string
+}
+
+func (p *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPolicy, p.Reason)
+}
+
+func (p *PolicyError) Is(target error) bool {
+	return target == ErrPolicy
+}
+
+// checkNewTorrent checks a torrent being added against the policy and the disk space,
+// the tasks already known, eg: restored ones, aren't checked
+func (e *Engine) checkNewTorrent(mi *metainfo.MetaInfo, dir string) error {
+	ih := mi.HashInfoBytes().HexString()
+	if e.isKnownTask(ih) {
+		return nil
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		// left to the adding
+		return nil
+	}
+	if err := checkPolicy(&e.config, &info); err != nil {
+		return err
+	}
+	if err := e.lowDiskErr(""); err != nil {
+		return err
+	}
+	err = e.checkAddSpace(mi, &info, dir)
+	var dse *DiskSpaceError
+	if errors.As(err, &dse) && e.reclaimSpace(dse, ih) {
+		err = e.checkAddSpace(mi, &info, dir)
+	}
+	e.lowDisk(ih, info.Name, err)
+	return err
+}
+
+// isKnownTask tells whether the task is added, cached or from the last session
+func (e *Engine) isKnownTask(ih string) bool {
+	if e.hasSession(ih) {
+		return true
+	}
+	if _, err := os.Stat(e.TorrentCacheFileName(ih)); err == nil {
+		return true
+	}
+	e.RLock()
+	defer e.RUnlock()
+	_, ok := e.ts[ih]
+	return ok
+}
+
+// checkGotInfo checks a task added in this session once its info is got
+func (e *Engine) checkGotInfo(t *Torrent, info *metainfo.Info) error {
+	t.Lock()
+	restored, owner := t.restored, t.AddedBy
+	t.Unlock()
+	if restored || info == nil {
+		return nil
+	}
+	if err := checkPolicy(&e.config, info); err != nil {
+		return err
+	}
+	// the size of a magnet is known now
+	if owner != "" {
+		return e.checkUserQuota(owner, info.TotalLength(), false)
+	}
+	return nil
+}
+
+// specSize returns the size of the torrent, 0 for a magnet
+func specSize(spec *torrent.TorrentSpec) int64 {
+	if len(spec.InfoBytes) == 0 {
+		return 0
+	}
+	var info metainfo.Info
+	if err := bencode.Unmarshal(spec.InfoBytes, &info); err != nil {
+		return 0
+	}
+	return info.TotalLength()
+}
+
+// rejectTask removes a task violating the policy with its cache
+func (e *Engine) rejectTask(t *Torrent, err error) {
+	ih := t.InfoHash
+	log.Warn("[Policy]", ih, err)
+	e.emit(EventError, t, err)
+	e.removeMagnetCache(ih)
+	e.removeTorrentCache(ih, false)
+	e.setTaskDir(ih, "")
+	e.setDeleteReason(ih, DeleteReasonPolicy)
+	common.FancyHandleError(e.DeleteTorrent(ih))
+}
+
+// checkPolicy returns a PolicyError if the torrent violates the limits of c
+func checkPolicy(c *Config, info *metainfo.Info) error {
+	if s := strings.TrimSpace(c.MaxTorrentSize); s != "" && s != "0" {
+		var max datasize.ByteSize
+		if err := max.UnmarshalText([]byte(strings.ToLower(s))); err != nil {
+			log.Warn("[Policy] MaxTorrentSize", err)
+		} else if size := info.TotalLength(); size > int64(max) {
+			return &PolicyError{fmt.Sprintf("size %s exceeds %s", humanize.IBytes(uint64(size)), humanize.IBytes(uint64(max)))}
+		}
+	}
+
+	files := info.UpvertedFiles()
+	if c.MaxTorrentFiles > 0 && len(files) > c.MaxTorrentFiles {
+		return &PolicyError{fmt.Sprintf("%d files exceed %d", len(files), c.MaxTorrentFiles)}
+	}
+
+	banned := bannedExtensions(c.BannedExtensions)
+	if len(banned) == 0 {
+		return nil
+	}
+	for _, f := range files {
+		name := info.Name
+		if len(f.Path) > 0 {
+			name = f.Path[len(f.Path)-1]
+		}
+		if ext := strings.ToLower(filepath.Ext(name)); ext != "" && banned[ext] {
+			return &PolicyError{fmt.Sprintf("file %q of a banned extension", name)}
+		}
+	}
+	return nil
+}
+
+// bannedExtensions parses the comma or space separated extensions, the dots are optional
+func bannedExtensions(s string) map[string]bool {
+	banned := make(map[string]bool)
+	for _, ext := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	}) {
+		banned["."+strings.TrimPrefix(ext, ".")] = true
+	}
+	return banned
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func Test_checkPolicy(t *testing.T) {
+	single := &metainfo.Info{Name: "Movie.MKV", Length: 2 << 30}
+	multi := &metainfo.Info{Name: "pack", Files: []metainfo.FileInfo{
+		{Path: []string{"a", "setup.exe"}, Length: 1},
+		{Path: []string{"readme.txt"}, Length: 1},
+		{Path: []string{"noext"}, Length: 1},
+	}}
+	tests := []struct {
+		name   string
+		c      Config
+		info   *metainfo.Info
+		reject bool
+	}{
+		{"none", Config{}, multi, false},
+		{"size ok", Config{MaxTorrentSize: "3GB"}, single, false},
+		{"size", Config{MaxTorrentSize: "1GB"}, single, true},
+		{"files ok", Config{MaxTorrentFiles: 3}, multi, false},
+		{"files", Config{MaxTorrentFiles: 2}, multi, true},
+		{"extension", Config{BannedExtensions: "bat, EXE"}, multi, true},
+		{"extension single", Config{BannedExtensions: ".mkv"}, single, true},
+		{"extension ok", Config{BannedExtensions: ".bat,.scr"}, multi, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPolicy(&tt.c, tt.info)
+			if (err != nil) != tt.reject {
+				t.Errorf("checkPolicy() error = %v, reject %v", err, tt.reject)
+			}
+			if err != nil && !errors.Is(err, ErrPolicy) {
+				t.Errorf("checkPolicy() error = %v, not ErrPolicy", err)
+			}
+		})
+	}
+}
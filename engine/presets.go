@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// taskPreset is given when adding a task, taken by it once added
+type taskPreset struct {
+	filePriorities FilePriorities
+	// the user adding it
+	owner string
+	// where it's added from, given to the AddHook
+	source string
+	// see SetTorrentTimes
+	startAt      time.Time
+	expireAt     time.Time
+	expireAction string
+}
+
+type taskPresets struct {
+	sync.Mutex
+	m map[string]*taskPreset
+}
+
+// preset updates the preset of the task of the magnet or torrent data
+func (e *Engine) preset(data []byte, update func(*taskPreset)) error {
+	ih, err := InfoHashOf(data)
+	if err != nil {
+		return err
+	}
+	e.presetInfoHash(ih, update)
+	return nil
+}
+
+// presetInfoHash updates the preset of the task of the info hash
+func (e *Engine) presetInfoHash(ih string, update func(*taskPreset)) {
+	e.presets.Lock()
+	defer e.presets.Unlock()
+	if e.presets.m == nil {
+		e.presets.m = make(map[string]*taskPreset)
+	}
+	p, ok := e.presets.m[ih]
+	if !ok {
+		p = &taskPreset{}
+		e.presets.m[ih] = p
+	}
+	update(p)
+}
+
+// PresetFilePriorities sets the file priorities of the task of the magnet or
+// torrent to be added, the tasks restored from the cache keep theirs
+func (e *Engine) PresetFilePriorities(data []byte, prios FilePriorities) error {
+	return e.preset(data, func(p *taskPreset) { p.filePriorities = prios })
+}
+
+// PresetOwner sets the user adding the task of the magnet or torrent, whose
+// quotas it's checked against
+func (e *Engine) PresetOwner(data []byte, user string) error {
+	return e.preset(data, func(p *taskPreset) { p.owner = user })
+}
+
+// PresetSource sets where the task of the magnet or torrent is added from,
+// eg: SourceRSS, the adds of no source are from the API
+func (e *Engine) PresetSource(data []byte, source string) error {
+	return e.preset(data, func(p *taskPreset) { p.source = source })
+}
+
+// PresetTimes sets the time the task of the magnet or torrent starts at and
+// expires at, see SetTorrentTimes
+func (e *Engine) PresetTimes(data []byte, startAt, expireAt time.Time, action string) error {
+	action, err := checkExpireAction(action)
+	if err != nil {
+		return err
+	}
+	return e.preset(data, func(p *taskPreset) {
+		p.startAt, p.expireAt, p.expireAction = startAt, expireAt, action
+	})
+}
+
+// presetSource returns where the task is added from and the user adding it
+func (e *Engine) presetSource(ih string) (string, string) {
+	e.presets.Lock()
+	defer e.presets.Unlock()
+	p, ok := e.presets.m[ih]
+	if !ok {
+		return SourceAPI, ""
+	}
+	if p.source == "" {
+		return SourceAPI, p.owner
+	}
+	return p.source, p.owner
+}
+
+// presetOwner returns the user adding the task, if any
+func (e *Engine) presetOwner(ih string) string {
+	e.presets.Lock()
+	defer e.presets.Unlock()
+	if p, ok := e.presets.m[ih]; ok {
+		return p.owner
+	}
+	return ""
+}
+
+func (e *Engine) dropPreset(ih string) {
+	e.presets.Lock()
+	defer e.presets.Unlock()
+	delete(e.presets.m, ih)
+}
+
+// takePreset gives the preset to the new task
+func (e *Engine) takePreset(t *Torrent) {
+	e.presets.Lock()
+	p, ok := e.presets.m[t.InfoHash]
+	delete(e.presets.m, t.InfoHash)
+	e.presets.Unlock()
+	if !ok {
+		return
+	}
+	if p.filePriorities != nil {
+		log.Printf("[FilePriority] %s preset %s", t.InfoHash, p.filePriorities)
+		t.filePriorities = p.filePriorities
+	}
+	if p.owner != "" {
+		t.AddedBy = p.owner
+	}
+	if !p.startAt.IsZero() || !p.expireAt.IsZero() {
+		log.Printf("[TaskTimes] %s preset start %v expire %v", t.InfoHash, p.startAt, p.expireAt)
+		t.StartAt, t.ExpireAt, t.ExpireAction = p.startAt, p.expireAt, p.expireAction
+	}
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestPresetDroppedOnExists(t *testing.T) {
+	tc := torrent.NewDefaultClientConfig()
+	tc.DataDir = t.TempDir()
+	tc.ListenPort = 0
+	tc.NoDHT = true
+	tc.DisableTrackers = true
+	tc.NoDefaultPortForwarding = true
+	cl, err := torrent.NewClient(tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	ih := "0123456789abcdef0123456789abcdef01234567"
+	if _, _, err := cl.AddTorrentSpec(&torrent.TorrentSpec{InfoHash: metainfo.NewHashFromHex(ih)}); err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{
+		client:    cl,
+		cacheDir:  t.TempDir(),
+		ts:        map[string]*Torrent{ih: {InfoHash: ih}},
+		limiters:  limiterMap{m: make(map[string]*torrentLimiter)},
+		timelines: timelineMap{m: make(map[string][]Event)},
+	}
+	magnet := "magnet:?xt=urn:btih:" + ih
+	if err := e.PresetOwner([]byte(magnet), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.NewMagnet(magnet, ""); !errors.Is(err, ErrTaskExists) {
+		t.Fatalf("NewMagnet() = %v, want ErrTaskExists", err)
+	}
+
+	// added again once removed, by nobody
+	delete(e.ts, ih)
+	task, err := e.upsertTorrent(ih, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.AddedBy != "" {
+		t.Errorf("AddedBy = %q, inherited from the add of the existing task", task.AddedBy)
+	}
+}
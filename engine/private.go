@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// infoPrivate tells if the info has the BEP 27 private flag
+func infoPrivate(info *metainfo.Info) bool {
+	return info != nil && info.Private != nil && *info.Private
+}
+
+// applyPrivate sets the private flag of the task got with its info, and
+// whether it gets its peers only from its own trackers by PrivateTrackersOnly
+func (e *Engine) applyPrivate(t *Torrent, info *metainfo.Info) bool {
+	private := infoPrivate(info)
+	t.Lock()
+	defer t.Unlock()
+	t.Private = private
+	t.TrackersOnly = private && e.config.PrivateTrackersOnly
+	return t.TrackersOnly
+}
+
+// peerSourceAllowed tells if a peer of the source may connect to the task,
+// the tasks with TrackersOnly refuse the peers of the DHT and PEX
+func peerSourceAllowed(trackersOnly bool, source torrent.PeerSource) bool {
+	if !trackersOnly {
+		return true
+	}
+	switch source {
+	case torrent.PeerSourceDhtGetPeers, torrent.PeerSourceDhtAnnouncePeer, torrent.PeerSourcePex:
+		return false
+	}
+	return true
+}
+
+// trackersMissing returns the trackers not in the tiers
+func trackersMissing(tiers [][]string, trackers []string) []string {
+	known := make(map[string]bool)
+	for _, tr := range flattenTrackers(tiers) {
+		known[tr] = true
+	}
+	var missing []string
+	for _, tr := range trackers {
+		if !known[tr] {
+			known[tr] = true
+			missing = append(missing, tr)
+		}
+	}
+	return missing
+}
+
+// dropInjectedTrackers removes the public trackers added to a magnet found
+// private once its info is got, the task is reloaded without them
+func (e *Engine) dropInjectedTrackers(t *Torrent) {
+	t.Lock()
+	injected := t.injectedTrackers
+	t.injectedTrackers = nil
+	t.Unlock()
+	if len(injected) == 0 {
+		return
+	}
+	log.Printf("[Private] %s removing %d public trackers", t.InfoHash, len(injected))
+	if err := e.RemoveTorrentTrackers(t.InfoHash, injected); err != nil {
+		log.Warn("[Private]", t.InfoHash, err)
+	}
+}
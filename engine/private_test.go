@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestApplyPrivate(t *testing.T) {
+	yes, no := true, false
+	e := &Engine{config: Config{PrivateTrackersOnly: true}}
+	for _, tc := range []struct {
+		info                  *metainfo.Info
+		private, trackersOnly bool
+	}{
+		{nil, false, false},
+		{&metainfo.Info{}, false, false},
+		{&metainfo.Info{Private: &no}, false, false},
+		{&metainfo.Info{Private: &yes}, true, true},
+	} {
+		task := &Torrent{}
+		if got := e.applyPrivate(task, tc.info); got != tc.trackersOnly || task.Private != tc.private {
+			t.Errorf("applyPrivate(%v) = %v, Private %v", tc.info, got, task.Private)
+		}
+	}
+	e.config.PrivateTrackersOnly = false
+	task := &Torrent{}
+	if e.applyPrivate(task, &metainfo.Info{Private: &yes}) || !task.Private {
+		t.Errorf("applyPrivate() without PrivateTrackersOnly = %v, Private %v", task.TrackersOnly, task.Private)
+	}
+}
+
+func TestPeerSourceAllowed(t *testing.T) {
+	for _, src := range []torrent.PeerSource{torrent.PeerSourceDhtGetPeers, torrent.PeerSourceDhtAnnouncePeer, torrent.PeerSourcePex} {
+		if peerSourceAllowed(true, src) {
+			t.Errorf("peerSourceAllowed(true, %q) = true", src)
+		}
+		if !peerSourceAllowed(false, src) {
+			t.Errorf("peerSourceAllowed(false, %q) = false", src)
+		}
+	}
+	for _, src := range []torrent.PeerSource{torrent.PeerSourceTracker, torrent.PeerSourceIncoming} {
+		if !peerSourceAllowed(true, src) {
+			t.Errorf("peerSourceAllowed(true, %q) = false", src)
+		}
+	}
+}
+
+func TestTrackersMissing(t *testing.T) {
+	tiers := [][]string{{"udp://a"}, {"udp://b"}}
+	got := trackersMissing(tiers, []string{"udp://a", "udp://c", "udp://c", "udp://d"})
+	if want := []string{"udp://c", "udp://d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("trackersMissing() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/net/proxy"
+)
+
+// peerProxyDialer dials the peers through the SOCKS5 proxy of PeerProxyURL,
+// counting the dials for the status
+type peerProxyDialer struct {
+	d              proxy.ContextDialer
+	dialed, failed int64
+	mu             sync.Mutex
+	lastErr        string
+}
+
+// newPeerProxy returns the dialer of the socks5:// or socks5h:// url
+func newPeerProxy(raw string) (*peerProxyDialer, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PeerProxyURL: %w", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("Invalid PeerProxyURL: %s is not a socks5 proxy", u.Redacted())
+	}
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PeerProxyURL: %w", err)
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("Invalid PeerProxyURL: %s can't dial with a context", u.Redacted())
+	}
+	return &peerProxyDialer{d: cd}, nil
+}
+
+func (p *peerProxyDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	c, err := p.d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		atomic.AddInt64(&p.failed, 1)
+		p.mu.Lock()
+		p.lastErr = err.Error()
+		p.mu.Unlock()
+		return nil, err
+	}
+	atomic.AddInt64(&p.dialed, 1)
+	return c, nil
+}
+
+func (p *peerProxyDialer) DialerNetwork() string {
+	return "tcp"
+}
+
+// setPeerProxy makes the client dial the peers only by the proxy dialer added
+// in Configure, a SOCKS5 proxy takes neither the incoming nor the uTP peers
+func (c *Config) setPeerProxy(tc *torrent.ClientConfig) error {
+	if c.PeerProxyURL == "" {
+		return nil
+	}
+	if _, err := newPeerProxy(c.PeerProxyURL); err != nil {
+		return err
+	}
+	tc.DisableTCP = true
+	tc.DisableUTP = true
+	return nil
+}
+
+// lookupProxiedTrackerIP is the tracker lookup with ProxyURL set, the UDP
+// announces can't go through the proxy so the UDP trackers are rejected
+// rather than announced to directly
+func lookupProxiedTrackerIP(u *url.URL) ([]net.IP, error) {
+	switch u.Scheme {
+	case "udp", "udp4", "udp6":
+		return nil, fmt.Errorf("udp tracker %s rejected, it can't go through ProxyURL", u.Host)
+	}
+	return net.LookupIP(u.Hostname())
+}
+
+// ProxyStatus tells what goes through the proxies
+type ProxyStatus struct {
+	// ProxyURL, for the HTTP trackers and the web seeds
+	TrackerProxy string
+	// the UDP trackers can't go through TrackerProxy, they aren't announced to
+	UDPTrackersRejected bool
+	PeerProxy           string
+	// the peers are dialed only by PeerProxy, no incoming or uTP peers
+	PeerProxyActive bool
+	PeersDialed     int64
+	PeersFailed     int64
+	LastError       string `json:",omitempty"`
+}
+
+// ProxyStatus returns the use of ProxyURL and PeerProxyURL
+func (e *Engine) ProxyStatus() ProxyStatus {
+	e.RLock()
+	c := e.config.Redacted()
+	p := e.peerProxy
+	e.RUnlock()
+	s := ProxyStatus{
+		TrackerProxy:        c.ProxyURL,
+		UDPTrackersRejected: c.ProxyURL != "" && !c.DisableTrackers,
+		PeerProxy:           c.PeerProxyURL,
+	}
+	if p != nil {
+		s.PeerProxyActive = true
+		s.PeersDialed = atomic.LoadInt64(&p.dialed)
+		s.PeersFailed = atomic.LoadInt64(&p.failed)
+		p.mu.Lock()
+		s.LastError = p.lastErr
+		p.mu.Unlock()
+	}
+	return s
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+var errNotQueued = errors.New("task not in the wait list")
+
+// QueuedTask is a task in the wait list, in the order to be added
+type QueuedTask struct {
+	InfoHash string
+	Name     string
+	Magnet   bool
+	Priority int
+}
+
+// WaitList returns the tasks waiting for MaxConcurrentTask, MaxActiveDownloads or MaxActiveSeeds
+func (e *Engine) WaitList() []QueuedTask {
+	elems := e.waitList.Elems()
+	e.RLock()
+	defer e.RUnlock()
+	tasks := []QueuedTask{}
+	for _, te := range elems {
+		q := QueuedTask{
+			InfoHash: te.ih,
+			Magnet:   te.tp == taskMagnet,
+			Priority: te.priority,
+		}
+		if t, ok := e.ts[te.ih]; ok {
+			q.Name = t.Name
+		}
+		tasks = append(tasks, q)
+	}
+	return tasks
+}
+
+// MoveWaitTaskTop makes the task the next one to be added
+func (e *Engine) MoveWaitTaskTop(infohash string) error {
+	te, ok := e.waitList.MoveToFront(infohash)
+	if !ok {
+		return errNotQueued
+	}
+	log.Println("[WaitList] moved to top", infohash)
+	e.setQueuePriority(infohash, te.priority)
+	return nil
+}
+
+// SetQueuePriority sets the priority of the task in the wait list, higher ones
+// are added first. It's kept for the task to be queued again, eg: after restarts.
+func (e *Engine) SetQueuePriority(infohash string, priority int) error {
+	e.RLock()
+	_, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	e.setQueuePriority(infohash, priority)
+	e.waitList.SetPriority(infohash, priority)
+	log.Println("[WaitList] priority", infohash, priority)
+	return nil
+}
+
+func (e *Engine) setQueuePriority(infohash string, priority int) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return
+	}
+	t.Lock()
+	t.QueuePriority = priority
+	t.Unlock()
+	e.notifyChanged()
+}
+
+// queuePriority is the priority of a task being queued, from the task or the
+// last session
+func (e *Engine) queuePriority(ih string) int {
+	e.RLock()
+	t, err := e.getTorrent(ih)
+	e.RUnlock()
+	if err == nil {
+		t.Lock()
+		defer t.Unlock()
+		return t.QueuePriority
+	}
+	e.sessions.Lock()
+	defer e.sessions.Unlock()
+	return e.sessions.m[ih].QueuePriority
+}
+
+// ForceStartWaitTask adds and starts the queued task, bypassing the limits
+func (e *Engine) ForceStartWaitTask(infohash string) error {
+	te, ok := e.waitList.Take(infohash)
+	if !ok {
+		return errNotQueued
+	}
+	res := fmt.Sprintf("%s%s.torrent", cacheSavedPrefix, te.ih)
+	if te.tp == taskMagnet {
+		res = fmt.Sprintf("%s%s.info", cacheSavedPrefix, te.ih)
+	}
+	fn := path.Join(e.cacheDir, res)
+	if _, err := os.Stat(fn); err != nil {
+		return err
+	}
+
+	log.Println("[WaitList] force start", infohash)
+	e.forceMu.Lock()
+	e.forceSet[infohash] = struct{}{}
+	e.forceMu.Unlock()
+	if err := e.RestoreTask(fn); err != nil {
+		e.takeForceStart(infohash)
+		e.waitList.Push(te)
+		return err
+	}
+	return nil
+}
+
+// nextReadyTask takes the first task of the wait list ready to be added
+func (e *Engine) nextReadyTask() (taskElem, bool) {
+	for _, te := range e.waitList.Elems() {
+		if !e.isReadyAddTask(te.ih) {
+			continue
+		}
+		if te, ok := e.waitList.Take(te.ih); ok {
+			return te, true
+		}
+	}
+	return taskElem{}, false
+}
+
+// activeTasks counts the added tasks not stopped, the finished ones are seeds
+func (e *Engine) activeTasks() (downloads, seeds int) {
+	e.RLock()
+	defer e.RUnlock()
+	for _, t := range e.ts {
+		t.Lock()
+		stopped := !t.Started && (!t.StoppedAt.IsZero() || t.resumeStopped)
+		if !t.IsQueueing && !stopped {
+			if t.Done || !t.FinishedAt.IsZero() {
+				seeds++
+			} else {
+				downloads++
+			}
+		}
+		t.Unlock()
+	}
+	return
+}
+
+// isFinishedTask tells whether the task has finished downloading, by the task
+// or the last session when it's not added yet
+func (e *Engine) isFinishedTask(ih string) bool {
+	e.RLock()
+	t, err := e.getTorrent(ih)
+	e.RUnlock()
+	if err == nil {
+		t.Lock()
+		defer t.Unlock()
+		return t.Done || !t.FinishedAt.IsZero()
+	}
+	e.sessions.Lock()
+	defer e.sessions.Unlock()
+	return !e.sessions.m[ih].FinishedAt.IsZero()
+}
+
+// takeForceStart reports whether the task is force started and clears the mark
+func (e *Engine) takeForceStart(ih string) bool {
+	e.forceMu.Lock()
+	defer e.forceMu.Unlock()
+	if _, ok := e.forceSet[ih]; ok {
+		delete(e.forceSet, ih)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/boypt/simple-torrent/common/trace"
+	"golang.org/x/time/rate"
+)
+
+// torrentLimiter throttles the storage of a single torrent. The anacrolix
+// client only has global limiters, so the per torrent rate is enforced by
+// delaying the storage writes (download) and the reads of completed pieces
+// for the peers (upload). The hashing and the local readers aren't throttled.
+type torrentLimiter struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+func newTorrentLimiter() *torrentLimiter {
+	return &torrentLimiter{
+		upload:   rate.NewLimiter(rate.Inf, 0),
+		download: rate.NewLimiter(rate.Inf, 0),
+	}
+}
+
+func setLimiter(l *rate.Limiter, rstr string) error {
+	nl, err := rateLimiter(rstr)
+	if err != nil {
+		return err
+	}
+	copyLimiter(l, nl)
+	return nil
+}
+
+func copyLimiter(l, from *rate.Limiter) {
+	l.SetLimit(from.Limit())
+	l.SetBurst(from.Burst())
+}
+
+// waitLimiter waits n tokens of l, in steps of the burst size as chunks may be larger than it
+func waitLimiter(l *rate.Limiter, n int) {
+	for n > 0 && l.Limit() != rate.Inf {
+		step := l.Burst()
+		if step <= 0 {
+			return
+		}
+		if step > n {
+			step = n
+		}
+		if err := l.WaitN(context.Background(), step); err != nil {
+			return
+		}
+		n -= step
+	}
+}
+
+type limitedStorage struct {
+	storage.ClientImpl
+	e *Engine
+}
+
+func (s *limitedStorage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	if name := s.e.sessionName(infoHash.HexString()); name != "" && name != info.Name {
+		renamed := *info
+		renamed.Name = name
+		info = &renamed
+	}
+	ti, err := s.ClientImpl.OpenTorrent(info, infoHash)
+	if err != nil {
+		return ti, err
+	}
+	ih := infoHash.HexString()
+	l := s.e.torrentLimiter(ih)
+	c := s.e.fileCounter(ih, info)
+	piece := ti.Piece
+	ti.Piece = func(p metainfo.Piece) storage.PieceImpl {
+		return &limitedPiece{PieceImpl: piece(p), l: l, c: c, cache: &s.e.pieceCache, local: &s.e.localReads,
+			errs: &s.e.storageErrs, key: pieceKey{ih, p.Offset()}, offset: p.Offset(), length: p.Length()}
+	}
+	return ti, nil
+}
+
+type limitedPiece struct {
+	storage.PieceImpl
+	l      *torrentLimiter
+	c      *fileCounter
+	cache  *pieceCache
+	errs   *storageErrorMap
+	local  *localReads
+	key    pieceKey
+	offset int64
+	length int64
+}
+
+func (p *limitedPiece) ReadAt(b []byte, off int64) (int, error) {
+	// incomplete pieces are read for hashing, don't hold them. The local
+	// readers (/stream) aren't an upload either
+	complete := p.Completion().Complete
+	peer := complete && !p.local.has(b)
+	if peer {
+		waitLimiter(p.l.upload, len(b))
+	}
+	if complete && p.cache.get(p.key, off, b) {
+		if peer {
+			p.c.add(p.c.uploaded, p.offset+off, len(b))
+		}
+		return len(b), nil
+	}
+	start := time.Now()
+	n, err := p.PieceImpl.ReadAt(b, off)
+	trace.Observe(context.Background(), "storage.ReadAt", start, "infohash", p.key.ih, "bytes", strconv.Itoa(n))
+	if peer {
+		p.c.add(p.c.uploaded, p.offset+off, n)
+	}
+	if complete && err == nil && n == len(b) {
+		p.cache.put(p.key, off, b)
+	}
+	return n, err
+}
+
+// WriteTo is how the client reads a piece to hash it, past the limiter and
+// the counters
+func (p *limitedPiece) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := p.PieceImpl.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.Copy(w, io.NewSectionReader(p.PieceImpl, 0, p.length))
+}
+
+func (p *limitedPiece) WriteAt(b []byte, off int64) (int, error) {
+	waitLimiter(p.l.download, len(b))
+	p.cache.invalidate(p.key)
+	start := time.Now()
+	n, err := p.PieceImpl.WriteAt(b, off)
+	trace.Observe(context.Background(), "storage.WriteAt", start, "infohash", p.key.ih, "bytes", strconv.Itoa(n))
+	p.c.add(p.c.downloaded, p.offset+off, n)
+	if err != nil {
+		p.errs.set(p.key.ih, err)
+	}
+	return n, err
+}
+
+type limiterMap struct {
+	sync.Mutex
+	m map[string]*torrentLimiter
+}
+
+func (e *Engine) torrentLimiter(ih string) *torrentLimiter {
+	e.limiters.Lock()
+	defer e.limiters.Unlock()
+	l, ok := e.limiters.m[ih]
+	if !ok {
+		l = newTorrentLimiter()
+		e.limiters.m[ih] = l
+	}
+	return l
+}
+
+func (e *Engine) removeTorrentLimiter(ih string) {
+	e.limiters.Lock()
+	defer e.limiters.Unlock()
+	delete(e.limiters.m, ih)
+}
+
+// applyDefaultRateLimit sets the configured per torrent rates to a new task
+func (e *Engine) applyDefaultRateLimit(t *Torrent) {
+	l := e.torrentLimiter(t.InfoHash)
+	if err := setLimiter(l.upload, e.config.TorrentUploadRate); err == nil {
+		t.UploadRateLimit = e.config.TorrentUploadRate
+	}
+	if err := setLimiter(l.download, e.config.TorrentDownloadRate); err == nil {
+		t.DownloadRateLimit = e.config.TorrentDownloadRate
+	}
+}
+
+// restoreRateLimit sets the rates the user set to the task restored
+func (e *Engine) restoreRateLimit(t *Torrent, r *taskRateLimit) {
+	l := e.torrentLimiter(t.InfoHash)
+	if err := setLimiter(l.upload, r.Upload); err != nil {
+		log.Warn("[restoreRateLimit]", t.InfoHash, err)
+		return
+	}
+	if err := setLimiter(l.download, r.Download); err != nil {
+		log.Warn("[restoreRateLimit]", t.InfoHash, err)
+		return
+	}
+	t.UploadRateLimit, t.DownloadRateLimit = r.Upload, r.Download
+	t.rateLimitSet = true
+}
+
+// SetTorrentRateLimit sets the upload/download rate of a single torrent,
+// accepts the same values as the global UploadRate/DownloadRate
+func (e *Engine) SetTorrentRateLimit(infohash, upload, download string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// both are checked before either is set
+	up, err := rateLimiter(upload)
+	if err != nil {
+		return err
+	}
+	down, err := rateLimiter(download)
+	if err != nil {
+		return err
+	}
+	l := e.torrentLimiter(infohash)
+	copyLimiter(l.upload, up)
+	copyLimiter(l.download, down)
+
+	t.Lock()
+	defer t.Unlock()
+	t.UploadRateLimit = upload
+	t.DownloadRateLimit = download
+	t.rateLimitSet = true
+	log.Printf("[SetTorrentRateLimit] %s upload %q download %q", infohash, upload, download)
+	return nil
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"golang.org/x/time/rate"
+)
+
+func TestSetTorrentRateLimitInvalid(t *testing.T) {
+	ih := "0123456789abcdef0123456789abcdef01234567"
+	e := &Engine{
+		ts:       map[string]*Torrent{ih: {InfoHash: ih}},
+		limiters: limiterMap{m: make(map[string]*torrentLimiter)},
+	}
+	if err := e.SetTorrentRateLimit(ih, "100k", "bogus"); err == nil {
+		t.Fatal("invalid download rate accepted")
+	}
+	l := e.torrentLimiter(ih)
+	if l.upload.Limit() != rate.Inf || e.ts[ih].UploadRateLimit != "" {
+		t.Errorf("upload set by a failed call: %v %q", l.upload.Limit(), e.ts[ih].UploadRateLimit)
+	}
+	if err := e.SetTorrentRateLimit(ih, "100k", "200k"); err != nil {
+		t.Fatal(err)
+	}
+	if l.upload.Limit() == rate.Inf || l.download.Limit() == rate.Inf {
+		t.Errorf("limits not set: %v %v", l.upload.Limit(), l.download.Limit())
+	}
+}
+
+func TestLocalReads(t *testing.T) {
+	var l localReads
+	buf := make([]byte, 64)
+	peer := make([]byte, 64)
+	done := l.begin(buf)
+	// the reader reads the storage into the slices of the buffer
+	for _, b := range [][]byte{buf, buf[:16], buf[16:], buf[10:20]} {
+		if !l.has(b) {
+			t.Errorf("slice %d of the buffer not local", len(b))
+		}
+	}
+	if l.has(peer) || l.has(nil) {
+		t.Error("other buffer is local")
+	}
+	done()
+	if l.has(buf) {
+		t.Error("buffer local after the read")
+	}
+}
+
+// completePiece is the storage of a single complete piece
+type completePiece struct {
+	storage.PieceImpl
+	data []byte
+}
+
+func (p completePiece) ReadAt(b []byte, off int64) (int, error) {
+	return copy(b, p.data[off:]), nil
+}
+
+func (p completePiece) Completion() storage.Completion {
+	return storage.Completion{Complete: true, Ok: true}
+}
+
+func TestLimitedPieceLocalReads(t *testing.T) {
+	info := &metainfo.Info{Name: "a", Length: 64, PieceLength: 64}
+	c := newFileCounter(info)
+	var local localReads
+	p := &limitedPiece{PieceImpl: completePiece{data: make([]byte, 64)}, l: newTorrentLimiter(),
+		c: c, cache: &pieceCache{}, local: &local, length: 64}
+
+	peer := make([]byte, 16)
+	if _, err := p.ReadAt(peer, 0); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 32)
+	done := local.begin(buf)
+	if _, err := p.ReadAt(buf, 16); err != nil {
+		t.Fatal(err)
+	}
+	done()
+	if _, up := c.get(0); up != 16 {
+		t.Errorf("uploaded %d, want only the 16 bytes of the peer", up)
+	}
+}
+
+func TestLimitsKeptByReload(t *testing.T) {
+	ih := "0123456789abcdef0123456789abcdef01234567"
+	e := &Engine{
+		ts:        map[string]*Torrent{ih: {InfoHash: ih}},
+		limiters:  limiterMap{m: make(map[string]*torrentLimiter)},
+		timelines: timelineMap{m: make(map[string][]Event)},
+		sessions:  sessionMap{m: make(map[string]taskSession)},
+	}
+	if err := e.SetTorrentRateLimit(ih, "100k", "200k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetTorrentConnLimits(ih, 20, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// as reloadTask does it, eg: renaming the task
+	old := e.ts[ih]
+	e.sessions.m[ih] = old.session()
+	e.deleteTorrent(ih)
+	e.sessions.m[ih] = old.session()
+	task, err := e.upsertTorrent(ih, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.UploadRateLimit != "100k" || task.DownloadRateLimit != "200k" {
+		t.Errorf("rates %q %q after the reload", task.UploadRateLimit, task.DownloadRateLimit)
+	}
+	l := e.torrentLimiter(ih)
+	if l.upload.Limit() == rate.Inf || l.download.Limit() == rate.Inf {
+		t.Errorf("limiters not set after the reload: %v %v", l.upload.Limit(), l.download.Limit())
+	}
+	if task.ConnLimits != (ConnLimits{MaxConns: 20, UploadSlots: 4}) {
+		t.Errorf("conn limits %+v after the reload", task.ConnLimits)
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// ReadinessReport is the result of the readiness checks, Ready if all OK
+type ReadinessReport struct {
+	Ready  bool
+	Time   time.Time
+	Checks []HealthCheck
+}
+
+func (r *ReadinessReport) add(name string, ok bool, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, HealthCheck{Name: name, OK: ok, Detail: fmt.Sprintf(format, args...)})
+	if !ok {
+		r.Ready = false
+	}
+}
+
+// Readiness checks the engine is configured, the client listening, the
+// download directory writable and its free space above the threshold, for
+// the readiness probes of the orchestrators
+func (e *Engine) Readiness() *ReadinessReport {
+	r := &ReadinessReport{Ready: true, Time: time.Now()}
+	e.RLock()
+	client := e.client
+	c := e.config
+	e.RUnlock()
+
+	switch {
+	case e.shuttingDownErr() != nil:
+		r.add("engine", false, "shutting down")
+	case client == nil:
+		r.add("engine", false, "not configured")
+	default:
+		r.add("engine", true, "configured")
+	}
+
+	if client != nil {
+		if port := client.LocalPort(); port > 0 {
+			r.add("listen", true, "port %d listening", port)
+		} else {
+			r.add("listen", false, "not listening for peers")
+		}
+	}
+
+	dir := c.DownloadDirectory
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if err := writableDir(dir); err != nil {
+		r.add("disk", false, "%s not writable: %v", dir, err)
+		return r
+	}
+	r.add("disk", true, "%s writable", dir)
+
+	// LowDiskSpace pauses the downloads, DiskReserve refuses them
+	threshold, _ := parseByteSize(c.LowDiskSpace)
+	if threshold <= 0 {
+		threshold = e.diskReserve()
+	}
+	usage, err := disk.Usage(dir)
+	if err != nil {
+		r.add("space", false, "free space of %s unknown: %v", dir, err)
+		return r
+	}
+	free := humanize.IBytes(usage.Free)
+	if int64(usage.Free) < threshold {
+		r.add("space", false, "%s free, below %s", free, humanize.IBytes(uint64(threshold)))
+	} else {
+		r.add("space", true, "%s free", free)
+	}
+	return r
+}
+
+// writableDir tests a file can be written in dir
+func writableDir(dir string) error {
+	f, err := ioutil.TempFile(dir, ".writable")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("simple-torrent")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	os.Remove(f.Name())
+	return err
+}
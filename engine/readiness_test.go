@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	checks := func(r *ReadinessReport) map[string]bool {
+		m := map[string]bool{}
+		for _, c := range r.Checks {
+			m[c.Name] = c.OK
+		}
+		return m
+	}
+
+	dir := t.TempDir()
+	e := &Engine{config: Config{DownloadDirectory: dir}}
+	r := e.Readiness()
+	if got := checks(r); r.Ready || got["engine"] || !got["disk"] || !got["space"] {
+		t.Errorf("unconfigured Readiness() = %+v, want not ready with the disk OK", r)
+	}
+
+	e.config.DownloadDirectory = filepath.Join(dir, "missing")
+	if got := checks(e.Readiness()); got["disk"] {
+		t.Errorf("missing dir writable")
+	}
+
+	e.config.DownloadDirectory = dir
+	e.config.LowDiskSpace = "1EB"
+	if got := checks(e.Readiness()); got["space"] {
+		t.Errorf("space above 1EB")
+	}
+}
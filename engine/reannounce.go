@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/boypt/simple-torrent/common"
+)
+
+const (
+	// new trackers from the tracker list tried on each reannounce
+	reannounceTrackers = 5
+	dhtAnnounceTimeout = time.Minute
+)
+
+// stalledSeedTime returns the StalledSeedTime of the label, the
+// StalledSeedLabels take precedence over the global one
+func (e *Engine) stalledSeedTime(label string) time.Duration {
+	if label != "" {
+		for _, line := range common.SplitLines(e.config.StalledSeedLabels) {
+			parts := strings.SplitN(line, "=>", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) != label {
+				continue
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+			if err != nil {
+				log.Warnf("[Reannounce] invalid duration %q: %v", line, err)
+				break
+			}
+			return d
+		}
+	}
+	return e.config.StalledSeedTime
+}
+
+// checkStalledSeed reannounces a seeding task not uploading anything for the
+// StalledSeedTime, to find new leechers. It's checked again after another period.
+func (e *Engine) checkStalledSeed(t *Torrent) {
+	t.Lock()
+	defer t.Unlock()
+	stalled := e.stalledSeedTime(t.Label)
+	if stalled <= 0 || !t.IsSeeding || t.t == nil || t.TrackersOnly {
+		t.seedIdleSince = time.Time{}
+		return
+	}
+
+	now := time.Now()
+	if t.seedIdleSince.IsZero() || t.Uploaded != t.seedUploaded {
+		t.seedIdleSince = now
+		t.seedUploaded = t.Uploaded
+		return
+	}
+	if now.Sub(t.seedIdleSince) < stalled {
+		return
+	}
+	t.seedIdleSince = now
+
+	// rotates through the tracker list, a batch on each round
+	var trackers []string
+	if n := len(e.Trackers); n > 0 {
+		for i := 0; i < reannounceTrackers && i < n; i++ {
+			trackers = append(trackers, e.Trackers[(t.reannounceOffset+i)%n])
+		}
+		t.reannounceOffset = (t.reannounceOffset + len(trackers)) % n
+	}
+	log.Printf("[Reannounce] %s no upload for %s, reannouncing with %d trackers", t.InfoHash, stalled, len(trackers))
+	go e.reannounce(t.t, trackers)
+}
+
+// reannounce announces the torrent to the DHT again and adds the trackers,
+// which are announced to once added
+func (e *Engine) reannounce(tt *torrent.Torrent, trackers []string) {
+	if len(trackers) > 0 {
+		tt.AddTrackers([][]string{trackers})
+	}
+	e.RLock()
+	client := e.client
+	e.RUnlock()
+	if client == nil {
+		return
+	}
+	for _, s := range client.DhtServers() {
+		done, stop, err := tt.AnnounceToDht(s)
+		if err != nil {
+			log.Warn("[Reannounce] dht", err)
+			continue
+		}
+		select {
+		case <-done:
+		case <-time.After(dhtAnnounceTimeout):
+			stop()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// dirtyFlagFile lists the tasks that are writing data, it's removed when
+	// nothing is being written, a leftover one means an unclean shutdown
+	dirtyFlagFile     = ".dirty"
+	dirtyFlagInterval = 10 * time.Second
+)
+
+func (e *Engine) dirtyFlagPath() string {
+	return filepath.Join(e.cacheDir, dirtyFlagFile)
+}
+
+// loadDirtyFlag reads the flag file left by last run, the tasks listed
+// will be verified once their info are loaded
+func (e *Engine) loadDirtyFlag() {
+	f, err := os.Open(e.dirtyFlagPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("[DirtyFlag] failed to read flag file", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	e.recheckMu.Lock()
+	defer e.recheckMu.Unlock()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if ih := strings.TrimSpace(sc.Text()); len(ih) == 40 {
+			e.recheckSet[ih] = struct{}{}
+		}
+	}
+	log.Printf("[DirtyFlag] unclean shutdown detected, %d tasks will be verified", len(e.recheckSet))
+}
+
+// markRecheck makes the task verified once its info is loaded
+func (e *Engine) markRecheck(ih string) {
+	e.recheckMu.Lock()
+	defer e.recheckMu.Unlock()
+	e.recheckSet[ih] = struct{}{}
+}
+
+// takeRecheck reports whether the task needs verification and clears the mark
+func (e *Engine) takeRecheck(ih string) bool {
+	e.recheckMu.Lock()
+	defer e.recheckMu.Unlock()
+	if _, ok := e.recheckSet[ih]; ok {
+		delete(e.recheckSet, ih)
+		return true
+	}
+	return false
+}
+
+// writingTasks lists tasks that are started but not done
+func (e *Engine) writingTasks() []string {
+	e.RLock()
+	defer e.RUnlock()
+	var ihs []string
+	for ih, t := range e.ts {
+		if t.Loaded && t.Started && !t.Done {
+			ihs = append(ihs, ih)
+		}
+	}
+	sort.Strings(ihs)
+	return ihs
+}
+
+// dirtyFlagRoutine keeps the flag file in sync with the tasks being written
+func (e *Engine) dirtyFlagRoutine(closeSync chan struct{}) {
+	var last string
+	tk := time.NewTicker(dirtyFlagInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			cur := strings.Join(e.writingTasks(), "\n")
+			if cur == last {
+				continue
+			}
+			if cur == "" {
+				if err := os.Remove(e.dirtyFlagPath()); err != nil && !os.IsNotExist(err) {
+					log.Warn("[DirtyFlag] failed to remove flag file", err)
+				}
+			} else if err := os.WriteFile(e.dirtyFlagPath(), []byte(cur), 0644); err != nil {
+				log.Warn("[DirtyFlag] failed to write flag file", err)
+			}
+			last = cur
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+// recheckTorrent verifies all the pieces of a task that may be corrupted
+func (e *Engine) recheckTorrent(t *Torrent) {
+	if !t.beginVerify() {
+		return
+	}
+	log.Println("[Recheck] verifying", t.InfoHash)
+	bad := e.verifyTorrent(t)
+	log.Printf("[Recheck] verified %s, %d bad pieces", t.InfoHash, bad)
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// a task finished that long ago is fully aged in the score
+const reclaimFullAge = 30 * 24 * time.Hour
+
+// reclaimWeights are the weights of the factors of ReclaimScoring
+type reclaimWeights struct {
+	Age, Ratio, Tracker float64
+}
+
+// parseReclaimScoring parses "age:<weight>,ratio:<weight>,tracker:<weight>",
+// the factors not listed weigh 0
+func parseReclaimScoring(s string) (reclaimWeights, error) {
+	var w reclaimWeights
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return w, fmt.Errorf("invalid ReclaimScoring %q", item)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || v < 0 {
+			return w, fmt.Errorf("invalid ReclaimScoring weight %q", item)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "age":
+			w.Age = v
+		case "ratio":
+			w.Ratio = v
+		case "tracker":
+			w.Tracker = v
+		default:
+			return w, fmt.Errorf("invalid ReclaimScoring factor %q, expecting age, ratio or tracker", kv[0])
+		}
+	}
+	return w, nil
+}
+
+// reclaimScore scores a completed task, the higher is removed first: the age
+// since finished up to reclaimFullAge, the ratio up to the target ratio, and
+// not being of an important tracker, each from 0 to 1 by its weight
+func reclaimScore(w reclaimWeights, age time.Duration, ratio, target float32, important bool) float64 {
+	a := float64(age) / float64(reclaimFullAge)
+	if a > 1 {
+		a = 1
+	} else if a < 0 {
+		a = 0
+	}
+	if target < 1 {
+		target = 1
+	}
+	r := float64(ratio / target)
+	if r > 1 {
+		r = 1
+	}
+	var t float64
+	if !important {
+		t = 1
+	}
+	return w.Age*a + w.Ratio*r + w.Tracker*t
+}
+
+// importantTracker tells whether an announce URL has a host of ReclaimTrackers
+func importantTracker(hosts string, trackers []string) bool {
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		for _, tr := range trackers {
+			if strings.Contains(tr, h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type reclaimCandidate struct {
+	ih, name   string
+	size       int64
+	score      float64
+	finishedAt time.Time
+}
+
+// reclaimSpace removes the completed tasks on the disk of dse, the least
+// valuable first, with their data until the space lacking is freed. Nothing
+// is removed when they can't free enough. The task self is kept. Must be
+// called with e unlocked.
+func (e *Engine) reclaimSpace(dse *DiskSpaceError, self string) bool {
+	e.RLock()
+	enabled, scoring, hosts, target := e.config.ReclaimSpace, e.config.ReclaimScoring, e.config.ReclaimTrackers, e.config.SeedRatio
+	e.RUnlock()
+	if !enabled {
+		return false
+	}
+	w, err := parseReclaimScoring(scoring)
+	if err != nil {
+		log.Warn("[Reclaim]", err)
+		return false
+	}
+	lacking := dse.Need + dse.Reserve - dse.Free
+	if lacking <= 0 {
+		return false
+	}
+
+	parts, _ := disk.Partitions(false)
+	mount := mountPoint(parts, dse.Dir)
+	now := time.Now()
+	var list []reclaimCandidate
+	for ih, t := range e.Torrents() {
+		if ih == self {
+			continue
+		}
+		// outside the task lock, the trackers take the client lock
+		trackers, _ := e.TorrentTrackers(ih)
+		t.Lock()
+		if t.Done && mountPoint(parts, e.taskPath(t)) == mount {
+			c := reclaimCandidate{ih: ih, name: t.Name, finishedAt: t.FinishedAt}
+			for _, f := range t.Files {
+				if f != nil {
+					c.size += f.Completed
+				}
+			}
+			c.score = reclaimScore(w, now.Sub(t.FinishedAt), t.SeedRatio, target, importantTracker(hosts, flattenTrackers(trackers)))
+			list = append(list, c)
+		}
+		t.Unlock()
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score > list[j].score
+		}
+		return list[i].finishedAt.Before(list[j].finishedAt)
+	})
+
+	var freed int64
+	n := 0
+	for n < len(list) && freed < lacking {
+		freed += list[n].size
+		n++
+	}
+	if freed < lacking {
+		log.Printf("[Reclaim] %s lacking in %s, the completed tasks have %s", humanize.IBytes(uint64(lacking)), mount, humanize.IBytes(uint64(freed)))
+		return false
+	}
+	for _, c := range list[:n] {
+		log.Printf("[Reclaim] %s %s removed with its %s, score %.2f", c.ih, c.name, humanize.IBytes(uint64(c.size)), c.score)
+		e.setDeleteReason(c.ih, DeleteReasonReclaim)
+		if err := e.RemoveTorrentData(c.ih, RemoveDataDelete); err != nil {
+			log.Warn("[Reclaim]", c.ih, err)
+			return false
+		}
+	}
+	return true
+}
+
+// reclaimForStart makes room for the task to be started
+func (e *Engine) reclaimForStart(infohash string) {
+	e.RLock()
+	var err error
+	if e.config.ReclaimSpace {
+		var t *Torrent
+		if t, err = e.getTorrent(infohash); err == nil {
+			err = e.checkDiskSpace(t)
+		}
+	}
+	e.RUnlock()
+	var dse *DiskSpaceError
+	if errors.As(err, &dse) {
+		e.reclaimSpace(dse, infohash)
+	}
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseReclaimScoring(t *testing.T) {
+	w, err := parseReclaimScoring("age:1, ratio:2.5")
+	if err != nil || w != (reclaimWeights{Age: 1, Ratio: 2.5}) {
+		t.Errorf("parseReclaimScoring() = %+v %v", w, err)
+	}
+	for _, s := range []string{"age", "age:x", "age:-1", "size:1"} {
+		if _, err := parseReclaimScoring(s); err == nil {
+			t.Errorf("parseReclaimScoring(%q) expecting error", s)
+		}
+	}
+}
+
+func Test_reclaimScore(t *testing.T) {
+	w := reclaimWeights{Age: 1, Ratio: 1, Tracker: 1}
+	day := 24 * time.Hour
+	tests := []struct {
+		name      string
+		age       time.Duration
+		ratio     float32
+		target    float32
+		important bool
+		want      float64
+	}{
+		{"new", 0, 0, 0, true, 0},
+		{"aged", 60 * day, 0, 0, true, 1},
+		{"half aged", 15 * day, 0, 0, true, 0.5},
+		{"ratio to target", 0, 1, 2, true, 0.5},
+		{"ratio over", 0, 3, 0, true, 1},
+		{"not important", 0, 0, 0, false, 1},
+		{"all", 90 * day, 5, 2, false, 3},
+	}
+	for _, tt := range tests {
+		if got := reclaimScore(w, tt.age, tt.ratio, tt.target, tt.important); got != tt.want {
+			t.Errorf("%s: reclaimScore() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_importantTracker(t *testing.T) {
+	trackers := []string{"udp://open.tracker.org:1337/announce", "https://private.org/announce?passkey=x"}
+	if !importantTracker("private.org", trackers) {
+		t.Error("importantTracker() missed private.org")
+	}
+	if importantTracker(" ,other.org", trackers) {
+		t.Error("importantTracker() matched other.org")
+	}
+}
@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+const (
+	// RemoveData values, what to do with the data of a removed task
+	RemoveDataKeep   = "keep"
+	RemoveDataTrash  = "trash"
+	RemoveDataDelete = "delete"
+
+	// TrashDataDir is made in the download directory of the task, for the
+	// data to be moved without crossing disks
+	TrashDataDir = ".trashData"
+	// recycleBinFile keeps where the trashed data is
+	recycleBinFile   = ".recycleBin.json"
+	recyclePurgeTick = time.Hour
+)
+
+var errDataPath = errors.New("invalid data path of the task")
+
+// TrashedData is the data of a removed task in the recycle bin, purged after
+// TrashRetention
+type TrashedData struct {
+	InfoHash  string
+	Name      string
+	Path      string
+	TrashedAt time.Time
+}
+
+type recycleBin struct {
+	sync.Mutex
+	// by path, nil before loaded
+	items map[string]TrashedData
+}
+
+// RecycleBin lists the trashed data, the latest first
+func (e *Engine) RecycleBin() []TrashedData {
+	e.recycle.Lock()
+	defer e.recycle.Unlock()
+	e.loadRecycleBin()
+	list := []TrashedData{}
+	for _, d := range e.recycle.items {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].TrashedAt.After(list[j].TrashedAt) })
+	return list
+}
+
+// RemoveTorrentData removes the task as stopRemoveTask does, then keeps,
+// trashes or deletes its data by mode
+func (e *Engine) RemoveTorrentData(infohash, mode string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	name, dir := t.Name, e.taskPath(t)
+	started := t.Started
+	t.Unlock()
+
+	if started {
+		if err := e.StopTorrent(infohash); err != nil {
+			return err
+		}
+	}
+	e.RemoveCache(infohash)
+	if err := e.DeleteTorrent(infohash); err != nil {
+		return err
+	}
+	// the files are closed once the task is dropped
+	return e.removeData(infohash, name, dir, mode)
+}
+
+// removeData trashes or deletes dir/name, the data of a removed task
+func (e *Engine) removeData(ih, name, dir, mode string) error {
+	// no data before the info is got
+	if name == "" || (mode != RemoveDataTrash && mode != RemoveDataDelete) {
+		return nil
+	}
+	src, err := dataPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(src); os.IsNotExist(err) {
+		return nil
+	}
+
+	if mode == RemoveDataDelete {
+		log.Printf("[RemoveData] %s delete %s", ih, src)
+		return os.RemoveAll(src)
+	}
+
+	dst := filepath.Join(dir, TrashDataDir, ih, name)
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	log.Printf("[RemoveData] %s moved to %s", ih, dst)
+
+	e.recycle.Lock()
+	defer e.recycle.Unlock()
+	e.loadRecycleBin()
+	e.recycle.items[dst] = TrashedData{InfoHash: ih, Name: name, Path: dst, TrashedAt: time.Now()}
+	e.saveRecycleBin()
+	return nil
+}
+
+// dataPath joins the name of the task to dir, the name must be a single
+// element of the path
+func dataPath(dir, name string) (string, error) {
+	p := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, p)
+	if err != nil || rel == "." || rel == ".." || strings.ContainsRune(rel, filepath.Separator) {
+		return "", errDataPath
+	}
+	return p, nil
+}
+
+// recycleRoutine purges the trashed data older than TrashRetention
+func (e *Engine) recycleRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(recyclePurgeTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			if e.config.TrashRetention > 0 {
+				e.purgeRecycleBin(time.Now().Add(-e.config.TrashRetention))
+			}
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+// purgeRecycleBin deletes the data trashed before
+func (e *Engine) purgeRecycleBin(before time.Time) {
+	e.recycle.Lock()
+	defer e.recycle.Unlock()
+	e.loadRecycleBin()
+	changed := false
+	for p, d := range e.recycle.items {
+		if d.TrashedAt.After(before) {
+			continue
+		}
+		// the <infohash> dir holding the data
+		if err := os.RemoveAll(filepath.Dir(p)); err != nil {
+			log.Warn("[RecycleBin] purge", err)
+			continue
+		}
+		log.Printf("[RecycleBin] purged %s", p)
+		delete(e.recycle.items, p)
+		changed = true
+	}
+	if changed {
+		e.saveRecycleBin()
+	}
+}
+
+// loadRecycleBin must hold lock
+func (e *Engine) loadRecycleBin() {
+	if e.recycle.items != nil {
+		return
+	}
+	e.recycle.items = make(map[string]TrashedData)
+	data, err := ioutil.ReadFile(filepath.Join(e.cacheDir, recycleBinFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("[RecycleBin]", err)
+		}
+		return
+	}
+	common.HandleError(json.Unmarshal(data, &e.recycle.items))
+}
+
+// saveRecycleBin must hold lock
+func (e *Engine) saveRecycleBin() {
+	data, err := json.Marshal(e.recycle.items)
+	if err != nil {
+		log.Warn("[RecycleBin]", err)
+		return
+	}
+	common.HandleError(ioutil.WriteFile(filepath.Join(e.cacheDir, recycleBinFile), data, 0644))
+}
@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDataPath(t *testing.T) {
+	dir := filepath.FromSlash("/srv/downloads")
+	tests := []struct {
+		name string
+		want string
+		err  bool
+	}{
+		{"ubuntu.iso", filepath.Join(dir, "ubuntu.iso"), false},
+		{"Some Show S01", filepath.Join(dir, "Some Show S01"), false},
+		{"", "", true},
+		{".", "", true},
+		{"..", "", true},
+		{"../etc", "", true},
+		{"a/b", "", true},
+	}
+	for _, tt := range tests {
+		got, err := dataPath(dir, tt.name)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("dataPath(%q) = %q, %v", tt.name, got, err)
+		}
+	}
+}
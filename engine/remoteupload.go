@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine/upload"
+)
+
+var (
+	errNoUploadRemote = errors.New("UploadRemote not configured")
+	errUploading      = errors.New("task already uploading")
+	errNotCompleted   = errors.New("task not completed")
+)
+
+// uploader returns the rclone of UploadRemote, nil without it
+func (e *Engine) uploader() (*upload.Rclone, error) {
+	c := e.Config()
+	if c.UploadRemote == "" {
+		return nil, nil
+	}
+	args, err := splitCommandLine(c.UploadArgs)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid UploadArgs: %w", err)
+	}
+	return &upload.Rclone{
+		Remote: c.UploadRemote,
+		Args:   args,
+		Started: func(cmd *exec.Cmd) {
+			e.background.niceCmd(cmd.Process.Pid)
+		},
+	}, nil
+}
+
+// UploadTorrent uploads the completed task to UploadRemote again, eg: after
+// a failure
+func (e *Engine) UploadTorrent(infohash string) error {
+	if e.Config().UploadRemote == "" {
+		return errNoUploadRemote
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	done, uploading := t.Done, t.RemoteUploading
+	t.Unlock()
+	if !done {
+		return errNotCompleted
+	}
+	if uploading {
+		return errUploading
+	}
+	go e.uploadCompleted(infohash)
+	return nil
+}
+
+// uploadCompleted uploads the data of a completed task to UploadRemote, one
+// task at a time, then removes it by UploadRemoveData. The progress and the
+// result are on the RemoteUpload fields of the task.
+func (e *Engine) uploadCompleted(infohash string) {
+	up, err := e.uploader()
+	if up == nil && err == nil {
+		return
+	}
+	root := e.TorrentDataPath(infohash)
+	e.RLock()
+	t, terr := e.getTorrent(infohash)
+	closeSync := e.closeSync
+	e.RUnlock()
+	if terr != nil || root == "" {
+		return
+	}
+	t.Lock()
+	if t.RemoteUploading {
+		t.Unlock()
+		return
+	}
+	name := t.Name
+	t.RemoteUploading = true
+	t.RemoteUploadPercent = 0
+	t.RemoteUploadSpeed = 0
+	t.RemoteUploadError = ""
+	t.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.dropWait:
+		case <-closeSync:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	if err == nil {
+		select {
+		case e.uploads <- struct{}{}:
+			log.Printf("[Upload] %s uploading to %s", infohash, up.Remote)
+			err = up.Upload(ctx, root, func(p upload.Progress) {
+				t.Lock()
+				t.RemoteUploadPercent = percent(p.Bytes, p.Total)
+				t.RemoteUploadSpeed = float32(p.Speed)
+				t.Unlock()
+			})
+			<-e.uploads
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() != nil {
+		t.Lock()
+		t.RemoteUploading = false
+		t.RemoteUploadSpeed = 0
+		t.Unlock()
+		return
+	}
+
+	t.Lock()
+	t.RemoteUploading = false
+	t.RemoteUploadSpeed = 0
+	if err != nil {
+		t.RemoteUploadError = err.Error()
+	} else {
+		t.RemoteUploadPercent = 100
+		t.RemoteUploadedAt = time.Now()
+	}
+	t.Unlock()
+	e.recordHook(infohash, name, "upload "+e.Config().UploadRemote, err)
+	if err != nil {
+		log.Warnf("[Upload] %s: %v", infohash, err)
+		e.emit(EventError, t, fmt.Errorf("upload: %w", err))
+		return
+	}
+	log.Printf("[Upload] %s done", infohash)
+
+	switch mode := e.Config().UploadRemoveData; mode {
+	case RemoveDataTrash, RemoveDataDelete:
+		e.setDeleteReason(infohash, DeleteReasonUploaded)
+		if err := e.RemoveTorrentData(infohash, mode); err != nil {
+			log.Warnf("[Upload] %s remove: %v", infohash, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sessionName returns the name the task's data was renamed to, empty if it
+// wasn't. The storage reads it, so the task isn't locked.
+func (e *Engine) sessionName(ih string) string {
+	e.sessions.Lock()
+	defer e.sessions.Unlock()
+	return e.sessions.m[ih].Name
+}
+
+// renamedPath replaces the leading name from of the file path p by to
+func renamedPath(p, from, to string) string {
+	switch {
+	case to == "" || from == to:
+		return p
+	case p == from:
+		return to
+	case strings.HasPrefix(p, from+"/"):
+		return to + p[len(from):]
+	}
+	return p
+}
+
+// RenameTorrent renames the task and its data in place, the task is reloaded
+// to store under the new name and keeps seeding
+func (e *Engine) RenameTorrent(infohash, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	ih, old, dir, tt := t.InfoHash, t.Name, t.DownloadDir, t.t
+	t.Unlock()
+	if tt == nil || tt.Info() == nil {
+		return errNotLoaded
+	}
+	if name == old {
+		return nil
+	}
+	if dir == "" {
+		if dir, err = filepath.Abs(e.config.DownloadDirectory); err != nil {
+			return err
+		}
+	}
+	src, dst := filepath.Join(dir, old), filepath.Join(dir, name)
+	if _, err := os.Lstat(dst); err == nil {
+		return errFileExists
+	}
+	infoName := tt.Info().Name
+	log.Printf("[RenameTorrent] %s from %q to %q", ih, old, name)
+
+	err = e.reloadTask(t, func(s *taskSession) error {
+		// nothing downloaded yet if missing
+		if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+			// added back in place
+			return err
+		}
+		s.Name = name
+		if name == infoName {
+			s.Name = ""
+		}
+		return nil
+	})
+	// the data is only found under the saved name after a restart
+	e.saveSession()
+	return err
+}
+
+// SetTorrentLocation moves the data of the task to dir, "" for the download
+// directory, the task is reloaded and rechecked there
+func (e *Engine) SetTorrentLocation(infohash, dir string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	return e.relocateTask(t, dir)
+}
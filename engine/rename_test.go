@@ -0,0 +1,20 @@
+package engine
+
+import "testing"
+
+func Test_renamedPath(t *testing.T) {
+	tests := []struct {
+		p, from, to string
+		want        string
+	}{
+		{"Show/s01/e01.mkv", "Show", "", "Show/s01/e01.mkv"},
+		{"Show/s01/e01.mkv", "Show", "Series", "Series/s01/e01.mkv"},
+		{"movie.mkv", "movie.mkv", "film.mkv", "film.mkv"},
+		{"Shows/e01.mkv", "Show", "Series", "Shows/e01.mkv"},
+	}
+	for _, tt := range tests {
+		if got := renamedPath(tt.p, tt.from, tt.to); got != tt.want {
+			t.Errorf("renamedPath(%q, %q, %q) = %q, want %q", tt.p, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
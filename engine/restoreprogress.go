@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+)
+
+// RestoreProgress tells how far the restoring of the cached tasks is, with
+// the rechecks it's followed by after an unclean shutdown
+type RestoreProgress struct {
+	Restoring bool
+	Restored  int
+	Total     int
+	// tasks to recheck once their info is loaded
+	PendingChecks int
+	Checking      []CheckProgress
+}
+
+// CheckProgress is a task being hash checked
+type CheckProgress struct {
+	InfoHash string
+	Name     string
+	Percent  float32
+}
+
+type restoreState struct {
+	sync.Mutex
+	restoring       bool
+	restored, total int
+}
+
+// beginRestore starts counting the restoring of n cached tasks
+func (e *Engine) beginRestore(n int) {
+	e.restore.Lock()
+	defer e.restore.Unlock()
+	e.restore.restoring = true
+	e.restore.restored, e.restore.total = 0, n
+}
+
+func (e *Engine) restoredOne() {
+	e.restore.Lock()
+	defer e.restore.Unlock()
+	e.restore.restored++
+}
+
+func (e *Engine) endRestore() {
+	e.restore.Lock()
+	defer e.restore.Unlock()
+	e.restore.restoring = false
+	log.Printf("[RestoreCacheDir] restored %d of %d tasks", e.restore.restored, e.restore.total)
+}
+
+// RestoreProgress reports the restoring of the cached tasks and the hash
+// checks running
+func (e *Engine) RestoreProgress() RestoreProgress {
+	e.restore.Lock()
+	p := RestoreProgress{
+		Restoring: e.restore.restoring,
+		Restored:  e.restore.restored,
+		Total:     e.restore.total,
+	}
+	e.restore.Unlock()
+
+	e.recheckMu.Lock()
+	p.PendingChecks = len(e.recheckSet)
+	e.recheckMu.Unlock()
+
+	for _, t := range e.Torrents() {
+		t.Lock()
+		if t.Verifying {
+			p.Checking = append(p.Checking, CheckProgress{InfoHash: t.InfoHash, Name: t.Name, Percent: t.VerifyPercent})
+		}
+		t.Unlock()
+	}
+	sort.Slice(p.Checking, func(i, j int) bool { return p.Checking[i].InfoHash < p.Checking[j].InfoHash })
+	return p
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+const (
+	// reverifiedFile keeps the last verification time of the tasks
+	reverifiedFile = ".reverified.json"
+	// at most one task is verified on each tick to limit the disk I/O
+	reverifyTick = 10 * time.Minute
+)
+
+// reverifyRoutine verifies the completed tasks every ReverifyInterval to
+// catch silent corruption of the data, one task at a time
+func (e *Engine) reverifyRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(reverifyTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			if e.config.ReverifyInterval > 0 {
+				e.reverifyNext()
+			}
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+func (e *Engine) loadReverified() map[string]time.Time {
+	last := make(map[string]time.Time)
+	data, err := ioutil.ReadFile(filepath.Join(e.cacheDir, reverifiedFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("[Reverify]", err)
+		}
+		return last
+	}
+	common.HandleError(json.Unmarshal(data, &last))
+	return last
+}
+
+func (e *Engine) saveReverified(last map[string]time.Time) {
+	data, err := json.Marshal(last)
+	if err != nil {
+		log.Warn("[Reverify]", err)
+		return
+	}
+	common.HandleError(ioutil.WriteFile(filepath.Join(e.cacheDir, reverifiedFile), data, 0644))
+}
+
+// reverifyNext verifies the completed task verified longest ago if it's due.
+// Tasks seen the first time are due after a full interval.
+func (e *Engine) reverifyNext() {
+	last := e.loadReverified()
+	now := time.Now()
+
+	var due *Torrent
+	var dueAt time.Time
+	current := make(map[string]time.Time)
+	e.RLock()
+	for ih, t := range e.ts {
+		at, ok := last[ih]
+		if !ok {
+			at = now
+		}
+		current[ih] = at
+		if !t.Loaded || !t.Done || t.t == nil || now.Sub(at) < e.config.ReverifyInterval {
+			continue
+		}
+		if due == nil || at.Before(dueAt) {
+			due, dueAt = t, at
+		}
+	}
+	e.RUnlock()
+
+	if due != nil {
+		current[due.InfoHash] = now
+		e.reverifyTorrent(due)
+	}
+	// the removed tasks are dropped
+	e.saveReverified(current)
+}
+
+// reverifyTorrent verifies all the pieces, the ones went bad are downloaded again
+func (e *Engine) reverifyTorrent(t *Torrent) {
+	if !t.beginVerify() {
+		return
+	}
+	log.Println("[Reverify] verifying", t.InfoHash)
+	bad := e.verifyTorrent(t)
+	log.Printf("[Reverify] verified %s, %d bad pieces", t.InfoHash, bad)
+}
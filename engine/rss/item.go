@@ -0,0 +1,101 @@
+package rss
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mmcdole/gofeed"
+)
+
+var (
+	magnetExp   = regexp.MustCompile(`magnet:[^< ]+`)
+	hashinfoExp = regexp.MustCompile(`[0-9a-zA-Z]{40}`)
+	torrentExp  = regexp.MustCompile(`\.torrent`)
+)
+
+// Links are the torrent links found in a feed item
+type Links struct {
+	Magnet   string
+	InfoHash string
+	Torrent  string
+	Size     string
+}
+
+// Found reports whether any link is found
+func (l *Links) Found() bool {
+	return l.Magnet != "" || l.InfoHash != "" || l.Torrent != ""
+}
+
+// FindLinks looks for the magnet/infohash/torrent of the item
+func FindLinks(i *gofeed.Item) Links {
+	var l Links
+	for _, ex := range []string{"torrent", "nyaa"} {
+		if ok := l.readExtention(i, ex); ok {
+			break
+		}
+	}
+
+	// some sites put it under enclosures
+	for _, e := range i.Enclosures {
+		if strings.HasPrefix(e.URL, "magnet:") {
+			l.Magnet = e.URL
+		} else if torrentExp.Match([]byte(e.URL)) {
+			l.Torrent = e.URL
+		}
+	}
+
+	// maybe the Link is a torrent file
+	if torrentExp.MatchString(i.Link) {
+		l.Torrent = i.Link
+	}
+
+	// not found magnet/torrent, try to find them in the description
+	if !l.Found() {
+
+		// try to find magnet in description
+		if s := magnetExp.FindString(i.Description); s != "" {
+			l.Magnet = s
+		}
+
+		// try to find hashinfo in description
+		if s := hashinfoExp.FindString(i.Description); s != "" {
+			l.InfoHash = s
+		}
+
+		//still not found?, well... whatever
+	}
+
+	return l
+}
+
+func (l *Links) readExtention(i *gofeed.Item, ext string) (found bool) {
+
+	// There are no starndards for rss feeds contains torrents or magnets
+	// Heres some sites putting info in the extentions
+	if etor, ok := i.Extensions[ext]; ok {
+
+		if e, ok := etor["size"]; ok && len(e) > 0 {
+			l.Size = e[0].Value
+		}
+
+		if e, ok := etor["contentLength"]; ok && len(e) > 0 {
+			if size, err := strconv.ParseUint(e[0].Value, 10, 64); err == nil {
+				l.Size = humanize.Bytes(size)
+			}
+		}
+
+		if e, ok := etor["magnetURI"]; ok && len(e) > 0 {
+			l.Magnet = e[0].Value
+			found = true
+		}
+
+		if e, ok := etor["infoHash"]; ok && len(e) > 0 {
+			l.InfoHash = e[0].Value
+			found = true
+		}
+	}
+
+	return
+}
@@ -0,0 +1,290 @@
+// Package rss polls the configured feeds and adds the items matching the
+// include/exclude rules of each feed, the added items are remembered in a
+// history file so they're never added twice.
+package rss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+	"github.com/mmcdole/gofeed"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultInterval = 15 * time.Minute
+	minInterval     = time.Minute
+	// history entries older than this are pruned
+	historyKeep = 90 * 24 * time.Hour
+	// max size of a .torrent to download (512k)
+	maxTorrentSize = 512 * 1024
+)
+
+var log *logging.Logger
+
+// ErrExists is returned by the Adder for an item already added, it goes to
+// the history as the added ones
+var ErrExists = errors.New("already added")
+
+// Adder adds the matched items to the engine, dir is the download
+// directory of the feed, empty for the default
+type Adder interface {
+	AddMagnet(magnet, dir string) error
+	AddTorrent(r io.Reader, dir string) error
+}
+
+// Feed is a rule of the rules file
+type Feed struct {
+	URL      string        `yaml:"url"`
+	Include  string        `yaml:"include"`
+	Exclude  string        `yaml:"exclude"`
+	Dir      string        `yaml:"dir"`
+	Interval time.Duration `yaml:"interval"`
+
+	include, exclude *regexp.Regexp
+}
+
+func (f *Feed) compile() error {
+	var err error
+	if f.Include != "" {
+		if f.include, err = regexp.Compile(f.Include); err != nil {
+			return fmt.Errorf("include: %w", err)
+		}
+	}
+	if f.Exclude != "" {
+		if f.exclude, err = regexp.Compile(f.Exclude); err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+	}
+	if f.Interval < minInterval {
+		f.Interval = defaultInterval
+	}
+	return nil
+}
+
+// Match tests the title against the rules, no include rule means all
+func (f *Feed) Match(title string) bool {
+	if f.include != nil && !f.include.MatchString(title) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.MatchString(title) {
+		return false
+	}
+	return true
+}
+
+// LoadRules reads the feeds from a yaml file, eg:
+//  - url: https://example.com/rss
+//    include: (?i)ubuntu.*amd64
+//    exclude: (?i)beta
+//    dir: linux
+//    interval: 30m
+func LoadRules(path string) ([]*Feed, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var feeds []*Feed
+	if err := yaml.Unmarshal(data, &feeds); err != nil {
+		return nil, err
+	}
+	valid := feeds[:0]
+	for _, f := range feeds {
+		if err := f.compile(); err != nil {
+			log.Warnf("feed %s ignored: %v", f.URL, err)
+			continue
+		}
+		valid = append(valid, f)
+	}
+	return valid, nil
+}
+
+// Downloader polls the feeds of the rules file
+type Downloader struct {
+	adder Adder
+	// RulesFile returns the current rules file path, empty disables the downloader
+	RulesFile   func() string
+	historyFile string
+	parser      *gofeed.Parser
+	client      *http.Client
+
+	mu       sync.Mutex
+	history  map[string]time.Time
+	lastPoll map[string]time.Time
+}
+
+func New(a Adder, rulesFile func() string, historyFile string) *Downloader {
+	client := &http.Client{Timeout: 60 * time.Second}
+	parser := gofeed.NewParser()
+	parser.Client = client
+	d := &Downloader{
+		adder:       a,
+		RulesFile:   rulesFile,
+		historyFile: historyFile,
+		parser:      parser,
+		client:      client,
+		history:     make(map[string]time.Time),
+		lastPoll:    make(map[string]time.Time),
+	}
+	d.loadHistory()
+	return d
+}
+
+func (d *Downloader) loadHistory() {
+	data, err := ioutil.ReadFile(d.historyFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn("load history", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &d.history); err != nil {
+		log.Warn("load history", err)
+	}
+}
+
+// saveHistory must hold lock
+func (d *Downloader) saveHistory() {
+	for k, t := range d.history {
+		if time.Since(t) > historyKeep {
+			delete(d.history, k)
+		}
+	}
+	data, err := json.Marshal(d.history)
+	if err != nil {
+		log.Warn("save history", err)
+		return
+	}
+	tmp := d.historyFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Warn("save history", err)
+		return
+	}
+	if err := os.Rename(tmp, d.historyFile); err != nil {
+		log.Warn("save history", err)
+	}
+}
+
+// Run checks the feeds every minute and polls the ones due
+func (d *Downloader) Run() {
+	tk := time.NewTicker(minInterval)
+	defer tk.Stop()
+	for {
+		d.pollDue()
+		<-tk.C
+	}
+}
+
+func (d *Downloader) pollDue() {
+	path := d.RulesFile()
+	if path == "" {
+		return
+	}
+	feeds, err := LoadRules(path)
+	if err != nil {
+		log.Warn("load rules", err)
+		return
+	}
+	for _, f := range feeds {
+		d.mu.Lock()
+		last, ok := d.lastPoll[f.URL]
+		d.mu.Unlock()
+		if ok && time.Since(last) < f.Interval {
+			continue
+		}
+		d.Poll(f)
+	}
+}
+
+// Poll fetches the feed and adds the new matching items
+func (d *Downloader) Poll(f *Feed) {
+	d.mu.Lock()
+	d.lastPoll[f.URL] = time.Now()
+	d.mu.Unlock()
+
+	feed, err := d.parser.ParseURL(f.URL)
+	if err != nil {
+		log.Warnf("parse feed %s: %v", f.URL, err)
+		return
+	}
+
+	var recorded int
+	for _, item := range feed.Items {
+		if !f.Match(item.Title) {
+			continue
+		}
+		key := item.GUID
+		if key == "" {
+			key = item.Link
+		}
+		d.mu.Lock()
+		_, seen := d.history[key]
+		d.mu.Unlock()
+		if seen {
+			continue
+		}
+
+		err := d.add(item, f.Dir)
+		switch {
+		case errors.Is(err, ErrExists):
+			log.Printf("%q from %s already added", item.Title, f.URL)
+		case err != nil:
+			log.Warnf("add %q from %s: %v", item.Title, f.URL, err)
+			continue
+		default:
+			log.Printf("added %q from %s", item.Title, f.URL)
+		}
+		recorded++
+		d.mu.Lock()
+		d.history[key] = time.Now()
+		d.mu.Unlock()
+	}
+
+	if recorded > 0 {
+		d.mu.Lock()
+		d.saveHistory()
+		d.mu.Unlock()
+	}
+}
+
+func (d *Downloader) add(item *gofeed.Item, dir string) error {
+	l := FindLinks(item)
+	switch {
+	case l.Magnet != "":
+		return d.adder.AddMagnet(l.Magnet, dir)
+	case l.Torrent != "":
+		return d.addTorrentURL(l.Torrent, dir)
+	case l.InfoHash != "":
+		return d.adder.AddMagnet("magnet:?xt=urn:btih:"+strings.ToLower(l.InfoHash), dir)
+	}
+	return errors.New("no magnet or torrent found")
+}
+
+func (d *Downloader) addTorrentURL(url, dir string) error {
+	resp, err := d.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength > maxTorrentSize {
+		return errors.New("remote torrent too large")
+	}
+	return d.adder.AddTorrent(io.LimitReader(resp.Body, maxTorrentSize), dir)
+}
+
+func init() {
+	log = logging.New("RSS")
+}
@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// modes of the alternative rates
+const (
+	AltRateAuto = "auto"
+	AltRateOn   = "on"
+	AltRateOff  = "off"
+)
+
+const scheduleTick = time.Minute
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// AltRate is the state of the alternative rates, AltUploadRate/AltDownloadRate
+// take place of the UploadRate/DownloadRate when Active. In the auto mode they're
+// active by the AltRateSchedule, on and off are the manual overrides.
+type AltRate struct {
+	sync.Mutex
+	Mode   string
+	Active bool
+}
+
+// AltRate is the current state of the alternative rates
+func (e *Engine) AltRate() *AltRate {
+	return &e.altRate
+}
+
+// SetAltRateMode switches the alternative rates on, off or back to the schedule
+func (e *Engine) SetAltRateMode(mode string) error {
+	switch mode {
+	case AltRateAuto, AltRateOn, AltRateOff:
+	default:
+		return fmt.Errorf("invalid mode %q, expecting auto, on or off", mode)
+	}
+	e.altRate.Lock()
+	e.altRate.Mode = mode
+	e.altRate.Unlock()
+	log.Println("[AltRate] mode", mode)
+	e.applyRateLimits()
+	return nil
+}
+
+// altRateActive tells whether the alternative rates are in effect now, by the
+// manual mode or the schedule, the state is updated
+func (e *Engine) altRateActive(schedule string, now time.Time) bool {
+	a := &e.altRate
+	a.Lock()
+	defer a.Unlock()
+	var active bool
+	switch a.Mode {
+	case AltRateOn:
+		active = true
+	case AltRateOff:
+	default:
+		a.Mode = AltRateAuto
+		if strings.TrimSpace(schedule) != "" {
+			rules, err := parseSchedule(schedule)
+			if err != nil {
+				log.Warn("[AltRate]", err)
+			}
+			active = inSchedule(rules, now)
+		}
+	}
+	if active != a.Active {
+		log.Println("[AltRate] active", active)
+		a.Active = active
+	}
+	return active
+}
+
+// scheduleRoutine applies the rates as the schedule goes
+func (e *Engine) scheduleRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(scheduleTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			e.RLock()
+			scheduled := strings.TrimSpace(e.config.AltRateSchedule) != ""
+			e.RUnlock()
+			if scheduled {
+				e.applyRateLimits()
+			}
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+// scheduleRule is a weekly time range, to before from spans into the next day,
+// equal from and to is the whole day
+type scheduleRule struct {
+	days     [7]bool
+	from, to int // minutes of the day
+}
+
+func (r scheduleRule) active(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	wd := t.Weekday()
+	switch {
+	case r.from == r.to:
+		return r.days[wd]
+	case r.from < r.to:
+		return r.days[wd] && m >= r.from && m < r.to
+	}
+	return (r.days[wd] && m >= r.from) || (r.days[(wd+6)%7] && m < r.to)
+}
+
+func inSchedule(rules []scheduleRule, t time.Time) bool {
+	for _, r := range rules {
+		if r.active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSchedule parses the lines of "<days> <HH:MM>-<HH:MM>", days are comma
+// separated weekdays or ranges of them, eg: "mon-fri 09:00-18:00", "sat,sun 22:00-06:00",
+// "* 01:00-07:00". The valid lines are returned with the error of the others.
+func parseSchedule(s string) ([]scheduleRule, error) {
+	var rules []scheduleRule
+	var bad []string
+	for _, line := range common.SplitLines(s) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, ok := parseScheduleLine(line)
+		if !ok {
+			bad = append(bad, line)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(bad) > 0 {
+		return rules, fmt.Errorf("invalid schedule: %q", bad)
+	}
+	return rules, nil
+}
+
+func parseScheduleLine(line string) (r scheduleRule, ok bool) {
+	fields := strings.Fields(strings.ToLower(line))
+	if len(fields) != 2 {
+		return r, false
+	}
+
+	for _, d := range strings.Split(fields[0], ",") {
+		if d == "*" {
+			for i := range r.days {
+				r.days[i] = true
+			}
+			continue
+		}
+		first, last := d, d
+		if i := strings.Index(d, "-"); i > 0 {
+			first, last = d[:i], d[i+1:]
+		}
+		wf, ok1 := weekdays[first]
+		wl, ok2 := weekdays[last]
+		if !ok1 || !ok2 {
+			return r, false
+		}
+		for w := wf; ; w = (w + 1) % 7 {
+			r.days[w] = true
+			if w == wl {
+				break
+			}
+		}
+	}
+
+	clock := strings.SplitN(fields[1], "-", 2)
+	if len(clock) != 2 {
+		return r, false
+	}
+	from, err1 := time.Parse("15:04", clock[0])
+	to, err2 := time.Parse("15:04", clock[1])
+	if err1 != nil || err2 != nil {
+		return r, false
+	}
+	r.from = from.Hour()*60 + from.Minute()
+	r.to = to.Hour()*60 + to.Minute()
+	return r, true
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_inSchedule(t *testing.T) {
+	rules, err := parseSchedule(`
+# work hours
+mon-fri 09:00-18:00
+sat,sun 22:00-06:00
+fri-sun 12:00-12:00
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2021-12-20 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2021, 12, 20+day, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"work", at(0, 9, 0), true},
+		{"work end", at(0, 18, 0), false},
+		{"early", at(1, 8, 59), false},
+		{"whole day", at(4, 20, 0), true},
+		{"overnight", at(5, 23, 0), true},
+		{"next day", at(7, 5, 59), true},
+		{"next day end", at(7, 6, 0), false},
+		{"monday night", at(0, 23, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inSchedule(rules, tt.t); got != tt.want {
+				t.Errorf("inSchedule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseSchedule(t *testing.T) {
+	rules, err := parseSchedule("mon 09:00-10:00\nsomeday 09:00-10:00\n* 25:00-01:00\n* 01:00")
+	if err == nil {
+		t.Error("parseSchedule() expecting error of the invalid lines")
+	}
+	if len(rules) != 1 {
+		t.Errorf("parseSchedule() got %d rules, want 1", len(rules))
+	}
+}
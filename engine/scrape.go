@@ -0,0 +1,449 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/tracker/udp"
+	"github.com/anacrolix/torrent/version"
+)
+
+const (
+	scrapeTick = time.Minute
+	// infohashes per scrape request, a BEP 15 packet holds about 74
+	scrapeBatch   = 50
+	scrapeTimeout = 15 * time.Second
+)
+
+var errScrapeUnsupported = errors.New("scrape not supported by the tracker")
+
+// TrackerStatus is a tracker of a task, with the announces of the client and
+// the swarm counts of the last scrape
+type TrackerStatus struct {
+	URL  string
+	Tier int
+	// the last announce succeeded
+	Working bool
+	// of the last announce, empty if working or not announced yet
+	Error string `json:",omitempty"`
+	// peers got by the last announce
+	Peers        int
+	NextAnnounce time.Time `json:",omitempty"`
+	// swarm counts of the last scrape, -1 if unknown
+	Seeders     int
+	Leechers    int
+	Downloaded  int
+	ScrapeError string    `json:",omitempty"`
+	ScrapedAt   time.Time `json:",omitempty"`
+}
+
+// scrapeCounts is the scrape result of a task from a tracker
+type scrapeCounts struct {
+	seeders, leechers, downloaded int
+	err                           string
+	at                            time.Time
+}
+
+// scrapeState are the last scrape results by infohash and tracker
+type scrapeState struct {
+	sync.Mutex
+	m map[string]map[string]scrapeCounts
+}
+
+func (s *scrapeState) set(tracker string, ihs []string, res map[string]scrapeCounts, err error) {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]map[string]scrapeCounts)
+	}
+	for _, ih := range ihs {
+		sc, ok := res[ih]
+		switch {
+		case err != nil:
+			sc = scrapeCounts{err: err.Error()}
+		case !ok:
+			sc = scrapeCounts{err: "not in the scrape response"}
+		}
+		sc.at = now
+		if s.m[ih] == nil {
+			s.m[ih] = make(map[string]scrapeCounts)
+		}
+		s.m[ih][tracker] = sc
+	}
+}
+
+func (s *scrapeState) get(ih string) map[string]scrapeCounts {
+	s.Lock()
+	defer s.Unlock()
+	m := make(map[string]scrapeCounts, len(s.m[ih]))
+	for tr, sc := range s.m[ih] {
+		m[tr] = sc
+	}
+	return m
+}
+
+// prune drops the results of the tasks gone
+func (s *scrapeState) prune(live map[string]bool) {
+	s.Lock()
+	defer s.Unlock()
+	for ih := range s.m {
+		if !live[ih] {
+			delete(s.m, ih)
+		}
+	}
+}
+
+// scrapeRoutine scrapes the trackers of the tasks every ScrapeInterval
+func (e *Engine) scrapeRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(scrapeTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			e.scrapeDue()
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+// scrapeDue scrapes the tasks not scraped for ScrapeInterval, the
+// infohashes of a tracker in batches
+func (e *Engine) scrapeDue() {
+	c := e.Config()
+	if c.ScrapeInterval <= 0 || c.DisableTrackers {
+		return
+	}
+	byTracker := make(map[string][]string)
+	live := make(map[string]bool)
+	var due []*Torrent
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		tt, at := t.t, t.ScrapedAt
+		t.Unlock()
+		if tt == nil {
+			continue
+		}
+		live[ih] = true
+		if !at.IsZero() && time.Since(at) < c.ScrapeInterval {
+			continue
+		}
+		due = append(due, t)
+		mi := tt.Metainfo()
+		for _, tier := range mi.UpvertedAnnounceList() {
+			for _, tr := range tier {
+				byTracker[tr] = append(byTracker[tr], ih)
+			}
+		}
+	}
+	e.scrapes.prune(live)
+	if len(due) == 0 {
+		return
+	}
+
+	s := newScraper(&c)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, trackerProbeWorkers)
+	for tr, ihs := range byTracker {
+		for i := 0; i < len(ihs); i += scrapeBatch {
+			end := i + scrapeBatch
+			if end > len(ihs) {
+				end = len(ihs)
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(tr string, batch []string) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				res, err := s.scrape(tr, batch)
+				e.scrapes.set(tr, batch, res, err)
+			}(tr, ihs[i:end])
+		}
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for _, t := range due {
+		results := e.scrapes.get(t.InfoHash)
+		t.Lock()
+		t.ScrapedAt = now
+		seeders, leechers, ok := swarmCounts(results)
+		if ok {
+			t.SwarmSeeders, t.SwarmLeechers = seeders, leechers
+		}
+		t.Unlock()
+	}
+}
+
+// swarmCounts are the largest counts of the trackers scraped, not ok if
+// none answered
+func swarmCounts(results map[string]scrapeCounts) (seeders, leechers int, ok bool) {
+	for _, sc := range results {
+		if sc.err != "" {
+			continue
+		}
+		ok = true
+		if sc.seeders > seeders {
+			seeders = sc.seeders
+		}
+		if sc.leechers > leechers {
+			leechers = sc.leechers
+		}
+	}
+	return
+}
+
+type scraper struct {
+	client    *http.Client
+	noUDP     bool
+	userAgent string
+}
+
+func newScraper(c *Config) *scraper {
+	p := newTrackerProber(c)
+	s := &scraper{client: p.client, noUDP: p.noUDP, userAgent: c.UserAgent}
+	if s.userAgent == "" {
+		s.userAgent = version.DefaultHttpUserAgent
+	}
+	return s
+}
+
+// scrape returns the counts of the infohashes from the tracker
+func (s *scraper) scrape(tracker string, ihs []string) (map[string]scrapeCounts, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+	switch u.Scheme {
+	case "udp", "udp4", "udp6":
+		if s.noUDP {
+			return nil, fmt.Errorf("udp tracker bypasses the proxy")
+		}
+		return scrapeUDP(ctx, u, ihs)
+	case "http", "https":
+		return s.scrapeHTTP(ctx, u, ihs)
+	}
+	return nil, errScrapeUnsupported
+}
+
+// scrapeUDP scrapes a BEP 15 tracker
+func scrapeUDP(ctx context.Context, u *url.URL, ihs []string) (map[string]scrapeCounts, error) {
+	req := make([]udp.InfoHash, len(ihs))
+	for i, ih := range ihs {
+		if _, err := hex.Decode(req[i][:], []byte(ih)); err != nil {
+			return nil, err
+		}
+	}
+	network := "udp"
+	if u.Scheme != "udp" {
+		network = u.Scheme
+	}
+	cc, err := udp.NewConnClient(udp.NewConnClientOpts{Network: network, Host: u.Host})
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+	resp, err := cc.Client.Scrape(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// in the order of the request
+	res := make(map[string]scrapeCounts, len(resp))
+	for i, r := range resp {
+		res[ihs[i]] = scrapeCounts{seeders: int(r.Seeders), leechers: int(r.Leechers), downloaded: int(r.Completed)}
+	}
+	return res, nil
+}
+
+// scrapeURL is the scrape URL of an announce URL by the convention of the
+// trackers: the last path element "announce" replaced by "scrape"
+func scrapeURL(u *url.URL) (*url.URL, error) {
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return nil, errScrapeUnsupported
+	}
+	su := *u
+	su.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	su.RawPath = ""
+	return &su, nil
+}
+
+// scrapeHTTP scrapes a BEP 48 tracker, the infohashes in one request
+func (s *scraper) scrapeHTTP(ctx context.Context, u *url.URL, ihs []string) (map[string]scrapeCounts, error) {
+	su, err := scrapeURL(u)
+	if err != nil {
+		return nil, err
+	}
+	q := su.RawQuery
+	for _, ih := range ihs {
+		b, err := hex.DecodeString(ih)
+		if err != nil {
+			return nil, err
+		}
+		if q != "" {
+			q += "&"
+		}
+		q += "info_hash=" + url.QueryEscape(string(b))
+	}
+	su.RawQuery = q
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, su.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape answered %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Files map[string]struct {
+			Complete   int `bencode:"complete"`
+			Incomplete int `bencode:"incomplete"`
+			Downloaded int `bencode:"downloaded"`
+		} `bencode:"files"`
+		FailureReason string `bencode:"failure reason"`
+	}
+	if err := bencode.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid scrape response: %w", err)
+	}
+	if r.FailureReason != "" {
+		return nil, errors.New(r.FailureReason)
+	}
+	res := make(map[string]scrapeCounts, len(r.Files))
+	for k, f := range r.Files {
+		res[hex.EncodeToString([]byte(k))] = scrapeCounts{seeders: f.Complete, leechers: f.Incomplete, downloaded: f.Downloaded}
+	}
+	return res, nil
+}
+
+// announceStatus is a tracker line of the status of anacrolix/torrent, which
+// doesn't expose its announcers: "udp://..."  next ann: 29m59s, last ann: 42 peers
+type announceStatus struct {
+	next      time.Duration
+	announced bool
+	peers     int
+	err       string
+}
+
+var announceStatusRe = regexp.MustCompile(`^\s+("(?:[^"\\]|\\.)*")\s+next ann: (.*?), last ann: (.*)$`)
+
+// parseAnnounceStatus reads the trackers of the task from the status of the
+// client
+func parseAnnounceStatus(r io.Reader, infohash string) map[string]announceStatus {
+	m := make(map[string]announceStatus)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var inTask, inTrackers bool
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "Infohash: ") {
+			if inTask {
+				break
+			}
+			inTask = strings.TrimPrefix(line, "Infohash: ") == infohash
+			continue
+		}
+		if !inTask {
+			continue
+		}
+		if line == "Enabled trackers:" {
+			inTrackers = true
+			continue
+		}
+		if !inTrackers {
+			continue
+		}
+		sm := announceStatusRe.FindStringSubmatch(line)
+		if sm == nil {
+			if !strings.HasPrefix(line, " ") {
+				break
+			}
+			continue
+		}
+		u, err := strconv.Unquote(sm[1])
+		if err != nil {
+			continue
+		}
+		var as announceStatus
+		as.next, _ = time.ParseDuration(sm[2])
+		switch last := sm[3]; {
+		case last == "never":
+		case strings.HasSuffix(last, " peers"):
+			as.announced = true
+			as.peers, _ = strconv.Atoi(strings.TrimSuffix(last, " peers"))
+		default:
+			as.announced = true
+			as.err = last
+		}
+		m[u] = as
+	}
+	return m
+}
+
+// TorrentTrackerStatus returns the trackers of the task with the state of
+// their announces and their last scrape
+func (e *Engine) TorrentTrackerStatus(infohash string) ([]TrackerStatus, error) {
+	tt, err := e.loadedTorrent(infohash)
+	if err != nil {
+		return nil, err
+	}
+	mi := tt.Metainfo()
+	var buf bytes.Buffer
+	e.RLock()
+	if e.client != nil {
+		e.client.WriteStatus(&buf)
+	}
+	e.RUnlock()
+	announces := parseAnnounceStatus(&buf, infohash)
+	scrapes := e.scrapes.get(infohash)
+
+	now := time.Now()
+	list := []TrackerStatus{}
+	for i, tier := range mi.UpvertedAnnounceList() {
+		for _, u := range tier {
+			ts := TrackerStatus{URL: u, Tier: i, Seeders: -1, Leechers: -1, Downloaded: -1}
+			if as, ok := announces[u]; ok {
+				ts.Working = as.announced && as.err == ""
+				ts.Error, ts.Peers = as.err, as.peers
+				if as.next > 0 {
+					ts.NextAnnounce = now.Add(as.next)
+				}
+			}
+			if sc, ok := scrapes[u]; ok {
+				ts.ScrapeError, ts.ScrapedAt = sc.err, sc.at
+				if sc.err == "" {
+					ts.Seeders, ts.Leechers, ts.Downloaded = sc.seeders, sc.leechers, sc.downloaded
+				}
+			}
+			list = append(list, ts)
+		}
+	}
+	return list, nil
+}
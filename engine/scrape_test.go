@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+func TestScrapeURL(t *testing.T) {
+	for _, tt := range []struct{ announce, want string }{
+		{"http://t.example/announce", "http://t.example/scrape"},
+		{"https://t.example/x/announce.php?passkey=abc", "https://t.example/x/scrape.php?passkey=abc"},
+		{"http://t.example/a", ""},
+	} {
+		u, _ := url.Parse(tt.announce)
+		su, err := scrapeURL(u)
+		got := ""
+		if err == nil {
+			got = su.String()
+		}
+		if got != tt.want {
+			t.Errorf("scrapeURL(%s) = %q, want %q", tt.announce, got, tt.want)
+		}
+	}
+}
+
+func TestScrapeHTTP(t *testing.T) {
+	ih := strings.Repeat("ab", 20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("passkey") != "k" {
+			http.NotFound(w, r)
+			return
+		}
+		files := map[string]interface{}{}
+		for _, h := range r.URL.Query()["info_hash"] {
+			files[h] = map[string]int{"complete": 12, "incomplete": 3, "downloaded": 40}
+		}
+		b, _ := bencode.Marshal(map[string]interface{}{"files": files})
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	s := newScraper(&Config{})
+	u, _ := url.Parse(srv.URL + "/announce?passkey=k")
+	res, err := s.scrapeHTTP(context.Background(), u, []string{ih})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc := res[ih]; sc.seeders != 12 || sc.leechers != 3 || sc.downloaded != 40 {
+		t.Errorf("scrapeHTTP() = %+v", res)
+	}
+
+	var st scrapeState
+	st.set(srv.URL+"/announce", []string{ih}, res, nil)
+	st.set("udp://down.example:80", []string{ih}, nil, context.DeadlineExceeded)
+	if seeders, leechers, ok := swarmCounts(st.get(ih)); !ok || seeders != 12 || leechers != 3 {
+		t.Errorf("swarmCounts() = %d, %d, %v", seeders, leechers, ok)
+	}
+}
+
+func TestParseAnnounceStatus(t *testing.T) {
+	ih := strings.Repeat("ab", 20)
+	status := `# Torrents: 2
+
+other
+Infohash: ` + strings.Repeat("cd", 20) + `
+Enabled trackers:
+    URL                             Extra
+    "udp://other.example:80"        next ann: 1m0s, last ann: 9 peers
+
+name
+Infohash: ` + ih + `
+Metadata length: 100
+Enabled trackers:
+    URL                             Extra
+    "http://a.example/announce"     next ann: 29m59s, last ann: 42 peers
+    "udp://b.example:80/announce"   next ann: anytime, last ann: error announcing: timeout, no answer
+    "udp://c.example:80"            next ann: anytime, last ann: never
+DHT Announces: 1
+`
+	m := parseAnnounceStatus(strings.NewReader(status), ih)
+	if len(m) != 3 {
+		t.Fatalf("parseAnnounceStatus() = %v", m)
+	}
+	if a := m["http://a.example/announce"]; !a.announced || a.peers != 42 || a.next != 29*time.Minute+59*time.Second {
+		t.Errorf("a = %+v", a)
+	}
+	if b := m["udp://b.example:80/announce"]; !b.announced || b.err != "error announcing: timeout, no answer" || b.next != 0 {
+		t.Errorf("b = %+v", b)
+	}
+	if c := m["udp://c.example:80"]; c.announced {
+		t.Errorf("c = %+v", c)
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+// the client is built uploading and seeding, EnableUpload and EnableSeeding
+// are applied to the tasks so they can change without rebuilding it
+
+// uploadRoutine applies EnableUpload, EnableSeeding and the connection limits
+// to the tasks started or completed
+func (e *Engine) uploadRoutine(events <-chan Event) {
+	for ev := range events {
+		if t, ok := e.Torrent(ev.InfoHash); ok {
+			e.applyUpload(t)
+			e.applyConnLimit(t)
+		}
+	}
+}
+
+// applyUploads applies EnableUpload and EnableSeeding to all the tasks
+func (e *Engine) applyUploads() {
+	for _, t := range e.Torrents() {
+		e.applyUpload(t)
+	}
+}
+
+// applyUpload allows the task to upload if EnableUpload, and once done if
+// EnableSeeding too
+func (e *Engine) applyUpload(t *Torrent) {
+	c := e.Config()
+	t.Lock()
+	tt := t.t
+	allow := c.EnableUpload && (c.EnableSeeding || !t.Done)
+	// a task started again has a new client torrent, allowed
+	disallowed := tt != nil && t.uploadDisallowed == tt
+	changed := tt != nil && disallowed == allow
+	if changed && allow {
+		t.uploadDisallowed = nil
+	} else if changed {
+		t.uploadDisallowed = tt
+	}
+	t.Unlock()
+	if !changed {
+		return
+	}
+	if allow {
+		tt.AllowDataUpload()
+	} else {
+		tt.DisallowDataUpload()
+	}
+}
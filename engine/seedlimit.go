@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+const (
+	// SeedLimitAction values, what to do with a task reaching its seed limits
+	SeedLimitStop   = "stop"
+	SeedLimitRemove = "remove"
+)
+
+// SeedLimits overrides the global SeedRatio, MaxSeedTime and MaxIdleTime of a
+// task, 0 follows the global option and negative ones disable the limit
+type SeedLimits struct {
+	Ratio    float32
+	SeedTime time.Duration
+	IdleTime time.Duration
+}
+
+// effective returns the limit of the task, or the global one
+func (l SeedLimits) effective(c *Config) SeedLimits {
+	if l.Ratio == 0 {
+		l.Ratio = c.SeedRatio
+	}
+	if l.SeedTime == 0 {
+		l.SeedTime = c.MaxSeedTime
+	}
+	if l.IdleTime == 0 {
+		l.IdleTime = c.MaxIdleTime
+	}
+	return l
+}
+
+// parseSeedLimits parses "<ratio>:<seed time>:<idle time>", empty fields
+// follow the global options and -1 disables the limit
+func parseSeedLimits(s string) (SeedLimits, error) {
+	var l SeedLimits
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return l, fmt.Errorf("invalid seed limits %q", s)
+	}
+	if r := strings.TrimSpace(parts[0]); r != "" {
+		f, err := strconv.ParseFloat(r, 32)
+		if err != nil {
+			return l, err
+		}
+		l.Ratio = float32(f)
+	}
+	for i, d := range []*time.Duration{&l.SeedTime, &l.IdleTime} {
+		v := strings.TrimSpace(parts[i+1])
+		switch v {
+		case "":
+		case "-1":
+			*d = -1
+		default:
+			pd, err := time.ParseDuration(v)
+			if err != nil {
+				return l, err
+			}
+			*d = pd
+		}
+	}
+	return l, nil
+}
+
+// SetTorrentSeedLimits sets the seed limits of a single torrent, in the format
+// of "<ratio>:<seed time>:<idle time>", eg: "2::24h", "-1:-1:-1" seeds forever
+func (e *Engine) SetTorrentSeedLimits(infohash, limits string) error {
+	l, err := parseSeedLimits(limits)
+	if err != nil {
+		return err
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.SeedLimits = l
+	t.Unlock()
+	log.Printf("[SetTorrentSeedLimits] %s ratio %v seed time %s idle time %s", infohash, l.Ratio, l.SeedTime, l.IdleTime)
+	e.notifyChanged()
+	return nil
+}
+
+// checkSeedLimits stops or removes a seeding task, not started manually, that
+// reached its seed ratio, seed time or idle time
+func (e *Engine) checkSeedLimits(t *Torrent) {
+	t.Lock()
+	defer t.Unlock()
+	seeding := t.Done && t.Started && !t.ManualStarted
+	if !seeding {
+		t.idleSince = time.Time{}
+		return
+	}
+
+	now := time.Now()
+	if t.idleSince.IsZero() || t.Uploaded != t.idleUploaded {
+		t.idleSince = now
+		t.idleUploaded = t.Uploaded
+	}
+
+	l := t.SeedLimits.effective(&e.config)
+	var reason string
+	switch {
+	case l.Ratio > 0 && t.SeedRatio > l.Ratio:
+		reason = fmt.Sprintf("reaching SeedRatio %f", t.SeedRatio)
+	case l.SeedTime > 0 && !t.FinishedAt.IsZero() && now.Sub(t.FinishedAt) > l.SeedTime:
+		reason = fmt.Sprintf("seeding for MaxSeedTime %s", l.SeedTime)
+	case l.IdleTime > 0 && now.Sub(t.idleSince) > l.IdleTime:
+		reason = fmt.Sprintf("no upload for MaxIdleTime %s", l.IdleTime)
+	default:
+		return
+	}
+
+	if e.config.SeedLimitAction == SeedLimitStop {
+		log.Printf("[TaskRoutine]%s Stopped due to %s", t.InfoHash, reason)
+		go func(ih string) { common.FancyHandleError(e.StopTorrent(ih)) }(t.InfoHash)
+		return
+	}
+	log.Printf("[TaskRoutine]%s Stopped and Drop due to %s", t.InfoHash, reason)
+	go e.stopRemoveTask(t.InfoHash)
+}
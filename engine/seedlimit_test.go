@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSeedLimits(t *testing.T) {
+	tests := []struct {
+		in   string
+		want SeedLimits
+		err  bool
+	}{
+		{"::", SeedLimits{}, false},
+		{"2::24h", SeedLimits{Ratio: 2, IdleTime: 24 * time.Hour}, false},
+		{"-1:-1:-1", SeedLimits{Ratio: -1, SeedTime: -1, IdleTime: -1}, false},
+		{"1.5:90m:", SeedLimits{Ratio: 1.5, SeedTime: 90 * time.Minute}, false},
+		{"1.5:90m", SeedLimits{}, true},
+		{"x::", SeedLimits{}, true},
+		{":1d:", SeedLimits{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSeedLimits(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseSeedLimits(%q) error %v", tt.in, err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("parseSeedLimits(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSeedLimitsEffective(t *testing.T) {
+	c := &Config{SeedRatio: 1.5, MaxSeedTime: time.Hour}
+	got := SeedLimits{SeedTime: -1, IdleTime: time.Minute}.effective(c)
+	want := SeedLimits{Ratio: 1.5, SeedTime: -1, IdleTime: time.Minute}
+	if got != want {
+		t.Errorf("effective = %+v, want %+v", got, want)
+	}
+}
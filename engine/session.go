@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/trace"
+)
+
+const (
+	// sessionFile keeps the runtime state of the tasks across restarts
+	sessionFile     = ".session.json"
+	sessionInterval = 30 * time.Second
+)
+
+// taskSession is the persisted runtime state of a task
+type taskSession struct {
+	Started       bool      `json:"started"`
+	Stopped       bool      `json:"stopped"`
+	ManualStarted bool      `json:"manualStarted"`
+	AddedAt       time.Time `json:"addedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	// bytes transferred in all sessions
+	Downloaded int64 `json:"downloaded"`
+	Uploaded   int64 `json:"uploaded"`
+	// set by rules or the user
+	Label string   `json:"label,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// the group of tasks set by the user
+	Collection string `json:"collection,omitempty"`
+	// wait list order set by the user
+	QueuePriority int `json:"queuePriority,omitempty"`
+	// seed limits set by the user
+	SeedLimits SeedLimits `json:"seedLimits"`
+	// weekly times it downloads set by the user
+	DownloadSchedule string `json:"downloadSchedule,omitempty"`
+	// encryption policy set by the user
+	Encryption string `json:"encryption,omitempty"`
+	// super-seeding set by the user
+	SuperSeed bool `json:"superSeed,omitempty"`
+	// set when adding or by the user, by file index
+	FilePriorities FilePriorities `json:"filePriorities,omitempty"`
+	// the user added it
+	AddedBy string `json:"addedBy,omitempty"`
+	// the data renamed by the user, stored under it
+	Name string `json:"name,omitempty"`
+	// scheduled when adding or by the user
+	StartAt      time.Time `json:"startAt"`
+	ExpireAt     time.Time `json:"expireAt"`
+	ExpireAction string    `json:"expireAction,omitempty"`
+	// set by the user, nil for the configured rates
+	RateLimit *taskRateLimit `json:"rateLimit,omitempty"`
+	// connections set by the user
+	ConnLimits ConnLimits `json:"connLimits"`
+}
+
+type taskRateLimit struct {
+	Upload   string `json:"upload"`
+	Download string `json:"download"`
+}
+
+type sessionMap struct {
+	sync.Mutex
+	m map[string]taskSession
+	// tasks removed since last save
+	dirty bool
+}
+
+// loadSession reads the state saved by the last run, called in Configure
+func (e *Engine) loadSession() {
+	e.sessions.Lock()
+	defer e.sessions.Unlock()
+	e.sessions.m = make(map[string]taskSession)
+	data, err := ioutil.ReadFile(filepath.Join(e.cacheDir, sessionFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("[Session] load", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &e.sessions.m); err != nil {
+		log.Warn("[Session] load", err)
+		return
+	}
+	log.Printf("[Session] loaded state of %d tasks", len(e.sessions.m))
+}
+
+// restoreSession applies the saved state to a newly added task
+func (e *Engine) restoreSession(t *Torrent) {
+	e.sessions.Lock()
+	s, ok := e.sessions.m[t.InfoHash]
+	e.sessions.Unlock()
+	if !ok {
+		return
+	}
+	t.restored = true
+	t.resumeStarted = s.Started
+	t.resumeStopped = s.Stopped
+	t.ManualStarted = s.ManualStarted
+	if !s.AddedAt.IsZero() {
+		t.AddedAt = s.AddedAt
+	}
+	t.FinishedAt = s.FinishedAt
+	t.prevDownloaded = s.Downloaded
+	t.prevUploaded = s.Uploaded
+	t.Uploaded = s.Uploaded
+	t.Label = s.Label
+	t.Tags = s.Tags
+	t.Collection = s.Collection
+	t.QueuePriority = s.QueuePriority
+	t.SeedLimits = s.SeedLimits
+	t.DownloadSchedule = s.DownloadSchedule
+	t.scheduleRules, _ = parseSchedule(s.DownloadSchedule)
+	t.Encryption = s.Encryption
+	t.SuperSeed = s.SuperSeed
+	if s.SuperSeed {
+		e.superSeeds.set(t.InfoHash, true)
+	}
+	t.filePriorities = s.FilePriorities
+	t.AddedBy = s.AddedBy
+	t.storageName = s.Name
+	t.StartAt = s.StartAt
+	t.ExpireAt = s.ExpireAt
+	t.ExpireAction = s.ExpireAction
+	if s.RateLimit != nil {
+		e.restoreRateLimit(t, s.RateLimit)
+	}
+	t.ConnLimits = s.ConnLimits
+}
+
+// hasSession tells whether the task is known from the last session
+func (e *Engine) hasSession(ih string) bool {
+	e.sessions.Lock()
+	defer e.sessions.Unlock()
+	_, ok := e.sessions.m[ih]
+	return ok
+}
+
+// shouldStart tells whether a task is started once its info is loaded
+func (e *Engine) shouldStart(t *Torrent) bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.resumeStopped || t.StartAt.After(time.Now()) {
+		return false
+	}
+	return t.resumeStarted || e.config.AutoStart
+}
+
+func (e *Engine) removeSession(ih string) {
+	e.sessions.Lock()
+	defer e.sessions.Unlock()
+	if _, ok := e.sessions.m[ih]; ok {
+		delete(e.sessions.m, ih)
+		e.sessions.dirty = true
+	}
+}
+
+// SaveState writes the state of the tasks and the speed history now, eg:
+// before the process is replaced
+func (e *Engine) SaveState() {
+	e.saveSession()
+	e.saveHistory()
+	e.saveTaskRecords()
+	e.saveWaitList()
+}
+
+func (e *Engine) saveSession() {
+	e.RLock()
+	cur := e.sessionSnapshot()
+	e.RUnlock()
+	e.writeSession(cur)
+}
+
+// sessionSnapshot must hold the engine lock
+func (e *Engine) sessionSnapshot() map[string]taskSession {
+	cur := make(map[string]taskSession)
+	for ih, t := range e.ts {
+		t.Lock()
+		cur[ih] = t.session()
+		t.Unlock()
+	}
+	return cur
+}
+
+// writeSession updates the state of the current tasks and writes the file
+// when anything changed
+func (e *Engine) writeSession(cur map[string]taskSession) {
+	e.sessions.Lock()
+	defer e.sessions.Unlock()
+	if e.sessions.m == nil {
+		return
+	}
+	changed := e.sessions.dirty
+	for ih, s := range cur {
+		if old, ok := e.sessions.m[ih]; !ok || !reflect.DeepEqual(old, s) {
+			e.sessions.m[ih] = s
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	defer trace.Observe(context.Background(), "engine.saveSession", time.Now(), "tasks", strconv.Itoa(len(e.sessions.m)))
+	data, err := json.Marshal(e.sessions.m)
+	if err != nil {
+		log.Warn("[Session] save", err)
+		return
+	}
+	tmp := filepath.Join(e.cacheDir, sessionFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		log.Warn("[Session] save", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(e.cacheDir, sessionFile)); err != nil {
+		log.Warn("[Session] save", err)
+		return
+	}
+	e.sessions.dirty = false
+}
+
+func (e *Engine) sessionRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(sessionInterval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			e.saveSession()
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+// session snapshots the state of the task, must hold lock
+func (t *Torrent) session() taskSession {
+	s := taskSession{
+		// or restored as started but not loaded yet
+		Started:          t.Started || (t.resumeStarted && !t.Loaded),
+		Stopped:          !t.Started && (!t.StoppedAt.IsZero() || t.resumeStopped),
+		ManualStarted:    t.ManualStarted,
+		AddedAt:          t.AddedAt,
+		FinishedAt:       t.FinishedAt,
+		Downloaded:       t.prevDownloaded,
+		Uploaded:         t.prevUploaded,
+		Label:            t.Label,
+		Tags:             append([]string(nil), t.Tags...),
+		Collection:       t.Collection,
+		QueuePriority:    t.QueuePriority,
+		SeedLimits:       t.SeedLimits,
+		DownloadSchedule: t.DownloadSchedule,
+		Encryption:       t.Encryption,
+		SuperSeed:        t.SuperSeed,
+		AddedBy:          t.AddedBy,
+		Name:             t.storageName,
+		StartAt:          t.StartAt,
+		ExpireAt:         t.ExpireAt,
+		ExpireAction:     t.ExpireAction,
+		ConnLimits:       t.ConnLimits,
+	}
+	if t.rateLimitSet {
+		s.RateLimit = &taskRateLimit{Upload: t.UploadRateLimit, Download: t.DownloadRateLimit}
+	}
+	if len(t.filePriorities) > 0 {
+		s.FilePriorities = make(FilePriorities, len(t.filePriorities))
+		for i, p := range t.filePriorities {
+			s.FilePriorities[i] = p
+		}
+	}
+	if t.Stats != nil {
+		s.Downloaded += t.Stats.BytesReadUsefulData.Int64()
+		s.Uploaded += t.Stats.BytesWrittenData.Int64()
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// waitListFile keeps the order of the wait list, restored in that order
+const waitListFile = ".waitlist"
+
+// ErrShuttingDown refuses the new tasks once Shutdown is called
+var ErrShuttingDown = errors.New("shutting down")
+
+// shuttingDownErr returns ErrShuttingDown once Shutdown is called
+func (e *Engine) shuttingDownErr() error {
+	if atomic.LoadInt32(&e.shuttingDown) != 0 {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+// Shutdown stops the engine before the exit: the new tasks are refused, the
+// state of the tasks and the wait list are saved, the tasks being verified are
+// flagged to be verified again on the next start, then the client is closed.
+// It returns ctx.Err() if the client isn't closed before ctx is done, the
+// state is saved anyway.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&e.shuttingDown, 0, 1) {
+		return ErrShuttingDown
+	}
+	e.Lock()
+	if e.client == nil {
+		e.Unlock()
+		return nil
+	}
+	// stops the routines, the dirty flag is written below
+	close(e.closeSync)
+	e.closeSync = make(chan struct{})
+	e.Unlock()
+
+	// the downloads are flagged too until the client is closed
+	checking := e.checkingTasks()
+	e.writeDirtyFlag(append(checking, e.writingTasks()...))
+	e.SaveState()
+	log.Printf("[Shutdown] state saved, %d tasks to verify on the next start", len(checking))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Lock()
+		for _, t := range e.client.Torrents() {
+			t.Drop()
+		}
+		e.client.Close()
+		common.FancyHandleError(e.dataStorage.Close())
+		e.closeTaskStorages()
+		e.Unlock()
+		// written cleanly
+		e.writeDirtyFlag(checking)
+		if e.plugins != nil {
+			timeout := 5 * time.Second
+			if d, ok := ctx.Deadline(); ok {
+				timeout = time.Until(d)
+			}
+			e.plugins.Close(timeout)
+		}
+		e.taskRecords.Lock()
+		if e.taskRecords.db != nil {
+			common.FancyHandleError(e.taskRecords.db.Close())
+			e.taskRecords.db = nil
+		}
+		e.taskRecords.Unlock()
+	}()
+	select {
+	case <-done:
+		log.Println("[Shutdown] client closed")
+		return nil
+	case <-ctx.Done():
+		log.Warn("[Shutdown] client not closed in time, the downloads will be verified on the next start")
+		return ctx.Err()
+	}
+}
+
+// checkingTasks lists the tasks being verified or waiting for it
+func (e *Engine) checkingTasks() []string {
+	e.recheckMu.Lock()
+	set := make(map[string]struct{}, len(e.recheckSet))
+	for ih := range e.recheckSet {
+		set[ih] = struct{}{}
+	}
+	e.recheckMu.Unlock()
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		if t.Verifying {
+			set[ih] = struct{}{}
+		}
+		t.Unlock()
+	}
+	ihs := make([]string, 0, len(set))
+	for ih := range set {
+		ihs = append(ihs, ih)
+	}
+	sort.Strings(ihs)
+	return ihs
+}
+
+// writeDirtyFlag lists the tasks in the dirty flag file, removed if none
+func (e *Engine) writeDirtyFlag(ihs []string) {
+	if len(ihs) == 0 {
+		if err := os.Remove(e.dirtyFlagPath()); err != nil && !os.IsNotExist(err) {
+			log.Warn("[DirtyFlag] failed to remove flag file", err)
+		}
+		return
+	}
+	if err := os.WriteFile(e.dirtyFlagPath(), []byte(strings.Join(ihs, "\n")), 0644); err != nil {
+		log.Warn("[DirtyFlag] failed to write flag file", err)
+	}
+}
+
+// saveWaitList writes the infohashes of the wait list in order
+func (e *Engine) saveWaitList() {
+	var ihs []string
+	for _, te := range e.waitList.Elems() {
+		ihs = append(ihs, te.ih)
+	}
+	fn := filepath.Join(e.cacheDir, waitListFile)
+	if len(ihs) == 0 {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			log.Warn("[WaitList] save", err)
+		}
+		return
+	}
+	if err := os.WriteFile(fn, []byte(strings.Join(ihs, "\n")), 0644); err != nil {
+		log.Warn("[WaitList] save", err)
+	}
+}
+
+// loadWaitList returns the positions in the wait list saved by the last run
+func (e *Engine) loadWaitList() map[string]int {
+	pos := make(map[string]int)
+	f, err := os.Open(filepath.Join(e.cacheDir, waitListFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("[WaitList] load", err)
+		}
+		return pos
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if ih := strings.TrimSpace(sc.Text()); len(ih) == 40 {
+			pos[ih] = len(pos) + 1
+		}
+	}
+	return pos
+}
+
+// sortByWaitList puts the cache files of the tasks waiting in the last run
+// after the others in their order, names sorted by the time they were added
+func sortByWaitList(names []string, pos map[string]int) {
+	if len(pos) == 0 {
+		return
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return pos[cacheFileInfoHash(names[i])] < pos[cacheFileInfoHash(names[j])]
+	})
+}
+
+// cacheFileInfoHash is the infohash of a cache file name
+func cacheFileInfoHash(name string) string {
+	name = strings.TrimPrefix(name, cacheSavedPrefix)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
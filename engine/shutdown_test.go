@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWaitListOrder(t *testing.T) {
+	a, b, c := strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40)
+	e := &Engine{cacheDir: t.TempDir(), waitList: NewSyncList()}
+	e.waitList.Push(taskElem{ih: c, tp: taskMagnet})
+	e.waitList.Push(taskElem{ih: a, tp: taskTorrent})
+	e.saveWaitList()
+
+	names := []string{
+		cacheSavedPrefix + a + ".torrent",
+		cacheSavedPrefix + b + ".torrent",
+		cacheSavedPrefix + c + ".info",
+	}
+	sortByWaitList(names, e.loadWaitList())
+	want := []string{
+		cacheSavedPrefix + b + ".torrent",
+		cacheSavedPrefix + c + ".info",
+		cacheSavedPrefix + a + ".torrent",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("sortByWaitList() = %v, want %v", names, want)
+	}
+
+	// emptied
+	e.waitList.Remove(a)
+	e.waitList.Remove(c)
+	e.saveWaitList()
+	if pos := e.loadWaitList(); len(pos) != 0 {
+		t.Errorf("loadWaitList() = %v, want none", pos)
+	}
+}
+
+func TestShutdownRefusesTasks(t *testing.T) {
+	e := &Engine{cacheDir: t.TempDir()}
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.NewMagnet("magnet:?xt=urn:btih:"+strings.Repeat("a", 40), ""); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("NewMagnet() = %v, want ErrShuttingDown", err)
+	}
+	if err := e.Shutdown(context.Background()); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Shutdown() again = %v", err)
+	}
+}
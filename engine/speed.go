@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"math"
+	"time"
+)
+
+// the time constant of the smoothed speeds, a change of the rate shows by
+// 63% after it
+const speedSmoothing = 10 * time.Second
+
+// SpeedStats is the smoothed speed of all the tasks, and the time to
+// download the bytes left of the tasks downloading at it
+type SpeedStats struct {
+	DownloadSpeed float32
+	UploadSpeed   float32
+	// seconds, -1 if not downloading, 0 with nothing left
+	ETA         int64
+	Downloading int
+}
+
+// smoothSpeed returns the speed moved towards the rate sampled over dt,
+// exponentially weighted by speedSmoothing
+func smoothSpeed(prev, rate float32, dt time.Duration) float32 {
+	if dt <= 0 {
+		return prev
+	}
+	alpha := float32(1 - math.Exp(-dt.Seconds()/speedSmoothing.Seconds()))
+	s := prev + alpha*(rate-prev)
+	// decays to 0 when idle
+	if s < 1 {
+		return 0
+	}
+	return s
+}
+
+// etaSeconds returns the seconds to download left at speed, -1 if it never
+// does
+func etaSeconds(left int64, speed float32) int64 {
+	if left <= 0 {
+		return 0
+	}
+	if speed < 1 {
+		return -1
+	}
+	return int64(math.Ceil(float64(left) / float64(speed)))
+}
+
+// bytesLeft returns the bytes of the wanted files still missing, t locked
+func (t *Torrent) bytesLeft() int64 {
+	if t.Done {
+		return 0
+	}
+	if len(t.Files) == 0 {
+		return t.Size - t.Downloaded
+	}
+	var left int64
+	for i, f := range t.Files {
+		if f == nil || t.filePriorities.of(i) == FilePrioritySkip {
+			continue
+		}
+		left += f.Size - f.Completed
+	}
+	return left
+}
+
+// updateSpeeds smooths the rates sampled over dt, and sets the ETA by them
+func (t *Torrent) updateSpeeds(dt time.Duration) {
+	t.DownloadSpeed = smoothSpeed(t.DownloadSpeed, t.DownloadRate, dt)
+	t.UploadSpeed = smoothSpeed(t.UploadSpeed, t.UploadRate, dt)
+	t.ETA = etaSeconds(t.bytesLeft(), t.DownloadSpeed)
+}
+
+// SpeedStats returns the smoothed speed of all the tasks
+func (e *Engine) SpeedStats() SpeedStats {
+	var s SpeedStats
+	var left int64
+	for _, t := range e.Torrents() {
+		t.Lock()
+		s.DownloadSpeed += t.DownloadSpeed
+		s.UploadSpeed += t.UploadSpeed
+		if t.Started && !t.Done {
+			s.Downloading++
+			left += t.bytesLeft()
+		}
+		t.Unlock()
+	}
+	s.ETA = etaSeconds(left, s.DownloadSpeed)
+	if s.Downloading == 0 {
+		s.ETA = -1
+	}
+	return s
+}
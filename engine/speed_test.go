@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSmoothSpeed(t *testing.T) {
+	s := smoothSpeed(0, 1000, speedSmoothing)
+	if s < 630 || s > 635 {
+		t.Errorf("smoothSpeed() after the time constant = %v, want ~632", s)
+	}
+	if got := smoothSpeed(s, 1000, 0); got != s {
+		t.Errorf("smoothSpeed() without time = %v, want %v", got, s)
+	}
+	// a spike of one sample moves it only a part of the way
+	s = 1000
+	for i := 0; i < 3; i++ {
+		s = smoothSpeed(s, 1000, 3*time.Second)
+	}
+	if got := smoothSpeed(s, 10000, 3*time.Second); got > 4000 {
+		t.Errorf("smoothSpeed() spike = %v, want < 4000", got)
+	}
+	// idle decays to 0
+	for i := 0; i < 100; i++ {
+		s = smoothSpeed(s, 0, 3*time.Second)
+	}
+	if s != 0 {
+		t.Errorf("smoothSpeed() idle = %v, want 0", s)
+	}
+}
+
+func TestETA(t *testing.T) {
+	for _, tc := range []struct {
+		left  int64
+		speed float32
+		want  int64
+	}{
+		{0, 0, 0},
+		{100, 0, -1},
+		{1000, 100, 10},
+		{1001, 100, 11},
+	} {
+		if got := etaSeconds(tc.left, tc.speed); got != tc.want {
+			t.Errorf("etaSeconds(%d, %v) = %d, want %d", tc.left, tc.speed, got, tc.want)
+		}
+	}
+
+	tt := &Torrent{Size: 300, Downloaded: 100}
+	if got := tt.bytesLeft(); got != 200 {
+		t.Errorf("bytesLeft() without files = %d, want 200", got)
+	}
+	tt.Files = []*File{{Size: 100, Completed: 50}, {Size: 200}}
+	tt.filePriorities = FilePriorities{1: FilePrioritySkip}
+	if got := tt.bytesLeft(); got != 50 {
+		t.Errorf("bytesLeft() skipping a file = %d, want 50", got)
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// the StorageBackend of the data, auto for mmap on 64bit machines
+const (
+	StorageAuto = ""
+	StorageMmap = "mmap"
+	StorageFile = "file"
+)
+
+// the FileAllocation of the data files
+const (
+	// the files grow as the pieces are written, no space is taken up front
+	FileAllocationSparse = "sparse"
+	// the files are allocated whole when the task is opened, less fragmented
+	FileAllocationFull = "full"
+)
+
+// the PieceCompletion stores of the pieces verified, default of the library
+// when empty
+const (
+	PieceCompletionDefault = ""
+	PieceCompletionSqlite  = "sqlite"
+	PieceCompletionBolt    = "bolt"
+	// kept in memory, the tasks are verified again on every start
+	PieceCompletionMemory = "memory"
+)
+
+// checkStorage validates the storage options
+func (c *Config) checkStorage() error {
+	switch c.StorageBackend {
+	case StorageAuto, StorageMmap, StorageFile:
+	default:
+		return fmt.Errorf("Invalid StorageBackend %q, mmap or file", c.StorageBackend)
+	}
+	switch c.FileAllocation {
+	case "", FileAllocationSparse, FileAllocationFull:
+	default:
+		return fmt.Errorf("Invalid FileAllocation %q, sparse or full", c.FileAllocation)
+	}
+	switch c.PieceCompletion {
+	case PieceCompletionDefault, PieceCompletionSqlite, PieceCompletionBolt, PieceCompletionMemory:
+	default:
+		return fmt.Errorf("Invalid PieceCompletion %q, sqlite, bolt or memory", c.PieceCompletion)
+	}
+	return nil
+}
+
+// useMmap tells whether the data is mapped in memory, never with --disable-mmap
+func (e *Engine) useMmap() bool {
+	if e.cld.GetBoolAttribute("DisableMmap") {
+		return false
+	}
+	switch e.config.StorageBackend {
+	case StorageMmap:
+		return true
+	case StorageFile:
+		return false
+	}
+	return strconv.IntSize == 64
+}
+
+// pieceCompletionDir is where the PieceCompletion store of the data in dir is,
+// under PieceCompletionDir by the hash of dir when set
+func (c *Config) pieceCompletionDir(dir string) string {
+	if c.PieceCompletionDir == "" {
+		return dir
+	}
+	sum := sha1.Sum([]byte(filepath.Clean(dir)))
+	return filepath.Join(c.PieceCompletionDir, hex.EncodeToString(sum[:8]))
+}
+
+// newPieceCompletion opens the PieceCompletion store of the data in dir, in
+// memory when it can't be opened
+func (e *Engine) newPieceCompletion(dir string) storage.PieceCompletion {
+	var pc storage.PieceCompletion
+	var err error
+	dir = e.config.pieceCompletionDir(dir)
+	if e.config.PieceCompletion != PieceCompletionMemory {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			log.Warnf("[Storage] piece completion in %s: %v, kept in memory", dir, err)
+			return storage.NewMapPieceCompletion()
+		}
+	}
+	switch e.config.PieceCompletion {
+	case PieceCompletionMemory:
+		return storage.NewMapPieceCompletion()
+	case PieceCompletionBolt:
+		pc, err = storage.NewBoltPieceCompletion(dir)
+	case PieceCompletionSqlite:
+		pc, err = storage.NewSqlitePieceCompletion(dir)
+	default:
+		pc, err = storage.NewDefaultPieceCompletionForDir(dir)
+	}
+	if err != nil {
+		log.Warnf("[Storage] piece completion in %s: %v, kept in memory", dir, err)
+		return storage.NewMapPieceCompletion()
+	}
+	return pc
+}
+
+// newDataStorage creates the storage of the torrent data under dir
+func (e *Engine) newDataStorage(dir string) storage.ClientImplCloser {
+	pc := e.newPieceCompletion(dir)
+	var st storage.ClientImplCloser
+	if e.useMmap() {
+		st = &mmapStorage{
+			ClientImplCloser: storage.NewMMapWithCompletion(dir, pc),
+			// closed with the mmap storage
+			file: storage.NewFileOpts(storage.NewFileClientOpts{ClientBaseDir: dir, PieceCompletion: noClosePieceCompletion{pc}}),
+			e:    e,
+		}
+	} else {
+		st = storage.NewFileOpts(storage.NewFileClientOpts{ClientBaseDir: dir, PieceCompletion: pc})
+	}
+	return &allocStorage{ClientImplCloser: st, dir: dir, e: e}
+}
+
+// mmapStorage writes the tasks over MmapMaxTaskSize, and the ones failing to
+// be mapped, as files
+type mmapStorage struct {
+	storage.ClientImplCloser
+	file storage.ClientImplCloser
+	e    *Engine
+}
+
+func (s *mmapStorage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	if max, _ := parseByteSize(s.e.config.MmapMaxTaskSize); max > 0 && info.TotalLength() > max {
+		log.Debugf("[Storage] %s over MmapMaxTaskSize, as files", infoHash.HexString())
+		return s.file.OpenTorrent(info, infoHash)
+	}
+	ti, err := s.ClientImplCloser.OpenTorrent(info, infoHash)
+	if err != nil {
+		log.Warnf("[Storage] %s mmap: %v, as files", infoHash.HexString(), err)
+		return s.file.OpenTorrent(info, infoHash)
+	}
+	return ti, nil
+}
+
+// noClosePieceCompletion shares a PieceCompletion closed by another storage
+type noClosePieceCompletion struct {
+	storage.PieceCompletion
+}
+
+func (noClosePieceCompletion) Close() error { return nil }
+
+// allocStorage allocates the data files when opened by FileAllocation
+type allocStorage struct {
+	storage.ClientImplCloser
+	dir string
+	e   *Engine
+}
+
+func (s *allocStorage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	if s.e.config.FileAllocation == FileAllocationFull {
+		if err := allocateFiles(s.dir, info); err != nil {
+			// the pieces are still written to the sparse files
+			log.Warnf("[Storage] %s allocate: %v", infoHash.HexString(), err)
+		}
+	}
+	return s.ClientImplCloser.OpenTorrent(info, infoHash)
+}
+
+// allocateFiles reserves the full size of the files of the torrent under dir,
+// the data already written is kept
+func allocateFiles(dir string, info *metainfo.Info) error {
+	for _, f := range info.UpvertedFiles() {
+		if f.Length == 0 {
+			continue
+		}
+		name, err := storage.ToSafeFilePath(append([]string{info.Name}, f.Path...)...)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, name)
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("path %q out of %s", path, dir)
+		}
+		if err := allocateFile(path, f.Length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func allocateFile(path string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	if err := fallocate(f, size); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,11 @@
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate allocates the blocks of the file up to size, never shrinking it
+func fallocate(f *os.File, size int64) error {
+	return syscall.Fallocate(int(f.Fd()), 0, 0, size)
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package engine
+
+import (
+	"io"
+	"os"
+)
+
+// fallocate extends the file with zeros up to size, slower than the
+// allocation of linux. The existing data is kept, the file never shrinks.
+func fallocate(f *os.File, size int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() >= size {
+		return nil
+	}
+	if _, err := f.Seek(fi.Size(), io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, zeroReader{}, size-fi.Size())
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+func Test_allocateFiles(t *testing.T) {
+	dir := t.TempDir()
+	info := &metainfo.Info{Name: "album", Files: []metainfo.FileInfo{
+		{Path: []string{"cd1", "01.flac"}, Length: 1 << 20},
+		{Path: []string{"empty.txt"}, Length: 0},
+	}}
+	// the data already written is kept
+	written := filepath.Join(dir, "album", "cd1", "01.flac")
+	if err := os.MkdirAll(filepath.Dir(written), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(written, []byte("head"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := allocateFiles(dir, info); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(written)
+	if err != nil || fi.Size() != 1<<20 {
+		t.Fatalf("allocated %v %v", fi, err)
+	}
+	if b, _ := ioutil.ReadFile(written); string(b[:4]) != "head" {
+		t.Errorf("data lost: %q", b[:4])
+	}
+
+	evil := &metainfo.Info{Name: "..", Files: []metainfo.FileInfo{{Path: []string{"..", "x"}, Length: 1}}}
+	if err := allocateFiles(dir, evil); err == nil {
+		t.Error("allocated out of dir")
+	}
+}
+
+func TestConfig_checkStorage(t *testing.T) {
+	c := Config{StorageBackend: StorageFile, FileAllocation: FileAllocationFull, PieceCompletion: PieceCompletionBolt}
+	if err := c.checkStorage(); err != nil {
+		t.Error(err)
+	}
+	for _, bad := range []Config{{StorageBackend: "ram"}, {FileAllocation: "prealloc"}, {PieceCompletion: "mysql"}} {
+		if err := bad.checkStorage(); err == nil {
+			t.Errorf("checkStorage(%+v) no error", bad)
+		}
+	}
+}
+
+func TestConfig_pieceCompletionDir(t *testing.T) {
+	c := Config{}
+	if got := c.pieceCompletionDir("/data"); got != "/data" {
+		t.Errorf("pieceCompletionDir() = %s", got)
+	}
+	c.PieceCompletionDir = "/var/lib/pc"
+	a, b := c.pieceCompletionDir("/data"), c.pieceCompletionDir("/data/movies")
+	if filepath.Dir(a) != "/var/lib/pc" || a == b || a != c.pieceCompletionDir("/data/") {
+		t.Errorf("pieceCompletionDir() = %s %s", a, b)
+	}
+}
+
+type openedStorage struct {
+	name   string
+	opened *string
+}
+
+func (s openedStorage) OpenTorrent(*metainfo.Info, metainfo.Hash) (storage.TorrentImpl, error) {
+	*s.opened = s.name
+	if s.name == "broken" {
+		return storage.TorrentImpl{}, errors.New("mmap: no such device")
+	}
+	return storage.TorrentImpl{}, nil
+}
+
+func (openedStorage) Close() error { return nil }
+
+func TestMmapStorage(t *testing.T) {
+	e := &Engine{config: Config{MmapMaxTaskSize: "1MB"}}
+	var opened string
+	s := &mmapStorage{
+		ClientImplCloser: openedStorage{"mmap", &opened},
+		file:             openedStorage{"file", &opened},
+		e:                e,
+	}
+	for _, tt := range []struct {
+		size int64
+		want string
+	}{{1 << 10, "mmap"}, {10 << 20, "file"}} {
+		if _, err := s.OpenTorrent(&metainfo.Info{Length: tt.size}, metainfo.Hash{}); err != nil || opened != tt.want {
+			t.Errorf("OpenTorrent(%d) opened %s, %v", tt.size, opened, err)
+		}
+	}
+
+	s.ClientImplCloser = openedStorage{"broken", &opened}
+	if _, err := s.OpenTorrent(&metainfo.Info{Length: 1}, metainfo.Hash{}); err != nil || opened != "file" {
+		t.Errorf("OpenTorrent() not mapped opened %s, %v", opened, err)
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+const streamReadahead = 5 << 20
+
+// NewFileReader opens a reader of the file inside a torrent, pieces are
+// prioritized while reading, so files can be streamed before they're done.
+// The reads waiting for the pieces end with ctx.
+func (e *Engine) NewFileReader(ctx context.Context, infohash, filepath string) (io.ReadSeekCloser, *File, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if !t.Loaded {
+		return nil, nil, fmt.Errorf("torrent %s info not loaded yet", infohash)
+	}
+
+	for _, f := range t.Files {
+		if f != nil && f.Path == filepath {
+			r := f.f.NewReader()
+			r.SetResponsive()
+			r.SetReadahead(streamReadahead)
+			return contextReader{r, ctx, &e.localReads}, f, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("Missing file %s", filepath)
+}
+
+// contextReader reads the torrent.Reader within ctx
+type contextReader struct {
+	torrent.Reader
+	ctx   context.Context
+	local *localReads
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	defer r.local.begin(p)()
+	return r.ReadContext(r.ctx, p)
+}
+
+// localReads keeps the buffers being read by the local readers, the storage
+// reads into them aren't uploads. The torrent.Reader reads the storage into
+// slices of the buffer given, so a buffer is known by the end of its capacity.
+type localReads struct {
+	sync.Map
+}
+
+func bufferEnd(b []byte) *byte {
+	if cap(b) == 0 {
+		return nil
+	}
+	return &b[:cap(b)][cap(b)-1]
+}
+
+// begin adds the buffer of a read, the returned func removes it
+func (l *localReads) begin(b []byte) func() {
+	end := bufferEnd(b)
+	if end == nil {
+		return func() {}
+	}
+	l.Store(end, struct{}{})
+	return func() { l.Delete(end) }
+}
+
+// has tells if b is in a buffer of a local read
+func (l *localReads) has(b []byte) bool {
+	end := bufferEnd(b)
+	if end == nil {
+		return false
+	}
+	_, ok := l.Load(end)
+	return ok
+}
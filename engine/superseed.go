@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"sync"
+	"time"
+	_ "unsafe" // go:linkname
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// a peer served a piece no other peer got is given another one after this,
+// the swarm may have no other peer to pass it on
+const superSeedStall = 2 * time.Minute
+
+// superSeedMap approximates the BEP 16 super-seeding of the tasks: each peer
+// is served one piece at a time, and a piece served to a peer isn't served to
+// the others until a third peer has it. anacrolix/torrent always sends the
+// whole bitfield, so the requests of the other pieces are dropped on reading
+// them: rejected to the peers of the fast extension (BEP 6), left to time out
+// at the others.
+type superSeedMap struct {
+	sync.Mutex
+	tasks map[string]*superSeedTask
+	// the task of the connections, set on the handshake
+	conns map[*torrent.PeerConn]string
+}
+
+type superSeedTask struct {
+	// the piece each peer is served
+	assigned map[*torrent.PeerConn]superSeedPiece
+	// the peer served each piece, until another peer has it
+	servedTo map[int]*torrent.PeerConn
+}
+
+type superSeedPiece struct {
+	index int
+	at    time.Time
+}
+
+// set turns the super-seeding of the task on or off
+func (sm *superSeedMap) set(ih string, on bool) {
+	sm.Lock()
+	defer sm.Unlock()
+	if !on {
+		delete(sm.tasks, ih)
+		return
+	}
+	if sm.tasks == nil {
+		sm.tasks = make(map[string]*superSeedTask)
+	}
+	if _, ok := sm.tasks[ih]; !ok {
+		sm.tasks[ih] = &superSeedTask{
+			assigned: make(map[*torrent.PeerConn]superSeedPiece),
+			servedTo: make(map[int]*torrent.PeerConn),
+		}
+	}
+}
+
+func (sm *superSeedMap) attach(pc *torrent.PeerConn, ih string) {
+	sm.Lock()
+	defer sm.Unlock()
+	if sm.conns == nil {
+		sm.conns = make(map[*torrent.PeerConn]string)
+	}
+	sm.conns[pc] = ih
+}
+
+// remove frees the piece served to the closed connection
+func (sm *superSeedMap) remove(pc *torrent.PeerConn) {
+	sm.Lock()
+	defer sm.Unlock()
+	ih, ok := sm.conns[pc]
+	if !ok {
+		return
+	}
+	delete(sm.conns, pc)
+	if st, ok := sm.tasks[ih]; ok {
+		st.release(pc)
+	}
+}
+
+// drop tells if the message read from pc is dropped, called by the client
+// with its lock held
+func (sm *superSeedMap) drop(pc *torrent.PeerConn, msg *pp.Message, now time.Time) bool {
+	if msg.Keepalive {
+		return false
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	st, ok := sm.tasks[sm.conns[pc]]
+	if !ok {
+		return false
+	}
+	switch msg.Type {
+	case pp.Have:
+		st.spread(pc, int(msg.Index))
+	case pp.Bitfield:
+		for i, has := range msg.Bitfield {
+			if has {
+				st.spread(pc, i)
+			}
+		}
+	case pp.Request:
+		return !st.allow(pc, int(msg.Index), now)
+	}
+	return false
+}
+
+// spread frees the piece pc has for the peer it was served to
+func (st *superSeedTask) spread(pc *torrent.PeerConn, index int) {
+	owner, ok := st.servedTo[index]
+	if !ok || owner == pc {
+		return
+	}
+	st.release(owner)
+}
+
+func (st *superSeedTask) release(pc *torrent.PeerConn) {
+	if p, ok := st.assigned[pc]; ok {
+		delete(st.assigned, pc)
+		if st.servedTo[p.index] == pc {
+			delete(st.servedTo, p.index)
+		}
+	}
+}
+
+// allow tells if the piece is served to pc, assigning it if pc has none
+func (st *superSeedTask) allow(pc *torrent.PeerConn, index int, now time.Time) bool {
+	if p, ok := st.assigned[pc]; ok {
+		if p.index == index {
+			return true
+		}
+		if now.Sub(p.at) < superSeedStall {
+			return false
+		}
+		st.release(pc)
+	}
+	if owner, ok := st.servedTo[index]; ok && owner != pc {
+		if now.Sub(st.assigned[owner].at) < superSeedStall {
+			return false
+		}
+		st.release(owner)
+	}
+	st.assigned[pc] = superSeedPiece{index: index, at: now}
+	st.servedTo[index] = pc
+	return true
+}
+
+// peerConnReject sends the Reject of the request to the peer, which must
+// have the fast extension. anacrolix/torrent writes the messages of a
+// connection by itself only.
+//
+//go:linkname peerConnReject github.com/anacrolix/torrent.(*PeerConn).reject
+func peerConnReject(pc *torrent.PeerConn, r torrent.Request)
+
+// setSuperSeeding filters the requests of the peers of the super-seeding
+// tasks, called in Configure after setPeerCallbacks. fast tells if the
+// client has the fast extension.
+func (e *Engine) setSuperSeeding(cb *torrent.Callbacks, fast bool) {
+	prevRead := cb.ReadMessage
+	cb.ReadMessage = func(pc *torrent.PeerConn, msg *pp.Message) {
+		if e.superSeeds.drop(pc, msg, time.Now()) {
+			if fast && pc.PeerExtensionBytes.SupportsFast() {
+				peerConnReject(pc, torrent.Request{Index: msg.Index, ChunkSpec: torrent.ChunkSpec{Begin: msg.Begin, Length: msg.Length}})
+			}
+			*msg = pp.Message{Keepalive: true}
+			return
+		}
+		if prevRead != nil {
+			prevRead(pc, msg)
+		}
+	}
+	prevClosed := cb.PeerConnClosed
+	cb.PeerConnClosed = func(pc *torrent.PeerConn) {
+		e.superSeeds.remove(pc)
+		if prevClosed != nil {
+			prevClosed(pc)
+		}
+	}
+	prevHandshake := cb.CompletedHandshake
+	cb.CompletedHandshake = func(pc *torrent.PeerConn, ih torrent.InfoHash) {
+		if prevHandshake != nil {
+			prevHandshake(pc, ih)
+		}
+		e.superSeeds.attach(pc, ih.HexString())
+	}
+}
+
+// SetTorrentSuperSeed turns the super-seeding of the task on or off, for a
+// task this instance is the only seed of
+func (e *Engine) SetTorrentSuperSeed(infohash string, on bool) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	t.SuperSeed = on
+	t.Unlock()
+	e.superSeeds.set(infohash, on)
+	log.Printf("[SuperSeed] %s %v", infohash, on)
+	e.notifyChanged()
+	return nil
+}
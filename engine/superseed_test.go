@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+func TestSuperSeedDrop(t *testing.T) {
+	var sm superSeedMap
+	a, b, c := &torrent.PeerConn{}, &torrent.PeerConn{}, &torrent.PeerConn{}
+	for _, pc := range []*torrent.PeerConn{a, b, c} {
+		sm.attach(pc, "ih")
+	}
+	now := time.Now()
+	req := func(pc *torrent.PeerConn, index int) bool {
+		return !sm.drop(pc, &pp.Message{Type: pp.Request, Index: pp.Integer(index)}, now)
+	}
+
+	if !req(a, 1) || !req(b, 1) {
+		t.Fatal("requests dropped without super-seeding")
+	}
+	sm.set("ih", true)
+	if !req(a, 1) || !req(a, 1) {
+		t.Error("first piece of a dropped")
+	}
+	if req(a, 2) {
+		t.Error("second piece of a served before the first spread")
+	}
+	if req(b, 1) {
+		t.Error("piece of a served to b")
+	}
+	if !req(b, 2) {
+		t.Error("other piece of b dropped")
+	}
+
+	// c has the piece of a, a gets another
+	sm.drop(c, &pp.Message{Type: pp.Have, Index: 1}, now)
+	if !req(a, 3) {
+		t.Error("a not given another piece once spread")
+	}
+	// b closed, its piece is free
+	sm.remove(b)
+	if !req(c, 2) {
+		t.Error("piece of the closed b dropped")
+	}
+	// stalled
+	now = now.Add(superSeedStall)
+	if !req(a, 4) {
+		t.Error("stalled a not given another piece")
+	}
+
+	sm.set("ih", false)
+	if !req(a, 1) {
+		t.Error("request dropped after super-seeding off")
+	}
+}
+
+func TestSuperSeedReject(t *testing.T) {
+	newClient := func(dir string, cb func(*torrent.Callbacks)) *torrent.Client {
+		tc := torrent.NewDefaultClientConfig()
+		tc.DataDir = dir
+		tc.ListenPort = 0
+		tc.Seed = true
+		tc.NoDHT = true
+		tc.DisableTrackers = true
+		tc.NoDefaultPortForwarding = true
+		cb(&tc.Callbacks)
+		cl, err := torrent.NewClient(tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cl
+	}
+
+	dir := t.TempDir()
+	data := make([]byte, 8<<14)
+	rand.Read(data)
+	if err := ioutil.WriteFile(filepath.Join(dir, "data"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 1 << 14}
+	if err := info.BuildFromFilePath(filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+	mi := &metainfo.MetaInfo{}
+	var err error
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{}
+	seeder := newClient(dir, func(cb *torrent.Callbacks) { e.setSuperSeeding(cb, true) })
+	defer seeder.Close()
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.VerifyData()
+	e.superSeeds.set(st.InfoHash().HexString(), true)
+
+	rejected := make(chan struct{}, 1)
+	leecher := newClient(t.TempDir(), func(cb *torrent.Callbacks) {
+		cb.ReadMessage = func(pc *torrent.PeerConn, msg *pp.Message) {
+			if msg.Type == pp.Reject {
+				select {
+				case rejected <- struct{}{}:
+				default:
+				}
+			}
+		}
+	})
+	defer leecher.Close()
+	lt, err := leecher.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt.AddClientPeer(seeder)
+	lt.DownloadAll()
+
+	select {
+	case <-rejected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no request of the other pieces rejected")
+	}
+}
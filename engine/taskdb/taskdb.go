@@ -0,0 +1,196 @@
+// Package taskdb keeps a record of every task ever added in a bbolt
+// database, outliving the tasks: when they were added, completed and
+// deleted, why, and how much they transferred.
+package taskdb
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("tasks")
+
+// the states of the records in Query
+const (
+	StateActive    = "active"
+	StateCompleted = "completed"
+	StateDeleted   = "deleted"
+)
+
+// Record is the history of a task, by its infohash
+type Record struct {
+	InfoHash     string
+	Name         string
+	Size         int64
+	Label        string `json:",omitempty"`
+	AddedBy      string `json:",omitempty"`
+	AddedAt      time.Time
+	CompletedAt  time.Time `json:",omitempty"`
+	DeletedAt    time.Time `json:",omitempty"`
+	DeleteReason string    `json:",omitempty"`
+	// the totals when last saved, at the completion and the deletion
+	Downloaded int64
+	Uploaded   int64
+	Ratio      float32
+}
+
+// State is deleted, completed or active
+func (r *Record) State() string {
+	switch {
+	case !r.DeletedAt.IsZero():
+		return StateDeleted
+	case !r.CompletedAt.IsZero():
+		return StateCompleted
+	}
+	return StateActive
+}
+
+// Query selects a page of the records, the newest added first. The empty
+// fields don't filter.
+type Query struct {
+	// in the names and the infohashes, case insensitive
+	Search string
+	State  string
+	// added within
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+// Page is a page of the records matching the query, Total before paging
+type Page struct {
+	Total   int
+	Offset  int
+	Limit   int
+	Records []Record
+}
+
+// DB is the database of the records
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database file
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// WriteTo writes a consistent copy of the database file, eg: for backups
+func (d *DB) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	err := d.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Update applies fn to the record of the infohash, a new one if none
+func (d *DB) Update(infohash string, fn func(r *Record)) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		r := Record{InfoHash: infohash}
+		if v := b.Get([]byte(infohash)); v != nil {
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+		}
+		fn(&r)
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(infohash), v)
+	})
+}
+
+// Get returns the record of the infohash, nil if none
+func (d *DB) Get(infohash string) (*Record, error) {
+	var r *Record
+	err := d.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucket).Get([]byte(infohash))
+		if v == nil {
+			return nil
+		}
+		r = &Record{}
+		return json.Unmarshal(v, r)
+	})
+	return r, err
+}
+
+// Find returns the page of the records selected by q
+func (d *DB) Find(q Query) (Page, error) {
+	var rs []Record
+	search := strings.ToLower(q.Search)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, v []byte) error {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if q.match(&r, search) {
+				rs = append(rs, r)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].AddedAt.Equal(rs[j].AddedAt) {
+			return rs[i].AddedAt.After(rs[j].AddedAt)
+		}
+		return rs[i].InfoHash < rs[j].InfoHash
+	})
+	p := Page{Total: len(rs), Offset: q.Offset, Limit: q.Limit, Records: []Record{}}
+	if q.Offset < len(rs) {
+		rs = rs[q.Offset:]
+		if q.Limit > 0 && len(rs) > q.Limit {
+			rs = rs[:q.Limit]
+		}
+		p.Records = rs
+	}
+	return p, nil
+}
+
+// match must be given the lowercased search
+func (q *Query) match(r *Record, search string) bool {
+	if search != "" && !strings.Contains(strings.ToLower(r.Name), search) && !strings.HasPrefix(r.InfoHash, search) {
+		return false
+	}
+	if q.State != "" && r.State() != q.State {
+		return false
+	}
+	if !q.Since.IsZero() && r.AddedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.AddedAt.Before(q.Until) {
+		return false
+	}
+	return true
+}
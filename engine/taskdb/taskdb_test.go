@@ -0,0 +1,76 @@
+package taskdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		{InfoHash: "aa01", Name: "Ubuntu 20.04", AddedAt: day},
+		{InfoHash: "bb02", Name: "Debian 11", AddedAt: day.Add(24 * time.Hour), CompletedAt: day.Add(25 * time.Hour)},
+		{InfoHash: "cc03", Name: "ubuntu 21.04", AddedAt: day.Add(48 * time.Hour), DeletedAt: day.Add(49 * time.Hour), DeleteReason: "user"},
+	}
+	for _, r := range records {
+		r := r
+		if err := d.Update(r.InfoHash, func(rec *Record) { *rec = r }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Update("aa01", func(r *Record) { r.Size = 42 }); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	// kept across opens
+	if d, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if r, err := d.Get("aa01"); err != nil || r == nil || r.Size != 42 || r.Name != "Ubuntu 20.04" {
+		t.Fatalf("Get() = %+v, %v", r, err)
+	}
+	if r, err := d.Get("ff"); r != nil || err != nil {
+		t.Errorf("Get(unknown) = %+v, %v", r, err)
+	}
+
+	tests := []struct {
+		q    Query
+		want []string
+	}{
+		{Query{}, []string{"cc03", "bb02", "aa01"}},
+		{Query{Search: "UBUNTU"}, []string{"cc03", "aa01"}},
+		{Query{Search: "bb"}, []string{"bb02"}},
+		{Query{State: StateDeleted}, []string{"cc03"}},
+		{Query{State: StateActive}, []string{"aa01"}},
+		{Query{Since: day.Add(time.Hour), Until: day.Add(48 * time.Hour)}, []string{"bb02"}},
+		{Query{Offset: 1, Limit: 1}, []string{"bb02"}},
+	}
+	for _, tt := range tests {
+		p, err := d.Find(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range p.Records {
+			got = append(got, r.InfoHash)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("Find(%+v) = %v, want %v", tt.q, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Find(%+v) = %v, want %v", tt.q, got, tt.want)
+				break
+			}
+		}
+	}
+}
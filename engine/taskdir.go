@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/storage"
+	"github.com/boypt/simple-torrent/common"
+)
+
+// taskDirsFile keeps the download directory of the tasks added to a dir
+// other than DownloadDirectory, so they're restored to the same place
+const taskDirsFile = ".taskdirs.json"
+
+var (
+	errTaskDir        = errors.New("Invalid download directory")
+	errTaskDirOutside = errors.New("the download directory is outside DownloadDirectory and TaskDirRoots")
+)
+
+type taskDirs struct {
+	sync.Mutex
+	// infohash -> dir, loaded on first use
+	dirs map[string]string
+	// dir -> storage, shared by the tasks of the same dir
+	storages map[string]storage.ClientImplCloser
+}
+
+// resolveTaskDir makes dir absolute, relative dirs are under DownloadDirectory.
+// Returns empty for the DownloadDirectory itself. The dir must be within the
+// roots of taskDirRoots, its symlinks followed.
+func (c *Config) resolveTaskDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	dldir, err := filepath.Abs(c.DownloadDirectory)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(dldir, dir)
+	}
+	dir = filepath.Clean(dir)
+	if dir == dldir {
+		return "", nil
+	}
+	roots := c.taskDirRoots()
+	if !withinRoots(dir, roots) {
+		return "", errTaskDirOutside
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errTaskDir
+	}
+	// a symlink may have been made meanwhile
+	if !withinRoots(dir, roots) {
+		return "", errTaskDirOutside
+	}
+	return dir, nil
+}
+
+// taskDirRoots returns the dirs the task dirs may be in: DownloadDirectory,
+// TaskDirRoots and the dirs of LabelDirs
+func (c *Config) taskDirRoots() []string {
+	roots := append([]string{c.DownloadDirectory}, common.SplitLines(c.TaskDirRoots)...)
+	dirs, _ := parseLabelDirs(c.LabelDirs)
+	for _, d := range dirs {
+		for _, dir := range []string{d.download, d.completed} {
+			if filepath.IsAbs(dir) {
+				roots = append(roots, dir)
+			}
+		}
+	}
+	return roots
+}
+
+// withinRoots tells if dir is one of the roots or under one, by the real
+// paths of both
+func withinRoots(dir string, roots []string) bool {
+	real := realPath(dir)
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(realPath(abs), real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// realPath returns the clean absolute path with the symlinks of its existing
+// part followed, the missing part joined as it is
+func realPath(p string) string {
+	p = filepath.Clean(p)
+	rest := ""
+	for {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(p, rest)
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// taskStorage returns the storage of dir, wrapped for per torrent rate limits
+func (e *Engine) taskStorage(dir string) storage.ClientImpl {
+	e.taskDirs.Lock()
+	defer e.taskDirs.Unlock()
+	if e.taskDirs.storages == nil {
+		e.taskDirs.storages = make(map[string]storage.ClientImplCloser)
+	}
+	st, ok := e.taskDirs.storages[dir]
+	if !ok {
+		log.Debug("[taskStorage] new storage", dir)
+		st = e.newDataStorage(dir)
+		e.taskDirs.storages[dir] = st
+	}
+	return &limitedStorage{ClientImpl: st, e: e}
+}
+
+// closeTaskStorages is called with the client closed
+func (e *Engine) closeTaskStorages() {
+	e.taskDirs.Lock()
+	defer e.taskDirs.Unlock()
+	for _, st := range e.taskDirs.storages {
+		common.FancyHandleError(st.Close())
+	}
+	e.taskDirs.storages = nil
+	// the cache dir may change with the config
+	e.taskDirs.dirs = nil
+}
+
+// loadTaskDirs must hold lock
+func (e *Engine) loadTaskDirs() {
+	if e.taskDirs.dirs != nil {
+		return
+	}
+	e.taskDirs.dirs = make(map[string]string)
+	data, err := ioutil.ReadFile(filepath.Join(e.cacheDir, taskDirsFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("[loadTaskDirs]", err)
+		}
+		return
+	}
+	common.HandleError(json.Unmarshal(data, &e.taskDirs.dirs))
+}
+
+// saveTaskDirs must hold lock
+func (e *Engine) saveTaskDirs() {
+	data, err := json.Marshal(e.taskDirs.dirs)
+	if err != nil {
+		log.Warn("[saveTaskDirs]", err)
+		return
+	}
+	common.HandleError(ioutil.WriteFile(filepath.Join(e.cacheDir, taskDirsFile), data, 0644))
+}
+
+// taskDir returns the saved dir of the task, empty for DownloadDirectory
+func (e *Engine) taskDir(ih string) string {
+	e.taskDirs.Lock()
+	defer e.taskDirs.Unlock()
+	e.loadTaskDirs()
+	return e.taskDirs.dirs[ih]
+}
+
+func (e *Engine) setTaskDir(ih, dir string) {
+	e.taskDirs.Lock()
+	defer e.taskDirs.Unlock()
+	e.loadTaskDirs()
+	if e.taskDirs.dirs[ih] == dir {
+		return
+	}
+	if dir == "" {
+		delete(e.taskDirs.dirs, ih)
+	} else {
+		e.taskDirs.dirs[ih] = dir
+	}
+	e.saveTaskDirs()
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveTaskDir(t *testing.T) {
+	base := t.TempDir()
+	dldir := filepath.Join(base, "downloads")
+	media := filepath.Join(base, "media")
+	extra := filepath.Join(base, "extra")
+	outside := filepath.Join(base, "outside")
+	for _, d := range []string{dldir, media, extra, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a link under DownloadDirectory to outside of it
+	if err := os.Symlink(outside, filepath.Join(dldir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{config: Config{
+		DownloadDirectory: dldir,
+		TaskDirRoots:      extra,
+		LabelDirs:         "tv => tv/incoming | " + media,
+	}}
+
+	for _, tc := range []struct {
+		dir  string
+		want string
+		err  error
+	}{
+		{"", "", nil},
+		{"linux", filepath.Join(dldir, "linux"), nil},
+		{dldir, "", nil},
+		{filepath.Join(media, "shows"), filepath.Join(media, "shows"), nil},
+		{filepath.Join(extra, "a", "b"), filepath.Join(extra, "a", "b"), nil},
+		{"../outside", "", errTaskDirOutside},
+		{"a/../../outside", "", errTaskDirOutside},
+		{outside, "", errTaskDirOutside},
+		{"/etc", "", errTaskDirOutside},
+		{"escape", "", errTaskDirOutside},
+		{"escape/deeper", "", errTaskDirOutside},
+	} {
+		got, err := e.config.resolveTaskDir(tc.dir)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("resolveTaskDir(%q) = %q, %v; want %q, %v", tc.dir, got, err, tc.want, tc.err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "deeper")); !os.IsNotExist(err) {
+		t.Error("a refused dir was made")
+	}
+}
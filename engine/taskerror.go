@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// the kinds of the error state of the tasks
+const (
+	// the info not got within MetadataTimeout after all its retries
+	TaskErrorMetadata = "metadata"
+	// none of the trackers of the task answered the scrape
+	TaskErrorTrackers = "trackers"
+	// downloading without a peer for StallTimeout
+	TaskErrorNoPeers = "nopeers"
+	// writing the data failed
+	TaskErrorStorage = "storage"
+)
+
+const (
+	// the error states of the tasks are checked this often
+	taskErrorTick = 30 * time.Second
+	// the backoff of the retries doubles up to it
+	retryMaxInterval = 6 * time.Hour
+)
+
+var errNoTaskError = errors.New("the task has no error")
+
+// TaskError is the error state of a task, retried by RetryInterval doubling
+// up to RetryMax times, and cleared once the task recovers
+type TaskError struct {
+	Kind    string
+	Detail  string
+	Since   time.Time
+	Retries int
+	// zero when no more retries are due
+	NextRetry time.Time
+}
+
+// storageErrorMap keeps the last failed write of the tasks, see limitedPiece
+type storageErrorMap struct {
+	sync.Mutex
+	m map[string]error
+}
+
+func (s *storageErrorMap) set(ih string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]error)
+	}
+	s.m[ih] = err
+}
+
+// take returns the failed write of the task since the last call
+func (s *storageErrorMap) take(ih string) error {
+	s.Lock()
+	defer s.Unlock()
+	err := s.m[ih]
+	delete(s.m, ih)
+	return err
+}
+
+// taskErrorMap keeps the error states by task, apart from the tasks as they
+// are reloaded by the retries
+type taskErrorMap struct {
+	sync.Mutex
+	m map[string]*taskErrorEntry
+}
+
+type taskErrorEntry struct {
+	state *TaskError
+	// watched for TaskErrorNoPeers
+	noPeersSince time.Time
+	// the storage error is cleared once it downloads more
+	downloaded int64
+	// the kind dismissed by the user until it recovers
+	dismissed string
+}
+
+// entry returns the entry of the task, m locked
+func (tm *taskErrorMap) entry(ih string) *taskErrorEntry {
+	if tm.m == nil {
+		tm.m = make(map[string]*taskErrorEntry)
+	}
+	en, ok := tm.m[ih]
+	if !ok {
+		en = &taskErrorEntry{}
+		tm.m[ih] = en
+	}
+	return en
+}
+
+func (tm *taskErrorMap) drop(ih string) {
+	tm.Lock()
+	defer tm.Unlock()
+	delete(tm.m, ih)
+}
+
+// retryBackoff returns the wait before the retry after n retries
+func retryBackoff(interval time.Duration, n int) time.Duration {
+	d := interval
+	for i := 0; i < n && d < retryMaxInterval; i++ {
+		d *= 2
+	}
+	if d > retryMaxInterval {
+		d = retryMaxInterval
+	}
+	return d
+}
+
+// taskErrorRoutine sets, retries and clears the error states of the tasks
+func (e *Engine) taskErrorRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(taskErrorTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			e.checkTaskErrors(time.Now())
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+func (e *Engine) checkTaskErrors(now time.Time) {
+	c := e.Config()
+	for _, t := range e.Torrents() {
+		if e.updateTaskError(t, &c, now) {
+			go e.retryTask(t)
+		}
+	}
+}
+
+// detectTaskError returns the error of the task found now, empty if healthy,
+// t and the map locked
+func (e *Engine) detectTaskError(t *Torrent, en *taskErrorEntry, c *Config, now time.Time) (kind, detail string) {
+	if !t.Loaded {
+		if t.MetadataTimeout {
+			return TaskErrorMetadata, fmt.Sprintf("no info after %d retries of %s", t.MetadataRetries, c.MetadataTimeout)
+		}
+		return "", ""
+	}
+	if !t.Started || t.Done {
+		en.noPeersSince = time.Time{}
+		return "", ""
+	}
+	if err := e.storageErrs.take(t.InfoHash); err != nil {
+		return TaskErrorStorage, err.Error()
+	}
+	if en.state != nil && en.state.Kind == TaskErrorStorage && t.Downloaded <= en.downloaded {
+		// until it writes again
+		return TaskErrorStorage, en.state.Detail
+	}
+	if results := e.scrapes.get(t.InfoHash); len(results) > 0 {
+		if _, _, ok := swarmCounts(results); !ok {
+			var first string
+			for _, sc := range results {
+				first = sc.err
+				break
+			}
+			return TaskErrorTrackers, fmt.Sprintf("none of the %d trackers answered: %s", len(results), first)
+		}
+	}
+	if c.StallTimeout > 0 && t.Stats != nil {
+		if t.Stats.ActivePeers > 0 {
+			en.noPeersSince = time.Time{}
+		} else if en.noPeersSince.IsZero() {
+			en.noPeersSince = now
+		} else if now.Sub(en.noPeersSince) >= c.StallTimeout {
+			return TaskErrorNoPeers, fmt.Sprintf("no peers for %s", now.Sub(en.noPeersSince).Round(time.Minute))
+		}
+	}
+	return "", ""
+}
+
+// updateTaskError sets or clears the error state of the task, returns true
+// when a retry is due
+func (e *Engine) updateTaskError(t *Torrent, c *Config, now time.Time) bool {
+	t.Lock()
+	defer t.Unlock()
+	e.taskErrors.Lock()
+	defer e.taskErrors.Unlock()
+	en := e.taskErrors.entry(t.InfoHash)
+	defer func() {
+		t.ErrorState = nil
+		if en.state != nil {
+			s := *en.state
+			t.ErrorState = &s
+		}
+	}()
+
+	kind, detail := e.detectTaskError(t, en, c, now)
+	if kind == "" {
+		if en.state != nil {
+			log.Printf("[TaskError] %s recovered from %s", t.InfoHash, en.state.Kind)
+		}
+		en.state = nil
+		en.dismissed = ""
+		return false
+	}
+	if kind == en.dismissed {
+		return false
+	}
+	if en.state == nil || en.state.Kind != kind {
+		en.state = &TaskError{Kind: kind, Detail: detail, Since: now}
+		en.downloaded = t.Downloaded
+		if c.RetryMax > 0 {
+			en.state.NextRetry = now.Add(c.RetryInterval)
+		}
+		log.Printf("[TaskError] %s %s: %s", t.InfoHash, kind, detail)
+		e.emit(EventError, t, fmt.Errorf("%s: %s", kind, detail))
+		return false
+	}
+	s := en.state
+	s.Detail = detail
+	if s.NextRetry.IsZero() || now.Before(s.NextRetry) {
+		return false
+	}
+	s.Retries++
+	s.NextRetry = time.Time{}
+	if s.Retries < c.RetryMax {
+		s.NextRetry = now.Add(retryBackoff(c.RetryInterval, s.Retries))
+	}
+	log.Printf("[TaskError] %s retry %d/%d of %s", t.InfoHash, s.Retries, c.RetryMax, kind)
+	return true
+}
+
+// retryTask announces the task again to find peers, the tasks failing to
+// write or only using their own trackers are reloaded
+func (e *Engine) retryTask(t *Torrent) {
+	t.Lock()
+	var kind string
+	if t.ErrorState != nil {
+		kind = t.ErrorState.Kind
+	}
+	tt, trackersOnly := t.t, t.TrackersOnly
+	t.Unlock()
+	// the storage is opened again
+	if kind == TaskErrorStorage || (trackersOnly && tt != nil) {
+		if err := e.reloadTask(t, nil); err != nil {
+			log.Warn("[TaskError] reload", t.InfoHash, err)
+		}
+		return
+	}
+	if tt == nil {
+		e.RLock()
+		if e.client != nil {
+			tt, _ = e.client.Torrent(metainfo.NewHashFromHex(t.InfoHash))
+		}
+		e.RUnlock()
+	}
+	if tt == nil {
+		return
+	}
+	if tt.Info() == nil {
+		// removed if the info turns out private
+		mi := tt.Metainfo()
+		t.Lock()
+		t.injectedTrackers = append(t.injectedTrackers, trackersMissing(mi.UpvertedAnnounceList(), e.Trackers)...)
+		t.Unlock()
+	}
+	e.reannounce(tt, e.Trackers)
+}
+
+// RetryTorrent retries the task in the error state now, its retries start over
+func (e *Engine) RetryTorrent(infohash string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	c := e.Config()
+	e.taskErrors.Lock()
+	en := e.taskErrors.entry(infohash)
+	s := en.state
+	if s != nil {
+		s.Retries = 0
+		s.NextRetry = time.Time{}
+		if c.RetryMax > 0 {
+			s.NextRetry = time.Now().Add(c.RetryInterval)
+		}
+	}
+	e.taskErrors.Unlock()
+	if s == nil {
+		return errNoTaskError
+	}
+	log.Printf("[TaskError] %s retried by the user", infohash)
+	go e.retryTask(t)
+	return nil
+}
+
+// DismissTorrentError clears the error state of the task, the same error
+// isn't set again until the task recovers
+func (e *Engine) DismissTorrentError(infohash string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	defer t.Unlock()
+	e.taskErrors.Lock()
+	defer e.taskErrors.Unlock()
+	en := e.taskErrors.entry(infohash)
+	if en.state == nil {
+		return errNoTaskError
+	}
+	en.dismissed = en.state.Kind
+	en.state = nil
+	t.ErrorState = nil
+	log.Printf("[TaskError] %s dismissed %s", infohash, en.dismissed)
+	e.notifyChanged()
+	return nil
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for n, want := range []time.Duration{5 * time.Minute, 10 * time.Minute, 20 * time.Minute} {
+		if got := retryBackoff(5*time.Minute, n); got != want {
+			t.Errorf("retryBackoff(%d) = %s, want %s", n, got, want)
+		}
+	}
+	if got := retryBackoff(time.Hour, 10); got != retryMaxInterval {
+		t.Errorf("retryBackoff() = %s, want the max %s", got, retryMaxInterval)
+	}
+}
+
+func TestUpdateTaskError(t *testing.T) {
+	e := &Engine{}
+	e.timelines.m = make(map[string][]Event)
+	c := &Config{StallTimeout: time.Minute, RetryInterval: time.Minute, RetryMax: 2}
+	task := &Torrent{InfoHash: "ih", Loaded: true, Started: true, Stats: &torrent.TorrentStats{}}
+	now := time.Now()
+
+	if e.updateTaskError(task, c, now) || task.ErrorState != nil {
+		t.Fatal("error state without peers before StallTimeout")
+	}
+	now = now.Add(time.Minute)
+	if e.updateTaskError(task, c, now) || task.ErrorState == nil || task.ErrorState.Kind != TaskErrorNoPeers {
+		t.Fatalf("ErrorState after StallTimeout = %+v", task.ErrorState)
+	}
+	if !e.updateTaskError(task, c, now.Add(time.Minute)) {
+		t.Error("first retry not due after RetryInterval")
+	}
+	if e.updateTaskError(task, c, now.Add(2*time.Minute)) {
+		t.Error("second retry due before its backoff")
+	}
+	if !e.updateTaskError(task, c, now.Add(3*time.Minute)) || task.ErrorState.Retries != 2 {
+		t.Errorf("second retry = %+v", task.ErrorState)
+	}
+	if e.updateTaskError(task, c, now.Add(time.Hour)) || !task.ErrorState.NextRetry.IsZero() {
+		t.Errorf("retried after RetryMax: %+v", task.ErrorState)
+	}
+
+	// dismissed until it recovers
+	e.ts = map[string]*Torrent{"ih": task}
+	if err := e.DismissTorrentError("ih"); err != nil {
+		t.Fatal(err)
+	}
+	if e.updateTaskError(task, c, now.Add(time.Hour)); task.ErrorState != nil {
+		t.Error("dismissed error set again")
+	}
+	task.Stats.ActivePeers = 1
+	e.updateTaskError(task, c, now.Add(time.Hour))
+	if err := e.DismissTorrentError("ih"); err != errNoTaskError {
+		t.Errorf("DismissTorrentError() healthy = %v", err)
+	}
+
+	// the storage error stays until it downloads more
+	e.storageErrs.set("ih", errors.New("no space left on device"))
+	e.updateTaskError(task, c, now)
+	if task.ErrorState == nil || task.ErrorState.Kind != TaskErrorStorage {
+		t.Fatalf("ErrorState on a failed write = %+v", task.ErrorState)
+	}
+	e.updateTaskError(task, c, now)
+	if task.ErrorState == nil {
+		t.Error("storage error cleared without progress")
+	}
+	task.Downloaded++
+	if e.updateTaskError(task, c, now); task.ErrorState != nil {
+		t.Errorf("storage error kept after progress: %+v", task.ErrorState)
+	}
+}
@@ -0,0 +1,271 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine/taskdb"
+)
+
+// taskDBFile records every task ever added, in the cache dir
+const taskDBFile = ".tasks.db"
+
+// the DeleteReason of the task records
+const (
+	DeleteReasonUser      = "user"
+	DeleteReasonSeedLimit = "seedlimit"
+	DeleteReasonReclaim   = "reclaim"
+	DeleteReasonUploaded  = "uploaded"
+	DeleteReasonPolicy    = "policy"
+	DeleteReasonExpired   = "expired"
+)
+
+var errNoTaskDB = errors.New("task history unavailable")
+
+type taskRecords struct {
+	sync.Mutex
+	db *taskdb.DB
+	// the reasons of the deletions to come
+	reasons map[string]string
+	// the last state of the deleted tasks, saved with their EventDeleted
+	deleted map[string]taskdb.Record
+}
+
+// openTaskDB opens the task history, called by the first Configure
+func (e *Engine) openTaskDB() {
+	db, err := taskdb.Open(filepath.Join(e.cacheDir, taskDBFile))
+	if err != nil {
+		log.Warn("[TaskDB]", err)
+		return
+	}
+	r := &e.taskRecords
+	r.Lock()
+	r.db = db
+	r.reasons = make(map[string]string)
+	r.deleted = make(map[string]taskdb.Record)
+	r.Unlock()
+	events, _ := e.Subscribe(webhookQueue, EventAdded, EventMetadata, EventCompleted, EventDeleted)
+	go e.taskDBRoutine(db, events)
+}
+
+// writeTaskDB writes a copy of the task history
+func (e *Engine) writeTaskDB(w io.Writer) error {
+	r := &e.taskRecords
+	r.Lock()
+	db := r.db
+	r.Unlock()
+	if db == nil {
+		return errNoTaskDB
+	}
+	_, err := db.WriteTo(w)
+	return err
+}
+
+// taskSnapshot must hold the lock of t
+func taskSnapshot(t *Torrent) taskdb.Record {
+	return taskdb.Record{
+		InfoHash:   t.InfoHash,
+		Name:       t.Name,
+		Size:       t.Size,
+		Label:      t.Label,
+		AddedBy:    t.AddedBy,
+		AddedAt:    t.AddedAt,
+		Downloaded: t.Downloaded,
+		Uploaded:   t.Uploaded,
+		Ratio:      t.SeedRatio,
+	}
+}
+
+// merge sets the known fields of the snapshot s on r
+func merge(r *taskdb.Record, s taskdb.Record) {
+	if s.Name != "" {
+		r.Name = s.Name
+	}
+	if s.Size > 0 {
+		r.Size = s.Size
+	}
+	r.Label, r.AddedBy = s.Label, s.AddedBy
+	if s.Downloaded > r.Downloaded || s.Uploaded > r.Uploaded {
+		r.Downloaded, r.Uploaded, r.Ratio = s.Downloaded, s.Uploaded, s.Ratio
+	}
+}
+
+// taskDBRoutine records the lifecycle events of the tasks
+func (e *Engine) taskDBRoutine(db *taskdb.DB, events <-chan Event) {
+	for ev := range events {
+		var snap *taskdb.Record
+		if ev.Type == EventDeleted {
+			e.taskRecords.Lock()
+			if s, ok := e.taskRecords.deleted[ev.InfoHash]; ok {
+				snap = &s
+				delete(e.taskRecords.deleted, ev.InfoHash)
+			}
+			e.taskRecords.Unlock()
+		} else if t, ok := e.Torrent(ev.InfoHash); ok {
+			t.Lock()
+			s := taskSnapshot(t)
+			t.Unlock()
+			snap = &s
+		}
+		err := db.Update(ev.InfoHash, func(r *taskdb.Record) {
+			if ev.Name != "" {
+				r.Name = ev.Name
+			}
+			if ev.Size > 0 {
+				r.Size = ev.Size
+			}
+			if snap != nil {
+				merge(r, *snap)
+			}
+			switch ev.Type {
+			case EventAdded:
+				// added again, eg: undeleted
+				r.DeletedAt, r.DeleteReason = time.Time{}, ""
+				if r.AddedAt.IsZero() {
+					r.AddedAt = ev.Time
+					if snap != nil && !snap.AddedAt.IsZero() {
+						r.AddedAt = snap.AddedAt
+					}
+				}
+			case EventCompleted:
+				if r.CompletedAt.IsZero() {
+					r.CompletedAt = ev.Time
+				}
+			case EventDeleted:
+				r.DeletedAt, r.DeleteReason = ev.Time, DeleteReasonUser
+				if snap != nil && snap.DeleteReason != "" {
+					r.DeleteReason = snap.DeleteReason
+				}
+			}
+		})
+		if err != nil {
+			log.Warn("[TaskDB]", ev.InfoHash, err)
+		}
+	}
+}
+
+// setDeleteReason tells why the task is about to be deleted, recorded in
+// the task history
+func (e *Engine) setDeleteReason(infohash, reason string) {
+	r := &e.taskRecords
+	r.Lock()
+	defer r.Unlock()
+	if r.reasons != nil {
+		r.reasons[infohash] = reason
+	}
+}
+
+// recordDeleting keeps the last state of the task being deleted with its
+// reason, t unlocked
+func (e *Engine) recordDeleting(t *Torrent) {
+	t.Lock()
+	s := taskSnapshot(t)
+	t.Unlock()
+	r := &e.taskRecords
+	r.Lock()
+	defer r.Unlock()
+	if r.db == nil {
+		return
+	}
+	s.DeleteReason = r.reasons[s.InfoHash]
+	delete(r.reasons, s.InfoHash)
+	r.deleted[s.InfoHash] = s
+}
+
+// saveTaskRecords saves the totals of the tasks to their records
+func (e *Engine) saveTaskRecords() {
+	e.taskRecords.Lock()
+	db := e.taskRecords.db
+	e.taskRecords.Unlock()
+	if db == nil {
+		return
+	}
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		s := taskSnapshot(t)
+		t.Unlock()
+		if err := db.Update(ih, func(r *taskdb.Record) { merge(r, s) }); err != nil {
+			log.Warn("[TaskDB]", ih, err)
+		}
+	}
+}
+
+// ParseTaskHistoryQuery reads the query of the task history API: q, state
+// (active, completed or deleted), since and until (RFC 3339 or 2006-01-02),
+// offset and limit
+func ParseTaskHistoryQuery(v url.Values) (taskdb.Query, error) {
+	q := taskdb.Query{
+		Search: strings.TrimSpace(v.Get("q")),
+		State:  strings.ToLower(v.Get("state")),
+		Limit:  defaultListLimit,
+	}
+	switch q.State {
+	case "", taskdb.StateActive, taskdb.StateCompleted, taskdb.StateDeleted:
+	default:
+		return q, fmt.Errorf("invalid state %q", q.State)
+	}
+	var err error
+	for _, f := range []struct {
+		key string
+		t   *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		s := v.Get(f.key)
+		if s == "" {
+			continue
+		}
+		if *f.t, err = time.Parse(time.RFC3339, s); err != nil {
+			if *f.t, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+				return q, fmt.Errorf("invalid %s %q", f.key, s)
+			}
+		}
+	}
+	if s := v.Get("offset"); s != "" {
+		if q.Offset, err = strconv.Atoi(s); err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("invalid offset %q", s)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	if q.Limit > maxListLimit {
+		q.Limit = maxListLimit
+	}
+	return q, nil
+}
+
+// TaskHistory returns the page of the records of the tasks ever added
+// selected by q, with the current totals of the live ones
+func (e *Engine) TaskHistory(q taskdb.Query) (taskdb.Page, error) {
+	e.taskRecords.Lock()
+	db := e.taskRecords.db
+	e.taskRecords.Unlock()
+	if db == nil {
+		return taskdb.Page{}, errNoTaskDB
+	}
+	p, err := db.Find(q)
+	if err != nil {
+		return p, err
+	}
+	for i := range p.Records {
+		r := &p.Records[i]
+		if r.State() == taskdb.StateDeleted {
+			continue
+		}
+		if t, ok := e.Torrent(r.InfoHash); ok {
+			t.Lock()
+			s := taskSnapshot(t)
+			t.Unlock()
+			merge(r, s)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine/taskdb"
+)
+
+func TestParseTaskHistoryQuery(t *testing.T) {
+	q, err := ParseTaskHistoryQuery(url.Values{"q": {" ubuntu "}, "state": {"Deleted"}, "since": {"2021-06-01"}, "until": {"2021-07-01T00:00:00Z"}, "limit": {"1000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2021, 6, 1, 0, 0, 0, 0, time.Local)
+	if q.Search != "ubuntu" || q.State != taskdb.StateDeleted || !q.Since.Equal(since) ||
+		!q.Until.Equal(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)) || q.Limit != maxListLimit {
+		t.Errorf("ParseTaskHistoryQuery() = %+v", q)
+	}
+	for _, v := range []url.Values{{"state": {"paused"}}, {"since": {"yesterday"}}, {"limit": {"0"}}, {"offset": {"-1"}}} {
+		if _, err := ParseTaskHistoryQuery(v); err == nil {
+			t.Errorf("ParseTaskHistoryQuery(%v) expecting error", v)
+		}
+	}
+}
+
+func TestTaskDBRoutine(t *testing.T) {
+	db, err := taskdb.Open(filepath.Join(t.TempDir(), taskDBFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	e := &Engine{}
+	e.taskRecords.db = db
+	e.taskRecords.reasons = make(map[string]string)
+	e.taskRecords.deleted = make(map[string]taskdb.Record)
+
+	added := time.Now().Add(-time.Hour)
+	e.setDeleteReason("aa", DeleteReasonSeedLimit)
+	e.recordDeleting(&Torrent{InfoHash: "aa", Name: "Ubuntu", Uploaded: 300, Downloaded: 100, SeedRatio: 3})
+	events := make(chan Event, 4)
+	events <- Event{Type: EventAdded, InfoHash: "aa", Name: "Ubuntu", Time: added}
+	events <- Event{Type: EventCompleted, InfoHash: "aa", Size: 100, Time: added.Add(time.Minute)}
+	events <- Event{Type: EventDeleted, InfoHash: "aa", Time: added.Add(2 * time.Minute)}
+	close(events)
+	e.taskDBRoutine(db, events)
+
+	r, err := db.Get("aa")
+	if err != nil || r == nil {
+		t.Fatal(r, err)
+	}
+	if !r.AddedAt.Equal(added) || r.Size != 100 || r.CompletedAt.IsZero() || r.State() != taskdb.StateDeleted ||
+		r.DeleteReason != DeleteReasonSeedLimit || r.Uploaded != 300 || r.Ratio != 3 {
+		t.Errorf("record = %+v", r)
+	}
+	if p, err := e.TaskHistory(taskdb.Query{State: taskdb.StateDeleted}); err != nil || p.Total != 1 {
+		t.Errorf("TaskHistory() = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"strings"
+	"time"
+)
+
+// SetTorrentSchedule sets the weekly times the task downloads, in the lines
+// of AltRateSchedule or separated by ";", eg: "* 02:00-07:00". Out of them
+// it only uploads, empty downloads anytime
+func (e *Engine) SetTorrentSchedule(infohash, schedule string) error {
+	schedule = strings.TrimSpace(strings.ReplaceAll(schedule, ";", "\n"))
+	rules, err := parseSchedule(schedule)
+	if err != nil {
+		return err
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.DownloadSchedule = schedule
+	t.scheduleRules = rules
+	t.Unlock()
+	log.Printf("[Schedule] %s %q", infohash, schedule)
+	e.applyTaskSchedule(t, time.Now())
+	e.notifyChanged()
+	return nil
+}
+
+// applyTaskSchedule allows or disallows downloading the data of the task by
+// its schedule
+func (e *Engine) applyTaskSchedule(t *Torrent, now time.Time) {
+	t.Lock()
+	tt := t.t
+	blocked := t.DownloadSchedule != "" && !inSchedule(t.scheduleRules, now)
+	if tt == nil || blocked == t.ScheduleBlocked {
+		t.Unlock()
+		return
+	}
+	t.ScheduleBlocked = blocked
+	t.Unlock()
+
+	if blocked {
+		tt.DisallowDataDownload()
+	} else {
+		tt.AllowDataDownload()
+	}
+	log.Printf("[Schedule] %s downloading allowed: %v", t.InfoHash, !blocked)
+	e.notifyChanged()
+}
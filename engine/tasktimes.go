@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the ExpireAction of the tasks
+const (
+	// stopped at ExpireAt, the default
+	ExpireStop = "stop"
+	// removed at ExpireAt, with the data as by RemoveData
+	ExpireRemove = "remove"
+)
+
+// the StartAt and ExpireAt of the tasks are checked this often
+const taskTimesTick = 30 * time.Second
+
+// SetTorrentTimes sets the time the task starts at and the time it's stopped
+// or removed by the action at, a zero time clears it. A task to start later
+// isn't started meanwhile.
+func (e *Engine) SetTorrentTimes(infohash string, startAt, expireAt time.Time, action string) error {
+	action, err := checkExpireAction(action)
+	if err != nil {
+		return err
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	t.StartAt, t.ExpireAt, t.ExpireAction = startAt, expireAt, action
+	t.Unlock()
+	log.Printf("[TaskTimes] %s start %v expire %v %s", infohash, startAt, expireAt, action)
+	e.checkTaskTimes(time.Now())
+	return nil
+}
+
+// ParseTaskTime parses a time of SetTorrentTimes given as unix seconds or
+// RFC 3339, an empty string or 0 is the zero time
+func ParseTaskTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time (%s)", s)
+	}
+	return tm, nil
+}
+
+func checkExpireAction(action string) (string, error) {
+	switch action {
+	case "":
+		return ExpireStop, nil
+	case ExpireStop, ExpireRemove:
+		return action, nil
+	}
+	return "", fmt.Errorf("Invalid expire action (%s)", action)
+}
+
+// taskTimesRoutine starts and expires the tasks at their times
+func (e *Engine) taskTimesRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(taskTimesTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			e.checkTaskTimes(time.Now())
+		case <-closeSync:
+			return
+		}
+	}
+}
+
+func (e *Engine) checkTaskTimes(now time.Time) {
+	for ih, t := range e.Torrents() {
+		t.Lock()
+		start := !t.StartAt.IsZero() && !now.Before(t.StartAt)
+		if start {
+			t.StartAt = time.Time{}
+			if !t.Loaded {
+				// started once its info is loaded
+				t.resumeStarted, t.resumeStopped = true, false
+			}
+		}
+		startNow := start && t.Loaded && !t.Started
+		expire := !t.ExpireAt.IsZero() && !now.Before(t.ExpireAt)
+		action := t.ExpireAction
+		if expire {
+			t.ExpireAt = time.Time{}
+			if !t.Loaded {
+				t.resumeStarted, t.resumeStopped = false, true
+			}
+		}
+		started := t.Started
+		t.Unlock()
+
+		switch {
+		case expire && action == ExpireRemove:
+			log.Printf("[TaskTimes] %s expired, removed", ih)
+			e.setDeleteReason(ih, DeleteReasonExpired)
+			if err := e.RemoveTorrentData(ih, e.config.RemoveData); err != nil {
+				log.Warn("[TaskTimes] remove", ih, err)
+			}
+		case expire:
+			log.Printf("[TaskTimes] %s expired, stopped", ih)
+			if started {
+				if err := e.StopTorrent(ih); err != nil {
+					log.Warn("[TaskTimes] stop", ih, err)
+				}
+			}
+		case startNow:
+			log.Printf("[TaskTimes] %s started at schedule", ih)
+			if err := e.StartTorrent(ih); err != nil {
+				log.Warn("[TaskTimes] start", ih, err)
+			}
+		}
+	}
+}
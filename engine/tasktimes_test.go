@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTaskTime(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want time.Time
+		err  bool
+	}{
+		{"", time.Time{}, false},
+		{"0", time.Time{}, false},
+		{"1700000000", time.Unix(1700000000, 0), false},
+		{"2023-11-14T22:13:20Z", time.Unix(1700000000, 0), false},
+		{"tomorrow", time.Time{}, true},
+	} {
+		got, err := ParseTaskTime(tt.s)
+		if (err != nil) != tt.err || !got.Equal(tt.want) {
+			t.Errorf("ParseTaskTime(%q) = %v, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestCheckTaskTimes(t *testing.T) {
+	now := time.Now()
+	later := &Torrent{InfoHash: "a", StartAt: now.Add(time.Hour)}
+	due := &Torrent{InfoHash: "b", StartAt: now.Add(-time.Minute), ExpireAt: now.Add(time.Hour)}
+	expired := &Torrent{InfoHash: "c", ExpireAt: now.Add(-time.Minute), resumeStarted: true}
+	e := &Engine{ts: map[string]*Torrent{"a": later, "b": due, "c": expired}}
+	e.config.AutoStart = true
+
+	e.checkTaskTimes(now)
+	if later.StartAt.IsZero() || e.shouldStart(later) {
+		t.Error("task started before its time")
+	}
+	if !due.StartAt.IsZero() || !due.resumeStarted || !e.shouldStart(due) {
+		t.Error("task not started at its time")
+	}
+	if due.ExpireAt.IsZero() {
+		t.Error("task expired before its time")
+	}
+	if !expired.ExpireAt.IsZero() || e.shouldStart(expired) {
+		t.Error("task not stopped at its expiry")
+	}
+}
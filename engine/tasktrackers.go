@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var errNoTrackers = errors.New("no trackers given")
+
+// TorrentTrackers returns the announce tiers of the task
+func (e *Engine) TorrentTrackers(infohash string) ([][]string, error) {
+	tt, err := e.loadedTorrent(infohash)
+	if err != nil {
+		return nil, err
+	}
+	mi := tt.Metainfo()
+	return mi.UpvertedAnnounceList(), nil
+}
+
+// AddTorrentTrackers adds the tiers to the task, the new trackers are announced to at once
+func (e *Engine) AddTorrentTrackers(infohash string, tiers [][]string) error {
+	tiers = cleanTiers(tiers)
+	if len(tiers) == 0 {
+		return errNoTrackers
+	}
+	tt, err := e.loadedTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	tt.AddTrackers(tiers)
+	log.Printf("[AddTorrentTrackers] %s %v", infohash, tiers)
+	mi := tt.Metainfo()
+	return e.saveTorrentTrackers(infohash, mi.UpvertedAnnounceList())
+}
+
+// RemoveTorrentTrackers removes the trackers from the task, the empty tiers are dropped.
+// The task is reloaded as trackers can't be removed from a running torrent.
+func (e *Engine) RemoveTorrentTrackers(infohash string, trackers []string) error {
+	tiers, err := e.TorrentTrackers(infohash)
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]bool)
+	for _, tr := range trackers {
+		removed[tr] = true
+	}
+	var kept [][]string
+	for _, tier := range tiers {
+		var k []string
+		for _, tr := range tier {
+			if !removed[tr] {
+				k = append(k, tr)
+			}
+		}
+		kept = append(kept, k)
+	}
+	log.Printf("[RemoveTorrentTrackers] %s %v", infohash, trackers)
+	return e.SetTorrentTrackers(infohash, kept)
+}
+
+// SetTorrentTrackers replaces the tiers of the task, which is reloaded
+func (e *Engine) SetTorrentTrackers(infohash string, tiers [][]string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	tiers = cleanTiers(tiers)
+	if err := e.saveTorrentTrackers(infohash, tiers); err != nil {
+		return err
+	}
+	log.Printf("[SetTorrentTrackers] %s %d tiers", infohash, len(tiers))
+	return e.reloadTask(t, nil)
+}
+
+// PruneTorrentTrackers removes the unreachable trackers of the task,
+// returns the removed ones
+func (e *Engine) PruneTorrentTrackers(infohash string) ([]string, error) {
+	tiers, err := e.TorrentTrackers(infohash)
+	if err != nil {
+		return nil, err
+	}
+
+	c := e.config
+	p := newTrackerProber(&c)
+	var mu sync.Mutex
+	var dead []string
+	var wg sync.WaitGroup
+	for _, tier := range tiers {
+		for _, tr := range tier {
+			wg.Add(1)
+			go func(tr string) {
+				defer wg.Done()
+				if err := p.probe(tr); err != nil {
+					mu.Lock()
+					dead = append(dead, tr)
+					mu.Unlock()
+				}
+			}(tr)
+		}
+	}
+	wg.Wait()
+	if len(dead) == 0 {
+		return nil, nil
+	}
+	return dead, e.RemoveTorrentTrackers(infohash, dead)
+}
+
+// ReannounceTorrent reloads the task, so all the trackers and the DHT are
+// announced to again
+func (e *Engine) ReannounceTorrent(infohash string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	log.Println("[ReannounceTorrent]", infohash)
+	return e.reloadTask(t, nil)
+}
+
+// loadedTorrent returns the anacrolix torrent of the task with info loaded
+func (e *Engine) loadedTorrent(infohash string) (*torrent.Torrent, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	defer t.Unlock()
+	if !t.Loaded || t.t == nil {
+		return nil, errNotLoaded
+	}
+	return t.t, nil
+}
+
+// saveTorrentTrackers writes the tiers to the cached torrent file, so they're
+// kept after restarts
+func (e *Engine) saveTorrentTrackers(infohash string, tiers [][]string) error {
+	return e.rewriteTorrentCache(infohash, func(mi *metainfo.MetaInfo) {
+		mi.AnnounceList = tiers
+		mi.Announce = ""
+		if len(tiers) > 0 {
+			mi.Announce = tiers[0][0]
+		}
+	})
+}
+
+// rewriteTorrentCache changes the cached torrent file of the task by fn
+func (e *Engine) rewriteTorrentCache(infohash string, fn func(mi *metainfo.MetaInfo)) error {
+	name := e.TorrentCacheFileName(infohash)
+	mi, err := metainfo.LoadFromFile(name)
+	if err != nil {
+		return err
+	}
+	fn(mi)
+
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := mi.Write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// cleanTiers drops the empty and duplicated trackers and the empty tiers
+func cleanTiers(tiers [][]string) [][]string {
+	seen := make(map[string]bool)
+	var ret [][]string
+	for _, tier := range tiers {
+		var t []string
+		for _, tr := range tier {
+			if tr == "" || seen[tr] {
+				continue
+			}
+			seen[tr] = true
+			t = append(t, tr)
+		}
+		if len(t) > 0 {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// reloadTask drops the task and adds it back from the cached torrent file,
+// with fn called in between, eg: to move the data. The state of the task is kept,
+// fn may change it.
+func (e *Engine) reloadTask(t *Torrent, fn func(s *taskSession) error) error {
+	t.Lock()
+	ih, tt := t.InfoHash, t.t
+	s := t.session()
+	t.Unlock()
+	if tt == nil {
+		return errNotLoaded
+	}
+
+	e.Lock()
+	if e.ts[ih] != t {
+		// deleted meanwhile
+		e.Unlock()
+		return nil
+	}
+	close(t.dropWait)
+	e.deleteTorrent(ih)
+	e.Unlock()
+	select {
+	case <-tt.Closed():
+	case <-time.After(time.Minute):
+	}
+
+	var err error
+	if fn != nil {
+		err = fn(&s)
+	}
+	e.sessions.Lock()
+	if e.sessions.m != nil {
+		e.sessions.m[ih] = s
+		e.sessions.dirty = true
+	}
+	e.sessions.Unlock()
+
+	if addErr := e.NewTorrentByFilePath(e.TorrentCacheFileName(ih), ""); addErr != nil && !errors.Is(addErr, ErrMaxConnTasks) {
+		return addErr
+	}
+	return err
+}
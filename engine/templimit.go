@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var errLimitExpired = errors.New("the time of the limit has passed")
+
+// TempRateLimit is a client wide rate limit in effect until Until, then the
+// UploadRate/DownloadRate of the config are restored. Empty rates keep the config.
+type TempRateLimit struct {
+	sync.Mutex
+	UploadRate   string
+	DownloadRate string
+	Until        time.Time
+	timer        *time.Timer
+}
+
+// TempRateLimit is the current temporary limit, zero Until if none
+func (e *Engine) TempRateLimit() *TempRateLimit {
+	return &e.tempLimit
+}
+
+// SetTempRateLimit limits the client rates until the time, eg: for a video call
+func (e *Engine) SetTempRateLimit(upload, download string, until time.Time) error {
+	for _, r := range []string{upload, download} {
+		if r == "" {
+			continue
+		}
+		if _, err := rateLimiter(r); err != nil {
+			return err
+		}
+	}
+	d := time.Until(until)
+	if d <= 0 {
+		return errLimitExpired
+	}
+
+	l := &e.tempLimit
+	l.Lock()
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.UploadRate = upload
+	l.DownloadRate = download
+	l.Until = until
+	l.timer = time.AfterFunc(d, e.ClearTempRateLimit)
+	l.Unlock()
+	log.Printf("[TempRateLimit] upload %q download %q until %s", upload, download, until.Format(time.RFC3339))
+
+	e.applyRateLimits()
+	return nil
+}
+
+// ClearTempRateLimit restores the rates of the config
+func (e *Engine) ClearTempRateLimit() {
+	l := &e.tempLimit
+	l.Lock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	active := !l.Until.IsZero()
+	l.UploadRate = ""
+	l.DownloadRate = ""
+	l.Until = time.Time{}
+	l.Unlock()
+	if !active {
+		return
+	}
+	log.Println("[TempRateLimit] restored the configured rates")
+
+	e.applyRateLimits()
+	e.notifyChanged()
+}
+
+// applyRateLimits sets the client limiters by the temporary limit, the alternative
+// rates or the config, in the order of precedence
+func (e *Engine) applyRateLimits() {
+	e.RLock()
+	upload, download := e.config.UploadRate, e.config.DownloadRate
+	altUpload, altDownload := e.config.AltUploadRate, e.config.AltDownloadRate
+	schedule := e.config.AltRateSchedule
+	ul, dl := e.uploadLimiter, e.downloadLimiter
+	e.RUnlock()
+
+	if e.altRateActive(schedule, time.Now()) {
+		if altUpload != "" {
+			upload = altUpload
+		}
+		if altDownload != "" {
+			download = altDownload
+		}
+	}
+
+	l := &e.tempLimit
+	l.Lock()
+	if !l.Until.IsZero() {
+		if l.UploadRate != "" {
+			upload = l.UploadRate
+		}
+		if l.DownloadRate != "" {
+			download = l.DownloadRate
+		}
+	}
+	l.Unlock()
+
+	setClientLimiter(ul, upload)
+	setClientLimiter(dl, download)
+}
+
+func setClientLimiter(l *rate.Limiter, rstr string) {
+	if l == nil {
+		return
+	}
+	if err := setLimiter(l, rstr); err != nil {
+		// same as the config, unrecognized rates are unlimited
+		l.SetLimit(rate.Inf)
+	}
+}
+
+// ParseUntil parses the end of a temporary limit, either a clock time "HH:MM"
+// for the next occurrence of it, or a duration from now like "1h30m"
+func ParseUntil(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, errLimitExpired
+		}
+		return now.Add(d), nil
+	}
+	c, err := time.Parse("15:04", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expecting HH:MM or a duration", s)
+	}
+	until := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, now.Location())
+	if !until.After(now) {
+		until = until.AddDate(0, 0, 1)
+	}
+	return until, nil
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2021, 7, 1, 17, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{"clock", "18:30", time.Date(2021, 7, 1, 18, 30, 0, 0, time.Local), false},
+		{"tomorrow", "08:00", time.Date(2021, 7, 2, 8, 0, 0, 0, time.Local), false},
+		{"now", "17:00", time.Date(2021, 7, 2, 17, 0, 0, 0, time.Local), false},
+		{"duration", " 1h30m", now.Add(90 * time.Minute), false},
+		{"negative", "-1h", time.Time{}, true},
+		{"invalid", "soon", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUntil(tt.s, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseUntil() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseUntil() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventHook records a DoneCmd or webhook run, only kept in the timeline
+const EventHook = "hook"
+
+// max events kept per task
+const timelineMax = 200
+
+// timelineMap keeps the events of every task since the start, dropped with
+// the task. The deleted tasks are looked up in the task history
+type timelineMap struct {
+	sync.Mutex
+	m map[string][]Event
+}
+
+func (e *Engine) record(ev Event) {
+	e.timelines.Lock()
+	defer e.timelines.Unlock()
+	tl, ok := e.timelines.m[ev.InfoHash]
+	// a hook finishing after the task got deleted
+	if !ok && ev.Type == EventHook {
+		return
+	}
+	tl = append(tl, ev)
+	if len(tl) > timelineMax {
+		tl = tl[len(tl)-timelineMax:]
+	}
+	e.timelines.m[ev.InfoHash] = tl
+}
+
+func (e *Engine) recordHook(ih, name, hook string, err error) {
+	ev := Event{
+		Type:     EventHook,
+		InfoHash: ih,
+		Name:     name,
+		Message:  hook + ": ok",
+		Time:     time.Now(),
+	}
+	if err != nil {
+		ev.Message = fmt.Sprintf("%s: %v", hook, err)
+		ev.Error = err.Error()
+	}
+	e.record(ev)
+}
+
+// dropTimeline forgets the events of a deleted task
+func (e *Engine) dropTimeline(ih string) {
+	e.timelines.Lock()
+	delete(e.timelines.m, ih)
+	e.timelines.Unlock()
+}
+
+// Timeline returns the recorded events of the task, oldest first
+func (e *Engine) Timeline(infohash string) []Event {
+	e.timelines.Lock()
+	defer e.timelines.Unlock()
+	tl := e.timelines.m[infohash]
+	ret := make([]Event, len(tl))
+	copy(ret, tl)
+	return ret
+}
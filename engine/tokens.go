@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopes of the API tokens
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "readwrite"
+)
+
+// tokenPrefix tells the tokens apart in the configs and the logs
+const tokenPrefix = "st_"
+
+var errTokenNotFound = errors.New("token not found")
+
+// APIToken is a long-lived token of the API for the scripts, given as
+// "Authorization: Bearer <token>". Only the hash of the secret is kept.
+type APIToken struct {
+	ID    string
+	Name  string
+	Scope string
+	// the user created it, empty for the single --auth account
+	Owner     string `json:",omitempty"`
+	Hash      string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// TokenStore keeps the API tokens in a JSON file
+type TokenStore struct {
+	mu     sync.RWMutex
+	path   string
+	tokens map[string]*APIToken
+}
+
+// OpenTokenStore reads the tokens of the file, missing is empty
+func OpenTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{path: path, tokens: make(map[string]*APIToken)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*APIToken
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("tokens file %s: %w", path, err)
+	}
+	for _, t := range list {
+		s.tokens[t.ID] = t
+	}
+	return s, nil
+}
+
+// Create adds a token of the owner, the secret returned is never shown again
+func (s *TokenStore) Create(name, owner, scope string) (string, APIToken, error) {
+	if scope != ScopeRead && scope != ScopeReadWrite {
+		return "", APIToken{}, fmt.Errorf("invalid scope %q, expecting read or readwrite", scope)
+	}
+	buf := make([]byte, 36)
+	if _, err := rand.Read(buf); err != nil {
+		return "", APIToken{}, err
+	}
+	id := hex.EncodeToString(buf[:4])
+	secret := tokenPrefix + id + "_" + hex.EncodeToString(buf[4:])
+	sum := sha256.Sum256([]byte(secret))
+	t := &APIToken{
+		ID:        id,
+		Name:      strings.TrimSpace(name),
+		Scope:     scope,
+		Owner:     owner,
+		Hash:      hex.EncodeToString(sum[:]),
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[id]; ok {
+		return "", APIToken{}, errors.New("token id taken, try again")
+	}
+	s.tokens[id] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return "", APIToken{}, err
+	}
+	return secret, stripTokenHash(t), nil
+}
+
+// Authenticate returns the token of the secret
+func (s *TokenStore) Authenticate(secret string) (APIToken, bool) {
+	if s == nil || !strings.HasPrefix(secret, tokenPrefix) {
+		return APIToken{}, false
+	}
+	id := strings.SplitN(strings.TrimPrefix(secret, tokenPrefix), "_", 2)[0]
+	s.mu.RLock()
+	t, ok := s.tokens[id]
+	s.mu.RUnlock()
+	if !ok {
+		return APIToken{}, false
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(t.Hash)) != 1 {
+		return APIToken{}, false
+	}
+	return stripTokenHash(t), true
+}
+
+// List returns the tokens of the owner by creation, all of them when all
+func (s *TokenStore) List(owner string, all bool) []APIToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		if all || t.Owner == owner {
+			list = append(list, stripTokenHash(t))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Revoke removes the token of the owner, of anyone when all
+func (s *TokenStore) Revoke(id, owner string, all bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || (!all && t.Owner != owner) {
+		return errTokenNotFound
+	}
+	delete(s.tokens, id)
+	return s.save()
+}
+
+// RevokeOwner removes the tokens of the owner, as the user is deleted
+func (s *TokenStore) RevokeOwner(owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.tokens)
+	for id, t := range s.tokens {
+		if t.Owner == owner {
+			delete(s.tokens, id)
+		}
+	}
+	if n == len(s.tokens) {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes the tokens to a temp file renamed over the file, must hold lock
+func (s *TokenStore) save() error {
+	list := make([]*APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func stripTokenHash(t *APIToken) APIToken {
+	c := *t
+	c.Hash = ""
+	return c
+}
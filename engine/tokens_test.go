@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "tokens.json")
+	s, err := OpenTokenStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Create("sonarr", "bob", "write"); err == nil {
+		t.Error("Create() invalid scope, expecting error")
+	}
+	secret, tok, err := s.Create("sonarr", "bob", ScopeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Hash != "" {
+		t.Error("Create() returned the hash")
+	}
+	if _, _, err := s.Create("grafana", "eve", ScopeRead); err != nil {
+		t.Fatal(err)
+	}
+
+	// read back from the file
+	if s, err = OpenTokenStore(p); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Authenticate(secret); !ok || got.ID != tok.ID || got.Owner != "bob" || got.Scope != ScopeReadWrite {
+		t.Errorf("Authenticate() = %+v %v", got, ok)
+	}
+	for _, bad := range []string{"", secret[:len(secret)-1] + "x", "st_" + tok.ID, "bearer"} {
+		if _, ok := s.Authenticate(bad); ok {
+			t.Errorf("Authenticate(%q) accepted", bad)
+		}
+	}
+	if n := len(s.List("bob", false)); n != 1 {
+		t.Errorf("List(bob) = %d tokens", n)
+	}
+	if n := len(s.List("", true)); n != 2 {
+		t.Errorf("List(all) = %d tokens", n)
+	}
+
+	if err := s.Revoke(tok.ID, "eve", false); err != errTokenNotFound {
+		t.Errorf("Revoke() of another owner = %v", err)
+	}
+	if err := s.Revoke(tok.ID, "bob", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Authenticate(secret); ok {
+		t.Error("Authenticate() revoked token")
+	}
+	if err := s.RevokeOwner("eve"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.List("", true)); n != 0 {
+		t.Errorf("List(all) after revoking = %d tokens", n)
+	}
+}
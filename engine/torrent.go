@@ -2,8 +2,8 @@ package engine
 
 import (
 	"fmt"
-	"os/exec"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/anacrolix/torrent"
@@ -22,6 +22,121 @@ type Torrent struct {
 	Size       int64
 	Files      []*File
 
+	//per torrent rate limits
+	UploadRateLimit   string
+	DownloadRateLimit string
+	//the rates set by SetTorrentRateLimit, not the defaults
+	rateLimitSet bool
+	//per torrent connections and upload slots, see SetTorrentConnLimits
+	ConnLimits ConnLimits
+
+	//info not got within MetadataTimeout after all retries
+	MetadataTimeout bool
+	MetadataRetries int
+	//why it's not downloading, see TaskError
+	ErrorState *TaskError
+
+	//where the connected peers were discovered
+	PeerSources PeerSources
+
+	//seeders and leechers in the swarm, the most of the trackers scraped
+	//at ScrapedAt, see scrapeRoutine
+	SwarmSeeders  int
+	SwarmLeechers int
+	ScrapedAt     time.Time
+
+	//BEP 19 web seeds with their transfer, see webseedRoutine
+	WebSeeds []WebSeedStatus
+
+	//download directory of the task, empty for DownloadDirectory
+	DownloadDir string
+
+	//set by LabelRules when added or by SetTorrentLabel
+	Label string
+	Tags  []string
+
+	//the group of tasks it's in, set by SetTorrentCollection
+	Collection string
+
+	//order in the wait list, higher first
+	QueuePriority int
+
+	//the user added it, empty without the users
+	AddedBy string
+
+	//overrides the global SeedRatio, MaxSeedTime and MaxIdleTime
+	SeedLimits SeedLimits
+
+	//weekly times it downloads, see SetTorrentSchedule
+	DownloadSchedule string
+	//out of the schedule, only uploading
+	ScheduleBlocked bool
+	//encryption policy of the peer connections, see SetTorrentEncryption
+	Encryption string
+	//serving a piece at a time to each peer, see SetTorrentSuperSeed
+	SuperSeed bool
+	//BEP 27 private, with TrackersOnly by PrivateTrackersOnly: no public
+	//trackers added and the peers of the DHT and PEX refused
+	Private      bool
+	TrackersOnly bool
+
+	//started at StartAt, stopped or removed by ExpireAction at ExpireAt,
+	//see SetTorrentTimes
+	StartAt      time.Time
+	ExpireAt     time.Time
+	ExpireAction string
+
+	//public trackers added to a magnet, removed if it's private
+	injectedTrackers []string
+
+	//state restored from the last session
+	restored       bool
+	resumeStarted  bool
+	resumeStopped  bool
+	prevDownloaded int64
+	prevUploaded   int64
+	//by file index, set when adding or by the user
+	filePriorities FilePriorities
+	//parsed DownloadSchedule
+	scheduleRules []scheduleRule
+	//the name of the data renamed by the user, see RenameTorrent
+	storageName string
+	//byte ranges wanted by a time, set by the players
+	deadlines []*pieceDeadline
+
+	//upload watched for reannouncing stalled seeds
+	seedIdleSince    time.Time
+	seedUploaded     int64
+	reannounceOffset int
+
+	//upload watched for MaxIdleTime
+	idleSince    time.Time
+	idleUploaded int64
+
+	//alerts sent for AlertUploadTotal and AlertMinSpeed
+	uploadAlerted bool
+	slowSince     time.Time
+	slowAlerted   bool
+
+	//hashing progress and result of the last verification
+	Verifying       bool
+	VerifyPercent   float32
+	VerifiedAt      time.Time
+	VerifyBadPieces int
+
+	//progress and errors of extracting the archives once completed
+	Extracting     bool
+	ExtractPercent float32
+	ExtractError   string
+	ExtractedAt    time.Time
+
+	//progress and errors of the upload to UploadRemote once completed
+	RemoteUploading     bool
+	RemoteUploadPercent float32
+	RemoteUploadSpeed   float32
+	RemoteUploadError   string
+	RemoteUploadedAt    time.Time
+
 	//cloud torrent
 	Stats          *torrent.TorrentStats
 	Started        bool
@@ -34,16 +149,26 @@ type Torrent struct {
 	Percent        float32
 	DownloadRate   float32
 	UploadRate     float32
-	SeedRatio      float32
-	AddedAt        time.Time
-	StartedAt      time.Time
-	FinishedAt     time.Time
-	StoppedAt      time.Time
-	updatedAt      time.Time
-	t              *torrent.Torrent
-	e              *Engine
-	dropWait       chan struct{}
-	cld            Server
+	//the rates smoothed, see updateSpeeds
+	DownloadSpeed float32
+	UploadSpeed   float32
+	//seconds to download the wanted files at DownloadSpeed, -1 never, 0 done
+	ETA        int64
+	SeedRatio  float32
+	AddedAt    time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	StoppedAt  time.Time
+	updatedAt  time.Time
+	t          *torrent.Torrent
+	// t not uploading by EnableUpload or EnableSeeding, see applyUpload
+	uploadDisallowed *torrent.Torrent
+	e                *Engine
+	dropWait         chan struct{}
+	cld              Server
+	// the connection limit set to connLimitT, see applyConnLimit
+	connLimit  int
+	connLimitT *torrent.Torrent
 }
 
 type File struct {
@@ -53,10 +178,47 @@ type File struct {
 	Completed     int64
 	Done          bool
 	DoneCmdCalled bool
+	//bytes written from peers and read for peers (and streaming), counted at the storage
+	Downloaded int64
+	Uploaded   int64
 	//cloud torrent
 	Started bool
-	Percent float32
-	f       *torrent.File
+	//skip, low, normal or high
+	Priority string
+	Percent  float32
+	f        *torrent.File
+}
+
+// PeerSources counts the connected peers by discovery mechanism
+type PeerSources struct {
+	Tracker  int
+	DHT      int
+	PEX      int
+	LSD      int
+	Incoming int
+	// direct peers from magnet x.pe or added manually
+	Direct int
+}
+
+func countPeerSources(t *torrent.Torrent) PeerSources {
+	var ps PeerSources
+	for _, pc := range t.PeerConns() {
+		switch pc.Discovery {
+		case torrent.PeerSourceTracker:
+			ps.Tracker++
+		case torrent.PeerSourceDhtGetPeers, torrent.PeerSourceDhtAnnouncePeer:
+			ps.DHT++
+		case torrent.PeerSourcePex:
+			ps.PEX++
+		case peerSourceLSD:
+			ps.LSD++
+		case torrent.PeerSourceIncoming:
+			ps.Incoming++
+		default:
+			ps.Direct++
+		}
+	}
+	return ps
 }
 
 // Update retrive info from torrent.Torrent
@@ -65,6 +227,9 @@ func (torrent *Torrent) updateOnGotInfo(t *torrent.Torrent) {
 	if t.Info() != nil && !torrent.Loaded {
 		torrent.t = t
 		torrent.Name = t.Name()
+		if torrent.storageName != "" {
+			torrent.Name = torrent.storageName
+		}
 		torrent.Loaded = true
 		torrent.updateFileStatus()
 		torrent.updateTorrentStatus()
@@ -78,7 +243,6 @@ func (torrent *Torrent) updateOnGotInfo(t *torrent.Torrent) {
 			} else {
 				torrent.Magnet = "ERROR{}"
 			}
-			torrent.Name = t.Name()
 		}
 	}
 }
@@ -87,6 +251,7 @@ func (torrent *Torrent) updateConnStat() {
 	now := time.Now()
 	lastStat := torrent.Stats
 	curStat := torrent.t.Stats()
+	torrent.PeerSources = countPeerSources(torrent.t)
 
 	if lastStat == nil {
 		torrent.updatedAt = now
@@ -103,11 +268,13 @@ func (torrent *Torrent) updateConnStat() {
 	// download will stop if torrent is done (bRead equals)
 	if bRead >= lRead || bWrite > lWrite {
 
-		// calculate ratio
-		if bRead > 0 {
-			torrent.SeedRatio = float32(bWrite) / float32(bRead)
+		// calculate ratio, including the bytes of the previous sessions
+		tRead := bRead + torrent.prevDownloaded
+		tWrite := bWrite + torrent.prevUploaded
+		if tRead > 0 {
+			torrent.SeedRatio = float32(tWrite) / float32(tRead)
 		} else if torrent.Done {
-			torrent.SeedRatio = float32(bWrite) / float32(torrent.Size)
+			torrent.SeedRatio = float32(tWrite) / float32(torrent.Size)
 		}
 
 		if lastStat != nil {
@@ -119,16 +286,31 @@ func (torrent *Torrent) updateConnStat() {
 
 			uldb := float32(bWrite - lWrite)
 			torrent.UploadRate = uldb * dtinv
+			torrent.updateSpeeds(now.Sub(torrent.updatedAt))
 		}
 
 		torrent.Downloaded = torrent.t.BytesCompleted()
-		torrent.Uploaded = bWrite
+		torrent.Uploaded = tWrite
 		torrent.updatedAt = now
 		torrent.Stats = &curStat
 	}
 }
 
+// updateFileCounters keeps going when the files are done, for the uploaded bytes
+func (torrent *Torrent) updateFileCounters() {
+	counter := torrent.e.loadFileCounter(torrent.InfoHash)
+	if counter == nil {
+		return
+	}
+	for i, file := range torrent.Files {
+		if file != nil {
+			file.Downloaded, file.Uploaded = counter.get(i)
+		}
+	}
+}
+
 func (torrent *Torrent) updateFileStatus() {
+	torrent.updateFileCounters()
 	if torrent.IsAllFilesDone {
 		return
 	}
@@ -140,11 +322,13 @@ func (torrent *Torrent) updateFileStatus() {
 
 	//merge in files
 	doneFlag := true
+	infoName := torrent.t.Info().Name
 	for i, f := range tfiles {
-		path := f.Path()
+		path := renamedPath(f.Path(), infoName, torrent.storageName)
 		file := torrent.Files[i]
 		if file == nil {
-			file = &File{Path: path, Started: torrent.Started, f: f}
+			prio := torrent.filePriorities.of(i)
+			file = &File{Path: path, Started: torrent.Started && prio != FilePrioritySkip, Priority: prio, f: f}
 			torrent.Files[i] = file
 		}
 
@@ -167,15 +351,35 @@ func (torrent *Torrent) updateFileStatus() {
 func (torrent *Torrent) updateTorrentStatus() {
 	torrent.Size = torrent.t.Length()
 	torrent.Percent = percent(torrent.t.BytesCompleted(), torrent.Size)
-	torrent.Done = (torrent.t.BytesMissing() == 0)
+	// or the files not skipped are
+	torrent.Done = (torrent.t.BytesMissing() == 0) || torrent.wantedDone()
 	torrent.IsSeeding = torrent.t.Seeding() && torrent.Done
 
 	// this process called at least on second Update calls
 	if torrent.Done && !torrent.DoneCmdCalled {
 		torrent.DoneCmdCalled = true
-		torrent.FinishedAt = time.Now()
+		// kept if restored from the last session
+		newlyDone := torrent.FinishedAt.IsZero()
+		if newlyDone {
+			torrent.FinishedAt = time.Now()
+		}
 		log.Println("[TaskFinished]", torrent.InfoHash)
+		torrent.e.emit(EventCompleted, torrent, nil)
 		go torrent.callDoneCmd(torrent.Name, "torrent", torrent.Size)
+		go func() {
+			torrent.e.moveCompleted(torrent)
+			if newlyDone {
+				// reloaded if moved
+				torrent.e.extractArchives(torrent.InfoHash)
+			}
+			torrent.e.refreshLibraries(torrent)
+			if newlyDone {
+				// may remove the task
+				torrent.e.uploadCompleted(torrent.InfoHash)
+			}
+		}()
+		// a download slot of MaxActiveDownloads is free
+		go torrent.e.NextWaitTask() // nolint: errcheck
 	}
 }
 
@@ -186,42 +390,38 @@ func percent(n, total int64) float32 {
 	return float32(int(float64(10000)*(float64(n)/float64(total)))) / 100
 }
 
+// callDoneCmd queues the DoneCmd of the task or one of its files, run by the
+// hook queue with the timeout and the retries of the Hooks
 func (t *Torrent) callDoneCmd(name, tasktype string, size int64) {
-
-	if cmd, env, err := t.e.config.GetCmdConfig(); err == nil {
-		cmd := exec.Command(cmd)
-		ih := t.InfoHash
-		cmd.Env = append(env,
-			fmt.Sprintf("CLD_RESTAPI=%s", t.cld.GetStrAttribute("RestAPI")),
-			fmt.Sprintf("CLD_PATH=%s", name),
-			fmt.Sprintf("CLD_HASH=%s", ih),
-			fmt.Sprintf("CLD_TYPE=%s", tasktype),
-			fmt.Sprintf("CLD_SIZE=%d", size),
-			fmt.Sprintf("CLD_STARTTS=%d", t.StartedAt.Unix()),
-			fmt.Sprintf("CLD_FILENUM=%d", len(t.Files)),
-		)
-		sout, _ := cmd.StdoutPipe()
-		serr, _ := cmd.StderrPipe()
-		log.Printf("[DoneCmd:%s]%sCMD:`%s' ENV:%s", tasktype, ih, cmd.String(), cmd.Env)
-		if err := cmd.Start(); err != nil {
-			log.Printf("[DoneCmd:%s]%sERR: %v", tasktype, ih, err)
-			return
-		}
-
-		var wg sync.WaitGroup
-		wg.Add(2)
-		go cmdScanLine(sout, &wg, fmt.Sprintf("[DoneCmd:%s]%sO:", log.filteredArg(tasktype, ih)...))
-		go cmdScanLine(serr, &wg, fmt.Sprintf("[DoneCmd:%s]%sE:", log.filteredArg(tasktype, ih)...))
-		wg.Wait()
-
-		// call Wait will close pipes above
-		if err := cmd.Wait(); err != nil {
-			log.Printf("[DoneCmd:%s]%sERR: %v", tasktype, ih, err)
-			return
-		}
-
-		log.Printf("[DoneCmd:%s]%sExit code: %d", tasktype, ih, cmd.ProcessState.ExitCode())
-	} else {
-		log.Println("[DoneCmd]", t.InfoHash, err)
+	cmd, env, err := t.e.config.GetCmdConfig()
+	if err != nil {
+		log.Warn("[DoneCmd]", t.InfoHash, err)
+		return
 	}
+	ih := t.InfoHash
+	env = append(env,
+		fmt.Sprintf("CLD_RESTAPI=%s", t.cld.GetStrAttribute("RestAPI")),
+		fmt.Sprintf("CLD_PATH=%s", name),
+		fmt.Sprintf("CLD_HASH=%s", ih),
+		fmt.Sprintf("CLD_TYPE=%s", tasktype),
+		fmt.Sprintf("CLD_SIZE=%d", size),
+		fmt.Sprintf("CLD_STARTTS=%d", t.StartedAt.Unix()),
+		fmt.Sprintf("CLD_FILENUM=%d", len(t.Files)),
+	)
+	if t.DownloadDir != "" {
+		// the later one takes effect
+		env = append(env, fmt.Sprintf("CLD_DIR=%s", t.DownloadDir))
+	}
+	if t.Label != "" {
+		env = append(env, fmt.Sprintf("CLD_LABEL=%s", t.Label))
+	}
+	log.Printf("[DoneCmd:%s]%sCMD:`%s' ENV:%s", tasktype, ih, cmd, env)
+	ev := Event{Type: EventCompleted, InfoHash: ih, Name: name}
+	t.e.queueHook("DoneCmd "+tasktype, ev, []string{cmd}, env, func(err error) {
+		if err != nil {
+			atomic.AddUint64(&t.e.doneCmdFailures, 1)
+			t.e.emit(EventError, t, fmt.Errorf("DoneCmd: %w", err))
+		}
+		t.e.recordHook(ih, name, "DoneCmd "+tasktype, err)
+	})
 }
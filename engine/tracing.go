@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/boypt/simple-torrent/common/trace"
+)
+
+// applyTraceConfig sets the slow threshold and the exporter of the traces
+func (e *Engine) applyTraceConfig(c *Config) {
+	trace.Configure(trace.Options{
+		SlowThreshold: c.SlowOpThreshold,
+		Endpoint:      c.TraceEndpoint,
+	})
+}
+
+// checkTraceEndpoint accepts an empty or an http(s) TraceEndpoint
+func (c *Config) checkTraceEndpoint() error {
+	if c.TraceEndpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(c.TraceEndpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid TraceEndpoint %q, eg: http://localhost:4318/v1/traces", c.TraceEndpoint)
+	}
+	return nil
+}
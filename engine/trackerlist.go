@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the TrackerListRefresh is checked this often, a change applies without
+// restarting the routine
+const trackerListTick = time.Minute
+
+// TrackerListSource is a remote: or file: line of TrackerList with the
+// trackers it gave on the last refresh
+type TrackerListSource struct {
+	Source   string
+	Trackers int
+	Error    string `json:",omitempty"`
+}
+
+// TrackerListStatus is the tracker list added to the new tasks
+type TrackerListStatus struct {
+	Trackers    []string
+	Sources     []TrackerListSource
+	RefreshedAt time.Time
+	// zero without TrackerListRefresh
+	NextRefresh time.Time `json:",omitempty"`
+	Refreshing  bool
+}
+
+type trackerListState struct {
+	sync.Mutex
+	sources     []TrackerListSource
+	refreshedAt time.Time
+	refreshing  bool
+	// bumped on every load, a verify of an older list is discarded
+	gen uint64
+}
+
+// ParseTrackerList loads the trackers of TrackerList: the trackers, the
+// lists at the http(s) URLs of the remote: lines and in the files of the
+// file: lines, one per line
+func (e *Engine) ParseTrackerList() error {
+	return e.loadTrackerList(false)
+}
+
+// RefreshTrackerList fetches the remote lists again, revalidated by their
+// ETag, and reads the files again
+func (e *Engine) RefreshTrackerList() error {
+	return e.loadTrackerList(true)
+}
+
+func (e *Engine) loadTrackerList(revalidate bool) error {
+	e.trackerList.Lock()
+	if e.trackerList.refreshing {
+		e.trackerList.Unlock()
+		return nil
+	}
+	e.trackerList.refreshing = true
+	e.trackerList.Unlock()
+
+	e.RLock()
+	c := e.config
+	cache := e.httpCache
+	e.RUnlock()
+
+	trackers := []string{}
+	var sources []TrackerListSource
+	for _, l := range strings.Split(c.TrackerList, "\n") {
+		line := strings.TrimSpace(l)
+		if line == "" {
+			continue
+		}
+
+		var lst []string
+		var err error
+		switch {
+		case strings.HasPrefix(line, "remote:"):
+			lst, err = fetchTxtList(cache, line[7:], revalidate)
+		case strings.HasPrefix(line, "file:"):
+			var body []byte
+			if body, err = ioutil.ReadFile(line[5:]); err == nil {
+				lst = txtLines(body)
+			}
+		default:
+			trackers = append(trackers, line)
+			continue
+		}
+		src := TrackerListSource{Source: line, Trackers: len(lst)}
+		if err != nil {
+			log.Warn("[ParseTrackerList] ignored", err, line)
+			src.Error = err.Error()
+		}
+		sources = append(sources, src)
+		trackers = append(trackers, lst...)
+	}
+
+	// remove duplicated entries
+	dupMap := make(map[string]struct{})
+	uniq := []string{}
+	for _, t := range trackers {
+		if _, ok := dupMap[t]; !ok {
+			dupMap[t] = struct{}{}
+			uniq = append(uniq, t)
+		}
+	}
+	e.trackerList.Lock()
+	e.trackerList.gen++
+	gen := e.trackerList.gen
+	e.Lock()
+	e.Trackers = uniq
+	e.Unlock()
+	e.trackerList.sources = sources
+	e.trackerList.refreshedAt = time.Now()
+	e.trackerList.refreshing = false
+	e.trackerList.Unlock()
+
+	if c.TrackerFallback {
+		// probing takes a while, the list is replaced when done
+		go func() {
+			e.setVerifiedTrackers(gen, verifyTrackers(&c, uniq))
+		}()
+	}
+
+	log.Printf("[ParseTrackerList] got %d trackers", len(uniq))
+	return nil
+}
+
+// setVerifiedTrackers replaces the list with its verified one, unless the
+// list got loaded again while probing
+func (e *Engine) setVerifiedTrackers(gen uint64, verified []string) bool {
+	e.trackerList.Lock()
+	defer e.trackerList.Unlock()
+	if gen != e.trackerList.gen {
+		log.Debugf("[verifyTrackers] discarded the result of a replaced list")
+		return false
+	}
+	e.Lock()
+	e.Trackers = verified
+	e.Unlock()
+	return true
+}
+
+// TrackerListStatus returns the trackers and the state of their sources
+func (e *Engine) TrackerListStatus() TrackerListStatus {
+	e.RLock()
+	s := TrackerListStatus{Trackers: append([]string{}, e.Trackers...)}
+	refresh := e.config.TrackerListRefresh
+	e.RUnlock()
+	e.trackerList.Lock()
+	defer e.trackerList.Unlock()
+	s.Sources = append([]TrackerListSource{}, e.trackerList.sources...)
+	s.RefreshedAt = e.trackerList.refreshedAt
+	s.Refreshing = e.trackerList.refreshing
+	if refresh > 0 && !s.RefreshedAt.IsZero() {
+		s.NextRefresh = s.RefreshedAt.Add(refresh)
+	}
+	return s
+}
+
+// trackerListRoutine refreshes the tracker list every TrackerListRefresh
+func (e *Engine) trackerListRoutine(closeSync chan struct{}) {
+	tk := time.NewTicker(trackerListTick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			if next := e.TrackerListStatus().NextRefresh; !next.IsZero() && time.Now().After(next) {
+				e.RefreshTrackerList() // nolint: errcheck
+			}
+		case <-closeSync:
+			return
+		}
+	}
+}
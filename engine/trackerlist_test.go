@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+func TestParseTrackerList(t *testing.T) {
+	dir := t.TempDir()
+	var fetches, notModified int32
+	list := "udp://a.example:80/announce\n\nhttp://b.example/announce\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(list))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(dir, "trackers.txt")
+	if err := ioutil.WriteFile(file, []byte("udp://c.example:80/announce\nhttp://b.example/announce\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{
+		config: Config{
+			TrackerList:        "udp://x.example:80/announce\nremote:" + srv.URL + "\nfile:" + file + "\nfile:" + filepath.Join(dir, "missing"),
+			TrackerListRefresh: time.Hour,
+		},
+		httpCache: common.NewHTTPCache(filepath.Join(dir, "cache"), time.Hour),
+	}
+	if err := e.ParseTrackerList(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"udp://x.example:80/announce", "udp://a.example:80/announce",
+		"http://b.example/announce", "udp://c.example:80/announce"}
+	st := e.TrackerListStatus()
+	if !reflect.DeepEqual(st.Trackers, want) {
+		t.Fatalf("trackers = %v, want %v", st.Trackers, want)
+	}
+	if len(st.Sources) != 3 || st.Sources[0].Trackers != 2 || st.Sources[1].Trackers != 2 || st.Sources[2].Error == "" {
+		t.Errorf("sources = %+v", st.Sources)
+	}
+	if st.NextRefresh.Sub(st.RefreshedAt) != time.Hour {
+		t.Errorf("next refresh at %v, refreshed at %v", st.NextRefresh, st.RefreshedAt)
+	}
+
+	// within MinInterval the cache answers, a refresh revalidates
+	if err := e.ParseTrackerList(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+	if err := e.RefreshTrackerList(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&notModified); n != 1 {
+		t.Errorf("revalidated %d times, want 1", n)
+	}
+	if st := e.TrackerListStatus(); !reflect.DeepEqual(st.Trackers, want) {
+		t.Errorf("trackers after refresh = %v, want %v", st.Trackers, want)
+	}
+}
+
+func TestVerifiedTrackersStale(t *testing.T) {
+	e := &Engine{config: Config{TrackerList: "udp://a.example:80/announce"}}
+	if err := e.ParseTrackerList(); err != nil {
+		t.Fatal(err)
+	}
+	stale := e.trackerList.gen
+	e.config.TrackerList = "http://b.example/announce"
+	if err := e.ParseTrackerList(); err != nil {
+		t.Fatal(err)
+	}
+	// the verify of the first list ends after the second load
+	if e.setVerifiedTrackers(stale, []string{"http://a.example/announce"}) {
+		t.Error("stale verify result applied")
+	}
+	if !reflect.DeepEqual(e.Trackers, []string{"http://b.example/announce"}) {
+		t.Errorf("trackers = %v", e.Trackers)
+	}
+	if !e.setVerifiedTrackers(e.trackerList.gen, []string{"https://b.example/announce"}) {
+		t.Error("current verify result discarded")
+	}
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	trackerProbeTimeout = 8 * time.Second
+	trackerProbeWorkers = 16
+	// BEP 15 magic constant for the connect request
+	udpTrackerProtocolID = 0x41727101980
+)
+
+// probeUDPTracker sends a BEP 15 connect request and waits for the answer
+func probeUDPTracker(u *url.URL) error {
+	conn, err := net.DialTimeout("udp", u.Host, trackerProbeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(trackerProbeTimeout)); err != nil {
+		return err
+	}
+
+	tid := make([]byte, 4)
+	if _, err := rand.Read(tid); err != nil {
+		return err
+	}
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:], udpTrackerProtocolID)
+	binary.BigEndian.PutUint32(req[8:], 0) // action connect
+	copy(req[12:], tid)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 16)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return err
+	}
+	if n < 16 || binary.BigEndian.Uint32(resp[0:]) != 0 || !bytes.Equal(resp[4:8], tid) {
+		return fmt.Errorf("unexpected connect response from %s", u.Host)
+	}
+	return nil
+}
+
+// probeHTTPTracker treats any http response as reachable, the announce
+// itself is expected to fail without the required params
+func probeHTTPTracker(client *http.Client, u *url.URL) error {
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// trackerVariants returns the same tracker on the other transport, most
+// public trackers serve both udp and http on the same host
+func trackerVariants(u *url.URL) []string {
+	host, port := u.Hostname(), u.Port()
+	switch u.Scheme {
+	case "udp", "udp4", "udp6":
+		vs := []string{fmt.Sprintf("https://%s/announce", host)}
+		if port != "" {
+			vs = append(vs, fmt.Sprintf("http://%s/announce", u.Host))
+		}
+		return append(vs, fmt.Sprintf("http://%s/announce", host))
+	case "http", "https":
+		if port == "" {
+			return nil
+		}
+		return []string{fmt.Sprintf("udp://%s/announce", u.Host)}
+	}
+	return nil
+}
+
+type trackerProber struct {
+	client *http.Client
+	// udp announces don't go through the http proxy, treat udp as blocked
+	noUDP bool
+}
+
+func newTrackerProber(c *Config) *trackerProber {
+	tr := &http.Transport{}
+	if c.ProxyURL != "" {
+		if pu, err := url.Parse(c.ProxyURL); err == nil {
+			tr.Proxy = http.ProxyURL(pu)
+		}
+	}
+	return &trackerProber{
+		client: &http.Client{
+			Transport: tr,
+			Timeout:   trackerProbeTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		noUDP: c.ProxyURL != "",
+	}
+}
+
+func (p *trackerProber) probe(tracker string) error {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "udp", "udp4", "udp6":
+		if p.noUDP {
+			return fmt.Errorf("udp tracker bypasses the proxy")
+		}
+		return probeUDPTracker(u)
+	case "http", "https":
+		return probeHTTPTracker(p.client, u)
+	}
+	// other schemes (ws/wss) aren't checked
+	return nil
+}
+
+// resolve returns tracker if it's reachable, or the first reachable
+// variant on the other transport, empty if none
+func (p *trackerProber) resolve(tracker string) string {
+	err := p.probe(tracker)
+	if err == nil {
+		return tracker
+	}
+	u, _ := url.Parse(tracker)
+	if u == nil {
+		return ""
+	}
+	for _, v := range trackerVariants(u) {
+		if p.probe(v) == nil {
+			log.Warnf("[verifyTrackers] %s unreachable (%v), fallback to %s", tracker, err, v)
+			return v
+		}
+	}
+	log.Warnf("[verifyTrackers] %s unreachable: %v", tracker, err)
+	return ""
+}
+
+// verifyTrackers probes every tracker and swaps the unreachable ones to
+// their variant on the other transport, the dead ones are dropped
+func verifyTrackers(c *Config, trackers []string) []string {
+	p := newTrackerProber(c)
+	result := make([]string, len(trackers))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, trackerProbeWorkers)
+	for i, t := range trackers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result[i] = p.resolve(t)
+		}(i, t)
+	}
+	wg.Wait()
+
+	dupMap := make(map[string]struct{})
+	verified := []string{}
+	for _, t := range result {
+		if _, ok := dupMap[t]; ok || t == "" {
+			continue
+		}
+		dupMap[t] = struct{}{}
+		verified = append(verified, t)
+	}
+	log.Printf("[verifyTrackers] %d of %d trackers reachable", len(verified), len(trackers))
+	if len(verified) == 0 {
+		// probably offline at the moment, keep the list as is
+		return trackers
+	}
+	return verified
+}
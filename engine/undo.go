@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+var errNothingToUndo = errors.New("the task isn't deleted or the undo window has passed")
+
+// DeletedTask is a task deleted by SoftDeleteTorrent, restorable until Until
+type DeletedTask struct {
+	InfoHash  string
+	Name      string
+	DeletedAt time.Time
+	Until     time.Time
+	session   taskSession
+	dir       string
+	// cache files moved to the trash
+	files []string
+	timer *time.Timer
+}
+
+// DeletedList is the tasks that can be undeleted
+type DeletedList struct {
+	sync.Mutex
+	Tasks map[string]*DeletedTask
+}
+
+// DeletedTasks returns the tasks that can be undeleted
+func (e *Engine) DeletedTasks() *DeletedList {
+	return &e.deleted
+}
+
+// SoftDeleteTorrent deletes the task and its cache, keeping the data. Within the
+// UndoDeleteWindow the task can be restored with its state by UndoDeleteTorrent.
+func (e *Engine) SoftDeleteTorrent(infohash string) error {
+	window := e.config.UndoDeleteWindow
+	if window <= 0 {
+		if err := e.DeleteTorrent(infohash); err != nil {
+			return err
+		}
+		e.RemoveCache(infohash)
+		return nil
+	}
+
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	d := &DeletedTask{InfoHash: infohash, Name: t.Name, session: t.session()}
+	t.Unlock()
+	d.dir = e.taskDir(infohash)
+	if err := e.DeleteTorrent(infohash); err != nil {
+		return err
+	}
+
+	// the cache is kept in the trash as RemoveCache does
+	for _, ext := range []string{".torrent", ".info"} {
+		name := cacheSavedPrefix + infohash + ext
+		if err := os.Rename(filepath.Join(e.cacheDir, name), filepath.Join(e.trashDir, name)); err == nil {
+			d.files = append(d.files, name)
+		} else if !os.IsNotExist(err) {
+			log.Warn("[SoftDelete] fail to move to trash", err)
+		}
+	}
+	e.setTaskDir(infohash, "")
+
+	d.DeletedAt = time.Now()
+	d.Until = d.DeletedAt.Add(window)
+	e.deleted.Lock()
+	if old, ok := e.deleted.Tasks[infohash]; ok {
+		old.timer.Stop()
+	}
+	d.timer = time.AfterFunc(window, func() { e.expireDeleted(d) })
+	e.deleted.Tasks[infohash] = d
+	e.deleted.Unlock()
+	log.Printf("[SoftDelete] %s restorable until %s", infohash, d.Until.Format(time.RFC3339))
+	e.notifyChanged()
+	return nil
+}
+
+// UndoDeleteTorrent restores the task deleted by SoftDeleteTorrent
+func (e *Engine) UndoDeleteTorrent(infohash string) error {
+	e.deleted.Lock()
+	d, ok := e.deleted.Tasks[infohash]
+	if ok {
+		d.timer.Stop()
+		delete(e.deleted.Tasks, infohash)
+	}
+	e.deleted.Unlock()
+	if !ok {
+		return errNothingToUndo
+	}
+
+	var restore string
+	for _, name := range d.files {
+		fn := filepath.Join(e.cacheDir, name)
+		if err := os.Rename(filepath.Join(e.trashDir, name), fn); err != nil {
+			return err
+		}
+		if restore == "" || filepath.Ext(fn) == ".torrent" {
+			restore = fn
+		}
+	}
+	if restore == "" {
+		return errNothingToUndo
+	}
+	if d.dir != "" {
+		e.setTaskDir(infohash, d.dir)
+	}
+	e.sessions.Lock()
+	if e.sessions.m != nil {
+		e.sessions.m[infohash] = d.session
+		e.sessions.dirty = true
+	}
+	e.sessions.Unlock()
+
+	log.Println("[UndoDelete]", infohash)
+	if err := e.RestoreTask(restore); err != nil && !errors.Is(err, ErrMaxConnTasks) {
+		return err
+	}
+	return nil
+}
+
+// expireDeleted drops the task from the undo list, the .torrent stays in the trash
+func (e *Engine) expireDeleted(d *DeletedTask) {
+	e.deleted.Lock()
+	if e.deleted.Tasks[d.InfoHash] != d {
+		e.deleted.Unlock()
+		return
+	}
+	delete(e.deleted.Tasks, d.InfoHash)
+	e.deleted.Unlock()
+
+	for _, name := range d.files {
+		if filepath.Ext(name) == ".info" {
+			common.HandleError(os.Remove(filepath.Join(e.trashDir, name)))
+		}
+	}
+	e.notifyChanged()
+}
@@ -0,0 +1,126 @@
+// Package upload copies the data of the completed tasks to a remote by an
+// rclone binary: S3, WebDAV, Google Drive or any remote of its config. The
+// progress is read from the JSON log of rclone.
+package upload
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoRclone is returned when the binary isn't found
+var ErrNoRclone = errors.New("rclone not found in PATH")
+
+// Progress is a stats line of rclone
+type Progress struct {
+	Bytes int64
+	Total int64
+	// bytes per second
+	Speed float64
+}
+
+// Rclone uploads to Remote, as remote:path
+type Rclone struct {
+	// the rclone binary, looked up in PATH if empty
+	Binary string
+	Remote string
+	// more flags of rclone, eg: --config /etc/rclone.conf --transfers 2
+	Args []string
+	// Started is told the process once started
+	Started func(*exec.Cmd)
+}
+
+// Dest is where src is copied: a directory keeps its name under Remote, a
+// file is copied into Remote
+func (r *Rclone) Dest(src string, isDir bool) string {
+	if !isDir {
+		return r.Remote
+	}
+	return joinRemote(r.Remote, filepath.Base(src))
+}
+
+func joinRemote(remote, name string) string {
+	if remote == "" || strings.HasSuffix(remote, ":") || strings.HasSuffix(remote, "/") {
+		return remote + name
+	}
+	return remote + "/" + name
+}
+
+// Upload copies the file or the directory src to the remote, telling
+// progress the stats every couple of seconds. The files already on the
+// remote and unchanged are skipped, so an upload can be run again.
+func (r *Rclone) Upload(ctx context.Context, src string, progress func(Progress)) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	bin := r.Binary
+	if bin == "" {
+		if bin, err = exec.LookPath("rclone"); err != nil {
+			return ErrNoRclone
+		}
+	}
+	args := []string{"copy", src, r.Dest(src, fi.IsDir()),
+		"--use-json-log", "--stats", "2s", "--stats-log-level", "NOTICE"}
+	cmd := exec.CommandContext(ctx, bin, append(args, r.Args...)...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if r.Started != nil {
+		r.Started(cmd)
+	}
+	lastErr := readLog(stderr, progress)
+	if err := cmd.Wait(); err != nil {
+		if lastErr != "" {
+			return fmt.Errorf("%w: %s", err, lastErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// logLine is a line of the JSON log of rclone
+type logLine struct {
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	Stats *struct {
+		Bytes      int64   `json:"bytes"`
+		TotalBytes int64   `json:"totalBytes"`
+		Speed      float64 `json:"speed"`
+	} `json:"stats"`
+}
+
+// readLog sends the stats to progress and returns the last error logged
+func readLog(r io.Reader, progress func(Progress)) string {
+	var lastErr string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var l logLine
+		if err := json.Unmarshal(s.Bytes(), &l); err != nil {
+			// not JSON, eg: a fatal error before the logging is set up
+			if line := strings.TrimSpace(s.Text()); line != "" {
+				lastErr = line
+			}
+			continue
+		}
+		if l.Stats != nil {
+			progress(Progress{Bytes: l.Stats.Bytes, Total: l.Stats.TotalBytes, Speed: l.Stats.Speed})
+		}
+		if l.Level == "error" || l.Level == "critical" {
+			lastErr = strings.TrimSpace(l.Msg)
+		}
+	}
+	return lastErr
+}
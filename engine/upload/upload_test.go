@@ -0,0 +1,43 @@
+package upload
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDest(t *testing.T) {
+	tests := []struct {
+		remote, src string
+		isDir       bool
+		want        string
+	}{
+		{"gdrive:", "/dl/Some Show", true, "gdrive:Some Show"},
+		{"s3:bucket/torrents", "/dl/Some Show", true, "s3:bucket/torrents/Some Show"},
+		{"s3:bucket/torrents/", "/dl/Some Show", true, "s3:bucket/torrents/Some Show"},
+		{"s3:bucket/torrents", "/dl/a.iso", false, "s3:bucket/torrents"},
+	}
+	for _, tt := range tests {
+		r := &Rclone{Remote: tt.remote}
+		if got := r.Dest(tt.src, tt.isDir); got != tt.want {
+			t.Errorf("Dest(%q, %q) = %q, want %q", tt.remote, tt.src, got, tt.want)
+		}
+	}
+}
+
+func Test_readLog(t *testing.T) {
+	log := `{"level":"notice","msg":"stats","stats":{"bytes":512,"totalBytes":1024,"speed":256.5}}
+{"level":"error","msg":"a.iso: Failed to copy: permission denied\n"}
+{"level":"notice","msg":"stats","stats":{"bytes":1024,"totalBytes":1024,"speed":300}}
+`
+	var got []Progress
+	lastErr := readLog(strings.NewReader(log), func(p Progress) { got = append(got, p) })
+	if len(got) != 2 || got[0] != (Progress{512, 1024, 256.5}) || got[1].Bytes != 1024 {
+		t.Errorf("progress = %+v", got)
+	}
+	if lastErr != "a.iso: Failed to copy: permission denied" {
+		t.Errorf("last error = %q", lastErr)
+	}
+	if lastErr := readLog(strings.NewReader("Failed to create file system\n"), func(Progress) {}); lastErr != "Failed to create file system" {
+		t.Errorf("plain last error = %q", lastErr)
+	}
+}
@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// roles of the users, from the most allowed
+const (
+	RoleAdmin    = "admin"
+	RoleUser     = "user"
+	RoleReadOnly = "readonly"
+)
+
+var errLastAdmin = errors.New("the last admin can't be removed")
+
+// User is an account of the web UI and the API
+type User struct {
+	Name         string
+	Role         string
+	PasswordHash string `json:",omitempty"`
+	// limits of the tasks added by the user, 0 for none
+	MaxTasks  int    `json:",omitempty"`
+	DiskQuota string `json:",omitempty"`
+}
+
+// UserStore keeps the users in a JSON file, the single --auth account is
+// used while it's empty
+type UserStore struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]*User
+	// sha256 of the passwords checked, bcrypt is too slow for every request
+	verified map[string][sha256.Size]byte
+}
+
+// OpenUserStore reads the users of the file, missing is empty
+func OpenUserStore(path string) (*UserStore, error) {
+	s := &UserStore{
+		path:     path,
+		users:    make(map[string]*User),
+		verified: make(map[string][sha256.Size]byte),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("users file %s: %w", path, err)
+	}
+	for _, u := range list {
+		s.users[u.Name] = u
+	}
+	return s, nil
+}
+
+// Len counts the users
+func (s *UserStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// Authenticate returns the user of the name and password
+func (s *UserStore) Authenticate(name, password string) (User, bool) {
+	sum := sha256.Sum256([]byte(password))
+	s.mu.RLock()
+	u, ok := s.users[name]
+	var cached [sha256.Size]byte
+	var hit bool
+	if ok {
+		cached, hit = s.verified[name]
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return User{}, false
+	}
+	if hit && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return stripHash(u), true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return User{}, false
+	}
+	s.mu.Lock()
+	s.verified[name] = sum
+	s.mu.Unlock()
+	return stripHash(u), true
+}
+
+// Get returns the user without the password hash
+func (s *UserStore) Get(name string) (User, bool) {
+	if s == nil {
+		return User{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, false
+	}
+	return stripHash(u), true
+}
+
+// List returns the users by name, without the password hashes
+func (s *UserStore) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, stripHash(u))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Set adds or updates the user, the password is kept when empty but for a
+// new user
+func (s *UserStore) Set(u User, password string) error {
+	u.Name = strings.TrimSpace(u.Name)
+	if u.Name == "" || strings.ContainsAny(u.Name, ":\r\n") {
+		return fmt.Errorf("invalid user name %q", u.Name)
+	}
+	switch u.Role {
+	case RoleAdmin, RoleUser, RoleReadOnly:
+	default:
+		return fmt.Errorf("invalid role %q, expecting admin, user or readonly", u.Role)
+	}
+	if u.MaxTasks < 0 {
+		return fmt.Errorf("invalid max tasks %d", u.MaxTasks)
+	}
+	if _, err := parseByteSize(u.DiskQuota); err != nil {
+		return fmt.Errorf("invalid disk quota %q: %w", u.DiskQuota, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, exists := s.users[u.Name]
+	switch {
+	case password != "":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = string(hash)
+	case exists:
+		u.PasswordHash = old.PasswordHash
+	default:
+		return fmt.Errorf("password of the new user %s required", u.Name)
+	}
+	if exists && old.Role == RoleAdmin && u.Role != RoleAdmin && s.admins() == 1 {
+		return errLastAdmin
+	}
+	if !exists && len(s.users) == 0 && u.Role != RoleAdmin {
+		return errors.New("the first user must be an admin")
+	}
+	s.users[u.Name] = &u
+	delete(s.verified, u.Name)
+	return s.save()
+}
+
+// Delete removes the user, the tasks added by it are kept
+func (s *UserStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return fmt.Errorf("user %s not found", name)
+	}
+	if u.Role == RoleAdmin && s.admins() == 1 && len(s.users) > 1 {
+		return errLastAdmin
+	}
+	delete(s.users, name)
+	delete(s.verified, name)
+	return s.save()
+}
+
+// admins counts the admins, must hold lock
+func (s *UserStore) admins() int {
+	n := 0
+	for _, u := range s.users {
+		if u.Role == RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// save writes the users to a temp file renamed over the file, must hold lock
+func (s *UserStore) save() error {
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func stripHash(u *User) User {
+	c := *u
+	c.PasswordHash = ""
+	return c
+}
+
+// SetUserStore enables the quotas of the users
+func (e *Engine) SetUserStore(s *UserStore) {
+	e.Lock()
+	defer e.Unlock()
+	e.users = s
+}
+
+// checkUserQuota returns a PolicyError if the task of size added by the user
+// exceeds its quotas, newTask counts it in MaxTasks
+func (e *Engine) checkUserQuota(name string, size int64, newTask bool) error {
+	e.RLock()
+	users := e.users
+	e.RUnlock()
+	u, ok := users.Get(name)
+	if !ok {
+		return nil
+	}
+	quota, _ := parseByteSize(u.DiskQuota)
+	if u.MaxTasks == 0 && quota == 0 {
+		return nil
+	}
+	var tasks int
+	var used int64
+	for _, t := range e.Torrents() {
+		t.Lock()
+		if t.AddedBy == name {
+			tasks++
+			used += t.Size
+		}
+		t.Unlock()
+	}
+	if newTask && u.MaxTasks > 0 && tasks >= u.MaxTasks {
+		return &PolicyError{fmt.Sprintf("user %s reached the max %d tasks", name, u.MaxTasks)}
+	}
+	if quota > 0 && used+size > quota {
+		return &PolicyError{fmt.Sprintf("user %s disk quota %s exceeded, %s used", name,
+			humanize.IBytes(uint64(quota)), humanize.IBytes(uint64(used)))}
+	}
+	return nil
+}
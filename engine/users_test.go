@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUserStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "users.json")
+	s, err := OpenUserStore(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(User{Name: "bob", Role: RoleUser}, "pw"); err == nil {
+		t.Error("Set() the first user not admin, expecting error")
+	}
+	if err := s.Set(User{Name: "root", Role: RoleAdmin}, ""); err == nil {
+		t.Error("Set() new user without password, expecting error")
+	}
+	if err := s.Set(User{Name: "root", Role: RoleAdmin}, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(User{Name: "bob", Role: RoleUser, MaxTasks: 2, DiskQuota: "10GB"}, "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(User{Name: "eve", Role: "owner"}, "pw"); err == nil {
+		t.Error("Set() invalid role, expecting error")
+	}
+	if err := s.Set(User{Name: "root", Role: RoleUser}, ""); err != errLastAdmin {
+		t.Errorf("Set() demoting the last admin = %v", err)
+	}
+
+	// read back from the file
+	if s, err = OpenUserStore(p); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		u, ok := s.Authenticate("bob", "pw")
+		if !ok || u.Role != RoleUser || u.MaxTasks != 2 || u.PasswordHash != "" {
+			t.Errorf("Authenticate() = %+v %v", u, ok)
+		}
+	}
+	if _, ok := s.Authenticate("bob", "wrong"); ok {
+		t.Error("Authenticate() wrong password")
+	}
+	// the password kept when updated without it
+	if err := s.Set(User{Name: "bob", Role: RoleReadOnly}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := s.Authenticate("bob", "pw"); !ok || u.Role != RoleReadOnly {
+		t.Errorf("Authenticate() after update = %+v %v", u, ok)
+	}
+	if err := s.Delete("root"); err != errLastAdmin {
+		t.Errorf("Delete() the last admin = %v", err)
+	}
+	if err := s.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len() = %d", s.Len())
+	}
+}
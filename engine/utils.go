@@ -2,13 +2,10 @@ package engine
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
-	"io"
-	"net/http"
 	"os"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/boypt/simple-torrent/common"
 	"github.com/c2h5oh/datasize"
@@ -47,18 +44,6 @@ func rateLimiter(rstr string) (*rate.Limiter, error) {
 	return rate.NewLimiter(rate.Limit(rateSize), rateSize*3), nil
 }
 
-func cmdScanLine(p io.ReadCloser, wg *sync.WaitGroup, logprefix string) {
-	sc := bufio.NewScanner(p)
-	for sc.Scan() {
-		oline := strings.TrimSpace(sc.Text())
-		if len(oline) > 0 {
-			log.Println(logprefix, oline)
-		}
-	}
-
-	wg.Done()
-}
-
 func mkdir(dirpath string) {
 	if st, err := os.Stat(dirpath); errors.Is(err, os.ErrNotExist) {
 		common.HandleError(os.MkdirAll(dirpath, os.ModePerm))
@@ -67,20 +52,27 @@ func mkdir(dirpath string) {
 	}
 }
 
-func fetchTxtList(url string) ([]string, error) {
-	var txtlines []string
-
-	log.Println("fetchTxtList: fetching", url)
-	client := http.Client{
-		Timeout: 10 * time.Second,
+// fetchTxtList fetches the lines of the list at url, revalidated by the ETag
+// regardless of the MinInterval of the cache if revalidate
+func fetchTxtList(c *common.HTTPCache, url string, revalidate bool) ([]string, error) {
+	log.Debug("fetchTxtList: fetching", url)
+	get := c.Get
+	if revalidate {
+		get = c.Revalidate
 	}
-
-	resp, err := client.Get(url)
+	body, err := get(url)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	scanner := bufio.NewScanner(resp.Body)
+	txtlines := txtLines(body)
+	log.Debug("fetchTxtList: got lines", len(txtlines))
+	return txtlines, nil
+}
+
+// txtLines are the lines of a list, the empty ones skipped
+func txtLines(body []byte) []string {
+	var txtlines []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
 	scanner.Split(bufio.ScanLines)
 
 	for scanner.Scan() {
@@ -90,7 +82,5 @@ func fetchTxtList(url string) ([]string, error) {
 		}
 		txtlines = append(txtlines, line)
 	}
-
-	log.Println("fetchTxtList: got lines", len(txtlines))
-	return txtlines, nil
+	return txtlines
 }
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	errNotLoaded      = errors.New("torrent info not loaded")
+	errVerifyRunning  = errors.New("verification already running")
+	errVerifyCanceled = errors.New("verification canceled")
+)
+
+// VerifyTorrent hashes all the pieces of the task again in background, eg: after
+// the data is moved manually or disk errors. The progress is on VerifyPercent.
+func (e *Engine) VerifyTorrent(infohash string) error {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	loaded := t.Loaded && t.t != nil
+	t.Unlock()
+	if !loaded {
+		return errNotLoaded
+	}
+	if !t.beginVerify() {
+		return errVerifyRunning
+	}
+	log.Println("[VerifyTorrent] verifying", infohash)
+	go func() {
+		bad := e.verifyTorrent(t)
+		log.Printf("[VerifyTorrent] verified %s, %d bad pieces", infohash, bad)
+	}()
+	return nil
+}
+
+// beginVerify marks the task verifying, false if it's already running
+func (t *Torrent) beginVerify() bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.Verifying {
+		return false
+	}
+	t.Verifying = true
+	t.VerifyPercent = 0
+	return true
+}
+
+// verifyTorrent hashes the pieces one by one for the progress, returns the
+// number of complete pieces went bad. Must be called after beginVerify, it
+// waits for a background worker and is throttled by BackgroundCPU.
+func (e *Engine) verifyTorrent(t *Torrent) int {
+	e.RLock()
+	closeSync := e.closeSync
+	e.RUnlock()
+	release, err := e.background.acquire(t.dropWait, closeSync)
+	if err != nil {
+		t.Lock()
+		t.Verifying = false
+		t.Unlock()
+		return 0
+	}
+	defer release()
+	var bad int
+	// the pieces are hashed as on the disk
+	e.pieceCache.dropTask(t.InfoHash)
+	n := t.t.NumPieces()
+	for i := 0; i < n; i++ {
+		select {
+		case <-t.dropWait:
+			err = errVerifyCanceled
+		case <-closeSync:
+			err = errVerifyCanceled
+		default:
+		}
+		if err != nil {
+			break
+		}
+		start := time.Now()
+		// the pieces downloaded meanwhile aren't counted either way
+		complete := t.t.PieceState(i).Complete
+		t.t.Piece(i).VerifyData()
+		if complete && !t.t.PieceState(i).Complete {
+			bad++
+		}
+		e.background.throttle(time.Since(start), closeSync)
+		t.Lock()
+		t.VerifyPercent = percent(int64(i+1), int64(n))
+		t.Unlock()
+	}
+
+	if err == nil && bad > 0 {
+		err = fmt.Errorf("%d pieces failed verification", bad)
+	}
+
+	t.Lock()
+	t.Verifying = false
+	if err != errVerifyCanceled {
+		t.VerifiedAt = time.Now()
+		t.VerifyBadPieces = bad
+	}
+	e.emit(EventVerified, t, err)
+	t.Unlock()
+	return bad
+}
@@ -5,7 +5,7 @@ import (
 	"sync"
 )
 
-// syncList is a FIFO queue
+// syncList is a FIFO queue of taskElem, the ones of higher priority first
 type syncList struct {
 	lst *list.List
 	sync.Mutex
@@ -20,6 +20,18 @@ func NewSyncList() *syncList {
 func (l *syncList) Push(v interface{}) *list.Element {
 	l.Lock()
 	defer l.Unlock()
+	return l.insert(v)
+}
+
+// insert puts v after the elements of the same or higher priority, l locked
+func (l *syncList) insert(v interface{}) *list.Element {
+	if te, ok := v.(taskElem); ok {
+		for temp := l.lst.Front(); temp != nil; temp = temp.Next() {
+			if elm, ok := temp.Value.(taskElem); ok && elm.priority < te.priority {
+				return l.lst.InsertBefore(v, temp)
+			}
+		}
+	}
 	return l.lst.PushBack(v)
 }
 
@@ -39,12 +51,76 @@ func (l *syncList) Remove(ih string) {
 	for temp := l.lst.Front(); temp != nil; temp = temp.Next() {
 		if elm, ok := temp.Value.(taskElem); ok && elm.ih == ih {
 			l.lst.Remove(temp)
-			log.Println("syncList removed ih", ih)
+			log.Debug("syncList removed ih", ih)
 			break
 		}
 	}
 }
 
+// Take removes the task from the list and returns it
+func (l *syncList) Take(ih string) (taskElem, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if temp := l.find(ih); temp != nil {
+		return l.lst.Remove(temp).(taskElem), true
+	}
+	return taskElem{}, false
+}
+
+// MoveToFront puts the task at the head of the list, raising its priority to
+// the highest one in the list
+func (l *syncList) MoveToFront(ih string) (taskElem, bool) {
+	l.Lock()
+	defer l.Unlock()
+	temp := l.find(ih)
+	if temp == nil {
+		return taskElem{}, false
+	}
+	te := temp.Value.(taskElem)
+	if front, ok := l.lst.Front().Value.(taskElem); ok && front.priority > te.priority {
+		te.priority = front.priority
+	}
+	temp.Value = te
+	l.lst.MoveToFront(temp)
+	return te, true
+}
+
+// SetPriority repositions the task by the priority
+func (l *syncList) SetPriority(ih string, priority int) bool {
+	l.Lock()
+	defer l.Unlock()
+	temp := l.find(ih)
+	if temp == nil {
+		return false
+	}
+	te := l.lst.Remove(temp).(taskElem)
+	te.priority = priority
+	l.insert(te)
+	return true
+}
+
+// Elems returns the tasks in the order of the list
+func (l *syncList) Elems() []taskElem {
+	l.Lock()
+	defer l.Unlock()
+	var elems []taskElem
+	for temp := l.lst.Front(); temp != nil; temp = temp.Next() {
+		if elm, ok := temp.Value.(taskElem); ok {
+			elems = append(elems, elm)
+		}
+	}
+	return elems
+}
+
+func (l *syncList) find(ih string) *list.Element {
+	for temp := l.lst.Front(); temp != nil; temp = temp.Next() {
+		if elm, ok := temp.Value.(taskElem); ok && elm.ih == ih {
+			return temp
+		}
+	}
+	return nil
+}
+
 func (l *syncList) Len() int {
 	return l.lst.Len()
 }
@@ -57,6 +133,7 @@ const (
 )
 
 type taskElem struct {
-	ih string
-	tp taskType
+	ih       string
+	tp       taskType
+	priority int
 }
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func Test_syncList(t *testing.T) {
+	l := NewSyncList()
+	l.Push(taskElem{ih: "a"})
+	l.Push(taskElem{ih: "b", priority: 1})
+	l.Push(taskElem{ih: "c"})
+	l.Push(taskElem{ih: "d", priority: 1})
+
+	order := func() []string {
+		var ihs []string
+		for _, te := range l.Elems() {
+			ihs = append(ihs, te.ih)
+		}
+		return ihs
+	}
+	if got, want := order(), []string{"b", "d", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Push() order = %v, want %v", got, want)
+	}
+
+	if te, ok := l.MoveToFront("c"); !ok || te.priority != 1 {
+		t.Errorf("MoveToFront() = %v, %v, want priority 1", te, ok)
+	}
+	if got, want := order(), []string{"c", "b", "d", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MoveToFront() order = %v, want %v", got, want)
+	}
+
+	l.SetPriority("a", 2)
+	l.SetPriority("c", -1)
+	if got, want := order(), []string{"a", "b", "d", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SetPriority() order = %v, want %v", got, want)
+	}
+
+	if _, ok := l.Take("d"); !ok {
+		t.Error("Take() missing d")
+	}
+	if _, ok := l.Take("d"); ok {
+		t.Error("Take() d twice")
+	}
+	if got := l.Pop().(taskElem).ih; got != "a" {
+		t.Errorf("Pop() = %v, want a", got)
+	}
+}
+
+func TestCachedSpec(t *testing.T) {
+	e := &Engine{cacheDir: t.TempDir()}
+	ih := "dd8255ecdc7ca55fb0bbf81323d87062db1f6d1c"
+	if _, _, err := e.cachedSpec(ih); !os.IsNotExist(err) {
+		t.Fatalf("cachedSpec() without cache = %v", err)
+	}
+
+	mag := "magnet:?xt=urn:btih:" + ih + "&dn=Big+Buck+Bunny&tr=udp%3A%2F%2Ftracker.example%3A80"
+	if err := ioutil.WriteFile(e.magnetCacheFileName(ih), []byte(mag), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, tp, err := e.cachedSpec(ih)
+	if err != nil || tp != taskMagnet {
+		t.Fatalf("cachedSpec() = %v, %v", tp, err)
+	}
+	if spec.DisplayName != "Big Buck Bunny" || len(spec.Trackers) != 1 {
+		t.Errorf("cachedSpec() magnet = %q %v", spec.DisplayName, spec.Trackers)
+	}
+
+	mi := &metainfo.MetaInfo{AnnounceList: [][]string{{"udp://a/announce"}, {"udp://b/announce"}}}
+	if mi.InfoBytes, err = bencode.Marshal(metainfo.Info{Name: "a.mkv", Length: 10, PieceLength: 16 << 10}); err != nil {
+		t.Fatal(err)
+	}
+	ih = mi.HashInfoBytes().HexString()
+	f, err := os.Create(e.TorrentCacheFileName(ih))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mi.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	spec, tp, err = e.cachedSpec(ih)
+	if err != nil || tp != taskTorrent {
+		t.Fatalf("cachedSpec() = %v, %v", tp, err)
+	}
+	if spec.DisplayName != "a.mkv" || len(spec.Trackers) != 2 {
+		t.Errorf("cachedSpec() torrent = %q %v", spec.DisplayName, spec.Trackers)
+	}
+}
@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/boypt/simple-torrent/common"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watch dir options, what to do with a .torrent file once added
+	WatchAfterDelete = "delete"
+	WatchAfterRename = "rename"
+
+	watchAddedSuffix = ".added"
+)
+
+// watchDir is a line of WatchDirs, eg:
+//  /srv/watch/tv => label=tv, dir=tv/incoming, after=rename
+type watchDir struct {
+	path  string
+	label string
+	dir   string
+	after string
+}
+
+func parseWatchDirs(s string) ([]watchDir, error) {
+	var dirs []watchDir
+	for _, line := range common.SplitLines(s) {
+		parts := strings.SplitN(line, "=>", 2)
+		w := watchDir{path: strings.TrimSpace(parts[0]), after: WatchAfterDelete}
+		if w.path == "" {
+			return nil, fmt.Errorf("invalid watch dir %q", line)
+		}
+		if len(parts) == 2 {
+			for _, opt := range strings.Split(parts[1], ",") {
+				if opt = strings.TrimSpace(opt); opt == "" {
+					continue
+				}
+				kv := strings.SplitN(opt, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("watch dir %q: invalid option %q", line, opt)
+				}
+				v := strings.TrimSpace(kv[1])
+				switch strings.TrimSpace(kv[0]) {
+				case "label":
+					w.label = v
+				case "dir":
+					w.dir = v
+				case "after":
+					if v != WatchAfterDelete && v != WatchAfterRename {
+						return nil, fmt.Errorf("watch dir %q: after must be delete or rename", line)
+					}
+					w.after = v
+				default:
+					return nil, fmt.Errorf("watch dir %q: unknown option %q", line, kv[0])
+				}
+			}
+		}
+		dirs = append(dirs, w)
+	}
+	return dirs, nil
+}
+
+// watchDirs returns WatchDirectory and the WatchDirs, the deepest first to
+// match the files of the nested ones
+func (e *Engine) watchDirs() ([]watchDir, error) {
+	dirs, err := parseWatchDirs(e.config.WatchDirs)
+	if err != nil {
+		return nil, err
+	}
+	if e.config.WatchDirectory != "" {
+		dirs = append(dirs, watchDir{path: e.config.WatchDirectory, after: WatchAfterDelete})
+	}
+	for i := range dirs {
+		if dirs[i].path, err = filepath.Abs(dirs[i].path); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return len(dirs[i].path) > len(dirs[j].path) })
+	return dirs, nil
+}
+
+// matchWatchDir returns the watch dir holding the file
+func matchWatchDir(dirs []watchDir, file string) (watchDir, bool) {
+	for _, w := range dirs {
+		if rel, err := filepath.Rel(w.path, file); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return w, true
+		}
+	}
+	return watchDir{}, false
+}
+
+// StartTorrentWatcher watches the WatchDirectory and WatchDirs, with their
+// sub directories, adding the .torrent files written in them
+func (e *Engine) StartTorrentWatcher() error {
+
+	if e.watcher != nil {
+		log.Println("Torrent Watcher: close")
+		e.watcher.Close()
+		e.watcher = nil
+	}
+
+	dirs, err := e.watchDirs()
+	if err != nil {
+		return fmt.Errorf("[Watcher] %w", err)
+	}
+	var watching []watchDir
+	for _, w := range dirs {
+		if st, err := os.Stat(w.path); err != nil || !st.IsDir() {
+			log.Warnf("[Watcher] [%s] is not a dir, will not watch", w.path)
+			continue
+		}
+		watching = append(watching, w)
+	}
+	if len(watching) == 0 {
+		return errors.New("[Watcher] no watch directory to watch")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	e.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+					continue
+				}
+				st, err := os.Stat(event.Name)
+				if err != nil {
+					continue
+				}
+				if st.IsDir() {
+					if event.Op&fsnotify.Create != 0 {
+						watchTree(watcher, event.Name)
+					}
+					continue
+				}
+				if !strings.HasSuffix(event.Name, ".torrent") {
+					continue
+				}
+				if w, ok := matchWatchDir(watching, event.Name); ok && !inWatchFailed(w, event.Name) {
+					e.addWatchedFile(w, event.Name)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn("error:", err)
+			}
+		}
+	}()
+
+	for _, w := range watching {
+		log.Printf("Torrent Watcher: watching torrent file in %s", w.path)
+		watchTree(watcher, w.path)
+	}
+	return nil
+}
+
+// watchTree adds dir and all its sub directories to the watcher
+func watchTree(watcher *fsnotify.Watcher, dir string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				log.Warnf("[Watcher] watch %s: %v", path, err)
+			}
+		}
+		return nil
+	})
+	common.HandleError(err)
+}
+
+// addWatchedFile adds the .torrent file with the options of the watch dir,
+// then deletes it or renames it to .added. The files failed to add are
+// quarantined.
+func (e *Engine) addWatchedFile(w watchDir, path string) {
+	info, err := metainfo.LoadFromFile(path)
+	if err != nil {
+		// written partially, added on the next write or quarantined
+		e.settleWatchedFile(w, path)
+		return
+	}
+	ih := info.HashInfoBytes().HexString()
+
+	dir := w.dir
+	if dir == "" {
+		dir = e.labelDir(w.label).download
+	}
+	e.presetInfoHash(ih, func(p *taskPreset) { p.source = SourceWatch })
+	err = e.NewTorrentByFilePath(path, dir)
+	var merged *MergedError
+	if err != nil && !errors.Is(err, ErrMaxConnTasks) && !errors.As(err, &merged) {
+		log.Warnf("Torrent Watcher: fail to add %s, ERR:%#v\n", path, err)
+		if errors.Is(err, ErrTaskExists) {
+			err = fmt.Errorf("duplicate of the task %s", ih)
+		}
+		e.quarantineWatched(w, path, err)
+		return
+	}
+	// the label of a task added before is kept
+	if w.label != "" && merged == nil {
+		common.HandleError(e.SetTorrentLabel(ih, w.label))
+	}
+
+	if w.after == WatchAfterRename {
+		if err := os.Rename(path, path+watchAddedSuffix); err != nil {
+			log.Warn("[Watcher]", err)
+		}
+		log.Printf("Torrent Watcher: added %s, file renamed\n", path)
+		return
+	}
+	os.Remove(path)
+	log.Printf("Torrent Watcher: added %s, file removed\n", path)
+}
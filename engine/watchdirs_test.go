@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseWatchDirs(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []watchDir
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"plain", "/srv/watch", []watchDir{{path: "/srv/watch", after: WatchAfterDelete}}, false},
+		{"options", "/srv/tv => label=tv, dir=tv/incoming, after=rename",
+			[]watchDir{{path: "/srv/tv", label: "tv", dir: "tv/incoming", after: WatchAfterRename}}, false},
+		{"lines", "# comment\n/a => label=x\n/b =>",
+			[]watchDir{{path: "/a", label: "x", after: WatchAfterDelete}, {path: "/b", after: WatchAfterDelete}}, false},
+		{"bad after", "/a => after=move", nil, true},
+		{"unknown", "/a => tag=x", nil, true},
+		{"no value", "/a => label", nil, true},
+		{"no path", "=> label=x", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWatchDirs(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWatchDirs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWatchDirs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchWatchDir(t *testing.T) {
+	// the deepest first, as watchDirs sorts them
+	dirs := []watchDir{{path: "/srv/watch/tv", label: "tv"}, {path: "/srv/watch"}}
+	tests := []struct {
+		file  string
+		label string
+		ok    bool
+	}{
+		{"/srv/watch/a.torrent", "", true},
+		{"/srv/watch/tv/a.torrent", "tv", true},
+		{"/srv/watch/tv/sub/a.torrent", "tv", true},
+		{"/srv/watch/tvshows/a.torrent", "", true},
+		{"/srv/other/a.torrent", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			w, ok := matchWatchDir(dirs, tt.file)
+			if ok != tt.ok || w.label != tt.label {
+				t.Errorf("matchWatchDir() = %q %v, want %q %v", w.label, ok, tt.label, tt.ok)
+			}
+		})
+	}
+}
+
+func Test_inWatchFailed(t *testing.T) {
+	w := watchDir{path: "/srv/watch"}
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"/srv/watch/a.torrent", false},
+		{"/srv/watch/failed/a.torrent", true},
+		{"/srv/watch/tv/failed/a.torrent", false},
+		{"/srv/watch/failedold/a.torrent", false},
+	}
+	for _, tt := range tests {
+		if got := inWatchFailed(w, tt.file); got != tt.want {
+			t.Errorf("inWatchFailed(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	// watchFailedDir is the sub directory of a watch dir the files failed to
+	// add are moved to, each with the error in a sidecar file
+	watchFailedDir   = "failed"
+	watchErrorSuffix = ".error"
+	// a file still unreadable this long after its last write isn't partially
+	// written but invalid
+	watchSettleTime = 10 * time.Second
+)
+
+// WatchFailure is a .torrent file of a watch dir that failed to be added
+type WatchFailure struct {
+	Name     string
+	Path     string
+	Error    string
+	FailedAt time.Time
+}
+
+type watchPending struct {
+	sync.Mutex
+	// unreadable files by path, checked again once settled
+	timers map[string]*time.Timer
+}
+
+// inWatchFailed tells if the file is in the failed dir of the watch dir
+func inWatchFailed(w watchDir, path string) bool {
+	rel, err := filepath.Rel(filepath.Join(w.path, watchFailedDir), path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// settleWatchedFile checks the unreadable file again once it's no longer
+// written, it's quarantined if it's still unreadable
+func (e *Engine) settleWatchedFile(w watchDir, path string) {
+	e.watchPending.Lock()
+	defer e.watchPending.Unlock()
+	if e.watchPending.timers == nil {
+		e.watchPending.timers = make(map[string]*time.Timer)
+	}
+	if tm, ok := e.watchPending.timers[path]; ok {
+		tm.Reset(watchSettleTime)
+		return
+	}
+	e.watchPending.timers[path] = time.AfterFunc(watchSettleTime, func() {
+		e.watchPending.Lock()
+		delete(e.watchPending.timers, path)
+		e.watchPending.Unlock()
+		if _, err := os.Stat(path); err != nil {
+			// removed or added meanwhile
+			return
+		}
+		if _, err := metainfo.LoadFromFile(path); err != nil {
+			e.quarantineWatched(w, path, fmt.Errorf("invalid torrent file: %w", err))
+			return
+		}
+		e.addWatchedFile(w, path)
+	})
+}
+
+// quarantineWatched moves the file to the failed dir of the watch dir, with
+// the reason next to it, so it isn't retried
+func (e *Engine) quarantineWatched(w watchDir, path string, reason error) {
+	dir := filepath.Join(w.path, watchFailedDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warn("[Watcher]", err)
+		return
+	}
+	name := filepath.Base(path)
+	dst := filepath.Join(dir, name)
+	if _, err := os.Lstat(dst); err == nil {
+		dst = filepath.Join(dir, fmt.Sprintf("%s-%d.torrent", strings.TrimSuffix(name, ".torrent"), time.Now().Unix()))
+	}
+	if err := os.Rename(path, dst); err != nil {
+		log.Warn("[Watcher]", err)
+		return
+	}
+	if err := ioutil.WriteFile(dst+watchErrorSuffix, []byte(reason.Error()+"\n"), 0644); err != nil {
+		log.Warn("[Watcher]", err)
+	}
+	log.Warnf("[Watcher] %s failed, moved to %s: %v", path, dst, reason)
+	e.notifyChanged()
+}
+
+// WatchFailures lists the files quarantined in the watch dirs, the latest first
+func (e *Engine) WatchFailures() []WatchFailure {
+	list := []WatchFailure{}
+	dirs, err := e.watchDirs()
+	if err != nil {
+		return list
+	}
+	for _, w := range dirs {
+		dir := filepath.Join(w.path, watchFailedDir)
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range files {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".torrent") {
+				continue
+			}
+			f := WatchFailure{Name: fi.Name(), Path: filepath.Join(dir, fi.Name()), FailedAt: fi.ModTime()}
+			if data, err := ioutil.ReadFile(f.Path + watchErrorSuffix); err == nil {
+				f.Error = strings.TrimSpace(string(data))
+			}
+			// written when quarantined, the file keeps its time
+			if st, err := os.Stat(f.Path + watchErrorSuffix); err == nil {
+				f.FailedAt = st.ModTime()
+			}
+			list = append(list, f)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].FailedAt.After(list[j].FailedAt) })
+	return list
+}
+
+// watchFailure finds the listed failure at path
+func (e *Engine) watchFailure(path string) (watchDir, error) {
+	dirs, err := e.watchDirs()
+	if err != nil {
+		return watchDir{}, err
+	}
+	path = filepath.Clean(path)
+	for _, w := range dirs {
+		if filepath.Dir(path) == filepath.Join(w.path, watchFailedDir) && strings.HasSuffix(path, ".torrent") {
+			if _, err := os.Lstat(path); err != nil {
+				return watchDir{}, err
+			}
+			return w, nil
+		}
+	}
+	return watchDir{}, errors.New("not a failed file of the watch dirs")
+}
+
+// RetryWatchFailure moves the quarantined file back to its watch dir to be
+// added again
+func (e *Engine) RetryWatchFailure(path string) error {
+	w, err := e.watchFailure(path)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(w.path, filepath.Base(path))
+	if _, err := os.Lstat(dst); err == nil {
+		return errFileExists
+	}
+	os.Remove(path + watchErrorSuffix)
+	log.Printf("[Watcher] retry %s", path)
+	if err := os.Rename(path, dst); err != nil {
+		return err
+	}
+	e.notifyChanged()
+	return nil
+}
+
+// DeleteWatchFailure deletes the quarantined file and its error
+func (e *Engine) DeleteWatchFailure(path string) error {
+	if _, err := e.watchFailure(path); err != nil {
+		return err
+	}
+	os.Remove(path + watchErrorSuffix)
+	log.Printf("[Watcher] delete %s", path)
+	e.notifyChanged()
+	return os.Remove(path)
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// lifecycle events of a task
+const (
+	EventAdded     = "added"
+	EventMetadata  = "metadata"
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventStopped   = "stopped"
+	EventDeleted   = "deleted"
+	EventError     = "error"
+	EventVerified  = "verified"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	webhookRetries = 3
+	webhookQueue   = 64
+)
+
+// webhookBackoff times the attempt number is waited before a retry
+var webhookBackoff = 2 * time.Second
+
+var errWebhookDropped = errors.New("dropped, the queue of the webhook is full")
+
+// Event is posted as JSON to the webhooks
+type Event struct {
+	Type     string    `json:"type"`
+	InfoHash string    `json:"infohash"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Percent  float32   `json:"percent,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// emit records and publishes a lifecycle event of the task, never blocks.
+// The caller may hold the lock of t
+func (e *Engine) emit(typ string, t *Torrent, err error) {
+	ev := Event{
+		Type:     typ,
+		InfoHash: t.InfoHash,
+		Name:     t.Name,
+		Size:     t.Size,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	e.record(ev)
+	e.bus.publish(ev)
+}
+
+// webhookRoutine posts the lifecycle events to the WebhookURL. Every URL has
+// its own worker and queue, a slow or dead one doesn't hold back the others
+func (e *Engine) webhookRoutine(events <-chan Event) {
+	client := &http.Client{Timeout: webhookTimeout}
+	workers := make(map[string]chan Event)
+	defer func() {
+		for _, q := range workers {
+			close(q)
+		}
+	}()
+	for ev := range events {
+		c := e.Config()
+		urls := make(map[string]bool)
+		for _, url := range strings.Split(c.WebhookURL, "\n") {
+			url = strings.TrimSpace(url)
+			if url == "" || strings.HasPrefix(url, "#") {
+				continue
+			}
+			urls[url] = true
+		}
+		// the workers of the removed URLs finish their queue and exit
+		for url, q := range workers {
+			if !urls[url] {
+				close(q)
+				delete(workers, url)
+			}
+		}
+		if !webhookWants(c.WebhookEvents, ev.Type) {
+			continue
+		}
+		for url := range urls {
+			q, ok := workers[url]
+			if !ok {
+				q = make(chan Event, webhookQueue)
+				workers[url] = q
+				go e.webhookWorker(client, url, q)
+			}
+			select {
+			case q <- ev:
+			default:
+				log.Warnf("[Webhook] %s queue full, dropped %s %s", url, ev.Type, ev.InfoHash)
+				e.recordHook(ev.InfoHash, ev.Name, fmt.Sprintf("webhook %s %s", ev.Type, url), errWebhookDropped)
+			}
+		}
+	}
+}
+
+// webhookWorker posts the queued events to url one by one
+func (e *Engine) webhookWorker(client *http.Client, url string, q <-chan Event) {
+	for ev := range q {
+		err := postWebhook(client, url, ev)
+		e.recordHook(ev.InfoHash, ev.Name, fmt.Sprintf("webhook %s %s", ev.Type, url), err)
+	}
+}
+
+// webhookWants checks the event against the comma separated filter, empty means all
+func webhookWants(filter, typ string) bool {
+	if strings.TrimSpace(filter) == "" {
+		return true
+	}
+	for _, f := range strings.Split(filter, ",") {
+		if strings.TrimSpace(f) == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func postWebhook(client *http.Client, url string, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Warn("[Webhook]", err)
+		return err
+	}
+	var lastErr error
+	for i := 0; i < webhookRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * webhookBackoff)
+		}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		lastErr = err
+		log.Warnf("[Webhook] %s %s %s attempt %d: %v", ev.Type, ev.InfoHash, url, i+1, err)
+	}
+	return lastErr
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookSlowURL(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	got := make(chan struct{}, 2)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- struct{}{}
+	}))
+	defer fast.Close()
+
+	e := &Engine{
+		config:    Config{WebhookURL: slow.URL + "\n" + fast.URL},
+		timelines: timelineMap{m: make(map[string][]Event)},
+	}
+	events := make(chan Event)
+	go e.webhookRoutine(events)
+	events <- Event{Type: EventAdded, InfoHash: "a"}
+	events <- Event{Type: EventCompleted, InfoHash: "a"}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the slow webhook held back the other one")
+		}
+	}
+	close(events)
+}
+
+func TestPostWebhookError(t *testing.T) {
+	backoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = backoff }()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := postWebhook(srv.Client(), srv.URL, Event{Type: EventAdded})
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("err = %v, want the status of the last attempt", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != webhookRetries {
+		t.Errorf("posted %d times, want %d", n, webhookRetries)
+	}
+}
+
+func TestTimelineDropped(t *testing.T) {
+	e := &Engine{timelines: timelineMap{m: make(map[string][]Event)}}
+	e.record(Event{Type: EventAdded, InfoHash: "a"})
+	e.dropTimeline("a")
+	e.recordHook("a", "name", "webhook deleted", nil)
+	if _, ok := e.timelines.m["a"]; ok {
+		t.Error("timeline of the deleted task kept")
+	}
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// WebseedPath is the route serving the completed torrents as web seeds
+const WebseedPath = "/webseed/"
+
+// WebseedURL returns the BEP 19 url-list entry of the torrent served by
+// this instance, empty if WebseedURL isn't configured. The url ends with
+// a slash so that clients append the torrent name and file path
+func (e *Engine) WebseedURL(infohash string) string {
+	base := strings.TrimRight(e.config.WebseedURL, "/")
+	if base == "" {
+		return ""
+	}
+	return base + WebseedPath + infohash + "/"
+}
+
+// WebseedServed tells if the files of the task are served at WebseedPath:
+// completed, not private and with the web seed of this instance, as the
+// torrents created by it. Others get it by adding the web seed.
+func (e *Engine) WebseedServed(infohash string) bool {
+	ws := e.WebseedURL(infohash)
+	if ws == "" {
+		return false
+	}
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return false
+	}
+	t.Lock()
+	tt, done := t.t, t.Done
+	t.Unlock()
+	if !done || tt == nil {
+		return false
+	}
+	return webseedServed(tt.Info(), tt.Metainfo().UrlList, ws)
+}
+
+// webseedServed tells if the torrent of the info, not private, has the web
+// seed ws in its urls
+func webseedServed(info *metainfo.Info, urls []string, ws string) bool {
+	if info == nil || (info.Private != nil && *info.Private) {
+		return false
+	}
+	for _, u := range urls {
+		if u == ws {
+			return true
+		}
+	}
+	return false
+}
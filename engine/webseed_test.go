@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestWebseedServed(t *testing.T) {
+	const ws = "https://seed.example/webseed/0123456789abcdef0123456789abcdef01234567/"
+	private := true
+	for _, c := range []struct {
+		info   *metainfo.Info
+		urls   []string
+		served bool
+	}{
+		{&metainfo.Info{}, []string{"https://mirror.example/", ws}, true},
+		{&metainfo.Info{}, []string{"https://mirror.example/"}, false},
+		{&metainfo.Info{Private: &private}, []string{ws}, false},
+		{nil, []string{ws}, false},
+	} {
+		if served := webseedServed(c.info, c.urls, ws); served != c.served {
+			t.Errorf("webseedServed(%v) = %v, want %v", c.urls, served, c.served)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ZipEntry is a completed file of a task to put in a zip
+type ZipEntry struct {
+	// in the zip, under the folder zipped
+	Name string
+	// of the file in the task, for NewFileReader
+	Path string
+	Size int64
+}
+
+// ZipEntries lists the completed files of the task under dir, a folder
+// relative to the one of the task, "" for all. The returned name is the one
+// of the folder zipped
+func (e *Engine) ZipEntries(infohash, dir string) (string, []ZipEntry, error) {
+	e.RLock()
+	t, err := e.getTorrent(infohash)
+	e.RUnlock()
+	if err != nil {
+		return "", nil, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if !t.Loaded {
+		return "", nil, fmt.Errorf("torrent %s info not loaded yet", infohash)
+	}
+	root, entries, found := zipEntries(t.Files, t.Name, dir)
+	if !found {
+		return "", nil, fmt.Errorf("Missing folder %s", dir)
+	}
+	if len(entries) == 0 {
+		return "", nil, fmt.Errorf("No completed files in %s", root)
+	}
+	return root, entries, nil
+}
+
+// zipEntries selects the done files under dir of the task, found tells if
+// any file is there
+func zipEntries(files []*File, name, dir string) (root string, entries []ZipEntry, found bool) {
+	prefix := name
+	if dir = strings.Trim(path.Clean("/"+dir), "/"); dir != "" {
+		prefix = name + "/" + dir
+	}
+	root = path.Base(prefix)
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		var entry string
+		switch {
+		case f.Path == prefix:
+			// a single file
+			entry = root
+		case strings.HasPrefix(f.Path, prefix+"/"):
+			entry = root + "/" + f.Path[len(prefix)+1:]
+		default:
+			continue
+		}
+		found = true
+		if f.Done {
+			entries = append(entries, ZipEntry{Name: entry, Path: f.Path, Size: f.Size})
+		}
+	}
+	return root, entries, found
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_zipEntries(t *testing.T) {
+	files := []*File{
+		{Path: "Show/S01/e1.mkv", Size: 1, Done: true},
+		{Path: "Show/S01/e2.mkv", Size: 2},
+		{Path: "Show/S02/e1.mkv", Size: 3, Done: true},
+		{Path: "Show/S010/e1.mkv", Size: 4, Done: true},
+		{Path: "Show/info.nfo", Size: 5, Done: true},
+	}
+	tests := []struct {
+		dir   string
+		root  string
+		names []string
+		found bool
+	}{
+		{"", "Show", []string{"Show/S01/e1.mkv", "Show/S02/e1.mkv", "Show/S010/e1.mkv", "Show/info.nfo"}, true},
+		{"S01", "S01", []string{"S01/e1.mkv"}, true},
+		{"/S01/", "S01", []string{"S01/e1.mkv"}, true},
+		{"../S02", "S02", []string{"S02/e1.mkv"}, true},
+		{"info.nfo", "info.nfo", []string{"info.nfo"}, true},
+		{"S03", "S03", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			root, entries, found := zipEntries(files, "Show", tt.dir)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name)
+			}
+			if root != tt.root || found != tt.found || !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("zipEntries(%q) = %q, %v, %v, want %q, %v, %v", tt.dir, root, names, found, tt.root, tt.names, tt.found)
+			}
+		})
+	}
+
+	root, entries, _ := zipEntries([]*File{{Path: "movie.mkv", Size: 9, Done: true}}, "movie.mkv", "")
+	if root != "movie.mkv" || len(entries) != 1 || entries[0].Name != "movie.mkv" {
+		t.Errorf("single file zipEntries() = %q, %v", root, entries)
+	}
+}
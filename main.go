@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -9,17 +10,67 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/boypt/simple-torrent/common/apiclient"
+	"github.com/boypt/simple-torrent/common/selfupdate"
 	"github.com/boypt/simple-torrent/server"
 	"github.com/jpillora/opts"
 )
 
 var VERSION = "0.0.0-src" //set with ldflags
 
+// updateCmd is the update command, installing the latest release
+type updateCmd struct {
+	DaemonFlags
+	Check   bool `opts:"help=Only tell whether a newer release is available"`
+	Force   bool `opts:"help=Install the latest release even if it's not newer"`
+	Restart bool `opts:"help=Restart the running server at --url into the installed release (an admin of the server)"`
+	// a checksum published next to the binary doesn't authenticate it
+	Unsigned bool `opts:"help=Install the release checked by its checksum only when the binary has no key of the release signatures"`
+}
+
+func (u *updateCmd) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	up := &selfupdate.Updater{Version: VERSION, AllowUnsigned: u.Unsigned}
+	rel, err := up.Check(ctx)
+	if err != nil {
+		return err
+	}
+	if !rel.Available {
+		fmt.Printf("%s is the latest release, running %s\n", rel.Latest, VERSION)
+	} else {
+		fmt.Printf("%s is available, running %s: %s\n", rel.Latest, VERSION, rel.URL)
+	}
+	if u.Check || (!rel.Available && !u.Force) {
+		return nil
+	}
+	exe, err := up.Apply(ctx, rel, u.Force)
+	if err != nil {
+		return err
+	}
+	if !u.Restart {
+		// a running service is another process, restarted by the service
+		// manager, by --restart or by POST /api/restart
+		fmt.Printf("installed %s to %s, restart the service to run it\n", rel.Latest, exe)
+		return nil
+	}
+	rctx, rcancel := context.WithTimeout(context.Background(), apiclient.Timeout)
+	defer rcancel()
+	if err := u.client().Restart(rctx); err != nil {
+		return fmt.Errorf("installed %s to %s, restart failed: %w", rel.Latest, exe, err)
+	}
+	fmt.Printf("installed %s to %s, the server is restarting\n", rel.Latest, exe)
+	return nil
+}
+
 func main() {
 	s := server.Server{
 		Title:  "SimpleTorrent",
 		Port:   3000, // depreciated
 		Listen: ":3000",
+		// brute-force lockout of the basic auth
+		AuthMaxFails: 10,
+		AuthLockout:  "15m",
 	}
 
 	o := opts.New(&s)
@@ -27,7 +78,23 @@ func main() {
 	o.Repo("https://github.com/boypt/simple-torrent")
 	o.PkgRepo()
 	o.SetLineWidth(96)
-	o.Parse()
+	o.AddCommand(opts.New(&updateCmd{}).Name("update").
+		Summary("Install the latest release of this platform, verified by its signature"))
+	// the commands calling the running server
+	o.AddCommand(opts.New(&addCmd{}).Name("add").
+		Summary("Add magnets, .torrent files or their URLs to the running server"))
+	o.AddCommand(opts.New(&listCmd{}).Name("list").
+		Summary("List the tasks of the running server"))
+	o.AddCommand(opts.New(&taskCmd{action: "start"}).Name("start").
+		Summary("Start the tasks of the infohashes"))
+	o.AddCommand(opts.New(&taskCmd{action: "stop"}).Name("stop").
+		Summary("Stop the tasks of the infohashes"))
+	o.AddCommand(opts.New(&rmCmd{}).Name("rm").
+		Summary("Delete the tasks of the infohashes, undoable without --data"))
+	if p := o.Parse(); p.IsRunnable() {
+		p.RunFatal()
+		return
+	}
 
 	t := &server.TPLInfo{
 		Title:   s.Title,
@@ -41,6 +108,8 @@ func main() {
 	}
 
 	log.Print(t.GetInfo())
+	// after a restart on windows, the old process still holds the port
+	selfupdate.WaitParent()
 	if err := s.Run(t); err != nil {
 		if errors.Is(err, server.ErrDiskSpace) {
 			log.Println(err)
@@ -20,6 +20,15 @@ OSSUFFIX=""
 PKGCMD=
 CGO=1
 GO_LDFLAGS="-s -w -X main.VERSION=$GITVER"
+# UPDATE_SIGNKEY is the ed25519 private key (PEM) signing the tag, the name and
+# the checksum of the self-updates, the base64 UPDATE_PUBKEY verifying them
+# derived from it
+if [[ ! -z ${UPDATE_SIGNKEY} && -z ${UPDATE_PUBKEY} ]]; then
+	UPDATE_PUBKEY=$(openssl pkey -in ${UPDATE_SIGNKEY} -pubout -outform DER | tail -c 32 | base64)
+fi
+if [[ ! -z ${UPDATE_PUBKEY} ]]; then
+	GO_LDFLAGS="${GO_LDFLAGS} -X github.com/boypt/simple-torrent/common/selfupdate.PublicKey=${UPDATE_PUBKEY}"
+fi
 GO_TAGS=""
 
 for arg in "$@"; do
@@ -41,9 +50,6 @@ case $arg in
 		OS=darwin
 		ARCH=amd64
 		;;
-	xz)
-		PKGCMD=xz
-    	;;
 	gzip)
 		PKGCMD=gzip
 		;;
@@ -83,4 +89,19 @@ fi
 
 if [[ ! -z $PKGCMD ]]; then
   ${PKGCMD} -v -9 ${BINFILE}
+  BINFILE=${BINFILE}.gz
+fi
+
+# checked by the update command, the binaries without UPDATE_PUBKEY install
+# the unsigned releases only with --unsigned
+(cd $(dirname ${BINFILE}) && sha256sum $(basename ${BINFILE}) > $(basename ${BINFILE}).sha256)
+if [[ ! -z ${UPDATE_SIGNKEY} ]]; then
+  # the message of selfupdate.SignedMessage
+  printf "simple-torrent %s %s %s\n" "${GITVER}" "$(basename ${BINFILE})" "$(cut -d' ' -f1 ${BINFILE}.sha256)" > ${BINFILE}.signed
+  openssl pkeyutl -sign -inkey ${UPDATE_SIGNKEY} -rawin -in ${BINFILE}.signed -out ${BINFILE}.sha256.sig
+  rm -f ${BINFILE}.signed
+  if [[ ! -f ${BINFILE}.sha256.sig ]]; then
+    echo "Signing failed. Check with error message above."
+    exit 1
+  fi
 fi
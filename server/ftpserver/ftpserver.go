@@ -0,0 +1,130 @@
+// Package ftpserver is a read-only FTP server of a directory, with explicit
+// FTPS (AUTH TLS), for the players and tools not speaking HTTP.
+// spec: RFC 959, RFC 2228 (AUTH), RFC 2428 (EPSV/EPRT), RFC 3659 (SIZE/MDTM/REST)
+package ftpserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+)
+
+const (
+	idleTimeout = 5 * time.Minute
+	dataTimeout = 30 * time.Second
+	// failed logins before the connection is closed
+	maxLoginFails = 3
+)
+
+var log *logging.Logger
+
+var errNoDataConn = errors.New("no data connection, use PASV or PORT first")
+
+// Server serves the files under Root read only. Anyone may log in when Login
+// is nil.
+type Server struct {
+	// the root may change while running
+	Root  func() string
+	Login func(user, pass string) bool
+	// enables AUTH TLS when set
+	TLS *tls.Config
+	// range of the passive ports, any port when zero
+	PassiveMin, PassiveMax int
+	// names hidden from the listings and never served
+	Hidden func(name string) bool
+
+	mu       sync.Mutex
+	listener net.Listener
+	nextPort int
+}
+
+// ListenAndServe serves the connections on addr until Close
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	log.Println("listening at", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go newSession(s, conn).serve()
+	}
+}
+
+// Close stops accepting connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// ParsePortRange parses the passive ports in "<min>-<max>"
+func ParsePortRange(r string) (int, int, error) {
+	if r == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(r, "-", 2)
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q", r)
+		}
+	}
+	if min <= 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	return min, max, nil
+}
+
+// listenPassive opens the listener of a passive data connection on ip
+func (s *Server) listenPassive(ip net.IP) (net.Listener, error) {
+	if s.PassiveMin == 0 {
+		return net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	}
+	n := s.PassiveMax - s.PassiveMin + 1
+	s.mu.Lock()
+	start := s.nextPort
+	s.nextPort = (s.nextPort + 1) % n
+	s.mu.Unlock()
+	var err error
+	for i := 0; i < n; i++ {
+		port := s.PassiveMin + (start+i)%n
+		var l net.Listener
+		if l, err = net.Listen("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port))); err == nil {
+			return l, nil
+		}
+	}
+	return nil, err
+}
+
+func (s *Server) checkLogin(user, pass string) bool {
+	return s.Login == nil || s.Login(user, pass)
+}
+
+func (s *Server) hidden(name string) bool {
+	return s.Hidden != nil && s.Hidden(name)
+}
+
+func init() {
+	log = logging.New("ftp")
+}
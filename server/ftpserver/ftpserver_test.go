@@ -0,0 +1,155 @@
+package ftpserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_cleanPath(t *testing.T) {
+	tests := []struct {
+		cwd, arg, want string
+	}{
+		{"/", "", "/"},
+		{"/", "a/b", "/a/b"},
+		{"/a", "..", "/"},
+		{"/a", "../../..", "/"},
+		{"/a", "/b/../../c", "/c"},
+		{"/a/b", "./c/", "/a/b/c"},
+	}
+	for _, tt := range tests {
+		if got := cleanPath(tt.cwd, tt.arg); got != tt.want {
+			t.Errorf("cleanPath(%q, %q) = %q, want %q", tt.cwd, tt.arg, got, tt.want)
+		}
+	}
+}
+
+func Test_parsePort(t *testing.T) {
+	tests := []struct {
+		cmd, arg, want string
+		wantErr        bool
+	}{
+		{"PORT", "192,168,1,2,117,48", "192.168.1.2:30000", false},
+		{"EPRT", "|1|10.0.0.1|2121|", "10.0.0.1:2121", false},
+		{"EPRT", "|2|::1|2121|", "[::1]:2121", false},
+		{"PORT", "192,168,1,2,117", "", true},
+		{"PORT", "192,168,1,2,0,0", "", true},
+		{"EPRT", "|1|nowhere|21|", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parsePort(tt.cmd, tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePort(%s %s) error = %v", tt.cmd, tt.arg, err)
+			continue
+		}
+		if err == nil && got.String() != tt.want {
+			t.Errorf("parsePort(%s %s) = %s, want %s", tt.cmd, tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if min, max, err := ParsePortRange("30000-30009"); err != nil || min != 30000 || max != 30009 {
+		t.Errorf("ParsePortRange() = %d %d %v", min, max, err)
+	}
+	for _, r := range []string{"30009-30000", "0-10", "a-b", "1-70000"} {
+		if _, _, err := ParsePortRange(r); err == nil {
+			t.Errorf("ParsePortRange(%q) expecting error", r)
+		}
+	}
+}
+
+func TestServer_retrieve(t *testing.T) {
+	root, err := ioutil.TempDir("", "ftpserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := ioutil.WriteFile(filepath.Join(root, "movie.mkv"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, ".hidden"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Root:   func() string { return root },
+		Login:  func(user, pass string) bool { return user == "user" && pass == "pass" },
+		Hidden: func(name string) bool { return strings.HasPrefix(name, ".") },
+	}
+	s.listener = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go newSession(s, conn).serve()
+		}
+	}()
+	defer s.Close()
+
+	c, err := textproto.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	expect := func(code int, format string, args ...interface{}) string {
+		t.Helper()
+		if format != "" {
+			if err := c.PrintfLine(format, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, msg, err := c.ReadResponse(code)
+		if err != nil {
+			t.Fatalf("%s: %v", fmt.Sprintf(format, args...), err)
+		}
+		return msg
+	}
+	expect(220, "")
+	expect(530, "LIST")
+	expect(331, "USER user")
+	expect(230, "PASS pass")
+	expect(550, "CWD .hidden")
+	expect(550, "STOR movie.mkv")
+	expect(213, "SIZE movie.mkv")
+
+	retr := func(cmd string) string {
+		t.Helper()
+		msg := expect(229, "EPSV")
+		var port int
+		if _, err := fmt.Sscanf(msg[strings.Index(msg, "|||"):], "|||%d|)", &port); err != nil {
+			t.Fatal(msg, err)
+		}
+		dc, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(150, cmd)
+		data, err := ioutil.ReadAll(dc)
+		dc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(226, "")
+		return string(data)
+	}
+	if got := retr("NLST"); got != "movie.mkv\r\n" {
+		t.Errorf("NLST = %q", got)
+	}
+	expect(350, "REST 4")
+	if got := retr("RETR movie.mkv"); got != "456789" {
+		t.Errorf("RETR = %q", got)
+	}
+	expect(221, "QUIT")
+}
@@ -0,0 +1,533 @@
+package ftpserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errHidden = errors.New("no such file or directory")
+
+// session is a control connection
+type session struct {
+	s      *Server
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	remote net.IP
+
+	user   string
+	authed bool
+	fails  int
+	// the working directory, "/" is the root
+	cwd string
+	tls bool
+	// data connections in TLS
+	prot bool
+	// offset of the next RETR
+	rest int64
+	// passive listener, or the address given by PORT
+	pasv net.Listener
+	port string
+}
+
+func newSession(s *Server, c net.Conn) *session {
+	ss := &session{s: s, cwd: "/"}
+	ss.setConn(c)
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		ss.remote = addr.IP
+	}
+	return ss
+}
+
+func (ss *session) setConn(c net.Conn) {
+	ss.conn = c
+	ss.r = bufio.NewReader(c)
+	ss.w = bufio.NewWriter(c)
+}
+
+func (ss *session) serve() {
+	defer ss.close()
+	ss.reply(220, "simple-torrent FTP ready")
+	for {
+		ss.conn.SetReadDeadline(time.Now().Add(idleTimeout)) // nolint: errcheck
+		line, err := ss.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg := parseCommand(line)
+		if !ss.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+func (ss *session) close() {
+	ss.closeData()
+	ss.conn.Close()
+}
+
+func (ss *session) closeData() {
+	if ss.pasv != nil {
+		ss.pasv.Close()
+		ss.pasv = nil
+	}
+	ss.port = ""
+}
+
+func (ss *session) reply(code int, msg string) {
+	fmt.Fprintf(ss.w, "%d %s\r\n", code, msg)
+	ss.w.Flush()
+}
+
+// replyLines sends a multi-line reply, the lines between the first and the
+// last are indented
+func (ss *session) replyLines(code int, first string, lines []string, last string) {
+	fmt.Fprintf(ss.w, "%d-%s\r\n", code, first)
+	for _, l := range lines {
+		fmt.Fprintf(ss.w, " %s\r\n", l)
+	}
+	fmt.Fprintf(ss.w, "%d %s\r\n", code, last)
+	ss.w.Flush()
+}
+
+func parseCommand(line string) (string, string) {
+	line = strings.TrimRight(line, "\r\n")
+	parts := strings.SplitN(line, " ", 2)
+	cmd := strings.ToUpper(parts[0])
+	if len(parts) == 1 {
+		return cmd, ""
+	}
+	return cmd, parts[1]
+}
+
+// handle runs the command, false to close the connection
+func (ss *session) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		ss.user, ss.authed = arg, false
+		ss.reply(331, "Password required")
+		return true
+	case "PASS":
+		if ss.s.checkLogin(ss.user, arg) {
+			ss.authed = true
+			log.Printf("%s logged in as %q", ss.remote, ss.user)
+			ss.reply(230, "Logged in")
+			return true
+		}
+		ss.fails++
+		log.Warnf("%s failed login as %q", ss.remote, ss.user)
+		time.Sleep(time.Second)
+		ss.reply(530, "Login incorrect")
+		return ss.fails < maxLoginFails
+	case "AUTH":
+		return ss.handleAuth(arg)
+	case "PBSZ":
+		ss.reply(200, "PBSZ=0")
+		return true
+	case "PROT":
+		switch strings.ToUpper(arg) {
+		case "P":
+			if !ss.tls {
+				ss.reply(503, "AUTH TLS first")
+				return true
+			}
+			ss.prot = true
+		case "C":
+			ss.prot = false
+		default:
+			ss.reply(504, "Protection level not supported")
+			return true
+		}
+		ss.reply(200, "Protection level set")
+		return true
+	case "SYST":
+		ss.reply(215, "UNIX Type: L8")
+		return true
+	case "FEAT":
+		feats := []string{"UTF8", "SIZE", "MDTM", "REST STREAM", "PASV", "EPSV", "EPRT"}
+		if ss.s.TLS != nil {
+			feats = append(feats, "AUTH TLS", "PBSZ", "PROT")
+		}
+		ss.replyLines(211, "Features:", feats, "End")
+		return true
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			ss.reply(200, "UTF8 always on")
+		} else {
+			ss.reply(501, "Option not understood")
+		}
+		return true
+	case "NOOP":
+		ss.reply(200, "OK")
+		return true
+	case "QUIT":
+		ss.reply(221, "Goodbye")
+		return false
+	}
+
+	if !ss.authed {
+		ss.reply(530, "Please login with USER and PASS")
+		return true
+	}
+	switch cmd {
+	case "PWD", "XPWD":
+		ss.reply(257, `"`+strings.ReplaceAll(ss.cwd, `"`, `""`)+`" is the current directory`)
+	case "CWD", "XCWD":
+		ss.changeDir(arg)
+	case "CDUP", "XCUP":
+		ss.changeDir("..")
+	case "TYPE":
+		ss.reply(200, "Type set")
+	case "MODE":
+		if strings.EqualFold(arg, "S") {
+			ss.reply(200, "Mode set")
+		} else {
+			ss.reply(504, "Only stream mode")
+		}
+	case "STRU":
+		if strings.EqualFold(arg, "F") {
+			ss.reply(200, "Structure set")
+		} else {
+			ss.reply(504, "Only file structure")
+		}
+	case "PASV":
+		ss.handlePasv(false)
+	case "EPSV":
+		if strings.EqualFold(arg, "ALL") {
+			ss.reply(200, "EPSV ALL accepted")
+			return true
+		}
+		ss.handlePasv(true)
+	case "PORT", "EPRT":
+		ss.handlePort(cmd, arg)
+	case "REST":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			ss.reply(501, "Invalid offset")
+			return true
+		}
+		ss.rest = n
+		ss.reply(350, fmt.Sprintf("Restarting at %d", n))
+	case "SIZE", "MDTM":
+		_, fp, err := ss.resolve(arg)
+		var fi os.FileInfo
+		if err == nil {
+			fi, err = os.Stat(fp)
+		}
+		if err != nil || fi.IsDir() {
+			ss.reply(550, "Not a file")
+			return true
+		}
+		if cmd == "SIZE" {
+			ss.reply(213, strconv.FormatInt(fi.Size(), 10))
+		} else {
+			ss.reply(213, fi.ModTime().UTC().Format("20060102150405"))
+		}
+	case "LIST", "NLST":
+		ss.list(cmd == "NLST", arg)
+	case "RETR":
+		ss.retrieve(arg)
+	case "STOR", "STOU", "APPE", "DELE", "MKD", "XMKD", "RMD", "XRMD", "RNFR", "RNTO", "SITE":
+		ss.reply(550, "Permission denied, read only")
+	default:
+		ss.reply(502, "Command not implemented")
+	}
+	return true
+}
+
+func (ss *session) handleAuth(arg string) bool {
+	switch strings.ToUpper(arg) {
+	case "TLS", "TLS-C", "SSL":
+	default:
+		ss.reply(504, "Unsupported mechanism")
+		return true
+	}
+	if ss.s.TLS == nil {
+		ss.reply(502, "TLS not configured")
+		return true
+	}
+	if ss.tls {
+		ss.reply(503, "Already in TLS")
+		return true
+	}
+	ss.reply(234, "AUTH TLS successful")
+	tc := tls.Server(ss.conn, ss.s.TLS)
+	tc.SetDeadline(time.Now().Add(dataTimeout)) // nolint: errcheck
+	if err := tc.Handshake(); err != nil {
+		log.Warn(ss.remote, "TLS handshake", err)
+		return false
+	}
+	tc.SetDeadline(time.Time{}) // nolint: errcheck
+	ss.setConn(tc)
+	ss.tls = true
+	return true
+}
+
+func (ss *session) changeDir(arg string) {
+	vp, fp, err := ss.resolve(arg)
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = os.Stat(fp); err == nil && !fi.IsDir() {
+			err = errors.New("not a directory")
+		}
+	}
+	if err != nil {
+		ss.reply(550, "Not a directory")
+		return
+	}
+	ss.cwd = vp
+	ss.reply(250, "Directory changed to "+vp)
+}
+
+func (ss *session) handlePasv(extended bool) {
+	ss.closeData()
+	var ip net.IP
+	if addr, ok := ss.conn.LocalAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	if !extended && ip.To4() == nil {
+		ss.reply(425, "Use EPSV on IPv6")
+		return
+	}
+	l, err := ss.s.listenPassive(ip)
+	if err != nil {
+		ss.reply(425, "Can't open passive connection")
+		return
+	}
+	ss.pasv = l
+	port := l.Addr().(*net.TCPAddr).Port
+	if extended {
+		ss.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+	ip4 := ip.To4()
+	ss.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)",
+		ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff))
+}
+
+// handlePort takes the address of the active data connection, which must be
+// of the client
+func (ss *session) handlePort(cmd, arg string) {
+	ss.closeData()
+	addr, err := parsePort(cmd, arg)
+	if err != nil {
+		ss.reply(501, err.Error())
+		return
+	}
+	if !addr.IP.Equal(ss.remote) {
+		ss.reply(500, "Illegal PORT, not the client address")
+		return
+	}
+	ss.port = addr.String()
+	ss.reply(200, cmd+" command successful")
+}
+
+// parsePort parses the address of PORT h1,h2,h3,h4,p1,p2 or EPRT |1|h|p|
+func parsePort(cmd, arg string) (*net.TCPAddr, error) {
+	var ip net.IP
+	var port int
+	var err error
+	if cmd == "EPRT" {
+		if len(arg) < 2 {
+			return nil, errors.New("invalid EPRT")
+		}
+		parts := strings.Split(arg[1:len(arg)-1], arg[:1])
+		if len(parts) != 3 {
+			return nil, errors.New("invalid EPRT")
+		}
+		ip = net.ParseIP(parts[1])
+		port, err = strconv.Atoi(parts[2])
+	} else {
+		parts := strings.Split(arg, ",")
+		if len(parts) != 6 {
+			return nil, errors.New("invalid PORT")
+		}
+		ip = net.ParseIP(strings.Join(parts[:4], "."))
+		var hi, lo int
+		if hi, err = strconv.Atoi(parts[4]); err == nil {
+			lo, err = strconv.Atoi(parts[5])
+		}
+		port = hi<<8 | lo
+	}
+	if err != nil || ip == nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid %s", cmd)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// dataConn opens the data connection set by PASV or PORT
+func (ss *session) dataConn() (net.Conn, error) {
+	var c net.Conn
+	var err error
+	switch {
+	case ss.pasv != nil:
+		if tl, ok := ss.pasv.(*net.TCPListener); ok {
+			tl.SetDeadline(time.Now().Add(dataTimeout)) // nolint: errcheck
+		}
+		c, err = ss.pasv.Accept()
+		if err == nil {
+			// only the client connects to it
+			if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok && !addr.IP.Equal(ss.remote) {
+				c.Close()
+				err = errors.New("data connection not from the client")
+			}
+		}
+	case ss.port != "":
+		c, err = net.DialTimeout("tcp", ss.port, dataTimeout)
+	default:
+		err = errNoDataConn
+	}
+	ss.closeData()
+	if err != nil {
+		return nil, err
+	}
+	if ss.prot {
+		c = tls.Server(c, ss.s.TLS)
+	}
+	return c, nil
+}
+
+// resolve returns the path in the session and the one on disk of arg
+func (ss *session) resolve(arg string) (string, string, error) {
+	vp := cleanPath(ss.cwd, arg)
+	for _, name := range strings.Split(vp, "/") {
+		if name != "" && ss.s.hidden(name) {
+			return "", "", errHidden
+		}
+	}
+	return vp, filepath.Join(ss.s.Root(), filepath.FromSlash(vp)), nil
+}
+
+// cleanPath joins arg to cwd, never above the root
+func cleanPath(cwd, arg string) string {
+	if !strings.HasPrefix(arg, "/") {
+		arg = path.Join(cwd, arg)
+	}
+	return path.Clean("/" + arg)
+}
+
+func (ss *session) list(namesOnly bool, arg string) {
+	// the options of ls some clients send
+	fields := strings.Fields(arg)
+	arg = ""
+	for _, f := range fields {
+		if !strings.HasPrefix(f, "-") {
+			arg = f
+		}
+	}
+	_, fp, err := ss.resolve(arg)
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = os.Stat(fp)
+	}
+	if err != nil {
+		ss.reply(550, "No such file or directory")
+		return
+	}
+	entries := []os.FileInfo{fi}
+	if fi.IsDir() {
+		infos, err := ioutil.ReadDir(fp)
+		if err != nil {
+			ss.reply(550, "Can't read directory")
+			return
+		}
+		entries = entries[:0]
+		for _, e := range infos {
+			if ss.s.hidden(e.Name()) {
+				continue
+			}
+			// the targets of the links
+			if st, err := os.Stat(filepath.Join(fp, e.Name())); err == nil {
+				entries = append(entries, st)
+			}
+		}
+	}
+
+	ss.reply(150, "Opening data connection")
+	c, err := ss.dataConn()
+	if err != nil {
+		ss.reply(425, err.Error())
+		return
+	}
+	w := bufio.NewWriter(c)
+	now := time.Now()
+	for _, e := range entries {
+		if namesOnly {
+			fmt.Fprintf(w, "%s\r\n", e.Name())
+		} else {
+			fmt.Fprintf(w, "%s\r\n", listLine(e, now))
+		}
+	}
+	err = w.Flush()
+	c.Close()
+	if err != nil {
+		ss.reply(426, "Transfer aborted")
+		return
+	}
+	ss.reply(226, "Transfer complete")
+}
+
+// listLine formats fi as ls -l does
+func listLine(fi os.FileInfo, now time.Time) string {
+	mode := "-" + fi.Mode().Perm().String()[1:]
+	if fi.IsDir() {
+		mode = "d" + mode[1:]
+	}
+	stamp := fi.ModTime().Format("Jan _2 15:04")
+	if t := fi.ModTime(); now.Sub(t) > 180*24*time.Hour || t.After(now) {
+		stamp = t.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s", mode, fi.Size(), stamp, fi.Name())
+}
+
+func (ss *session) retrieve(arg string) {
+	offset := ss.rest
+	ss.rest = 0
+	_, fp, err := ss.resolve(arg)
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(fp)
+	}
+	if err != nil {
+		ss.reply(550, "No such file")
+		return
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		ss.reply(550, "Not a file")
+		return
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			ss.reply(551, "Can't restart at the offset")
+			return
+		}
+	}
+
+	ss.reply(150, "Opening data connection")
+	c, err := ss.dataConn()
+	if err != nil {
+		ss.reply(425, err.Error())
+		return
+	}
+	_, err = io.Copy(c, f)
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		ss.reply(426, "Transfer aborted")
+		return
+	}
+	ss.reply(226, "Transfer complete")
+}
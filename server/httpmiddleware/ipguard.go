@@ -0,0 +1,219 @@
+package httpmiddleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// the idle clients are forgotten after this, unless locked out
+const guardIdle = 10 * time.Minute
+
+// IPGuard limits the clients of the web server by their IP: the allowed and
+// denied networks, the requests a second and the failed logins. The client
+// IP is taken from X-Forwarded-For or X-Real-IP only from the trusted proxies.
+type IPGuard struct {
+	Allow, Deny []*net.IPNet
+	// the loopback and unix socket peers are always trusted
+	TrustedProxies []*net.IPNet
+	// requests a second of an IP in bursts of 5 seconds, 0 for no limit
+	Rate float64
+	// failed logins of an IP before it's refused for Lockout, 0 for no lockout
+	MaxFails int
+	Lockout  time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*guardClient
+	lastPrune time.Time
+}
+
+type guardClient struct {
+	limiter     *rate.Limiter
+	fails       int
+	lockedUntil time.Time
+	seen        time.Time
+}
+
+// ParseCIDRs parses the networks separated by commas, a single IP is a /32 or /128
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			ip := net.ParseIP(f)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", f)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(f)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client of the request, nil if unknown
+func (g *IPGuard) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if !g.trusted(peer) {
+		return peer
+	}
+	if xff := r.Header.Values(xForwardedFor); len(xff) > 0 {
+		if ip := g.forwardedFor(strings.Join(xff, ",")); ip != nil {
+			return ip
+		}
+	} else if rip := net.ParseIP(strings.TrimSpace(r.Header.Get(xRealIP))); rip != nil {
+		return rip
+	}
+	return peer
+}
+
+// trusted tells if the headers of the peer are trusted, the unix sockets
+// have no peer address
+func (g *IPGuard) trusted(peer net.IP) bool {
+	return peer == nil || peer.IsLoopback() || containsIP(g.TrustedProxies, peer)
+}
+
+// forwardedFor returns the client of X-Forwarded-For, the last address not of
+// a trusted proxy, as the ones before it are sent by the client
+func (g *IPGuard) forwardedFor(xff string) net.IP {
+	hops := strings.Split(xff, ",")
+	var ip net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !g.trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// Wrap refuses the requests of the clients not allowed, denied, over the
+// rate or locked out
+func (g *IPGuard) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := g.ClientIP(r)
+		if ip == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if (len(g.Allow) > 0 && !containsIP(g.Allow, ip)) || containsIP(g.Deny, ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if wait := g.admit(ip, time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds()+1)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// admit counts the request of ip, returns how long it has to wait if refused
+func (g *IPGuard) admit(ip net.IP, now time.Time) time.Duration {
+	if g.Rate <= 0 && g.MaxFails <= 0 {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := g.client(ip, now)
+	if now.Before(c.lockedUntil) {
+		return c.lockedUntil.Sub(now)
+	}
+	if c.limiter != nil && !c.limiter.AllowN(now, 1) {
+		return time.Second
+	}
+	return 0
+}
+
+// LoginFailed counts a failed login of the client, returns true when it's
+// locked out on MaxFails
+func (g *IPGuard) LoginFailed(r *http.Request) bool {
+	ip := g.ClientIP(r)
+	if g.MaxFails <= 0 || ip == nil {
+		return false
+	}
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := g.client(ip, now)
+	c.fails++
+	if c.fails >= g.MaxFails {
+		c.fails = 0
+		c.lockedUntil = now.Add(g.Lockout)
+		return true
+	}
+	return false
+}
+
+// LoginSucceeded clears the failed logins of the client
+func (g *IPGuard) LoginSucceeded(r *http.Request) {
+	ip := g.ClientIP(r)
+	if g.MaxFails <= 0 || ip == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.clients[ip.String()]; ok {
+		c.fails = 0
+	}
+}
+
+// client returns the state of ip, g locked
+func (g *IPGuard) client(ip net.IP, now time.Time) *guardClient {
+	if g.clients == nil {
+		g.clients = make(map[string]*guardClient)
+	}
+	if now.Sub(g.lastPrune) > time.Minute {
+		g.lastPrune = now
+		for k, c := range g.clients {
+			if now.Sub(c.seen) > guardIdle && now.After(c.lockedUntil) {
+				delete(g.clients, k)
+			}
+		}
+	}
+	c, ok := g.clients[ip.String()]
+	if !ok {
+		c = &guardClient{}
+		if g.Rate > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(g.Rate), int(g.Rate*5)+1)
+		}
+		g.clients[ip.String()] = c
+	}
+	c.seen = now
+	return c
+}
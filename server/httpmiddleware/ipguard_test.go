@@ -0,0 +1,95 @@
+package httpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIPGuard(t *testing.T) {
+	allow, err := ParseCIDRs("192.168.0.0/16, 10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, _ := ParseCIDRs("192.168.6.0/24")
+	proxies, _ := ParseCIDRs("172.17.0.2")
+	if _, err := ParseCIDRs("10.0.0.300"); err == nil {
+		t.Error("ParseCIDRs() invalid IP no error")
+	}
+	g := &IPGuard{Allow: allow, Deny: deny, TrustedProxies: proxies, Rate: 1, MaxFails: 2, Lockout: time.Minute}
+	h := g.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(remote, realIP string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		if realIP != "" {
+			r.Header.Set("X-Real-IP", realIP)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, tt := range []struct {
+		remote, realIP string
+		want           int
+	}{
+		{"10.0.0.1:5000", "", 200},
+		{"10.0.0.2:5000", "", 403},
+		{"192.168.6.7:5000", "", 403},
+		// the headers of the clients aren't trusted
+		{"8.8.8.8:5000", "10.0.0.1", 403},
+		{"172.17.0.2:5000", "192.168.1.1", 200},
+		{"127.0.0.1:5000", "192.168.6.1", 403},
+	} {
+		if got := serve(tt.remote, tt.realIP); got != tt.want {
+			t.Errorf("serve(%s, %q) = %d, want %d", tt.remote, tt.realIP, got, tt.want)
+		}
+	}
+
+	// a burst of 5 seconds at 1 a second
+	n := 0
+	for serve("192.168.2.2:5000", "") == 200 {
+		n++
+	}
+	if n != 6 {
+		t.Errorf("served %d of the burst", n)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.168.3.3:5000"
+	if g.LoginFailed(r) || !g.LoginFailed(r) {
+		t.Error("not locked out on MaxFails")
+	}
+	if got := serve(r.RemoteAddr, ""); got != http.StatusTooManyRequests {
+		t.Errorf("locked out served %d", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, _ := ParseCIDRs("172.17.0.0/24")
+	g := &IPGuard{TrustedProxies: proxies}
+	for _, tt := range []struct {
+		remote, xff, realIP, want string
+	}{
+		{"8.8.8.8:5000", "1.1.1.1", "2.2.2.2", "8.8.8.8"},
+		{"172.17.0.2:5000", "", "2.2.2.2", "2.2.2.2"},
+		// the client sends the addresses before the one appended by the proxy
+		{"172.17.0.2:5000", "10.0.0.1, 9.9.9.9", "", "9.9.9.9"},
+		{"172.17.0.2:5000", "10.0.0.1, 9.9.9.9, 172.17.0.3", "10.0.0.2", "9.9.9.9"},
+		{"172.17.0.2:5000", "172.17.0.4", "", "172.17.0.4"},
+		{"172.17.0.2:5000", "junk", "", "172.17.0.2"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := g.ClientIP(r).String(); got != tt.want {
+			t.Errorf("ClientIP(%s, %q, %q) = %s, want %s", tt.remote, tt.xff, tt.realIP, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// provider is a fake OpenID provider signing the tokens by key
+type provider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key}
+	mux := http.NewServeMux()
+	p.Server = httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": b64.EncodeToString(key.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "client" || pass != "secret" || r.FormValue("code") != "code" || r.FormValue("code_verifier") != "verifier" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, "k1", p.claims)})
+	})
+	return p
+}
+
+func (p *provider) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	b64 := base64.RawURLEncoding
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	body, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(hdr) + "." + b64.EncodeToString(body)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestProvider(t *testing.T) {
+	fp := newProvider(t)
+	defer fp.Close()
+	ctx := context.Background()
+	p, err := Discover(ctx, fp.URL, "client", "secret", "https://torrent.example.com/auth/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := p.AuthCodeURL("state", "nonce", "verifier"); u[:len(fp.URL)+10] != fp.URL+"/authorize" {
+		t.Errorf("AuthCodeURL() = %s", u)
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": fp.URL, "aud": []string{"client"}, "exp": time.Now().Add(time.Hour).Unix(),
+			"nonce": "nonce", "email": "me@example.com", "groups": []string{"media"},
+		}
+	}
+	fp.claims = valid()
+	c, err := p.Exchange(ctx, "code", "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Email != "me@example.com" || len(c.Groups) != 1 || c.Groups[0] != "media" {
+		t.Errorf("Exchange() = %+v", c)
+	}
+	if _, err := p.Exchange(ctx, "code", "other", "nonce"); err == nil {
+		t.Error("Exchange() with a wrong verifier")
+	}
+
+	tests := []struct {
+		name string
+		set  func(map[string]interface{})
+	}{
+		{"issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{"audience", func(c map[string]interface{}) { c["aud"] = "other" }},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }},
+	}
+	for _, tt := range tests {
+		claims := valid()
+		tt.set(claims)
+		if _, err := p.Verify(ctx, fp.sign(t, "k1", claims), "nonce"); err == nil {
+			t.Errorf("Verify() accepted a bad %s", tt.name)
+		}
+	}
+	if _, err := p.Verify(ctx, fp.sign(t, "k2", valid()), "nonce"); err != errUnknownKey {
+		t.Errorf("Verify() of an unknown key = %v", err)
+	}
+	tok := fp.sign(t, "k1", valid())
+	if _, err := p.Verify(ctx, tok[:len(tok)-4]+"AAAA", "nonce"); err == nil {
+		t.Error("Verify() accepted a bad signature")
+	}
+}
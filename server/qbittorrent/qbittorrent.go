@@ -0,0 +1,286 @@
+// Package qbittorrent implements a subset of the qBittorrent WebAPI v2 on
+// top of the engine, for tools that only speak qBittorrent (cross-seed, autobrr).
+// spec: https://github.com/qbittorrent/qBittorrent/wiki/WebUI-API-(qBittorrent-4.1)
+package qbittorrent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const (
+	// Prefix is the path the handler is mounted on
+	Prefix     = "/api/v2/"
+	appVersion = "v4.3.9"
+	apiVersion = "2.8.3"
+	// max size of the multipart form of torrents/add
+	maxAddMemory = 32 << 20
+	// the sessions unused this long are logged out, as qBittorrent does
+	sessionTimeout = time.Hour
+)
+
+// the calls that only read, the others change the tasks and take only POST
+var readOnly = map[string]bool{
+	"app/version": true, "app/webapiVersion": true, "app/defaultSavePath": true, "app/preferences": true,
+	"torrents/info": true, "torrents/properties": true, "torrents/files": true, "torrents/trackers": true,
+	"torrents/webseeds": true, "transfer/speedLimitsMode": true,
+}
+
+var log *logging.Logger
+
+type userKey struct{}
+
+// Handler serves /api/v2/. The calls other than auth/login take the SID
+// cookie of a login checked by the accounts of the server
+type Handler struct {
+	engine *engine.Engine
+	// checks the username and password of auth/login
+	login func(r *http.Request, name, pass string) bool
+	// checks the user logged in may change the task
+	checkOwner func(user, infohash string) error
+	// tells the engine the user logged in adding the magnet or torrent
+	presetOwner func(user string, data []byte) error
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// session is the login of a SID
+type session struct {
+	user string
+	// the last use
+	at time.Time
+}
+
+func New(e *engine.Engine, login func(r *http.Request, name, pass string) bool,
+	checkOwner func(user, infohash string) error, presetOwner func(user string, data []byte) error) *Handler {
+	return &Handler{
+		engine:      e,
+		login:       login,
+		checkOwner:  checkOwner,
+		presetOwner: presetOwner,
+		sessions:    make(map[string]*session),
+	}
+}
+
+// newSession returns the SID of a new session of the user
+func (h *Handler) newSession(user string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	sid := hex.EncodeToString(b)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for sid, s := range h.sessions {
+		if now.Sub(s.at) > sessionTimeout {
+			delete(h.sessions, sid)
+		}
+	}
+	h.sessions[sid] = &session{user: user, at: now}
+	return sid
+}
+
+// session returns the user of the SID session of the request, refreshing it
+func (h *Handler) session(r *http.Request) (string, bool) {
+	c, err := r.Cookie("SID")
+	if err != nil {
+		return "", false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[c.Value]
+	if !ok || time.Since(s.at) > sessionTimeout {
+		delete(h.sessions, c.Value)
+		return "", false
+	}
+	s.at = time.Now()
+	return s.user, true
+}
+
+// requestUser returns the user of the session of the request
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+func (h *Handler) logout(r *http.Request) {
+	if c, err := r.Cookie("SID"); err == nil {
+		h.mu.Lock()
+		delete(h.sessions, c.Value)
+		h.mu.Unlock()
+	}
+}
+
+// sameOrigin tells if the Origin, or else the Referer, of the request is
+// its host, as qBittorrent checks against CSRF. The requests of the tools
+// have neither
+func sameOrigin(r *http.Request) bool {
+	from := r.Header.Get("Origin")
+	if from == "" {
+		from = r.Header.Get("Referer")
+	}
+	if from == "" {
+		return true
+	}
+	u, err := url.Parse(from)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	call := strings.TrimPrefix(r.URL.Path, Prefix)
+	if !sameOrigin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !readOnly[call] && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if call != "auth/login" {
+		user, ok := h.session(r)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+	}
+	var err error
+	switch call {
+	case "auth/login":
+		if !h.login(r, r.FormValue("username"), r.FormValue("password")) {
+			log.Warn("failed login of", r.FormValue("username"), r.RemoteAddr)
+			writeText(w, "Fails.")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: h.newSession(r.FormValue("username")), Path: "/", HttpOnly: true,
+			SameSite: http.SameSiteStrictMode})
+		writeText(w, "Ok.")
+	case "auth/logout":
+		h.logout(r)
+		w.WriteHeader(http.StatusOK)
+	case "app/version":
+		writeText(w, appVersion)
+	case "app/webapiVersion":
+		writeText(w, apiVersion)
+	case "app/defaultSavePath":
+		writeText(w, h.engine.Config().DownloadDirectory)
+	case "app/preferences":
+		writeJSON(w, h.preferences())
+	case "torrents/info":
+		writeJSON(w, h.torrentsInfo(r))
+	case "torrents/properties":
+		err = h.torrentProperties(w, r)
+	case "torrents/files":
+		err = h.torrentFiles(w, r)
+	case "torrents/add":
+		err = h.torrentsAdd(r)
+	case "torrents/pause":
+		err = h.forEach(r, h.engine.StopTorrent)
+	case "torrents/resume":
+		err = h.forEach(r, h.engine.ManualStartTorrent)
+	case "torrents/recheck":
+		err = h.forEach(r, h.engine.VerifyTorrent)
+	case "torrents/delete":
+		err = h.torrentsDelete(r)
+	case "torrents/trackers":
+		err = h.torrentTrackers(w, r)
+	case "torrents/addTrackers":
+		err = h.forEach(r, func(ih string) error {
+			return h.engine.AddTorrentTrackers(ih, [][]string{strings.Split(r.FormValue("urls"), "\n")})
+		})
+	case "torrents/removeTrackers":
+		err = h.forEach(r, func(ih string) error {
+			return h.engine.RemoveTorrentTrackers(ih, strings.Split(r.FormValue("urls"), "|"))
+		})
+	case "torrents/webseeds":
+		err = h.torrentWebSeeds(w, r)
+	case "torrents/addWebSeeds":
+		err = h.forEach(r, func(ih string) error {
+			return h.engine.AddTorrentWebSeeds(ih, strings.Split(r.FormValue("urls"), "|"))
+		})
+	case "torrents/reannounce":
+		err = h.forEach(r, h.engine.ReannounceTorrent)
+	case "transfer/speedLimitsMode":
+		if h.altRateActive() {
+			writeText(w, "1")
+		} else {
+			writeText(w, "0")
+		}
+	case "transfer/toggleSpeedLimitsMode":
+		mode := engine.AltRateOn
+		if h.altRateActive() {
+			mode = engine.AltRateOff
+		}
+		err = h.engine.SetAltRateMode(mode)
+	case "torrents/setCategory":
+		err = h.forEach(r, func(ih string) error {
+			return h.engine.SetTorrentLabel(ih, r.FormValue("category"))
+		})
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		log.Warn(r.URL.Path, err)
+		status := http.StatusBadRequest
+		if err == errNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+	}
+}
+
+func (h *Handler) altRateActive() bool {
+	a := h.engine.AltRate()
+	a.Lock()
+	defer a.Unlock()
+	return a.Active
+}
+
+func writeText(w http.ResponseWriter, s string) {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	if _, err := w.Write([]byte(s)); err != nil {
+		log.Warn("write response", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("encode response", err)
+	}
+}
+
+func (h *Handler) preferences() map[string]interface{} {
+	c := h.engine.Config()
+	return map[string]interface{}{
+		"save_path":            c.DownloadDirectory,
+		"listen_port":          c.IncomingPort,
+		"max_active_torrents":  c.MaxConcurrentTask,
+		"max_active_downloads": c.MaxActiveDownloads,
+		"max_active_uploads":   c.MaxActiveSeeds,
+		"queueing_enabled":     c.QueueEnabled(),
+		"max_ratio_enabled":    c.SeedRatio > 0,
+		"max_ratio":            c.SeedRatio,
+		"dht":                  true,
+	}
+}
+
+func init() {
+	log = logging.New("qbittorrent")
+}
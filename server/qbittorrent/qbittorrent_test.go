@@ -0,0 +1,94 @@
+package qbittorrent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func serve(h *Handler, method, call string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "http://torrent.local"+Prefix+call, strings.NewReader(form.Encode()))
+	if method == http.MethodPost {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	h := New(nil, func(r *http.Request, name, pass string) bool {
+		return name == "admin" && pass == "secret"
+	}, nil, nil)
+
+	if w := serve(h, http.MethodPost, "auth/login", url.Values{"username": {"admin"}, "password": {"wrong"}}, nil); w.Body.String() != "Fails." {
+		t.Errorf("wrong password: %s", w.Body)
+	}
+	if w := serve(h, http.MethodGet, "app/version", nil, nil); w.Code != http.StatusForbidden {
+		t.Errorf("without a session: %d", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "auth/login", url.Values{"username": {"admin"}, "password": {"secret"}}, nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("login by GET: %d", w.Code)
+	}
+
+	w := serve(h, http.MethodPost, "auth/login", url.Values{"username": {"admin"}, "password": {"secret"}}, nil)
+	if w.Body.String() != "Ok." || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("login: %s", w.Body)
+	}
+	cookie := http.Header{"Cookie": {w.Result().Cookies()[0].String()}}
+	if w := serve(h, http.MethodGet, "app/version", nil, cookie); w.Code != http.StatusOK || w.Body.String() != appVersion {
+		t.Errorf("with the session: %d %s", w.Code, w.Body)
+	}
+	// the changes take only POST
+	if w := serve(h, http.MethodGet, "torrents/delete", url.Values{"hashes": {"all"}}, cookie); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("delete by GET: %d", w.Code)
+	}
+	// another site posting with the cookie of the browser
+	cross := http.Header{"Cookie": cookie["Cookie"], "Origin": {"https://evil.example"}}
+	if w := serve(h, http.MethodPost, "torrents/delete", url.Values{"hashes": {"all"}}, cross); w.Code != http.StatusUnauthorized {
+		t.Errorf("cross-site delete: %d", w.Code)
+	}
+	cross = http.Header{"Cookie": cookie["Cookie"], "Referer": {"https://evil.example/page"}}
+	if w := serve(h, http.MethodPost, "torrents/pause", url.Values{"hashes": {"all"}}, cross); w.Code != http.StatusUnauthorized {
+		t.Errorf("cross-site pause: %d", w.Code)
+	}
+	same := http.Header{"Cookie": cookie["Cookie"], "Origin": {"http://torrent.local"}}
+	if w := serve(h, http.MethodGet, "app/webapiVersion", nil, same); w.Code != http.StatusOK {
+		t.Errorf("same origin: %d", w.Code)
+	}
+
+	serve(h, http.MethodPost, "auth/logout", nil, cookie)
+	if w := serve(h, http.MethodGet, "app/version", nil, cookie); w.Code != http.StatusForbidden {
+		t.Errorf("after logout: %d", w.Code)
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	var presetBy string
+	h := New(nil, func(r *http.Request, name, pass string) bool {
+		return pass == "secret"
+	}, nil, func(user string, data []byte) error {
+		presetBy = user
+		return errors.New("forbidden")
+	})
+
+	w := serve(h, http.MethodPost, "auth/login", url.Values{"username": {"alice"}, "password": {"secret"}}, nil)
+	if w.Body.String() != "Ok." {
+		t.Fatalf("login: %s", w.Body)
+	}
+	cookie := http.Header{"Cookie": {w.Result().Cookies()[0].String()}}
+	magnet := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"
+	w = serve(h, http.MethodPost, "torrents/add", url.Values{"urls": {magnet}}, cookie)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("add refused for the user: %d %s", w.Code, w.Body)
+	}
+	if presetBy != "alice" {
+		t.Errorf("added as %q, want alice", presetBy)
+	}
+}
@@ -0,0 +1,437 @@
+package qbittorrent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+var errNotFound = errors.New("torrent hash was not found")
+
+// torrentInfo is the item of torrents/info
+type torrentInfo struct {
+	Hash         string  `json:"hash"`
+	Name         string  `json:"name"`
+	MagnetURI    string  `json:"magnet_uri"`
+	Size         int64   `json:"size"`
+	TotalSize    int64   `json:"total_size"`
+	Progress     float32 `json:"progress"`
+	Downloaded   int64   `json:"downloaded"`
+	Uploaded     int64   `json:"uploaded"`
+	AmountLeft   int64   `json:"amount_left"`
+	Completed    int64   `json:"completed"`
+	DlSpeed      int64   `json:"dlspeed"`
+	UpSpeed      int64   `json:"upspeed"`
+	Ratio        float32 `json:"ratio"`
+	Eta          int64   `json:"eta"`
+	State        string  `json:"state"`
+	NumSeeds     int     `json:"num_seeds"`
+	NumLeechs    int     `json:"num_leechs"`
+	SwarmSeeds   int     `json:"num_complete"`
+	SwarmLeechs  int     `json:"num_incomplete"`
+	AddedOn      int64   `json:"added_on"`
+	CompletionOn int64   `json:"completion_on"`
+	SavePath     string  `json:"save_path"`
+	ContentPath  string  `json:"content_path"`
+	Category     string  `json:"category"`
+	Tags         string  `json:"tags"`
+	Tracker      string  `json:"tracker"`
+}
+
+// eta of the spec when unknown
+const etaInfinity = 8640000
+
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func state(t *engine.Torrent) string {
+	switch {
+	case t.IsQueueing:
+		return "queuedDL"
+	case t.MetadataTimeout:
+		return "error"
+	case !t.Loaded:
+		return "metaDL"
+	case t.Verifying && t.Done:
+		return "checkingUP"
+	case t.Verifying:
+		return "checkingDL"
+	case !t.Started && t.Done:
+		return "pausedUP"
+	case !t.Started:
+		return "pausedDL"
+	case t.Done && t.UploadRate > 0:
+		return "uploading"
+	case t.Done:
+		return "stalledUP"
+	case t.DownloadRate > 0:
+		return "downloading"
+	}
+	return "stalledDL"
+}
+
+func info(t *engine.Torrent, dldir string) *torrentInfo {
+	t.Lock()
+	defer t.Unlock()
+
+	left := t.Size - t.Downloaded
+	if left < 0 {
+		left = 0
+	}
+	eta := int64(etaInfinity)
+	if left == 0 {
+		eta = 0
+	} else if t.DownloadRate > 0 {
+		eta = int64(float32(left) / t.DownloadRate)
+	}
+	if t.DownloadDir != "" {
+		dldir = t.DownloadDir
+	}
+	var seeds, leechs int
+	if t.Stats != nil {
+		seeds = t.Stats.ConnectedSeeders
+		leechs = t.Stats.ActivePeers - seeds
+	}
+
+	return &torrentInfo{
+		Hash:         t.InfoHash,
+		Name:         t.Name,
+		MagnetURI:    t.Magnet,
+		Size:         t.Size,
+		TotalSize:    t.Size,
+		Progress:     t.Percent / 100,
+		Downloaded:   t.Downloaded,
+		Uploaded:     t.Uploaded,
+		AmountLeft:   left,
+		Completed:    t.Downloaded,
+		DlSpeed:      int64(t.DownloadRate),
+		UpSpeed:      int64(t.UploadRate),
+		Ratio:        t.SeedRatio,
+		Eta:          eta,
+		State:        state(t),
+		NumSeeds:     seeds,
+		NumLeechs:    leechs,
+		SwarmSeeds:   t.SwarmSeeders,
+		SwarmLeechs:  t.SwarmLeechers,
+		AddedOn:      unixTime(t.AddedAt),
+		CompletionOn: unixTime(t.FinishedAt),
+		SavePath:     dldir,
+		ContentPath:  filepath.Join(dldir, t.Name),
+		Category:     t.Label,
+		Tags:         strings.Join(t.Tags, ","),
+	}
+}
+
+// selected returns the torrents of the "hashes" param (| separated, or
+// "all"), every torrent if the param is missing
+func (h *Handler) selected(hashes string) []*engine.Torrent {
+	ts := h.engine.Torrents()
+
+	var list []*engine.Torrent
+	if hashes == "" || hashes == "all" {
+		for _, t := range ts {
+			list = append(list, t)
+		}
+	} else {
+		for _, ih := range strings.Split(hashes, "|") {
+			if t, ok := ts[strings.ToLower(ih)]; ok {
+				list = append(list, t)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].AddedAt.Before(list[j].AddedAt)
+	})
+	return list
+}
+
+func matchFilter(filter string, i *torrentInfo) bool {
+	switch filter {
+	case "", "all":
+		return true
+	case "downloading":
+		return strings.HasSuffix(i.State, "DL") || i.State == "downloading"
+	case "seeding", "completed":
+		return strings.HasSuffix(i.State, "UP") || i.State == "uploading"
+	case "paused":
+		return strings.HasPrefix(i.State, "paused")
+	case "resumed", "active":
+		return !strings.HasPrefix(i.State, "paused")
+	case "stalled":
+		return strings.HasPrefix(i.State, "stalled")
+	case "errored":
+		return i.State == "error"
+	}
+	return false
+}
+
+func (h *Handler) torrentsInfo(r *http.Request) []*torrentInfo {
+	q := r.URL.Query()
+	dldir := h.engine.Config().DownloadDirectory
+	list := []*torrentInfo{}
+	for _, t := range h.selected(q.Get("hashes")) {
+		i := info(t, dldir)
+		// an absent category matches all, an empty one the unlabeled
+		if _, ok := q["category"]; ok && i.Category != q.Get("category") {
+			continue
+		}
+		if matchFilter(q.Get("filter"), i) {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+func (h *Handler) findTorrent(r *http.Request) (*engine.Torrent, error) {
+	hash := r.FormValue("hash")
+	if hash == "" || hash == "all" {
+		return nil, errNotFound
+	}
+	ts := h.selected(hash)
+	if len(ts) == 0 {
+		return nil, errNotFound
+	}
+	return ts[0], nil
+}
+
+func (h *Handler) torrentProperties(w http.ResponseWriter, r *http.Request) error {
+	t, err := h.findTorrent(r)
+	if err != nil {
+		return err
+	}
+	i := info(t, h.engine.Config().DownloadDirectory)
+	writeJSON(w, map[string]interface{}{
+		"save_path":            i.SavePath,
+		"total_size":           i.TotalSize,
+		"total_downloaded":     i.Downloaded,
+		"total_uploaded":       i.Uploaded,
+		"dl_speed":             i.DlSpeed,
+		"up_speed":             i.UpSpeed,
+		"share_ratio":          i.Ratio,
+		"eta":                  i.Eta,
+		"seeds":                i.NumSeeds,
+		"peers":                i.NumLeechs,
+		"seeds_total":          i.SwarmSeeds,
+		"peers_total":          i.SwarmLeechs,
+		"addition_date":        i.AddedOn,
+		"completion_date":      i.CompletionOn,
+		"pieces_have":          -1,
+		"piece_size":           -1,
+		"time_elapsed":         time.Now().Unix() - i.AddedOn,
+		"creation_date":        -1,
+		"comment":              "",
+		"is_private":           false,
+		"download_path":        "",
+		"infohash_v1":          i.Hash,
+		"infohash_v2":          "",
+		"total_wasted":         0,
+		"nb_connections":       i.NumSeeds + i.NumLeechs,
+		"nb_connections_limit": -1,
+	})
+	return nil
+}
+
+func (h *Handler) torrentFiles(w http.ResponseWriter, r *http.Request) error {
+	t, err := h.findTorrent(r)
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	defer t.Unlock()
+	files := []map[string]interface{}{}
+	for i, f := range t.Files {
+		if f == nil {
+			continue
+		}
+		priority := 0
+		if f.Started {
+			priority = 1
+		}
+		files = append(files, map[string]interface{}{
+			"index":    i,
+			"name":     f.Path,
+			"size":     f.Size,
+			"progress": f.Percent / 100,
+			"priority": priority,
+		})
+	}
+	writeJSON(w, files)
+	return nil
+}
+
+func (h *Handler) torrentTrackers(w http.ResponseWriter, r *http.Request) error {
+	t, err := h.findTorrent(r)
+	if err != nil {
+		return err
+	}
+	list, err := h.engine.TorrentTrackerStatus(t.InfoHash)
+	if err != nil {
+		return err
+	}
+	trackers := []map[string]interface{}{}
+	for _, ts := range list {
+		// 1 not contacted yet, 2 working, 4 not working
+		status := 1
+		switch {
+		case ts.Working:
+			status = 2
+		case ts.Error != "":
+			status = 4
+		}
+		trackers = append(trackers, map[string]interface{}{
+			"url":            ts.URL,
+			"tier":           ts.Tier,
+			"status":         status,
+			"msg":            ts.Error,
+			"num_peers":      ts.Peers,
+			"num_seeds":      ts.Seeders,
+			"num_leeches":    ts.Leechers,
+			"num_downloaded": ts.Downloaded,
+		})
+	}
+	writeJSON(w, trackers)
+	return nil
+}
+
+func (h *Handler) torrentWebSeeds(w http.ResponseWriter, r *http.Request) error {
+	t, err := h.findTorrent(r)
+	if err != nil {
+		return err
+	}
+	list, err := h.engine.TorrentWebSeeds(t.InfoHash)
+	if err != nil {
+		return err
+	}
+	seeds := []map[string]interface{}{}
+	for _, ws := range list {
+		seeds = append(seeds, map[string]interface{}{"url": ws.URL})
+	}
+	writeJSON(w, seeds)
+	return nil
+}
+
+func (h *Handler) torrentsAdd(r *http.Request) error {
+	if err := r.ParseMultipartForm(maxAddMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+
+	dir := r.FormValue("savepath")
+	var added int
+	for _, u := range strings.Split(r.FormValue("urls"), "\n") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if err := h.addURL(r, u, dir); err != nil && !isAdded(err) {
+			return err
+		}
+		added++
+	}
+
+	if r.MultipartForm != nil {
+		for _, fh := range r.MultipartForm.File["torrents"] {
+			f, err := fh.Open()
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			if err := h.addTorrent(r, data, dir); err != nil && !isAdded(err) {
+				return err
+			}
+			added++
+		}
+	}
+
+	if added == 0 {
+		return errors.New("no torrent added")
+	}
+	return nil
+}
+
+// isAdded tells whether the task is queued or already added, not a failure
+func isAdded(err error) bool {
+	return errors.Is(err, engine.ErrMaxConnTasks) || errors.Is(err, engine.ErrTaskExists)
+}
+
+func (h *Handler) addURL(r *http.Request, u, dir string) error {
+	// qBittorrent takes the bare infohashes too
+	if m, ok := engine.MagnetOfInfoHash(u); ok {
+		u = m
+	}
+	if strings.HasPrefix(u, "magnet:") {
+		if err := h.presetOwner(requestUser(r), []byte(u)); err != nil {
+			return err
+		}
+		return h.engine.NewMagnet(u, dir)
+	}
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return fmt.Errorf("unsupported url %s", u)
+	}
+	data, err := common.FetchTorrentFile(u)
+	if err != nil {
+		return err
+	}
+	return h.addTorrent(r, data, dir)
+}
+
+// addTorrent adds the .torrent as the user of the session
+func (h *Handler) addTorrent(r *http.Request, data []byte, dir string) error {
+	if err := h.presetOwner(requestUser(r), data); err != nil {
+		return err
+	}
+	return h.engine.NewTorrentByReader(bytes.NewReader(data), dir)
+}
+
+// forEach applies fn to the torrents of the hashes, the ones of the other
+// users are skipped
+func (h *Handler) forEach(r *http.Request, fn func(string) error) error {
+	hashes := r.FormValue("hashes")
+	if hashes == "" {
+		return errors.New("missing hashes")
+	}
+	user := requestUser(r)
+	for _, t := range h.selected(hashes) {
+		if err := h.checkOwner(user, t.InfoHash); err != nil {
+			log.Warn(t.InfoHash, err)
+			continue
+		}
+		if err := fn(t.InfoHash); err != nil {
+			log.Warn(t.InfoHash, err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) torrentsDelete(r *http.Request) error {
+	deleteFiles := r.FormValue("deleteFiles") == "true"
+	return h.forEach(r, func(ih string) error {
+		dataPath := h.engine.TorrentDataPath(ih)
+		if err := h.engine.DeleteTorrent(ih); err != nil {
+			return err
+		}
+		h.engine.RemoveCache(ih)
+		if deleteFiles && dataPath != "" {
+			log.Println("removing data", dataPath)
+			return os.RemoveAll(dataPath)
+		}
+		return nil
+	})
+}
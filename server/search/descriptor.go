@@ -0,0 +1,361 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// the descriptors of the providers parsed as HTML pages, the default
+	TypeHTML = "html"
+	// the descriptors of the providers parsed as JSON APIs
+	TypeJSON = "json"
+	// the most read of a response of a JSON provider (4MB)
+	maxJSONSize = 4 << 20
+)
+
+var templateRe = regexp.MustCompile(`\{\{\s*(\w+)\s*(:(\w+))?\s*\}\}`)
+
+// Descriptor defines an external provider, in a .json, .yaml or .yml file
+type Descriptor struct {
+	// the name of the file without its extension by default
+	ID   string `json:"id" yaml:"id"`
+	Name string `json:"name" yaml:"name"`
+	// html or json
+	Type string `json:"type" yaml:"type"`
+	// the search URL with {{query}} and {{page}}, {{page:0}} counts the pages
+	// from 0
+	URL     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+	// the CSS selector of the results, or the JSON path of their array
+	List string `json:"list,omitempty" yaml:"list"`
+	// the fields of a result by the CSS selectors and extractors of the
+	// scraper, or by the JSON paths in a result
+	Result map[string]interface{} `json:"result" yaml:"result"`
+	// looks up the pages of the results without a magnet
+	Item *ItemDescriptor `json:"item,omitempty" yaml:"item"`
+}
+
+// ItemDescriptor looks up the page of a result, {{item}} in its URL replaced
+// by the path of the result
+type ItemDescriptor struct {
+	URL    string                 `json:"url" yaml:"url"`
+	Result map[string]interface{} `json:"result" yaml:"result"`
+}
+
+// ParseDescriptor parses the descriptor of the file name, JSON or YAML by its
+// extension
+func ParseDescriptor(name string, data []byte) (*Descriptor, error) {
+	d := &Descriptor{}
+	var err error
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".json":
+		err = json.Unmarshal(data, d)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, d)
+		if err == nil {
+			d.Result = stringKeys(d.Result)
+			if d.Item != nil {
+				d.Item.Result = stringKeys(d.Item.Result)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s: not a .json, .yaml or .yml descriptor", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if d.ID == "" {
+		d.ID = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	}
+	if d.Name == "" {
+		d.Name = d.ID
+	}
+	if d.Type == "" {
+		d.Type = TypeHTML
+	}
+	if err := d.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// stringKeys makes the maps of the YAML marshalable to JSON
+func stringKeys(m map[string]interface{}) map[string]interface{} {
+	for k, v := range m {
+		m[k] = stringKeysOf(v)
+	}
+	return m
+}
+
+func stringKeysOf(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, mv := range v {
+			m[fmt.Sprint(k)] = stringKeysOf(mv)
+		}
+		return m
+	case []interface{}:
+		for i, lv := range v {
+			v[i] = stringKeysOf(lv)
+		}
+	}
+	return v
+}
+
+func (d *Descriptor) check() error {
+	if strings.ContainsAny(d.ID, "/?#") || strings.HasPrefix(d.ID, KindPlugin+":") {
+		return fmt.Errorf("Invalid id %q", d.ID)
+	}
+	if d.Type != TypeHTML && d.Type != TypeJSON {
+		return fmt.Errorf("Invalid type %q", d.Type)
+	}
+	if u, err := url.Parse(d.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid url %q", d.URL)
+	}
+	if !strings.Contains(d.URL, "{{query}}") {
+		return fmt.Errorf("Invalid url %q: no {{query}}", d.URL)
+	}
+	if len(d.Result) == 0 {
+		return fmt.Errorf("no result fields")
+	}
+	if d.Item != nil && (d.Item.URL == "" || len(d.Item.Result) == 0) {
+		return fmt.Errorf("Invalid item: no url or result fields")
+	}
+	return nil
+}
+
+// NewProvider returns the provider of the descriptor, source is its file
+func NewProvider(d *Descriptor, source string, headers map[string]string, debug bool) (Provider, error) {
+	hs := make(map[string]string)
+	for k, v := range headers {
+		hs[k] = v
+	}
+	for k, v := range d.Headers {
+		hs[k] = v
+	}
+	if d.Type == TypeJSON {
+		return &jsonProvider{d: d, source: source, headers: hs,
+			client: &http.Client{Timeout: searchTimeout}}, nil
+	}
+	// the HTML providers are scraper endpoints
+	config := map[string]interface{}{
+		d.ID: map[string]interface{}{
+			"name": d.Name, "url": d.URL, "headers": hs, "list": d.List, "result": d.Result,
+		},
+	}
+	if d.Item != nil {
+		config[d.ID+itemSuffix] = map[string]interface{}{
+			"name": d.Name + itemSuffix, "url": d.Item.URL, "headers": hs, "result": d.Item.Result,
+		}
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := ScraperProviders(KindExternal, source, b, nil, debug)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return ps[0], nil
+}
+
+// LoadDescriptors returns the providers of the descriptors in dir, the
+// errors of the files skipped
+func LoadDescriptors(dir string, headers map[string]string, debug bool) ([]Provider, []error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, []error{err}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	var ps []Provider
+	var errs []error
+	for _, fi := range entries {
+		switch strings.ToLower(filepath.Ext(fi.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		if fi.IsDir() {
+			continue
+		}
+		fn := filepath.Join(dir, fi.Name())
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		d, err := ParseDescriptor(fn, data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p, err := NewProvider(d, fn, headers, debug)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ps = append(ps, p)
+	}
+	return ps, errs
+}
+
+// jsonProvider searches a JSON API by the paths of gjson
+type jsonProvider struct {
+	d       *Descriptor
+	source  string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (p *jsonProvider) Info() Info {
+	return Info{
+		ID:     p.d.ID,
+		Name:   p.d.Name,
+		URL:    p.d.URL,
+		Kind:   KindExternal,
+		Source: p.source,
+		Item:   p.d.Item != nil,
+	}
+}
+
+func (p *jsonProvider) Search(ctx context.Context, query string, page int) ([]Result, error) {
+	if page < 1 {
+		page = 1
+	}
+	if strings.Contains(p.d.URL, "{{page:0}}") {
+		page--
+	}
+	u, err := expand(p.d.URL, map[string]string{"query": query, "page": strconv.Itoa(page)})
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	var items []gjson.Result
+	if p.d.List == "" {
+		items = []gjson.Result{gjson.Parse(body)}
+	} else {
+		list := gjson.Get(body, p.d.List)
+		if !list.Exists() {
+			// no results
+			return nil, nil
+		}
+		if !list.IsArray() {
+			return nil, fmt.Errorf("%s is not an array", p.d.List)
+		}
+		items = list.Array()
+	}
+	results := make([]Result, 0, len(items))
+	for _, item := range items {
+		if r := extractJSON(item, p.d.Result); r["name"] != "" {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func (p *jsonProvider) Item(ctx context.Context, path string) (Result, error) {
+	if p.d.Item == nil {
+		return nil, ErrNoItem
+	}
+	u, err := expand(p.d.Item.URL, map[string]string{"item": path})
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return extractJSON(gjson.Parse(body), p.d.Item.Result), nil
+}
+
+func (p *jsonProvider) get(ctx context.Context, u string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Status: %d", resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxJSONSize))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// extractJSON returns the fields of the item by their paths, a path given
+// as a list is joined by dots as the scraper does
+func extractJSON(item gjson.Result, fields map[string]interface{}) Result {
+	r := Result{}
+	for field, path := range fields {
+		var p string
+		switch path := path.(type) {
+		case string:
+			p = path
+		case []interface{}:
+			var parts []string
+			for _, part := range path {
+				parts = append(parts, fmt.Sprint(part))
+			}
+			p = strings.Join(parts, ".")
+		default:
+			continue
+		}
+		if v := item.Get(p); v.Exists() && v.String() != "" {
+			r[field] = v.String()
+		}
+	}
+	return r
+}
+
+// expand replaces the {{param}} and {{param:default}} of the URL template,
+// escaping the params in its query, and in its path but the item path
+func expand(tpl string, params map[string]string) (string, error) {
+	var err error
+	queryi := strings.Index(tpl, "?")
+	out := templateRe.ReplaceAllStringFunc(tpl, func(key string) string {
+		m := templateRe.FindStringSubmatch(key)
+		value, ok := params[m[1]]
+		if !ok {
+			if m[3] == "" {
+				err = fmt.Errorf("Missing param: %s", m[1])
+			}
+			value = m[3]
+		}
+		if queryi != -1 && strings.Index(tpl, key) > queryi {
+			return url.QueryEscape(value)
+		}
+		if m[1] == "item" {
+			return value
+		}
+		return url.PathEscape(value)
+	})
+	return out, err
+}
@@ -0,0 +1,111 @@
+package search
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/boypt/scraper"
+)
+
+// the endpoint "<id>/item" of a scraper config looks up the pages of the
+// results of the endpoint "<id>"
+const itemSuffix = "/item"
+
+// scraperProvider is an endpoint of the scraper
+type scraperProvider struct {
+	id, kind, source string
+	search, item     *scraper.Endpoint
+}
+
+// ScraperProviders returns the providers of the scraper config, see
+// github.com/jpillora/scraper for its specification
+func ScraperProviders(kind, source string, config []byte, headers map[string]string, debug bool) ([]Provider, error) {
+	h := &scraper.Handler{Headers: headers, Debug: debug}
+	if err := h.LoadConfig(config); err != nil {
+		return nil, err
+	}
+	var ps []Provider
+	for id, ep := range h.Config {
+		if strings.HasSuffix(id, itemSuffix) {
+			continue
+		}
+		ps = append(ps, &scraperProvider{
+			id:     id,
+			kind:   kind,
+			source: source,
+			search: ep,
+			item:   h.Config[id+itemSuffix],
+		})
+	}
+	return ps, nil
+}
+
+func (p *scraperProvider) Info() Info {
+	name := p.search.Name
+	if name == "" {
+		name = p.id
+	}
+	return Info{
+		ID:     p.id,
+		Name:   name,
+		URL:    p.search.URL,
+		Kind:   p.kind,
+		Source: p.source,
+		Item:   p.item != nil,
+	}
+}
+
+func (p *scraperProvider) Search(ctx context.Context, query string, page int) ([]Result, error) {
+	if page < 1 {
+		page = 1
+	}
+	// the sites counting the pages from 0
+	if strings.Contains(p.search.URL, "{{page:0}}") {
+		page--
+	}
+	return execute(ctx, p.search, map[string]string{"query": query, "page": strconv.Itoa(page)})
+}
+
+func (p *scraperProvider) Item(ctx context.Context, path string) (Result, error) {
+	if p.item == nil {
+		return nil, ErrNoItem
+	}
+	results, err := execute(ctx, p.item, map[string]string{"item": path})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return Result{}, nil
+	}
+	return results[0], nil
+}
+
+// execute runs the endpoint, given up with the context as the scraper
+// doesn't take one
+func execute(ctx context.Context, ep *scraper.Endpoint, params map[string]string) ([]Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+	type outcome struct {
+		results []scraper.Result
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		results, err := ep.Execute(params)
+		done <- outcome{results, err}
+	}()
+	select {
+	case o := <-done:
+		if o.err != nil {
+			return nil, o.err
+		}
+		results := make([]Result, 0, len(o.results))
+		for _, r := range o.results {
+			results = append(results, Result(r))
+		}
+		return results, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
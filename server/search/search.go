@@ -0,0 +1,204 @@
+// Package search holds the search providers of the web UI behind Provider:
+// the built-in scraper endpoints, the endpoints of ScraperURL, the external
+// providers of the descriptors and the search plugins.
+package search
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the kinds of the providers, the later kinds override the providers of the
+// same ID of the earlier ones
+const (
+	KindBuiltin  = "builtin"
+	KindScraper  = "scraper"
+	KindExternal = "external"
+	KindPlugin   = "plugin"
+)
+
+var kinds = []string{KindBuiltin, KindScraper, KindExternal, KindPlugin}
+
+const (
+	// a search of a provider is given up after this long
+	searchTimeout = 30 * time.Second
+	// the results of a test kept in its outcome
+	testSample = 3
+)
+
+var (
+	ErrNotFound = errors.New("no such search provider")
+	ErrDisabled = errors.New("the search provider is disabled")
+	ErrNoItem   = errors.New("the search provider doesn't look up items")
+)
+
+// Result is a result of a search by the fields of the scraper: name, url or
+// path, magnet, infohash, torrent, size, seeds and peers
+type Result map[string]string
+
+// Info describes a provider to the web UI
+type Info struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// the search URL template, the web UI completes the relative URLs of
+	// the results by its origin
+	URL  string `json:"url,omitempty"`
+	Kind string `json:"kind"`
+	// the descriptor file or the URL the provider is defined by
+	Source string `json:"source,omitempty"`
+	// it looks up the pages of the results without a magnet
+	Item    bool `json:"item"`
+	Enabled bool `json:"enabled"`
+}
+
+// Provider searches a site
+type Provider interface {
+	Info() Info
+	// Search returns the results of the page, counted from 1
+	Search(ctx context.Context, query string, page int) ([]Result, error)
+	// Item looks up the page of a result, for its magnet, infohash or torrent
+	Item(ctx context.Context, path string) (Result, error)
+}
+
+// Registry keeps the providers of each kind, replaced as their sources are
+// loaded again, and the IDs disabled by the user
+type Registry struct {
+	sync.RWMutex
+	providers map[string][]Provider
+	disabled  map[string]bool
+}
+
+// Set replaces the providers of the kind
+func (r *Registry) Set(kind string, ps []Provider) {
+	r.Lock()
+	defer r.Unlock()
+	if r.providers == nil {
+		r.providers = make(map[string][]Provider)
+	}
+	r.providers[kind] = ps
+}
+
+// SetDisabled replaces the disabled IDs
+func (r *Registry) SetDisabled(ids []string) {
+	r.Lock()
+	defer r.Unlock()
+	r.disabled = make(map[string]bool)
+	for _, id := range ids {
+		r.disabled[id] = true
+	}
+}
+
+// all returns the providers by ID, r locked
+func (r *Registry) all() map[string]Provider {
+	all := make(map[string]Provider)
+	for _, kind := range kinds {
+		for _, p := range r.providers[kind] {
+			all[p.Info().ID] = p
+		}
+	}
+	return all
+}
+
+// List returns the infos of the providers by ID
+func (r *Registry) List() map[string]Info {
+	r.RLock()
+	defer r.RUnlock()
+	infos := make(map[string]Info)
+	for id, p := range r.all() {
+		info := p.Info()
+		info.Enabled = !r.disabled[id]
+		infos[id] = info
+	}
+	return infos
+}
+
+// IDs returns the sorted IDs of the providers
+func (r *Registry) IDs() []string {
+	r.RLock()
+	defer r.RUnlock()
+	var ids []string
+	for id := range r.all() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Lookup returns the provider of the ID, enabled or not
+func (r *Registry) Lookup(id string) (Provider, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	p, ok := r.all()[id]
+	return p, ok
+}
+
+// Get returns the enabled provider of the ID
+func (r *Registry) Get(id string) (Provider, error) {
+	p, ok := r.Lookup(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	r.RLock()
+	defer r.RUnlock()
+	if r.disabled[id] {
+		return nil, ErrDisabled
+	}
+	return p, nil
+}
+
+// TestResult is the outcome of a test search of a provider
+type TestResult struct {
+	ID      string
+	Query   string
+	Results int
+	// the first results
+	Sample []Result
+	// the fields the web UI needs missing from the first result: the name,
+	// and a magnet, an infohash, a torrent or a page to look up
+	Missing []string `json:",omitempty"`
+	Elapsed string
+	Error   string `json:",omitempty"`
+}
+
+// Test searches the provider once, enabled or not
+func (r *Registry) Test(ctx context.Context, id, query string) TestResult {
+	tr := TestResult{ID: id, Query: query}
+	p, ok := r.Lookup(id)
+	if !ok {
+		tr.Error = ErrNotFound.Error()
+		return tr
+	}
+	start := time.Now()
+	results, err := p.Search(ctx, query, 1)
+	tr.Elapsed = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		tr.Error = err.Error()
+		return tr
+	}
+	tr.Results = len(results)
+	tr.Sample = results
+	if len(tr.Sample) > testSample {
+		tr.Sample = tr.Sample[:testSample]
+	}
+	if len(results) > 0 {
+		tr.Missing = missingFields(results[0], p.Info().Item)
+	}
+	return tr
+}
+
+// missingFields returns the fields missing for the result to be added
+func missingFields(r Result, item bool) []string {
+	var missing []string
+	if r["name"] == "" {
+		missing = append(missing, "name")
+	}
+	if r["magnet"] == "" && r["infohash"] == "" && r["torrent"] == "" {
+		if !item || (r["url"] == "" && r["path"] == "") {
+			missing = append(missing, "magnet")
+		}
+	}
+	return missing
+}
@@ -0,0 +1,235 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yamlDescriptor = `
+name: Example JSON
+type: json
+url: {{server}}/api?q={{query}}&p={{page:0}}
+list: data.torrents
+result:
+  name: title
+  infohash: hash
+  seeds: [stats, seeders]
+item:
+  url: {{server}}/item{{item}}
+  result:
+    magnet: magnet
+`
+
+func TestParseDescriptor(t *testing.T) {
+	d, err := ParseDescriptor("/dir/example.yaml", []byte(strings.ReplaceAll(yamlDescriptor, "{{server}}", "https://example.org")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "example" || d.Name != "Example JSON" || d.Type != TypeJSON || d.List != "data.torrents" {
+		t.Errorf("descriptor %+v", d)
+	}
+	if d.Item == nil || d.Item.Result["magnet"] != "magnet" {
+		t.Errorf("item %+v", d.Item)
+	}
+
+	for name, data := range map[string]string{
+		"noquery.json":  `{"url": "https://example.org/", "result": {"name": "a"}}`,
+		"noresult.json": `{"url": "https://example.org/?q={{query}}"}`,
+		"type.json":     `{"type": "xml", "url": "https://example.org/?q={{query}}", "result": {"name": "a"}}`,
+		"scheme.json":   `{"url": "ftp://example.org/?q={{query}}", "result": {"name": "a"}}`,
+		"id.json":       `{"id": "a/b", "url": "https://example.org/?q={{query}}", "result": {"name": "a"}}`,
+		"example.txt":   `{"url": "https://example.org/?q={{query}}", "result": {"name": "a"}}`,
+	} {
+		if _, err := ParseDescriptor(name, []byte(data)); err == nil {
+			t.Errorf("ParseDescriptor(%s) accepted", name)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	for _, tc := range []struct {
+		tpl  string
+		want string
+	}{
+		{"https://a.org/s/{{query}}/{{page:1}}", "https://a.org/s/two%20words/1"},
+		{"https://a.org/s?q={{query}}&p={{page:1}}", "https://a.org/s?q=two+words&p=1"},
+		{"https://a.org{{item}}", "https://a.org/t/1 2"},
+	} {
+		got, err := expand(tc.tpl, map[string]string{"query": "two words", "item": "/t/1 2"})
+		if err != nil || got != tc.want {
+			t.Errorf("expand(%s) = %s, %v", tc.tpl, got, err)
+		}
+	}
+	if _, err := expand("https://a.org/?q={{query}}", nil); err == nil {
+		t.Error("missing param accepted")
+	}
+}
+
+func testServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api":
+			if r.URL.Query().Get("q") != "two words" || r.URL.Query().Get("p") != "1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data": {"torrents": [
+				{"title": "first", "hash": "aa", "stats": {"seeders": 5}},
+				{"title": "", "hash": "bb"},
+				{"title": "second", "stats": {"seeders": 1}}
+			]}}`))
+		case "/item/t/2":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"magnet": "magnet:?xt=urn:btih:cc"}`))
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<table>
+				<tr class="r"><td><a href="/t/1">first</a></td><td>3</td></tr>
+				<tr class="r"><td><a href="/t/2">second</a></td><td>4</td></tr>
+			</table>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestJSONProvider(t *testing.T) {
+	ts := testServer(t)
+	d, err := ParseDescriptor("example.yml", []byte(strings.ReplaceAll(yamlDescriptor, "{{server}}", ts.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProvider(d, "example.yml", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// {{page:0}} takes the second page as 1
+	results, err := p.Search(context.Background(), "two words", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Result{
+		{"name": "first", "infohash": "aa", "seeds": "5"},
+		{"name": "second", "seeds": "1"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results %v", results)
+	}
+	item, err := p.Item(context.Background(), "/t/2")
+	if err != nil || item["magnet"] != "magnet:?xt=urn:btih:cc" {
+		t.Errorf("item %v %v", item, err)
+	}
+	if info := p.Info(); info.ID != "example" || info.Kind != KindExternal || !info.Item {
+		t.Errorf("info %+v", info)
+	}
+}
+
+func TestHTMLProvider(t *testing.T) {
+	ts := testServer(t)
+	d, err := ParseDescriptor("html.json", []byte(`{
+		"url": "`+ts.URL+`/html?q={{query}}",
+		"list": "tr.r",
+		"result": {"name": "a", "path": ["a", "@href"], "seeds": "td:nth-of-type(2)"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProvider(d, "html.json", map[string]string{"User-Agent": "test"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := p.Search(context.Background(), "x", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Result{
+		{"name": "first", "path": "/t/1", "seeds": "3"},
+		{"name": "second", "path": "/t/2", "seeds": "4"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results %v", results)
+	}
+	if _, err := p.Item(context.Background(), "/t/1"); !errors.Is(err, ErrNoItem) {
+		t.Errorf("item %v", err)
+	}
+}
+
+type fakeProvider struct {
+	info    Info
+	results []Result
+}
+
+func (p *fakeProvider) Info() Info { return p.info }
+
+func (p *fakeProvider) Search(ctx context.Context, query string, page int) ([]Result, error) {
+	return p.results, nil
+}
+
+func (p *fakeProvider) Item(ctx context.Context, path string) (Result, error) {
+	return nil, ErrNoItem
+}
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	r.Set(KindBuiltin, []Provider{
+		&fakeProvider{info: Info{ID: "a", Name: "builtin a", Kind: KindBuiltin}},
+		&fakeProvider{info: Info{ID: "b", Kind: KindBuiltin}},
+	})
+	r.Set(KindExternal, []Provider{
+		&fakeProvider{info: Info{ID: "a", Name: "external a", Kind: KindExternal}, results: []Result{
+			{"name": "x", "infohash": "aa"}, {"name": "y"}, {"name": "z"}, {"name": "w"},
+		}},
+	})
+	r.SetDisabled([]string{"b"})
+
+	infos := r.List()
+	if len(infos) != 2 || infos["a"].Name != "external a" || !infos["a"].Enabled || infos["b"].Enabled {
+		t.Errorf("list %+v", infos)
+	}
+	if ids := r.IDs(); !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("ids %v", ids)
+	}
+	if _, err := r.Get("b"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("get disabled: %v", err)
+	}
+	if _, err := r.Get("c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get unknown: %v", err)
+	}
+
+	tr := r.Test(context.Background(), "a", "q")
+	if tr.Error != "" || tr.Results != 4 || len(tr.Sample) != testSample || tr.Missing != nil {
+		t.Errorf("test %+v", tr)
+	}
+	// tested while disabled
+	if tr := r.Test(context.Background(), "b", "q"); tr.Error != "" || tr.Results != 0 {
+		t.Errorf("test disabled %+v", tr)
+	}
+	if tr := r.Test(context.Background(), "c", "q"); tr.Error == "" {
+		t.Errorf("test unknown %+v", tr)
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	for _, tc := range []struct {
+		r    Result
+		item bool
+		want []string
+	}{
+		{Result{"name": "a", "magnet": "m"}, false, nil},
+		{Result{"name": "a", "path": "/t/1"}, true, nil},
+		{Result{"name": "a", "path": "/t/1"}, false, []string{"magnet"}},
+		{Result{"infohash": "aa"}, false, []string{"name"}},
+	} {
+		if got := missingFields(tc.r, tc.item); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("missingFields(%v, %v) = %v", tc.r, tc.item, got)
+		}
+	}
+}
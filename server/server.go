@@ -3,27 +3,35 @@ package server
 import (
 	"compress/gzip"
 	"fmt"
-	stdlog "log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/common/logging"
+	"github.com/boypt/simple-torrent/common/selfupdate"
+	"github.com/boypt/simple-torrent/common/trace"
 	"github.com/boypt/simple-torrent/server/httpmiddleware"
+	"github.com/boypt/simple-torrent/server/qbittorrent"
+	"github.com/boypt/simple-torrent/server/search"
+	"github.com/boypt/simple-torrent/server/torznab"
+	"github.com/boypt/simple-torrent/server/transmissionrpc"
+	"github.com/boypt/simple-torrent/server/webpush"
 
 	"errors"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/anacrolix/torrent"
-	"github.com/boypt/scraper"
 	"github.com/boypt/simple-torrent/engine"
 	ctstatic "github.com/boypt/simple-torrent/static"
-	"github.com/jpillora/cookieauth"
+	"github.com/c2h5oh/datasize"
 	"github.com/jpillora/requestlog"
 	"github.com/jpillora/velox"
 	"github.com/mmcdole/gofeed"
@@ -37,7 +45,7 @@ const (
 
 var (
 	isListenOnUnix bool
-	log            *stdlog.Logger
+	log            = logging.New("server")
 	//ErrDiskSpace raised if disk space not enough
 	ErrDiskSpace = errors.New("not enough disk space")
 )
@@ -45,32 +53,79 @@ var (
 //Server is the "State" portion of the diagram
 type Server struct {
 	//config
-	Title          string `opts:"help=Title of this instance,env=TITLE"`
-	Port           int    `opts:"help=Depreciated. use --listen. Listening port(),env=PORT"`
-	Host           string `opts:"help=Depreciated. use --listen. Listening interface,env=HOST"`
-	Listen         string `opts:"help=Listening Address:Port or unix socket (default all),env=LISTEN"`
-	UnixPerm       string `opts:"help=DomainSocket file permission (default 0666),env=UNIXPERM"`
-	Auth           string `opts:"help=Optional basic auth in form 'user:password',env=AUTH"`
-	ProxyURL       string `opts:"help=Proxy url,env=PROXY_URL"`
-	ConfigPath     string `opts:"help=Configuration file path (default ./cloud-torrent.yaml),short=c,env=CONFIGPATH"`
-	KeyPath        string `opts:"help=TLS Key file path"`
-	CertPath       string `opts:"help=TLS Certicate file path,short=r"`
-	RestAPI        string `opts:"help=Listen on a trusted port accepts /api/ requests (eg. localhost:3001),env=RESTAPI"`
-	ReqLog         bool   `opts:"help=Enable request logging,env=REQLOG"`
-	Open           bool   `opts:"help=Open now with your default browser"`
-	DisableLogTime bool   `opts:"help=Don't print timestamp in log,env=DISABLELOGTIME"`
-	DisableMmap    bool   `opts:"help=Don't use mmap,env=DISABLEMMAP"`
-	Debug          bool   `opts:"help=Debug app,env=DEBUG"`
-	DebugTorrent   bool   `opts:"help=Debug torrent engine,env=DEBUGTORRENT"`
-	ConvYAML       bool   `opts:"help=Convert old json config to yaml format."`
-	IntevalSec     int    `opts:"help=Inteval seconds to push data to clients (default 3),env=INTEVALSEC"`
+	Title            string `opts:"help=Title of this instance,env=TITLE"`
+	Port             int    `opts:"help=Depreciated. use --listen. Listening port(),env=PORT"`
+	Host             string `opts:"help=Depreciated. use --listen. Listening interface,env=HOST"`
+	Listen           string `opts:"help=Listening Address:Port or unix socket as unix:///path/to.sock (default all),env=LISTEN"`
+	UnixPerm         string `opts:"help=DomainSocket file permission in octal (eg. 0660) else by the umask,env=UNIXPERM"`
+	Auth             string `opts:"help=Optional basic auth in form 'user:password',env=AUTH"`
+	ProxyURL         string `opts:"help=Proxy url,env=PROXY_URL"`
+	ConfigPath       string `opts:"help=Configuration file path (default ./cloud-torrent.yaml),short=c,env=CONFIGPATH"`
+	KeyPath          string `opts:"help=TLS Key file path"`
+	CertPath         string `opts:"help=TLS Certicate file path,short=r"`
+	RestAPI          string `opts:"help=Listen on a trusted port accepts /api/ requests (eg. localhost:3001),env=RESTAPI"`
+	FTPListen        string `opts:"help=Optional read-only FTP server of the downloads (eg. :2121) with the --auth account and FTPS by --keypath/--certpath,env=FTPLISTEN"`
+	FTPPassive       string `opts:"help=Passive ports range of the FTP server (eg. 30000-30009),env=FTPPASSIVE"`
+	SFTPListen       string `opts:"help=Optional read-only SFTP server of the downloads (eg. :2022) with the accounts and users chrooted to their tasks,env=SFTPLISTEN"`
+	ReqLog           bool   `opts:"help=Enable request logging,env=REQLOG"`
+	Open             bool   `opts:"help=Open now with your default browser"`
+	DisableLogTime   bool   `opts:"help=Don't print timestamp in log,env=DISABLELOGTIME"`
+	LogLevel         string `opts:"help=Log level debug|info|warn|error then comma separated levels of the modules like engine=debug,env=LOGLEVEL"`
+	LogFormat        string `opts:"help=Log format console|json (default console),env=LOGFORMAT"`
+	LogFile          string `opts:"help=Write the log to the file instead of stdout,env=LOGFILE"`
+	LogMaxSize       string `opts:"help=Rotate the log file once larger (eg. 100MB),env=LOGMAXSIZE"`
+	LogMaxAge        string `opts:"help=Rotate the log file once older (eg. 24h),env=LOGMAXAGE"`
+	LogMaxBackups    int    `opts:"help=Rotated log files kept (default all),env=LOGMAXBACKUPS"`
+	DisableMmap      bool   `opts:"help=Don't use mmap,env=DISABLEMMAP"`
+	Debug            bool   `opts:"help=Debug app,env=DEBUG"`
+	DebugTorrent     bool   `opts:"help=Debug torrent engine,env=DEBUGTORRENT"`
+	ConvYAML         bool   `opts:"help=Convert old json config to yaml format."`
+	IntevalSec       int    `opts:"help=Inteval seconds to push data to clients (default 3),env=INTEVALSEC"`
+	OIDCIssuer       string `opts:"help=Optional OpenID Connect login of the web UI by the issuer URL (eg. https://auth.example.com) with --auth and the users as fallback (open /?basic),env=OIDC_ISSUER"`
+	OIDCClientID     string `opts:"help=Client ID of the OpenID Connect login,env=OIDC_CLIENT_ID"`
+	OIDCClientSecret string `opts:"help=Client secret of the OpenID Connect login,env=OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL  string `opts:"help=Public URL of this instance for the callback <url>/auth/callback,env=OIDC_REDIRECT_URL"`
+	OIDCAllowEmails  string `opts:"help=Emails allowed to log in by OpenID Connect separated by commas,env=OIDC_ALLOW_EMAILS"`
+	OIDCAllowGroups  string `opts:"help=Groups claims allowed to log in by OpenID Connect separated by commas,env=OIDC_ALLOW_GROUPS"`
+	OIDCAdminGroups  string `opts:"help=Groups claims of the admins logged in by OpenID Connect,env=OIDC_ADMIN_GROUPS"`
+	HTTPSDomain      string `opts:"help=Serve HTTPS with the certificates of Let's Encrypt for the domains separated by commas (needs --listen :443 or --https-redirect :80),env=HTTPS_DOMAIN"`
+	HTTPSEmail       string `opts:"help=Contact email of the Let's Encrypt account,env=HTTPS_EMAIL"`
+	HTTPSRedirect    string `opts:"help=Listen on a plain HTTP address (eg. :80) redirecting to HTTPS and answering the ACME challenges,env=HTTPS_REDIRECT"`
+	SNMPListen       string `opts:"help=Optional read-only SNMP v1/v2c agent of the core counters on the UDP address (eg. :1161),env=SNMP_LISTEN"`
+	SNMPCommunity    string `opts:"help=Community of the SNMP agent,env=SNMP_COMMUNITY"`
+	BasePath         string `opts:"help=URL prefix of all the routes when served under a subpath by a reverse proxy (eg. /torrent),env=BASEPATH"`
+	Restore          string `opts:"help=Restore the config and the tasks of a backup of /api/backup (eg. simple-torrent-backup.tar.gz) at startup replacing the config file,env=RESTORE"`
+	AllowIPs         string `opts:"help=Only the IPs or networks separated by commas (eg. 192.168.0.0/16) are served (default all),env=ALLOW_IPS"`
+	DenyIPs          string `opts:"help=The IPs or networks separated by commas refused,env=DENY_IPS"`
+	TrustedProxies   string `opts:"help=The reverse proxies whose X-Forwarded-For and X-Real-IP are trusted for the client IP besides the loopback,env=TRUSTED_PROXIES"`
+	RateLimit        int    `opts:"help=Requests a second allowed of an IP in bursts of 5 seconds (default no limit),env=RATE_LIMIT"`
+	AuthMaxFails     int    `opts:"help=Failed logins of an IP before it's locked out (0 for never),env=AUTH_MAX_FAILS"`
+	AuthLockout      string `opts:"help=How long an IP is locked out after the failed logins,env=AUTH_LOCKOUT"`
+	UnsignedUpdate   bool   `opts:"help=Allow POST /api/update to install a release checked by its checksum only when the binary has no key of the release signatures,env=UNSIGNED_UPDATE"`
 
 	//http handlers
-	scraperh, dlfilesh, statich, verStatich, rssh http.Handler
-	scraper                                       *scraper.Handler
+	scraperh, dlfilesh, statich, verStatich, rssh, streamh, transmissionh http.Handler
+	qbith, playh, tracedAPI                                               http.Handler
+	torznab                                                               *torznab.Client
+	webpush                                                               *webpush.Service
 
 	//torrent engine
 	engine *engine.Engine
+	//accounts of the users file
+	users *engine.UserStore
+	//API tokens of the scripts
+	tokens *engine.TokenStore
+	//OpenID Connect login, nil when disabled
+	oidc *oidcLogin
+	//IP lists, rate limit and login lockout
+	guard *httpmiddleware.IPGuard
+	//last check of the latest release
+	update updateState
+	//the executable to restart into after the shutdown
+	restartc chan string
+
+	//torrents diff push
+	diffs *diffHub
 
 	//sync req
 	syncConnected chan struct{}
@@ -82,18 +137,33 @@ type Server struct {
 		UseQueue      bool
 		LatestRSSGuid string
 		Torrents      *map[string]*engine.Torrent
+		TempRateLimit *engine.TempRateLimit
+		AltRate       *engine.AltRate
+		Deleted       *engine.DeletedList
 		Users         map[string]struct{}
 		Stats         struct {
-			System   osStats
-			ConnStat torrent.ConnStats
+			System     osStats
+			ConnStat   torrent.ConnStats
+			Blocklist  engine.BlocklistStats
+			Restore    engine.RestoreProgress
+			Memory     engine.MemoryStats
+			Background engine.BackgroundStats
+			LowDisk    engine.LowDiskStatus
+			Speed      engine.SpeedStats
+			Proxy      engine.ProxyStatus
 		}
 	}
 
+	//results of the requests with Idempotency-Key
+	idempotency idempotencyCache
+
 	rssMark         map[string]string
 	rssCache        []*gofeed.Item
-	searchProviders *scraper.Config
+	providers       search.Registry
 	engineConfig    *engine.Config
-	tpl             *TPLInfo
+	//one change of the config at a time, by the API or the config file
+	configMu sync.Mutex
+	tpl      *TPLInfo
 }
 
 // Run the server
@@ -105,26 +175,31 @@ func (s *Server) Run(tpl *TPLInfo) error {
 		s.IntevalSec = 3
 	}
 
-	if s.DisableLogTime {
-		engine.SetLoggerFlag(stdlog.Lmsgprefix)
-		log.SetFlags(stdlog.Lmsgprefix)
+	if err := s.setupLogging(); err != nil {
+		return err
 	}
 
 	if s.Host != "" || s.Port != 3000 {
-		log.Println("WARNING: --host --port arguments are depreciated, use --linsten instead, eg:`--listen :3000`")
+		log.Warn("WARNING: --host --port arguments are depreciated, use --linsten instead, eg:`--listen :3000`")
 		s.Listen = fmt.Sprintf("%s:%d", s.Host, s.Port)
 		if strings.HasPrefix(s.Host, "unix:") {
 			s.Listen = s.Host
 		}
 	}
 	isListenOnUnix = strings.HasPrefix(s.Listen, "unix:")
-
-	isTLS := s.CertPath != "" || s.KeyPath != "" //poor man's XOR
-	if isTLS && (s.CertPath == "" || s.KeyPath == "") {
-		return fmt.Errorf("ERROR: You must provide both key and cert paths")
+	if isListenOnUnix && s.UnixPerm != "" {
+		if _, err := strconv.ParseUint(s.UnixPerm, 8, 32); err != nil {
+			return fmt.Errorf("invalid --unix-perm %q, expecting an octal mode like 0660", s.UnixPerm)
+		}
+	}
+	s.BasePath = cleanBasePath(s.BasePath)
+	if err := s.setupGuard(); err != nil {
+		return err
 	}
 
 	s.syncConnected = make(chan struct{})
+	s.restartc = make(chan string, 1)
+	s.diffs = newDiffHub()
 	//init maps
 	s.state.Users = make(map[string]struct{})
 	s.rssMark = make(map[string]string)
@@ -134,30 +209,39 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	s.verStatich = http.StripPrefix("/"+s.tpl.Version, s.statich)
 	s.dlfilesh = http.StripPrefix("/download/", http.HandlerFunc(s.serveDownloadFiles))
 	s.rssh = http.HandlerFunc(s.serveRSS)
+	s.streamh = http.StripPrefix("/stream", http.HandlerFunc(s.serveStream))
+	s.playh = http.StripPrefix("/play", http.HandlerFunc(s.servePlay))
 
-	//scraper
-	s.scraper = &scraper.Handler{
-		Log: s.Debug, Debug: s.Debug,
-		Headers: map[string]string{
-			//we're a trusty browser :)
-			"User-Agent": scraperUA,
-		},
-	}
-	if err := s.scraper.LoadConfig(defaultSearchConfig); err != nil {
+	//search providers
+	builtin, err := search.ScraperProviders(search.KindBuiltin, "", defaultSearchConfig, s.searchHeaders(), s.Debug)
+	if err != nil {
 		log.Fatal(err)
 	}
-	s.searchProviders = &s.scraper.Config //share scraper config with web frontend
-	s.scraperh = http.StripPrefix("/search", s.scraper)
+	s.providers.Set(search.KindBuiltin, builtin)
+	s.scraperh = trace.Handler(http.StripPrefix("/search", s.cachedSearch(http.HandlerFunc(s.serveSearch))))
+	s.tracedAPI = trace.Handler(http.HandlerFunc(s.restAPIhandle))
 
 	// sync config from cmd arg to viper
 	viper.SetDefault("ProxyURL", s.ProxyURL)
 
 	//torrent engine
 	s.engine = engine.New(s)
+	if s.Restore != "" {
+		p, err := engine.ExtractBackupConfig(s.Restore, s.ConfigPath)
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", s.Restore, err)
+		}
+		s.ConfigPath = p
+	}
 	c, err := engine.InitConf(&s.ConfigPath)
 	if err != nil {
 		return err
 	}
+	if s.Restore != "" {
+		if _, err := engine.ExtractBackupCache(s.Restore, c); err != nil {
+			return fmt.Errorf("restore %s: %w", s.Restore, err)
+		}
+	}
 	c.EngineDebug = s.DebugTorrent
 
 	// write cloud-torrent.yaml at the same dir with -c conf and exit
@@ -177,18 +261,46 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	}
 
 	if err := detectDiskStat(c.DownloadDirectory); err != nil {
-		return err
+		// the watchdog of LowDiskSpace pauses the downloads instead
+		if !errors.Is(err, ErrDiskSpace) || c.LowDiskSpace == "" {
+			return err
+		}
+		log.Warn(err)
 	}
 
 	// engine configure
 	s.state.Stats.System.diskDirPath = c.DownloadDirectory
-	s.state.UseQueue = (c.MaxConcurrentTask > 0)
+	s.state.UseQueue = c.QueueEnabled()
 	s.engineConfig = c
 	s.tpl.AllowRuntimeConfigure = c.AllowRuntimeConfigure
 	if err := s.engine.Configure(c); err != nil {
 		return err
 	}
+	defer s.stopEngine()
+	if err := s.openUsers(); err != nil {
+		return err
+	}
+	if err := s.openTokens(); err != nil {
+		return err
+	}
+	if s.OIDCIssuer != "" {
+		if err := s.setupOIDC(); err != nil {
+			return err
+		}
+	}
 	s.state.Torrents = s.engine.GetTorrents()
+	s.state.TempRateLimit = s.engine.TempRateLimit()
+	s.state.AltRate = s.engine.AltRate()
+	s.state.Deleted = s.engine.DeletedTasks()
+	s.transmissionh = transmissionrpc.New(s.engine, s.checkOwner, s.presetOwner)
+	s.qbith = qbittorrent.New(s.engine, s.qbitLogin, s.qbitCheckOwner, s.qbitPresetOwner)
+	s.torznab = torznab.New()
+	if wp, err := webpush.New(path.Join(c.DownloadDirectory, engine.CachedTorrentDir, ".webpush.json")); err == nil {
+		s.webpush = wp
+		s.engine.AddEventListener(wp.OnEvent)
+	} else {
+		log.Warn("[webpush] disabled:", err)
+	}
 
 	if s.Debug {
 		viper.Debug()
@@ -196,9 +308,18 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	}
 
 	if err := s.engine.ParseTrackerList(); err != nil {
-		log.Println("UpdateTrackers err", err)
+		log.Warn("UpdateTrackers err", err)
 	}
 	s.backgroundRoutines()
+	if err := s.watchConfigFile(); err != nil {
+		log.Warn("[config] not watching the config file:", err)
+	}
+
+	tlsConfig, err := s.setupHTTPS()
+	if err != nil {
+		return err
+	}
+	isTLS := tlsConfig != nil
 
 	if s.Open && !isListenOnUnix {
 		go func() {
@@ -207,7 +328,7 @@ func (s *Server) Run(tpl *TPLInfo) error {
 				proto += "s"
 			}
 			time.Sleep(1 * time.Second)
-			common.FancyHandleError(open.Run(fmt.Sprintf("%s://localhost:%d", proto, s.Port)))
+			common.FancyHandleError(open.Run(fmt.Sprintf("%s://localhost:%d%s/", proto, s.Port, s.BasePath)))
 		}()
 	}
 
@@ -216,24 +337,42 @@ func (s *Server) Run(tpl *TPLInfo) error {
 		go func() {
 			restServer := http.Server{
 				Addr: s.RestAPI,
-				Handler: requestlog.Wrap(
+				Handler: requestlog.WrapWith(
 					httpmiddleware.RealIP(
-						http.Handler(http.HandlerFunc(s.restAPIhandle)),
+						s.probeBypass(trace.Handler(http.HandlerFunc(s.restAPIhandle))),
 					),
+					reqLogOptions(),
 				),
 			}
 			log.Println("[RestAPI] listening at ", s.RestAPI)
 			if err := restServer.ListenAndServe(); err != nil {
-				log.Println("[RestAPI] err ", err)
+				log.Warn("[RestAPI] err ", err)
 			}
 		}()
 	}
 
+	if s.FTPListen != "" {
+		if err := s.startFTP(s.CertPath != ""); err != nil {
+			return err
+		}
+	}
+
+	if s.SFTPListen != "" {
+		if err := s.startSFTP(); err != nil {
+			return err
+		}
+	}
+
+	if s.SNMPListen != "" {
+		if err := s.startSNMP(); err != nil {
+			return err
+		}
+	}
+
 	//define handler chain, from last to first
 	h := http.Handler(http.HandlerFunc(s.webHandle))
 	//gzip
 	h = httpmiddleware.RealIP(h)
-	h = httpmiddleware.Liveness(h)
 
 	// dont enable gzip handler if certantlly we are behind a web server
 	if !isListenOnUnix {
@@ -242,39 +381,64 @@ func (s *Server) Run(tpl *TPLInfo) error {
 	}
 
 	//auth
-	if s.Auth != "" {
-		user := s.Auth
-		pass := ""
-		if s := strings.SplitN(s.Auth, ":", 2); len(s) == 2 {
-			user = s[0]
-			pass = s[1]
-		}
-		h = cookieauth.New().SetUserPass(user, pass).Wrap(h)
-		log.Printf("Enabled HTTP authentication")
-	}
+	h = s.authWrap(h)
+	//web seeds are fetched by peers, not behind auth
+	h = s.webseedBypass(h)
+	//health probes, not behind auth either
+	h = s.probeBypass(h)
+	//routes under --base-path
+	h = s.basePathWrap(h)
+	//clients refused by IP, rate or failed logins
+	h = s.guard.Wrap(h)
 	if s.ReqLog {
-		h = requestlog.Wrap(h)
+		h = requestlog.WrapWith(h, reqLogOptions())
 	}
 
 	server := http.Server{
 		//handler stack
-		Handler: h,
+		Handler:   h,
+		TLSConfig: tlsConfig,
+	}
+
+	//serve until SIGTERM or SIGINT
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.serve(&server, isTLS)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-sigc:
+		return s.shutdown(&server, sig.String()+" received", sigc)
+	case exe := <-s.restartc:
+		if err := s.shutdown(&server, "restarting", sigc); err != nil {
+			return err
+		}
+		return selfupdate.Restart(exe)
 	}
+}
 
-	//serve!
+// serve listens at --listen
+func (s *Server) serve(server *http.Server, isTLS bool) error {
+	var err error
 	var listener net.Listener
 	if isListenOnUnix {
-		sockPath := s.Listen[5:]
-		if _, err := os.Stat(sockPath); !errors.Is(err, os.ErrNotExist) {
+		sockPath := unixSocketPath(s.Listen)
+		if fi, err := os.Lstat(sockPath); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				return fmt.Errorf("%s exists and isn't a socket", sockPath)
+			}
 			log.Println("Listening sock exists, removing", sockPath)
 			os.Remove(sockPath)
 		}
 		log.Println("Listening at", s.Listen)
 		listener, err = net.Listen("unix", sockPath)
 		if err != nil {
-			log.Fatalln("Failed listening", err)
+			return fmt.Errorf("failed listening: %w", err)
 		}
-		if um, err := strconv.ParseInt(s.UnixPerm, 8, 0); err == nil {
+		if um, err := strconv.ParseUint(s.UnixPerm, 8, 32); err == nil {
 			uxmod := os.FileMode(um)
 			log.Println("Listening DomainSocket mode change to:", uxmod.String(), s.UnixPerm)
 			common.HandleError(os.Chmod(sockPath, uxmod))
@@ -283,15 +447,57 @@ func (s *Server) Run(tpl *TPLInfo) error {
 		log.Println("Listening at", s.Listen)
 		listener, err = net.Listen("tcp", s.Listen)
 		if err != nil {
-			log.Fatalln("Failed listening", err)
+			return fmt.Errorf("failed listening: %w", err)
 		}
 		if isTLS {
-			return server.ServeTLS(listener, s.CertPath, s.KeyPath)
+			return server.ServeTLS(listener, "", "")
 		}
 	}
 	return server.Serve(listener)
 }
 
-func init() {
-	log = stdlog.New(os.Stdout, "[server]", stdlog.LstdFlags|stdlog.Lmsgprefix)
+// unixSocketPath returns the path of the unix:/path/to.sock or the
+// unix:///path/to.sock listen address
+func unixSocketPath(listen string) string {
+	p := strings.TrimPrefix(listen, "unix:")
+	if strings.HasPrefix(p, "//") {
+		p = p[2:]
+	}
+	return p
+}
+
+// setupLogging applies the --log options to the loggers of all the modules
+func (s *Server) setupLogging() error {
+	o := logging.Options{
+		Level:      s.LogLevel,
+		Format:     s.LogFormat,
+		File:       s.LogFile,
+		MaxBackups: s.LogMaxBackups,
+		NoTime:     s.DisableLogTime,
+	}
+	if s.LogMaxSize != "" {
+		var size datasize.ByteSize
+		if err := size.UnmarshalText([]byte(s.LogMaxSize)); err != nil {
+			return fmt.Errorf("invalid --log-max-size %q: %w", s.LogMaxSize, err)
+		}
+		o.MaxSize = int64(size.Bytes())
+	}
+	if s.LogMaxAge != "" {
+		d, err := time.ParseDuration(s.LogMaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid --log-max-age %q: %w", s.LogMaxAge, err)
+		}
+		o.MaxAge = d
+	}
+	return logging.Setup(o)
+}
+
+// reqLogOptions logs the requests as the lines of the http module
+func reqLogOptions() requestlog.Options {
+	return requestlog.Options{
+		Writer: logging.New("http").Writer(logging.LevelInfo),
+		Format: `{{ .Method }} {{ .Path }} {{ .Code }} {{ .Duration }}{{ if .Size }} {{ .Size }}{{end}}` +
+			`{{ if .IP }} ({{ .IP }}){{end}}` + "\n",
+		Colors: &requestlog.Colors{},
+	}
 }
@@ -3,12 +3,15 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,9 +20,26 @@ import (
 )
 
 var (
+	// the actions of the admins only
+	adminGET = map[string]bool{
+		"configure": true, "configversions": true, "export": true, "enginedebug": true, "users": true,
+		"watchfailures": true, "update": true, "plugins": true, "nat": true,
+		"trackerlist": true, "backup": true, "searchprovidertest": true,
+	}
+	adminPOST = map[string]bool{
+		"configure": true, "configrollback": true, "users": true, "templimit": true, "altrate": true,
+		"fileop": true, "location": true, "watchfailures": true, "update": true,
+		"trackerlist": true, "restore": true, "searchprovider": true, "import": true,
+		"restart": true,
+	}
+	// the GET actions adding tasks to the client, not for the readonly
+	changeGET = map[string]bool{"magnet": true, "metadata": true}
+
 	errInvalidReq = errors.New("INVALID REQUEST")
 	errUnknowAct  = errors.New("UNKOWN ACTION")
 	errUnknowPath = errors.New("UNKOWN PATH")
+
+	errWebPushDisabled = errors.New("web push unavailable")
 )
 
 func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
@@ -32,6 +52,12 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 
 	w.Header().Set("Content-Type", "application/json")
 	action := routeDirs[0]
+	if adminGET[action] && !isAdmin(r) {
+		return errForbidden
+	}
+	if changeGET[action] && isReadOnly(r) {
+		return errForbidden
+	}
 	switch action {
 	case "magnet": // adds magnet by GET: /api/magnet?m=...
 		tdata := struct {
@@ -41,31 +67,100 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 		}{}
 
 		m := r.URL.Query().Get("m")
-		if err := s.engine.NewMagnet(m); err != nil {
-			if !errors.Is(err, engine.ErrMaxConnTasks) {
-				tdata.HasError = true
-				tdata.Error = err.Error()
-			}
+		err := s.presetTask(r, []byte(m))
+		if err == nil {
+			err = ignoreQueued(s.engine.NewMagnetContext(r.Context(), m, r.URL.Query().Get("dir")))
+		}
+		if err != nil {
+			tdata.HasError = true
+			tdata.Error = err.Error()
 		}
 		tdata.Magnet = m
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		common.HandleError(htmlTPL["magadded.html"].Execute(w, tdata))
+	case "metadata": // the .torrent of a magnet without adding it: /api/metadata?m=...[&format=json]
+		return s.apiMetadata(w, r)
 	case "configure":
 		common.HandleError(json.NewEncoder(w).Encode(*(s.engineConfig)))
-	case "torrents":
-		common.HandleError(json.NewEncoder(w).Encode(s.engine.GetTorrents()))
+	case "export": // the whole state as one JSON document, secrets masked
+		return s.apiExport(w)
+	case "backup": // tar.gz of the config and the tasks for /api/restore or --restore
+		return s.apiBackup(w)
+	case "users":
+		common.HandleError(json.NewEncoder(w).Encode(s.users.List()))
+	case "whoami":
+		s.apiWhoami(w, r)
+	case "tokens":
+		s.apiTokenList(w, r)
+	case "configversions":
+		common.HandleError(json.NewEncoder(w).Encode(engine.ConfigVersions()))
+	case "update": // whether a newer release is available: /api/update[?refresh=1]
+		return s.apiUpdateCheck(w, r)
+	case "torrents": // all, or only the changes after a version: /api/torrents?since=<version>
+		since := r.URL.Query().Get("since")
+		if since == "" {
+			common.HandleError(json.NewEncoder(w).Encode(s.engine.Torrents()))
+			return nil
+		}
+		v, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return errInvalidReq
+		}
+		common.HandleError(json.NewEncoder(w).Encode(s.diffs.since(v, s.torrentsJSON())))
+	case "list": // a page of the torrents: /api/list?q=&state=&label=&collection=&sort=&order=&offset=&limit=
+		q, err := engine.ParseListQuery(r.URL.Query())
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.ListTorrents(q)))
+	case "history": // every task ever added: /api/history?q=&state=&since=&until=&offset=&limit=
+		q, err := engine.ParseTaskHistoryQuery(r.URL.Query())
+		if err != nil {
+			return err
+		}
+		p, err := s.engine.TaskHistory(q)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(p))
+	case "nat": // the UPnP mappings and the reachability of IncomingPort: /api/nat[?check=1]
+		if r.URL.Query().Get("check") == "" {
+			common.HandleError(json.NewEncoder(w).Encode(s.engine.NATStatus()))
+			return nil
+		}
+		st, err := s.engine.CheckPort(r.Context())
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(st))
+	case "trackerlist": // the trackers added to the new tasks with their sources
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.TrackerListStatus()))
+	case "revision": // polled to refetch the torrents only when changed
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.Revision()))
 	case "files":
 		common.HandleError(json.NewEncoder(w).Encode(s.listFiles()))
+	case "waitlist":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.WaitList()))
+	case "collections":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.Collections()))
+	case "recyclebin":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.RecycleBin()))
+	case "hooks": // the latest runs of DoneCmd and the Hooks with their output: /api/hooks?hash=
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.HookRuns(r.URL.Query().Get("hash"))))
+	case "watchfailures": // the .torrent files of the watch dirs failed to add
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.WatchFailures()))
 	case "torrent":
-		if len(routeDirs) != 2 {
+		if len(routeDirs) < 2 || len(routeDirs) > 3 {
 			return errUnknowAct
 		}
 		hash := routeDirs[1]
 		if len(hash) != 40 {
 			return errUnknowPath
 		}
-		m := s.engine.GetTorrents()
-		if t, ok := (*m)[hash]; ok {
+		if len(routeDirs) == 3 {
+			return s.apiTorrentGET(w, r, hash, routeDirs[2])
+		}
+		if t, ok := s.engine.Torrent(hash); ok {
 			common.HandleError(json.NewEncoder(w).Encode(t))
 		} else {
 			return errUnknowPath
@@ -73,9 +168,67 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 	case "stat":
 		s.state.Stats.System.loadStats()
 		s.state.Stats.ConnStat = s.engine.ConnStat()
+		s.state.Stats.Blocklist = s.engine.BlocklistStats()
+		s.state.Stats.Restore = s.engine.RestoreProgress()
+		s.state.Stats.Memory = s.engine.MemoryStats()
+		s.state.Stats.Background = s.engine.BackgroundStats()
+		s.state.Stats.LowDisk = s.engine.LowDiskStatus()
+		s.state.Stats.Speed = s.engine.SpeedStats()
+		s.state.Stats.Proxy = s.engine.ProxyStatus()
 		common.HandleError(json.NewEncoder(w).Encode(s.state.Stats))
+	case "stats": // the speeds downsampled: /api/stats/history?range=24h
+		if len(routeDirs) != 2 {
+			return errUnknowPath
+		}
+		if routeDirs[1] == "peers" { // by country and ASN, of a task by ?infohash=
+			common.HandleError(json.NewEncoder(w).Encode(s.engine.PeerGeoStats(r.URL.Query().Get("infohash"))))
+			return nil
+		}
+		if routeDirs[1] != "history" {
+			return errUnknowPath
+		}
+		rng, err := engine.ParseHistoryRange(r.URL.Query().Get("range"))
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.SpeedHistory(rng)))
+	case "pushkey": // VAPID public key for the browser to subscribe
+		if s.webpush == nil {
+			return errWebPushDisabled
+		}
+		common.HandleError(json.NewEncoder(w).Encode(struct {
+			PublicKey string `json:"publicKey"`
+		}{s.webpush.PublicKey()}))
+	case "dashboard":
+		common.HandleError(json.NewEncoder(w).Encode(s.dashboardStats()))
 	case "searchproviders":
-		common.HandleError(json.NewEncoder(w).Encode(s.searchProviders))
+		common.HandleError(json.NewEncoder(w).Encode(s.searchProviders().List()))
+	case "searchprovidertest": // /api/searchprovidertest?id=...&query=...
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			return errInvalidReq
+		}
+		query := r.URL.Query().Get("query")
+		if query == "" {
+			query = "ubuntu"
+		}
+		common.HandleError(json.NewEncoder(w).Encode(s.searchProviders().Test(r.Context(), id, query)))
+	case "plugins":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.Plugins()))
+	case "search": // torznab search: /api/search?q=...
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			return errInvalidReq
+		}
+		endpoints := common.SplitLines(s.engineConfig.TorznabURL)
+		if len(endpoints) == 0 {
+			return errors.New("TorznabURL not configured")
+		}
+		results, err := s.torznab.Search(endpoints, q)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(results))
 	case "enginedebug":
 		w.Header().Set("Content-Type", "application/json")
 		var buf bytes.Buffer
@@ -91,6 +244,176 @@ func (s *Server) apiGET(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// apiTorrentGET serves the sub resources of a torrent: /api/torrent/<infohash>/<action>
+func (s *Server) apiTorrentGET(w http.ResponseWriter, r *http.Request, hash, action string) error {
+	switch action {
+	case "tree":
+		tree, err := s.engine.TorrentFileTree(hash)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(tree))
+	case "files":
+		files, err := s.engine.SearchTorrentFiles(hash, r.URL.Query().Get("q"))
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(files))
+	case "ratelimit":
+		t, ok := s.engine.Torrent(hash)
+		if !ok {
+			return errUnknowPath
+		}
+		common.HandleError(json.NewEncoder(w).Encode(struct {
+			UploadRate   string
+			DownloadRate string
+		}{t.UploadRateLimit, t.DownloadRateLimit}))
+	case "connlimit": // the limits of the task and the connections allowed now
+		t, ok := s.engine.Torrent(hash)
+		if !ok {
+			return errUnknowPath
+		}
+		t.Lock()
+		limits := t.ConnLimits
+		t.Unlock()
+		common.HandleError(json.NewEncoder(w).Encode(struct {
+			engine.ConnLimits
+			Effective int
+		}{limits, s.engine.TorrentConnLimit(t)}))
+	case "trackers": // with the state of the announces and the swarm counts of the scrapes
+		trackers, err := s.engine.TorrentTrackerStatus(hash)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(trackers))
+	case "webseeds": // with their transfer
+		seeds, err := s.engine.TorrentWebSeeds(hash)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(seeds))
+	case "peers":
+		peers, err := s.engine.TorrentPeers(hash)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(peers))
+	case "pieces":
+		t, ok := s.engine.Torrent(hash)
+		if !ok {
+			return errUnknowPath
+		}
+		states, err := t.PieceStates()
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(states))
+	case "timeline":
+		common.HandleError(json.NewEncoder(w).Encode(s.engine.Timeline(hash)))
+	case "history": // the speeds of the last day at most: ?range=6h
+		rng, err := engine.ParseHistoryRange(r.URL.Query().Get("range"))
+		if err != nil {
+			return err
+		}
+		sh, err := s.engine.TorrentSpeedHistory(hash, rng)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(sh))
+	case "deadlines":
+		list, err := s.engine.PieceDeadlines(hash)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(list))
+	case "diagnose":
+		report, err := s.engine.Diagnose(hash)
+		if err != nil {
+			return err
+		}
+		if r.URL.Query().Get("format") == "json" {
+			common.HandleError(json.NewEncoder(w).Encode(report))
+			return nil
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = io.WriteString(w, report.String())
+		common.HandleError(err)
+	default:
+		return errUnknowAct
+	}
+	return nil
+}
+
+// torrentAction applies the action of the torrent API to the task
+func (s *Server) torrentAction(state, infohash string) error {
+	switch state {
+	case "start":
+		return s.engine.ManualStartTorrent(infohash)
+	case "superseed":
+		// started super-seeding, as the only seed of new content
+		if err := s.engine.SetTorrentSuperSeed(infohash, true); err != nil {
+			return err
+		}
+		return s.engine.ManualStartTorrent(infohash)
+	case "stop":
+		return s.engine.StopTorrent(infohash)
+	case "delete":
+		return s.engine.SoftDeleteTorrent(infohash)
+	case "trash", "deletedata":
+		// removed with the data, no undo
+		mode := engine.RemoveDataTrash
+		if state == "deletedata" {
+			mode = engine.RemoveDataDelete
+		}
+		return s.engine.RemoveTorrentData(infohash, mode)
+	case "undelete":
+		return s.engine.UndoDeleteTorrent(infohash)
+	case "verify":
+		return s.engine.VerifyTorrent(infohash)
+	case "upload":
+		return s.engine.UploadTorrent(infohash)
+	case "move2wait":
+		return s.engine.PushWaitTask(infohash)
+	case "retry":
+		return s.engine.RetryTorrent(infohash)
+	case "dismiss":
+		return s.engine.DismissTorrentError(infohash)
+	}
+	return fmt.Errorf("ERROR: Invalid state: %s", state)
+}
+
+// collectionAction applies the action to all the tasks of the collection,
+// the failed ones don't stop the others
+func (s *Server) collectionAction(r *http.Request, state, name string) error {
+	switch state {
+	case "start", "stop", "delete", "trash", "deletedata", "verify":
+	default:
+		return fmt.Errorf("ERROR: Invalid state: %s", state)
+	}
+	ihs := s.engine.CollectionTasks(name)
+	if len(ihs) == 0 {
+		return errUnknowPath
+	}
+	var failed int
+	var first error
+	for _, ih := range ihs {
+		err := s.checkOwner(r, ih)
+		if err == nil {
+			err = s.torrentAction(state, ih)
+		}
+		if err != nil {
+			log.Warnf("[collection] %s %s %s: %v", name, state, ih, err)
+			if failed++; first == nil {
+				first = err
+			}
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("%d of %d tasks failed: %w", failed, len(ihs), first)
+	}
+	return nil
+}
+
 func (s *Server) apiPOST(r *http.Request) error {
 	defer r.Body.Close()
 
@@ -99,38 +422,62 @@ func (s *Server) apiPOST(r *http.Request) error {
 		return fmt.Errorf("ERROR: Invalid request method (expecting POST)")
 	}
 
+	// checked before any outbound fetch of url/searchadd
+	if isReadOnly(r) || (adminPOST[action] && !isAdmin(r)) {
+		return errForbidden
+	}
+
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return fmt.Errorf("ERROR: Failed to download request body: %w", err)
 	}
+	//optional download directory of the added task
+	dir := r.URL.Query().Get("dir")
 
 	//convert url into torrent bytes
 	if action == "url" {
 		url := string(data)
-		remote, err := http.Get(url)
-		if err != nil {
-			return fmt.Errorf("ERROR: Invalid remote torrent URL: %s %w", url, err)
-		}
-		defer remote.Body.Close()
-		if remote.ContentLength > 512*1024 {
-			//enforce max body size (512k)
-			return fmt.Errorf("ERROR: Remote torrent too large")
-		}
-		data, err = ioutil.ReadAll(remote.Body)
+		data, err = common.FetchTorrentFile(url)
 		if err != nil {
 			return fmt.Errorf("ERROR: Failed to download remote torrent: %w", err)
 		}
 		action = "torrentfile"
 	}
 
+	//add a torznab search result by its link
+	if action == "searchadd" {
+		magnet, torrent, err := s.torznab.Fetch(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("ERROR: Failed to fetch search result: %w", err)
+		}
+		if magnet != "" {
+			data = []byte(magnet)
+			action = "magnet"
+		} else {
+			data = torrent
+			action = "torrentfile"
+		}
+	}
+
+	//add a torrent with its data already on disk: /api/import?path=...
+	if action == "import" {
+		p := strings.TrimSpace(r.URL.Query().Get("path"))
+		if p == "" {
+			return errInvalidReq
+		}
+		return ignoreQueued(s.engine.ImportTorrent(bytes.NewReader(data), p))
+	}
+
+	//initial file priorities of the added task: ?files=*:skip,0:high
+	if action == "torrentfile" || action == "magnet" {
+		if err := s.presetTask(r, data); err != nil {
+			return err
+		}
+	}
+
 	//convert torrent bytes into magnet
 	if action == "torrentfile" {
-		if err := s.engine.NewTorrentByReader(bytes.NewBuffer(data)); err != nil {
-			if !errors.Is(err, engine.ErrMaxConnTasks) {
-				return err
-			}
-		}
-		return nil
+		return ignoreQueued(s.engine.NewTorrentByReaderContext(r.Context(), bytes.NewBuffer(data), dir))
 	}
 
 	//update after action completes
@@ -139,12 +486,33 @@ func (s *Server) apiPOST(r *http.Request) error {
 	//interface with engine
 	switch action {
 	case "configure":
-		return s.apiConfigure(data)
+		return s.apiConfigure(r.Context(), data)
+	case "configrollback":
+		return s.apiConfigRollback(r.Context(), data)
+	case "restore":
+		// a backup of /api/backup, the tasks not added yet are added
+		_, err := s.engine.RestoreBackup(bytes.NewReader(data))
+		return err
+	case "update":
+		return s.apiUpdate(data)
+	case "restart":
+		return s.apiRestart()
+	case "users":
+		return s.apiUsers(r, data)
+	case "fileop":
+		return s.apiFileOp(data)
+	case "pushsubscribe":
+		if s.webpush == nil {
+			return errWebPushDisabled
+		}
+		return s.webpush.Subscribe(data)
+	case "pushunsubscribe":
+		if s.webpush == nil {
+			return errWebPushDisabled
+		}
+		return s.webpush.Unsubscribe(strings.TrimSpace(string(data)))
 	case "magnet":
-		if err := s.engine.NewMagnet(string(data)); err != nil {
-			if errors.Is(err, engine.ErrMaxConnTasks) {
-				return nil
-			}
+		if err := ignoreQueued(s.engine.NewMagnetContext(r.Context(), string(data), dir)); err != nil {
 			return fmt.Errorf("ERROR: Magnet error: %w", err)
 		}
 	case "torrent":
@@ -152,59 +520,329 @@ func (s *Server) apiPOST(r *http.Request) error {
 		if len(cmd) != 2 {
 			return errInvalidReq
 		}
+		if err := s.checkOwner(r, cmd[1]); err != nil {
+			return err
+		}
+		return s.torrentAction(cmd[0], cmd[1])
+	case "searchprovider":
+		// <enable|disable>:<id>
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 || (cmd[0] != "enable" && cmd[0] != "disable") {
+			return errInvalidReq
+		}
+		return s.enableSearchProvider(r.Context(), cmd[1], cmd[0] == "enable")
+	case "collection":
+		// <infohash>:<name>, empty name takes it out
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		return s.engine.SetTorrentCollection(cmd[0], cmd[1])
+	case "collections":
+		// <start|stop|delete|trash|deletedata|verify>:<name>
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 || cmd[1] == "" {
+			return errInvalidReq
+		}
+		return s.collectionAction(r, cmd[0], cmd[1])
+	case "watchfailures":
+		// <retry|delete>:<path>
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 || cmd[1] == "" {
+			return errInvalidReq
+		}
+		switch cmd[0] {
+		case "retry":
+			return s.engine.RetryWatchFailure(cmd[1])
+		case "delete":
+			return s.engine.DeleteWatchFailure(cmd[1])
+		}
+		return errInvalidReq
+	case "file":
+		cmd := strings.SplitN(string(data), ":", 3)
+		if len(cmd) != 3 {
+			return errInvalidReq
+		}
 		state := cmd[0]
 		infohash := cmd[1]
+		filepath := cmd[2]
+		if err := s.checkOwner(r, infohash); err != nil {
+			return err
+		}
 		switch state {
 		case "start":
-			if err := s.engine.ManualStartTorrent(infohash); err != nil {
+			if err := s.engine.StartFile(infohash, filepath); err != nil {
 				return err
 			}
 		case "stop":
-			if err := s.engine.StopTorrent(infohash); err != nil {
+			if err := s.engine.StopFile(infohash, filepath); err != nil {
 				return err
 			}
-		case "delete":
-			if err := s.engine.DeleteTorrent(infohash); err != nil {
+		case engine.FilePrioritySkip, engine.FilePriorityLow, engine.FilePriorityNormal, engine.FilePriorityHigh:
+			if err := s.engine.SetFilePriority(infohash, filepath, state); err != nil {
 				return err
 			}
-			s.engine.RemoveCache(infohash)
-		case "move2wait":
-			if err := s.engine.DeleteTorrent(infohash); err != nil {
-				return err
+		default:
+			return fmt.Errorf("ERROR: Invalid state: %s", state)
+		}
+	case "queue":
+		// <top|priority|start>:<infohash>[:<priority>]
+		cmd := strings.SplitN(string(data), ":", 3)
+		if len(cmd) < 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[1]); err != nil {
+			return err
+		}
+		switch cmd[0] {
+		case "top":
+			return s.engine.MoveWaitTaskTop(cmd[1])
+		case "priority":
+			if len(cmd) != 3 {
+				return errInvalidReq
 			}
-			if err := s.engine.PushWaitTask(infohash); err != nil {
+			p, err := strconv.Atoi(strings.TrimSpace(cmd[2]))
+			if err != nil {
 				return err
 			}
+			return s.engine.SetQueuePriority(cmd[1], p)
+		case "start":
+			return s.engine.ForceStartWaitTask(cmd[1])
 		default:
-			return fmt.Errorf("ERROR: Invalid state: %s", state)
+			return fmt.Errorf("ERROR: Invalid queue action: %s", cmd[0])
 		}
-	case "file":
+	case "label":
+		// <infohash>:<label>, empty label clears it
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		if err := s.engine.SetTorrentLabel(cmd[0], cmd[1]); err != nil {
+			return err
+		}
+	case "trackers":
+		// <add|remove|replace|prune|reannounce>:<infohash>[:<trackers>]
+		// trackers are newline separated, tiers are separated by an empty line
 		cmd := strings.SplitN(string(data), ":", 3)
-		if len(cmd) != 3 {
+		if len(cmd) < 2 {
 			return errInvalidReq
 		}
-		state := cmd[0]
 		infohash := cmd[1]
-		filepath := cmd[2]
-		switch state {
-		case "start":
-			if err := s.engine.StartFile(infohash, filepath); err != nil {
-				return err
-			}
-		case "stop":
-			if err := s.engine.StopFile(infohash, filepath); err != nil {
-				return err
+		if err := s.checkOwner(r, infohash); err != nil {
+			return err
+		}
+		var tiers [][]string
+		if len(cmd) == 3 {
+			tiers = parseTiers(cmd[2])
+		}
+		switch cmd[0] {
+		case "add":
+			return s.engine.AddTorrentTrackers(infohash, tiers)
+		case "remove":
+			var trackers []string
+			for _, tier := range tiers {
+				trackers = append(trackers, tier...)
 			}
+			return s.engine.RemoveTorrentTrackers(infohash, trackers)
+		case "replace":
+			return s.engine.SetTorrentTrackers(infohash, tiers)
+		case "prune":
+			_, err := s.engine.PruneTorrentTrackers(infohash)
+			return err
+		case "reannounce":
+			return s.engine.ReannounceTorrent(infohash)
 		default:
-			return fmt.Errorf("ERROR: Invalid state: %s", state)
+			return fmt.Errorf("ERROR: Invalid trackers action: %s", cmd[0])
+		}
+	case "webseeds":
+		// <infohash>:<urls>, newline separated BEP 19 web seeds to add
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		return s.engine.AddTorrentWebSeeds(cmd[0], strings.Split(cmd[1], "\n"))
+	case "templimit":
+		// <upload rate>:<download rate>:<HH:MM or duration>, or "cancel"
+		if strings.TrimSpace(string(data)) == "cancel" {
+			s.engine.ClearTempRateLimit()
+			return nil
+		}
+		cmd := strings.SplitN(string(data), ":", 3)
+		if len(cmd) != 3 {
+			return errInvalidReq
+		}
+		until, err := engine.ParseUntil(cmd[2], time.Now())
+		if err != nil {
+			return err
+		}
+		return s.engine.SetTempRateLimit(strings.TrimSpace(cmd[0]), strings.TrimSpace(cmd[1]), until)
+	case "trackerlist":
+		// loads the tracker list again now
+		return s.engine.RefreshTrackerList()
+	case "altrate":
+		// auto, on or off
+		return s.engine.SetAltRateMode(strings.TrimSpace(string(data)))
+	case "ratelimit":
+		// <infohash>:<upload rate>:<download rate>
+		cmd := strings.SplitN(string(data), ":", 3)
+		if len(cmd) != 3 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		if err := s.engine.SetTorrentRateLimit(cmd[0], cmd[1], cmd[2]); err != nil {
+			return err
+		}
+	case "times":
+		// <infohash>:<start at>:<expire at>[:<stop|remove>], unix seconds, 0 for none
+		cmd := strings.Split(string(data), ":")
+		if len(cmd) != 3 && len(cmd) != 4 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		startAt, err := engine.ParseTaskTime(cmd[1])
+		if err != nil {
+			return err
+		}
+		expireAt, err := engine.ParseTaskTime(cmd[2])
+		if err != nil {
+			return err
+		}
+		var action string
+		if len(cmd) == 4 {
+			action = strings.TrimSpace(cmd[3])
+		}
+		return s.engine.SetTorrentTimes(cmd[0], startAt, expireAt, action)
+	case "connlimit":
+		// <infohash>:<connections>:<upload slots>, 0 for the global limits
+		cmd := strings.SplitN(string(data), ":", 3)
+		if len(cmd) != 3 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		conns, err := strconv.Atoi(strings.TrimSpace(cmd[1]))
+		if err != nil {
+			return errInvalidReq
+		}
+		slots, err := strconv.Atoi(strings.TrimSpace(cmd[2]))
+		if err != nil {
+			return errInvalidReq
+		}
+		if err := s.engine.SetTorrentConnLimits(cmd[0], conns, slots); err != nil {
+			return err
+		}
+	case "deadline":
+		// <infohash>:<offset>:<length>:<within|clear>:<path>, for the
+		// external players, length 0 to the end of the file
+		cmd := strings.SplitN(string(data), ":", 5)
+		if len(cmd) != 5 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		if cmd[3] == "clear" {
+			return s.engine.ClearPieceDeadlines(cmd[0], cmd[4])
+		}
+		off, err := strconv.ParseInt(cmd[1], 10, 64)
+		if err != nil {
+			return errInvalidReq
 		}
+		length, err := strconv.ParseInt(cmd[2], 10, 64)
+		if err != nil {
+			return errInvalidReq
+		}
+		in, err := time.ParseDuration(cmd[3])
+		if err != nil {
+			return errInvalidReq
+		}
+		if _, err := s.engine.SetPieceDeadline(cmd[0], cmd[4], off, length, in); err != nil {
+			return err
+		}
+	case "seedlimits":
+		// <infohash>:<ratio>:<seed time>:<idle time>
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		if err := s.engine.SetTorrentSeedLimits(cmd[0], cmd[1]); err != nil {
+			return err
+		}
+	case "schedule":
+		// <infohash>:<schedule>, lines or ";" separated, empty downloads anytime
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		return s.engine.SetTorrentSchedule(cmd[0], cmd[1])
+	case "encryption":
+		// <infohash>:<policy>, "require" or empty to follow the config
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		return s.engine.SetTorrentEncryption(cmd[0], strings.TrimSpace(cmd[1]))
+	case "superseed":
+		// <infohash>:<true|false>
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		on, err := strconv.ParseBool(strings.TrimSpace(cmd[1]))
+		if err != nil {
+			return errInvalidReq
+		}
+		return s.engine.SetTorrentSuperSeed(cmd[0], on)
+	case "rename":
+		// <infohash>:<name>
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		if err := s.checkOwner(r, cmd[0]); err != nil {
+			return err
+		}
+		return s.engine.RenameTorrent(cmd[0], strings.TrimSpace(cmd[1]))
+	case "location":
+		// <infohash>:<dir>, empty for the download directory
+		cmd := strings.SplitN(string(data), ":", 2)
+		if len(cmd) != 2 {
+			return errInvalidReq
+		}
+		return s.engine.SetTorrentLocation(cmd[0], strings.TrimSpace(cmd[1]))
 	default:
 		return fmt.Errorf("ERROR: Invalid action: %s", action)
 	}
 	return nil
 }
 
-func (s *Server) apiConfigure(data []byte) error {
+func (s *Server) apiConfigure(ctx context.Context, data []byte) error {
 
 	if !s.engineConfig.AllowRuntimeConfigure {
 		return errors.New("AllowRuntimeConfigure is set to false")
@@ -214,15 +852,44 @@ func (s *Server) apiConfigure(data []byte) error {
 	if err := json.Unmarshal(data, &c); err != nil {
 		return err
 	}
+	return s.applyConfig(ctx, c, true)
+}
+
+// apiConfigRollback restores a previous config file listed by GET /api/configversions
+func (s *Server) apiConfigRollback(ctx context.Context, data []byte) error {
+	if !s.engineConfig.AllowRuntimeConfigure {
+		return errors.New("AllowRuntimeConfigure is set to false")
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errInvalidReq
+	}
+	c, err := engine.LoadConfigVersion(version)
+	if err != nil {
+		return err
+	}
+	log.Printf("[api] rolling back to config version %d", version)
+	return s.applyConfig(ctx, *c, true)
+}
+
+// applyConfig applies the new config, saved to the config file if save. Only
+// the changes of the options the torrent client is built with rebuild it,
+// dropping and restoring the tasks.
+func (s *Server) applyConfig(ctx context.Context, c engine.Config, save bool) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
 	if _, err := c.NormlizeConfigDir(); err != nil {
 		return err
 	}
+	if err := c.Check(); err != nil {
+		return fmt.Errorf("ERROR: Invalid config: %w", err)
+	}
 
-	if !reflect.DeepEqual(s.engineConfig, c) {
+	if !reflect.DeepEqual(*s.engineConfig, c) {
 		status := s.engineConfig.Validate(&c)
 
 		if status&engine.ForbidRuntimeChange > 0 {
-			log.Printf("[api] warnning! someone tried to change DoneCmd config")
+			log.Warnf("[api] warnning! someone tried to change DoneCmd/Hooks config")
 			return errors.New("ERROR: This item is NOT allowed being changed on runtime")
 		}
 		if status&engine.NeedRestartWatch > 0 {
@@ -233,17 +900,19 @@ func (s *Server) apiConfigure(data []byte) error {
 		// now it's safe to save the configure
 		s.engineConfig.SyncViper(c)
 		s.engineConfig = &c
-		if err := s.engineConfig.WriteDefault(); err != nil {
-			return err
+		if save {
+			if err := s.engineConfig.WriteDefault(); err != nil {
+				return err
+			}
+			log.Printf("[api] config saved")
 		}
-		log.Printf("[api] config saved")
 
 		// finally to reconfigure the engine
 		if status&engine.NeedEngineReConfig > 0 {
-			if err := s.engine.Configure(s.engineConfig); err != nil {
+			if err := s.engine.ConfigureContext(ctx, s.engineConfig); err != nil {
 				if !s.engine.IsConfigred() {
 					go func() {
-						log.Println("[apiConfigure] serious error occured while reconfigured, will exit in 10s")
+						log.Error("[apiConfigure] serious error occured while reconfigured, will exit in 10s")
 						time.Sleep(time.Second * 10)
 						log.Fatalln(err)
 					}()
@@ -265,7 +934,7 @@ func (s *Server) apiConfigure(data []byte) error {
 		s.state.Push()
 
 		// do after config synced
-		s.state.UseQueue = (s.engineConfig.MaxConcurrentTask > 0)
+		s.state.UseQueue = s.engineConfig.QueueEnabled()
 		if status&engine.NeedLoadWaitList > 0 {
 			go func() {
 				for {
@@ -281,8 +950,8 @@ func (s *Server) apiConfigure(data []byte) error {
 		log.Printf("[api] configure unchanged")
 	}
 
-	// update search config anyway
-	go s.fetchSearchConfig(s.engineConfig.ScraperURL) // nolint: errcheck
+	// update search providers anyway
+	go s.loadSearchProviders()
 	return nil
 }
 
@@ -295,3 +964,62 @@ func (s *Server) GetBoolAttribute(name string) bool {
 	cval := reflect.Indirect(reflect.ValueOf(s)).FieldByName(name)
 	return cval.Bool()
 }
+
+// parseTiers splits the newline separated trackers into tiers by empty lines
+func parseTiers(s string) [][]string {
+	var tiers [][]string
+	var tier []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(tier) > 0 {
+				tiers = append(tiers, tier)
+				tier = nil
+			}
+			continue
+		}
+		tier = append(tier, line)
+	}
+	if len(tier) > 0 {
+		tiers = append(tiers, tier)
+	}
+	return tiers
+}
+
+// presetTask tells the engine the user adding the task and the files query
+// of the add requests, the priorities of the files by index, "*" for the
+// ones not listed. The startAt and expireAt queries, unix seconds or RFC 3339,
+// with expireAction schedule the task.
+func (s *Server) presetTask(r *http.Request, data []byte) error {
+	if err := s.presetOwner(r, data); err != nil {
+		return err
+	}
+	if err := s.presetTimes(r, data); err != nil {
+		return err
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("files"))
+	if q == "" {
+		return nil
+	}
+	prios, err := engine.ParseFilePriorities(q)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	return s.engine.PresetFilePriorities(data, prios)
+}
+
+func (s *Server) presetTimes(r *http.Request, data []byte) error {
+	q := r.URL.Query()
+	if q.Get("startAt") == "" && q.Get("expireAt") == "" {
+		return nil
+	}
+	startAt, err := engine.ParseTaskTime(q.Get("startAt"))
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	expireAt, err := engine.ParseTaskTime(q.Get("expireAt"))
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	return s.engine.PresetTimes(data, startAt, expireAt, q.Get("expireAction"))
+}
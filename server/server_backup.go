@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// apiBackup streams a tar.gz of the config, the users, the API tokens and
+// the tasks with their state, restored by /api/restore or --restore
+func (s *Server) apiBackup(w http.ResponseWriter) error {
+	cf := viper.ConfigFileUsed()
+	dir := filepath.Dir(cf)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="simple-torrent-backup-%s.tar.gz"`,
+		time.Now().Format("20060102-150405")))
+	return s.engine.WriteBackup(w, s.tpl.Version, cf,
+		[]string{filepath.Join(dir, usersFile), filepath.Join(dir, tokensFile)})
+}
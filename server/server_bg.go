@@ -4,14 +4,19 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/telegram"
 )
 
 func (s *Server) backgroundRoutines() {
 
-	go s.fetchSearchConfig(s.engineConfig.ScraperURL) // nolint: errcheck
+	go s.loadSearchProviders()
 
 	// initial state
 	s.state.Stats.System.loadStats()
+	// one pending event stands for any number of changes
+	changed, _ := s.engine.Subscribe(1, engine.EventChanged)
 	//collecting sys stats
 	go func() {
 		for {
@@ -20,13 +25,15 @@ func (s *Server) backgroundRoutines() {
 				if atomic.CompareAndSwapInt32(&(s.syncSemphor), 0, 1) {
 					go s.tickerRoutine()
 				}
-			case <-s.engine.TsChanged: // task added/deleted
+			case <-changed: // task added/deleted
 				s.engine.RLock()
 				s.state.Push()
 				s.engine.RUnlock()
+				s.diffs.notify()
 			}
 		}
 	}()
+	go s.diffs.run(s.torrentsJSON, time.Duration(s.IntevalSec)*time.Second)
 
 	// rss updater
 	go func() {
@@ -43,9 +50,15 @@ func (s *Server) backgroundRoutines() {
 		}
 	}()
 
+	// rss auto downloader, idles until RssRulesFile is configured
+	s.startRSSDownloader()
+
+	// telegram bot, idles until TelegramToken is configured
+	go telegram.New(s.engine).Run()
+
 	go s.engine.RestoreCacheDir()
 	if err := s.engine.StartTorrentWatcher(); err != nil {
-		log.Println(err)
+		log.Warn(err)
 	}
 }
 
@@ -54,7 +67,7 @@ func (s *Server) tickerRoutine() {
 	defer atomic.StoreInt32(&(s.syncSemphor), 0)
 
 	tick := time.Duration(s.IntevalSec) * time.Second
-	log.Println("[tickerRoutine] sync connected, ticking for", tick)
+	log.Debug("[tickerRoutine] sync connected, ticking for", tick)
 	tk := time.NewTicker(tick)
 	defer tk.Stop()
 
@@ -69,11 +82,18 @@ func (s *Server) tickerRoutine() {
 		case <-tk.C:
 			s.state.Stats.System.loadStats()
 			s.state.Stats.ConnStat = s.engine.ConnStat()
+			s.state.Stats.Blocklist = s.engine.BlocklistStats()
+			s.state.Stats.Restore = s.engine.RestoreProgress()
+			s.state.Stats.Memory = s.engine.MemoryStats()
+			s.state.Stats.Background = s.engine.BackgroundStats()
+			s.state.Stats.LowDisk = s.engine.LowDiskStatus()
+			s.state.Stats.Speed = s.engine.SpeedStats()
+			s.state.Stats.Proxy = s.engine.ProxyStatus()
 			s.engine.RLock()
 			s.state.Push()
 			s.engine.RUnlock()
 		case <-done:
-			log.Println("[tickerRoutine] sync exit")
+			log.Debug("[tickerRoutine] sync exit")
 			return
 		}
 	}
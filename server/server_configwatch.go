@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// the editors write the config file in several steps, it's read once they
+// are done for this long
+const configSettle = time.Second
+
+// watchConfigFile applies the config file edited outside, eg: by hand or a
+// config management tool, as if saved by the web UI. The directory is
+// watched as the editors replace the file.
+func (s *Server) watchConfigFile() error {
+	cf, err := filepath.Abs(viper.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(cf)); err != nil {
+		watcher.Close()
+		return err
+	}
+	log.Println("[config] watching", cf)
+	go func() {
+		settle := time.NewTimer(configSettle)
+		settle.Stop()
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) == cf && ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0 {
+					settle.Reset(configSettle)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn("[config] watch", err)
+			case <-settle.C:
+				s.reloadConfigFile()
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadConfigFile applies the config file if changed, the same way as the
+// config posted to the API
+func (s *Server) reloadConfigFile() {
+	c, err := engine.ReadConfigFile()
+	if err != nil {
+		log.Warn("[config] reload", err)
+		return
+	}
+	// set by the command line
+	c.EngineDebug = s.DebugTorrent
+	// eg: saved by the web UI
+	if _, err := c.NormlizeConfigDir(); err == nil {
+		s.configMu.Lock()
+		unchanged := reflect.DeepEqual(*c, *s.engineConfig)
+		s.configMu.Unlock()
+		if unchanged {
+			return
+		}
+	}
+	log.Println("[config] config file changed, reloading")
+	if err := s.applyConfig(context.Background(), *c, false); err != nil {
+		log.Warn("[config] reload", err)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// createRequest is the body of POST /api/createtorrent
+type createRequest struct {
+	Path        string
+	Trackers    []string
+	Private     bool
+	PieceLength int64
+}
+
+// apiCreateTorrent makes a torrent of the data in the download directory and
+// seeds it, responding {InfoHash, Magnet}, or the .torrent with ?format=torrent
+func (s *Server) apiCreateTorrent(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxTorrentSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Failed to read the request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var req createRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Path == "" {
+		http.Error(w, errInvalidReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mi, err := s.engine.CreateTorrent(req.Path, req.Trackers, req.Private, req.PieceLength)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Failed to create the torrent: %v", err), http.StatusBadRequest)
+		return
+	}
+	s.state.Push()
+
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") == "torrent" {
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name+".torrent"))
+		common.HandleError(mi.Write(w))
+		return
+	}
+	ih := mi.HashInfoBytes()
+	w.Header().Set("Content-Type", "application/json")
+	common.HandleError(json.NewEncoder(w).Encode(struct {
+		InfoHash string
+		Magnet   string
+	}{ih.HexString(), mi.Magnet(&ih, &info).String()}))
+}
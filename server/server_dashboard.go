@@ -0,0 +1,77 @@
+package server
+
+import (
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// dashboardStats is a summary of the engine for dashboards and status bars,
+// it's much lighter than the full torrent list
+type dashboardStats struct {
+	Torrents     int     `json:"torrents"`
+	Active       int     `json:"active"`
+	Downloading  int     `json:"downloading"`
+	Seeding      int     `json:"seeding"`
+	Queued       int     `json:"queued"`
+	Stopped      int     `json:"stopped"`
+	Errored      int     `json:"errored"`
+	Waiting      int     `json:"waiting"`
+	DownloadRate float32 `json:"downloadRate"`
+	UploadRate   float32 `json:"uploadRate"`
+	Downloaded   int64   `json:"downloaded"`
+	Uploaded     int64   `json:"uploaded"`
+	Peers        int     `json:"peers"`
+	DiskFree     uint64  `json:"diskFree"`
+	DiskTotal    uint64  `json:"diskTotal"`
+	Uptime       int64   `json:"uptime"`
+	Version      string  `json:"version"`
+	Runtime      string  `json:"runtime"`
+	// restoring the tasks at startup, and the hash checks
+	Restore engine.RestoreProgress `json:"restore"`
+}
+
+func (s *Server) dashboardStats() *dashboardStats {
+	d := &dashboardStats{
+		Waiting: s.engine.WaitListLen(),
+		Restore: s.engine.RestoreProgress(),
+		Uptime:  time.Now().Unix() - s.tpl.Uptime,
+		Version: s.tpl.Version,
+		Runtime: s.tpl.Runtime,
+	}
+
+	for _, t := range s.engine.Torrents() {
+		t.Lock()
+		d.Torrents++
+		switch {
+		case t.MetadataTimeout:
+			d.Errored++
+		case t.IsQueueing:
+			d.Queued++
+		case !t.Started:
+			d.Stopped++
+		case t.Done:
+			d.Active++
+			d.Seeding++
+		default:
+			d.Active++
+			d.Downloading++
+		}
+		d.DownloadRate += t.DownloadRate
+		d.UploadRate += t.UploadRate
+		if t.Stats != nil {
+			d.Peers += t.Stats.ActivePeers
+		}
+		t.Unlock()
+	}
+
+	cs := s.engine.ConnStat()
+	d.Downloaded = cs.BytesReadUsefulData.Int64()
+	d.Uploaded = cs.BytesWrittenData.Int64()
+	if stat, err := disk.Usage(s.engineConfig.DownloadDirectory); err == nil {
+		d.DiskFree = stat.Free
+		d.DiskTotal = stat.Total
+	}
+	return d
+}
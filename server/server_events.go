@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// the removed torrents remembered for /api/torrents?since=, older versions
+// get a full snapshot
+const maxTombstones = 1024
+
+// torrentsDiff is the message pushed to /sync/torrents clients, the first
+// message is a full snapshot, the following ones only contain changes. It's
+// also the answer of /api/torrents?since=<version>.
+type torrentsDiff struct {
+	Version uint64                     `json:"version"`
+	Full    bool                       `json:"full,omitempty"`
+	Changed map[string]json.RawMessage `json:"changed,omitempty"`
+	Removed []string                   `json:"removed,omitempty"`
+}
+
+// diffHub tracks the last pushed json of every torrent and fans out diffs
+type diffHub struct {
+	sync.Mutex
+	subs    map[chan []byte]struct{}
+	last    map[string][]byte
+	trigger chan struct{}
+	// bumped on every change, starts at the startup time in milliseconds so
+	// the versions of a previous run are older (and under 2^53 for the JS)
+	version uint64
+	// the version a torrent last changed at, and removed at
+	versions   map[string]uint64
+	tombstones map[string]uint64
+	// the changes up to it are unknown, a full snapshot is sent
+	pruned uint64
+}
+
+func newDiffHub() *diffHub {
+	start := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	return &diffHub{
+		subs:       make(map[chan []byte]struct{}),
+		last:       make(map[string][]byte),
+		trigger:    make(chan struct{}, 1),
+		version:    start,
+		versions:   make(map[string]uint64),
+		tombstones: make(map[string]uint64),
+		pruned:     start,
+	}
+}
+
+// notify requests a diff to be computed, never blocks
+func (h *diffHub) notify() {
+	select {
+	case h.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// update compares cur to the last snapshot and broadcasts the changes, must hold lock
+func (h *diffHub) update(cur map[string][]byte) {
+	diff := torrentsDiff{Changed: make(map[string]json.RawMessage)}
+	for ih, b := range cur {
+		if old, ok := h.last[ih]; !ok || !bytes.Equal(old, b) {
+			diff.Changed[ih] = b
+		}
+	}
+	for ih := range h.last {
+		if _, ok := cur[ih]; !ok {
+			diff.Removed = append(diff.Removed, ih)
+		}
+	}
+	h.last = cur
+	if len(diff.Changed) == 0 && len(diff.Removed) == 0 {
+		return
+	}
+	h.version++
+	diff.Version = h.version
+	for ih := range diff.Changed {
+		h.versions[ih] = h.version
+		delete(h.tombstones, ih)
+	}
+	for _, ih := range diff.Removed {
+		delete(h.versions, ih)
+		h.tombstones[ih] = h.version
+	}
+	h.pruneTombstones()
+
+	msg, err := json.Marshal(diff)
+	if common.HandleError(err) {
+		return
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- msg:
+		default:
+			// slow client, drop it and let it reconnect for a full snapshot
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// pruneTombstones drops the oldest tombstones over maxTombstones, must hold lock
+func (h *diffHub) pruneTombstones() {
+	for len(h.tombstones) > maxTombstones {
+		var oldest string
+		for ih, v := range h.tombstones {
+			if oldest == "" || v < h.tombstones[oldest] {
+				oldest = ih
+			}
+		}
+		if v := h.tombstones[oldest]; v > h.pruned {
+			h.pruned = v
+		}
+		delete(h.tombstones, oldest)
+	}
+}
+
+// full is the snapshot of all the torrents, must hold lock
+func (h *diffHub) full() torrentsDiff {
+	full := torrentsDiff{Version: h.version, Full: true, Changed: make(map[string]json.RawMessage)}
+	for ih, b := range h.last {
+		full.Changed[ih] = b
+	}
+	return full
+}
+
+func (h *diffHub) subscribe(cur map[string][]byte) chan []byte {
+	h.Lock()
+	defer h.Unlock()
+	h.update(cur)
+
+	ch := make(chan []byte, 16)
+	if msg, err := json.Marshal(h.full()); err == nil {
+		ch <- msg
+	}
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+// since returns the torrents changed and removed after the version, all of
+// them if the version is unknown: pruned, of a previous run or 0
+func (h *diffHub) since(version uint64, cur map[string][]byte) torrentsDiff {
+	h.Lock()
+	defer h.Unlock()
+	h.update(cur)
+	if version < h.pruned || version > h.version {
+		return h.full()
+	}
+	diff := torrentsDiff{Version: h.version, Changed: make(map[string]json.RawMessage)}
+	for ih, v := range h.versions {
+		if v > version {
+			diff.Changed[ih] = h.last[ih]
+		}
+	}
+	for ih, v := range h.tombstones {
+		if v > version {
+			diff.Removed = append(diff.Removed, ih)
+		}
+	}
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+func (h *diffHub) unsubscribe(ch chan []byte) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *diffHub) run(snapshot func() map[string][]byte, tick time.Duration) {
+	tk := time.NewTicker(tick)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+		case <-h.trigger:
+		}
+		h.Lock()
+		if len(h.subs) > 0 {
+			h.update(snapshot())
+		}
+		h.Unlock()
+	}
+}
+
+// torrentsJSON marshals every torrent separately for diffing
+func (s *Server) torrentsJSON() map[string][]byte {
+	ts := s.engine.Torrents()
+	m := make(map[string][]byte, len(ts))
+	for ih, t := range ts {
+		t.Lock()
+		b, err := json.Marshal(t)
+		t.Unlock()
+		if err == nil {
+			m[ih] = b
+		}
+	}
+	return m
+}
+
+// serveTorrentsSync pushes the torrents diffs by server-sent events
+func (s *Server) serveTorrentsSync(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// avoid gzip buffer
+	w.Header().Set("Content-Encoding", "identity")
+
+	ch := s.diffs.subscribe(s.torrentsJSON())
+	defer s.diffs.unsubscribe(ch)
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// apiExport streams the state as a single JSON document for backups and
+// audits: {"ExportedAt":...,"Version":...,"Config":...,"Stats":...,"Torrents":[...]}.
+// The secrets of the config are masked, the torrents are encoded one by one.
+func (s *Server) apiExport(w http.ResponseWriter) error {
+	s.state.Stats.System.loadStats()
+	s.state.Stats.ConnStat = s.engine.ConnStat()
+	s.state.Stats.Blocklist = s.engine.BlocklistStats()
+	s.state.Stats.Restore = s.engine.RestoreProgress()
+	s.state.Stats.Memory = s.engine.MemoryStats()
+	s.state.Stats.Background = s.engine.BackgroundStats()
+	s.state.Stats.LowDisk = s.engine.LowDiskStatus()
+	s.state.Stats.Speed = s.engine.SpeedStats()
+	s.state.Stats.Proxy = s.engine.ProxyStatus()
+
+	w.Header().Set("Content-Disposition", `attachment; filename="simple-torrent-export.json"`)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	fields := []struct {
+		name string
+		v    interface{}
+	}{
+		{"ExportedAt", time.Now()},
+		{"Version", s.tpl.Version},
+		{"Config", s.engineConfig.Redacted()},
+		{"Stats", s.state.Stats},
+	}
+	bw.WriteString("{")
+	for _, f := range fields {
+		bw.WriteString(`"` + f.name + `":`)
+		if err := enc.Encode(f.v); err != nil {
+			return err
+		}
+		bw.WriteString(",")
+	}
+
+	ts := s.engine.Torrents()
+	ihs := make([]string, 0, len(ts))
+	for ih := range ts {
+		ihs = append(ihs, ih)
+	}
+	sort.Strings(ihs)
+	bw.WriteString(`"Torrents":[`)
+	for i, ih := range ihs {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		t := ts[ih]
+		t.Lock()
+		err := enc.Encode(t)
+		t.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	bw.WriteString("]}\n")
+	return bw.Flush()
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"encoding/json"
+)
+
+// apiFileOp renames, moves or deletes a file or directory in the download
+// directory: POST /api/fileop {"Action":"rename|move|delete","Path","To"}.
+// Path and To are relative to the download directory, To is the new name to
+// rename or the directory to move into.
+func (s *Server) apiFileOp(data []byte) error {
+	req := struct {
+		Action string
+		Path   string
+		To     string
+	}{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	switch req.Action {
+	case "rename":
+		return s.engine.RenameData(req.Path, req.To)
+	case "move":
+		return s.engine.MoveData(req.Path, req.To)
+	case "delete":
+		return s.engine.DeleteData(req.Path)
+	}
+	return errInvalidReq
+}
@@ -28,13 +28,16 @@ func (s *Server) listFiles() *fsNode {
 	root := &fsNode{}
 	if info, err := os.Stat(rootDir); err == nil {
 		if err := list(rootDir, info, root, new(uint)); err != nil {
-			log.Printf("File listing failed: %s", err)
+			log.Warnf("File listing failed: %s", err)
 		}
 	}
 	return root
 }
 
 func (s *Server) serveDownloadFiles(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "zip/") && s.serveTorrentZip(w, r, r.URL.Path[len("zip/"):]) {
+		return
+	}
 	//dldir is absolute
 	dldir := s.engineConfig.DownloadDirectory
 	file, err := filepath.Abs(filepath.Join(dldir, r.URL.Path))
@@ -66,8 +69,12 @@ func (s *Server) serveDownloadFiles(w http.ResponseWriter, r *http.Request) {
 			http.ServeFile(w, r, file)
 		}
 	case "DELETE":
-		if err := os.RemoveAll(file); err != nil {
-			http.Error(w, "Delete failed: "+err.Error(), http.StatusInternalServerError)
+		if !isAdmin(r) {
+			http.Error(w, errForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		if err := s.engine.DeleteData(r.URL.Path); err != nil {
+			http.Error(w, "Delete failed: "+err.Error(), http.StatusBadRequest)
 		}
 	default:
 		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/boypt/simple-torrent/server/ftpserver"
+)
+
+// startFTP serves DownloadDirectory read only by FTP, with the accounts of
+// the web UI, the dot files like the cached torrents hidden
+func (s *Server) startFTP(isTLS bool) error {
+	min, max, err := ftpserver.ParsePortRange(s.FTPPassive)
+	if err != nil {
+		return err
+	}
+	fs := &ftpserver.Server{
+		Root:       func() string { return s.engineConfig.DownloadDirectory },
+		Login:      s.checkLogin,
+		PassiveMin: min,
+		PassiveMax: max,
+		Hidden:     func(name string) bool { return strings.HasPrefix(name, ".") },
+	}
+	if isTLS {
+		cert, err := tls.LoadX509KeyPair(s.CertPath, s.KeyPath)
+		if err != nil {
+			return err
+		}
+		fs.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	go func() {
+		if err := fs.ListenAndServe(s.FTPListen); err != nil {
+			log.Warn("[FTP] err", err)
+		}
+	}()
+	return nil
+}
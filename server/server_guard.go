@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/boypt/simple-torrent/server/httpmiddleware"
+)
+
+// setupGuard sets the IP lists, the rate limit and the login lockout of the
+// web server from the flags
+func (s *Server) setupGuard() error {
+	g := &httpmiddleware.IPGuard{Rate: float64(s.RateLimit), MaxFails: s.AuthMaxFails}
+	var err error
+	if g.Allow, err = httpmiddleware.ParseCIDRs(s.AllowIPs); err != nil {
+		return fmt.Errorf("invalid --allow-ips: %w", err)
+	}
+	if g.Deny, err = httpmiddleware.ParseCIDRs(s.DenyIPs); err != nil {
+		return fmt.Errorf("invalid --deny-ips: %w", err)
+	}
+	if g.TrustedProxies, err = httpmiddleware.ParseCIDRs(s.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid --trusted-proxies: %w", err)
+	}
+	if s.AuthMaxFails > 0 {
+		if g.Lockout, err = time.ParseDuration(s.AuthLockout); err != nil || g.Lockout <= 0 {
+			return fmt.Errorf("invalid --auth-lockout %q, expecting a duration like 15m", s.AuthLockout)
+		}
+	}
+	if len(g.Allow) > 0 || len(g.Deny) > 0 {
+		log.Printf("[IPGuard] %d allowed and %d denied networks", len(g.Allow), len(g.Deny))
+	}
+	s.guard = g
+	return nil
+}
+
+// countLogin counts the logins by basic auth of the client for the lockout
+func (s *Server) countLogin(r *http.Request, ok bool) {
+	if ok {
+		s.guard.LoginSucceeded(r)
+		return
+	}
+	if s.guard.LoginFailed(r) {
+		log.Warnf("[IPGuard] %s locked out for %s after %d failed logins", s.guard.ClientIP(r), s.guard.Lockout, s.AuthMaxFails)
+	}
+}
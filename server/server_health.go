@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// probeBypass serves the /healthz and /readyz probes of Docker HEALTHCHECK
+// and Kubernetes before the auth layer, other requests go to next
+func (s *Server) probeBypass(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			s.serveHealthz(w, r)
+		case "/readyz":
+			s.serveReadyz(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveHealthz answers while the process is up
+func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, r, http.StatusOK, struct {
+		Status  string
+		Version string
+		Uptime  int64
+	}{"ok", s.tpl.Version, time.Now().Unix() - s.tpl.Uptime})
+}
+
+// serveReadyz answers 503 until the engine is ready to download, see
+// engine.Readiness
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	rr := s.engine.Readiness()
+	status := http.StatusOK
+	if !rr.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeProbe(w, r, status, rr)
+}
+
+func writeProbe(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	common.HandleError(json.NewEncoder(w).Encode(v))
+}
@@ -1,13 +1,18 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/qbittorrent"
 	ctstatic "github.com/boypt/simple-torrent/static"
 	"github.com/jpillora/velox"
 )
@@ -34,7 +39,7 @@ func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
 		}
 		conn, err := velox.Sync(&s.state, w, r)
 		if err != nil {
-			log.Printf("sync failed: %s", err)
+			log.Warnf("sync failed: %s", err)
 			return
 		}
 		ukey := conn.ID() + "|" + r.RemoteAddr
@@ -62,9 +67,23 @@ func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		s.restAPIhandle(w, r)
+		s.tracedAPI.ServeHTTP(w, r)
 	case "download":
 		s.dlfilesh.ServeHTTP(w, r)
+	case "stream":
+		s.streamh.ServeHTTP(w, r)
+	case "play":
+		s.playh.ServeHTTP(w, r)
+	case "transmission":
+		s.transmissionh.ServeHTTP(w, r)
+	case "metrics":
+		s.serveMetrics(w, r)
+	case "sync":
+		if r.URL.Path != "/sync/torrents" {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveTorrentsSync(w, r)
 	case s.tpl.Version:
 		w.Header().Set("Expires", time.Now().UTC().AddDate(0, 6, 0).Format(http.TimeFormat))
 		w.Header().Set("Cache-Control", "max-age:290304000, public")
@@ -78,12 +97,78 @@ func (s *Server) webHandle(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// cleanBasePath normalizes --base-path to /<prefix> without the trailing slash,
+// empty for the root
+func cleanBasePath(p string) string {
+	p = path.Clean("/" + strings.TrimSpace(p))
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+// basePathWrap strips --base-path from the requests, the UI uses relative
+// URLs so it's opened at <base>/
+func (s *Server) basePathWrap(h http.Handler) http.Handler {
+	if s.BasePath == "" {
+		return h
+	}
+	strip := http.StripPrefix(s.BasePath, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == s.BasePath:
+			u := *r.URL
+			u.Path += "/"
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, s.BasePath+"/"):
+			strip.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
 // restAPIhandle is used both by main webserver and restapi server
 func (s *Server) restAPIhandle(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, qbittorrent.Prefix) {
+		s.qbith.ServeHTTP(w, r)
+		return
+	}
+	if r.Method == "POST" && r.URL.Path == "/api/torrentzip" {
+		s.apiTorrentZip(w, r)
+		return
+	}
+	if r.Method == "POST" && r.URL.Path == "/api/inspect" {
+		s.apiInspect(w, r)
+		return
+	}
+	if r.Method == "POST" && r.URL.Path == "/api/tokens" {
+		s.apiTokens(w, r)
+		return
+	}
+	if r.Method == "POST" && r.URL.Path == "/api/createtorrent" {
+		if !isAdmin(r) {
+			http.Error(w, errForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		s.apiCreateTorrent(w, r)
+		return
+	}
 	switch r.Method {
 	case "POST":
-		if err := s.apiPOST(r); err != nil {
-			http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+		if err := s.idempotent(r, func() error { return s.apiPOST(r) }); err != nil {
+			var dse *engine.DiskSpaceError
+			if errors.As(err, &dse) {
+				// structured for the clients to tell the needed space
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInsufficientStorage)
+				common.HandleError(json.NewEncoder(w).Encode(struct {
+					Error string
+					*engine.DiskSpaceError
+				}{dse.Error(), dse}))
+				return
+			}
+			http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), apiErrorStatus(err))
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -91,7 +176,7 @@ func (s *Server) restAPIhandle(w http.ResponseWriter, r *http.Request) {
 		common.HandleError(err)
 	case "GET":
 		if err := s.apiGET(w, r); err != nil {
-			http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), http.StatusBadRequest)
+			http.Error(w, fmt.Sprintf("%s:%s:%v", r.Method, r.URL, err.Error()), apiErrorStatus(err))
 			return
 		}
 	default:
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeCacheDir next to the config file keeps the account and the certificates
+// of Let's Encrypt
+const acmeCacheDir = "acme-certs"
+
+// setupHTTPS returns the TLS config of the listener, nil for plain HTTP. The
+// certificates of --https-domain are obtained and renewed by ACME, the ones
+// of --key-path/--cert-path are loaded from the files
+func (s *Server) setupHTTPS() (*tls.Config, error) {
+	hasFiles := s.CertPath != "" || s.KeyPath != "" //poor man's XOR
+	if hasFiles && (s.CertPath == "" || s.KeyPath == "") {
+		return nil, errors.New("ERROR: You must provide both key and cert paths")
+	}
+	if s.HTTPSDomain != "" && hasFiles {
+		return nil, errors.New("--https-domain can't be used with --key-path/--cert-path")
+	}
+	if (s.HTTPSDomain != "" || s.HTTPSRedirect != "") && isListenOnUnix {
+		return nil, errors.New("--https-domain and --https-redirect need a TCP --listen")
+	}
+
+	var tc *tls.Config
+	var m *autocert.Manager
+	switch {
+	case s.HTTPSDomain != "":
+		var domains []string
+		for _, d := range strings.Split(s.HTTPSDomain, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				domains = append(domains, d)
+			}
+		}
+		m = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(filepath.Join(filepath.Dir(viper.ConfigFileUsed()), acmeCacheDir)),
+			Email:      s.HTTPSEmail,
+		}
+		tc = m.TLSConfig()
+		log.Printf("[https] certificates of %v by ACME", domains)
+	case hasFiles:
+		cert, err := tls.LoadX509KeyPair(s.CertPath, s.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		tc = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	if s.HTTPSRedirect != "" {
+		if tc == nil {
+			return nil, errors.New("--https-redirect needs --https-domain or --key-path/--cert-path")
+		}
+		_, port, _ := net.SplitHostPort(s.Listen)
+		var h http.Handler = httpsRedirect(port)
+		if m != nil {
+			// also answers the http-01 challenges
+			h = m.HTTPHandler(h)
+		}
+		go func() {
+			log.Println("[https] redirecting HTTP at", s.HTTPSRedirect)
+			if err := http.ListenAndServe(s.HTTPSRedirect, h); err != nil {
+				log.Warn("[https] redirect err", err)
+			}
+		}()
+	}
+	return tc, nil
+}
+
+// httpsRedirect redirects the requests to the same URL by HTTPS at the port
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
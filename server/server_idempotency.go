@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the results of the keyed requests are remembered for the retries
+const idempotencyTTL = time.Hour
+
+// errIdempotencyMismatch is a key reused for another request body
+var errIdempotencyMismatch = errors.New("Idempotency-Key reused with a different request")
+
+type idempotentResult struct {
+	body [sha256.Size]byte
+	done chan struct{}
+	err  error
+	at   time.Time
+}
+
+// idempotencyCache maps the Idempotency-Key of the POST requests to their results
+type idempotencyCache struct {
+	sync.Mutex
+	m map[string]*idempotentResult
+}
+
+// idempotent runs fn once for the requests of a user with the same Idempotency-Key
+// header and path, the retries get the result of the first one, waiting for it if
+// still running. Failures aren't remembered, so they can be retried.
+func (s *Server) idempotent(r *http.Request, fn func() error) error {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return fn()
+	}
+	var user string
+	if u := requestUser(r); u != nil {
+		user = u.Name
+	}
+	key = user + "\n" + r.URL.Path + "\n" + key
+	data, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	body := sha256.Sum256(data)
+
+	c := &s.idempotency
+	c.Lock()
+	if c.m == nil {
+		c.m = make(map[string]*idempotentResult)
+	}
+	now := time.Now()
+	for k, res := range c.m {
+		if !res.at.IsZero() && now.Sub(res.at) > idempotencyTTL {
+			delete(c.m, k)
+		}
+	}
+	if res, ok := c.m[key]; ok {
+		c.Unlock()
+		if res.body != body {
+			return errIdempotencyMismatch
+		}
+		<-res.done
+		log.Debug("[Idempotency] replayed", r.URL.Path)
+		return res.err
+	}
+	res := &idempotentResult{body: body, done: make(chan struct{})}
+	c.m[key] = res
+	c.Unlock()
+
+	res.err = fn()
+	c.Lock()
+	if res.err != nil {
+		delete(c.m, key)
+	} else {
+		res.at = time.Now()
+	}
+	c.Unlock()
+	close(res.done)
+	return res.err
+}
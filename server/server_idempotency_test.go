@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+func TestIdempotent(t *testing.T) {
+	s := &Server{}
+	var runs int
+	fn := func(r *http.Request) func() error {
+		return func() error {
+			// the body is still there for the handler
+			if b, _ := ioutil.ReadAll(r.Body); len(b) == 0 {
+				t.Error("body consumed")
+			}
+			runs++
+			return nil
+		}
+	}
+	req := func(user, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/magnet", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", "k1")
+		if user != "" {
+			u := engine.User{Name: user, Role: engine.RoleUser}
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, u))
+		}
+		return r
+	}
+
+	for i := 0; i < 2; i++ {
+		r := req("alice", "magnet:a")
+		if err := s.idempotent(r, fn(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("ran %d times, want the retry replayed", runs)
+	}
+	r := req("alice", "magnet:b")
+	if err := s.idempotent(r, fn(r)); !errors.Is(err, errIdempotencyMismatch) || apiErrorStatus(err) != http.StatusUnprocessableEntity {
+		t.Errorf("other body: %v", err)
+	}
+	r = req("bob", "magnet:a")
+	if err := s.idempotent(r, fn(r)); err != nil || runs != 2 {
+		t.Errorf("key of another user: %v, ran %d times", err, runs)
+	}
+}
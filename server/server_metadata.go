@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const metadataFetchTimeout = 2 * time.Minute
+
+// apiMetadata resolves the magnet m and responds its .torrent, or the
+// inspection of it with format=json, no task is added
+func (s *Server) apiMetadata(w http.ResponseWriter, r *http.Request) error {
+	m := strings.TrimSpace(r.URL.Query().Get("m"))
+	if m == "" {
+		return errInvalidReq
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), metadataFetchTimeout)
+	defer cancel()
+	mi, err := s.engine.FetchMetadata(ctx, m)
+	if err != nil {
+		return fmt.Errorf("ERROR: Failed to fetch the metadata: %w", err)
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		ins, err := engine.Inspect(mi)
+		if err != nil {
+			return err
+		}
+		common.HandleError(json.NewEncoder(w).Encode(ins))
+		return nil
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name+".torrent"))
+	common.HandleError(mi.Write(w))
+	return nil
+}
+
+// apiInspect responds the content of the .torrent or magnet POSTed to
+// /api/inspect without adding it, for the files to be chosen before adding
+func (s *Server) apiInspect(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxTorrentSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Failed to read the request: %v", err), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), metadataFetchTimeout)
+	defer cancel()
+	ins, err := s.engine.InspectTorrent(ctx, data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Failed to inspect the torrent: %v", err), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	common.HandleError(json.NewEncoder(w).Encode(ins))
+}
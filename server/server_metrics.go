@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const metricsPrefix = "simpletorrent_"
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes the prometheus text exposition format
+type metricsWriter struct {
+	*bufio.Writer
+}
+
+func (m metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(m, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+func (m metricsWriter) value(name string, v interface{}, labels ...string) {
+	m.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		m.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.WriteByte(',')
+			}
+			fmt.Fprintf(m, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		m.WriteByte('}')
+	}
+	fmt.Fprintf(m, " %v\n", v)
+}
+
+func (m metricsWriter) metric(name, typ, help string, v interface{}) {
+	m.header(name, typ, help)
+	m.value(name, v)
+}
+
+type torrentMetric struct {
+	ih, name                           string
+	downloaded, uploaded               int64
+	downloadRate, uploadRate, progress float32
+	seedRatio                          float32
+	activePeers, totalPeers            int
+}
+
+// engineTotals are the stats of all the torrents, shared by prometheus and SNMP
+type engineTotals struct {
+	active, queueing int
+	dlRate, ulRate   float32
+}
+
+// collectMetrics reads the stats of the torrents
+func (s *Server) collectMetrics() ([]torrentMetric, engineTotals) {
+	var tms []torrentMetric
+	var tot engineTotals
+	for ih, t := range s.engine.Torrents() {
+		t.Lock()
+		tm := torrentMetric{
+			ih:           ih,
+			name:         t.Name,
+			downloaded:   t.Downloaded,
+			uploaded:     t.Uploaded,
+			downloadRate: t.DownloadRate,
+			uploadRate:   t.UploadRate,
+			progress:     t.Percent / 100,
+			seedRatio:    t.SeedRatio,
+		}
+		if t.Stats != nil {
+			tm.activePeers = t.Stats.ActivePeers
+			tm.totalPeers = t.Stats.TotalPeers
+		}
+		if t.Started {
+			tot.active++
+		}
+		if t.IsQueueing {
+			tot.queueing++
+		}
+		t.Unlock()
+		tot.dlRate += tm.downloadRate
+		tot.ulRate += tm.uploadRate
+		tms = append(tms, tm)
+	}
+	return tms, tot
+}
+
+// serveMetrics exposes the engine and torrents stats for prometheus
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	tms, tot := s.collectMetrics()
+	cs := s.engine.ConnStat()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m := metricsWriter{bufio.NewWriter(w)}
+	defer func() {
+		common.HandleError(m.Flush())
+	}()
+
+	m.metric("downloaded_bytes_total", "counter", "Useful data downloaded by the engine.", cs.BytesReadUsefulData.Int64())
+	m.metric("uploaded_bytes_total", "counter", "Data uploaded by the engine.", cs.BytesWrittenData.Int64())
+	m.metric("download_rate_bytes", "gauge", "Download speed of all torrents in bytes/s.", tot.dlRate)
+	m.metric("upload_rate_bytes", "gauge", "Upload speed of all torrents in bytes/s.", tot.ulRate)
+	m.metric("torrents", "gauge", "Torrents in the engine.", len(tms))
+	m.metric("torrents_active", "gauge", "Started torrents.", tot.active)
+	m.metric("torrents_queueing", "gauge", "Torrents shown as queueing.", tot.queueing)
+	m.metric("waitlist_tasks", "gauge", "Tasks in the wait list.", s.engine.WaitListLen())
+	bl := s.engine.BlocklistStats()
+	m.metric("blocklist_ranges", "gauge", "IP ranges in the blocklist.", bl.Ranges)
+	m.metric("blocklist_blocked_total", "counter", "Peer addresses refused by the blocklist.", bl.Blocked)
+	ms := s.engine.MemoryStats()
+	m.metric("memory_in_use_bytes", "gauge", "Memory taken from the OS by the process.", ms.InUse)
+	m.metric("memory_sheds_total", "counter", "Times the caches were shed over MemoryLimit.", ms.Sheds)
+	m.metric("piece_cache_bytes", "gauge", "Data in the piece read cache.", ms.PieceCache)
+	m.metric("piece_cache_hits_total", "counter", "Reads served by the piece read cache.", ms.CacheHits)
+	m.metric("donecmd_failures_total", "counter", "DoneCmd calls failed to start or exited non-zero.", s.engine.DoneCmdFailures())
+	if stat, err := disk.Usage(s.engineConfig.DownloadDirectory); err == nil {
+		m.metric("disk_free_bytes", "gauge", "Free space of the download directory.", stat.Free)
+	}
+
+	perTorrent := []struct {
+		name, typ, help string
+		value           func(*torrentMetric) interface{}
+	}{
+		{"torrent_downloaded_bytes", "gauge", "Completed bytes of the torrent.", func(t *torrentMetric) interface{} { return t.downloaded }},
+		{"torrent_uploaded_bytes", "gauge", "Uploaded bytes of the torrent.", func(t *torrentMetric) interface{} { return t.uploaded }},
+		{"torrent_download_rate_bytes", "gauge", "Download speed of the torrent in bytes/s.", func(t *torrentMetric) interface{} { return t.downloadRate }},
+		{"torrent_upload_rate_bytes", "gauge", "Upload speed of the torrent in bytes/s.", func(t *torrentMetric) interface{} { return t.uploadRate }},
+		{"torrent_progress_ratio", "gauge", "Completion of the torrent, 0 to 1.", func(t *torrentMetric) interface{} { return t.progress }},
+		{"torrent_seed_ratio", "gauge", "Upload/download ratio of the torrent.", func(t *torrentMetric) interface{} { return t.seedRatio }},
+		{"torrent_peers_active", "gauge", "Active peers of the torrent.", func(t *torrentMetric) interface{} { return t.activePeers }},
+		{"torrent_peers_total", "gauge", "Known peers of the torrent.", func(t *torrentMetric) interface{} { return t.totalPeers }},
+	}
+	if len(tms) == 0 {
+		return
+	}
+	for _, pt := range perTorrent {
+		m.header(pt.name, pt.typ, pt.help)
+		for i := range tms {
+			m.value(pt.name, pt.value(&tms[i]), "infohash", tms[i].ih, "name", tms[i].name)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/oidc"
+)
+
+const (
+	oidcCookie     = "st_oidc"
+	oidcFlowCookie = "st_oidc_flow"
+	oidcSessionTTL = 24 * time.Hour
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+var errOIDCDenied = errors.New("not allowed to log in")
+
+// oidcLogin is the provider, the key of the signed cookies and the sessions
+// of the logins
+type oidcLogin struct {
+	p   *oidc.Provider
+	key []byte
+
+	mu sync.Mutex
+	// the claims of the logins by the session ID of the cookie, kept here so
+	// a logout revokes them and the user is checked again on each request
+	sessions map[string]*oidcLoginSession
+}
+
+type oidcLoginSession struct {
+	claims *oidc.Claims
+	expiry time.Time
+}
+
+// oidcSession is the signed content of the cookies
+type oidcSession struct {
+	ID string `json:",omitempty"`
+	// the login flow
+	State, Nonce, Verifier string `json:",omitempty"`
+	Expiry                 int64
+}
+
+// setupOIDC discovers the provider, the basic auth and the users stay the
+// fallback of the scripts and of /?basic
+func (s *Server) setupOIDC() error {
+	if s.OIDCClientID == "" || s.OIDCRedirectURL == "" {
+		return errors.New("--oidc-issuer needs --oidc-client-id and --oidc-redirect-url")
+	}
+	if s.OIDCAllowEmails == "" && s.OIDCAllowGroups == "" {
+		return errors.New("--oidc-issuer needs --oidc-allow-emails or --oidc-allow-groups, else anyone of the provider logs in")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	redirect := strings.TrimSuffix(s.OIDCRedirectURL, "/") + "/auth/callback"
+	p, err := oidc.Discover(ctx, s.OIDCIssuer, s.OIDCClientID, s.OIDCClientSecret, redirect)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	s.oidc = &oidcLogin{p: p, key: key, sessions: make(map[string]*oidcLoginSession)}
+	log.Printf("[oidc] login by %s, callback %s", s.OIDCIssuer, redirect)
+	return nil
+}
+
+// oidcWrap serves /auth/ and the requests of the logged in sessions, the
+// others go to the login when opening the web UI, or to the fallback
+func (s *Server) oidcWrap(w http.ResponseWriter, r *http.Request, h http.Handler) bool {
+	if strings.HasPrefix(r.URL.Path, "/auth/") {
+		s.serveOIDC(w, r)
+		return true
+	}
+	var sess oidcSession
+	if s.oidc.readCookie(r, oidcCookie, &sess) && sess.ID != "" {
+		if c := s.oidc.session(sess.ID); c != nil {
+			// the account, its role or the allowed ones may have changed
+			// since the login
+			u, err := s.oidcUser(c)
+			if err != nil {
+				s.oidc.revoke(sess.ID)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return true
+			}
+			s.serveAs(w, r, h, u)
+			return true
+		}
+	}
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	if r.Method == "GET" && r.URL.Path == "/" && !r.URL.Query().Has("basic") {
+		http.Redirect(w, r, s.BasePath+"/auth/login", http.StatusFound)
+		return true
+	}
+	if s.Auth == "" && s.users.Len() == 0 {
+		// no fallback, all behind the login
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return true
+	}
+	return false
+}
+
+func (s *Server) serveOIDC(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/auth/login":
+		flow := oidcSession{
+			State:    oidc.RandomString(),
+			Nonce:    oidc.RandomString(),
+			Verifier: oidc.RandomString(),
+			Expiry:   time.Now().Add(oidcFlowTTL).Unix(),
+		}
+		s.oidc.setCookie(w, r, oidcFlowCookie, s.BasePath+"/auth/", flow, oidcFlowTTL)
+		http.Redirect(w, r, s.oidc.p.AuthCodeURL(flow.State, flow.Nonce, flow.Verifier), http.StatusFound)
+	case "/auth/callback":
+		var flow oidcSession
+		q := r.URL.Query()
+		if !s.oidc.readCookie(r, oidcFlowCookie, &flow) || flow.State == "" || q.Get("state") != flow.State {
+			http.Error(w, "invalid login state, try again", http.StatusBadRequest)
+			return
+		}
+		s.oidc.setCookie(w, r, oidcFlowCookie, s.BasePath+"/auth/", nil, -1)
+		if e := q.Get("error"); e != "" {
+			http.Error(w, e+": "+q.Get("error_description"), http.StatusUnauthorized)
+			return
+		}
+		c, err := s.oidc.p.Exchange(r.Context(), q.Get("code"), flow.Verifier, flow.Nonce)
+		var u engine.User
+		if err == nil {
+			u, err = s.oidcUser(c)
+		}
+		if err != nil {
+			log.Warn("[oidc] login failed:", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		log.Printf("[oidc] %s logged in as %s", u.Name, u.Role)
+		expiry := time.Now().Add(oidcSessionTTL)
+		s.oidc.setCookie(w, r, oidcCookie, s.BasePath+"/", oidcSession{
+			ID:     s.oidc.login(c, expiry),
+			Expiry: expiry.Unix(),
+		}, oidcSessionTTL)
+		http.Redirect(w, r, s.BasePath+"/", http.StatusFound)
+	case "/auth/logout":
+		var sess oidcSession
+		if s.oidc.readCookie(r, oidcCookie, &sess) {
+			s.oidc.revoke(sess.ID)
+		}
+		s.oidc.setCookie(w, r, oidcCookie, s.BasePath+"/", nil, -1)
+		http.Redirect(w, r, s.BasePath+"/?basic", http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// oidcUser checks the claims against the allowed emails and groups, the role
+// is of the account of the same name, admin by the admin groups, or an admin
+// as the single account without the users
+func (s *Server) oidcUser(c *oidc.Claims) (engine.User, error) {
+	email := strings.ToLower(c.Email)
+	if c.EmailVerified != nil && !*c.EmailVerified {
+		email = ""
+	}
+	allowed := email != "" && inList(s.OIDCAllowEmails, email)
+	for _, g := range c.Groups {
+		allowed = allowed || inList(s.OIDCAllowGroups, g)
+	}
+	if !allowed {
+		return engine.User{}, errOIDCDenied
+	}
+	u := engine.User{Name: email, Role: engine.RoleUser}
+	if u.Name == "" {
+		u.Name = c.PreferredUsername
+	}
+	if u.Name == "" {
+		u.Name = c.Subject
+	}
+	if acc, ok := s.users.Get(u.Name); ok {
+		return acc, nil
+	}
+	for _, g := range c.Groups {
+		if inList(s.OIDCAdminGroups, g) {
+			u.Role = engine.RoleAdmin
+		}
+	}
+	if s.users.Len() == 0 {
+		u.Role = engine.RoleAdmin
+	}
+	return u, nil
+}
+
+// inList tells whether v is in the list separated by commas, ignoring case
+func inList(list, v string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" && strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// login keeps the claims of a new session until expiry, returns its ID
+func (o *oidcLogin) login(c *oidc.Claims, expiry time.Time) string {
+	id := oidc.RandomString()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	for k, sess := range o.sessions {
+		if now.After(sess.expiry) {
+			delete(o.sessions, k)
+		}
+	}
+	o.sessions[id] = &oidcLoginSession{claims: c, expiry: expiry}
+	return id
+}
+
+// session returns the claims of the session, nil if logged out or expired
+func (o *oidcLogin) session(id string) *oidc.Claims {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	sess, ok := o.sessions[id]
+	if !ok || time.Now().After(sess.expiry) {
+		return nil
+	}
+	return sess.claims
+}
+
+func (o *oidcLogin) revoke(id string) {
+	o.mu.Lock()
+	delete(o.sessions, id)
+	o.mu.Unlock()
+}
+
+// setCookie signs v into the cookie, nil removes it
+func (o *oidcLogin) setCookie(w http.ResponseWriter, r *http.Request, name, path string, v interface{}, ttl time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(o.p.RedirectURL, "https:"),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ttl / time.Second),
+	}
+	if v == nil {
+		c.MaxAge = -1
+	} else {
+		data, _ := json.Marshal(v)
+		payload := base64.RawURLEncoding.EncodeToString(data)
+		c.Value = payload + "." + o.sign(name, payload)
+	}
+	http.SetCookie(w, c)
+}
+
+// readCookie verifies the cookie and reads it into v, false when missing,
+// forged or expired
+func (o *oidcLogin) readCookie(r *http.Request, name string, v *oidcSession) bool {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	parts := strings.SplitN(c.Value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(o.sign(name, parts[0]))) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, v) != nil {
+		return false
+	}
+	return time.Now().Unix() < v.Expiry
+}
+
+func (o *oidcLogin) sign(name, payload string) string {
+	m := hmac.New(sha256.New, o.key)
+	m.Write([]byte(name + "=" + payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boypt/simple-torrent/server/oidc"
+)
+
+func TestOIDCSession(t *testing.T) {
+	s := &Server{}
+	s.OIDCAllowEmails = "alice@example.com"
+	s.oidc = &oidcLogin{
+		p:        &oidc.Provider{RedirectURL: "https://torrent.example.com"},
+		key:      []byte("0123456789abcdef0123456789abcdef"),
+		sessions: make(map[string]*oidcLoginSession),
+	}
+	// the cookie of the session logged in
+	expiry := time.Now().Add(time.Hour)
+	w := httptest.NewRecorder()
+	s.oidc.setCookie(w, httptest.NewRequest("GET", "/", nil), oidcCookie, "/", oidcSession{
+		ID:     s.oidc.login(&oidc.Claims{Email: "alice@example.com"}, expiry),
+		Expiry: expiry.Unix(),
+	}, time.Hour)
+	cookie := w.Result().Cookies()[0]
+
+	var served string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := requestUser(r); u != nil {
+			served = u.Name
+		}
+	})
+	serve := func(path string) *httptest.ResponseRecorder {
+		served = ""
+		r := httptest.NewRequest("GET", path, nil)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		if !s.oidcWrap(w, r, h) {
+			w.Code = 0
+		}
+		return w
+	}
+
+	if serve("/api/torrents"); served != "alice@example.com" {
+		t.Fatalf("served as %q", served)
+	}
+	// no longer allowed since the login
+	s.OIDCAllowEmails = "bob@example.com"
+	if w := serve("/api/torrents"); served != "" || w.Code != http.StatusUnauthorized {
+		t.Errorf("served as %q after the allowed emails changed: %d", served, w.Code)
+	}
+	if len(s.oidc.sessions) != 0 {
+		t.Error("session of the denied user kept")
+	}
+
+	// the logout revokes the session, not only its cookie
+	s.OIDCAllowEmails = "alice@example.com"
+	w = httptest.NewRecorder()
+	s.oidc.setCookie(w, httptest.NewRequest("GET", "/", nil), oidcCookie, "/", oidcSession{
+		ID:     s.oidc.login(&oidc.Claims{Email: "alice@example.com"}, expiry),
+		Expiry: expiry.Unix(),
+	}, time.Hour)
+	cookie = w.Result().Cookies()[0]
+	serve("/auth/logout")
+	if serve("/api/torrents"); served != "" {
+		t.Errorf("served as %q after the logout", served)
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+// the streaming gateway of the players add-ons under /play:
+//
+//	GET|POST /play/add?magnet=...    adds and starts a magnet, or the .torrent
+//	                                 or magnet posted, returns the URLs below
+//	GET      /play/<infohash>/status the progress of the task and its stream
+//	GET|HEAD /play/<infohash>/stream[/<index>]
+//	                                 the largest file, or the file at index,
+//	                                 with Range, 503 until the info is loaded
+
+// playRetry is the Retry-After of the streams not ready
+const playRetry = "2"
+
+type playAdded struct {
+	InfoHash string
+	Status   string
+	Stream   string
+}
+
+type playStatus struct {
+	InfoHash     string
+	Name         string
+	Loaded       bool
+	Started      bool
+	Done         bool
+	Percent      float32
+	DownloadRate float32
+	Peers        int
+	// the file streamed by /play/<infohash>/stream
+	File   *playFile `json:",omitempty"`
+	Stream string
+}
+
+type playFile struct {
+	Index     int
+	Path      string
+	Size      int64
+	Completed int64
+	Percent   float32
+}
+
+func (s *Server) servePlay(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "add" {
+		s.playAdd(w, r)
+		return
+	}
+	if len(parts) < 2 || len(parts[0]) != 40 {
+		http.Error(w, errUnknowPath.Error(), http.StatusNotFound)
+		return
+	}
+	ih := strings.ToLower(parts[0])
+	switch {
+	case len(parts) == 2 && parts[1] == "status":
+		s.playStatus(w, ih)
+	case len(parts) <= 3 && parts[1] == "stream":
+		index := -1
+		if len(parts) == 3 {
+			i, err := strconv.Atoi(parts[2])
+			if err != nil || i < 0 {
+				http.Error(w, errUnknowPath.Error(), http.StatusNotFound)
+				return
+			}
+			index = i
+		}
+		s.playStream(w, r, ih, index)
+	default:
+		http.Error(w, errUnknowPath.Error(), http.StatusNotFound)
+	}
+}
+
+// playAdd adds the task and starts it, a queued one is started at once
+func (s *Server) playAdd(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	switch r.Method {
+	case "GET":
+		data = []byte(r.URL.Query().Get("magnet"))
+	case "POST":
+		var err error
+		if data, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ih, err := engine.InfoHashOf(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := s.engine.Torrent(ih); !ok {
+		if err := s.presetOwner(r, data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "magnet:") {
+			err = s.engine.NewMagnet(strings.TrimSpace(string(data)), "")
+		} else {
+			err = s.engine.NewTorrentByReader(bytes.NewReader(data), "")
+		}
+		if errors.Is(err, engine.ErrMaxConnTasks) {
+			err = s.engine.ForceStartWaitTask(ih)
+		}
+		if err != nil && !errors.Is(err, engine.ErrTaskExists) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if t, ok := s.engine.Torrent(ih); ok {
+		t.Lock()
+		started := t.Started
+		t.Unlock()
+		if !started {
+			// the stopped task of another user stays so
+			if err := s.checkOwner(r, ih); err != nil {
+				http.Error(w, err.Error(), apiErrorStatus(err))
+				return
+			}
+			if err := s.engine.ManualStartTorrent(ih); err != nil {
+				log.Warn("[play]", ih, err)
+			}
+		}
+	}
+	log.Println("[play] added", ih)
+
+	w.Header().Set("Content-Type", "application/json")
+	common.HandleError(json.NewEncoder(w).Encode(playAdded{
+		InfoHash: ih,
+		Status:   fmt.Sprintf("%s/play/%s/status", s.BasePath, ih),
+		Stream:   fmt.Sprintf("%s/play/%s/stream", s.BasePath, ih),
+	}))
+}
+
+func (s *Server) playStatus(w http.ResponseWriter, ih string) {
+	t, ok := s.engine.Torrent(ih)
+	if !ok {
+		http.Error(w, errUnknowPath.Error(), http.StatusNotFound)
+		return
+	}
+	t.Lock()
+	st := playStatus{
+		InfoHash:     t.InfoHash,
+		Name:         t.Name,
+		Loaded:       t.Loaded,
+		Started:      t.Started,
+		Done:         t.Done,
+		Percent:      t.Percent,
+		DownloadRate: t.DownloadRate,
+		Stream:       fmt.Sprintf("%s/play/%s/stream", s.BasePath, ih),
+	}
+	if t.Stats != nil {
+		st.Peers = t.Stats.ActivePeers
+	}
+	if i := largestFile(t.Files); i >= 0 {
+		f := t.Files[i]
+		st.File = &playFile{Index: i, Path: f.Path, Size: f.Size, Completed: f.Completed, Percent: f.Percent}
+	}
+	t.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	common.HandleError(json.NewEncoder(w).Encode(st))
+}
+
+func (s *Server) playStream(w http.ResponseWriter, r *http.Request, ih string, index int) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t, ok := s.engine.Torrent(ih)
+	if !ok {
+		http.Error(w, errUnknowPath.Error(), http.StatusNotFound)
+		return
+	}
+	t.Lock()
+	loaded := t.Loaded
+	if index < 0 {
+		index = largestFile(t.Files)
+	}
+	var fp string
+	if index >= 0 && index < len(t.Files) && t.Files[index] != nil {
+		fp = t.Files[index].Path
+	}
+	t.Unlock()
+	if !loaded {
+		w.Header().Set("Retry-After", playRetry)
+		http.Error(w, "torrent info not loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+	if fp == "" {
+		http.Error(w, errUnknowPath.Error(), http.StatusNotFound)
+		return
+	}
+
+	reader, f, err := s.engine.NewFileReader(r.Context(), ih, fp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer func() {
+		common.HandleError(reader.Close())
+	}()
+
+	// avoid gzip buffering the ranged content
+	w.Header().Set("Content-Encoding", "identity")
+	http.ServeContent(w, r, path.Base(f.Path), time.Time{}, reader)
+}
+
+// largestFile returns the index of the largest file, -1 when none
+func largestFile(files []*engine.File) int {
+	index := -1
+	var size int64 = -1
+	for i, f := range files {
+		if f != nil && f.Size > size {
+			index, size = i, f.Size
+		}
+	}
+	return index
+}
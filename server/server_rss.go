@@ -1,25 +1,24 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"regexp"
+	"path"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/boypt/simple-torrent/common"
-	"github.com/dustin/go-humanize"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/engine/rss"
 	"github.com/mmcdole/gofeed"
 )
 
-var (
-	magnetExp   = regexp.MustCompile(`magnet:[^< ]+`)
-	hashinfoExp = regexp.MustCompile(`[0-9a-zA-Z]{40}`)
-	torrentExp  = regexp.MustCompile(`\.torrent`)
-)
-
 type rssJSONItem struct {
 	Name            string `json:"name"`
 	Magnet          string `json:"magnet"`
@@ -32,74 +31,12 @@ type rssJSONItem struct {
 }
 
 func (ritem *rssJSONItem) findFromFeedItem(i *gofeed.Item) (found bool) {
-
-	for _, ex := range []string{"torrent", "nyaa"} {
-		if ok := ritem.readExtention(i, ex); ok {
-			break
-		}
-	}
-
-	// some sites put it under enclosures
-	for _, e := range i.Enclosures {
-		if strings.HasPrefix(e.URL, "magnet:") {
-			ritem.Magnet = e.URL
-		} else if torrentExp.Match([]byte(e.URL)) {
-			ritem.Torrent = e.URL
-		}
-	}
-
-	// maybe the Link is a torrent file
-	if torrentExp.MatchString(i.Link) {
-		ritem.Torrent = i.Link
-	}
-
-	// not found magnet/torrent, try to find them in the description
-	if ritem.Magnet == "" && ritem.InfoHash == "" && ritem.Torrent == "" {
-
-		// try to find magnet in description
-		if s := magnetExp.FindString(i.Description); s != "" {
-			ritem.Magnet = s
-		}
-
-		// try to find hashinfo in description
-		if s := hashinfoExp.FindString(i.Description); s != "" {
-			ritem.InfoHash = s
-		}
-
-		//still not found?, well... whatever
-	}
-
-	return (ritem.Magnet != "" || ritem.InfoHash != "" || ritem.Torrent != "")
-}
-
-func (r *rssJSONItem) readExtention(i *gofeed.Item, ext string) (found bool) {
-
-	// There are no starndards for rss feeds contains torrents or magnets
-	// Heres some sites putting info in the extentions
-	if etor, ok := i.Extensions[ext]; ok {
-
-		if e, ok := etor["size"]; ok && len(e) > 0 {
-			r.Size = e[0].Value
-		}
-
-		if e, ok := etor["contentLength"]; ok && len(e) > 0 {
-			if size, err := strconv.ParseUint(e[0].Value, 10, 64); err == nil {
-				r.Size = humanize.Bytes(size)
-			}
-		}
-
-		if e, ok := etor["magnetURI"]; ok && len(e) > 0 {
-			r.Magnet = e[0].Value
-			found = true
-		}
-
-		if e, ok := etor["infoHash"]; ok && len(e) > 0 {
-			r.InfoHash = e[0].Value
-			found = true
-		}
-	}
-
-	return
+	l := rss.FindLinks(i)
+	ritem.Magnet = l.Magnet
+	ritem.InfoHash = l.InfoHash
+	ritem.Torrent = l.Torrent
+	ritem.Size = l.Size
+	return l.Found()
 }
 
 func (s *Server) updateRSS() {
@@ -114,7 +51,7 @@ func (s *Server) updateRSS() {
 		rss = strings.TrimSpace(rss)
 		feed, err := fp.ParseURL(rss)
 		if err != nil {
-			log.Printf("RSS: parse feed err %s", err.Error())
+			log.Warnf("RSS: parse feed err %s", err.Error())
 			continue
 		}
 
@@ -181,3 +118,48 @@ func (s *Server) serveRSS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	common.HandleError(json.NewEncoder(w).Encode(results))
 }
+
+// rssAdder adds the items of the rss auto downloader
+type rssAdder struct {
+	e *engine.Engine
+}
+
+func (a rssAdder) AddMagnet(magnet, dir string) error {
+	common.HandleError(a.e.PresetSource([]byte(magnet), engine.SourceRSS))
+	return rssAdded(a.e.NewMagnet(magnet, dir))
+}
+
+func (a rssAdder) AddTorrent(r io.Reader, dir string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	common.HandleError(a.e.PresetSource(data, engine.SourceRSS))
+	return rssAdded(a.e.NewTorrentByReader(bytes.NewReader(data), dir))
+}
+
+// rssAdded tells the downloader an item already added, so it goes to the
+// history and isn't tried on every poll
+func rssAdded(err error) error {
+	if errors.Is(err, engine.ErrTaskExists) {
+		return fmt.Errorf("%w: %v", rss.ErrExists, err)
+	}
+	return ignoreQueued(err)
+}
+
+// ignoreQueued treats the queued and the already added tasks as added,
+// so the retries of the clients succeed
+func ignoreQueued(err error) error {
+	if errors.Is(err, engine.ErrMaxConnTasks) || errors.Is(err, engine.ErrTaskExists) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) startRSSDownloader() {
+	history := path.Join(s.engineConfig.DownloadDirectory, engine.CachedTorrentDir, ".rsshistory.json")
+	d := rss.New(rssAdder{s.engine}, func() string {
+		return s.engineConfig.RssRulesFile
+	}, history)
+	go d.Run()
+}
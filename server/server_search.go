@@ -2,31 +2,83 @@ package server
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/search"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// search results are reused within the ttl, and removed after it
+	searchCacheTTL = 10 * time.Minute
+	// the keys of the search results in the HTTP cache
+	searchCachePrefix = "search:"
+	// the search providers of the plugins are named plugin:<name>
+	pluginProviderPrefix = "plugin:"
 )
 
+// searchLimiter limits the searches sent to the upstream sites
+var searchLimiter = rate.NewLimiter(rate.Every(time.Second), 5)
+
+// searchPruned is when the expired search results were last removed, the
+// limiter keeps the ones within the ttl few
+var searchPruned struct {
+	sync.Mutex
+	at time.Time
+}
+
 //go:embed default-scraper-config.json
 var defaultSearchConfig []byte
 var currentConfig []byte
 
+// searchHeaders are sent to the sites by the scraper providers
+func (s *Server) searchHeaders() map[string]string {
+	return map[string]string{
+		//we're a trusty browser :)
+		"User-Agent": scraperUA,
+	}
+}
+
+// loadSearchProviders loads the providers of ScraperURL and of the
+// descriptors in SearchProviderDir, and the disabled ones
+func (s *Server) loadSearchProviders() {
+	s.providers.SetDisabled(splitSearchIDs(s.engineConfig.SearchDisabled))
+	go s.fetchSearchConfig(s.engineConfig.ScraperURL) // nolint: errcheck
+
+	dir := s.engineConfig.SearchProviderDir
+	if dir == "" {
+		s.providers.Set(search.KindExternal, nil)
+		return
+	}
+	ps, errs := search.LoadDescriptors(dir, s.searchHeaders(), s.Debug)
+	for _, err := range errs {
+		log.Warn("[search] descriptor skipped:", err)
+	}
+	s.providers.Set(search.KindExternal, ps)
+	log.Printf("[search] loaded %d providers from %s", len(ps), dir)
+}
+
 func (s *Server) fetchSearchConfig(confurl string) error {
 	if !strings.HasPrefix(confurl, "http") {
 		log.Println("fetchSearchConfig: unconfigured, using the default conf", confurl)
+		s.providers.Set(search.KindScraper, nil)
+		currentConfig = nil
 		return nil
 	}
 	log.Println("fetchSearchConfig: loading search config from", confurl)
-	resp, err := http.Get(confurl)
-	if err != nil {
-		log.Println("[fetchSearchConfig]", err)
-		return err
-	}
-	defer resp.Body.Close()
-	newConfig, err := ioutil.ReadAll(resp.Body)
+	newConfig, err := s.engine.HTTPCache().Get(confurl)
 	if err != nil {
+		log.Warn("[fetchSearchConfig]", err)
 		return err
 	}
 	newConfig, err = normalize(newConfig)
@@ -36,15 +88,198 @@ func (s *Server) fetchSearchConfig(confurl string) error {
 	if bytes.Equal(currentConfig, newConfig) {
 		return nil //skip
 	}
-	if err := s.scraper.LoadConfig(newConfig); err != nil {
+	ps, err := search.ScraperProviders(search.KindScraper, confurl, newConfig, s.searchHeaders(), s.Debug)
+	if err != nil {
 		return err
 	}
-	s.searchProviders = &s.scraper.Config
+	s.providers.Set(search.KindScraper, ps)
 	currentConfig = newConfig
 	log.Printf("Loaded new search providers")
 	return nil
 }
 
+// splitSearchIDs splits the IDs of SearchDisabled by lines or commas
+func splitSearchIDs(s string) []string {
+	var ids []string
+	for _, line := range common.SplitLines(s) {
+		for _, id := range strings.Split(line, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// pluginProvider is a search plugin of the engine
+type pluginProvider struct {
+	name string
+	e    *engine.Engine
+}
+
+func (p *pluginProvider) Info() search.Info {
+	return search.Info{
+		ID:   pluginProviderPrefix + p.name,
+		Name: p.name + " (plugin)",
+		Kind: search.KindPlugin,
+	}
+}
+
+func (p *pluginProvider) Search(ctx context.Context, query string, page int) ([]search.Result, error) {
+	results, err := p.e.PluginSearch(ctx, p.name, query, page)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]search.Result, 0, len(results))
+	for _, r := range results {
+		rs = append(rs, search.Result{
+			"name": r.Name, "url": r.URL, "magnet": r.Magnet, "infohash": r.InfoHash,
+			"torrent": r.Torrent, "size": r.Size, "seeds": r.Seeds, "peers": r.Peers,
+		})
+	}
+	return rs, nil
+}
+
+func (p *pluginProvider) Item(ctx context.Context, path string) (search.Result, error) {
+	return nil, search.ErrNoItem
+}
+
+// searchProviders returns the registry with the search plugins running now
+func (s *Server) searchProviders() *search.Registry {
+	var ps []search.Provider
+	for _, name := range s.engine.SearchPlugins() {
+		ps = append(ps, &pluginProvider{name: name, e: s.engine})
+	}
+	s.providers.Set(search.KindPlugin, ps)
+	return &s.providers
+}
+
+// serveSearch serves the searches /<id>?query=&page= of the providers, and
+// the lookups /<id>/item?item= of the pages of their results
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	item := strings.HasSuffix(id, "/item")
+	id = strings.TrimSuffix(id, "/item")
+	p, err := s.searchProviders().Get(id)
+	switch {
+	case errors.Is(err, search.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	var v interface{}
+	if item {
+		v, err = p.Item(r.Context(), q.Get("item"))
+	} else {
+		page, perr := strconv.Atoi(q.Get("page"))
+		if perr != nil || page < 1 {
+			page = 1
+		}
+		v, err = p.Search(r.Context(), q.Get("query"), page)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	common.HandleError(enc.Encode(v))
+}
+
+// enableSearchProvider enables or disables the provider, saved in
+// SearchDisabled
+func (s *Server) enableSearchProvider(ctx context.Context, id string, on bool) error {
+	if _, ok := s.searchProviders().Lookup(id); !ok {
+		return search.ErrNotFound
+	}
+	var ids []string
+	for _, d := range splitSearchIDs(s.engineConfig.SearchDisabled) {
+		if d != id {
+			ids = append(ids, d)
+		}
+	}
+	if !on {
+		ids = append(ids, id)
+	}
+	c := *s.engineConfig
+	c.SearchDisabled = strings.Join(ids, "\n")
+	log.Printf("[search] %s enabled %v", id, on)
+	return s.applyConfig(ctx, c, true)
+}
+
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (b *bodyRecorder) WriteHeader(code int) {
+	b.status = code
+	b.ResponseWriter.WriteHeader(code)
+}
+
+func (b *bodyRecorder) Write(p []byte) (int, error) {
+	b.buf.Write(p)
+	return b.ResponseWriter.Write(p)
+}
+
+// cachedSearch caches the search results on disk and limits the rate of the
+// requests actually sent, so frequent searches don't hammer the sites
+func (s *Server) cachedSearch(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := s.engine.HTTPCache()
+		if r.Method != "GET" || c == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := searchCachePrefix + r.URL.RequestURI()
+		if body, meta, err := c.Load(key); err == nil && time.Since(meta.FetchedAt) < searchCacheTTL {
+			w.Header().Set("Content-Type", meta.ContentType)
+			_, err := w.Write(body)
+			common.HandleError(err)
+			return
+		}
+
+		if err := searchLimiter.Wait(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		if rec.status == http.StatusOK {
+			common.HandleError(c.Store(key, rec.buf.Bytes(), &common.HTTPCacheMeta{
+				URL:         key,
+				ContentType: w.Header().Get("Content-Type"),
+				FetchedAt:   time.Now(),
+			}))
+			pruneSearchCache(c)
+		}
+	})
+}
+
+// pruneSearchCache removes the expired search results once a ttl
+func pruneSearchCache(c *common.HTTPCache) {
+	searchPruned.Lock()
+	due := time.Since(searchPruned.at) >= searchCacheTTL
+	if due {
+		searchPruned.at = time.Now()
+	}
+	searchPruned.Unlock()
+	if due {
+		go func() {
+			if n := c.Prune(searchCachePrefix, searchCacheTTL); n > 0 {
+				log.Debugf("[search] %d expired results removed", n)
+			}
+		}()
+	}
+}
+
 func normalize(input []byte) ([]byte, error) {
 	output := bytes.Buffer{}
 	if err := json.Indent(&output, input, "", "  "); err != nil {
@@ -54,4 +289,4 @@ func normalize(input []byte) ([]byte, error) {
 }
 
 //see github.com/jpillora/scraper for config specification
-//cloud-torrent uses "<id>-item" handlers
+//cloud-torrent uses "<id>/item" handlers
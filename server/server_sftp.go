@@ -0,0 +1,64 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/boypt/simple-torrent/server/sftpserver"
+	"github.com/spf13/viper"
+)
+
+// sftpHostKey next to the config file is the host key of the SFTP server
+const sftpHostKey = "sftp_host_ed25519_key"
+
+// startSFTP serves the downloads read only by SFTP with the accounts of the
+// web UI, the admins see DownloadDirectory, the other users are chrooted to
+// the tasks they added
+func (s *Server) startSFTP() error {
+	key, err := sftpserver.LoadHostKey(filepath.Join(filepath.Dir(viper.ConfigFileUsed()), sftpHostKey))
+	if err != nil {
+		return err
+	}
+	ss := &sftpserver.Server{
+		HostKey: key,
+		Login:   s.checkLogin,
+		Chroot:  s.sftpChroot,
+		Hidden:  func(name string) bool { return strings.HasPrefix(name, ".") },
+	}
+	go func() {
+		if err := ss.ListenAndServe(s.SFTPListen); err != nil {
+			log.Warn("[SFTP] err", err)
+		}
+	}()
+	return nil
+}
+
+// sftpChroot mounts the data of the tasks added by the user at the root
+func (s *Server) sftpChroot(name string) sftpserver.Chroot {
+	if s.users.Len() == 0 {
+		// the single --auth account
+		return sftpserver.Chroot{Dir: s.engineConfig.DownloadDirectory}
+	}
+	mounts := make(map[string]string)
+	u, ok := s.users.Get(name)
+	if !ok {
+		// deleted while connected
+		return sftpserver.Chroot{Mounts: mounts}
+	}
+	if u.Role == engine.RoleAdmin {
+		return sftpserver.Chroot{Dir: s.engineConfig.DownloadDirectory}
+	}
+	for ih, t := range s.engine.Torrents() {
+		t.Lock()
+		owner, tname := t.AddedBy, t.Name
+		t.Unlock()
+		if owner != name {
+			continue
+		}
+		if p := s.engine.TorrentDataPath(ih); p != "" {
+			mounts[tname] = p
+		}
+	}
+	return sftpserver.Chroot{Mounts: mounts}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+// the requests in flight are given this long, the long polls of the UI
+// never end by themselves
+const httpShutdownTimeout = 2 * time.Second
+
+// shutdown stops the server within ShutdownTimeout, on a signal or for a
+// restart, the engine saves the state of the tasks and closes the client.
+// A signal of sigc exits right away.
+func (s *Server) shutdown(server *http.Server, reason string, sigc <-chan os.Signal) error {
+	timeout := s.engine.Config().ShutdownTimeout
+	log.Printf("[shutdown] %s, stopping within %s", reason, timeout)
+	go func() {
+		<-sigc
+		log.Warn("[shutdown] exiting right away")
+		os.Exit(1)
+	}()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	httpCtx, cancel := context.WithTimeout(ctx, httpShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(httpCtx); err != nil {
+		server.Close() // nolint: errcheck
+	}
+
+	if err := s.engine.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	log.Println("[shutdown] done")
+	return nil
+}
+
+// stopEngine stops the engine on the other exits of Run, the errors after
+// the engine is configured, so that they aren't taken for a crash on the next
+// start. It does nothing after shutdown.
+func (s *Server) stopEngine() {
+	ctx := context.Background()
+	if timeout := s.engine.Config().ShutdownTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := s.engine.Shutdown(ctx); err != nil && !errors.Is(err, engine.ErrShuttingDown) {
+		log.Warn("[shutdown]", err)
+	}
+}
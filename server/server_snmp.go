@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/boypt/simple-torrent/server/snmp"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// snmpBase is the placeholder arc of the NET-SNMP MIB for the unregistered
+// agents, the values are at <base>.1.<n>.0
+var snmpBase = snmp.MustParseOID("1.3.6.1.4.1.8072.9999.9999.1")
+
+var (
+	snmpSysDescr  = snmp.MustParseOID("1.3.6.1.2.1.1.1.0")
+	snmpSysUpTime = snmp.MustParseOID("1.3.6.1.2.1.1.3.0")
+)
+
+// startSNMP serves the core counters read only by SNMP v1/v2c
+func (s *Server) startSNMP() error {
+	if s.SNMPCommunity == "" {
+		return errors.New("--snmp-listen needs --snmp-community")
+	}
+	a := &snmp.Agent{Community: s.SNMPCommunity, MIB: s.snmpMIB}
+	go func() {
+		if err := a.ListenAndServe(s.SNMPListen); err != nil {
+			log.Warn("[SNMP] err", err)
+		}
+	}()
+	return nil
+}
+
+// snmpMIB are the values of the prometheus metrics, the rates in bytes/s and
+// the disk free in MiB as Gauge32, the totals as Counter64
+func (s *Server) snmpMIB() []snmp.Var {
+	tms, tot := s.collectMetrics()
+	cs := s.engine.ConnStat()
+	bl := s.engine.BlocklistStats()
+	mib := []snmp.Var{
+		{OID: snmpSysDescr, Value: "SimpleTorrent " + s.tpl.Version},
+		{OID: snmpSysUpTime, Value: snmp.TimeTicks((time.Now().Unix() - s.tpl.Uptime) * 100)},
+	}
+	values := []interface{}{
+		1:  gauge32(len(tms)),
+		2:  gauge32(tot.active),
+		3:  gauge32(tot.queueing),
+		4:  gauge32(s.engine.WaitListLen()),
+		5:  gauge32(tot.dlRate),
+		6:  gauge32(tot.ulRate),
+		7:  snmp.Counter64(cs.BytesReadUsefulData.Int64()),
+		8:  snmp.Counter64(cs.BytesWrittenData.Int64()),
+		9:  snmp.Counter64(bl.Blocked),
+		10: snmp.Counter64(s.engine.DoneCmdFailures()),
+	}
+	for n, v := range values {
+		if v != nil {
+			mib = append(mib, snmp.Var{OID: snmpBase.Append(uint32(n), 0), Value: v})
+		}
+	}
+	if stat, err := disk.Usage(s.engineConfig.DownloadDirectory); err == nil {
+		mib = append(mib, snmp.Var{OID: snmpBase.Append(11, 0), Value: gauge32(stat.Free >> 20)})
+	}
+	return mib
+}
+
+// gauge32 caps the number to the Gauge32
+func gauge32(n interface{}) snmp.Gauge32 {
+	var f float64
+	switch x := n.(type) {
+	case int:
+		f = float64(x)
+	case float32:
+		f = float64(x)
+	case uint64:
+		f = float64(x)
+	}
+	return snmp.Gauge32(math.Max(0, math.Min(f, math.MaxUint32)))
+}
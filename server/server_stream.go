@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// serveStream serves /stream/<infohash>/<filepath> with Range support,
+// reading from the torrent directly so that unfinished files can be played
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || len(parts[0]) != 40 || parts[1] == "" {
+		http.Error(w, errUnknowPath.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader, f, err := s.engine.NewFileReader(r.Context(), parts[0], parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer func() {
+		common.HandleError(reader.Close())
+	}()
+
+	// avoid gzip buffering the ranged content
+	w.Header().Set("Content-Encoding", "identity")
+	http.ServeContent(w, r, path.Base(f.Path), time.Time{}, reader)
+}
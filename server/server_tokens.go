@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/spf13/viper"
+)
+
+// tokensFile next to the config file keeps the API tokens
+const tokensFile = "tokens.json"
+
+type tokenKey struct{}
+
+// openTokens opens the API tokens next to the config file
+func (s *Server) openTokens() error {
+	p := filepath.Join(filepath.Dir(viper.ConfigFileUsed()), tokensFile)
+	tokens, err := engine.OpenTokenStore(p)
+	if err != nil {
+		return err
+	}
+	s.tokens = tokens
+	return nil
+}
+
+// bearerToken returns the token of the API request
+func bearerToken(r *http.Request) (string, bool) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return "", false
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// tokenAuth serves the API request as the owner of the token, the read
+// scope only reading
+func (s *Server) tokenAuth(w http.ResponseWriter, r *http.Request, h http.Handler, secret string) {
+	tok, ok := s.tokens.Authenticate(secret)
+	var u engine.User
+	if ok && tok.Owner != "" {
+		u, ok = s.users.Get(tok.Owner)
+	} else if ok && s.users.Len() > 0 {
+		// of the single account, ignored as --auth is
+		ok = false
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="simple-torrent"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), tokenKey{}, tok))
+	if tok.Scope == engine.ScopeRead {
+		u.Role = engine.RoleReadOnly
+	} else if tok.Owner == "" {
+		// the single account is an admin
+		h.ServeHTTP(w, r)
+		return
+	}
+	s.serveAs(w, r, h, u)
+}
+
+// apiTokens creates or revokes the tokens of the user:
+// {"Action":"create","Name","Scope":"read|readwrite"} returns the token once,
+// {"Action":"revoke","ID"}. Tokens can't manage the tokens.
+func (s *Server) apiTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(tokenKey{}) != nil {
+		http.Error(w, errForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := struct {
+		Action, ID, Name, Scope string
+	}{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var owner string
+	if u := requestUser(r); u != nil {
+		owner = u.Name
+	}
+
+	switch req.Action {
+	case "create":
+		secret, tok, err := s.tokens.Create(req.Name, owner, req.Scope)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[tokens] created %s %q of %q", tok.ID, tok.Name, owner)
+		w.Header().Set("Content-Type", "application/json")
+		common.HandleError(json.NewEncoder(w).Encode(struct {
+			Token string
+			engine.APIToken
+		}{secret, tok}))
+	case "revoke":
+		if err := s.tokens.Revoke(req.ID, owner, isAdmin(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[tokens] revoked %s", req.ID)
+		_, err := w.Write([]byte("OK"))
+		common.HandleError(err)
+	default:
+		http.Error(w, errInvalidReq.Error(), http.StatusBadRequest)
+	}
+}
+
+// apiTokenList lists the tokens of the user, all of them for the admins
+func (s *Server) apiTokenList(w http.ResponseWriter, r *http.Request) {
+	var owner string
+	if u := requestUser(r); u != nil {
+		owner = u.Name
+	}
+	common.HandleError(json.NewEncoder(w).Encode(s.tokens.List(owner, isAdmin(r))))
+}
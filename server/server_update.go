@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/common/selfupdate"
+)
+
+// the latest release is asked to GitHub at most this often
+const updateCheckInterval = time.Hour
+
+// updateState caches the last check of the latest release
+type updateState struct {
+	sync.Mutex
+	release  *selfupdate.Release
+	applying bool
+}
+
+func (s *Server) updater() *selfupdate.Updater {
+	return &selfupdate.Updater{Version: s.tpl.Version, AllowUnsigned: s.UnsignedUpdate}
+}
+
+// latestRelease returns the cached check, fetched again when older than
+// updateCheckInterval or refresh
+func (s *Server) latestRelease(ctx context.Context, refresh bool) (*selfupdate.Release, error) {
+	s.update.Lock()
+	defer s.update.Unlock()
+	if rel := s.update.release; rel != nil && !refresh && time.Since(rel.CheckedAt) < updateCheckInterval {
+		return rel, nil
+	}
+	rel, err := s.updater().Check(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.update.release = rel
+	return rel, nil
+}
+
+// apiUpdateCheck reports whether a newer release is available: /api/update[?refresh=1]
+func (s *Server) apiUpdateCheck(w http.ResponseWriter, r *http.Request) error {
+	rel, err := s.latestRelease(r.Context(), r.URL.Query().Get("refresh") != "")
+	if err != nil {
+		return err
+	}
+	common.HandleError(json.NewEncoder(w).Encode(rel))
+	return nil
+}
+
+// apiUpdate installs the latest release and restarts into it, the body
+// "force" installs it even if it's not newer
+func (s *Server) apiUpdate(data []byte) error {
+	s.update.Lock()
+	if s.update.applying {
+		s.update.Unlock()
+		return errInvalidReq
+	}
+	s.update.applying = true
+	s.update.Unlock()
+	defer func() {
+		s.update.Lock()
+		s.update.applying = false
+		s.update.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	rel, err := s.latestRelease(ctx, true)
+	if err != nil {
+		return err
+	}
+	exe, err := s.updater().Apply(ctx, rel, string(data) == "force")
+	if err != nil {
+		return err
+	}
+	log.Printf("[update] installed %s to %s, restarting", rel.Latest, exe)
+	return s.restart(exe)
+}
+
+// restart has Run shut down as on a signal and run exe, the shutdown waits
+// for the response in flight
+func (s *Server) restart(exe string) error {
+	select {
+	case s.restartc <- exe:
+		return nil
+	default:
+		return errInvalidReq
+	}
+}
+
+// apiRestart restarts the server into its executable, eg: installed by the
+// update command
+func (s *Server) apiRestart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	log.Printf("[update] restarting into %s", exe)
+	return s.restart(exe)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const (
+	maxZipSize     = 64 << 20
+	maxTorrentSize = 512 * 1024
+)
+
+// zipResult is the report of one file of the archive
+type zipResult struct {
+	File     string `json:"file"`
+	InfoHash string `json:"infohash,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// apiTorrentZip adds every .torrent of a zip archive: POST /api/torrentzip[?dir=...]
+// and reports the result of each file
+func (s *Server) apiTorrentZip(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxZipSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Failed to read the archive: %v", err), http.StatusBadRequest)
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Invalid zip archive: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	dir := r.URL.Query().Get("dir")
+	results := []zipResult{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.HasSuffix(strings.ToLower(f.Name), ".torrent") ||
+			strings.HasPrefix(path.Base(f.Name), ".") {
+			continue
+		}
+		results = append(results, s.addZipEntry(r, f, dir))
+	}
+	s.state.Push()
+
+	w.Header().Set("Content-Type", "application/json")
+	common.HandleError(json.NewEncoder(w).Encode(results))
+}
+
+func (s *Server) addZipEntry(r *http.Request, f *zip.File, dir string) zipResult {
+	res := zipResult{File: f.Name}
+	fail := func(err error) zipResult {
+		res.Status = "error"
+		res.Error = err.Error()
+		return res
+	}
+
+	if f.UncompressedSize64 > maxTorrentSize {
+		return fail(errors.New("torrent too large"))
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fail(err)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxTorrentSize))
+	rc.Close()
+	if err != nil {
+		return fail(err)
+	}
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return fail(err)
+	}
+	res.InfoHash = mi.HashInfoBytes().HexString()
+
+	_, exists := s.engine.Torrent(res.InfoHash)
+	if exists {
+		// its trackers are merged into the task of the user only
+		if err := s.checkOwner(r, res.InfoHash); err != nil {
+			return fail(err)
+		}
+		res.Status = "exists"
+		var merged *engine.MergedError
+		if err := s.engine.NewTorrentByReader(bytes.NewReader(data), dir); errors.As(err, &merged) && merged.Trackers+merged.WebSeeds > 0 {
+			res.Status = "merged"
+		}
+		return res
+	}
+
+	if err := s.presetOwner(r, data); err != nil {
+		return fail(err)
+	}
+	switch err := s.engine.NewTorrentByReader(bytes.NewReader(data), dir); {
+	case err == nil:
+		res.Status = "added"
+	case errors.Is(err, engine.ErrMaxConnTasks):
+		res.Status = "queued"
+	default:
+		return fail(err)
+	}
+	return res
+}
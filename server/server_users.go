@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+	"github.com/jpillora/cookieauth"
+	"github.com/spf13/viper"
+)
+
+// usersFile next to the config file keeps the accounts
+const usersFile = "users.json"
+
+var errForbidden = errors.New("forbidden for the role")
+
+type userKey struct{}
+
+// openUsers opens the accounts next to the config file
+func (s *Server) openUsers() error {
+	p := filepath.Join(filepath.Dir(viper.ConfigFileUsed()), usersFile)
+	users, err := engine.OpenUserStore(p)
+	if err != nil {
+		return err
+	}
+	s.users = users
+	s.engine.SetUserStore(users)
+	if n := users.Len(); n > 0 {
+		log.Printf("[users] %d accounts in %s, --auth ignored", n, p)
+	}
+	return nil
+}
+
+// authWrap checks the API tokens, the OpenID Connect sessions, then the
+// accounts of the users file, the single --auth account, or nothing, while
+// the users file is empty
+func (s *Server) authWrap(h http.Handler) http.Handler {
+	single := h
+	if s.Auth != "" {
+		user, pass := splitAuth(s.Auth)
+		single = cookieauth.New().SetUserPass(user, pass).Wrap(h)
+		log.Printf("Enabled HTTP authentication")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret, ok := bearerToken(r); ok {
+			s.tokenAuth(w, r, h, secret)
+			return
+		}
+		if s.oidc != nil && s.oidcWrap(w, r, h) {
+			return
+		}
+		if s.users.Len() == 0 {
+			if name, pass, ok := r.BasicAuth(); ok && s.Auth != "" {
+				s.countLogin(r, s.checkLogin(name, pass))
+			}
+			single.ServeHTTP(w, r)
+			return
+		}
+		name, pass, ok := r.BasicAuth()
+		var u engine.User
+		if ok {
+			u, ok = s.users.Authenticate(name, pass)
+			s.countLogin(r, ok)
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="simple-torrent"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.serveAs(w, r, h, u)
+	})
+}
+
+// serveAs serves the request of the user, only reading for the readonly
+func (s *Server) serveAs(w http.ResponseWriter, r *http.Request, h http.Handler, u engine.User) {
+	if u.Role == engine.RoleReadOnly && r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		http.Error(w, errForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+}
+
+// checkLogin checks the account for the other protocols
+func (s *Server) checkLogin(name, pass string) bool {
+	if s.users.Len() > 0 {
+		_, ok := s.users.Authenticate(name, pass)
+		return ok
+	}
+	if s.Auth == "" {
+		return true
+	}
+	user, password := splitAuth(s.Auth)
+	u := subtle.ConstantTimeCompare([]byte(name), []byte(user))
+	p := subtle.ConstantTimeCompare([]byte(pass), []byte(password))
+	return u&p == 1
+}
+
+// qbitLogin checks the login of the qBittorrent API, counted for the lockout
+func (s *Server) qbitLogin(r *http.Request, name, pass string) bool {
+	ok := s.checkLogin(name, pass)
+	s.countLogin(r, ok)
+	return ok
+}
+
+func splitAuth(auth string) (string, string) {
+	if up := strings.SplitN(auth, ":", 2); len(up) == 2 {
+		return up[0], up[1]
+	}
+	return auth, ""
+}
+
+// requestUser returns the user of the request, nil without the users file
+// or from the trusted RestAPI port, who is an admin
+func requestUser(r *http.Request) *engine.User {
+	if u, ok := r.Context().Value(userKey{}).(engine.User); ok {
+		return &u
+	}
+	return nil
+}
+
+// apiErrorStatus is the status of the failed API requests
+func apiErrorStatus(err error) int {
+	if errors.Is(err, errForbidden) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, engine.ErrDiskSpace) {
+		return http.StatusInsufficientStorage
+	}
+	if errors.Is(err, engine.ErrShuttingDown) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, errIdempotencyMismatch) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
+
+func isAdmin(r *http.Request) bool {
+	u := requestUser(r)
+	return u == nil || u.Role == engine.RoleAdmin
+}
+
+func isReadOnly(r *http.Request) bool {
+	u := requestUser(r)
+	return u != nil && u.Role == engine.RoleReadOnly
+}
+
+// checkOwner allows the admins and the user added the task
+func (s *Server) checkOwner(r *http.Request, infohash string) error {
+	return s.checkUserOwner(requestUser(r), infohash)
+}
+
+// checkUserOwner allows the admins, nil is, and the user added the task
+func (s *Server) checkUserOwner(u *engine.User, infohash string) error {
+	if u == nil || u.Role == engine.RoleAdmin {
+		return nil
+	}
+	if u.Role == engine.RoleReadOnly {
+		return errForbidden
+	}
+	t, ok := s.engine.Torrent(infohash)
+	if !ok {
+		return nil
+	}
+	t.Lock()
+	owner := t.AddedBy
+	t.Unlock()
+	if owner != u.Name {
+		return fmt.Errorf("%w: task %s added by another user", errForbidden, infohash)
+	}
+	return nil
+}
+
+// presetOwner tells the engine the user adding the magnet or torrent
+func (s *Server) presetOwner(r *http.Request, data []byte) error {
+	return s.presetUserOwner(requestUser(r), data)
+}
+
+func (s *Server) presetUserOwner(u *engine.User, data []byte) error {
+	if u == nil {
+		return nil
+	}
+	if u.Role == engine.RoleReadOnly {
+		return errForbidden
+	}
+	return s.engine.PresetOwner(data, u.Name)
+}
+
+// loginUser returns the account logged in to the qBittorrent API as name,
+// nil for the single --auth account. The accounts deleted since only read
+func (s *Server) loginUser(name string) *engine.User {
+	if s.users.Len() == 0 {
+		return nil
+	}
+	u, ok := s.users.Get(name)
+	if !ok {
+		return &engine.User{Name: name, Role: engine.RoleReadOnly}
+	}
+	return &u
+}
+
+// qbitCheckOwner checks the task changed by the login of the qBittorrent API
+func (s *Server) qbitCheckOwner(name, infohash string) error {
+	return s.checkUserOwner(s.loginUser(name), infohash)
+}
+
+// qbitPresetOwner presets the owner of the task added by the login of the
+// qBittorrent API
+func (s *Server) qbitPresetOwner(name string, data []byte) error {
+	return s.presetUserOwner(s.loginUser(name), data)
+}
+
+// apiUsers manages the accounts: {"Action":"set|delete","Name",
+// "Password","Role","MaxTasks","DiskQuota"}, the first one an admin
+func (s *Server) apiUsers(r *http.Request, data []byte) error {
+	if !isAdmin(r) {
+		return errForbidden
+	}
+	req := struct {
+		Action   string
+		Password string
+		engine.User
+	}{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	req.PasswordHash = ""
+	switch req.Action {
+	case "set":
+		log.Printf("[users] set %s as %s", req.Name, req.Role)
+		return s.users.Set(req.User, req.Password)
+	case "delete":
+		log.Printf("[users] delete %s", req.Name)
+		if err := s.users.Delete(req.Name); err != nil {
+			return err
+		}
+		return s.tokens.RevokeOwner(req.Name)
+	}
+	return errInvalidReq
+}
+
+// apiWhoami returns the user of the request, an admin without the users
+func (s *Server) apiWhoami(w http.ResponseWriter, r *http.Request) {
+	u := requestUser(r)
+	if u == nil {
+		u = &engine.User{Role: engine.RoleAdmin}
+	}
+	common.HandleError(json.NewEncoder(w).Encode(u))
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+func TestReadOnlyUser(t *testing.T) {
+	s := &Server{}
+	ro := engine.User{Name: "viewer", Role: engine.RoleReadOnly}
+	as := func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), userKey{}, ro))
+	}
+	magnet := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"
+
+	// the GET actions adding tasks
+	for _, action := range []string{"magnet", "metadata"} {
+		r := as(httptest.NewRequest(http.MethodGet, "/api/"+action+"?m="+magnet, nil))
+		if err := s.apiGET(httptest.NewRecorder(), r); !errors.Is(err, errForbidden) {
+			t.Errorf("GET %s: %v, want forbidden", action, err)
+		}
+	}
+
+	// the changes by the other methods
+	served := false
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true })
+	w := httptest.NewRecorder()
+	s.serveAs(w, httptest.NewRequest(http.MethodPost, "/api/magnet", nil), h, ro)
+	if served || w.Code != http.StatusForbidden {
+		t.Errorf("POST served %v: %d", served, w.Code)
+	}
+
+	// the url adds are refused before fetching
+	fetched := false
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fetched = true }))
+	defer remote.Close()
+	for _, action := range []string{"url", "searchadd"} {
+		r := as(httptest.NewRequest(http.MethodPost, "/api/"+action, strings.NewReader(remote.URL)))
+		if err := s.apiPOST(r); !errors.Is(err, errForbidden) || fetched {
+			t.Errorf("POST %s: %v fetched %v, want forbidden", action, err, fetched)
+		}
+	}
+
+	// the adds and the changes by the other APIs
+	if err := s.presetUserOwner(&ro, []byte(magnet)); !errors.Is(err, errForbidden) {
+		t.Errorf("preset owner: %v, want forbidden", err)
+	}
+	if err := s.checkUserOwner(&ro, "0123456789abcdef0123456789abcdef01234567"); !errors.Is(err, errForbidden) {
+		t.Errorf("check owner: %v, want forbidden", err)
+	}
+}
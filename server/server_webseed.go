@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+// webseedBypass serves /webseed/ before the auth layer so that peers can
+// fetch, other requests go to next
+func (s *Server) webseedBypass(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, engine.WebseedPath) && s.engineConfig.WebseedURL != "" {
+			s.serveWebseed(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveWebseed serves /webseed/<infohash>/<filepath> as a BEP 19 web seed,
+// only the files of the tasks of engine.WebseedServed
+func (s *Server) serveWebseed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, engine.WebseedPath), "/", 2)
+	if len(parts) != 2 || len(parts[0]) != 40 || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.engine.WebseedServed(parts[0]) {
+		http.NotFound(w, r)
+		return
+	}
+
+	reader, f, err := s.engine.NewFileReader(r.Context(), parts[0], parts[1])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() {
+		common.HandleError(reader.Close())
+	}()
+
+	w.Header().Set("Content-Encoding", "identity")
+	http.ServeContent(w, r, path.Base(f.Path), time.Time{}, reader)
+}
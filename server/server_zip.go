@@ -0,0 +1,83 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common"
+)
+
+// serveTorrentZip streams a zip of the completed files of the task, stored
+// without compression: GET /download/zip/<infohash>[/subdir]. It returns
+// false if the infohash isn't of a task
+func (s *Server) serveTorrentZip(w http.ResponseWriter, r *http.Request, p string) bool {
+	parts := strings.SplitN(p, "/", 2)
+	if len(parts[0]) != 40 {
+		return false
+	}
+	ih := strings.ToLower(parts[0])
+	if _, ok := s.engine.Torrent(ih); !ok {
+		return false
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
+		return true
+	}
+	if err := s.checkOwner(r, ih); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return true
+	}
+	var dir string
+	if len(parts) == 2 {
+		dir = parts[1]
+	}
+	root, entries, err := s.engine.ZipEntries(ih, dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", root+".zip"))
+	// already stored, avoid gzip buffering
+	w.Header().Set("Content-Encoding", "identity")
+	if r.Method == "HEAD" {
+		return true
+	}
+	zw := zip.NewWriter(w)
+	modified := time.Now()
+	for _, e := range entries {
+		if err := s.writeZipEntry(r.Context(), zw, ih, e.Path, &zip.FileHeader{
+			Name:               e.Name,
+			Method:             zip.Store,
+			Modified:           modified,
+			UncompressedSize64: uint64(e.Size),
+		}); err != nil {
+			// the response is started, the zip is left truncated
+			log.Warnf("[zip] %s %s: %v", ih, e.Path, err)
+			return true
+		}
+	}
+	common.HandleError(zw.Close())
+	return true
+}
+
+func (s *Server) writeZipEntry(ctx context.Context, zw *zip.Writer, ih, path string, fh *zip.FileHeader) error {
+	reader, _, err := s.engine.NewFileReader(ctx, ih, path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	fw, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	// the reader may run past the end of the file
+	_, err = io.CopyN(fw, reader, int64(fh.UncompressedSize64))
+	return err
+}
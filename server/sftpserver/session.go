@@ -0,0 +1,498 @@
+package sftpserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// packet types of SFTP version 3
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpReadlink = 19
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+)
+
+// status codes
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+const (
+	attrSize        = 0x1
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+
+	// only reading is served, the write, create, truncate and append flags
+	// of OPEN are denied
+	openRead = 0x1
+
+	sftpVersion = 3
+	// largest packet taken from the clients, and data sent by READ
+	maxPacket = 256 << 10
+	maxRead   = 32 << 10
+	// entries sent by a READDIR
+	readdirBatch = 100
+)
+
+var (
+	errBadMessage = errors.New("bad message")
+	errHidden     = errors.New("no such file or directory")
+)
+
+// session serves the sftp packets of a channel
+type session struct {
+	s    *Server
+	user string
+	rw   io.ReadWriter
+
+	handles    map[string]*handle
+	nextHandle int
+}
+
+// handle is an open file, or a directory with the entries left to send
+type handle struct {
+	f       *os.File
+	entries []os.FileInfo
+	dir     bool
+}
+
+func newSession(s *Server, user string, rw io.ReadWriter) *session {
+	return &session{s: s, user: user, rw: rw, handles: make(map[string]*handle)}
+}
+
+func (ss *session) serve() {
+	defer ss.close()
+	for {
+		typ, data, err := readPacket(ss.rw)
+		if err != nil {
+			if err != io.EOF {
+				log.Warn(ss.user, err)
+			}
+			return
+		}
+		if err := ss.handle(typ, data); err != nil {
+			log.Warn(ss.user, err)
+			return
+		}
+	}
+}
+
+func (ss *session) close() {
+	for _, h := range ss.handles {
+		if h.f != nil {
+			h.f.Close()
+		}
+	}
+	ss.handles = nil
+}
+
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 1 || n > maxPacket {
+		return 0, nil, fmt.Errorf("invalid packet length %d", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return data[0], data[1:], nil
+}
+
+// packet builds a packet to send
+type packet []byte
+
+func newPacket(typ byte, id uint32) packet {
+	p := packet{0, 0, 0, 0, typ}
+	if typ != fxpVersion {
+		p = p.uint32(id)
+	}
+	return p
+}
+
+func (p packet) uint32(v uint32) packet {
+	return append(p, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (p packet) uint64(v uint64) packet {
+	return p.uint32(uint32(v >> 32)).uint32(uint32(v))
+}
+
+func (p packet) string(s string) packet {
+	return append(p.uint32(uint32(len(s))), s...)
+}
+
+func (p packet) attrs(fi os.FileInfo) packet {
+	p = p.uint32(attrSize | attrPermissions | attrACModTime)
+	p = p.uint64(uint64(fi.Size()))
+	p = p.uint32(fileMode(fi.Mode()))
+	mtime := uint32(fi.ModTime().Unix())
+	return p.uint32(mtime).uint32(mtime)
+}
+
+func (ss *session) send(p packet) error {
+	binary.BigEndian.PutUint32(p, uint32(len(p)-4))
+	_, err := ss.rw.Write(p)
+	return err
+}
+
+func (ss *session) sendStatus(id uint32, code uint32, msg string) error {
+	return ss.send(newPacket(fxpStatus, id).uint32(code).string(msg).string(""))
+}
+
+// sendError sends the status of err
+func (ss *session) sendError(id uint32, err error) error {
+	switch {
+	case err == errHidden, os.IsNotExist(err):
+		return ss.sendStatus(id, fxNoSuchFile, "No such file")
+	case os.IsPermission(err):
+		return ss.sendStatus(id, fxPermissionDenied, "Permission denied")
+	case err == errBadMessage:
+		return ss.sendStatus(id, fxBadMessage, err.Error())
+	}
+	return ss.sendStatus(id, fxFailure, err.Error())
+}
+
+// reader reads the fields of a packet received
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = errBadMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	return uint64(r.uint32())<<32 | uint64(r.uint32())
+}
+
+func (r *reader) string() string {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.err = errBadMessage
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// handle answers the packet, an error closes the session
+func (ss *session) handle(typ byte, data []byte) error {
+	r := &reader{b: data}
+	if typ == fxpInit {
+		// the extensions aren't served
+		return ss.send(newPacket(fxpVersion, 0).uint32(sftpVersion))
+	}
+	id := r.uint32()
+	if r.err != nil {
+		return r.err
+	}
+	switch typ {
+	case fxpRealpath:
+		p := cleanPath(r.string())
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		fi := dirInfo{name: path.Base(p)}
+		return ss.send(newPacket(fxpName, id).uint32(1).string(p).string(longName(fi, time.Now())).attrs(fi))
+	case fxpStat, fxpLstat:
+		p := r.string()
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		fi, err := ss.stat(p)
+		if err != nil {
+			return ss.sendError(id, err)
+		}
+		return ss.send(newPacket(fxpAttrs, id).attrs(fi))
+	case fxpOpen:
+		p, flags := r.string(), r.uint32()
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		if flags&^openRead != 0 {
+			return ss.sendStatus(id, fxPermissionDenied, "Read only")
+		}
+		return ss.open(id, p)
+	case fxpOpendir:
+		p := r.string()
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		return ss.opendir(id, p)
+	case fxpRead:
+		h, off, n := ss.handles[r.string()], r.uint64(), r.uint32()
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		if h == nil || h.dir {
+			return ss.sendStatus(id, fxFailure, "Invalid handle")
+		}
+		if n > maxRead {
+			n = maxRead
+		}
+		buf := make([]byte, n)
+		got, err := h.f.ReadAt(buf, int64(off))
+		if got == 0 && err != nil {
+			if err == io.EOF {
+				return ss.sendStatus(id, fxEOF, "EOF")
+			}
+			return ss.sendError(id, err)
+		}
+		return ss.send(newPacket(fxpData, id).string(string(buf[:got])))
+	case fxpFstat:
+		h := ss.handles[r.string()]
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		if h == nil || h.dir {
+			return ss.sendStatus(id, fxFailure, "Invalid handle")
+		}
+		fi, err := h.f.Stat()
+		if err != nil {
+			return ss.sendError(id, err)
+		}
+		return ss.send(newPacket(fxpAttrs, id).attrs(fi))
+	case fxpReaddir:
+		h := ss.handles[r.string()]
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		if h == nil || !h.dir {
+			return ss.sendStatus(id, fxFailure, "Invalid handle")
+		}
+		if len(h.entries) == 0 {
+			return ss.sendStatus(id, fxEOF, "EOF")
+		}
+		batch := h.entries
+		if len(batch) > readdirBatch {
+			batch = batch[:readdirBatch]
+		}
+		h.entries = h.entries[len(batch):]
+		now := time.Now()
+		p := newPacket(fxpName, id).uint32(uint32(len(batch)))
+		for _, fi := range batch {
+			p = p.string(fi.Name()).string(longName(fi, now)).attrs(fi)
+		}
+		return ss.send(p)
+	case fxpClose:
+		key := r.string()
+		if r.err != nil {
+			return ss.sendError(id, r.err)
+		}
+		h, ok := ss.handles[key]
+		if !ok {
+			return ss.sendStatus(id, fxFailure, "Invalid handle")
+		}
+		delete(ss.handles, key)
+		if h.f != nil {
+			h.f.Close()
+		}
+		return ss.sendStatus(id, fxOK, "OK")
+	case fxpReadlink:
+		return ss.sendStatus(id, fxOpUnsupported, "Unsupported")
+	}
+	// writes, removes, renames and links
+	return ss.sendStatus(id, fxPermissionDenied, "Read only")
+}
+
+func (ss *session) addHandle(h *handle) (string, error) {
+	if len(ss.handles) >= maxHandles {
+		return "", errors.New("too many open handles")
+	}
+	ss.nextHandle++
+	key := strconv.Itoa(ss.nextHandle)
+	ss.handles[key] = h
+	return key, nil
+}
+
+func (ss *session) open(id uint32, p string) error {
+	fp, err := ss.resolve(p)
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(fp)
+	}
+	if err != nil {
+		return ss.sendError(id, err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		return ss.sendStatus(id, fxFailure, "Not a file")
+	}
+	key, err := ss.addHandle(&handle{f: f})
+	if err != nil {
+		f.Close()
+		return ss.sendError(id, err)
+	}
+	return ss.send(newPacket(fxpHandle, id).string(key))
+}
+
+func (ss *session) opendir(id uint32, p string) error {
+	entries, err := ss.readDir(p)
+	if err != nil {
+		return ss.sendError(id, err)
+	}
+	key, err := ss.addHandle(&handle{entries: entries, dir: true})
+	if err != nil {
+		return ss.sendError(id, err)
+	}
+	return ss.send(newPacket(fxpHandle, id).string(key))
+}
+
+// resolve returns the file of the path in the chroot of the user, empty for
+// the virtual root listing the mounts
+func (ss *session) resolve(p string) (string, error) {
+	vp := cleanPath(p)
+	names := strings.Split(strings.TrimPrefix(vp, "/"), "/")
+	for _, name := range names {
+		if name != "" && ss.s.hidden(name) {
+			return "", errHidden
+		}
+	}
+	c := ss.s.Chroot(ss.user)
+	if c.Dir != "" {
+		return filepath.Join(c.Dir, filepath.FromSlash(vp)), nil
+	}
+	if vp == "/" {
+		return "", nil
+	}
+	mount, ok := c.Mounts[names[0]]
+	if !ok {
+		return "", errHidden
+	}
+	return filepath.Join(append([]string{mount}, names[1:]...)...), nil
+}
+
+func (ss *session) stat(p string) (os.FileInfo, error) {
+	fp, err := ss.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	if fp == "" {
+		return dirInfo{name: "/"}, nil
+	}
+	return os.Stat(fp)
+}
+
+// readDir lists the directory sorted by name, the hidden names left out
+func (ss *session) readDir(p string) ([]os.FileInfo, error) {
+	fp, err := ss.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	var list []os.FileInfo
+	if fp != "" {
+		if list, err = ioutil.ReadDir(fp); err != nil {
+			return nil, err
+		}
+	} else {
+		for name, mount := range ss.s.Chroot(ss.user).Mounts {
+			fi, err := os.Stat(mount)
+			if err != nil {
+				continue
+			}
+			list = append(list, namedInfo{fi, name})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	}
+	entries := list[:0]
+	for _, fi := range list {
+		if !ss.s.hidden(fi.Name()) {
+			entries = append(entries, fi)
+		}
+	}
+	return entries, nil
+}
+
+// cleanPath cleans the path, relative ones are to the root
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// fileMode returns the unix mode of m
+func fileMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	switch {
+	case m.IsDir():
+		mode |= 0040000
+	case m&os.ModeSymlink != 0:
+		mode |= 0120000
+	default:
+		mode |= 0100000
+	}
+	return mode
+}
+
+// longName is the listing line of the entry as by ls -l
+func longName(fi os.FileInfo, now time.Time) string {
+	mode := "-" + fi.Mode().Perm().String()[1:]
+	if fi.IsDir() {
+		mode = "d" + mode[1:]
+	}
+	stamp := fi.ModTime().Format("Jan _2 15:04")
+	if t := fi.ModTime(); now.Sub(t) > 180*24*time.Hour || t.After(now) {
+		stamp = t.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 sftp sftp %12d %s %s", mode, fi.Size(), stamp, fi.Name())
+}
+
+// dirInfo is the virtual root and the paths given by REALPATH
+type dirInfo struct {
+	name string
+}
+
+func (d dirInfo) Name() string       { return d.name }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return time.Unix(0, 0) }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
+
+// namedInfo is a mount listed at the virtual root by its name
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (n namedInfo) Name() string { return n.name }
@@ -0,0 +1,177 @@
+// Package sftpserver is a read-only SFTP server over SSH, each user chrooted
+// to a directory or to the paths mounted at the root, for the seedbox users
+// pulling the files with sftp, sshfs or rclone.
+// spec: draft-ietf-secsh-filexfer-02 (SFTP version 3)
+package sftpserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// time given to the SSH handshake
+	handshakeTimeout = 30 * time.Second
+	// open files and directories of a session
+	maxHandles = 64
+	// failed logins before the connection is closed
+	maxLoginFails = 3
+)
+
+var log *logging.Logger
+
+// Chroot is the tree served to a user, the directory Dir, or the paths of
+// Mounts listed at the root when Dir is empty
+type Chroot struct {
+	Dir    string
+	Mounts map[string]string
+}
+
+// Server serves the chroots of the users read only
+type Server struct {
+	HostKey ssh.Signer
+	Login   func(user, pass string) bool
+	// the chroot of the user, asked on every request as the tasks of the
+	// user may change while connected
+	Chroot func(user string) Chroot
+	// names hidden from the listings and never served
+	Hidden func(name string) bool
+
+	mu       sync.Mutex
+	listener net.Listener
+}
+
+// ListenAndServe serves the connections on addr until Close
+func (s *Server) ListenAndServe(addr string) error {
+	if s.HostKey == nil || s.Login == nil || s.Chroot == nil {
+		return errors.New("sftp server needs a host key, the login and the chroots")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	log.Println("listening at", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) config() *ssh.ServerConfig {
+	c := &ssh.ServerConfig{
+		MaxAuthTries: maxLoginFails,
+		PasswordCallback: func(meta ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if s.Login(meta.User(), string(pass)) {
+				return nil, nil
+			}
+			log.Warnf("login of %s from %s failed", meta.User(), meta.RemoteAddr())
+			return nil, errors.New("invalid user or password")
+		},
+	}
+	c.AddHostKey(s.HostKey)
+	return c
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	c.SetDeadline(time.Now().Add(handshakeTimeout)) // nolint: errcheck
+	conn, chans, reqs, err := ssh.NewServerConn(c, s.config())
+	if err != nil {
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{}) // nolint: errcheck
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions are served") // nolint: errcheck
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveChannel(conn.User(), ch, chReqs)
+	}
+}
+
+// serveChannel serves the sftp subsystem of the session, no shell nor exec
+func (s *Server) serveChannel(user string, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "subsystem":
+			ok = len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		case "env":
+			ok = true
+		}
+		if req.WantReply {
+			req.Reply(ok, nil) // nolint: errcheck
+		}
+		if ok && req.Type == "subsystem" {
+			go ssh.DiscardRequests(reqs)
+			newSession(s, user, ch).serve()
+			return
+		}
+	}
+}
+
+func (s *Server) hidden(name string) bool {
+	return s.Hidden != nil && s.Hidden(name)
+}
+
+// LoadHostKey reads the host key at path, a new ed25519 key is written there
+// when missing
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+		log.Println("generated host key", path)
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+func init() {
+	log = logging.New("sftp")
+}
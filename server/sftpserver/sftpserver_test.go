@@ -0,0 +1,197 @@
+package sftpserver
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_fileMode(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want uint32
+	}{
+		{0644, 0100644},
+		{os.ModeDir | 0755, 0040755},
+		{os.ModeSymlink | 0777, 0120777},
+	}
+	for _, tt := range tests {
+		if got := fileMode(tt.mode); got != tt.want {
+			t.Errorf("fileMode(%s) = %o, want %o", tt.mode, got, tt.want)
+		}
+	}
+}
+
+// client speaks the sftp packets of the tests
+type client struct {
+	t  *testing.T
+	rw io.ReadWriter
+	id uint32
+}
+
+func (c *client) call(typ byte, p packet) (byte, *reader) {
+	c.t.Helper()
+	c.id++
+	p = append(newPacket(typ, c.id), p...)
+	binary.BigEndian.PutUint32(p, uint32(len(p)-4))
+	if _, err := c.rw.Write(p); err != nil {
+		c.t.Fatal(err)
+	}
+	rtyp, data, err := readPacket(c.rw)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	r := &reader{b: data}
+	if id := r.uint32(); id != c.id {
+		c.t.Fatalf("reply id %d, want %d", id, c.id)
+	}
+	return rtyp, r
+}
+
+func (c *client) status(typ byte, p packet) uint32 {
+	c.t.Helper()
+	rtyp, r := c.call(typ, p)
+	if rtyp != fxpStatus {
+		c.t.Fatalf("reply %d, want status", rtyp)
+	}
+	return r.uint32()
+}
+
+func (c *client) handle(typ byte, p packet) string {
+	c.t.Helper()
+	rtyp, r := c.call(typ, p)
+	if rtyp != fxpHandle {
+		c.t.Fatalf("reply %d, want handle", rtyp)
+	}
+	return r.string()
+}
+
+func TestServer_chroot(t *testing.T) {
+	root, err := ioutil.TempDir("", "sftpserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, d := range []string{"movie", "other", ".torrents"} {
+		if err := os.Mkdir(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "movie", "movie.mkv"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := LoadHostKey(filepath.Join(root, ".hostkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		HostKey: key,
+		Login:   func(user, pass string) bool { return user == "user" && pass == "pass" },
+		Chroot: func(user string) Chroot {
+			return Chroot{Mounts: map[string]string{"movie": filepath.Join(root, "movie")}}
+		},
+		Hidden: func(name string) bool { return strings.HasPrefix(name, ".") },
+	}
+	s.listener = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn)
+		}
+	}()
+	defer s.Close()
+
+	config := &ssh.ClientConfig{
+		User:            "user",
+		Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+		HostKeyCallback: ssh.FixedHostKey(key.PublicKey()),
+	}
+	if _, err := ssh.Dial("tcp", l.Addr().String(), config); err == nil {
+		t.Fatal("login with a wrong password")
+	}
+	config.Auth = []ssh.AuthMethod{ssh.Password("pass")}
+	conn, err := ssh.Dial("tcp", l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sess, err := conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	if err := sess.Run("ls"); err == nil {
+		t.Error("exec served")
+	}
+	if sess, err = conn.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := sess.StdinPipe()
+	r, _ := sess.StdoutPipe()
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+	c := &client{t: t, rw: struct {
+		io.Reader
+		io.Writer
+	}{r, w}}
+
+	if _, err := w.Write(packet{0, 0, 0, 5, fxpInit}.uint32(sftpVersion)); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _, err := readPacket(r); err != nil || typ != fxpVersion {
+		t.Fatal(typ, err)
+	}
+
+	// the root lists the mounts only
+	h := c.handle(fxpOpendir, packet{}.string("/"))
+	typ, rd := c.call(fxpReaddir, packet{}.string(h))
+	if typ != fxpName || rd.uint32() != 1 || rd.string() != "movie" {
+		t.Fatalf("READDIR / = %d", typ)
+	}
+	if code := c.status(fxpReaddir, packet{}.string(h)); code != fxEOF {
+		t.Errorf("READDIR at the end = %d", code)
+	}
+	c.status(fxpClose, packet{}.string(h))
+
+	if code := c.status(fxpStat, packet{}.string("/other")); code != fxNoSuchFile {
+		t.Errorf("STAT /other = %d", code)
+	}
+	if code := c.status(fxpStat, packet{}.string("/movie/../../.torrents")); code != fxNoSuchFile {
+		t.Errorf("STAT hidden = %d", code)
+	}
+	if code := c.status(fxpOpen, packet{}.string("/movie/new").uint32(0x1a).uint32(0)); code != fxPermissionDenied {
+		t.Errorf("OPEN for writing = %d", code)
+	}
+	// REMOVE
+	if code := c.status(13, packet{}.string("/movie/movie.mkv")); code != fxPermissionDenied {
+		t.Errorf("REMOVE = %d", code)
+	}
+
+	h = c.handle(fxpOpen, packet{}.string("movie/movie.mkv").uint32(openRead).uint32(0))
+	typ, rd = c.call(fxpRead, packet{}.string(h).uint64(4).uint32(100))
+	if got := rd.string(); typ != fxpData || got != "456789" {
+		t.Errorf("READ = %d %q", typ, got)
+	}
+	if code := c.status(fxpRead, packet{}.string(h).uint64(10).uint32(100)); code != fxEOF {
+		t.Errorf("READ at the end = %d", code)
+	}
+	if code := c.status(fxpClose, packet{}.string(h)); code != fxOK {
+		t.Errorf("CLOSE = %d", code)
+	}
+}
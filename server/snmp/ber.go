@@ -0,0 +1,229 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the BER tags of SNMP, RFC 1157 and RFC 3416
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+	tagCounter64   = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduSet      = 0xa3
+	pduGetBulk  = 0xa5
+)
+
+var errMalformed = errors.New("malformed BER")
+
+// OID is an object identifier, eg: 1.3.6.1.2.1.1.1.0
+type OID []uint32
+
+// ParseOID parses the dotted OID
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	oid := make(OID, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = uint32(n)
+	}
+	return oid, nil
+}
+
+// MustParseOID is ParseOID panicking on the error
+func MustParseOID(s string) OID {
+	oid, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+// Append returns the OID with the sub ids appended
+func (o OID) Append(ids ...uint32) OID {
+	return append(append(OID{}, o...), ids...)
+}
+
+func (o OID) String() string {
+	s := make([]string, len(o))
+	for i, n := range o {
+		s[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(s, ".")
+}
+
+// Compare orders the OIDs lexicographically, -1, 0 or 1
+func (o OID) Compare(b OID) int {
+	for i := 0; i < len(o) && i < len(b); i++ {
+		if o[i] != b[i] {
+			if o[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(b):
+		return -1
+	case len(o) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// readTLV splits the first element of b
+func readTLV(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag, n := b[0], int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < size {
+			return 0, nil, nil, errMalformed
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if len(b) < n {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// expect reads the element of the tag
+func expect(tag byte, b []byte) (content, rest []byte, err error) {
+	t, content, rest, err := readTLV(b)
+	if err == nil && t != tag {
+		err = fmt.Errorf("%w: tag %#x, expecting %#x", errMalformed, t, tag)
+	}
+	return content, rest, err
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errMalformed
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, errMalformed
+	}
+	oid := OID{uint32(b[0]) / 40, uint32(b[0]) % 40}
+	var n uint32
+	for i, c := range b[1:] {
+		if n > 1<<25 {
+			return nil, errMalformed
+		}
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		} else if i == len(b)-2 {
+			return nil, errMalformed
+		}
+	}
+	return oid, nil
+}
+
+func tlv(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func encodeInt(tag byte, n int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		if (n < 0x80 && n >= -0x80) || len(b) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return tlv(tag, b)
+}
+
+func encodeUint(tag byte, n uint64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return tlv(tag, b)
+}
+
+func encodeOID(oid OID) []byte {
+	if len(oid) < 2 {
+		return tlv(tagOID, []byte{0})
+	}
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		var sub []byte
+		for {
+			sub = append([]byte{byte(n & 0x7f)}, sub...)
+			n >>= 7
+			if n == 0 {
+				break
+			}
+		}
+		for i := 0; i < len(sub)-1; i++ {
+			sub[i] |= 0x80
+		}
+		b = append(b, sub...)
+	}
+	return tlv(tagOID, b)
+}
@@ -0,0 +1,332 @@
+// Package snmp is a read only SNMP v1/v2c agent of a few scalar values.
+// spec: RFC 1157 (v1), RFC 1901 and RFC 3416 (v2c)
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sort"
+
+	"github.com/boypt/simple-torrent/common/logging"
+)
+
+const (
+	version1  = 0
+	version2c = 1
+
+	// the error status of the responses
+	errNoSuchName  = 2
+	errNotWritable = 17
+
+	maxPacket      = 1472
+	maxRepetitions = 32
+)
+
+var log = logging.New("snmp")
+
+// the types of the values besides int as INTEGER and string as OCTET STRING
+type (
+	Gauge32   uint32
+	Counter64 uint64
+	// TimeTicks are hundredths of a second
+	TimeTicks uint32
+)
+
+// Var is a value of the MIB
+type Var struct {
+	OID   OID
+	Value interface{}
+}
+
+// Agent answers the Get, GetNext and GetBulk requests of the community
+type Agent struct {
+	Community string
+	// MIB returns the current values, in any order
+	MIB func() []Var
+
+	conn net.PacketConn
+}
+
+// ListenAndServe serves the requests on the UDP address
+func (a *Agent) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	a.conn = conn
+	log.Println("listening at", conn.LocalAddr())
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		resp, err := a.Handle(buf[:n])
+		if err != nil {
+			log.Warnf("%s: %v", from, err)
+			continue
+		}
+		if resp != nil {
+			if _, err := conn.WriteTo(resp, from); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+}
+
+// Close stops ListenAndServe
+func (a *Agent) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	return a.conn.Close()
+}
+
+// request is a decoded message
+type request struct {
+	version   int64
+	community []byte
+	pdu       byte
+	id        int64
+	// non-repeaters and max-repetitions of GetBulk
+	nonRep, maxRep int64
+	oids           []OID
+}
+
+func parseRequest(b []byte) (*request, error) {
+	msg, _, err := expect(tagSequence, b)
+	if err != nil {
+		return nil, err
+	}
+	r := &request{}
+	content, rest, err := expect(tagInteger, msg)
+	if err != nil {
+		return nil, err
+	}
+	if r.version, err = decodeInt(content); err != nil {
+		return nil, err
+	}
+	if r.community, rest, err = expect(tagOctetString, rest); err != nil {
+		return nil, err
+	}
+	var pdu []byte
+	if r.pdu, pdu, _, err = readTLV(rest); err != nil {
+		return nil, err
+	}
+	var fields [3]int64
+	for i := range fields {
+		if content, pdu, err = expect(tagInteger, pdu); err != nil {
+			return nil, err
+		}
+		if fields[i], err = decodeInt(content); err != nil {
+			return nil, err
+		}
+	}
+	r.id, r.nonRep, r.maxRep = fields[0], fields[1], fields[2]
+	list, _, err := expect(tagSequence, pdu)
+	if err != nil {
+		return nil, err
+	}
+	for len(list) > 0 {
+		var vb []byte
+		if vb, list, err = expect(tagSequence, list); err != nil {
+			return nil, err
+		}
+		if content, _, err = expect(tagOID, vb); err != nil {
+			return nil, err
+		}
+		oid, err := decodeOID(content)
+		if err != nil {
+			return nil, err
+		}
+		r.oids = append(r.oids, oid)
+	}
+	return r, nil
+}
+
+// Handle answers the request packet, nil for the ones dropped silently
+// as of a wrong community
+func (a *Agent) Handle(packet []byte) ([]byte, error) {
+	r, err := parseRequest(packet)
+	if err != nil {
+		return nil, err
+	}
+	if r.version != version1 && r.version != version2c {
+		return nil, nil
+	}
+	if !bytes.Equal(r.community, []byte(a.Community)) {
+		return nil, nil
+	}
+
+	mib := a.MIB()
+	if r.version == version1 {
+		// v1 has no Counter64
+		n := 0
+		for _, v := range mib {
+			if _, ok := v.Value.(Counter64); !ok {
+				mib[n] = v
+				n++
+			}
+		}
+		mib = mib[:n]
+	}
+	sort.Slice(mib, func(i, j int) bool { return mib[i].OID.Compare(mib[j].OID) < 0 })
+
+	var vbs [][]byte
+	var status, index int64
+	switch r.pdu {
+	case pduGet:
+		for i, oid := range r.oids {
+			v, ok := get(mib, oid)
+			if !ok {
+				if r.version == version1 {
+					status, index = errNoSuchName, int64(i+1)
+					break
+				}
+				v = Var{oid, exception(tagNoSuchObject)}
+			}
+			vbs = append(vbs, encodeVar(v))
+		}
+	case pduGetNext:
+		for i, oid := range r.oids {
+			v, ok := next(mib, oid)
+			if !ok {
+				if r.version == version1 {
+					status, index = errNoSuchName, int64(i+1)
+					break
+				}
+				v = Var{oid, exception(tagEndOfMibView)}
+			}
+			vbs = append(vbs, encodeVar(v))
+		}
+	case pduGetBulk:
+		if r.version == version1 {
+			return nil, nil
+		}
+		vbs = getBulk(mib, r)
+	case pduSet:
+		status, index = errNotWritable, 1
+		if r.version == version1 {
+			status = errNoSuchName
+		}
+	default:
+		return nil, nil
+	}
+	if status != 0 {
+		// the error responses have the varbinds of the request
+		vbs = vbs[:0]
+		for _, oid := range r.oids {
+			vbs = append(vbs, tlv(tagSequence, encodeOID(oid), tlv(tagNull)))
+		}
+	}
+	resp := encodeResponse(r, status, index, vbs)
+	for len(resp) > maxPacket && len(vbs) > 1 && r.pdu == pduGetBulk {
+		// the bulk responses are cut to fit
+		vbs = vbs[:len(vbs)/2]
+		resp = encodeResponse(r, 0, 0, vbs)
+	}
+	return resp, nil
+}
+
+func getBulk(mib []Var, r *request) [][]byte {
+	var vbs [][]byte
+	nonRep, maxRep := int(r.nonRep), int(r.maxRep)
+	if nonRep < 0 {
+		nonRep = 0
+	}
+	if nonRep > len(r.oids) {
+		nonRep = len(r.oids)
+	}
+	if maxRep < 0 {
+		maxRep = 0
+	}
+	if maxRep > maxRepetitions {
+		maxRep = maxRepetitions
+	}
+	for _, oid := range r.oids[:nonRep] {
+		v, ok := next(mib, oid)
+		if !ok {
+			v = Var{oid, exception(tagEndOfMibView)}
+		}
+		vbs = append(vbs, encodeVar(v))
+	}
+	repeat := append([]OID{}, r.oids[nonRep:]...)
+	for i := 0; i < maxRep && len(repeat) > 0; i++ {
+		end := true
+		for j, oid := range repeat {
+			v, ok := next(mib, oid)
+			if !ok {
+				v = Var{oid, exception(tagEndOfMibView)}
+			} else {
+				end = false
+				repeat[j] = v.OID
+			}
+			vbs = append(vbs, encodeVar(v))
+		}
+		if end {
+			break
+		}
+	}
+	return vbs
+}
+
+func encodeResponse(r *request, status, index int64, vbs [][]byte) []byte {
+	return tlv(tagSequence,
+		encodeInt(tagInteger, r.version),
+		tlv(tagOctetString, r.community),
+		tlv(pduResponse,
+			encodeInt(tagInteger, r.id),
+			encodeInt(tagInteger, status),
+			encodeInt(tagInteger, index),
+			tlv(tagSequence, vbs...),
+		),
+	)
+}
+
+// get finds the value of the OID in the sorted mib
+func get(mib []Var, oid OID) (Var, bool) {
+	i := sort.Search(len(mib), func(i int) bool { return mib[i].OID.Compare(oid) >= 0 })
+	if i < len(mib) && mib[i].OID.Compare(oid) == 0 {
+		return mib[i], true
+	}
+	return Var{}, false
+}
+
+// next finds the value following the OID in the sorted mib
+func next(mib []Var, oid OID) (Var, bool) {
+	i := sort.Search(len(mib), func(i int) bool { return mib[i].OID.Compare(oid) > 0 })
+	if i < len(mib) {
+		return mib[i], true
+	}
+	return Var{}, false
+}
+
+// exception is a v2c exception value, eg: noSuchObject
+type exception byte
+
+func encodeVar(v Var) []byte {
+	var val []byte
+	switch x := v.Value.(type) {
+	case int:
+		val = encodeInt(tagInteger, int64(x))
+	case int64:
+		val = encodeInt(tagInteger, x)
+	case string:
+		val = tlv(tagOctetString, []byte(x))
+	case Gauge32:
+		val = encodeUint(tagGauge32, uint64(x))
+	case TimeTicks:
+		val = encodeUint(tagTimeTicks, uint64(x))
+	case Counter64:
+		val = encodeUint(tagCounter64, uint64(x))
+	case exception:
+		val = tlv(byte(x))
+	default:
+		val = tlv(tagNull)
+	}
+	return tlv(tagSequence, encodeOID(v.OID), val)
+}
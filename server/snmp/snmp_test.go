@@ -0,0 +1,130 @@
+package snmp
+
+import (
+	"testing"
+)
+
+func Test_OID(t *testing.T) {
+	for _, s := range []string{"1.3.6.1.2.1.1.1.0", "1.3.6.1.4.1.8072.9999.9999.1.7.0", "1.3.6.1.4.1.4294967295"} {
+		oid := MustParseOID(s)
+		content, _, err := expect(tagOID, encodeOID(oid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeOID(content)
+		if err != nil || got.String() != s {
+			t.Errorf("decodeOID(encodeOID(%s)) = %s, %v", s, got, err)
+		}
+	}
+	if MustParseOID("1.3.6.1.2").Compare(MustParseOID("1.3.6.1.10")) != -1 ||
+		MustParseOID("1.3.6.1").Compare(MustParseOID("1.3.6.1.0")) != -1 {
+		t.Error("Compare() isn't numeric")
+	}
+}
+
+func Test_encodeInt(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40, -1 << 40} {
+		content, _, _ := expect(tagInteger, encodeInt(tagInteger, n))
+		if got, err := decodeInt(content); err != nil || got != n {
+			t.Errorf("decodeInt(encodeInt(%d)) = %d, %v", n, got, err)
+		}
+	}
+}
+
+// response decodes the type and the values of the response
+func response(t *testing.T, b []byte) (status int64, vars []Var) {
+	t.Helper()
+	msg, _, err := expect(tagSequence, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rest, _ := expect(tagInteger, msg)
+	_, rest, _ = expect(tagOctetString, rest)
+	pdu, _, err := expect(pduResponse, rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pdu, _ = expect(tagInteger, pdu)
+	content, pdu, _ := expect(tagInteger, pdu)
+	status, _ = decodeInt(content)
+	_, pdu, _ = expect(tagInteger, pdu)
+	list, _, _ := expect(tagSequence, pdu)
+	for len(list) > 0 {
+		var vb []byte
+		vb, list, _ = expect(tagSequence, list)
+		content, rest, _ := expect(tagOID, vb)
+		oid, _ := decodeOID(content)
+		tag, val, _, _ := readTLV(rest)
+		var v interface{} = exception(tag)
+		switch tag {
+		case tagInteger, tagGauge32, tagCounter64:
+			n, _ := decodeInt(append([]byte{0}, val...))
+			v = n
+		case tagOctetString:
+			v = string(val)
+		}
+		vars = append(vars, Var{oid, v})
+	}
+	return status, vars
+}
+
+func newRequest(version int64, community string, pdu byte, a, b int64, oids ...string) []byte {
+	var vbs [][]byte
+	for _, s := range oids {
+		vbs = append(vbs, tlv(tagSequence, encodeOID(MustParseOID(s)), tlv(tagNull)))
+	}
+	return tlv(tagSequence,
+		encodeInt(tagInteger, version),
+		tlv(tagOctetString, []byte(community)),
+		tlv(pdu, encodeInt(tagInteger, 42), encodeInt(tagInteger, a), encodeInt(tagInteger, b), tlv(tagSequence, vbs...)),
+	)
+}
+
+func TestAgent(t *testing.T) {
+	a := &Agent{Community: "secret", MIB: func() []Var {
+		return []Var{
+			{MustParseOID("1.3.6.1.4.1.1.2.0"), Counter64(1 << 40)},
+			{MustParseOID("1.3.6.1.4.1.1.1.0"), Gauge32(7)},
+			{MustParseOID("1.3.6.1.4.1.1.10.0"), "v1.0"},
+		}
+	}}
+
+	if resp, _ := a.Handle(newRequest(version2c, "public", pduGet, 0, 0, "1.3.6.1.4.1.1.1.0")); resp != nil {
+		t.Error("answered a wrong community")
+	}
+
+	resp, err := a.Handle(newRequest(version2c, "secret", pduGet, 0, 0, "1.3.6.1.4.1.1.1.0", "1.3.6.1.4.1.1.3.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, vars := response(t, resp); len(vars) != 2 || vars[0].Value != int64(7) || vars[1].Value != exception(tagNoSuchObject) {
+		t.Errorf("Get = %v", vars)
+	}
+
+	// walks in the order of the OIDs
+	resp, _ = a.Handle(newRequest(version2c, "secret", pduGetBulk, 0, 10, "1.3.6.1.4.1.1"))
+	_, vars := response(t, resp)
+	want := []string{"1.3.6.1.4.1.1.1.0", "1.3.6.1.4.1.1.2.0", "1.3.6.1.4.1.1.10.0", "1.3.6.1.4.1.1.10.0"}
+	if len(vars) != len(want) || vars[1].Value != int64(1<<40) || vars[2].Value != "v1.0" || vars[3].Value != exception(tagEndOfMibView) {
+		t.Fatalf("GetBulk = %v", vars)
+	}
+	for i, v := range vars {
+		if v.OID.String() != want[i] {
+			t.Errorf("GetBulk[%d] = %s, want %s", i, v.OID, want[i])
+		}
+	}
+
+	// v1 skips the Counter64
+	resp, _ = a.Handle(newRequest(version1, "secret", pduGetNext, 0, 0, "1.3.6.1.4.1.1.1.0"))
+	if _, vars := response(t, resp); len(vars) != 1 || vars[0].OID.String() != "1.3.6.1.4.1.1.10.0" {
+		t.Errorf("v1 GetNext = %v", vars)
+	}
+	resp, _ = a.Handle(newRequest(version1, "secret", pduGet, 0, 0, "1.3.6.1.4.1.1.2.0"))
+	if status, _ := response(t, resp); status != errNoSuchName {
+		t.Errorf("v1 Get of a Counter64 status = %d", status)
+	}
+	resp, _ = a.Handle(newRequest(version2c, "secret", pduSet, 0, 0, "1.3.6.1.4.1.1.1.0"))
+	if status, _ := response(t, resp); status != errNotWritable {
+		t.Errorf("Set status = %d", status)
+	}
+}
@@ -0,0 +1,139 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const helpText = `/list - list the torrents
+/start <hash> - start a torrent
+/stop <hash> - stop a torrent
+/delete <hash> - delete a torrent
+/help - this message
+Send a magnet link or an infohash to add it.
+<hash> may be the first characters of the infohash.`
+
+// handle runs a command and returns the reply
+func (b *Bot) handle(text string) string {
+	if strings.HasPrefix(text, "magnet:") {
+		return b.addMagnet(text)
+	}
+	if _, ok := engine.MagnetOfInfoHash(text); ok {
+		return b.addMagnet(text)
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return helpText
+	}
+	// commands in groups come as /cmd@botname
+	cmd := strings.SplitN(fields[0], "@", 2)[0]
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+
+	switch cmd {
+	case "/list":
+		return b.list()
+	case "/add":
+		return b.addMagnet(arg)
+	case "/start":
+		return b.withTorrent(arg, "started", b.engine.ManualStartTorrent)
+	case "/stop":
+		return b.withTorrent(arg, "stopped", b.engine.StopTorrent)
+	case "/delete":
+		return b.withTorrent(arg, "deleted", func(ih string) error {
+			if err := b.engine.DeleteTorrent(ih); err != nil {
+				return err
+			}
+			b.engine.RemoveCache(ih)
+			return nil
+		})
+	}
+	return helpText
+}
+
+func (b *Bot) addMagnet(m string) string {
+	if mm, ok := engine.MagnetOfInfoHash(m); ok {
+		m = mm
+	}
+	if !strings.HasPrefix(m, "magnet:") {
+		return "usage: /add <magnet|infohash>"
+	}
+	if err := b.engine.NewMagnet(m, ""); err != nil {
+		if errors.Is(err, engine.ErrMaxConnTasks) {
+			return "Added to the wait list"
+		}
+		var merged *engine.MergedError
+		if errors.As(err, &merged) {
+			return fmt.Sprintf("Already added, merged %d trackers", merged.Trackers)
+		}
+		if errors.Is(err, engine.ErrTaskExists) {
+			return "Already added"
+		}
+		return "Error: " + err.Error()
+	}
+	return "Magnet added"
+}
+
+func (b *Bot) list() string {
+	all := b.engine.Torrents()
+	ts := make([]*engine.Torrent, 0, len(all))
+	for _, t := range all {
+		ts = append(ts, t)
+	}
+	if len(ts) == 0 {
+		return "No torrents"
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].AddedAt.Before(ts[j].AddedAt)
+	})
+
+	var sb strings.Builder
+	for _, t := range ts {
+		t.Lock()
+		state := "stopped"
+		switch {
+		case t.IsQueueing:
+			state = "queueing"
+		case t.Started && t.Done:
+			state = "seeding"
+		case t.Started:
+			state = fmt.Sprintf("%.0fKB/s", t.DownloadRate/1024)
+		}
+		fmt.Fprintf(&sb, "%s %5.1f%% %s\n%s\n\n", t.InfoHash[:8], t.Percent, state, t.Name)
+		t.Unlock()
+	}
+	return sb.String()
+}
+
+// withTorrent runs fn on the torrent matching the infohash prefix
+func (b *Bot) withTorrent(prefix, done string, fn func(string) error) string {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 {
+		return "usage: <command> <hash>, hash needs at least 4 characters"
+	}
+
+	var matched []string
+	for ih := range b.engine.Torrents() {
+		if strings.HasPrefix(ih, prefix) {
+			matched = append(matched, ih)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return "No torrent matches " + prefix
+	case 1:
+		if err := fn(matched[0]); err != nil {
+			return "Error: " + err.Error()
+		}
+		return fmt.Sprintf("%s %s", matched[0][:8], done)
+	}
+	return fmt.Sprintf("%d torrents match %s, use a longer hash", len(matched), prefix)
+}
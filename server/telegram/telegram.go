@@ -0,0 +1,174 @@
+// Package telegram is an optional bot to control the engine and receive
+// notifications from Telegram, enabled by TelegramToken in the config.
+// Only the chats listed in TelegramChatIDs are served.
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const (
+	apiBase     = "https://api.telegram.org/bot"
+	pollTimeout = 50
+	// recheck interval while the bot is unconfigured
+	idleInterval = 30 * time.Second
+)
+
+var log *logging.Logger
+
+type update struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// Bot polls the Telegram Bot API for commands, the token and chat ids are
+// read from the engine config on each poll so they can change at runtime
+type Bot struct {
+	engine *engine.Engine
+	client *http.Client
+	offset int64
+}
+
+func New(e *engine.Engine) *Bot {
+	b := &Bot{
+		engine: e,
+		client: &http.Client{Timeout: (pollTimeout + 10) * time.Second},
+	}
+	e.AddEventListener(b.onEvent)
+	return b
+}
+
+func (b *Bot) config() (string, map[int64]bool) {
+	c := b.engine.Config()
+	chats := make(map[int64]bool)
+	for _, f := range strings.FieldsFunc(c.TelegramChatIDs, func(r rune) bool {
+		return r == ',' || r == '\n' || r == ' '
+	}) {
+		if id, err := strconv.ParseInt(f, 10, 64); err == nil {
+			chats[id] = true
+		} else {
+			log.Warn("invalid chat id", f)
+		}
+	}
+	return strings.TrimSpace(c.TelegramToken), chats
+}
+
+func (b *Bot) call(token, method string, params url.Values) (json.RawMessage, error) {
+	resp, err := b.client.PostForm(apiBase+token+"/"+method, params)
+	if err != nil {
+		// the error contains the url with the token
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var r apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	if !r.OK {
+		return nil, fmt.Errorf("%s: %s", method, r.Description)
+	}
+	return r.Result, nil
+}
+
+func (b *Bot) send(token string, chat int64, text string) {
+	_, err := b.call(token, "sendMessage", url.Values{
+		"chat_id":                  {strconv.FormatInt(chat, 10)},
+		"text":                     {text},
+		"disable_web_page_preview": {"true"},
+	})
+	if err != nil {
+		log.Warn("send", err)
+	}
+}
+
+// Run polls updates forever
+func (b *Bot) Run() {
+	for {
+		token, chats := b.config()
+		if token == "" || len(chats) == 0 {
+			time.Sleep(idleInterval)
+			continue
+		}
+
+		raw, err := b.call(token, "getUpdates", url.Values{
+			"offset":          {strconv.FormatInt(b.offset, 10)},
+			"timeout":         {strconv.Itoa(pollTimeout)},
+			"allowed_updates": {`["message"]`},
+		})
+		if err != nil {
+			log.Warn("getUpdates", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		var updates []update
+		if err := json.Unmarshal(raw, &updates); err != nil {
+			log.Warn("getUpdates", err)
+			continue
+		}
+
+		for _, u := range updates {
+			b.offset = u.UpdateID + 1
+			if u.Message == nil {
+				continue
+			}
+			chat := u.Message.Chat.ID
+			if !chats[chat] {
+				log.Warn("ignored message from chat", chat)
+				continue
+			}
+			b.send(token, chat, b.handle(strings.TrimSpace(u.Message.Text)))
+		}
+	}
+}
+
+func (b *Bot) onEvent(ev engine.Event) {
+	var text string
+	switch ev.Type {
+	case engine.EventCompleted:
+		text = fmt.Sprintf("✅ Completed: %s", ev.Name)
+	case engine.EventError:
+		text = fmt.Sprintf("❌ %s: %s", ev.Name, ev.Error)
+	case engine.EventAlert:
+		text = fmt.Sprintf("⚠️ %s: %s", ev.Name, ev.Message)
+	default:
+		return
+	}
+	token, chats := b.config()
+	if token == "" {
+		return
+	}
+	go func() {
+		for chat := range chats {
+			b.send(token, chat, text)
+		}
+	}()
+}
+
+func init() {
+	log = logging.New("telegram")
+}
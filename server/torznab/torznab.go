@@ -0,0 +1,233 @@
+// Package torznab queries Torznab endpoints (Jackett, Prowlarr) and
+// normalizes the results.
+// spec: https://torznab.github.io/spec-1.3-draft/torznab/Specification-v1.3.html
+package torznab
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// max size of a .torrent to download (512k)
+	maxTorrentSize = 512 * 1024
+	// max size of a search response
+	maxFeedSize = 4 << 20
+)
+
+// Result is a normalized search result
+type Result struct {
+	Title    string
+	Indexer  string
+	Size     int64
+	Seeders  int
+	Peers    int
+	InfoHash string
+	Magnet   string
+	// Link downloads the .torrent, may redirect to a magnet
+	Link    string
+	PubDate time.Time
+}
+
+type feed struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []item `xml:"item"`
+	} `xml:"channel"`
+}
+
+type item struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	Size      int64  `xml:"size"`
+	PubDate   string `xml:"pubDate"`
+	Indexer   string `xml:"jackettindexer"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+	} `xml:"enclosure"`
+	Attrs []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"`
+}
+
+type apiError struct {
+	Code        string `xml:"code,attr"`
+	Description string `xml:"description,attr"`
+}
+
+type Client struct {
+	HTTP *http.Client
+}
+
+func New() *Client {
+	return &Client{
+		HTTP: &http.Client{
+			Timeout: 30 * time.Second,
+			// magnet redirects are handled by Fetch
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Scheme == "magnet" {
+					return http.ErrUseLastResponse
+				}
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Search queries every endpoint concurrently, the results are sorted by
+// seeders. An error is returned only if all the endpoints failed
+func (c *Client) Search(endpoints []string, query string) ([]Result, error) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []Result
+		errs    []string
+	)
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep string) {
+			defer wg.Done()
+			rs, err := c.search(ep, query)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			results = append(results, rs...)
+		}(ep)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 && len(errs) == len(endpoints) {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Seeders > results[j].Seeders
+	})
+	return results, nil
+}
+
+func (c *Client) search(endpoint, query string) ([]Result, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("t", "search")
+	q.Set("q", query)
+	u.RawQuery = q.Encode()
+
+	resp, err := c.HTTP.Get(u.String())
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			// the url contains the apikey
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%s: %w", u.Host, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var aerr apiError
+	if xml.Unmarshal(body, &aerr) == nil && aerr.Code != "" {
+		return nil, fmt.Errorf("%s: error %s %s", u.Host, aerr.Code, aerr.Description)
+	}
+	var f feed
+	if err := xml.Unmarshal(body, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", u.Host, err)
+	}
+
+	results := make([]Result, 0, len(f.Channel.Items))
+	for _, i := range f.Channel.Items {
+		results = append(results, i.normalize(f.Channel.Title))
+	}
+	return results, nil
+}
+
+func (i *item) normalize(channel string) Result {
+	r := Result{
+		Title:   i.Title,
+		Indexer: i.Indexer,
+		Size:    i.Size,
+		Link:    i.Link,
+	}
+	if r.Indexer == "" {
+		r.Indexer = channel
+	}
+	if r.Size == 0 {
+		r.Size = i.Enclosure.Length
+	}
+	if r.Link == "" {
+		r.Link = i.Enclosure.URL
+	}
+	if t, err := time.Parse(time.RFC1123Z, i.PubDate); err == nil {
+		r.PubDate = t
+	}
+	for _, a := range i.Attrs {
+		switch a.Name {
+		case "seeders":
+			r.Seeders, _ = strconv.Atoi(a.Value)
+		case "peers":
+			r.Peers, _ = strconv.Atoi(a.Value)
+		case "size":
+			if r.Size == 0 {
+				r.Size, _ = strconv.ParseInt(a.Value, 10, 64)
+			}
+		case "infohash":
+			r.InfoHash = strings.ToLower(a.Value)
+		case "magneturl":
+			r.Magnet = a.Value
+		}
+	}
+	if strings.HasPrefix(r.Link, "magnet:") {
+		r.Magnet, r.Link = r.Link, ""
+	}
+	if r.Magnet == "" && r.InfoHash != "" {
+		r.Magnet = "magnet:?xt=urn:btih:" + r.InfoHash + "&dn=" + url.QueryEscape(r.Title)
+	}
+	return r
+}
+
+// Fetch downloads the link of a result, the indexer may redirect to a
+// magnet, in which case the magnet is returned instead of the torrent data
+func (c *Client) Fetch(link string) (string, []byte, error) {
+	if strings.HasPrefix(link, "magnet:") {
+		return link, nil, nil
+	}
+	resp, err := c.HTTP.Get(link)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if loc := resp.Header.Get("Location"); strings.HasPrefix(loc, "magnet:") {
+		return loc, nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("fetch torrent: %s", resp.Status)
+	}
+	if resp.ContentLength > maxTorrentSize {
+		return "", nil, errors.New("remote torrent too large")
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTorrentSize))
+	return "", data, err
+}
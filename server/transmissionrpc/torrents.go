@@ -0,0 +1,298 @@
+package transmissionrpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/boypt/simple-torrent/common"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+// torrent status of the spec
+const (
+	statusStopped      = 0
+	statusDownloadWait = 3
+	statusDownload     = 4
+	statusSeed         = 6
+)
+
+func (h *Handler) sessionGet() map[string]interface{} {
+	c := h.engine.Config()
+	return map[string]interface{}{
+		"version":                version,
+		"rpc-version":            rpcVersion,
+		"rpc-version-minimum":    rpcVersionMin,
+		"download-dir":           c.DownloadDirectory,
+		"peer-port":              c.IncomingPort,
+		"seedRatioLimit":         c.SeedRatio,
+		"seedRatioLimited":       c.SeedRatio > 0,
+		"download-queue-size":    c.MaxActiveDownloads,
+		"download-queue-enabled": c.MaxActiveDownloads > 0,
+		"seed-queue-size":        c.MaxActiveSeeds,
+		"seed-queue-enabled":     c.MaxActiveSeeds > 0,
+		"dht-enabled":            true,
+		"utp-enabled":            !c.DisableUTP,
+		"encryption":             encryption(c),
+		"alt-speed-enabled":      h.altRateActive(),
+	}
+}
+
+func (h *Handler) altRateActive() bool {
+	a := h.engine.AltRate()
+	a.Lock()
+	defer a.Unlock()
+	return a.Active
+}
+
+func encryption(c engine.Config) string {
+	switch {
+	case c.ObfsRequirePreferred && c.ObfsPreferred:
+		return "required"
+	case c.ObfsPreferred:
+		return "preferred"
+	}
+	return "tolerated"
+}
+
+func (h *Handler) sessionStats() map[string]interface{} {
+	var active, paused int
+	var dlRate, ulRate float32
+	ts := h.snapshot(nil)
+	for _, t := range ts {
+		if t.Started {
+			active++
+		} else {
+			paused++
+		}
+		dlRate += t.DownloadRate
+		ulRate += t.UploadRate
+	}
+	return map[string]interface{}{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       len(ts),
+		"downloadSpeed":      int64(dlRate),
+		"uploadSpeed":        int64(ulRate),
+	}
+}
+
+func status(t *engine.Torrent) int {
+	switch {
+	case t.IsQueueing:
+		return statusDownloadWait
+	case !t.Started:
+		return statusStopped
+	case t.Done:
+		return statusSeed
+	}
+	return statusDownload
+}
+
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// torrentFields maps a torrent into the fields of the spec
+func (h *Handler) torrentFields(t *engine.Torrent, downloadDir string) map[string]interface{} {
+	t.Lock()
+	defer t.Unlock()
+
+	h.idMu.Lock()
+	id := h.id(t.InfoHash)
+	h.idMu.Unlock()
+
+	if t.DownloadDir != "" {
+		downloadDir = t.DownloadDir
+	}
+	left := t.Size - t.Downloaded
+	eta := int64(-1)
+	if left <= 0 {
+		eta = 0
+	} else if t.DownloadRate > 0 {
+		eta = int64(float32(left) / t.DownloadRate)
+	}
+
+	var peers int
+	if t.Stats != nil {
+		peers = t.Stats.ActivePeers
+	}
+
+	files := make([]map[string]interface{}, 0, len(t.Files))
+	fileStats := make([]map[string]interface{}, 0, len(t.Files))
+	for _, f := range t.Files {
+		if f == nil {
+			continue
+		}
+		files = append(files, map[string]interface{}{
+			"name":           f.Path,
+			"length":         f.Size,
+			"bytesCompleted": f.Completed,
+		})
+		fileStats = append(fileStats, map[string]interface{}{
+			"bytesCompleted": f.Completed,
+			"wanted":         f.Started,
+			"priority":       0,
+		})
+	}
+
+	labels := []string{}
+	if t.Label != "" {
+		labels = append(labels, t.Label)
+	}
+
+	// 3 is local error in the spec
+	errCode, errString := 0, ""
+	if t.MetadataTimeout {
+		errCode, errString = 3, "metadata timeout"
+	}
+
+	return map[string]interface{}{
+		"id":             id,
+		"hashString":     t.InfoHash,
+		"name":           t.Name,
+		"magnetLink":     t.Magnet,
+		"status":         status(t),
+		"percentDone":    t.Percent / 100,
+		"totalSize":      t.Size,
+		"sizeWhenDone":   t.Size,
+		"leftUntilDone":  left,
+		"haveValid":      t.Downloaded,
+		"downloadedEver": t.Downloaded,
+		"uploadedEver":   t.Uploaded,
+		"uploadRatio":    t.SeedRatio,
+		"rateDownload":   int64(t.DownloadRate),
+		"rateUpload":     int64(t.UploadRate),
+		"eta":            eta,
+		"addedDate":      unixTime(t.AddedAt),
+		"startDate":      unixTime(t.StartedAt),
+		"doneDate":       unixTime(t.FinishedAt),
+		"isFinished":     t.Done && !t.Started,
+		"isStalled":      false,
+		"downloadDir":    downloadDir,
+		"error":          errCode,
+		"errorString":    errString,
+		"peersConnected": peers,
+		"queuePosition":  0,
+		"files":          files,
+		"fileStats":      fileStats,
+		"labels":         labels,
+	}
+}
+
+func (h *Handler) torrentGet(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		IDs    json.RawMessage `json:"ids"`
+		Fields []string        `json:"fields"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, err
+		}
+	}
+	hashes, err := h.resolveIDs(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	dldir := h.engine.Config().DownloadDirectory
+	torrents := []map[string]interface{}{}
+	for _, t := range h.snapshot(hashes) {
+		all := h.torrentFields(t, dldir)
+		if len(args.Fields) == 0 {
+			torrents = append(torrents, all)
+			continue
+		}
+		selected := make(map[string]interface{}, len(args.Fields))
+		for _, f := range args.Fields {
+			if v, ok := all[f]; ok {
+				selected[f] = v
+			}
+		}
+		torrents = append(torrents, selected)
+	}
+	return map[string]interface{}{"torrents": torrents}, nil
+}
+
+func (h *Handler) torrentAdd(r *http.Request, raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Filename    string `json:"filename"`
+		Metainfo    string `json:"metainfo"`
+		DownloadDir string `json:"download-dir"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	var ih, name string
+	var add func() error
+	if m, ok := engine.MagnetOfInfoHash(args.Filename); ok {
+		args.Filename = m
+	}
+	switch {
+	case strings.HasPrefix(args.Filename, "magnet:"):
+		m, err := metainfo.ParseMagnetUri(args.Filename)
+		if err != nil {
+			return nil, err
+		}
+		ih, name = m.InfoHash.HexString(), m.DisplayName
+		add = func() error {
+			if err := h.presetOwner(r, []byte(args.Filename)); err != nil {
+				return err
+			}
+			return h.engine.NewMagnet(args.Filename, args.DownloadDir)
+		}
+	default:
+		var data []byte
+		var err error
+		if args.Metainfo != "" {
+			data, err = base64.StdEncoding.DecodeString(args.Metainfo)
+		} else {
+			data, err = common.FetchTorrentFile(args.Filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+		mi, err := metainfo.Load(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		info, err := mi.UnmarshalInfo()
+		if err != nil {
+			return nil, err
+		}
+		ih, name = mi.HashInfoBytes().HexString(), info.Name
+		add = func() error {
+			if err := h.presetOwner(r, data); err != nil {
+				return err
+			}
+			return h.engine.NewTorrentByReader(bytes.NewReader(data), args.DownloadDir)
+		}
+	}
+
+	h.idMu.Lock()
+	id := h.id(ih)
+	h.idMu.Unlock()
+	added := map[string]interface{}{"id": id, "name": name, "hashString": ih}
+
+	if h.exists(ih) {
+		return map[string]interface{}{"torrent-duplicate": added}, nil
+	}
+	if err := add(); err != nil && !errors.Is(err, engine.ErrMaxConnTasks) {
+		return nil, err
+	}
+	return map[string]interface{}{"torrent-added": added}, nil
+}
+
+func (h *Handler) exists(ih string) bool {
+	_, ok := h.engine.Torrent(ih)
+	return ok
+}
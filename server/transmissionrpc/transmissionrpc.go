@@ -0,0 +1,315 @@
+// Package transmissionrpc implements a subset of the Transmission RPC spec
+// on top of the engine, so tools speaking Transmission (Sonarr, Radarr,
+// remotes) can drive simple-torrent.
+// spec: https://github.com/transmission/transmission/blob/main/docs/rpc-spec.md
+package transmissionrpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const (
+	sessionHeader = "X-Transmission-Session-Id"
+	rpcVersion    = 15
+	rpcVersionMin = 1
+	version       = "3.00 (simple-torrent)"
+	// the torrents active within it are "recently-active", as Transmission
+	recentlyActiveWindow = time.Minute
+)
+
+var (
+	log *logging.Logger
+
+	errUnknownMethod = errors.New("method name not recognized")
+	errRemoveIDs     = errors.New(`torrent-remove needs the ids, or "all"`)
+)
+
+type request struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       interface{}     `json:"tag,omitempty"`
+}
+
+type response struct {
+	Result    string      `json:"result"`
+	Arguments interface{} `json:"arguments"`
+	Tag       interface{} `json:"tag,omitempty"`
+}
+
+// Handler serves /transmission/rpc
+type Handler struct {
+	engine    *engine.Engine
+	sessionID string
+	// checks the user of the request may change the task
+	checkOwner func(r *http.Request, infohash string) error
+	// tells the engine the user of the request adding the magnet or torrent
+	presetOwner func(r *http.Request, data []byte) error
+
+	// transmission refers torrents by integer ids
+	idMu   sync.Mutex
+	ids    map[string]int
+	hashes map[int]string
+	nextID int
+}
+
+func New(e *engine.Engine, checkOwner func(r *http.Request, infohash string) error,
+	presetOwner func(r *http.Request, data []byte) error) *Handler {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return &Handler{
+		engine:      e,
+		sessionID:   hex.EncodeToString(b),
+		checkOwner:  checkOwner,
+		presetOwner: presetOwner,
+		ids:         make(map[string]int),
+		hashes:      make(map[int]string),
+		nextID:      1,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// CSRF protection of the spec
+	if r.Header.Get(sessionHeader) != h.sessionID {
+		w.Header().Set(sessionHeader, h.sessionID)
+		http.Error(w, fmt.Sprintf("409: Conflict\n%s: %s", sessionHeader, h.sessionID), http.StatusConflict)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := response{Result: "success", Tag: req.Tag}
+	args, err := h.call(r, req.Method, req.Arguments)
+	if err != nil {
+		log.Warnf("%s error: %v", req.Method, err)
+		resp.Result = err.Error()
+		args = struct{}{}
+	}
+	if args == nil {
+		args = struct{}{}
+	}
+	resp.Arguments = args
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Warn("encode response", err)
+	}
+}
+
+func (h *Handler) call(r *http.Request, method string, args json.RawMessage) (interface{}, error) {
+	switch method {
+	case "session-get":
+		return h.sessionGet(), nil
+	case "session-set":
+		// settings are managed by the simple-torrent config
+		return nil, nil
+	case "session-stats":
+		return h.sessionStats(), nil
+	case "torrent-get":
+		return h.torrentGet(args)
+	case "torrent-add":
+		return h.torrentAdd(r, args)
+	case "torrent-start", "torrent-start-now":
+		return nil, h.forEach(r, args, h.engine.ManualStartTorrent)
+	case "torrent-stop":
+		return nil, h.forEach(r, args, h.engine.StopTorrent)
+	case "torrent-verify":
+		return nil, h.forEach(r, args, h.engine.VerifyTorrent)
+	case "torrent-reannounce":
+		return nil, h.forEach(r, args, h.engine.ReannounceTorrent)
+	case "torrent-remove":
+		return nil, h.torrentRemove(r, args)
+	case "torrent-set":
+		// accepted but ignored, avoids breaking clients
+		return nil, nil
+	}
+	return nil, errUnknownMethod
+}
+
+// id returns the integer id of a hash, must hold idMu
+func (h *Handler) id(hash string) int {
+	if id, ok := h.ids[hash]; ok {
+		return id
+	}
+	id := h.nextID
+	h.nextID++
+	h.ids[hash] = id
+	h.hashes[id] = hash
+	return id
+}
+
+// resolveIDs parses the "ids" argument into infohashes, nil means all torrents
+func (h *Handler) resolveIDs(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var list []interface{}
+	var single interface{}
+	if err := json.Unmarshal(raw, &single); err != nil {
+		return nil, err
+	}
+	switch v := single.(type) {
+	case []interface{}:
+		list = v
+	case string:
+		if v == "recently-active" {
+			return h.recentlyActive(time.Now()), nil
+		}
+		list = []interface{}{v}
+	default:
+		list = []interface{}{v}
+	}
+
+	h.idMu.Lock()
+	defer h.idMu.Unlock()
+	hashes := []string{}
+	for _, i := range list {
+		switch v := i.(type) {
+		case float64:
+			if hash, ok := h.hashes[int(v)]; ok {
+				hashes = append(hashes, hash)
+			}
+		case string:
+			hashes = append(hashes, v)
+		}
+	}
+	return hashes, nil
+}
+
+// recentlyActive returns the hashes of the torrents transferring, or added,
+// started, finished or stopped within recentlyActiveWindow, never nil
+func (h *Handler) recentlyActive(now time.Time) []string {
+	hashes := []string{}
+	for hash, t := range h.engine.Torrents() {
+		t.Lock()
+		active := t.DownloadRate > 0 || t.UploadRate > 0
+		for _, at := range []time.Time{t.AddedAt, t.StartedAt, t.FinishedAt, t.StoppedAt} {
+			if now.Sub(at) < recentlyActiveWindow {
+				active = true
+			}
+		}
+		t.Unlock()
+		if active {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// snapshot returns the torrents selected by hashes, or all if nil
+func (h *Handler) snapshot(hashes []string) []*engine.Torrent {
+	ts := h.engine.Torrents()
+	var selected []*engine.Torrent
+	if hashes == nil {
+		for _, t := range ts {
+			selected = append(selected, t)
+		}
+	} else {
+		for _, hash := range hashes {
+			if t, ok := ts[hash]; ok {
+				selected = append(selected, t)
+			}
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].AddedAt.Before(selected[j].AddedAt)
+	})
+	return selected
+}
+
+// forEach applies fn to the torrents of the ids, the ones of the other
+// users are skipped
+func (h *Handler) forEach(r *http.Request, raw json.RawMessage, fn func(string) error) error {
+	var args struct {
+		IDs json.RawMessage `json:"ids"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return err
+		}
+	}
+	hashes, err := h.resolveIDs(args.IDs)
+	if err != nil {
+		return err
+	}
+	for _, t := range h.snapshot(hashes) {
+		if err := h.checkOwner(r, t.InfoHash); err != nil {
+			log.Warn(t.InfoHash, err)
+			continue
+		}
+		if err := fn(t.InfoHash); err != nil {
+			log.Warn(t.InfoHash, err)
+		}
+	}
+	return nil
+}
+
+// torrentRemove removes the torrents of the ids, none if one is of another user
+func (h *Handler) torrentRemove(r *http.Request, raw json.RawMessage) error {
+	var args struct {
+		IDs             json.RawMessage `json:"ids"`
+		DeleteLocalData bool            `json:"delete-local-data"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	// removing all takes "all", not the missing ids of the other methods
+	var hashes []string
+	if all := strings.TrimSpace(string(args.IDs)); all == "" || all == "null" {
+		return errRemoveIDs
+	} else if all != `"all"` {
+		var err error
+		if hashes, err = h.resolveIDs(args.IDs); err != nil {
+			return err
+		}
+	}
+	selected := h.snapshot(hashes)
+	for _, t := range selected {
+		if err := h.checkOwner(r, t.InfoHash); err != nil {
+			return err
+		}
+	}
+	for _, t := range selected {
+		ih := t.InfoHash
+		dataPath := h.engine.TorrentDataPath(ih)
+		if err := h.engine.DeleteTorrent(ih); err != nil {
+			return err
+		}
+		h.engine.RemoveCache(ih)
+		if args.DeleteLocalData && dataPath != "" {
+			log.Println("removing data", dataPath)
+			if err := os.RemoveAll(dataPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	log = logging.New("transmissionrpc")
+}
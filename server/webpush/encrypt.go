@@ -0,0 +1,154 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+const (
+	recordSize = 4096
+	jwtExpire  = 12 * time.Hour
+)
+
+var (
+	b64 = base64.RawURLEncoding
+
+	errInvalidKeys = errors.New("invalid subscription keys")
+)
+
+// decodeKey accepts both padded and unpadded base64url from browsers
+func decodeKey(s string) ([]byte, error) {
+	for len(s)%4 != 0 {
+		s += "="
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+// hkdf is HKDF-SHA256 with output no longer than a block, which is all RFC 8291 needs
+func hkdf(salt, ikm, info []byte, n int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:n]
+}
+
+// encrypt encodes the payload with aes128gcm for the subscription (RFC 8291)
+func encrypt(sub *Subscription, payload []byte) ([]byte, error) {
+	uaPublic, err := decodeKey(sub.Keys.P256dh)
+	if err != nil {
+		return nil, errInvalidKeys
+	}
+	authSecret, err := decodeKey(sub.Keys.Auth)
+	if err != nil || len(authSecret) == 0 {
+		return nil, errInvalidKeys
+	}
+	// ephemeral key of the application server
+	as, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encryptWith(as, salt, uaPublic, authSecret, payload)
+}
+
+// encryptWith is encrypt with the given ephemeral key and salt
+func encryptWith(as *ecdsa.PrivateKey, salt, uaPublic, authSecret, payload []byte) ([]byte, error) {
+	curve := as.Curve
+	ux, uy := elliptic.Unmarshal(curve, uaPublic)
+	if ux == nil {
+		return nil, errInvalidKeys
+	}
+	asPublic := elliptic.Marshal(curve, as.X, as.Y)
+	sx, _ := curve.ScalarMult(ux, uy, as.D.Bytes())
+	ecdhSecret := make([]byte, 32)
+	sx.FillBytes(ecdhSecret)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// single record, delimited by 0x02
+	plain := append(append([]byte{}, payload...), 2)
+	if len(plain)+gcm.Overhead() > recordSize {
+		return nil, errors.New("payload too large")
+	}
+
+	header := make([]byte, 16+4+1, 16+4+1+len(asPublic))
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[16:], recordSize)
+	header[20] = byte(len(asPublic))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, plain, nil), nil
+}
+
+// vapidAuth returns the Authorization header for the push service of endpoint (RFC 8292)
+func vapidAuth(endpoint, subject string, key *ecdsa.PrivateKey) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(jwtExpire).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." + b64.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, b64.EncodeToString(sig), publicKey(key)), nil
+}
+
+func publicKey(key *ecdsa.PrivateKey) string {
+	return b64.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y))
+}
+
+func privateKeyFrom(d string) (*ecdsa.PrivateKey, error) {
+	raw, err := decodeKey(d)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(raw)}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(raw)
+	return key, nil
+}
@@ -0,0 +1,280 @@
+// Package webpush sends the task notifications to the browsers subscribed
+// with the Push API, so they're received with the page closed. The VAPID
+// keys and the subscriptions are kept in a file managed by the server.
+package webpush
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/boypt/simple-torrent/common/logging"
+	"github.com/boypt/simple-torrent/engine"
+)
+
+const (
+	subject = "https://github.com/boypt/simple-torrent"
+	// pushes not delivered within a day are dropped by the push service
+	ttl = 86400
+)
+
+var (
+	log *logging.Logger
+
+	ErrInvalidSubscription = errors.New("invalid push subscription")
+)
+
+// Subscription is the PushSubscription JSON of the browser
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+type store struct {
+	PrivateKey    string          `json:"privateKey"`
+	Subscriptions []*Subscription `json:"subscriptions"`
+}
+
+// Service keeps the subscriptions and pushes the engine events to them
+type Service struct {
+	path   string
+	client *http.Client
+
+	mu   sync.Mutex
+	key  *ecdsa.PrivateKey
+	subs []*Subscription
+}
+
+// New loads the keys and subscriptions from path, the keys are created
+// on the first run
+func New(path string) (*Service, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: dialPublic}
+	s := &Service{
+		path: path,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+	}
+
+	var st store
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &st)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if st.PrivateKey != "" {
+		if s.key, err = privateKeyFrom(st.PrivateKey); err != nil {
+			return nil, err
+		}
+		s.subs = st.Subscriptions
+		return s, nil
+	}
+
+	if s.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+	log.Println("generated VAPID keys")
+	return s, s.save()
+}
+
+// save must hold lock
+func (s *Service) save() error {
+	data, err := json.Marshal(store{
+		PrivateKey:    b64.EncodeToString(s.key.D.FillBytes(make([]byte, 32))),
+		Subscriptions: s.subs,
+	})
+	if err != nil {
+		return err
+	}
+	// the private key is kept here
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+// PublicKey is the applicationServerKey for PushManager.subscribe
+func (s *Service) PublicKey() string {
+	return publicKey(s.key)
+}
+
+// Subscribe adds or updates the subscription of a browser
+func (s *Service) Subscribe(data []byte) error {
+	sub := &Subscription{}
+	if err := json.Unmarshal(data, sub); err != nil {
+		return ErrInvalidSubscription
+	}
+	if checkEndpoint(sub.Endpoint) != nil || sub.Keys.P256dh == "" || sub.Keys.Auth == "" {
+		return ErrInvalidSubscription
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(sub.Endpoint)
+	s.subs = append(s.subs, sub)
+	log.Println("subscribed", sub.Endpoint)
+	return s.save()
+}
+
+// checkEndpoint allows the https endpoints of the public hosts, the push
+// services are on the internet
+func checkEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	if u.Scheme != "https" || host == "" {
+		return ErrInvalidSubscription
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrInvalidSubscription
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return ErrInvalidSubscription
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsUnspecified()
+}
+
+// dialPublic refuses the addresses checkEndpoint does, for the host names
+// resolving to them
+func dialPublic(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("push endpoint address %s not allowed", host)
+	}
+	return nil
+}
+
+// Unsubscribe removes the subscription of the endpoint
+func (s *Service) Unsubscribe(endpoint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.remove(endpoint) {
+		return nil
+	}
+	log.Println("unsubscribed", endpoint)
+	return s.save()
+}
+
+// remove must hold lock
+func (s *Service) remove(endpoint string) bool {
+	for i, sub := range s.subs {
+		if sub.Endpoint == endpoint {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// OnEvent is the engine event listener, it doesn't block
+func (s *Service) OnEvent(ev engine.Event) {
+	var title string
+	switch ev.Type {
+	case engine.EventCompleted:
+		title = "Download completed"
+	case engine.EventError:
+		title = "Task error"
+	case engine.EventAlert:
+		title = "Task alert"
+	default:
+		return
+	}
+	body := ev.Name
+	if ev.Error != "" {
+		body = fmt.Sprintf("%s: %s", ev.Name, ev.Error)
+	} else if ev.Message != "" {
+		body = fmt.Sprintf("%s: %s", ev.Name, ev.Message)
+	}
+	go s.Notify(title, body, ev.InfoHash)
+}
+
+// Notify pushes a notification to all the subscriptions, the expired ones are removed
+func (s *Service) Notify(title, body, tag string) {
+	payload, err := json.Marshal(map[string]string{"title": title, "body": body, "tag": tag})
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+
+	s.mu.Lock()
+	subs := append([]*Subscription{}, s.subs...)
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		gone, err := s.push(sub, payload)
+		if err != nil {
+			log.Warnf("push to %s: %v", sub.Endpoint, err)
+		}
+		if gone {
+			if err := s.Unsubscribe(sub.Endpoint); err != nil {
+				log.Warn(err)
+			}
+		}
+	}
+}
+
+// push sends the payload, gone is true if the subscription is expired
+func (s *Service) push(sub *Subscription, payload []byte) (gone bool, err error) {
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return errors.Is(err, errInvalidKeys), err
+	}
+	auth, err := vapidAuth(sub.Endpoint, subject, s.key)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprint(ttl))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return true, fmt.Errorf("subscription expired: %s", resp.Status)
+	case resp.StatusCode >= 300:
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("%s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return false, nil
+}
+
+func init() {
+	log = logging.New("webpush")
+}
@@ -0,0 +1,176 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// the example of RFC 8291 appendix A
+const (
+	rfcPlaintext  = "When I grow up, I want to be a watermelon"
+	rfcASPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcUAPrivate  = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfcUAPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfcAuthSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcSalt       = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfcMessage    = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	b, err := decodeKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// decrypt is the user agent side of RFC 8291
+func decrypt(t *testing.T, uaPrivate *ecdsa.PrivateKey, authSecret, msg []byte) []byte {
+	salt, idlen := msg[:16], int(msg[20])
+	asPublic, ciphertext := msg[21:21+idlen], msg[21+idlen:]
+	curve := elliptic.P256()
+	ax, ay := elliptic.Unmarshal(curve, asPublic)
+	sx, _ := curve.ScalarMult(ax, ay, uaPrivate.D.Bytes())
+	ecdhSecret := sx.FillBytes(make([]byte, 32))
+
+	uaPublic := elliptic.Marshal(curve, uaPrivate.X, uaPrivate.Y)
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) == 0 || plain[len(plain)-1] != 2 {
+		t.Fatalf("record delimiter missing: %x", plain)
+	}
+	return plain[:len(plain)-1]
+}
+
+func TestEncryptRFC8291(t *testing.T) {
+	as, err := privateKeyFrom(rfcASPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := encryptWith(as, mustDecode(t, rfcSalt), mustDecode(t, rfcUAPublic), mustDecode(t, rfcAuthSecret), []byte(rfcPlaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b64.EncodeToString(msg); got != rfcMessage {
+		t.Errorf("message\n%s\nwant\n%s", got, rfcMessage)
+	}
+
+	ua, err := privateKeyFrom(rfcUAPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decrypt(t, ua, mustDecode(t, rfcAuthSecret), msg); string(got) != rfcPlaintext {
+		t.Errorf("decrypted %q", got)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	ua, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	sub := &Subscription{Endpoint: "https://push.example.com/x"}
+	sub.Keys.P256dh = b64.EncodeToString(elliptic.Marshal(ua.Curve, ua.X, ua.Y))
+	sub.Keys.Auth = b64.EncodeToString(auth)
+	payload := []byte(`{"title":"Download completed"}`)
+	msg, err := encrypt(sub, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decrypt(t, ua, auth, msg); string(got) != string(payload) {
+		t.Errorf("decrypted %q", got)
+	}
+}
+
+func TestVapidAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := vapidAuth("https://push.example.com/send/abc", subject, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token, k string
+	for _, p := range strings.Split(strings.TrimPrefix(auth, "vapid "), ", ") {
+		switch {
+		case strings.HasPrefix(p, "t="):
+			token = p[2:]
+		case strings.HasPrefix(p, "k="):
+			k = p[2:]
+		}
+	}
+	if k != publicKey(key) {
+		t.Fatalf("k = %s", k)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %s", token)
+	}
+
+	// verified by the public key of k
+	pub := mustDecode(t, k)
+	x, y := elliptic.Unmarshal(elliptic.P256(), pub)
+	sig := mustDecode(t, parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if len(sig) != 64 || !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], r, s) {
+		t.Error("signature not verified")
+	}
+
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(mustDecode(t, parts[1]), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Aud != "https://push.example.com" || claims.Sub != subject || claims.Exp == 0 {
+		t.Errorf("claims %+v", claims)
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	for endpoint, ok := range map[string]bool{
+		"https://fcm.googleapis.com/fcm/send/abc":   true,
+		"https://updates.push.services.mozilla.com": true,
+		"http://fcm.googleapis.com/fcm/send/abc":    false,
+		"https://localhost/x":                       false,
+		"https://127.0.0.1/x":                       false,
+		"https://10.0.0.1/x":                        false,
+		"https://192.168.1.1:8443/x":                false,
+		"https://169.254.169.254/latest":            false,
+		"https://[::1]/x":                           false,
+		"https://[fe80::1]/x":                       false,
+		"https:///x":                                false,
+	} {
+		if err := checkEndpoint(endpoint); (err == nil) != ok {
+			t.Errorf("checkEndpoint(%s) = %v", endpoint, err)
+		}
+	}
+}
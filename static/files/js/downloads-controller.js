@@ -10,26 +10,33 @@ app.controller("DownloadsController", function ($scope, $rootScope, apiget) {
     }
   });
 
+  var reload = function () {
+    $scope.$isLoadingFiles = true;
+    apiget.files().then(function (xhr) {
+      if (xhr.data.Children) {
+        $scope.$DownloadedFiles = xhr.data.Children;
+      } else {
+        $scope.$DownloadedFiles = [];
+      }
+    }).finally(function () {
+      $scope.$isLoadingFiles = false;
+      $scope.$applyAsync();
+    });
+  };
+
   $scope.$expanded = false;
   $scope.section_expanded_toggle = function () {
     $scope.$expanded = !$scope.$expanded;
     if ($scope.$expanded) {
-      $scope.$isLoadingFiles = true;
-      apiget.files().then(function (xhr) {
-        if (xhr.data.Children) {
-          $scope.$DownloadedFiles = xhr.data.Children;
-        } else {
-          $scope.$DownloadedFiles = [];
-        }
-      }).finally(function () {
-        $scope.$isLoadingFiles = false;
-        $scope.$applyAsync();
-      });
+      reload();
     }
   };
+
+  // renamed or moved in the tree
+  $scope.$on("filesChanged", reload);
 });
 
-app.controller("NodeController", function ($scope, $rootScope, $http, $timeout, reqerr) {
+app.controller("NodeController", function ($scope, $rootScope, $http, $timeout, api, reqerr) {
   var n = $scope.node;
   $scope.isfile = function () {
     return !n.Children;
@@ -106,6 +113,27 @@ app.controller("NodeController", function ($scope, $rootScope, $http, $timeout,
       });
   };
 
+  var fileop = function (action, to) {
+    var req = {Action: action, Path: n.$path, To: to};
+    api.fileop(JSON.stringify(req)).then(function () {
+      $scope.$emit("filesChanged");
+    }, reqerr);
+  };
+
+  $scope.rename = function () {
+    var name = window.prompt("Rename " + n.$path + " to", n.Name);
+    if (name && name.trim() !== n.Name) {
+      fileop("rename", name.trim());
+    }
+  };
+
+  $scope.move = function () {
+    var dir = window.prompt("Move " + n.$path + " into the folder, empty for the download directory", "");
+    if (dir !== null) {
+      fileop("move", dir.trim());
+    }
+  };
+
   $scope.togglePreview = function () {
     $scope.showPreview = !$scope.showPreview;
   };
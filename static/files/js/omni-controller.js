@@ -26,7 +26,7 @@ app.controller("OmniController", function (
   });
   apiget.searchproviders().then(function (xhr) {
     angular.forEach(xhr.data, function (val, k) {
-      if (/\/item$/.test(k)) return;
+      if (!val.enabled) return;
       $scope.providers[k] = val;
     });
     $scope.SearchProvidersConfig = xhr.data;
@@ -100,6 +100,8 @@ app.controller("OmniController", function (
 
     if (/^https?:\/\//.test($scope.inputs.omni)) parseTorrent();
     else if (/^magnet:\?(.+)$/.test($scope.inputs.omni)) parseMagnet(RegExp.$1);
+    else if (/^\s*([0-9a-fA-F]{40}|[A-Za-z2-7]{32})\s*$/.test($scope.inputs.omni))
+      parseMagnet("xt=urn:btih:" + RegExp.$1);
     else if ($scope.inputs.omni) parseSearch();
     else $scope.edit = false;
   };
@@ -174,18 +176,17 @@ app.controller("OmniController", function (
     $rootScope.set_torrent_expanded(true);
   };
 
+  $scope.fetchMetadata = function () {
+    window.open("api/metadata?m=" + encodeURIComponent($scope.inputs.omni));
+  };
+
   $scope.submitSearch = function () {
     //lookup provider's origin
     var provider = $scope.SearchProvidersConfig[$scope.inputs.provider];
     if (!provider) return;
     var origin = /(https?:\/\/[^\/]+)/.test(provider.url) && RegExp.$1;
-    var page = $scope.page;
-    if (/{{page:0}}/.test(provider.url)) {
-      page -= 1;
-    }
-
     search
-      .all($scope.inputs.provider, $scope.inputs.omni, page)
+      .all($scope.inputs.provider, $scope.inputs.omni, $scope.page)
       .then(function (xhr) {
         var results = xhr.data;
         if (!results || results.length === 0) {
@@ -104,6 +104,25 @@ app.run(function ($rootScope, $window, $location, $log, search, api, apiget, sto
     var files = filter.call(fileContainer.files, function (file) {
       return file.name.endsWith(".torrent");
     });
+    // zip archives of .torrent files are added by the server
+    var zips = filter.call(fileContainer.files, function (file) {
+      return file.name.endsWith(".zip");
+    });
+    zips.forEach(function (file) {
+      api.torrentzip(file).then(function (xhr) {
+        if (!xhr) {
+          return;
+        }
+        var failed = xhr.data.filter(function (r) { return r.status === "error"; });
+        $rootScope.info = `${file.name}: ${xhr.data.length - failed.length} of ${xhr.data.length} torrents added`;
+        if (failed.length > 0) {
+          $rootScope.alertErr(failed.map(function (r) { return `${r.file}: ${r.error}`; }).join("; "));
+        }
+      });
+    });
+    if (files.length === 0 && zips.length > 0) {
+      return;
+    }
     if (files.length === 0) {
       return $rootScope.alertErr("No torrent files to upload");
     }
@@ -167,6 +186,32 @@ app.run(function ($rootScope, $window, $location, $log, search, api, apiget, sto
     $rootScope.$applyAsync();
   }
 
+  // web push notifications of completed tasks, works with the page closed
+  // only available when visited as a https site
+  $scope.pushSupported = ('serviceWorker' in $window.navigator) && ('PushManager' in $window);
+  $scope.enablePush = function () {
+    var b64ToBytes = function (s) {
+      var raw = $window.atob((s + "===".slice((s.length + 3) % 4)).replace(/-/g, "+").replace(/_/g, "/"));
+      return Uint8Array.from(raw, function (c) { return c.charCodeAt(0); });
+    };
+    Promise.all([
+      $window.navigator.serviceWorker.register("sw.js"),
+      apiget.pushkey()
+    ]).then(function (res) {
+      return res[0].pushManager.subscribe({
+        userVisibleOnly: true,
+        applicationServerKey: b64ToBytes(res[1].data.publicKey)
+      });
+    }).then(function (sub) {
+      return api.pushsubscribe(JSON.stringify(sub));
+    }).then(function () {
+      $rootScope.info = "Notifications enabled";
+      $rootScope.$applyAsync();
+    }).catch(function (err) {
+      $rootScope.alertErr("Notifications: " + err);
+    });
+  };
+
   //page-wide keybinding, listen for space,
   //toggle pause/play the video on-screen
   document.addEventListener("keydown", function (e) {
@@ -1,4 +1,4 @@
-/* globals app */
+/* globals app,window,angular */
 
 app.controller("TorrentsController", function ($scope, $rootScope, api, reqinfo, reqerr) {
   $rootScope.torrents = $scope;
@@ -11,10 +11,149 @@ app.controller("TorrentsController", function ($scope, $rootScope, api, reqinfo,
     });
   };
 
+  $scope.filePriorities = ["skip", "low", "normal", "high"];
+
   $scope.submitFile = function (action, t, f) {
     api.file([action, t.InfoHash, f.Path].join(":")).then(reqinfo, reqerr);
   };
 
+  $scope.setLabel = function (t) {
+    var label = window.prompt("Label of " + t.Name + " (empty to clear)", t.Label || "");
+    if (label === null) {
+      return;
+    }
+    api.label([t.InfoHash, label.trim()].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.setCollection = function (t) {
+    var name = window.prompt("Collection of " + t.Name + ", eg: a series (empty to take it out)", t.Collection || "");
+    if (name === null) {
+      return;
+    }
+    api.collection([t.InfoHash, name.trim()].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.submitCollection = function (action) {
+    if (action === "delete" && !window.confirm("Remove all the tasks of " + $scope.collectionFilter + "?")) {
+      return;
+    }
+    api.collections([action, $scope.collectionFilter].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.setSeedLimits = function (t) {
+    var l = t.SeedLimits || {};
+    var cur = [l.Ratio || "", l.SeedTime ? (l.SeedTime < 0 ? -1 : l.SeedTime / 6e10 + "m") : "",
+      l.IdleTime ? (l.IdleTime < 0 ? -1 : l.IdleTime / 6e10 + "m") : ""].join(":");
+    var limits = window.prompt("Ratio:Seed time:Idle time, eg: 2:48h:6h. Empty follows the config, -1 for no limit", cur);
+    if (limits === null) {
+      return;
+    }
+    api.seedlimits([t.InfoHash, limits.trim()].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.setSchedule = function (t) {
+    var cur = (t.DownloadSchedule || "").split("\n").join("; ");
+    var sched = window.prompt("Download only at, eg: * 02:00-07:00; Sat,Sun 00:00-23:59. Empty for anytime", cur);
+    if (sched === null) {
+      return;
+    }
+    api.schedule([t.InfoHash, sched.trim()].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.toggleEncryption = function (t) {
+    var policy = t.Encryption === "require" ? "" : "require";
+    api.encryption([t.InfoHash, policy].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.toggleSuperSeed = function (t) {
+    api.superseed([t.InfoHash, !t.SuperSeed].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.renameTask = function (t) {
+    var name = window.prompt("Rename the task and its data to", t.Name);
+    if (name === null || name.trim() === "" || name.trim() === t.Name) {
+      return;
+    }
+    api.rename([t.InfoHash, name.trim()].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.moveTask = function (t) {
+    var dir = window.prompt("Move the data to the directory, absolute or relative to the downloads. Empty for the downloads", t.DownloadDir || "");
+    if (dir === null) {
+      return;
+    }
+    api.location([t.InfoHash, dir.trim()].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.tempLimited = function () {
+    var l = $rootScope.state.TempRateLimit;
+    return l && l.Until && !l.Until.startsWith("0001");
+  };
+  $scope.setTempLimit = function () {
+    var limit = window.prompt("Upload rate:Download rate:Until (HH:MM or a duration like 1h), eg: 50k:1MB:18:30", "Low:Low:1h");
+    if (!limit) {
+      return;
+    }
+    api.templimit(limit.trim()).then(reqinfo, reqerr);
+  };
+  $scope.cancelTempLimit = function () {
+    api.templimit("cancel").then(reqinfo, reqerr);
+  };
+  $scope.toggleAltRate = function () {
+    // on/off override the schedule, then back to it
+    var a = $rootScope.state.AltRate || {};
+    var mode = "auto";
+    if (!a.Mode || a.Mode === "auto") {
+      mode = a.Active ? "off" : "on";
+    }
+    api.altrate(mode).then(reqinfo, reqerr);
+  };
+
+  $scope.queue = function (action, t) {
+    api.queue([action, t.InfoHash].join(":")).then(reqinfo, reqerr);
+  };
+  $scope.queuePriority = function (t) {
+    var p = window.prompt("Queue priority, higher ones are added first", t.QueuePriority || 0);
+    if (p === null || !/^\s*-?\d+\s*$/.test(p)) {
+      return;
+    }
+    api.queue(["priority", t.InfoHash, p.trim()].join(":")).then(reqinfo, reqerr);
+  };
+
+  $scope.labelFilter = "";
+  $scope.setLabelFilter = function (label) {
+    $scope.labelFilter = label;
+  };
+  $scope.labelMatch = function (t) {
+    return (!$scope.labelFilter || t.Label === $scope.labelFilter) &&
+      (!$scope.collectionFilter || t.Collection === $scope.collectionFilter);
+  };
+
+  $scope.collectionFilter = "";
+  $scope.setCollectionFilter = function (name) {
+    $scope.collectionFilter = name;
+  };
+  // the totals of the filtered collection
+  $scope.collectionStats = function () {
+    var c = { Tasks: 0, Size: 0, Downloaded: 0, Uploaded: 0, Percent: 0, Ratio: 0 };
+    angular.forEach($rootScope.state.Torrents, function (t) {
+      if (t.Collection !== $scope.collectionFilter) {
+        return;
+      }
+      c.Tasks++;
+      c.Size += t.Size;
+      c.Downloaded += t.Downloaded;
+      c.Uploaded += t.Uploaded;
+    });
+    if (c.Size > 0) {
+      c.Percent = 100 * c.Downloaded / c.Size;
+    }
+    if (c.Downloaded > 0) {
+      c.Ratio = c.Uploaded / c.Downloaded;
+    }
+    return c;
+  };
+
   $scope.downloading = function (f) {
     return f.Completed > 0 && f.Completed < f.Size;
   };
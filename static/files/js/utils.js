@@ -56,11 +56,28 @@ app.factory("api", function ($rootScope, $http, reqerr) {
   var api = {};
   var actions = [
     "configure",
+    "configrollback",
     "magnet",
     "url",
     "torrent",
     "file",
-    "torrentfile"
+    "torrentfile",
+    "torrentzip",
+    "label",
+    "collection",
+    "collections",
+    "templimit",
+    "altrate",
+    "queue",
+    "seedlimits",
+    "schedule",
+    "encryption",
+    "superseed",
+    "rename",
+    "location",
+    "fileop",
+    "deadline",
+    "pushsubscribe"
   ];
   actions.forEach(function (action) {
     api[action] = request.bind(null, action);
@@ -87,9 +104,11 @@ app.factory("apiget", function ($rootScope, $http, reqerr) {
   var api = {};
   var actions = [
     "configure",
+    "configversions",
     "enginedebug",
     "searchproviders",
-    "files"
+    "files",
+    "pushkey"
   ];
   actions.forEach(function (action) {
     api[action] = request.bind(null, action);
@@ -198,6 +217,23 @@ app.filter("round", function () {
   };
 });
 
+app.filter("eta", function () {
+  return function (sec) {
+    if (typeof sec !== "number" || sec < 0) {
+      return "∞";
+    }
+    var d = Math.floor(sec / 86400), h = Math.floor(sec % 86400 / 3600),
+      m = Math.floor(sec % 3600 / 60), s = sec % 60;
+    if (d > 0) {
+      return d + "d " + h + "h";
+    }
+    if (h > 0) {
+      return h + "h " + m + "m";
+    }
+    return m > 0 ? m + "m " + s + "s" : s + "s";
+  };
+});
+
 app.filter("escape", function () {
   return window.encodeURIComponent;
 });
@@ -0,0 +1,30 @@
+/* service worker showing the web push notifications */
+self.addEventListener("push", function (event) {
+  var data = {};
+  try {
+    data = event.data ? event.data.json() : {};
+  } catch (e) {
+    data = { title: "SimpleTorrent", body: event.data.text() };
+  }
+  event.waitUntil(
+    self.registration.showNotification(data.title || "SimpleTorrent", {
+      body: data.body || "",
+      tag: data.tag,
+      icon: "cloud-favicon.png"
+    })
+  );
+});
+
+self.addEventListener("notificationclick", function (event) {
+  event.notification.close();
+  event.waitUntil(
+    clients.matchAll({ type: "window" }).then(function (list) {
+      for (var i = 0; i < list.length; i++) {
+        if ("focus" in list[i]) {
+          return list[i].focus();
+        }
+      }
+      return clients.openWindow(self.registration.scope);
+    })
+  );
+});